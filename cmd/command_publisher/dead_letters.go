@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/pkg/pagination"
+)
+
+func newDeadLettersCommand() *cobra.Command {
+	var (
+		status      string
+		commandCode string
+		limit       int
+	)
+	cmd := &cobra.Command{
+		Use:     "dead-letters",
+		Aliases: []string{"dlq"},
+		Short:   "List the commands and command events that couldn't be handled, latest failure first",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter := &dto.DeadLetterFilter{}
+			if status != "" {
+				for _, status := range strings.Split(status, ",") {
+					if !model.IsValidDeadLetterStatus(status) {
+						return fmt.Errorf("unknown dead letter status %q", status)
+					}
+					filter.Status = append(filter.Status, status)
+				}
+			}
+			if commandCode != "" {
+				filter.CommandCode = &commandCode
+			}
+
+			deadLetterService, closeTools, err := openDeadLetterService()
+			if err != nil {
+				return err
+			}
+			defer closeTools()
+
+			letters, total, err := deadLetterService.ListDeadLetters(cmd.Context(), filter, &pagination.Pagination{Page: 1, PageSize: limit})
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tSTATUS\tATTEMPTS\tLAST FAILED\tSUBJECT\tCOMMAND\tERROR")
+			for _, letter := range letters {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s: %s\n", letter.ID, letter.Status, letter.Attempts, letter.LastFailedAt.Local().Format(time.DateTime), letter.Subject, letter.CommandCode, letter.ErrorCode, letter.Error)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if total > int64(len(letters)) {
+				fmt.Fprintf(cmd.OutOrStdout(), "%d of %d dead letters, raise --limit for more\n", len(letters), total)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&status, "status", "pending", "comma separated statuses: pending, redriven, discarded, empty for all")
+	cmd.Flags().StringVar(&commandCode, "code", "", "only dead letters of the command code")
+	cmd.Flags().IntVar(&limit, "limit", 50, "dead letters to list")
+	cmd.AddCommand(
+		newDeadLetterShowCommand(),
+		newDeadLetterEditCommand(),
+		newDeadLetterRedriveCommand(),
+		newDeadLetterDiscardCommand(),
+		newDeadLetterVolumeCommand(),
+		newDeadLetterPruneCommand(),
+	)
+	return cmd
+}
+
+func newDeadLetterShowCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <dead_letter_id>",
+		Short: "Print a dead letter with its message as json",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			letterID, err := parseDeadLetterID(args[0])
+			if err != nil {
+				return err
+			}
+			deadLetterService, closeTools, err := openDeadLetterService()
+			if err != nil {
+				return err
+			}
+			defer closeTools()
+
+			letter, err := deadLetterService.GetDeadLetter(cmd.Context(), letterID)
+			if err != nil {
+				return err
+			}
+			return printJSON(cmd.OutOrStdout(), letter)
+		},
+	}
+}
+
+func newDeadLetterEditCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "edit <dead_letter_id> <message_file>",
+		Short: "Replace the message of a pending dead letter, - reads it from stdin",
+		Example: `  command_publisher dead-letters show 6f1c... | jq -r .message > message.json
+  $EDITOR message.json
+  command_publisher dead-letters edit 6f1c... message.json`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			letterID, err := parseDeadLetterID(args[0])
+			if err != nil {
+				return err
+			}
+			var reader io.Reader = os.Stdin
+			if args[1] != "-" {
+				file, err := os.Open(args[1])
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				reader = file
+			}
+			message, err := io.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+
+			deadLetterService, closeTools, err := openDeadLetterService()
+			if err != nil {
+				return err
+			}
+			defer closeTools()
+
+			letter, err := deadLetterService.Edit(cmd.Context(), letterID, strings.TrimSpace(string(message)))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "edited %s, re-drive it with: command_publisher dead-letters redrive %s\n", letter.ID, letter.ID)
+			return nil
+		},
+	}
+}
+
+func newDeadLetterRedriveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "redrive <dead_letter_id>...",
+		Short: "Publish pending dead letters again to the subject they came from",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			deadLetterService, closeTools, err := openDeadLetterService()
+			if err != nil {
+				return err
+			}
+			defer closeTools()
+
+			return eachDeadLetter(cmd, args, "re-driven", func(letterID uuid.UUID) error {
+				_, err := deadLetterService.Redrive(cmd.Context(), letterID)
+				return err
+			})
+		},
+	}
+}
+
+func newDeadLetterDiscardCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "discard <dead_letter_id>...",
+		Short: "Give up on pending dead letters, they are deleted with the resolved ones",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			deadLetterService, closeTools, err := openDeadLetterService()
+			if err != nil {
+				return err
+			}
+			defer closeTools()
+
+			return eachDeadLetter(cmd, args, "discarded", func(letterID uuid.UUID) error {
+				_, err := deadLetterService.Discard(cmd.Context(), letterID)
+				return err
+			})
+		},
+	}
+}
+
+func newDeadLetterVolumeCommand() *cobra.Command {
+	var window time.Duration
+	cmd := &cobra.Command{
+		Use:   "volume",
+		Short: "Count the dead letters per command code, subject and status",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			deadLetterService, closeTools, err := openDeadLetterService()
+			if err != nil {
+				return err
+			}
+			defer closeTools()
+
+			volume, err := deadLetterService.Volume(cmd.Context(), time.Now().Add(-window))
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "COMMAND\tSUBJECT\tSTATUS\tCOUNT\tATTEMPTS\tLAST FAILED")
+			for _, row := range volume {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", row.CommandCode, row.Subject, row.Status, row.Count, row.Attempts, row.LastFailedAt.Local().Format(time.DateTime))
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().DurationVar(&window, "since", 24*time.Hour, "count the dead letters that failed within the duration")
+	return cmd
+}
+
+func newDeadLetterPruneCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "prune",
+		Short: "Delete the dead letters past command.dead_letter_retention and command.resolved_dead_letter_retention",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			deadLetterService, closeTools, err := openDeadLetterService()
+			if err != nil {
+				return err
+			}
+			defer closeTools()
+
+			pruned, err := deadLetterService.Prune(cmd.Context(), time.Now())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "pruned %d dead letters\n", pruned)
+			return nil
+		},
+	}
+}
+
+func parseDeadLetterID(raw string) (uuid.UUID, error) {
+	letterID, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("dead letter id %q is not a uuid", raw)
+	}
+	return letterID, nil
+}
+
+// eachDeadLetter runs the action on every dead letter id, it reports every failure and fails when one did.
+func eachDeadLetter(cmd *cobra.Command, args []string, done string, action func(letterID uuid.UUID) error) error {
+	failed := 0
+	for _, arg := range args {
+		letterID, err := parseDeadLetterID(arg)
+		if err == nil {
+			err = action(letterID)
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(cmd.ErrOrStderr(), "%s  failed: %v\n", arg, err)
+			continue
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s  %s\n", letterID, done)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dead letters failed", failed, len(args))
+	}
+	return nil
+}
@@ -2,77 +2,53 @@ package main
 
 import (
 	"os"
-	"os/signal"
-	"syscall"
 
+	"github.com/spf13/cobra"
 	"github.com/vars7899/iots/config"
-	"github.com/vars7899/iots/internal/db"
-	"github.com/vars7899/iots/internal/repository/postgres"
-	"github.com/vars7899/iots/internal/service/command"
-	"github.com/vars7899/iots/pkg/apperror"
 	"github.com/vars7899/iots/pkg/logger"
-	"github.com/vars7899/iots/pkg/pubsub"
 	"go.uber.org/zap"
 )
 
 func main() {
+	Execute()
+}
+
+// Execute runs the operator CLI, without a subcommand it serves like before the CLI existed.
+func Execute() {
 	// initialize logger
 	logger.InitDev()
 	defer logger.Sync()
 
-	// load configuration from the path
-	if err := config.Load("config.dev", "yaml", "./configs", logger.L()); err != nil {
-		logger.L().Fatal("failed to load config, shutting down app", zap.Error(err))
-		return
-	}
-
-	// load device command schema registry from the path
-	registry := config.NewDeviceCommandSchemaRegistry()
-	registry.LoadDeviceCommandSchemaRegistry("device.command.schema", "yaml", "./configs", logger.L())
-
-	// initialize connection to database
-	gormDB, err := db.NewGormDB(logger.L(), config.GlobalConfig.Postgres)
-	if err != nil {
-		logger.L().Fatal("failed to connect to db", zap.Error(err))
-		return
-	}
-
-	deviceRepo := postgres.NewDeviceRepositoryPostgres(gormDB.DB(), logger.L())
-
-	natsPubSub, err := pubsub.NewNatsPubSub(config.GlobalConfig.Nats.BaseUrl, logger.L())
-	if err != nil {
-		// Use apperror for logging NATS connection errors
-		appErr := apperror.WrapAppErrWithContext(err, "Failed to connect to NATS", apperror.ErrCodeInit)
-		logger.L().Fatal("Failed to connect to NATS", zap.Error(appErr))
+	rootCmd := &cobra.Command{
+		Use:   "command_publisher",
+		Short: "Command processor and operator tools for device commands",
+		Long: `Runs the command processor (serve, the default) and gives on-call engineers the tools around it: send
+commands, tail the command and event subjects, inspect and validate command schemas and replay commands.`,
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// load configuration from the path
+			if err := config.Load("config.dev", "yaml", "./configs", logger.L()); err != nil {
+				logger.L().Error("failed to load config", zap.Error(err))
+				return err
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serve()
+			return nil
+		},
 	}
-	defer natsPubSub.Close()
-
-	// 7. Initialize CommandProcessorService (Pass schemaRegistry)
-	cmdProcessor, err := command.NewCommandProcessorService(registry, natsPubSub, deviceRepo, logger.L())
-	if err != nil {
-		// NewCommandProcessorService uses fmt.Errorf, wrap it if needed, or just log
-		logger.L().Fatal("Failed to create CommandProcessorService", zap.Error(err))
-	}
-
-	// 8. Start the CommandProcessorService
-	err = cmdProcessor.Start()
-	if err != nil {
-		// Start method now returns AppError, log it
-		logger.L().Fatal("Failed to start CommandProcessorService", zap.Error(err))
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "log what the services do while running a tool")
+	rootCmd.AddCommand(
+		newServeCommand(),
+		newSendCommand(),
+		newTailCommand(),
+		newSchemasCommand(),
+		newReplayCommand(),
+		newDeadLettersCommand(),
+	)
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
 	}
-	// Stop is deferred below after the signal handler
-
-	logger.L().Info("CommandProcessorService started and listening on NATS", zap.String("topic", pubsub.NatsTopicCommandsInbound))
-	logger.L().Info("Press Ctrl+C to stop.")
-
-	// 9. Graceful Shutdown
-	stopChan := make(chan os.Signal, 1)
-	signal.Notify(stopChan, syscall.SIGINT, syscall.SIGTERM)
-
-	<-stopChan // Block until a shutdown signal is received
-
-	logger.L().Info("Shutdown signal received. Stopping CommandProcessorService...")
-	cmdProcessor.Stop() // Stop the service
-
-	logger.L().Info("CommandProcessorService stopped. Exiting.")
 }
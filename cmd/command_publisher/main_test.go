@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToolFlags(t *testing.T) {
+	send := newSendCommand()
+	require.NoError(t, send.ParseFlags([]string{"--device", "a,b", "--device", "c", "--ttl", "90s", "--rpc", "--rpc-timeout", "20s"}))
+	deviceIDs, err := send.Flags().GetStringSlice("device")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, deviceIDs)
+	ttl, err := send.Flags().GetDuration("ttl")
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, ttl)
+	rpcTimeout, err := send.Flags().GetDuration("rpc-timeout")
+	require.NoError(t, err)
+	assert.Equal(t, 20*time.Second, rpcTimeout)
+
+	tail := newTailCommand()
+	require.NoError(t, tail.ParseFlags(nil))
+	subjects, err := tail.Flags().GetStringSlice("subject")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"system", "device", "outbound"}, subjects, "every subject is followed by default")
+	require.NoError(t, tail.ParseFlags([]string{"--type", "error,command_failed"}))
+	types, err := tail.Flags().GetStringSlice("type")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"error", "command_failed"}, types)
+}
+
+// the tools check their arguments before connecting, none of these needs nats or the db
+func TestToolArgumentsAreCheckedBeforeConnecting(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     *cobra.Command
+		args    []string
+		wantErr string
+	}{
+		{"send without a command code", newSendCommand(), nil, "accepts 1 arg(s)"},
+		{"send with both payloads", newSendCommand(), []string{"device@reboot", "--payload", "{}", "--payload-file", "reboot.json"}, "set either --payload or --payload-file"},
+		{"send with a payload that isn't an object", newSendCommand(), []string{"device@reboot", "--payload", "[5]"}, "payload is not a json object"},
+		{"send with a duration that isn't one", newSendCommand(), []string{"device@reboot", "--ttl", "soon"}, "invalid argument"},
+		{"tail of an unknown subject", newTailCommand(), []string{"--subject", "system,alerts"}, `unknown subject "alerts"`},
+		{"tail with an argument", newTailCommand(), []string{"device"}, "unknown command"},
+		{"replay of a command id that isn't a uuid", newReplayCommand(), []string{"42"}, `command id "42" is not a uuid`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tt.cmd.SetOut(&out)
+			tt.cmd.SetErr(&out)
+			tt.cmd.SetArgs(tt.args)
+			err := tt.cmd.Execute()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/vars7899/iots/internal/domain/model"
+)
+
+func newReplayCommand() *cobra.Command {
+	var ttl time.Duration
+	cmd := &cobra.Command{
+		Use:   "replay <command_id>",
+		Short: "Send a command from the history again, as a new command to the same device",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			commandID, err := uuid.Parse(args[0])
+			if err != nil {
+				return fmt.Errorf("command id %q is not a uuid", args[0])
+			}
+			tools, closeTools, err := openCommandTools()
+			if err != nil {
+				return err
+			}
+			defer closeTools()
+
+			original, err := tools.commandRepo.GetByID(cmd.Context(), commandID)
+			if err != nil {
+				return err
+			}
+			// a new command, the idempotency key of the original would only return the original
+			replayed, err := tools.commandService.Send(cmd.Context(), &model.Command{
+				DeviceID:          original.DeviceID,
+				CommandCode:       original.CommandCode,
+				Payload:           original.Payload,
+				AckTimeoutSeconds: original.AckTimeoutSeconds,
+				MaxAttempts:       original.MaxAttempts,
+			}, ttl)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "replayed %s (%s) to %s as %s, %s\n", original.ID, original.CommandCode, original.DeviceID, replayed.ID, replayed.Status)
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&ttl, "ttl", 0, "how long the command may take, command.default_ttl when unset")
+	return cmd
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/vars7899/iots/internal/commands"
+)
+
+func newSchemasCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schemas",
+		Short: "List the command schemas of device.command.schema.yaml",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			registry, err := loadRegistry()
+			if err != nil {
+				return err
+			}
+			schemas := registry.GetConfig().Commands
+			codes := make([]string, 0, len(schemas))
+			for code := range schemas {
+				codes = append(codes, code)
+			}
+			sort.Strings(codes)
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "CODE\tDIRECTION\tTYPE\tVERSIONS\tREQUIRED\tDESCRIPTION")
+			for _, code := range codes {
+				schema := schemas[code]
+				versions := make([]string, 0, len(schema.PreviousVersions)+1)
+				for _, version := range schema.ActiveVersions() {
+					versions = append(versions, fmt.Sprint(version))
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", code, schema.Direction, schema.Type, strings.Join(versions, ","), strings.Join(schema.Payload.Required, ","), schema.Description)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.AddCommand(newSchemaShowCommand(), newSchemaValidateCommand())
+	return cmd
+}
+
+func newSchemaShowCommand() *cobra.Command {
+	var version int
+	cmd := &cobra.Command{
+		Use:   "show <command_code>",
+		Short: "Print a command schema as json",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			registry, err := loadRegistry()
+			if err != nil {
+				return err
+			}
+			schema := registry.GetCommandVersion(args[0], version)
+			if schema == nil {
+				return fmt.Errorf("no active schema for %s version %d", args[0], version)
+			}
+			return printJSON(cmd.OutOrStdout(), schema)
+		},
+	}
+	cmd.Flags().IntVar(&version, "version", 0, "schema version, the latest when unset")
+	return cmd
+}
+
+func newSchemaValidateCommand() *cobra.Command {
+	var version int
+	cmd := &cobra.Command{
+		Use:   "validate <command_code> <payload_file>",
+		Short: "Check a payload file against a command schema, offline",
+		Long:  "Check a payload file against a command schema without a connection, - reads the payload from stdin. Exits non-zero on violations.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			registry, err := loadRegistry()
+			if err != nil {
+				return err
+			}
+			schema := registry.GetCommandVersion(args[0], version)
+			if schema == nil {
+				return fmt.Errorf("no active schema for %s version %d", args[0], version)
+			}
+			payload, err := readPayload(args[1])
+			if err != nil {
+				return err
+			}
+
+			violations := commands.ValidatePayload(&schema.Payload, payload)
+			if len(violations) == 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "payload is valid for %s version %d\n", args[0], schema.SchemaVersion)
+				return nil
+			}
+			for _, violation := range violations {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: %s (%s)\n", violation.Field, violation.Message, violation.Code)
+			}
+			return fmt.Errorf("payload has %d violation(s) against %s version %d", len(violations), args[0], schema.SchemaVersion)
+		},
+	}
+	cmd.Flags().IntVar(&version, "version", 0, "schema version, the latest when unset")
+	return cmd
+}
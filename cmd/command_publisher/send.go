@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/vars7899/iots/internal/domain/model"
+)
+
+func newSendCommand() *cobra.Command {
+	var (
+		deviceIDs   []string
+		tag, site   string
+		payloadJSON string
+		payloadFile string
+		ttl         time.Duration
+		rpc         bool
+		rpcTimeout  time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "send <command_code>",
+		Short: "Send a u>d command to devices, by id, tag or site",
+		Example: `  command_publisher send device@reboot --device 0b6c... --payload '{"delay_seconds": 5}'
+  command_publisher send device@reboot --tag gateway --payload-file reboot.json
+  command_publisher send device@reboot --device 0b6c... --rpc --rpc-timeout 20s`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var payload map[string]interface{}
+			switch {
+			case payloadJSON != "" && payloadFile != "":
+				return fmt.Errorf("set either --payload or --payload-file")
+			case payloadJSON != "":
+				if err := json.Unmarshal([]byte(payloadJSON), &payload); err != nil {
+					return fmt.Errorf("payload is not a json object: %w", err)
+				}
+			case payloadFile != "":
+				var err error
+				if payload, err = readPayload(payloadFile); err != nil {
+					return err
+				}
+			}
+			raw, err := json.Marshal(payload)
+			if err != nil {
+				return err
+			}
+
+			tools, closeTools, err := openCommandTools()
+			if err != nil {
+				return err
+			}
+			defer closeTools()
+			ctx := cmd.Context()
+
+			devices, err := selectDevices(ctx, tools, deviceIDs, tag, site)
+			if err != nil {
+				return err
+			}
+			if rpc && len(devices) != 1 {
+				return fmt.Errorf("--rpc waits on one device, %d are selected", len(devices))
+			}
+
+			failed := 0
+			for _, deviceID := range devices {
+				command := &model.Command{DeviceID: deviceID, CommandCode: args[0], Payload: raw}
+				if rpc {
+					command, answer, err := tools.commandService.Call(ctx, command, ttl, rpcTimeout)
+					if err != nil {
+						return err
+					}
+					if answer != nil {
+						return printJSON(cmd.OutOrStdout(), answer)
+					}
+					return printJSON(cmd.OutOrStdout(), command)
+				}
+
+				sent, err := tools.commandService.Send(ctx, command, ttl)
+				if err != nil {
+					failed++
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s  failed: %v\n", deviceID, err)
+					continue
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s  %s  %s\n", deviceID, sent.ID, sent.Status)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d devices didn't get the command", failed, len(devices))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVar(&deviceIDs, "device", nil, "device id, repeat or comma separate for several")
+	cmd.Flags().StringVar(&tag, "tag", "", "every device with the tag")
+	cmd.Flags().StringVar(&site, "site", "", "every device of the site")
+	cmd.Flags().StringVar(&payloadJSON, "payload", "", "payload as a json object")
+	cmd.Flags().StringVar(&payloadFile, "payload-file", "", "file with the payload as a json object, - for stdin")
+	cmd.Flags().DurationVar(&ttl, "ttl", 0, "how long the command may take, command.default_ttl when unset")
+	cmd.Flags().BoolVar(&rpc, "rpc", false, "wait for the device's answer and print it")
+	cmd.Flags().DurationVar(&rpcTimeout, "rpc-timeout", 0, "how long --rpc waits, command.rpc_timeout when unset")
+	return cmd
+}
+
+// selectDevices resolves the device selection of a tool, exactly one of ids, tag or site.
+func selectDevices(ctx context.Context, tools *commandTools, deviceIDs []string, tag, site string) ([]uuid.UUID, error) {
+	selections := 0
+	for _, set := range []bool{len(deviceIDs) > 0, tag != "", site != ""} {
+		if set {
+			selections++
+		}
+	}
+	if selections != 1 {
+		return nil, fmt.Errorf("select the devices with exactly one of --device, --tag or --site")
+	}
+
+	if len(deviceIDs) > 0 {
+		ids := make([]uuid.UUID, 0, len(deviceIDs))
+		for _, raw := range deviceIDs {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				return nil, fmt.Errorf("device id %q is not a uuid", raw)
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	}
+
+	target := &model.CommandTarget{TargetType: model.CommandTargetTag, Tag: tag}
+	if site != "" {
+		target = &model.CommandTarget{TargetType: model.CommandTargetSite, Site: site}
+	}
+	devices, err := tools.commandRepo.TargetDevices(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no device matches the selection")
+	}
+	ids := make([]uuid.UUID, 0, len(devices))
+	for _, device := range devices {
+		ids = append(ids, device.ID)
+	}
+	return ids, nil
+}
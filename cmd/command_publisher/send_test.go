@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+)
+
+type targetCommandRepo struct {
+	repository.CommandRepository
+	devices []*model.Device
+	targets []*model.CommandTarget
+}
+
+func (r *targetCommandRepo) TargetDevices(ctx context.Context, target *model.CommandTarget) ([]*model.Device, error) {
+	r.targets = append(r.targets, target)
+	return r.devices, nil
+}
+
+func TestSelectDevices(t *testing.T) {
+	boiler, chiller := uuid.New(), uuid.New()
+	repo := &targetCommandRepo{devices: []*model.Device{{ID: boiler}, {ID: chiller}}}
+	tools := &commandTools{commandRepo: repo}
+	ctx := context.Background()
+
+	ids, err := selectDevices(ctx, tools, []string{boiler.String(), chiller.String()}, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{boiler, chiller}, ids)
+	assert.Empty(t, repo.targets, "device ids are taken as they are")
+
+	ids, err = selectDevices(ctx, tools, nil, "gateway", "")
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{boiler, chiller}, ids)
+	require.Len(t, repo.targets, 1)
+	assert.Equal(t, &model.CommandTarget{TargetType: model.CommandTargetTag, Tag: "gateway"}, repo.targets[0])
+
+	_, err = selectDevices(ctx, tools, nil, "", "plant-1")
+	require.NoError(t, err)
+	require.Len(t, repo.targets, 2)
+	assert.Equal(t, &model.CommandTarget{TargetType: model.CommandTargetSite, Site: "plant-1"}, repo.targets[1])
+
+	_, err = selectDevices(ctx, tools, []string{"boiler"}, "", "")
+	assert.EqualError(t, err, `device id "boiler" is not a uuid`)
+
+	ambiguous := []struct {
+		deviceIDs []string
+		tag, site string
+	}{
+		{nil, "", ""},
+		{[]string{boiler.String()}, "gateway", ""},
+		{nil, "gateway", "plant-1"},
+	}
+	for _, selection := range ambiguous {
+		_, err = selectDevices(ctx, tools, selection.deviceIDs, selection.tag, selection.site)
+		assert.EqualError(t, err, "select the devices with exactly one of --device, --tag or --site", "selection %+v", selection)
+	}
+
+	repo.devices = nil
+	_, err = selectDevices(ctx, tools, nil, "gateway", "")
+	assert.EqualError(t, err, "no device matches the selection")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/commands"
+	"github.com/vars7899/iots/internal/db"
+	"github.com/vars7899/iots/internal/leader"
+	"github.com/vars7899/iots/internal/repository/postgres"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/internal/service/command"
+	"github.com/vars7899/iots/internal/worker"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+func newServeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Run the command processor and the leader-elected schedule and campaign workers",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serve()
+			return nil
+		},
+	}
+}
+
+// serve boots the processor of the commands devices send and, on the leading instance, the command schedules and
+// campaigns. It runs until SIGINT or SIGTERM.
+func serve() {
+	// load device command schema registry from the path
+	registry := config.NewDeviceCommandSchemaRegistry()
+	registry.LoadDeviceCommandSchemaRegistry("device.command.schema", "yaml", "./configs", logger.L())
+
+	// initialize connection to database
+	gormDB, err := db.NewGormDB(logger.L(), config.GlobalConfig.Postgres)
+	if err != nil {
+		logger.L().Fatal("failed to connect to db", zap.Error(err))
+		return
+	}
+
+	deviceRepo := postgres.NewDeviceRepositoryPostgres(gormDB.DB(), logger.L())
+
+	natsPubSub, err := pubsub.NewNatsPubSub(config.GlobalConfig.Nats.BaseUrl, logger.L())
+	if err != nil {
+		// Use apperror for logging NATS connection errors
+		appErr := apperror.WrapAppErrWithContext(err, "Failed to connect to NATS", apperror.ErrCodeInit)
+		logger.L().Fatal("Failed to connect to NATS", zap.Error(appErr))
+	}
+	defer natsPubSub.Close()
+
+	// handlers of the commands the server receives, every one of them runs through the middleware
+	metrics := commands.NewMetrics()
+	handlers := commands.NewRegistry(commands.Logging(logger.L()), metrics.Middleware(), commands.AuthorizeDevice())
+	if err := commands.RegisterDeviceHandlers(handlers); err != nil {
+		logger.L().Fatal("Failed to register command handlers", zap.Error(err))
+	}
+
+	// commands the processor fails on are kept as dead letters, operators re-drive them through the api or the cli
+	deadLetterService := service.NewDeadLetterService(postgres.NewDeadLetterRepositoryPostgres(gormDB.DB(), logger.L()), natsPubSub, config.GlobalConfig.Command, logger.L())
+
+	// 7. Initialize CommandProcessorService (Pass schemaRegistry)
+	// status changes the devices report go through their lifecycle and are published as state_changed events
+	deviceStatusService := service.NewDeviceStatusService(deviceRepo, natsPubSub, logger.L())
+
+	cmdProcessor, err := command.NewCommandProcessorService(registry, handlers, natsPubSub, deviceRepo, deviceStatusService, deadLetterService, logger.L())
+	if err != nil {
+		// NewCommandProcessorService uses fmt.Errorf, wrap it if needed, or just log
+		logger.L().Fatal("Failed to create CommandProcessorService", zap.Error(err))
+	}
+
+	// 8. Start the CommandProcessorService
+	err = cmdProcessor.Start()
+	if err != nil {
+		// Start method now returns AppError, log it
+		logger.L().Fatal("Failed to start CommandProcessorService", zap.Error(err))
+	}
+	// Stop is deferred below after the signal handler
+
+	logger.L().Info("CommandProcessorService started and listening on NATS", zap.String("topic", pubsub.NatsTopicCommandsInbound))
+
+	// command schedules and campaigns, every instance competes for the lease and only the leader runs them
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	commandRepo := postgres.NewCommandRepositoryPostgres(gormDB.DB(), logger.L())
+	commandService := service.NewCommandService(commandRepo, deviceRepo, registry, natsPubSub, config.GlobalConfig.Command, logger.L())
+	scheduleService := service.NewCommandScheduleService(postgres.NewCommandScheduleRepositoryPostgres(gormDB.DB(), logger.L()), commandRepo, commandService, registry, logger.L())
+
+	campaignService := service.NewCommandCampaignService(postgres.NewCommandCampaignRepositoryPostgres(gormDB.DB(), logger.L()), deviceRepo, commandService, registry, logger.L())
+
+	scheduleInterval, campaignInterval, leaseTTL := 15*time.Second, 10*time.Second, 45*time.Second
+	if cfg := config.GlobalConfig.Command; cfg != nil {
+		if cfg.ScheduleInterval > 0 {
+			scheduleInterval = cfg.ScheduleInterval
+		}
+		if cfg.CampaignInterval > 0 {
+			campaignInterval = cfg.CampaignInterval
+		}
+		if cfg.LeaderLeaseTTL > 0 {
+			leaseTTL = cfg.LeaderLeaseTTL
+		}
+	}
+	elector := leader.NewElector(postgres.NewLeaseRepositoryPostgres(gormDB.DB(), logger.L()), "command-scheduler", leaseTTL, logger.L())
+
+	wg.Add(1)
+	go worker.CommandSchedulerWorker(ctx, &wg, scheduleInterval, elector, scheduleService, logger.L())
+	wg.Add(1)
+	go worker.CommandCampaignWorker(ctx, &wg, campaignInterval, elector, campaignService, logger.L())
+	logger.L().Info("Press Ctrl+C to stop.")
+
+	// 9. Graceful Shutdown
+	stopChan := make(chan os.Signal, 1)
+	signal.Notify(stopChan, syscall.SIGINT, syscall.SIGTERM)
+
+	<-stopChan // Block until a shutdown signal is received
+
+	logger.L().Info("Shutdown signal received. Stopping CommandProcessorService...")
+	cancel()
+	wg.Wait()
+	cmdProcessor.Stop() // Stop the service
+	logger.L().Info("Command handler metrics", zap.Any("commands", metrics.Snapshot()))
+
+	logger.L().Info("CommandProcessorService stopped. Exiting.")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vars7899/iots/pkg/pubsub"
+)
+
+// tailSubjects are the subjects tail follows, by the name of the --subject flag.
+var tailSubjects = map[string]string{
+	"system":   pubsub.NatsTopicSystemEvents,
+	"device":   pubsub.NatsTopicDeviceEventsPrefix + ">",
+	"outbound": pubsub.NatsTopicCommandsOutboundPrefix + ">",
+}
+
+// tailMessage holds the fields events and commands share, enough to filter and print either.
+type tailMessage struct {
+	Type          string                 `json:"type"`
+	DeviceID      string                 `json:"device_id"`
+	CommandCode   string                 `json:"command_code"`
+	CorrelationID string                 `json:"correlation_id"`
+	Timestamp     time.Time              `json:"timestamp"`
+	Payload       map[string]interface{} `json:"payload"`
+}
+
+type tailFilter struct {
+	deviceID    string
+	commandCode string
+	types       []string
+}
+
+func (f *tailFilter) matches(message *tailMessage) bool {
+	if f.deviceID != "" && message.DeviceID != f.deviceID {
+		return false
+	}
+	if f.commandCode != "" && message.CommandCode != f.commandCode {
+		return false
+	}
+	return len(f.types) == 0 || slices.Contains(f.types, message.Type)
+}
+
+func newTailCommand() *cobra.Command {
+	var (
+		subjects []string
+		filter   tailFilter
+		raw      bool
+	)
+	cmd := &cobra.Command{
+		Use:   "tail",
+		Short: "Follow system.events, device.events.* and commands.outbound.* with filters",
+		Example: `  command_publisher tail --device 0b6c...
+  command_publisher tail --subject system --type error
+  command_publisher tail --subject outbound --code device@reboot --raw | jq .payload`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, subject := range subjects {
+				if _, ok := tailSubjects[subject]; !ok {
+					return fmt.Errorf("unknown subject %q, use system, device or outbound", subject)
+				}
+			}
+			natsPubSub, err := connectNats()
+			if err != nil {
+				return err
+			}
+			defer natsPubSub.Close()
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+
+			type received struct {
+				subject string
+				data    []byte
+			}
+			messages := make(chan received)
+			for _, subject := range subjects {
+				channel, err := natsPubSub.Subscribe(ctx, tailSubjects[subject])
+				if err != nil {
+					return fmt.Errorf("subscribe to %s: %w", tailSubjects[subject], err)
+				}
+				go func(subject string, channel <-chan []byte) {
+					for data := range channel {
+						select {
+						case messages <- received{subject: subject, data: data}:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}(subject, channel)
+			}
+
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case msg := <-messages:
+					var message tailMessage
+					if err := json.Unmarshal(msg.data, &message); err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "%s: undecodable message: %v\n", msg.subject, err)
+						continue
+					}
+					if !filter.matches(&message) {
+						continue
+					}
+					if raw {
+						fmt.Fprintln(cmd.OutOrStdout(), string(msg.data))
+						continue
+					}
+					printTailMessage(cmd.OutOrStdout(), msg.subject, &message)
+				}
+			}
+		},
+	}
+	cmd.Flags().StringSliceVar(&subjects, "subject", []string{"system", "device", "outbound"}, "subjects to follow: system, device, outbound")
+	cmd.Flags().StringVar(&filter.deviceID, "device", "", "only messages of the device")
+	cmd.Flags().StringVar(&filter.commandCode, "code", "", "only messages of the command code")
+	cmd.Flags().StringSliceVar(&filter.types, "type", nil, "only messages of the types, e.g. error,command_failed")
+	cmd.Flags().BoolVar(&raw, "raw", false, "print the messages as received, one json per line")
+	return cmd
+}
+
+// printTailMessage prints one line per message: time, subject, type, command code, device, correlation and payload.
+func printTailMessage(w io.Writer, subject string, message *tailMessage) {
+	at := message.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+	orDash := func(value string) string {
+		if value == "" || value == "00000000-0000-0000-0000-000000000000" {
+			return "-"
+		}
+		return value
+	}
+	payload, _ := json.Marshal(message.Payload)
+	fmt.Fprintf(w, "%s  %-8s  %-22s  %-24s  %-36s  %-36s  %s\n",
+		at.Local().Format("15:04:05.000"),
+		subject,
+		orDash(message.Type),
+		orDash(message.CommandCode),
+		orDash(message.DeviceID),
+		orDash(message.CorrelationID),
+		payload,
+	)
+}
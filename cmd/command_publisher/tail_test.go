@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailFilter(t *testing.T) {
+	reboot := &tailMessage{Type: "command_failed", DeviceID: "0b6c", CommandCode: "device@reboot"}
+	tests := []struct {
+		name   string
+		filter tailFilter
+		want   bool
+	}{
+		{"no filter", tailFilter{}, true},
+		{"device", tailFilter{deviceID: "0b6c"}, true},
+		{"another device", tailFilter{deviceID: "7e21"}, false},
+		{"command code", tailFilter{commandCode: "device@reboot"}, true},
+		{"another command code", tailFilter{commandCode: "device@ping"}, false},
+		{"one of the types", tailFilter{types: []string{"error", "command_failed"}}, true},
+		{"none of the types", tailFilter{types: []string{"error"}}, false},
+		{"every filter", tailFilter{deviceID: "0b6c", commandCode: "device@reboot", types: []string{"command_failed"}}, true},
+		{"every filter but one", tailFilter{deviceID: "0b6c", commandCode: "device@ping", types: []string{"command_failed"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.matches(reboot))
+		})
+	}
+}
+
+func TestPrintTailMessage(t *testing.T) {
+	var out bytes.Buffer
+	printTailMessage(&out, "outbound", &tailMessage{
+		Type:          "command",
+		DeviceID:      "0b6c",
+		CorrelationID: "00000000-0000-0000-0000-000000000000",
+		Timestamp:     time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local),
+		Payload:       map[string]interface{}{"delay_seconds": 5},
+	})
+	fields := strings.Fields(out.String())
+	assert.Equal(t, []string{"12:00:00.000", "outbound", "command", "-", "0b6c", "-", `{"delay_seconds":5}`}, fields, "missing values print as a dash")
+}
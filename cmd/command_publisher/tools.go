@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/db"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/repository/postgres"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+// verbose keeps the service logs of the tools, they only print their results otherwise.
+var verbose bool
+
+func toolLogger() *zap.Logger {
+	if verbose {
+		return logger.L()
+	}
+	return zap.NewNop()
+}
+
+func loadRegistry() (*config.DeviceCommandSchemaRegistry, error) {
+	registry := config.NewDeviceCommandSchemaRegistry()
+	if err := registry.LoadDeviceCommandSchemaRegistry("device.command.schema", "yaml", "./configs", toolLogger()); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+func connectNats() (pubsub.PubSubPublisher, error) {
+	natsPubSub, err := pubsub.NewNatsPubSub(config.GlobalConfig.Nats.BaseUrl, toolLogger())
+	if err != nil {
+		return nil, fmt.Errorf("connect to nats: %w", err)
+	}
+	return natsPubSub, nil
+}
+
+// commandTools is what the tools sending commands need, closed by the returned function.
+type commandTools struct {
+	commandService *service.CommandService
+	commandRepo    repository.CommandRepository
+}
+
+func openCommandTools() (*commandTools, func(), error) {
+	registry, err := loadRegistry()
+	if err != nil {
+		return nil, nil, err
+	}
+	gormDB, err := db.NewGormDB(toolLogger(), config.GlobalConfig.Postgres)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect to db: %w", err)
+	}
+	natsPubSub, err := connectNats()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commandRepo := postgres.NewCommandRepositoryPostgres(gormDB.DB(), toolLogger())
+	deviceRepo := postgres.NewDeviceRepositoryPostgres(gormDB.DB(), toolLogger())
+	tools := &commandTools{
+		commandService: service.NewCommandService(commandRepo, deviceRepo, registry, natsPubSub, config.GlobalConfig.Command, toolLogger()),
+		commandRepo:    commandRepo,
+	}
+	return tools, func() { natsPubSub.Close() }, nil
+}
+
+func openDeadLetterService() (*service.DeadLetterService, func(), error) {
+	gormDB, err := db.NewGormDB(toolLogger(), config.GlobalConfig.Postgres)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect to db: %w", err)
+	}
+	natsPubSub, err := connectNats()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deadLetterRepo := postgres.NewDeadLetterRepositoryPostgres(gormDB.DB(), toolLogger())
+	deadLetterService := service.NewDeadLetterService(deadLetterRepo, natsPubSub, config.GlobalConfig.Command, toolLogger())
+	return deadLetterService, func() { natsPubSub.Close() }, nil
+}
+
+// readPayload decodes a json object from the file, "-" reads stdin.
+func readPayload(path string) (map[string]interface{}, error) {
+	var reader io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	var payload map[string]interface{}
+	if err := json.NewDecoder(reader).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("payload is not a json object: %w", err)
+	}
+	return payload, nil
+}
+
+func printJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -14,14 +14,18 @@ import (
 var GlobalConfig *AppConfig
 
 type AppConfig struct {
-	Server    *ServerConfig    `mapstructure:"server"`
-	Postgres  *PostgresConfig  `mapstructure:"postgres"`
-	Jwt       *JwtConfig       `mapstructure:"jwt"`
-	Redis     *RedisConfig     `mapstructure:"redis"`
-	Auth      *AuthConfig      `mapstructure:"auth"`
-	Frontend  *FrontendConfig  `mapstructure:"frontend"`
-	Websocket *WebsocketConfig `mapstructure:"websocket"`
-	Nats      *NatsConfig      `mapstructure:"nats"`
+	Server       *ServerConfig       `mapstructure:"server"`
+	Postgres     *PostgresConfig     `mapstructure:"postgres"`
+	Jwt          *JwtConfig          `mapstructure:"jwt"`
+	Redis        *RedisConfig        `mapstructure:"redis"`
+	Auth         *AuthConfig         `mapstructure:"auth"`
+	Frontend     *FrontendConfig     `mapstructure:"frontend"`
+	Websocket    *WebsocketConfig    `mapstructure:"websocket"`
+	Nats         *NatsConfig         `mapstructure:"nats"`
+	Coap         *CoapConfig         `mapstructure:"coap"`
+	Telemetry    *TelemetryConfig    `mapstructure:"telemetry"`
+	Notification *NotificationConfig `mapstructure:"notification"`
+	Command      *CommandConfig      `mapstructure:"command"`
 }
 
 type ServerConfig struct {
@@ -76,15 +80,106 @@ type NatsConfig struct {
 	BaseUrl string `mapstructure:"base_url"`
 }
 
+type CoapConfig struct {
+	Enabled          bool          `mapstructure:"enabled"`
+	Address          string        `mapstructure:"address"`
+	MaxMessageSize   int           `mapstructure:"max_message_size"`
+	ExchangeLifetime time.Duration `mapstructure:"exchange_lifetime"`
+	HandlerTimeout   time.Duration `mapstructure:"handler_timeout"`
+	MaxInFlight      int           `mapstructure:"max_in_flight"`
+}
+
+type TelemetryConfig struct {
+	SensorCacheTTL  time.Duration `mapstructure:"sensor_cache_ttl"`  // how long a sensor binding lookup is reused on ingest
+	SensorCacheSize int           `mapstructure:"sensor_cache_size"` // max cached sensor bindings
+
+	StatsRollupInterval time.Duration `mapstructure:"stats_rollup_interval"` // how often ingest counters are moved from redis to postgres
+	StatsTTL            time.Duration `mapstructure:"stats_ttl"`             // how long redis keeps the ingest counters of a device that went quiet
+	ThresholdCacheTTL   time.Duration `mapstructure:"threshold_cache_ttl"`   // how long a device's alert thresholds are reused
+
+	AlertFlapWindow        time.Duration `mapstructure:"alert_flap_window"`         // an alert auto resolved this recently is reopened instead of raised again
+	AlertFlapThreshold     int           `mapstructure:"alert_flap_threshold"`      // reopenings within the flap window that make an alert flapping
+	AlertFlapSweepInterval time.Duration `mapstructure:"alert_flap_sweep_interval"` // how often quiet flapping alerts are resolved
+	AlertGroupBy           string        `mapstructure:"alert_group_by"`            // device or site
+
+	RuleCheckpointInterval  time.Duration `mapstructure:"rule_checkpoint_interval"`  // how often the rules are reloaded and rule window state is saved to postgres
+	MaintenanceSyncInterval time.Duration `mapstructure:"maintenance_sync_interval"` // how often device status follows the maintenance windows
+}
+
+type CommandConfig struct {
+	DefaultTTL       time.Duration `mapstructure:"default_ttl"`       // how long a command may take when the request sets no ttl
+	MaxTTL           time.Duration `mapstructure:"max_ttl"`           // longest ttl a request may ask for
+	ExpiryInterval   time.Duration `mapstructure:"expiry_interval"`   // how often unfinished commands past their ttl are expired
+	AckTimeout       time.Duration `mapstructure:"ack_timeout"`       // how long the first attempt waits for the device's ack when the request sets none
+	MaxAckWait       time.Duration `mapstructure:"max_ack_wait"`      // the ack timeout doubles with every retry up to this
+	MaxAttempts      int           `mapstructure:"max_attempts"`      // how often a command is published without an ack when the request sets no limit
+	RetryInterval    time.Duration `mapstructure:"retry_interval"`    // how often commands past their ack deadline are retried
+	ScheduleInterval time.Duration `mapstructure:"schedule_interval"` // how often the command publisher looks for due command schedules
+	LeaderLeaseTTL   time.Duration `mapstructure:"leader_lease_ttl"`  // how long the leading command publisher holds the scheduler lease without renewing it
+	CampaignInterval time.Duration `mapstructure:"campaign_interval"` // how often the leading command publisher moves the running command campaigns on
+	RPCTimeout       time.Duration `mapstructure:"rpc_timeout"`       // how long a rpc command waits for the device's answer when the request sets no timeout
+	MaxRPCTimeout    time.Duration `mapstructure:"max_rpc_timeout"`   // longest wait a rpc request may ask for
+
+	// Dead letters are the inbound commands and command status events that couldn't be handled, they are kept for
+	// DeadLetterRetention when nobody looks at them and for ResolvedDeadLetterRetention once re-driven or discarded.
+	DeadLetterRetention         time.Duration `mapstructure:"dead_letter_retention"`
+	ResolvedDeadLetterRetention time.Duration `mapstructure:"resolved_dead_letter_retention"`
+	DeadLetterPruneInterval     time.Duration `mapstructure:"dead_letter_prune_interval"` // how often dead letters past their retention are deleted
+}
+
+type NotificationConfig struct {
+	DispatchInterval   time.Duration `mapstructure:"dispatch_interval"`   // how often due deliveries are picked up
+	EscalationInterval time.Duration `mapstructure:"escalation_interval"` // how often due alert escalations are picked up
+	BatchSize          int           `mapstructure:"batch_size"`          // max deliveries sent per dispatch
+	MaxAttempts        int           `mapstructure:"max_attempts"`        // attempts before a delivery is marked failed
+	RetryBackoff       time.Duration `mapstructure:"retry_backoff"`       // delay before the first retry, doubled on every attempt
+	MaxRetryBackoff    time.Duration `mapstructure:"max_retry_backoff"`
+
+	// Routes maps a notification event (password_reset, alert_triggered, device_state_changed, ...) to the channels
+	// it is delivered on, events without a route use "default".
+	Routes           map[string][]string `mapstructure:"routes"`
+	DeviceEventTypes []string            `mapstructure:"device_event_types"` // device events forwarded as device_<type>
+
+	Webhook *WebhookChannelConfig `mapstructure:"webhook"`
+	Smtp    *SmtpChannelConfig    `mapstructure:"smtp"`
+	Outbox  *OutboxChannelConfig  `mapstructure:"outbox"`
+}
+
+type WebhookChannelConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Url     string        `mapstructure:"url"`
+	Secret  string        `mapstructure:"secret"` // HMAC-SHA256 key of the X-Iots-Signature header
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type SmtpChannelConfig struct {
+	Enabled           bool     `mapstructure:"enabled"`
+	Host              string   `mapstructure:"host"`
+	Port              int      `mapstructure:"port"`
+	Username          string   `mapstructure:"username"`
+	Password          string   `mapstructure:"password"`
+	From              string   `mapstructure:"from"`
+	DefaultRecipients []string `mapstructure:"default_recipients"` // used when the notification doesn't name any, e.g. alerts
+}
+
+type OutboxChannelConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Dir     string `mapstructure:"dir"`
+}
+
 var envBindings = map[string]string{
-	"postgres.db_user":     "POSTGRES_DB_USER",
-	"postgres.db_password": "POSTGRES_DB_PASSWORD",
-	"postgres.db_name":     "POSTGRES_DB_NAME",
-	"jwt.access_secret":    "JWT_ACCESS_SECRET",
-	"jwt.refresh_secret":   "JWT_REFRESH_SECRET",
-	"redis.password":       "REDIS_PASSWORD",
-	"frontend.base_url":    "FRONTEND_BASE_URL",
-	"nats.base_url":        "NATS_BASE_URL",
+	"postgres.db_user":            "POSTGRES_DB_USER",
+	"postgres.db_password":        "POSTGRES_DB_PASSWORD",
+	"postgres.db_name":            "POSTGRES_DB_NAME",
+	"jwt.access_secret":           "JWT_ACCESS_SECRET",
+	"jwt.refresh_secret":          "JWT_REFRESH_SECRET",
+	"redis.password":              "REDIS_PASSWORD",
+	"frontend.base_url":           "FRONTEND_BASE_URL",
+	"nats.base_url":               "NATS_BASE_URL",
+	"notification.webhook.url":    "NOTIFICATION_WEBHOOK_URL",
+	"notification.webhook.secret": "NOTIFICATION_WEBHOOK_SECRET",
+	"notification.smtp.username":  "SMTP_USERNAME",
+	"notification.smtp.password":  "SMTP_PASSWORD",
 }
 
 func Load(filename string, filetype string, path string, baseLogger *zap.Logger) error {
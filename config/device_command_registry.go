@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/fsnotify/fsnotify"
@@ -19,27 +21,142 @@ type CommandSchema struct {
 	Type          string        `json:"type" mapstructure:"type"`
 	Command       string        `json:"command" mapstructure:"command"`
 	CommandCode   string        `json:"command_code" mapstructure:"command_code"`
-	SchemaVersion int           `json:"schema_version" mapstructure:"schema_version"`
+	SchemaVersion int           `json:"schema_version" mapstructure:"schema_version"` // latest version, bumped by every breaking change
 	Direction     string        `json:"direction" mapstructure:"direction"`
 	Description   string        `json:"description" mapstructure:"description"`
 	Payload       PayloadSchema `json:"payload_schema" mapstructure:"payload_schema"`
+
+	PreviousVersions []CommandSchemaVersion `json:"previous_versions,omitempty" mapstructure:"previous_versions"` // older versions devices may still speak
+}
+
+// CommandSchemaVersion is an older payload of a command kept active for the devices that didn't move on yet.
+type CommandSchemaVersion struct {
+	SchemaVersion int           `json:"schema_version" mapstructure:"schema_version"`
+	Payload       PayloadSchema `json:"payload_schema" mapstructure:"payload_schema"`
+}
+
+// Version returns the command as of the schema version, nil when the version isn't active. Version 0 is the latest.
+func (c *CommandSchema) Version(version int) *CommandSchema {
+	if version == 0 || version == c.SchemaVersion {
+		return c
+	}
+	for _, previous := range c.PreviousVersions {
+		if previous.SchemaVersion == version {
+			versioned := *c
+			versioned.SchemaVersion = previous.SchemaVersion
+			versioned.Payload = previous.Payload
+			versioned.PreviousVersions = nil
+			return &versioned
+		}
+	}
+	return nil
+}
+
+// ActiveVersions lists the schema versions of the command devices may use, latest first.
+func (c *CommandSchema) ActiveVersions() []int {
+	versions := []int{c.SchemaVersion}
+	for _, previous := range c.PreviousVersions {
+		versions = append(versions, previous.SchemaVersion)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	return versions
+}
+
+// Command endpoints, the emitter and the receiver in CommandSchema.Direction, e.g. "d>s" for a device sending to
+// the server.
+const (
+	EndpointDevice        = "d"
+	EndpointVirtualDevice = "v"
+	EndpointServer        = "s"
+	EndpointUser          = "u"
+)
+
+// CommandDirection is the parsed direction of a command.
+type CommandDirection struct {
+	From string
+	To   string
+}
+
+// ParseDirection parses a direction of the form emitter>receiver.
+func ParseDirection(direction string) (CommandDirection, error) {
+	from, to, ok := strings.Cut(direction, ">")
+	if !ok {
+		return CommandDirection{}, fmt.Errorf("direction %q is not emitter>receiver", direction)
+	}
+	for _, endpoint := range []string{from, to} {
+		switch endpoint {
+		case EndpointDevice, EndpointVirtualDevice, EndpointServer, EndpointUser:
+		default:
+			return CommandDirection{}, fmt.Errorf("direction %q has unknown endpoint %q", direction, endpoint)
+		}
+	}
+	if from == to {
+		return CommandDirection{}, fmt.Errorf("direction %q sends to its own emitter", direction)
+	}
+	return CommandDirection{From: from, To: to}, nil
+}
+
+// FromDevice reports whether a device, real or virtual, emits the command.
+func (d CommandDirection) FromDevice() bool {
+	return d.From == EndpointDevice || d.From == EndpointVirtualDevice
+}
+
+// ToDevice reports whether a device, real or virtual, receives the command.
+func (d CommandDirection) ToDevice() bool {
+	return d.To == EndpointDevice || d.To == EndpointVirtualDevice
+}
+
+// Endpoints returns the parsed direction of the command, the zero direction matching no endpoint when it doesn't
+// parse. The registry refuses to load a schema with such a direction.
+func (c *CommandSchema) Endpoints() CommandDirection {
+	direction, err := ParseDirection(c.Direction)
+	if err != nil {
+		return CommandDirection{}
+	}
+	return direction
 }
 
 type PayloadSchema struct {
-	Required   []string                           `json:"required" mapstructure:"required"`
-	Properties map[string]PayloadPropertiesSchema `json:"properties" mapstructure:"properties"`
+	Required      []string                           `json:"required" mapstructure:"required"`
+	Properties    map[string]PayloadPropertiesSchema `json:"properties" mapstructure:"properties"`
+	UnknownFields string                             `json:"unknown_fields" mapstructure:"unknown_fields"` // allow (default) or reject fields missing from properties
 }
 
+// Payload data types, see PayloadPropertiesSchema.DataType.
+const (
+	PayloadTypeString      = "string"
+	PayloadTypeInt         = "int"
+	PayloadTypeFloat       = "float"
+	PayloadTypeBool        = "bool"
+	PayloadTypeStringArray = "string_array"
+	PayloadTypeObject      = "object"
+)
+
+// Unknown field policies, see PayloadSchema.UnknownFields.
+const (
+	UnknownFieldsAllow  = "allow"
+	UnknownFieldsReject = "reject"
+)
+
 type PayloadPropertiesSchema struct {
 	Name     string `json:"name" mapstructure:"name"`
 	DataType string `json:"data_type" mapstructure:"data_type"`
 }
 
+// SchemaReload is the outcome of a hot reload of the schema file, a rejected reload keeps the loaded schema.
+type SchemaReload struct {
+	Applied bool           `json:"applied"`
+	Error   string         `json:"error,omitempty"`
+	Changes []SchemaChange `json:"changes"`
+}
+
 type DeviceCommandSchemaRegistry struct {
-	config *DeviceCommandSchemaConfig
-	mu     sync.RWMutex
-	v      *viper.Viper
-	l      *zap.Logger
+	config    *DeviceCommandSchemaConfig
+	listeners []func(SchemaReload)
+	checks    []func(*DeviceCommandSchemaConfig) error
+	mu        sync.RWMutex
+	v         *viper.Viper
+	l         *zap.Logger
 }
 
 func NewDeviceCommandSchemaRegistry() *DeviceCommandSchemaRegistry {
@@ -66,6 +183,11 @@ func (r *DeviceCommandSchemaRegistry) LoadDeviceCommandSchemaRegistry(filename,
 		return apperror.ErrConfigParse.WithMessage("error while parsing device command schema config").Wrap(err)
 	}
 
+	if err := validateSchemas(schemaCfg); err != nil {
+		l.Error("invalid device command schema config", zap.String("path", completePath), zap.Error(err))
+		return apperror.ErrConfigParse.WithMessage("invalid command in device command schema config").Wrap(err)
+	}
+
 	r.mu.Lock()
 	r.config = schemaCfg
 	r.mu.Unlock()
@@ -76,14 +198,10 @@ func (r *DeviceCommandSchemaRegistry) LoadDeviceCommandSchemaRegistry(filename,
 		var updated DeviceCommandSchemaConfig
 		if err := v.Unmarshal(&updated); err != nil {
 			l.Error("Failed to reload device command schema config", zap.Error(err))
+			r.notify(SchemaReload{Error: err.Error()})
 			return
 		}
-
-		r.mu.Lock()
-		r.config = &updated
-		r.mu.Unlock()
-
-		l.Info("[Reload]: Device command schema config reloaded successfully.")
+		r.Reload(&updated)
 	})
 
 	r.v = v
@@ -93,6 +211,75 @@ func (r *DeviceCommandSchemaRegistry) LoadDeviceCommandSchemaRegistry(filename,
 	return nil
 }
 
+// Reload replaces the loaded schema with the updated one unless it is invalid, fails a reload check or changes the
+// loaded one in a breaking way. The outcome is passed to the listeners and returned.
+func (r *DeviceCommandSchemaRegistry) Reload(updated *DeviceCommandSchemaConfig) SchemaReload {
+	l := r.l
+	if l == nil {
+		l = zap.NewNop()
+	}
+	reject := func(err error, changes []SchemaChange) SchemaReload {
+		l.Error("Rejected device command schema reload, keeping the loaded one", zap.Error(err))
+		reload := SchemaReload{Error: err.Error(), Changes: changes}
+		r.notify(reload)
+		return reload
+	}
+
+	if err := validateSchemas(updated); err != nil {
+		return reject(err, nil)
+	}
+	r.mu.RLock()
+	checks := r.checks
+	r.mu.RUnlock()
+	for _, check := range checks {
+		if err := check(updated); err != nil {
+			return reject(err, nil)
+		}
+	}
+
+	// compared and swapped under the lock, two quick saves of the file can't both pass against the same config
+	r.mu.Lock()
+	changes, err := DiffSchemas(r.config, updated)
+	if err == nil {
+		r.config = updated
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return reject(err, changes)
+	}
+
+	l.Info("[Reload]: Device command schema config reloaded successfully.", zap.Int("changes", len(changes)))
+	reload := SchemaReload{Applied: true, Changes: changes}
+	if len(changes) > 0 {
+		r.notify(reload)
+	}
+	return reload
+}
+
+// AddReloadCheck registers a check a reloaded schema has to pass before it replaces the loaded one, e.g. that every
+// command the server receives has a handler. The startup load doesn't run the checks, their owners do.
+func (r *DeviceCommandSchemaRegistry) AddReloadCheck(check func(*DeviceCommandSchemaConfig) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, check)
+}
+
+// OnReload registers a listener for the hot reloads of the schema file, applied or rejected.
+func (r *DeviceCommandSchemaRegistry) OnReload(fn func(SchemaReload)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+func (r *DeviceCommandSchemaRegistry) notify(reload SchemaReload) {
+	r.mu.RLock()
+	listeners := r.listeners
+	r.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(reload)
+	}
+}
+
 func (r *DeviceCommandSchemaRegistry) GetConfig() *DeviceCommandSchemaConfig {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -107,3 +294,35 @@ func (r *DeviceCommandSchemaRegistry) GetCommandByCode(commandCode string) *Comm
 	}
 	return nil
 }
+
+// GetCommandVersion returns the command as of the schema version, nil when the command or the version isn't active.
+// Version 0 is the latest.
+func (r *DeviceCommandSchemaRegistry) GetCommandVersion(commandCode string, version int) *CommandSchema {
+	cmd := r.GetCommandByCode(commandCode)
+	if cmd == nil {
+		return nil
+	}
+	return cmd.Version(version)
+}
+
+func validateSchemas(cfg *DeviceCommandSchemaConfig) error {
+	for code, schema := range cfg.Commands {
+		if _, err := ParseDirection(schema.Direction); err != nil {
+			return fmt.Errorf("%s: %w", code, err)
+		}
+		if schema.SchemaVersion < 1 {
+			return fmt.Errorf("%s: schema_version must be at least 1", code)
+		}
+		seen := map[int]bool{schema.SchemaVersion: true}
+		for _, previous := range schema.PreviousVersions {
+			if previous.SchemaVersion < 1 || previous.SchemaVersion >= schema.SchemaVersion {
+				return fmt.Errorf("%s: previous version %d must be between 1 and schema_version", code, previous.SchemaVersion)
+			}
+			if seen[previous.SchemaVersion] {
+				return fmt.Errorf("%s: version %d is declared twice", code, previous.SchemaVersion)
+			}
+			seen[previous.SchemaVersion] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config_test
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/commands"
+	"go.uber.org/zap"
+)
+
+func TestReloadRunsTheReloadChecks(t *testing.T) {
+	registry := config.NewDeviceCommandSchemaRegistry()
+	require.NoError(t, registry.LoadDeviceCommandSchemaRegistry("device.command.schema", "yaml", "../configs", zap.NewNop()))
+	handlers := commands.NewRegistry()
+	require.NoError(t, commands.RegisterDeviceHandlers(handlers))
+	require.NoError(t, handlers.Validate(registry.GetConfig()))
+	registry.AddReloadCheck(handlers.Validate)
+
+	var reloads []config.SchemaReload
+	registry.OnReload(func(reload config.SchemaReload) { reloads = append(reloads, reload) })
+
+	loaded := registry.GetConfig()
+	updated := *loaded
+	updated.Commands = maps.Clone(loaded.Commands)
+	updated.Commands["device@locate"] = config.CommandSchema{Type: "command", Command: "locate", SchemaVersion: 1, Direction: "d>s"}
+
+	// the server would receive device@locate without a handler for it
+	reload := registry.Reload(&updated)
+	assert.False(t, reload.Applied)
+	assert.Contains(t, reload.Error, "no handler for command(s) device@locate")
+	assert.Same(t, loaded, registry.GetConfig(), "the loaded schema is kept")
+
+	// the server only forwards what it sends to devices
+	locate := updated.Commands["device@locate"]
+	locate.Direction = "u>d"
+	updated.Commands["device@locate"] = locate
+	reload = registry.Reload(&updated)
+	assert.True(t, reload.Applied)
+	assert.Same(t, &updated, registry.GetConfig())
+	assert.Len(t, reloads, 2, "listeners hear of rejected and applied reloads")
+}
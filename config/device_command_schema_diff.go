@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Kinds of SchemaChange.
+const (
+	SchemaChangeAdded          = "added"           // new command
+	SchemaChangeRemoved        = "removed"         // command left out of the file
+	SchemaChangeField          = "field"           // type, command, direction or description of the command
+	SchemaChangePayload        = "payload"         // property, required field or unknown field policy
+	SchemaChangeVersion        = "version"         // schema_version moved
+	SchemaChangeVersionRetired = "version_retired" // previous version no longer active
+)
+
+// SchemaChange is one difference between the loaded schema of a command and the reloaded one. A breaking change
+// makes a device speaking the loaded version fail against the new one, it needs a higher schema_version.
+type SchemaChange struct {
+	CommandCode string `json:"command_code"`
+	Kind        string `json:"kind"`
+	Detail      string `json:"detail"`
+	Breaking    bool   `json:"breaking"`
+	FromVersion int    `json:"from_version,omitempty"`
+	ToVersion   int    `json:"to_version,omitempty"`
+}
+
+// DiffSchemas lists the changes from the loaded config to the updated one, by command code. It fails when a command
+// changes in a breaking way without a higher schema_version, moves its version back, retires an active version or is
+// removed. Devices may still send a removed command or speak a retired version they negotiated, so both take a
+// restart.
+func DiffSchemas(loaded, updated *DeviceCommandSchemaConfig) ([]SchemaChange, error) {
+	var changes []SchemaChange
+	var problems []string
+
+	for _, code := range sortedCommandCodes(loaded, updated) {
+		before, hadBefore := loaded.Commands[code]
+		after, hasAfter := updated.Commands[code]
+		switch {
+		case !hadBefore:
+			changes = append(changes, SchemaChange{CommandCode: code, Kind: SchemaChangeAdded, Detail: "command added", ToVersion: after.SchemaVersion})
+			continue
+		case !hasAfter:
+			changes = append(changes, SchemaChange{CommandCode: code, Kind: SchemaChangeRemoved, Detail: "command removed", Breaking: true, FromVersion: before.SchemaVersion})
+			problems = append(problems, fmt.Sprintf("%s: removing a command takes a restart", code))
+			continue
+		}
+
+		commandChanges := diffCommand(code, &before, &after)
+		changes = append(changes, commandChanges...)
+
+		// previous versions are frozen, devices still speak them
+		for _, previous := range after.PreviousVersions {
+			loadedVersion := before.Version(previous.SchemaVersion)
+			if loadedVersion == nil {
+				continue
+			}
+			for _, diff := range diffPayload(&loadedVersion.Payload, &previous.Payload) {
+				changes = append(changes, SchemaChange{
+					CommandCode: code,
+					Kind:        SchemaChangePayload,
+					Detail:      fmt.Sprintf("version %d: %s", previous.SchemaVersion, diff.detail),
+					Breaking:    diff.breaking,
+					FromVersion: previous.SchemaVersion,
+					ToVersion:   previous.SchemaVersion,
+				})
+				if diff.breaking {
+					problems = append(problems, fmt.Sprintf("%s: version %d is active, %s", code, previous.SchemaVersion, diff.detail))
+				}
+			}
+		}
+		if after.SchemaVersion < before.SchemaVersion {
+			problems = append(problems, fmt.Sprintf("%s: schema_version went back from %d to %d", code, before.SchemaVersion, after.SchemaVersion))
+			continue
+		}
+		for _, change := range commandChanges {
+			switch {
+			case change.Kind == SchemaChangeVersionRetired:
+				problems = append(problems, fmt.Sprintf("%s: %s, keep it in previous_versions, devices may have negotiated it", code, change.Detail))
+			case change.Breaking && after.SchemaVersion == before.SchemaVersion:
+				problems = append(problems, fmt.Sprintf("%s: %s without a schema_version bump", code, change.Detail))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return changes, fmt.Errorf("breaking schema changes: %s", strings.Join(problems, "; "))
+	}
+	return changes, nil
+}
+
+func diffCommand(code string, before, after *CommandSchema) []SchemaChange {
+	var changes []SchemaChange
+	change := func(kind string, breaking bool, format string, args ...interface{}) {
+		changes = append(changes, SchemaChange{
+			CommandCode: code,
+			Kind:        kind,
+			Detail:      fmt.Sprintf(format, args...),
+			Breaking:    breaking,
+			FromVersion: before.SchemaVersion,
+			ToVersion:   after.SchemaVersion,
+		})
+	}
+
+	if before.SchemaVersion != after.SchemaVersion {
+		change(SchemaChangeVersion, false, "schema_version %d -> %d", before.SchemaVersion, after.SchemaVersion)
+	}
+	if before.Type != after.Type {
+		change(SchemaChangeField, true, "type %q -> %q", before.Type, after.Type)
+	}
+	if before.Command != after.Command {
+		change(SchemaChangeField, true, "command %q -> %q", before.Command, after.Command)
+	}
+	if before.Direction != after.Direction {
+		change(SchemaChangeField, true, "direction %q -> %q", before.Direction, after.Direction)
+	}
+	if before.Description != after.Description {
+		change(SchemaChangeField, false, "description changed")
+	}
+
+	for _, diff := range diffPayload(&before.Payload, &after.Payload) {
+		change(SchemaChangePayload, diff.breaking, "%s", diff.detail)
+	}
+
+	// a bump without keeping the loaded version as a previous one retires it too
+	active := after.ActiveVersions()
+	for _, version := range before.ActiveVersions() {
+		if !slices.Contains(active, version) {
+			change(SchemaChangeVersionRetired, true, "version %d retired", version)
+		}
+	}
+	return changes
+}
+
+type payloadDiff struct {
+	detail   string
+	breaking bool
+}
+
+func diffPayload(before, after *PayloadSchema) []payloadDiff {
+	var diffs []payloadDiff
+
+	for _, field := range sortedKeys(before.Properties) {
+		previous := before.Properties[field]
+		current, ok := after.Properties[field]
+		if !ok {
+			diffs = append(diffs, payloadDiff{detail: fmt.Sprintf("property %s removed", field), breaking: true})
+			continue
+		}
+		if previous.DataType != current.DataType {
+			diffs = append(diffs, payloadDiff{detail: fmt.Sprintf("property %s %s -> %s", field, previous.DataType, current.DataType), breaking: true})
+		}
+	}
+	for _, field := range sortedKeys(after.Properties) {
+		if _, ok := before.Properties[field]; !ok {
+			diffs = append(diffs, payloadDiff{detail: fmt.Sprintf("property %s added", field), breaking: slices.Contains(after.Required, field)})
+		}
+	}
+
+	for _, field := range after.Required {
+		if !slices.Contains(before.Required, field) {
+			diffs = append(diffs, payloadDiff{detail: fmt.Sprintf("field %s now required", field), breaking: true})
+		}
+	}
+	for _, field := range before.Required {
+		if !slices.Contains(after.Required, field) {
+			diffs = append(diffs, payloadDiff{detail: fmt.Sprintf("field %s no longer required", field)})
+		}
+	}
+
+	if unknownFieldsPolicy(before) != unknownFieldsPolicy(after) {
+		diffs = append(diffs, payloadDiff{
+			detail:   fmt.Sprintf("unknown_fields %s -> %s", unknownFieldsPolicy(before), unknownFieldsPolicy(after)),
+			breaking: unknownFieldsPolicy(after) == UnknownFieldsReject,
+		})
+	}
+	return diffs
+}
+
+func unknownFieldsPolicy(payload *PayloadSchema) string {
+	if payload.UnknownFields == "" {
+		return UnknownFieldsAllow
+	}
+	return payload.UnknownFields
+}
+
+func sortedCommandCodes(configs ...*DeviceCommandSchemaConfig) []string {
+	seen := map[string]bool{}
+	var codes []string
+	for _, cfg := range configs {
+		for code := range cfg.Commands {
+			if !seen[code] {
+				seen[code] = true
+				codes = append(codes, code)
+			}
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func sortedKeys(properties map[string]PayloadPropertiesSchema) []string {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
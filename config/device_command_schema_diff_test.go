@@ -0,0 +1,68 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/config"
+)
+
+func rebootSchema(version int, required []string, properties map[string]string) config.CommandSchema {
+	schema := config.CommandSchema{
+		Type:          "command",
+		Command:       "reboot",
+		SchemaVersion: version,
+		Direction:     "u>d",
+		Payload:       config.PayloadSchema{Required: required, Properties: map[string]config.PayloadPropertiesSchema{}},
+	}
+	for field, dataType := range properties {
+		schema.Payload.Properties[field] = config.PayloadPropertiesSchema{DataType: dataType}
+	}
+	return schema
+}
+
+func TestDiffSchemasRequiresVersionBumpForBreakingChanges(t *testing.T) {
+	loaded := &config.DeviceCommandSchemaConfig{Commands: map[string]config.CommandSchema{
+		"device@reboot": rebootSchema(1, nil, map[string]string{"delay_seconds": "int"}),
+	}}
+
+	// an optional property is compatible
+	changes, err := config.DiffSchemas(loaded, &config.DeviceCommandSchemaConfig{Commands: map[string]config.CommandSchema{
+		"device@reboot": rebootSchema(1, nil, map[string]string{"delay_seconds": "int", "reason": "string"}),
+	}})
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.False(t, changes[0].Breaking)
+
+	// retyping a property needs a new version
+	retyped := rebootSchema(1, nil, map[string]string{"delay_seconds": "string"})
+	changes, err = config.DiffSchemas(loaded, &config.DeviceCommandSchemaConfig{Commands: map[string]config.CommandSchema{"device@reboot": retyped}})
+	assert.ErrorContains(t, err, "property delay_seconds int -> string without a schema_version bump")
+	require.Len(t, changes, 1)
+	assert.True(t, changes[0].Breaking)
+
+	// the bump keeps version 1 active for the devices still on it
+	bumped := rebootSchema(2, nil, map[string]string{"delay_seconds": "string"})
+	bumped.PreviousVersions = []config.CommandSchemaVersion{{SchemaVersion: 1, Payload: loaded.Commands["device@reboot"].Payload}}
+	_, err = config.DiffSchemas(loaded, &config.DeviceCommandSchemaConfig{Commands: map[string]config.CommandSchema{"device@reboot": bumped}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, bumped.ActiveVersions())
+	assert.Equal(t, "int", bumped.Version(1).Payload.Properties["delay_seconds"].DataType)
+	assert.Nil(t, bumped.Version(3))
+
+	// a bump dropping the loaded version leaves the devices that negotiated it without a schema
+	retired := rebootSchema(2, nil, map[string]string{"delay_seconds": "string"})
+	changes, err = config.DiffSchemas(loaded, &config.DeviceCommandSchemaConfig{Commands: map[string]config.CommandSchema{"device@reboot": retired}})
+	assert.ErrorContains(t, err, "device@reboot: version 1 retired, keep it in previous_versions")
+	assert.Contains(t, changes, config.SchemaChange{CommandCode: "device@reboot", Kind: config.SchemaChangeVersionRetired, Detail: "version 1 retired", Breaking: true, FromVersion: 1, ToVersion: 2})
+
+	// active previous versions are frozen and commands can't go away
+	changed := bumped
+	changed.PreviousVersions = []config.CommandSchemaVersion{{SchemaVersion: 1, Payload: config.PayloadSchema{Required: []string{"reason"}}}}
+	_, err = config.DiffSchemas(&config.DeviceCommandSchemaConfig{Commands: map[string]config.CommandSchema{"device@reboot": bumped}},
+		&config.DeviceCommandSchemaConfig{Commands: map[string]config.CommandSchema{"device@reboot": changed}})
+	assert.ErrorContains(t, err, "version 1 is active")
+	_, err = config.DiffSchemas(loaded, &config.DeviceCommandSchemaConfig{Commands: map[string]config.CommandSchema{}})
+	assert.ErrorContains(t, err, "removing a command takes a restart")
+}
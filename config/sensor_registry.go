@@ -85,6 +85,32 @@ func LoadSensorSchemaRegistry(filename string, filetype string, path string, bas
 	return nil
 }
 
+// FindSchema returns the schema registered for the sensor code and version.
+func (c *SensorSchemaConfig) FindSchema(sensorCode string, schemaVersion int) *SensorSchema {
+	if c == nil {
+		return nil
+	}
+	for i := range c.Schema {
+		if c.Schema[i].SensorCode == sensorCode && c.Schema[i].SchemaVersion == schemaVersion {
+			return &c.Schema[i]
+		}
+	}
+	return nil
+}
+
+// HasSensorCode reports whether any schema version is registered for the sensor code.
+func (c *SensorSchemaConfig) HasSensorCode(sensorCode string) bool {
+	if c == nil {
+		return false
+	}
+	for i := range c.Schema {
+		if c.Schema[i].SensorCode == sensorCode {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *SensorSchemaRegistry) GetConfig() *SensorSchemaConfig {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
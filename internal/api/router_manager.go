@@ -79,10 +79,8 @@ func (r *APIRouterManager) AddWebsocketRoute(wsRoute WsRouteConfig) {
 
 func (r *APIRouterManager) MountWebsockets() {
 	for _, route := range r.wsRoutes {
-		if len(route.Middleware) > 0 {
-			r.base.Use(route.Middleware...)
-		}
-		r.base.GET(route.Path, route.Handler)
+		// route level middleware, Use on the base group would apply it to every route mounted after
+		r.base.GET(route.Path, route.Handler, route.Middleware...)
 	}
 	r.logger.Info("websocket routes mounted", zap.Int("count", len(r.wsRoutes)))
 }
@@ -0,0 +1,139 @@
+package dto
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/validation"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/pagination"
+)
+
+var alertSortFields = map[string]bool{
+	"triggered_at": true,
+	"created_at":   true,
+	"updated_at":   true,
+	"severity":     true,
+	"status":       true,
+}
+
+type AlertQueryParamsDTO struct {
+	DeviceID      *string `query:"device_id" validate:"omitempty,uuid"`
+	SensorID      *string `query:"sensor_id" validate:"omitempty,uuid"`
+	SensorCode    *string `query:"sensor_code"`
+	Status        *string `query:"status"` // comma separated, e.g. open,acknowledged
+	GroupKey      *string `query:"group_key"`
+	Severity      *string `query:"severity" validate:"omitempty,oneof=info warning critical"`
+	TriggeredFrom *string `query:"triggered_from"`
+	TriggeredTo   *string `query:"triggered_to"`
+	Limit         int     `query:"limit"`
+	Offset        int     `query:"offset"`
+	SortBy        string  `query:"sort_by"`
+	SortOrder     string  `query:"sort_order"`
+}
+
+func (dto *AlertQueryParamsDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *AlertQueryParamsDTO) AsModel() (*pagination.Pagination, *AlertFilter, error) {
+	const defaultLimit = 20
+	const maxLimit = 100
+
+	// Sanitize Limit
+	limit := dto.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("limit exceeds maximum allowed %d", maxLimit)
+	}
+
+	// Sanitize Offset
+	if dto.Offset < 0 {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("offset cannot be negative %d", dto.Offset)
+	}
+	page := (dto.Offset / limit) + 1
+
+	// Sanitize Sort
+	sortBy := dto.SortBy
+	if sortBy == "" {
+		sortBy = "triggered_at"
+	} else if !alertSortFields[sortBy] {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("cannot sort alerts by %s", sortBy)
+	}
+	sortOrder := strings.ToUpper(dto.SortOrder)
+	if sortOrder != "ASC" && sortOrder != "DESC" {
+		sortOrder = "DESC"
+	}
+
+	paginationConfig := &pagination.Pagination{
+		Page:      page,
+		PageSize:  limit,
+		SortBy:    sortBy,
+		SortOrder: sortOrder,
+	}
+
+	filter := &AlertFilter{}
+
+	if dto.DeviceID != nil && *dto.DeviceID != "" {
+		deviceID := uuid.MustParse(*dto.DeviceID) // validated
+		filter.DeviceID = &deviceID
+	}
+	if dto.SensorID != nil && *dto.SensorID != "" {
+		sensorID := uuid.MustParse(*dto.SensorID) // validated
+		filter.SensorID = &sensorID
+	}
+	if dto.SensorCode != nil && *dto.SensorCode != "" {
+		filter.SensorCode = dto.SensorCode
+	}
+	if dto.Status != nil && *dto.Status != "" {
+		for _, status := range strings.Split(*dto.Status, ",") {
+			status = strings.TrimSpace(status)
+			if !model.IsValidAlertStatus(status) {
+				return nil, nil, apperror.ErrBadRequest.WithMessagef("invalid alert status %s", status)
+			}
+			filter.Status = append(filter.Status, status)
+		}
+	}
+	if dto.GroupKey != nil && *dto.GroupKey != "" {
+		filter.GroupKey = dto.GroupKey
+	}
+	if dto.Severity != nil && *dto.Severity != "" {
+		filter.Severity = dto.Severity
+	}
+	if dto.TriggeredFrom != nil && *dto.TriggeredFrom != "" {
+		if t, err := time.Parse(time.RFC3339, *dto.TriggeredFrom); err == nil {
+			filter.TriggeredFrom = &t
+		} else {
+			return nil, nil, apperror.ErrBadRequest.WithMessage("invalid triggered_from format (expected RFC3339)")
+		}
+	}
+	if dto.TriggeredTo != nil && *dto.TriggeredTo != "" {
+		if t, err := time.Parse(time.RFC3339, *dto.TriggeredTo); err == nil {
+			filter.TriggeredTo = &t
+		} else {
+			return nil, nil, apperror.ErrBadRequest.WithMessage("invalid triggered_to format (expected RFC3339)")
+		}
+	}
+
+	return paginationConfig, filter, nil
+}
+
+// AlertActionRequest is the body of acknowledge and resolve, the note is optional.
+type AlertActionRequest struct {
+	Note string `json:"note" validate:"omitempty,max=2000"`
+}
+
+func (dto *AlertActionRequest) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+type AlertNoteRequest struct {
+	Body string `json:"body" validate:"required,max=2000"`
+}
+
+func (dto *AlertNoteRequest) Validate() error {
+	return validation.Validate.Struct(dto)
+}
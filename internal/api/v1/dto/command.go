@@ -0,0 +1,161 @@
+package dto
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/validation"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/pagination"
+)
+
+var commandSortFields = map[string]bool{
+	"created_at":   true,
+	"updated_at":   true,
+	"status":       true,
+	"command_code": true,
+	"expires_at":   true,
+}
+
+// SendCommandRequest is the body of POST /device/:id/commands, the command code must be defined in
+// device.command.schema.yaml.
+type SendCommandRequest struct {
+	CommandCode       string                 `json:"command_code" validate:"required,max=100"`
+	Payload           map[string]interface{} `json:"payload"`
+	TTLSeconds        int                    `json:"ttl_seconds" validate:"omitempty,min=1"`         // defaults to command.default_ttl
+	AckTimeoutSeconds int                    `json:"ack_timeout_seconds" validate:"omitempty,min=1"` // wait of the first attempt for the ack, defaults to command.ack_timeout
+	MaxAttempts       int                    `json:"max_attempts" validate:"omitempty,min=1,max=10"` // defaults to command.max_attempts
+	IdempotencyKey    string                 `json:"idempotency_key" validate:"omitempty,max=255"`   // or the Idempotency-Key header, a retried request returns the first command
+	RPC               bool                   `json:"rpc"`                                            // wait for the device's answer instead of returning once the command is sent
+	RPCTimeoutSeconds int                    `json:"rpc_timeout_seconds" validate:"omitempty,min=1"` // wait of a rpc, defaults to command.rpc_timeout
+}
+
+func (dto *SendCommandRequest) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *SendCommandRequest) TTL() time.Duration {
+	return time.Duration(dto.TTLSeconds) * time.Second
+}
+
+func (dto *SendCommandRequest) RPCTimeout() time.Duration {
+	return time.Duration(dto.RPCTimeoutSeconds) * time.Second
+}
+
+func (dto *SendCommandRequest) AsModel(deviceID uuid.UUID, issuedBy uuid.UUID) (*model.Command, error) {
+	raw, err := marshalCommandPayload(dto.Payload)
+	if err != nil {
+		return nil, err
+	}
+	command := &model.Command{
+		DeviceID:          deviceID,
+		CommandCode:       dto.CommandCode,
+		Payload:           raw,
+		IssuedBy:          &issuedBy,
+		AckTimeoutSeconds: dto.AckTimeoutSeconds,
+		MaxAttempts:       dto.MaxAttempts,
+	}
+	if dto.IdempotencyKey != "" {
+		command.IdempotencyKey = &dto.IdempotencyKey
+	}
+	return command, nil
+}
+
+type CommandQueryParamsDTO struct {
+	CommandCode *string `query:"command_code"`
+	Status      *string `query:"status"` // comma separated, e.g. sent,delivered
+	CreatedFrom *string `query:"created_from"`
+	CreatedTo   *string `query:"created_to"`
+	Limit       int     `query:"limit"`
+	Offset      int     `query:"offset"`
+	SortBy      string  `query:"sort_by"`
+	SortOrder   string  `query:"sort_order"`
+}
+
+func (dto *CommandQueryParamsDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+// AsModel returns the command history query of the device.
+func (dto *CommandQueryParamsDTO) AsModel(deviceID uuid.UUID) (*pagination.Pagination, *CommandFilter, error) {
+	return dto.asModel(&CommandFilter{DeviceID: &deviceID})
+}
+
+// AsScheduleModel returns the query of the commands the schedule sent.
+func (dto *CommandQueryParamsDTO) AsScheduleModel(scheduleID uuid.UUID) (*pagination.Pagination, *CommandFilter, error) {
+	return dto.asModel(&CommandFilter{ScheduleID: &scheduleID})
+}
+
+// AsCampaignModel returns the query of the commands the campaign sent.
+func (dto *CommandQueryParamsDTO) AsCampaignModel(campaignID uuid.UUID) (*pagination.Pagination, *CommandFilter, error) {
+	return dto.asModel(&CommandFilter{CampaignID: &campaignID})
+}
+
+func (dto *CommandQueryParamsDTO) asModel(filter *CommandFilter) (*pagination.Pagination, *CommandFilter, error) {
+	const defaultLimit = 20
+	const maxLimit = 100
+
+	// Sanitize Limit
+	limit := dto.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("limit exceeds maximum allowed %d", maxLimit)
+	}
+
+	// Sanitize Offset
+	if dto.Offset < 0 {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("offset cannot be negative %d", dto.Offset)
+	}
+	page := (dto.Offset / limit) + 1
+
+	// Sanitize Sort
+	sortBy := dto.SortBy
+	if sortBy == "" {
+		sortBy = "created_at"
+	} else if !commandSortFields[sortBy] {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("cannot sort commands by %s", sortBy)
+	}
+	sortOrder := strings.ToUpper(dto.SortOrder)
+	if sortOrder != "ASC" && sortOrder != "DESC" {
+		sortOrder = "DESC"
+	}
+
+	paginationConfig := &pagination.Pagination{
+		Page:      page,
+		PageSize:  limit,
+		SortBy:    sortBy,
+		SortOrder: sortOrder,
+	}
+
+	if dto.CommandCode != nil && *dto.CommandCode != "" {
+		filter.CommandCode = dto.CommandCode
+	}
+	if dto.Status != nil && *dto.Status != "" {
+		for _, status := range strings.Split(*dto.Status, ",") {
+			status = strings.TrimSpace(status)
+			if !model.IsValidCommandStatus(status) {
+				return nil, nil, apperror.ErrBadRequest.WithMessagef("invalid command status %s", status)
+			}
+			filter.Status = append(filter.Status, status)
+		}
+	}
+	if dto.CreatedFrom != nil && *dto.CreatedFrom != "" {
+		if t, err := time.Parse(time.RFC3339, *dto.CreatedFrom); err == nil {
+			filter.CreatedFrom = &t
+		} else {
+			return nil, nil, apperror.ErrBadRequest.WithMessage("invalid created_from format (expected RFC3339)")
+		}
+	}
+	if dto.CreatedTo != nil && *dto.CreatedTo != "" {
+		if t, err := time.Parse(time.RFC3339, *dto.CreatedTo); err == nil {
+			filter.CreatedTo = &t
+		} else {
+			return nil, nil, apperror.ErrBadRequest.WithMessage("invalid created_to format (expected RFC3339)")
+		}
+	}
+
+	return paginationConfig, filter, nil
+}
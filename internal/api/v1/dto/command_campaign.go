@@ -0,0 +1,91 @@
+package dto
+
+import (
+	"strings"
+
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/validation"
+	"github.com/vars7899/iots/pkg/apperror"
+)
+
+const defaultCampaignMaxFailurePercent = 10
+
+type CommandCampaignQueryParamsDTO struct {
+	Status *string `query:"status"` // comma separated, e.g. running,paused
+}
+
+func (dto *CommandCampaignQueryParamsDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *CommandCampaignQueryParamsDTO) AsModel() ([]model.CampaignStatus, error) {
+	if dto.Status == nil || *dto.Status == "" {
+		return nil, nil
+	}
+	var statuses []model.CampaignStatus
+	for _, status := range strings.Split(*dto.Status, ",") {
+		status = strings.TrimSpace(status)
+		if !model.IsValidCampaignStatus(status) {
+			return nil, apperror.ErrBadRequest.WithMessagef("invalid campaign status: %s", status)
+		}
+		statuses = append(statuses, model.CampaignStatus(status))
+	}
+	return statuses, nil
+}
+
+// CreateCommandCampaignRequest rolls a command out to the devices in device_ids, or to the devices having all of the
+// tags and capabilities and one of the statuses. Waves either cover a cumulative share of the devices, e.g.
+// wave_percents [1, 10, 100], or batch_size devices each.
+type CreateCommandCampaignRequest struct {
+	Name                string                 `json:"name" validate:"required,max=255"`
+	Description         string                 `json:"description"`
+	CommandCode         string                 `json:"command_code" validate:"required,max=100"`
+	Payload             map[string]interface{} `json:"payload"`
+	TTLSeconds          int                    `json:"ttl_seconds" validate:"omitempty,min=1"`
+	DeviceIDs           []string               `json:"device_ids" validate:"omitempty,dive,uuid"`
+	Tags                []string               `json:"tags" validate:"omitempty,dive,min=1"`
+	Capabilities        []string               `json:"capabilities" validate:"omitempty,dive,min=1"`
+	Statuses            []string               `json:"statuses"`
+	WavePercents        []int64                `json:"wave_percents" validate:"omitempty,dive,min=1,max=100"`
+	BatchSize           int                    `json:"batch_size" validate:"omitempty,min=1"`
+	WaveIntervalSeconds int                    `json:"wave_interval_seconds" validate:"omitempty,min=0"`
+	MaxFailurePercent   *int                   `json:"max_failure_percent" validate:"omitempty,min=0,max=100"` // defaults to 10
+}
+
+func (dto *CreateCommandCampaignRequest) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *CreateCommandCampaignRequest) AsModel() (*model.CommandCampaign, error) {
+	payload, err := marshalCommandPayload(dto.Payload)
+	if err != nil {
+		return nil, err
+	}
+	campaign := &model.CommandCampaign{
+		Name:                dto.Name,
+		Description:         dto.Description,
+		CommandCode:         dto.CommandCode,
+		Payload:             payload,
+		TTLSeconds:          dto.TTLSeconds,
+		DeviceIDs:           dto.DeviceIDs,
+		Tags:                dto.Tags,
+		Capabilities:        dto.Capabilities,
+		Statuses:            dto.Statuses,
+		WavePercents:        dto.WavePercents,
+		BatchSize:           dto.BatchSize,
+		WaveIntervalSeconds: dto.WaveIntervalSeconds,
+		MaxFailurePercent:   defaultCampaignMaxFailurePercent,
+	}
+	if dto.MaxFailurePercent != nil {
+		campaign.MaxFailurePercent = *dto.MaxFailurePercent
+	}
+	return campaign, nil
+}
+
+type CommandCampaignActionRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+func (dto *CommandCampaignActionRequest) Validate() error {
+	return validation.Validate.Struct(dto)
+}
@@ -0,0 +1,136 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/validation"
+	"github.com/vars7899/iots/pkg/apperror"
+)
+
+type CommandScheduleQueryParamsDTO struct {
+	DeviceID *string `query:"device_id" validate:"omitempty,uuid"`
+}
+
+func (dto *CommandScheduleQueryParamsDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+// CreateCommandScheduleRequest schedules a command once at run_at or on a cron expression, e.g. "0 3 * * sun" with
+// timezone "Europe/Berlin" for every Sunday at 03:00 local time.
+type CreateCommandScheduleRequest struct {
+	Name        string                 `json:"name" validate:"required,max=255"`
+	Description string                 `json:"description"`
+	TargetType  string                 `json:"target_type" validate:"required,oneof=device tag site"`
+	DeviceID    *string                `json:"device_id" validate:"omitempty,uuid"`
+	Tag         string                 `json:"tag" validate:"max=255"`
+	Site        string                 `json:"site" validate:"max=255"`
+	CommandCode string                 `json:"command_code" validate:"required,max=100"`
+	Payload     map[string]interface{} `json:"payload"`
+	TTLSeconds  int                    `json:"ttl_seconds" validate:"omitempty,min=1"`
+	RunAt       *time.Time             `json:"run_at"`
+	Cron        string                 `json:"cron" validate:"max=100"`
+	Timezone    string                 `json:"timezone" validate:"max=64"` // defaults to UTC
+	Enabled     *bool                  `json:"enabled"`                    // defaults to true
+}
+
+func (dto *CreateCommandScheduleRequest) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *CreateCommandScheduleRequest) AsModel() (*model.CommandSchedule, error) {
+	payload, err := marshalCommandPayload(dto.Payload)
+	if err != nil {
+		return nil, err
+	}
+	schedule := &model.CommandSchedule{
+		Name:        dto.Name,
+		Description: dto.Description,
+		Target: model.CommandTarget{
+			TargetType: model.CommandTargetType(dto.TargetType),
+			Tag:        dto.Tag,
+			Site:       dto.Site,
+		},
+		CommandCode: dto.CommandCode,
+		Payload:     payload,
+		TTLSeconds:  dto.TTLSeconds,
+		RunAt:       dto.RunAt,
+		Cron:        dto.Cron,
+		Timezone:    dto.Timezone,
+		Enabled:     dto.Enabled == nil || *dto.Enabled,
+	}
+	if dto.DeviceID != nil {
+		deviceID := uuid.MustParse(*dto.DeviceID)
+		schedule.Target.DeviceID = &deviceID
+	}
+	return schedule, nil
+}
+
+// UpdateCommandScheduleRequest changes a schedule, its target and command code are fixed once created. Setting
+// run_at turns the schedule into a one-off one, setting cron into a recurring one.
+type UpdateCommandScheduleRequest struct {
+	Name        *string                 `json:"name" validate:"omitempty,min=1,max=255"`
+	Description *string                 `json:"description"`
+	Payload     *map[string]interface{} `json:"payload"`
+	TTLSeconds  *int                    `json:"ttl_seconds" validate:"omitempty,min=0"`
+	RunAt       *time.Time              `json:"run_at"`
+	Cron        *string                 `json:"cron" validate:"omitempty,min=1,max=100"`
+	Timezone    *string                 `json:"timezone" validate:"omitempty,min=1,max=64"`
+	Enabled     *bool                   `json:"enabled"`
+}
+
+func (dto *UpdateCommandScheduleRequest) Validate() error {
+	if err := validation.Validate.Struct(dto); err != nil {
+		return err
+	}
+	if dto.RunAt != nil && dto.Cron != nil {
+		return apperror.ErrValidation.WithMessage("set either run_at or cron")
+	}
+	return nil
+}
+
+// ApplyTo copies the fields present in the request onto the schedule.
+func (dto *UpdateCommandScheduleRequest) ApplyTo(schedule *model.CommandSchedule) error {
+	if dto.Name != nil {
+		schedule.Name = *dto.Name
+	}
+	if dto.Description != nil {
+		schedule.Description = *dto.Description
+	}
+	if dto.Payload != nil {
+		payload, err := marshalCommandPayload(*dto.Payload)
+		if err != nil {
+			return err
+		}
+		schedule.Payload = payload
+	}
+	if dto.TTLSeconds != nil {
+		schedule.TTLSeconds = *dto.TTLSeconds
+	}
+	if dto.RunAt != nil {
+		schedule.RunAt, schedule.Cron = dto.RunAt, ""
+	}
+	if dto.Cron != nil {
+		schedule.RunAt, schedule.Cron = nil, *dto.Cron
+	}
+	if dto.Timezone != nil {
+		schedule.Timezone = *dto.Timezone
+	}
+	if dto.Enabled != nil {
+		schedule.Enabled = *dto.Enabled
+	}
+	return nil
+}
+
+func marshalCommandPayload(payload map[string]interface{}) ([]byte, error) {
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, apperror.ErrBadRequest.WithMessage("invalid command payload").Wrap(err)
+	}
+	return raw, nil
+}
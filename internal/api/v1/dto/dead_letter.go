@@ -0,0 +1,142 @@
+package dto
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/validation"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/pagination"
+)
+
+var deadLetterSortFields = map[string]bool{
+	"last_failed_at":  true,
+	"first_failed_at": true,
+	"attempts":        true,
+	"command_code":    true,
+	"status":          true,
+}
+
+const defaultDeadLetterVolumeWindow = 24 * time.Hour
+
+type DeadLetterQueryParamsDTO struct {
+	Subject     *string `query:"subject"`
+	DeviceID    *string `query:"device_id" validate:"omitempty,uuid"`
+	CommandCode *string `query:"command_code"`
+	ErrorCode   *string `query:"error_code"`
+	Status      *string `query:"status"` // comma separated, e.g. pending,redriven
+	FailedFrom  *string `query:"failed_from"`
+	FailedTo    *string `query:"failed_to"`
+	Limit       int     `query:"limit"`
+	Offset      int     `query:"offset"`
+	SortBy      string  `query:"sort_by"`
+	SortOrder   string  `query:"sort_order"`
+}
+
+func (dto *DeadLetterQueryParamsDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *DeadLetterQueryParamsDTO) AsModel() (*pagination.Pagination, *DeadLetterFilter, error) {
+	const defaultLimit = 20
+	const maxLimit = 100
+
+	limit := dto.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("limit exceeds maximum allowed %d", maxLimit)
+	}
+	if dto.Offset < 0 {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("offset cannot be negative %d", dto.Offset)
+	}
+	page := (dto.Offset / limit) + 1
+
+	sortBy := dto.SortBy
+	if sortBy == "" {
+		sortBy = "last_failed_at"
+	} else if !deadLetterSortFields[sortBy] {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("cannot sort dead letters by %s", sortBy)
+	}
+	sortOrder := strings.ToUpper(dto.SortOrder)
+	if sortOrder != "ASC" && sortOrder != "DESC" {
+		sortOrder = "DESC"
+	}
+
+	paginationConfig := &pagination.Pagination{
+		Page:      page,
+		PageSize:  limit,
+		SortBy:    sortBy,
+		SortOrder: sortOrder,
+	}
+
+	filter := &DeadLetterFilter{}
+	if dto.Subject != nil && *dto.Subject != "" {
+		filter.Subject = dto.Subject
+	}
+	if dto.DeviceID != nil && *dto.DeviceID != "" {
+		deviceID := uuid.MustParse(*dto.DeviceID)
+		filter.DeviceID = &deviceID
+	}
+	if dto.CommandCode != nil && *dto.CommandCode != "" {
+		filter.CommandCode = dto.CommandCode
+	}
+	if dto.ErrorCode != nil && *dto.ErrorCode != "" {
+		filter.ErrorCode = dto.ErrorCode
+	}
+	if dto.Status != nil && *dto.Status != "" {
+		for _, status := range strings.Split(*dto.Status, ",") {
+			status = strings.TrimSpace(status)
+			if !model.IsValidDeadLetterStatus(status) {
+				return nil, nil, apperror.ErrBadRequest.WithMessagef("invalid dead letter status %s", status)
+			}
+			filter.Status = append(filter.Status, status)
+		}
+	}
+	if dto.FailedFrom != nil && *dto.FailedFrom != "" {
+		if t, err := time.Parse(time.RFC3339, *dto.FailedFrom); err == nil {
+			filter.FailedFrom = &t
+		} else {
+			return nil, nil, apperror.ErrBadRequest.WithMessage("invalid failed_from format (expected RFC3339)")
+		}
+	}
+	if dto.FailedTo != nil && *dto.FailedTo != "" {
+		if t, err := time.Parse(time.RFC3339, *dto.FailedTo); err == nil {
+			filter.FailedTo = &t
+		} else {
+			return nil, nil, apperror.ErrBadRequest.WithMessage("invalid failed_to format (expected RFC3339)")
+		}
+	}
+
+	return paginationConfig, filter, nil
+}
+
+// EditDeadLetterRequest is the body of PATCH /command/dead-letters/:id, the message replaces the one received.
+type EditDeadLetterRequest struct {
+	Message string `json:"message" validate:"required"`
+}
+
+func (dto *EditDeadLetterRequest) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+type DeadLetterVolumeQueryDTO struct {
+	Since *string `query:"since"` // RFC3339, the last 24 hours by default
+}
+
+func (dto *DeadLetterVolumeQueryDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *DeadLetterVolumeQueryDTO) SinceTime(now time.Time) (time.Time, error) {
+	if dto.Since == nil || *dto.Since == "" {
+		return now.Add(-defaultDeadLetterVolumeWindow), nil
+	}
+	since, err := time.Parse(time.RFC3339, *dto.Since)
+	if err != nil {
+		return time.Time{}, apperror.ErrBadRequest.WithMessage("invalid since format (expected RFC3339)")
+	}
+	return since, nil
+}
@@ -1,9 +1,18 @@
 package dto
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
 	"github.com/lib/pq"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/domain"
 	"github.com/vars7899/iots/internal/domain/model"
 	"github.com/vars7899/iots/internal/validation"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/pagination"
 	"gorm.io/datatypes"
 )
 
@@ -18,183 +27,6 @@ type RefreshSessionTokenRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
 }
 
-// type CreateNewDeviceDTO struct {
-// 	Name            string             `json:"name" validate:"required,max=255"`
-// 	Description     string             `json:"description,omitempty" validate:"max=255"`
-// 	Manufacturer    string             `json:"manufacturer" validate:"required"`
-// 	ModelNumber     string             `json:"model_number" validate:"required"`
-// 	SerialNumber    string             `json:"serial_number" validate:"required"`
-// 	FirmwareVersion string             `json:"firmware_version" validate:"required"`
-// 	IPAddress       string             `json:"ip_address" validate:"omitempty,ip"`
-// 	MACAddress      string             `json:"mac_address" validate:"omitempty,max=17"`
-// 	ConnectionType  string             `json:"connection_type" validate:"required,connection_type"`
-// 	Location        GeoLocationDTO     `json:"location" validate:"required"`
-// 	Metadata        *datatypes.JSON    `json:"metadata" validate:"omitempty"` // TODO: meta data can have maximum 100 fields
-// 	Tags            pq.StringArray     `json:"tags" validate:"omitempty,dive,min=1"`
-// 	Capabilities    pq.StringArray     `json:"capabilities" validate:"omitempty,dive,min=1"`
-// 	TelemetryConfig TelemetryConfigDTO `json:"telemetry_config"`
-// 	BroadcastConfig BroadcastConfigDTO `json:"broadcast_config"`
-// }
-
-// func (dto *CreateNewDeviceDTO) Validate() error { return validation.Validate.Struct(dto) }
-
-// func (dto *CreateNewDeviceDTO) AsModel() *model.Device {
-// 	device := &model.Device{
-// 		Name:            dto.Name,
-// 		Description:     dto.Description,
-// 		Manufacturer:    dto.Manufacturer,
-// 		ModelNumber:     dto.ModelNumber,
-// 		SerialNumber:    dto.SerialNumber,
-// 		FirmwareVersion: dto.FirmwareVersion,
-// 		IPAddress:       dto.IPAddress,
-// 		MACAddress:      dto.MACAddress,
-// 		ConnectionType:  domain.ConnectionType(dto.ConnectionType),
-// 		Location:        *dto.Location.AsModel(),
-// 		Tags:            dto.Tags,
-// 		Capabilities:    dto.Capabilities,
-// 		TelemetryConfig: *dto.TelemetryConfig.AsModel(),
-// 		BroadcastConfig: *dto.BroadcastConfig.AsModel(),
-// 	}
-
-// 	if dto.Metadata != nil {
-// 		device.Metadata = *dto.Metadata
-// 	}
-
-// 	return device
-// }
-
-// type UpdateDeviceDTO struct {
-// 	Name            *string `json:"name,omitempty" validate:"omitempty,max=255"`
-// 	Description     *string `json:"description,omitempty" validate:"omitempty"`
-// 	Manufacturer    *string `json:"manufacturer,omitempty" validate:"omitempty"`
-// 	ModelNumber     *string `json:"model_number,omitempty" validate:"omitempty"`
-// 	SerialNumber    *string `json:"serial_number,omitempty" validate:"omitempty"`
-// 	FirmwareVersion *string `json:"firmware_version,omitempty" validate:"omitempty"`
-// 	IPAddress       *string `json:"ip_address,omitempty" validate:"omitempty,ip"`
-// 	MACAddress      *string `json:"mac_address,omitempty" validate:"omitempty,max=17"`
-// 	ConnectionType  *string `json:"connection_type,omitempty" validate:"omitempty"`
-// }
-
-// func (dto *UpdateDeviceDTO) Validate() error { return validation.Validate.Struct(dto) }
-
-// func (dto *UpdateDeviceDTO) ToDevice() *model.Device {
-// 	device := &model.Device{}
-
-// 	if dto.Name != nil {
-// 		device.Name = *dto.Name
-// 	}
-// 	if dto.Description != nil {
-// 		device.Description = *dto.Description
-// 	}
-// 	if dto.Manufacturer != nil {
-// 		device.Manufacturer = *dto.Manufacturer
-// 	}
-// 	if dto.ModelNumber != nil {
-// 		device.ModelNumber = *dto.ModelNumber
-// 	}
-// 	if dto.SerialNumber != nil {
-// 		device.SerialNumber = *dto.SerialNumber
-// 	}
-// 	if dto.FirmwareVersion != nil {
-// 		device.FirmwareVersion = *dto.FirmwareVersion
-// 	}
-// 	if dto.IPAddress != nil {
-// 		device.IPAddress = *dto.IPAddress
-// 	}
-// 	if dto.MACAddress != nil {
-// 		device.MACAddress = *dto.MACAddress
-// 	}
-// 	if dto.ConnectionType != nil {
-// 		device.ConnectionType = domain.ConnectionType(*dto.ConnectionType)
-// 	}
-
-// 	return device
-// }
-
-// type BulkCreateDevicesDTO struct {
-// 	Devices []CreateNewDeviceDTO `json:"devices" validate:"required,dive"`
-// }
-
-// func (dto *BulkCreateDevicesDTO) Validate() error {
-// 	return validation.Validate.Struct(dto)
-// }
-
-// func (dto *BulkCreateDevicesDTO) ToDevices() []*model.Device {
-// 	devices := make([]*model.Device, 0, len(dto.Devices))
-// 	for _, d := range dto.Devices {
-// 		devices = append(devices, d.AsModel())
-// 	}
-// 	return devices
-// }
-
-// type BulkDeleteDeviceDTO struct {
-// 	DeviceIDs []string `json:"device_ids" validate:"required,min=1,dive,required,uuid"`
-// }
-
-// // After validation, convert strings to UUIDs
-// func (dto *BulkDeleteDeviceDTO) ToUUIDs() ([]uuid.UUID, error) {
-// 	uuids := make([]uuid.UUID, len(dto.DeviceIDs))
-// 	for i, id := range dto.DeviceIDs {
-// 		uid, err := uuid.Parse(id)
-// 		if err != nil {
-// 			return nil, err
-// 		}
-// 		uuids[i] = uid
-// 	}
-// 	return uuids, nil
-// }
-
-// func (dto *BulkDeleteDeviceDTO) Validate() error {
-// 	err := validation.Validate.Struct(dto)
-// 	if err != nil {
-// 		// Handle validation errors
-// 		if validationErrors, ok := err.(validator.ValidationErrors); ok {
-// 			// You can format validation errors here
-// 			return fmt.Errorf("validation error: %v", validationErrors)
-// 		}
-// 		return err
-// 	}
-
-// 	// Additional custom validation logic
-// 	if len(dto.DeviceIDs) == 0 {
-// 		return fmt.Errorf("at least one device ID is required")
-// 	}
-
-// 	// Check for nil UUIDs
-// 	for i, id := range dto.DeviceIDs {
-// 		id, _ := uuid.Parse(id)
-// 		if id == uuid.Nil {
-// 			return fmt.Errorf("device ID at position %d cannot be nil", i)
-// 		}
-// 	}
-
-// 	return nil
-// }
-
-// type BulkUpdateDeviceDTO struct {
-// 	Devices []UpdateDeviceDTO `json:"devices" validate:"required,dive"`
-// }
-
-// func (dto *BulkUpdateDeviceDTO) Validate() error {
-// 	return validation.Validate.Struct(dto)
-// }
-
-// func (dto *BulkUpdateDeviceDTO) ToDevices() []*model.Device {
-// 	devices := make([]*model.Device, 0, len(dto.Devices))
-// 	for _, d := range dto.Devices {
-// 		devices = append(devices, d.ToDevice())
-// 	}
-// 	return devices
-// }
-
-// type UpdateDeviceStatusDTO struct {
-// 	Status string `json:"status" validate:"required,status"`
-// }
-
-// func (dto *UpdateDeviceStatusDTO) Validate() error {
-// 	return validation.Validate.Struct(dto)
-// }
-
 // type BroadcastConfigDTO struct {
 // 	BroadcastEnabled bool   `json:"broadcast_enabled" validate:"boolean"`
 // 	Protocol         string `json:"protocol" validate:"required,oneof=MQTT AMQP"`
@@ -266,6 +98,7 @@ type RegisterDeviceRequest struct {
 	MACAddress      string                     `json:"mac_address" validate:"required,mac"`
 	IPAddress       string                     `json:"ip_address" validate:"omitempty,ip"`
 	Specification   DeviceSpecificationPayload `json:"specification" validate:"required"`
+	Location        *LocationPayload           `json:"location,omitempty"`
 	TelemetryConfig *TelemetryConfigPayload    `json:"telemetry_config,omitempty"`
 	BroadcastConfig *BroadcastConfigPayload    `json:"broadcast_config,omitempty"`
 	Metadata        datatypes.JSON             `json:"metadata,omitempty"`
@@ -273,19 +106,94 @@ type RegisterDeviceRequest struct {
 	Capabilities    pq.StringArray             `json:"capabilities,omitempty"`
 }
 
-func (dto *RegisterDeviceRequest) Validate() error { return validation.Validate.Struct(dto) }
+func (dto *RegisterDeviceRequest) Validate() error {
+	if err := validation.Validate.Struct(dto); err != nil {
+		return err
+	}
+	if dto.TelemetryConfig != nil {
+		return dto.TelemetryConfig.Validate()
+	}
+	return nil
+}
 
 func (dto *RegisterDeviceRequest) AsModel() *model.Device {
-	return &model.Device{
+	device := &model.Device{
 		Name:          dto.Name,
 		Description:   dto.Description,
 		MACAddress:    dto.MACAddress,
 		IPAddress:     dto.IPAddress,
 		Specification: *dto.Specification.AsModel(),
-		// TelemetryConfig: ,
-		Metadata:     dto.Metadata,
-		Tags:         dto.Tags,
-		Capabilities: dto.Capabilities,
+		Metadata:      dto.Metadata,
+		Tags:          dto.Tags,
+		Capabilities:  dto.Capabilities,
+	}
+	if dto.TelemetryConfig != nil {
+		device.TelemetryConfig = *dto.TelemetryConfig.AsModel()
+	}
+	if dto.Location != nil {
+		device.Location = *dto.Location.AsModel()
+	}
+	return device
+}
+
+// RegisterDeviceRequestFromDevice is the registration of an existing device, e.g. to export it in a manifest.
+func RegisterDeviceRequestFromDevice(device *model.Device) *RegisterDeviceRequest {
+	registration := &RegisterDeviceRequest{
+		Name:        device.Name,
+		Description: device.Description,
+		MACAddress:  device.MACAddress,
+		IPAddress:   device.IPAddress,
+		Specification: DeviceSpecificationPayload{
+			Manufacturer:    device.Specification.Manufacturer,
+			ModelNumber:     device.Specification.ModelNumber,
+			SerialNumber:    device.Specification.SerialNumber,
+			FirmwareVersion: device.Specification.FirmwareVersion,
+			HardwareVersion: device.Specification.HardwareVersion,
+			SoftwareVersion: device.Specification.SoftwareVersion,
+		},
+		Metadata:     device.Metadata,
+		Tags:         device.Tags,
+		Capabilities: device.Capabilities,
+	}
+	// devices registered without a location sit at 0,0
+	if location := device.Location; location.Latitude != 0 || location.Longitude != 0 || location.Altitude != nil || location.Description != "" {
+		registration.Location = &LocationPayload{
+			Latitude:    location.Latitude,
+			Longitude:   location.Longitude,
+			Altitude:    location.Altitude,
+			Description: location.Description,
+		}
+	}
+	// thresholds were validated when they were stored
+	thresholds, _ := model.ParseAlertThresholds(device.TelemetryConfig.AlertThresholds)
+	registration.TelemetryConfig = &TelemetryConfigPayload{
+		Enabled:            device.TelemetryConfig.Enabled,
+		ReportingFrequency: device.TelemetryConfig.ReportingFrequency,
+		BatchSize:          device.TelemetryConfig.BatchSize,
+		RetentionPeriod:    device.TelemetryConfig.RetentionPeriod,
+		StorageQuota:       device.TelemetryConfig.StorageQuota,
+		CompressionEnabled: device.TelemetryConfig.CompressionEnabled,
+		EncryptionEnabled:  device.TelemetryConfig.EncryptionEnabled,
+		AlertThresholds:    thresholds,
+	}
+	return registration
+}
+
+type LocationPayload struct {
+	Latitude    float64  `json:"latitude" validate:"min=-90,max=90"`
+	Longitude   float64  `json:"longitude" validate:"min=-180,max=180"`
+	Altitude    *float64 `json:"altitude,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+func (dto *LocationPayload) Validate() error { return validation.Validate.Struct(dto) }
+
+func (dto *LocationPayload) AsModel() *domain.GeoLocation {
+	return &domain.GeoLocation{
+		Latitude:    dto.Latitude,
+		Longitude:   dto.Longitude,
+		Altitude:    dto.Altitude,
+		Description: dto.Description,
 	}
 }
 
@@ -312,23 +220,42 @@ func (dto *DeviceSpecificationPayload) AsModel() *model.DeviceSpecification {
 }
 
 type TelemetryConfigPayload struct {
-	Enabled            bool                   `json:"enabled"`
-	ReportingFrequency int                    `json:"reporting_frequency_seconds"`
-	BatchSize          int                    `json:"batch_size"`
-	RetentionPeriod    int                    `json:"retention_period_days"`
-	StorageQuota       int64                  `json:"storage_quota_bytes"`
-	CompressionEnabled bool                   `json:"compression_enabled"`
-	EncryptionEnabled  bool                   `json:"encryption_enabled"`
-	AlertThresholds    map[string]interface{} `json:"alert_thresholds,omitempty"`
+	Enabled            bool                  `json:"enabled"`
+	ReportingFrequency int                   `json:"reporting_frequency_seconds"`
+	BatchSize          int                   `json:"batch_size"`
+	RetentionPeriod    int                   `json:"retention_period_days"`
+	StorageQuota       int64                 `json:"storage_quota_bytes"`
+	CompressionEnabled bool                  `json:"compression_enabled"`
+	EncryptionEnabled  bool                  `json:"encryption_enabled"`
+	AlertThresholds    model.AlertThresholds `json:"alert_thresholds,omitempty"`
 }
 
-func (dto *TelemetryConfigPayload) Validate() error { return validation.Validate.Struct(dto) }
+func (dto *TelemetryConfigPayload) Validate() error {
+	if err := validation.Validate.Struct(dto); err != nil {
+		return err
+	}
+	for sensorCode := range dto.AlertThresholds {
+		if !config.SensorSchemaRepository.HasSensorCode(sensorCode) {
+			return fmt.Errorf("alert thresholds: unknown sensor code %s", sensorCode)
+		}
+	}
+	return dto.AlertThresholds.Validate()
+}
 
-// func (dto *TelemetryConfigPayload) AsModel() *model.TelemetryConfig {
-// 	return &model.TelemetryConfig{
-// 		Enabled: dto,
-// 	}
-// }
+func (dto *TelemetryConfigPayload) AsModel() *model.TelemetryConfig {
+	// a map of plain structs always marshals
+	thresholds, _ := json.Marshal(dto.AlertThresholds)
+	return &model.TelemetryConfig{
+		Enabled:            dto.Enabled,
+		ReportingFrequency: dto.ReportingFrequency,
+		BatchSize:          dto.BatchSize,
+		RetentionPeriod:    dto.RetentionPeriod,
+		StorageQuota:       dto.StorageQuota,
+		CompressionEnabled: dto.CompressionEnabled,
+		EncryptionEnabled:  dto.EncryptionEnabled,
+		AlertThresholds:    thresholds,
+	}
+}
 
 type BroadcastConfigPayload struct {
 	BroadcastEnabled bool   `json:"broadcast_enabled"`
@@ -345,3 +272,313 @@ type BroadcastConfigPayload struct {
 }
 
 func (dto *BroadcastConfigPayload) Validate() error { return validation.Validate.Struct(dto) }
+
+var deviceSortFields = map[string]bool{
+	"name":           true,
+	"status":         true,
+	"created_at":     true,
+	"updated_at":     true,
+	"last_connected": true,
+}
+
+type DeviceQueryParamsDTO struct {
+	Name         *string `query:"name"`
+	Status       *string `query:"status"`       // comma separated, e.g. online,offline
+	Tags         *string `query:"tags"`         // comma separated, devices having every tag
+	Capabilities *string `query:"capabilities"` // comma separated, devices having every capability
+	OwnerID      *string `query:"owner_id" validate:"omitempty,uuid"`
+	Manufacturer *string `query:"manufacturer"`
+	Deleted      bool    `query:"deleted"`
+	Limit        int     `query:"limit"`
+	Offset       int     `query:"offset"`
+	SortBy       string  `query:"sort_by"`
+	SortOrder    string  `query:"sort_order"`
+}
+
+func (dto *DeviceQueryParamsDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *DeviceQueryParamsDTO) AsModel() (*pagination.Pagination, *domain.DeviceFilter, error) {
+	const defaultLimit = 20
+	const maxLimit = 100
+
+	limit := dto.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("limit exceeds maximum allowed %d", maxLimit)
+	}
+	if dto.Offset < 0 {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("offset cannot be negative %d", dto.Offset)
+	}
+	page := (dto.Offset / limit) + 1
+
+	sortBy := dto.SortBy
+	if sortBy == "" {
+		sortBy = "created_at"
+	} else if !deviceSortFields[sortBy] {
+		return nil, nil, apperror.ErrBadRequest.WithMessagef("cannot sort devices by %s", sortBy)
+	}
+	sortOrder := strings.ToUpper(dto.SortOrder)
+	if sortOrder != "ASC" && sortOrder != "DESC" {
+		sortOrder = "DESC"
+	}
+
+	paginationConfig := &pagination.Pagination{
+		Page:      page,
+		PageSize:  limit,
+		SortBy:    sortBy,
+		SortOrder: sortOrder,
+	}
+
+	filter := &domain.DeviceFilter{Deleted: dto.Deleted}
+	if dto.Name != nil && *dto.Name != "" {
+		filter.Name = dto.Name
+	}
+	if dto.Status != nil && *dto.Status != "" {
+		for _, status := range splitQueryList(*dto.Status) {
+			if !model.IsValidDeviceStatus(status) {
+				return nil, nil, apperror.ErrBadRequest.WithMessagef("invalid device status %s", status)
+			}
+			filter.Status = append(filter.Status, status)
+		}
+	}
+	if dto.Tags != nil && *dto.Tags != "" {
+		filter.Tags = splitQueryList(*dto.Tags)
+	}
+	if dto.Capabilities != nil && *dto.Capabilities != "" {
+		filter.Capabilities = splitQueryList(*dto.Capabilities)
+	}
+	if dto.OwnerID != nil && *dto.OwnerID != "" {
+		ownerID := uuid.MustParse(*dto.OwnerID)
+		filter.OwnerID = &ownerID
+	}
+	if dto.Manufacturer != nil && *dto.Manufacturer != "" {
+		filter.Manufacturer = dto.Manufacturer
+	}
+
+	return paginationConfig, filter, nil
+}
+
+// splitQueryList splits a comma separated query parameter, dropping the empty entries.
+func splitQueryList(raw string) []string {
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+type GetDeviceQueryDTO struct {
+	Include *string `query:"include"` // comma separated relations, only sensors for now
+}
+
+func (dto *GetDeviceQueryDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *GetDeviceQueryDTO) IncludeSensors() (bool, error) {
+	if dto.Include == nil {
+		return false, nil
+	}
+	includeSensors := false
+	for _, relation := range splitQueryList(*dto.Include) {
+		if relation != "sensors" {
+			return false, apperror.ErrBadRequest.WithMessagef("cannot include %s with a device", relation)
+		}
+		includeSensors = true
+	}
+	return includeSensors, nil
+}
+
+// UpdateDeviceRequest changes the fields present, the mac address and the specification identity stay as registered.
+type UpdateDeviceRequest struct {
+	Name            *string        `json:"name,omitempty" validate:"omitempty,min=1,max=255"`
+	Description     *string        `json:"description,omitempty"`
+	IPAddress       *string        `json:"ip_address,omitempty" validate:"omitempty,ip"`
+	FirmwareVersion *string        `json:"firmware_version,omitempty" validate:"omitempty,max=20"`
+	HardwareVersion *string        `json:"hardware_version,omitempty" validate:"omitempty,max=20"`
+	SoftwareVersion *string        `json:"software_version,omitempty" validate:"omitempty,max=20"`
+	Metadata        datatypes.JSON `json:"metadata,omitempty"`
+	Tags            pq.StringArray `json:"tags,omitempty"`
+	Capabilities    pq.StringArray `json:"capabilities,omitempty"`
+
+	AlertThresholds model.AlertThresholds `json:"alert_thresholds,omitempty"` // replaces the alert thresholds of the telemetry config
+}
+
+func (dto *UpdateDeviceRequest) Validate() error {
+	if err := validation.Validate.Struct(dto); err != nil {
+		return err
+	}
+	if dto.Name == nil && dto.Description == nil && dto.IPAddress == nil && dto.FirmwareVersion == nil &&
+		dto.HardwareVersion == nil && dto.SoftwareVersion == nil && dto.Metadata == nil && dto.Tags == nil && dto.Capabilities == nil &&
+		dto.AlertThresholds == nil {
+		return apperror.ErrValidation.WithMessage("at least one field to update is required")
+	}
+	if dto.AlertThresholds != nil {
+		return (&TelemetryConfigPayload{AlertThresholds: dto.AlertThresholds}).Validate()
+	}
+	return nil
+}
+
+func (dto *UpdateDeviceRequest) AsModel(deviceID uuid.UUID) *model.Device {
+	device := &model.Device{
+		ID:           deviceID,
+		Metadata:     dto.Metadata,
+		Tags:         dto.Tags,
+		Capabilities: dto.Capabilities,
+	}
+	if dto.Name != nil {
+		device.Name = *dto.Name
+	}
+	if dto.Description != nil {
+		device.Description = *dto.Description
+	}
+	if dto.IPAddress != nil {
+		device.IPAddress = *dto.IPAddress
+	}
+	if dto.FirmwareVersion != nil {
+		device.Specification.FirmwareVersion = *dto.FirmwareVersion
+	}
+	if dto.HardwareVersion != nil {
+		device.Specification.HardwareVersion = *dto.HardwareVersion
+	}
+	if dto.SoftwareVersion != nil {
+		device.Specification.SoftwareVersion = *dto.SoftwareVersion
+	}
+	if dto.AlertThresholds != nil {
+		// a map of plain structs always marshals, an empty one clears the thresholds
+		device.TelemetryConfig.AlertThresholds, _ = json.Marshal(dto.AlertThresholds)
+	}
+	return device
+}
+
+type UpdateDeviceStatusRequest struct {
+	Status string `json:"status" validate:"required"`
+	Reason string `json:"reason" validate:"required,max=500"` // kept in the status history of the device
+}
+
+func (dto *UpdateDeviceStatusRequest) Validate() error {
+	if err := validation.Validate.Struct(dto); err != nil {
+		return err
+	}
+	if !model.IsValidDeviceStatus(dto.Status) {
+		return apperror.ErrValidation.WithMessagef("invalid device status %s", dto.Status)
+	}
+	return nil
+}
+
+// AsModel is the status change the user asks for.
+func (dto *UpdateDeviceStatusRequest) AsModel(deviceID, userID uuid.UUID) *model.DeviceStatusChange {
+	return &model.DeviceStatusChange{
+		DeviceID:  deviceID,
+		ToStatus:  model.DeviceStatus(dto.Status),
+		Reason:    strings.TrimSpace(dto.Reason),
+		ActorType: model.DeviceStatusActorUser,
+		ActorID:   &userID,
+		Source:    "api",
+	}
+}
+
+type DeviceStatusHistoryQueryDTO struct {
+	Limit  int `query:"limit"`
+	Offset int `query:"offset"`
+}
+
+func (dto *DeviceStatusHistoryQueryDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+// AsModel pages the status history, the latest change first.
+func (dto *DeviceStatusHistoryQueryDTO) AsModel() (*pagination.Pagination, error) {
+	const defaultLimit = 20
+	const maxLimit = 100
+
+	limit := dto.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		return nil, apperror.ErrBadRequest.WithMessagef("limit exceeds maximum allowed %d", maxLimit)
+	}
+	if dto.Offset < 0 {
+		return nil, apperror.ErrBadRequest.WithMessagef("offset cannot be negative %d", dto.Offset)
+	}
+	return &pagination.Pagination{
+		Page:      (dto.Offset / limit) + 1,
+		PageSize:  limit,
+		SortBy:    "changed_at",
+		SortOrder: "DESC",
+	}, nil
+}
+
+type BulkCreateDevicesRequest struct {
+	Devices []RegisterDeviceRequest `json:"devices" validate:"required,min=1,max=100,dive"`
+}
+
+func (dto *BulkCreateDevicesRequest) Validate() error {
+	if err := validation.Validate.Struct(dto); err != nil {
+		return err
+	}
+	for i := range dto.Devices {
+		if err := dto.Devices[i].Validate(); err != nil {
+			return apperror.ErrValidation.WithMessagef("device at position %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (dto *BulkCreateDevicesRequest) AsModel() []*model.Device {
+	devices := make([]*model.Device, 0, len(dto.Devices))
+	for i := range dto.Devices {
+		devices = append(devices, dto.Devices[i].AsModel())
+	}
+	return devices
+}
+
+type BulkUpdateDeviceItem struct {
+	DeviceID string `json:"device_id" validate:"required,uuid"`
+	UpdateDeviceRequest
+}
+
+type BulkUpdateDevicesRequest struct {
+	Devices []BulkUpdateDeviceItem `json:"devices" validate:"required,min=1,max=100,dive"`
+}
+
+func (dto *BulkUpdateDevicesRequest) Validate() error {
+	if err := validation.Validate.Struct(dto); err != nil {
+		return err
+	}
+	for i := range dto.Devices {
+		if err := dto.Devices[i].UpdateDeviceRequest.Validate(); err != nil {
+			return apperror.ErrValidation.WithMessagef("device at position %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (dto *BulkUpdateDevicesRequest) AsModel() []*model.Device {
+	devices := make([]*model.Device, 0, len(dto.Devices))
+	for i := range dto.Devices {
+		devices = append(devices, dto.Devices[i].AsModel(uuid.MustParse(dto.Devices[i].DeviceID)))
+	}
+	return devices
+}
+
+type BulkDeleteDevicesRequest struct {
+	DeviceIDs []string `json:"device_ids" validate:"required,min=1,max=100,dive,required,uuid"`
+}
+
+func (dto *BulkDeleteDevicesRequest) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *BulkDeleteDevicesRequest) AsModel() []uuid.UUID {
+	deviceIDs := make([]uuid.UUID, 0, len(dto.DeviceIDs))
+	for _, deviceID := range dto.DeviceIDs {
+		deviceIDs = append(deviceIDs, uuid.MustParse(deviceID))
+	}
+	return deviceIDs
+}
@@ -0,0 +1,34 @@
+package dto
+
+import (
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/validation"
+)
+
+// DeviceImportResult is the outcome of a manifest row. The provision code is only in the report of the import that
+// created the device, it's stored hashed.
+type DeviceImportResult struct {
+	Line          int        `json:"line"`
+	Name          string     `json:"name,omitempty"`
+	MACAddress    string     `json:"mac_address,omitempty"`
+	DeviceID      *uuid.UUID `json:"device_id,omitempty"`
+	ProvisionCode string     `json:"provision_code,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+type DeviceImportReport struct {
+	DryRun  bool                  `json:"dry_run"`
+	Total   int                   `json:"total"`
+	Created int                   `json:"created"`
+	Failed  int                   `json:"failed"`
+	Results []*DeviceImportResult `json:"results"`
+}
+
+type DeviceImportQueryDTO struct {
+	Format string `query:"format" validate:"omitempty,oneof=csv ndjson"` // the content type or the file extension by default
+	DryRun bool   `query:"dry_run"`                                      // validate the manifest without creating a device
+}
+
+func (dto *DeviceImportQueryDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
@@ -0,0 +1,105 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/validation"
+)
+
+// EscalationPolicyRequest creates a policy, or replaces every field of an existing one.
+type EscalationPolicyRequest struct {
+	Name        string                 `json:"name" validate:"required,max=255"`
+	Description string                 `json:"description"`
+	DeviceID    *string                `json:"device_id" validate:"omitempty,uuid"`
+	Tag         string                 `json:"tag" validate:"max=255"`
+	Severity    string                 `json:"severity" validate:"omitempty,oneof=info warning critical"`
+	Priority    int                    `json:"priority"`
+	Enabled     *bool                  `json:"enabled"`
+	Tiers       []model.EscalationTier `json:"tiers" validate:"required,min=1"`
+}
+
+func (dto *EscalationPolicyRequest) Validate() error {
+	if err := validation.Validate.Struct(dto); err != nil {
+		return err
+	}
+	return model.ValidateEscalationTiers(dto.Tiers)
+}
+
+// ApplyTo copies the request onto the policy.
+func (dto *EscalationPolicyRequest) ApplyTo(policy *model.EscalationPolicy) {
+	// plain structs always marshal
+	tiers, _ := json.Marshal(dto.Tiers)
+	policy.Name = dto.Name
+	policy.Description = dto.Description
+	policy.DeviceID = nil
+	if dto.DeviceID != nil {
+		deviceID := uuid.MustParse(*dto.DeviceID)
+		policy.DeviceID = &deviceID
+	}
+	policy.Tag = dto.Tag
+	policy.Severity = model.AlertSeverity(dto.Severity)
+	policy.Priority = dto.Priority
+	policy.Enabled = dto.Enabled == nil || *dto.Enabled
+	policy.Tiers = tiers
+}
+
+// OnCallScheduleRequest creates a schedule, or replaces the rotation of an existing one.
+type OnCallScheduleRequest struct {
+	Name          string    `json:"name" validate:"required,max=255"`
+	Description   string    `json:"description"`
+	Members       []string  `json:"members" validate:"required,min=1,dive,uuid"`
+	RotationStart time.Time `json:"rotation_start" validate:"required"`
+	ShiftHours    int       `json:"shift_hours" validate:"required,min=1"`
+}
+
+func (dto *OnCallScheduleRequest) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+// ApplyTo copies the request onto the schedule.
+func (dto *OnCallScheduleRequest) ApplyTo(schedule *model.OnCallSchedule) {
+	schedule.Name = dto.Name
+	schedule.Description = dto.Description
+	schedule.Members = pq.StringArray(dto.Members)
+	schedule.RotationStart = dto.RotationStart
+	schedule.ShiftHours = dto.ShiftHours
+}
+
+type OnCallOverrideRequest struct {
+	UserID   string    `json:"user_id" validate:"required,uuid"`
+	StartsAt time.Time `json:"starts_at" validate:"required"`
+	EndsAt   time.Time `json:"ends_at" validate:"required"`
+}
+
+func (dto *OnCallOverrideRequest) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *OnCallOverrideRequest) AsModel(scheduleID uuid.UUID) *model.OnCallOverride {
+	return &model.OnCallOverride{
+		ScheduleID: scheduleID,
+		UserID:     uuid.MustParse(dto.UserID),
+		StartsAt:   dto.StartsAt,
+		EndsAt:     dto.EndsAt,
+	}
+}
+
+type OnCallQueryParamsDTO struct {
+	At *string `query:"at" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"` // now when omitted
+}
+
+func (dto *OnCallQueryParamsDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+func (dto *OnCallQueryParamsDTO) Time() time.Time {
+	if dto.At == nil {
+		return time.Now()
+	}
+	at, _ := time.Parse(time.RFC3339, *dto.At)
+	return at
+}
@@ -19,6 +19,17 @@ type SensorFilter struct {
 	UpdatedAt *time.Time `query:"updated_at"`
 }
 
+type AlertFilter struct {
+	DeviceID      *uuid.UUID
+	SensorID      *uuid.UUID
+	SensorCode    *string
+	Status        []string
+	GroupKey      *string
+	Severity      *string
+	TriggeredFrom *time.Time
+	TriggeredTo   *time.Time
+}
+
 type UserFilter struct {
 	ID           *uuid.UUID
 	Username     *string
@@ -37,3 +48,23 @@ type UserFilter struct {
 	SortBy       string
 	SortOrder    string // "ASC" or "DESC"
 }
+
+type CommandFilter struct {
+	DeviceID    *uuid.UUID
+	ScheduleID  *uuid.UUID
+	CampaignID  *uuid.UUID
+	CommandCode *string
+	Status      []string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+type DeadLetterFilter struct {
+	Subject     *string
+	DeviceID    *uuid.UUID
+	CommandCode *string
+	ErrorCode   *string
+	Status      []string
+	FailedFrom  *time.Time
+	FailedTo    *time.Time
+}
@@ -0,0 +1,36 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	IngestStatsSourceLive   = "live"   // postgres rollup plus the counters redis kept since
+	IngestStatsSourceRollup = "rollup" // last postgres rollup alone, redis couldn't be read
+)
+
+type IngestStatsDTO struct {
+	Received          int64            `json:"received"`
+	Accepted          int64            `json:"accepted"`
+	RejectedTotal     int64            `json:"rejected_total"`
+	Rejected          map[string]int64 `json:"rejected"` // by rejection reason
+	Bytes             int64            `json:"bytes"`
+	FirstMessageAt    *time.Time       `json:"first_message_at"`
+	LastMessageAt     *time.Time       `json:"last_message_at"`
+	AvgInterArrivalMs float64          `json:"avg_inter_arrival_ms"`
+}
+
+type SensorIngestStatsDTO struct {
+	SensorID string `json:"sensor_id"` // "unknown" for payloads that named no valid sensor
+	IngestStatsDTO
+}
+
+type DeviceIngestStatsDTO struct {
+	DeviceID   uuid.UUID              `json:"device_id"`
+	Source     string                 `json:"source"`
+	RolledUpAt *time.Time             `json:"rolled_up_at,omitempty"`
+	Totals     IngestStatsDTO         `json:"totals"`
+	Sensors    []SensorIngestStatsDTO `json:"sensors"`
+}
@@ -0,0 +1,107 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/validation"
+)
+
+type MaintenanceQueryParamsDTO struct {
+	DeviceID *string `query:"device_id" validate:"omitempty,uuid"`
+}
+
+func (dto *MaintenanceQueryParamsDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+type CreateMaintenanceWindowRequest struct {
+	Name            string     `json:"name" validate:"required,max=255"`
+	Description     string     `json:"description"`
+	Scope           string     `json:"scope" validate:"required,oneof=device tag site"`
+	DeviceID        *string    `json:"device_id" validate:"omitempty,uuid"`
+	Tag             string     `json:"tag" validate:"max=255"`
+	Site            string     `json:"site" validate:"max=255"`
+	StartsAt        time.Time  `json:"starts_at" validate:"required"`
+	EndsAt          time.Time  `json:"ends_at" validate:"required"`
+	Recurrence      string     `json:"recurrence" validate:"omitempty,oneof=none daily weekly"`
+	RecurUntil      *time.Time `json:"recur_until"`
+	Timezone        string     `json:"timezone" validate:"max=64"` // defaults to UTC
+	SetDeviceStatus bool       `json:"set_device_status"`
+}
+
+func (dto *CreateMaintenanceWindowRequest) Validate() error {
+	if err := validation.Validate.Struct(dto); err != nil {
+		return err
+	}
+	return dto.AsModel().Validate()
+}
+
+func (dto *CreateMaintenanceWindowRequest) AsModel() *model.MaintenanceWindow {
+	window := &model.MaintenanceWindow{
+		Name:            dto.Name,
+		Description:     dto.Description,
+		Scope:           model.MaintenanceScope(dto.Scope),
+		Tag:             dto.Tag,
+		Site:            dto.Site,
+		StartsAt:        dto.StartsAt,
+		EndsAt:          dto.EndsAt,
+		Recurrence:      model.MaintenanceRecurrence(dto.Recurrence),
+		RecurUntil:      dto.RecurUntil,
+		Timezone:        dto.Timezone,
+		SetDeviceStatus: dto.SetDeviceStatus,
+	}
+	if window.Recurrence == "" {
+		window.Recurrence = model.MaintenanceRecurrenceNone
+	}
+	if dto.DeviceID != nil {
+		deviceID := uuid.MustParse(*dto.DeviceID)
+		window.DeviceID = &deviceID
+	}
+	return window
+}
+
+// UpdateMaintenanceWindowRequest changes the schedule of a window, the scope is fixed once created.
+type UpdateMaintenanceWindowRequest struct {
+	Name            *string    `json:"name" validate:"omitempty,min=1,max=255"`
+	Description     *string    `json:"description"`
+	StartsAt        *time.Time `json:"starts_at"`
+	EndsAt          *time.Time `json:"ends_at"`
+	Recurrence      *string    `json:"recurrence" validate:"omitempty,oneof=none daily weekly"`
+	RecurUntil      *time.Time `json:"recur_until"`
+	Timezone        *string    `json:"timezone" validate:"omitempty,min=1,max=64"`
+	SetDeviceStatus *bool      `json:"set_device_status"`
+}
+
+func (dto *UpdateMaintenanceWindowRequest) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+// ApplyTo copies the fields present in the request onto the window.
+func (dto *UpdateMaintenanceWindowRequest) ApplyTo(window *model.MaintenanceWindow) {
+	if dto.Name != nil {
+		window.Name = *dto.Name
+	}
+	if dto.Description != nil {
+		window.Description = *dto.Description
+	}
+	if dto.StartsAt != nil {
+		window.StartsAt = *dto.StartsAt
+	}
+	if dto.EndsAt != nil {
+		window.EndsAt = *dto.EndsAt
+	}
+	if dto.Recurrence != nil {
+		window.Recurrence = model.MaintenanceRecurrence(*dto.Recurrence)
+	}
+	if dto.RecurUntil != nil {
+		window.RecurUntil = dto.RecurUntil
+	}
+	if dto.Timezone != nil {
+		window.Timezone = *dto.Timezone
+	}
+	if dto.SetDeviceStatus != nil {
+		window.SetDeviceStatus = *dto.SetDeviceStatus
+	}
+}
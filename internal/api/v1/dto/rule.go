@@ -0,0 +1,95 @@
+package dto
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/validation"
+)
+
+type RuleQueryParamsDTO struct {
+	DeviceID *string `query:"device_id" validate:"omitempty,uuid"`
+}
+
+func (dto *RuleQueryParamsDTO) Validate() error {
+	return validation.Validate.Struct(dto)
+}
+
+type CreateRuleRequest struct {
+	Name        string               `json:"name" validate:"required,max=255"`
+	Description string               `json:"description"`
+	DeviceID    *string              `json:"device_id" validate:"omitempty,uuid"` // every device when omitted
+	Enabled     *bool                `json:"enabled"`
+	Definition  model.RuleDefinition `json:"definition"`
+}
+
+func (dto *CreateRuleRequest) Validate() error {
+	if err := validation.Validate.Struct(dto); err != nil {
+		return err
+	}
+	return validateRuleDefinition(&dto.Definition)
+}
+
+func (dto *CreateRuleRequest) AsModel() *model.Rule {
+	// plain structs always marshal
+	definition, _ := json.Marshal(dto.Definition)
+	rule := &model.Rule{
+		Name:        dto.Name,
+		Description: dto.Description,
+		Definition:  definition,
+		Enabled:     dto.Enabled == nil || *dto.Enabled,
+	}
+	if dto.DeviceID != nil {
+		deviceID := uuid.MustParse(*dto.DeviceID)
+		rule.DeviceID = &deviceID
+	}
+	return rule
+}
+
+type UpdateRuleRequest struct {
+	Name        *string               `json:"name" validate:"omitempty,min=1,max=255"`
+	Description *string               `json:"description"`
+	Enabled     *bool                 `json:"enabled"`
+	Definition  *model.RuleDefinition `json:"definition"`
+}
+
+func (dto *UpdateRuleRequest) Validate() error {
+	if err := validation.Validate.Struct(dto); err != nil {
+		return err
+	}
+	if dto.Definition != nil {
+		return validateRuleDefinition(dto.Definition)
+	}
+	return nil
+}
+
+// ApplyTo copies the fields present in the request onto the rule.
+func (dto *UpdateRuleRequest) ApplyTo(rule *model.Rule) {
+	if dto.Name != nil {
+		rule.Name = *dto.Name
+	}
+	if dto.Description != nil {
+		rule.Description = *dto.Description
+	}
+	if dto.Enabled != nil {
+		rule.Enabled = *dto.Enabled
+	}
+	if dto.Definition != nil {
+		rule.Definition, _ = json.Marshal(dto.Definition)
+	}
+}
+
+func validateRuleDefinition(def *model.RuleDefinition) error {
+	if err := def.Validate(); err != nil {
+		return err
+	}
+	for _, cond := range def.Conditions {
+		if !config.SensorSchemaRepository.HasSensorCode(cond.SensorCode) {
+			return fmt.Errorf("condition %s: unknown sensor code %s", cond.ID, cond.SensorCode)
+		}
+	}
+	return nil
+}
@@ -14,14 +14,16 @@ import (
 )
 
 type CreateSensorDTO struct {
-	DeviceID  string          `json:"device_id" validate:"required,uuid"`
-	Name      string          `json:"name" validate:"required,max=255"`
-	Type      string          `json:"type" validate:"required,oneof=temperature humidity pressure level flow"` // TODO: update when adding more sensor type
-	Status    string          `json:"status,omitempty" validate:"omitempty"`
-	Unit      string          `json:"unit" validate:"omitempty,max=20"`
-	Precision int             `json:"precision" validate:"omitempty,min=0,max=10"`
-	Location  string          `json:"location" validate:"omitempty,max=255"`
-	Metadata  *datatypes.JSON `json:"metadata" validate:"omitempty"`
+	DeviceID      string          `json:"device_id" validate:"required,uuid"`
+	Name          string          `json:"name" validate:"required,max=255"`
+	Type          string          `json:"type" validate:"required,oneof=temperature humidity pressure level flow"` // TODO: update when adding more sensor type
+	Status        string          `json:"status,omitempty" validate:"omitempty"`
+	Unit          string          `json:"unit" validate:"omitempty,max=20"`
+	Precision     int             `json:"precision" validate:"omitempty,min=0,max=10"`
+	Location      string          `json:"location" validate:"omitempty,max=255"`
+	Metadata      *datatypes.JSON `json:"metadata" validate:"omitempty"`
+	SchemaCode    string          `json:"schema_code" validate:"required,sensor_schema_code"`
+	SchemaVersion int             `json:"schema_version" validate:"omitempty,min=1"`
 }
 
 func (dto *CreateSensorDTO) Validate() error {
@@ -30,13 +32,15 @@ func (dto *CreateSensorDTO) Validate() error {
 
 func (dto *CreateSensorDTO) AsModel() *model.Sensor {
 	sensor := &model.Sensor{
-		DeviceID:  dto.DeviceID,
-		Name:      dto.Name,
-		Type:      model.SensorType(dto.Type),
-		Status:    domain.Pending, // enforced for default create
-		Unit:      dto.Unit,
-		Precision: dto.Precision,
-		Location:  dto.Location,
+		DeviceID:      dto.DeviceID,
+		Name:          dto.Name,
+		Type:          model.SensorType(dto.Type),
+		Status:        domain.Pending, // enforced for default create
+		Unit:          dto.Unit,
+		Precision:     dto.Precision,
+		Location:      dto.Location,
+		SchemaCode:    dto.SchemaCode,
+		SchemaVersion: dto.SchemaVersion,
 	}
 	if dto.Metadata != nil {
 		sensor.MetaData = *dto.Metadata
@@ -45,14 +49,16 @@ func (dto *CreateSensorDTO) AsModel() *model.Sensor {
 }
 
 type UpdateSensorDTO struct {
-	DeviceID  *string         `json:"device_id,omitempty" validate:"omitempty,uuid"`
-	Name      *string         `json:"name,omitempty" validate:"omitempty,max=255"`
-	Type      *string         `json:"type,omitempty" validate:"omitempty,oneof=temperature humidity pressure level flow"` // TODO: update when adding more sensor type
-	Status    *string         `json:"status,omitempty" validate:"omitempty,status"`
-	Unit      *string         `json:"unit,omitempty" validate:"omitempty,max=20"`
-	Precision *int            `json:"precision,omitempty" validate:"omitempty,max=20"`
-	Location  *string         `json:"location,omitempty" validate:"omitempty,max=255"`
-	Metadata  *datatypes.JSON `json:"metadata" validate:"omitempty"`
+	DeviceID      *string         `json:"device_id,omitempty" validate:"omitempty,uuid"`
+	Name          *string         `json:"name,omitempty" validate:"omitempty,max=255"`
+	Type          *string         `json:"type,omitempty" validate:"omitempty,oneof=temperature humidity pressure level flow"` // TODO: update when adding more sensor type
+	Status        *string         `json:"status,omitempty" validate:"omitempty,status"`
+	Unit          *string         `json:"unit,omitempty" validate:"omitempty,max=20"`
+	Precision     *int            `json:"precision,omitempty" validate:"omitempty,max=20"`
+	Location      *string         `json:"location,omitempty" validate:"omitempty,max=255"`
+	Metadata      *datatypes.JSON `json:"metadata" validate:"omitempty"`
+	SchemaCode    *string         `json:"schema_code,omitempty" validate:"omitempty,sensor_schema_code"`
+	SchemaVersion *int            `json:"schema_version,omitempty" validate:"omitempty,min=1"`
 }
 
 func (dto *UpdateSensorDTO) Validate() error {
@@ -85,6 +91,12 @@ func (dto UpdateSensorDTO) AsModel() *model.Sensor {
 	if dto.Metadata != nil {
 		sensor.MetaData = *dto.Metadata
 	}
+	if dto.SchemaCode != nil {
+		sensor.SchemaCode = *dto.SchemaCode
+	}
+	if dto.SchemaVersion != nil {
+		sensor.SchemaVersion = *dto.SchemaVersion
+	}
 
 	return &sensor
 }
@@ -20,20 +20,65 @@ type TelemetryPayloadDTO struct {
 	Data          map[string]interface{} `json:"data" validate:"required"`
 }
 
+// TelemetryPayloadFromMap builds the payload from a generically decoded document (e.g. CBOR).
+// Constrained devices may send sensor_id as 16 raw uuid bytes instead of its string form.
+func TelemetryPayloadFromMap(m map[string]interface{}) (*TelemetryPayloadDTO, error) {
+	dto := &TelemetryPayloadDTO{}
+
+	switch v := m["sensor_id"].(type) {
+	case string:
+		dto.SensorID = v
+	case []byte:
+		id, err := uuid.FromBytes(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid binary sensor_id: %w", err)
+		}
+		dto.SensorID = id.String()
+	case nil:
+	default:
+		return nil, fmt.Errorf("sensor_id: unexpected type %T", v)
+	}
+
+	switch v := m["sensor_code"].(type) {
+	case string:
+		dto.SensorCode = v
+	case nil:
+	default:
+		return nil, fmt.Errorf("sensor_code: unexpected type %T", v)
+	}
+
+	switch v := m["schema_version"].(type) {
+	case int64:
+		dto.SchemaVersion = int(v)
+	case float64:
+		if v != float64(int(v)) {
+			return nil, fmt.Errorf("schema_version: expected integer, got %v", v)
+		}
+		dto.SchemaVersion = int(v)
+	case nil:
+	default:
+		return nil, fmt.Errorf("schema_version: unexpected type %T", v)
+	}
+
+	switch v := m["data"].(type) {
+	case map[string]interface{}:
+		dto.Data = v
+	case nil:
+	default:
+		return nil, fmt.Errorf("data: unexpected type %T", v)
+	}
+
+	return dto, nil
+}
+
 func (dto *TelemetryPayloadDTO) ValidateBasicStructure() error {
 	return validation.Validate.Struct(dto)
 }
 
 func (dto *TelemetryPayloadDTO) ValidateAgainstSchema() error {
-	var matchingSchema *config.SensorSchema
-	for _, schema := range config.SensorSchemaRepository.Schema {
-		if schema.SensorCode == dto.SensorCode && schema.SchemaVersion == dto.SchemaVersion {
-			matchingSchema = &schema
-			break
-		}
-	}
+	matchingSchema := config.SensorSchemaRepository.FindSchema(dto.SensorCode, dto.SchemaVersion)
 	if matchingSchema == nil {
-		return fmt.Errorf("no schema found for sensor %d with %d", dto.SensorCode, dto.SchemaVersion)
+		return fmt.Errorf("no schema found for sensor %s with version %d", dto.SensorCode, dto.SchemaVersion)
 	}
 
 	fieldMap := make(map[int64]config.SensorField)
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/middleware"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/di"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/response"
+	"github.com/vars7899/iots/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type AlertHandler struct {
+	AlertService *service.AlertService
+	middleware   *middleware.MiddlewareRegistry
+	logger       *zap.Logger
+}
+
+func NewAlertHandler(container *di.AppContainer, baseLogger *zap.Logger) *AlertHandler {
+	return &AlertHandler{
+		AlertService: container.Services.AlertService,
+		middleware:   container.Api.Middleware,
+		logger:       logger.Named(baseLogger, "AlertHandler"),
+	}
+}
+
+func (h *AlertHandler) SetupRoutes(e *echo.Group) {
+	e.GET("", h.ListAlerts, h.middleware.PermissionRequired("alert", "read"))
+	e.GET("/groups", h.ListAlertGroups, h.middleware.PermissionRequired("alert", "read"))
+	e.GET("/:id", h.GetAlert, h.middleware.PermissionRequired("alert", "read"))
+	e.POST("/:id/acknowledge", h.AcknowledgeAlert, h.middleware.PermissionRequired("alert", "acknowledge"))
+	e.POST("/:id/resolve", h.ResolveAlert, h.middleware.PermissionRequired("alert", "resolve"))
+	e.POST("/:id/notes", h.AddAlertNote, h.middleware.PermissionRequired("alert", "comment"))
+}
+
+func (h *AlertHandler) ListAlerts(c echo.Context) error {
+	var dto dto.AlertQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	paginationConfig, filterParams, err := dto.AsModel()
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to list %s", domain.EntityAlert)).WithPath(reqPath)
+	}
+
+	alerts, total, err := h.AlertService.ListAlerts(c.Request().Context(), filterParams, paginationConfig)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityAlert)).WithPath(reqPath)
+	}
+
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"alerts": alerts,
+		"total":  total,
+		"limit":  paginationConfig.PageSize,
+		"offset": dto.Offset,
+	})
+}
+
+func (h *AlertHandler) ListAlertGroups(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	groups, err := h.AlertService.ListGroups(c.Request().Context())
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s groups", domain.EntityAlert)).WithPath(reqPath)
+	}
+
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"groups": groups,
+		"total":  len(groups),
+	})
+}
+
+func (h *AlertHandler) GetAlert(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	alertID, err := h.parseAlertID(c)
+	if err != nil {
+		return err
+	}
+
+	alert, err := h.AlertService.GetAlert(c.Request().Context(), alertID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityAlert, alertID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"alert": alert,
+	})
+}
+
+func (h *AlertHandler) AcknowledgeAlert(c echo.Context) error {
+	var dto dto.AlertActionRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	alertID, err := h.parseAlertID(c)
+	if err != nil {
+		return err
+	}
+	userID, err := middleware.GetAccessUserIDClaims(c)
+	if err != nil {
+		return err
+	}
+
+	alert, err := h.AlertService.Acknowledge(c.Request().Context(), alertID, *userID, dto.Note)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to acknowledge %s with ID %s", domain.EntityAlert, alertID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "alert acknowledged successfully",
+		"alert":   alert,
+	})
+}
+
+func (h *AlertHandler) ResolveAlert(c echo.Context) error {
+	var dto dto.AlertActionRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	alertID, err := h.parseAlertID(c)
+	if err != nil {
+		return err
+	}
+	userID, err := middleware.GetAccessUserIDClaims(c)
+	if err != nil {
+		return err
+	}
+
+	alert, err := h.AlertService.Resolve(c.Request().Context(), alertID, *userID, dto.Note)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to resolve %s with ID %s", domain.EntityAlert, alertID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "alert resolved successfully",
+		"alert":   alert,
+	})
+}
+
+func (h *AlertHandler) AddAlertNote(c echo.Context) error {
+	var dto dto.AlertNoteRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	alertID, err := h.parseAlertID(c)
+	if err != nil {
+		return err
+	}
+	userID, err := middleware.GetAccessUserIDClaims(c)
+	if err != nil {
+		return err
+	}
+
+	note, err := h.AlertService.AddNote(c.Request().Context(), alertID, *userID, dto.Body)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to add %s", domain.EntityAlertNote)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusCreated, echo.Map{
+		"message": "note added successfully",
+		"note":    note,
+	})
+}
+
+func (h *AlertHandler) parseAlertID(c echo.Context) (uuid.UUID, error) {
+	reqID := c.Param("id")
+	alertID, err := uuid.Parse(reqID)
+	if err != nil {
+		return uuid.Nil, apperror.ErrBadRequest.WithMessagef("invalid %s ID format", domain.EntityAlert).WithDetails(echo.Map{
+			"alert_id": reqID,
+			"error":    err.Error(),
+		}).WithPath(utils.GetRequestUrlPath(c)).Wrap(err)
+	}
+	return alertID, nil
+}
@@ -169,15 +169,9 @@ func (h *AuthHandler) RequestPasswordReset(c echo.Context) error {
 	responsePayload := echo.Map{
 		"message": "If your email is registered with us, you will receive password reset instructions",
 	}
-	resetToken, resetLink, err := h.authService.RequestPasswordReset(ctx, dto.Email)
-
-	if err != nil {
-		return response.JSON(c, http.StatusOK, responsePayload)
-	}
-
-	if !config.InProd() {
-		responsePayload["reset_password_token"] = resetToken
-		responsePayload["reset_link"] = resetLink
+	// same answer either way, the response must not tell whether the email is registered
+	if err := h.authService.RequestPasswordReset(ctx, dto.Email); err != nil {
+		h.logger.Debug("password reset request not fulfilled", zap.Error(err))
 	}
 	return response.JSON(c, http.StatusOK, responsePayload)
 }
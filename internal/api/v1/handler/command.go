@@ -0,0 +1,491 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/middleware"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/di"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/response"
+	"github.com/vars7899/iots/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// CommandHandler serves the command features spanning several devices, commands of a single device live under
+// the device routes.
+type CommandHandler struct {
+	CommandService         *service.CommandService
+	CommandScheduleService *service.CommandScheduleService
+	CommandCampaignService *service.CommandCampaignService
+	DeadLetterService      *service.DeadLetterService
+	middleware             *middleware.MiddlewareRegistry
+	logger                 *zap.Logger
+}
+
+func NewCommandHandler(container *di.AppContainer, baseLogger *zap.Logger) *CommandHandler {
+	return &CommandHandler{
+		CommandService:         container.Services.CommandService,
+		CommandScheduleService: container.Services.CommandScheduleService,
+		CommandCampaignService: container.Services.CommandCampaignService,
+		DeadLetterService:      container.Services.DeadLetterService,
+		middleware:             container.Api.Middleware,
+		logger:                 logger.Named(baseLogger, "CommandHandler"),
+	}
+}
+
+func (h *CommandHandler) SetupRoutes(e *echo.Group) {
+	e.GET("/schedules", h.ListSchedules, h.middleware.PermissionRequired("command_schedule", "read"))
+	e.POST("/schedules", h.CreateSchedule, h.middleware.PermissionRequired("command_schedule", "create"))
+	e.GET("/schedules/:id", h.GetSchedule, h.middleware.PermissionRequired("command_schedule", "read"))
+	e.PATCH("/schedules/:id", h.UpdateSchedule, h.middleware.PermissionRequired("command_schedule", "update"))
+	e.DELETE("/schedules/:id", h.DeleteSchedule, h.middleware.PermissionRequired("command_schedule", "delete"))
+	e.GET("/schedules/:id/commands", h.ListScheduleCommands, h.middleware.PermissionRequired("command_schedule", "read"))
+
+	e.GET("/campaigns", h.ListCampaigns, h.middleware.PermissionRequired("command_campaign", "read"))
+	e.POST("/campaigns", h.CreateCampaign, h.middleware.PermissionRequired("command_campaign", "create"))
+	e.GET("/campaigns/:id", h.GetCampaign, h.middleware.PermissionRequired("command_campaign", "read"))
+	e.POST("/campaigns/:id/pause", h.PauseCampaign, h.middleware.PermissionRequired("command_campaign", "update"))
+	e.POST("/campaigns/:id/resume", h.ResumeCampaign, h.middleware.PermissionRequired("command_campaign", "update"))
+	e.POST("/campaigns/:id/abort", h.AbortCampaign, h.middleware.PermissionRequired("command_campaign", "update"))
+	e.GET("/campaigns/:id/commands", h.ListCampaignCommands, h.middleware.PermissionRequired("command_campaign", "read"))
+
+	e.GET("/dead-letters", h.ListDeadLetters, h.middleware.PermissionRequired("dead_letter", "read"))
+	e.GET("/dead-letters/volume", h.DeadLetterVolume, h.middleware.PermissionRequired("dead_letter", "read"))
+	e.GET("/dead-letters/:id", h.GetDeadLetter, h.middleware.PermissionRequired("dead_letter", "read"))
+	e.PATCH("/dead-letters/:id", h.EditDeadLetter, h.middleware.PermissionRequired("dead_letter", "update"))
+	e.POST("/dead-letters/:id/redrive", h.RedriveDeadLetter, h.middleware.PermissionRequired("dead_letter", "update"))
+	e.POST("/dead-letters/:id/discard", h.DiscardDeadLetter, h.middleware.PermissionRequired("dead_letter", "update"))
+}
+
+func (h *CommandHandler) ListSchedules(c echo.Context) error {
+	var dto dto.CommandScheduleQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	var deviceID *uuid.UUID
+	if dto.DeviceID != nil {
+		id := uuid.MustParse(*dto.DeviceID)
+		deviceID = &id
+	}
+
+	schedules, err := h.CommandScheduleService.ListSchedules(c.Request().Context(), deviceID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityCommandSchedule)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"schedules": schedules,
+	})
+}
+
+func (h *CommandHandler) CreateSchedule(c echo.Context) error {
+	var dto dto.CreateCommandScheduleRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	userID, err := middleware.GetAccessUserIDClaims(c)
+	if err != nil {
+		return err
+	}
+
+	schedule, err := dto.AsModel()
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to create %s", domain.EntityCommandSchedule)).WithPath(reqPath)
+	}
+	schedule.CreatedBy = userID
+	schedule, err = h.CommandScheduleService.CreateSchedule(c.Request().Context(), schedule)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to create %s", domain.EntityCommandSchedule)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusCreated, echo.Map{
+		"message":  "command schedule created successfully",
+		"schedule": schedule,
+	})
+}
+
+func (h *CommandHandler) GetSchedule(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	scheduleID, err := parseUUIDParam(c, "id", domain.EntityCommandSchedule)
+	if err != nil {
+		return err
+	}
+
+	schedule, err := h.CommandScheduleService.GetSchedule(c.Request().Context(), scheduleID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityCommandSchedule, scheduleID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"schedule": schedule,
+	})
+}
+
+func (h *CommandHandler) UpdateSchedule(c echo.Context) error {
+	var dto dto.UpdateCommandScheduleRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	scheduleID, err := parseUUIDParam(c, "id", domain.EntityCommandSchedule)
+	if err != nil {
+		return err
+	}
+
+	schedule, err := h.CommandScheduleService.GetSchedule(c.Request().Context(), scheduleID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityCommandSchedule, scheduleID)).WithPath(reqPath)
+	}
+	if err := dto.ApplyTo(schedule); err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to update %s with ID %s", domain.EntityCommandSchedule, scheduleID)).WithPath(reqPath)
+	}
+
+	schedule, err = h.CommandScheduleService.UpdateSchedule(c.Request().Context(), schedule)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to update %s with ID %s", domain.EntityCommandSchedule, scheduleID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message":  "command schedule updated successfully",
+		"schedule": schedule,
+	})
+}
+
+func (h *CommandHandler) DeleteSchedule(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	scheduleID, err := parseUUIDParam(c, "id", domain.EntityCommandSchedule)
+	if err != nil {
+		return err
+	}
+
+	if err := h.CommandScheduleService.DeleteSchedule(c.Request().Context(), scheduleID); err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBDelete, fmt.Sprintf("failed to delete %s with ID %s", domain.EntityCommandSchedule, scheduleID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "command schedule deleted successfully",
+	})
+}
+
+// ListScheduleCommands returns the commands the schedule sent, newest first.
+func (h *CommandHandler) ListScheduleCommands(c echo.Context) error {
+	var dto dto.CommandQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	scheduleID, err := parseUUIDParam(c, "id", domain.EntityCommandSchedule)
+	if err != nil {
+		return err
+	}
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	paginationConfig, filterParams, err := dto.AsScheduleModel(scheduleID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to list %s", domain.EntityCommand)).WithPath(reqPath)
+	}
+
+	commands, total, err := h.CommandService.ListCommands(c.Request().Context(), filterParams, paginationConfig)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityCommand)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"commands": commands,
+		"total":    total,
+		"limit":    paginationConfig.PageSize,
+		"offset":   dto.Offset,
+	})
+}
+
+func (h *CommandHandler) ListCampaigns(c echo.Context) error {
+	var dto dto.CommandCampaignQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	statuses, err := dto.AsModel()
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to list %s", domain.EntityCommandCampaign)).WithPath(reqPath)
+	}
+
+	campaigns, err := h.CommandCampaignService.ListCampaigns(c.Request().Context(), statuses)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityCommandCampaign)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"campaigns": campaigns,
+	})
+}
+
+func (h *CommandHandler) CreateCampaign(c echo.Context) error {
+	var dto dto.CreateCommandCampaignRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	userID, err := middleware.GetAccessUserIDClaims(c)
+	if err != nil {
+		return err
+	}
+
+	campaign, err := dto.AsModel()
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to create %s", domain.EntityCommandCampaign)).WithPath(reqPath)
+	}
+	campaign.CreatedBy = userID
+	campaign, err = h.CommandCampaignService.CreateCampaign(c.Request().Context(), campaign)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to create %s", domain.EntityCommandCampaign)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusCreated, echo.Map{
+		"message":  "command campaign created successfully",
+		"campaign": campaign,
+	})
+}
+
+// GetCampaign returns the campaign with the progress of each of its waves.
+func (h *CommandHandler) GetCampaign(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	campaignID, err := parseUUIDParam(c, "id", domain.EntityCommandCampaign)
+	if err != nil {
+		return err
+	}
+
+	campaign, err := h.CommandCampaignService.GetCampaign(c.Request().Context(), campaignID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityCommandCampaign, campaignID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"campaign": campaign,
+	})
+}
+
+func (h *CommandHandler) PauseCampaign(c echo.Context) error {
+	var dto dto.CommandCampaignActionRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	campaignID, err := parseUUIDParam(c, "id", domain.EntityCommandCampaign)
+	if err != nil {
+		return err
+	}
+
+	campaign, err := h.CommandCampaignService.PauseCampaign(c.Request().Context(), campaignID, dto.Reason)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to pause %s with ID %s", domain.EntityCommandCampaign, campaignID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message":  "command campaign paused successfully",
+		"campaign": campaign,
+	})
+}
+
+func (h *CommandHandler) ResumeCampaign(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	campaignID, err := parseUUIDParam(c, "id", domain.EntityCommandCampaign)
+	if err != nil {
+		return err
+	}
+
+	campaign, err := h.CommandCampaignService.ResumeCampaign(c.Request().Context(), campaignID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to resume %s with ID %s", domain.EntityCommandCampaign, campaignID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message":  "command campaign resumed successfully",
+		"campaign": campaign,
+	})
+}
+
+func (h *CommandHandler) AbortCampaign(c echo.Context) error {
+	var dto dto.CommandCampaignActionRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	campaignID, err := parseUUIDParam(c, "id", domain.EntityCommandCampaign)
+	if err != nil {
+		return err
+	}
+
+	campaign, err := h.CommandCampaignService.AbortCampaign(c.Request().Context(), campaignID, dto.Reason)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to abort %s with ID %s", domain.EntityCommandCampaign, campaignID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message":  "command campaign aborted successfully",
+		"campaign": campaign,
+	})
+}
+
+// ListCampaignCommands returns the commands the campaign sent, newest first.
+func (h *CommandHandler) ListCampaignCommands(c echo.Context) error {
+	var dto dto.CommandQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	campaignID, err := parseUUIDParam(c, "id", domain.EntityCommandCampaign)
+	if err != nil {
+		return err
+	}
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	paginationConfig, filterParams, err := dto.AsCampaignModel(campaignID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to list %s", domain.EntityCommand)).WithPath(reqPath)
+	}
+
+	commands, total, err := h.CommandService.ListCommands(c.Request().Context(), filterParams, paginationConfig)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityCommand)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"commands": commands,
+		"total":    total,
+		"limit":    paginationConfig.PageSize,
+		"offset":   dto.Offset,
+	})
+}
+
+func (h *CommandHandler) ListDeadLetters(c echo.Context) error {
+	var dto dto.DeadLetterQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	paginationConfig, filterParams, err := dto.AsModel()
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to list %s", domain.EntityDeadLetter)).WithPath(reqPath)
+	}
+
+	letters, total, err := h.DeadLetterService.ListDeadLetters(c.Request().Context(), filterParams, paginationConfig)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityDeadLetter)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"dead_letters": letters,
+		"total":        total,
+		"limit":        paginationConfig.PageSize,
+		"offset":       dto.Offset,
+	})
+}
+
+// DeadLetterVolume counts the dead letters per command code, subject and status that failed since the time.
+func (h *CommandHandler) DeadLetterVolume(c echo.Context) error {
+	var dto dto.DeadLetterVolumeQueryDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	since, err := dto.SinceTime(time.Now())
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to count %s", domain.EntityDeadLetter)).WithPath(reqPath)
+	}
+
+	volume, err := h.DeadLetterService.Volume(c.Request().Context(), since)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to count %s", domain.EntityDeadLetter)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"since":  since,
+		"volume": volume,
+	})
+}
+
+func (h *CommandHandler) GetDeadLetter(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	letterID, err := parseUUIDParam(c, "id", domain.EntityDeadLetter)
+	if err != nil {
+		return err
+	}
+
+	letter, err := h.DeadLetterService.GetDeadLetter(c.Request().Context(), letterID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityDeadLetter, letterID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"dead_letter": letter,
+	})
+}
+
+// EditDeadLetter replaces the message of a pending dead letter before it is re-driven.
+func (h *CommandHandler) EditDeadLetter(c echo.Context) error {
+	var dto dto.EditDeadLetterRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	letterID, err := parseUUIDParam(c, "id", domain.EntityDeadLetter)
+	if err != nil {
+		return err
+	}
+
+	letter, err := h.DeadLetterService.Edit(c.Request().Context(), letterID, dto.Message)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to update %s with ID %s", domain.EntityDeadLetter, letterID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message":     "dead letter updated successfully",
+		"dead_letter": letter,
+	})
+}
+
+// RedriveDeadLetter publishes the message of a pending dead letter to the subject it came from.
+func (h *CommandHandler) RedriveDeadLetter(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	letterID, err := parseUUIDParam(c, "id", domain.EntityDeadLetter)
+	if err != nil {
+		return err
+	}
+
+	letter, err := h.DeadLetterService.Redrive(c.Request().Context(), letterID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to re-drive %s with ID %s", domain.EntityDeadLetter, letterID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusAccepted, echo.Map{
+		"message":     "dead letter re-driven successfully",
+		"dead_letter": letter,
+	})
+}
+
+func (h *CommandHandler) DiscardDeadLetter(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	letterID, err := parseUUIDParam(c, "id", domain.EntityDeadLetter)
+	if err != nil {
+		return err
+	}
+
+	letter, err := h.DeadLetterService.Discard(c.Request().Context(), letterID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to discard %s with ID %s", domain.EntityDeadLetter, letterID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message":     "dead letter discarded successfully",
+		"dead_letter": letter,
+	})
+}
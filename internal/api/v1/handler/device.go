@@ -1,11 +1,19 @@
 package handler
 
 import (
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/vars7899/iots/config"
 	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/devicemanifest"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
 	"github.com/vars7899/iots/internal/middleware"
 	"github.com/vars7899/iots/internal/service"
 	"github.com/vars7899/iots/pkg/apperror"
@@ -19,42 +27,53 @@ import (
 )
 
 type DeviceHandler struct {
-	DeviceService service.DeviceService
-	middleware    *middleware.MiddlewareRegistry
-	logger        *zap.Logger
+	DeviceService      service.DeviceService
+	IngestStatsService *service.IngestStatsService
+	CommandService     *service.CommandService
+	middleware         *middleware.MiddlewareRegistry
+	logger             *zap.Logger
 }
 
 func NewDeviceHandler(container *di.AppContainer, baseLogger *zap.Logger) *DeviceHandler {
 	return &DeviceHandler{
-		DeviceService: container.Services.DeviceService,
-		middleware:    container.Api.Middleware,
-		logger:        logger.Named(baseLogger, "DeviceHandler"),
+		DeviceService:      container.Services.DeviceService,
+		IngestStatsService: container.Services.IngestStatsService,
+		CommandService:     container.Services.CommandService,
+		middleware:         container.Api.Middleware,
+		logger:             logger.Named(baseLogger, "DeviceHandler"),
 	}
 }
 
 func (h *DeviceHandler) SetupRoutes(e *echo.Group) {
-	// e.POST("", h.CreateNewDevice)
-	// e.GET("/:id", h.GetDeviceByID)
-	// e.DELETE("/:id", h.DeleteDeviceByID)
-	// e.PATCH("/:id", h.UpdateDevice)
+	e.GET("", h.ListDevices, h.middleware.PermissionRequired("device", "read"))
+	e.GET("/status-counts", h.CountDevicesByStatus, h.middleware.PermissionRequired("device", "read"))
+	e.GET("/:id", h.GetDeviceByID, h.middleware.PermissionRequired("device", "read"))
+	e.PATCH("/:id", h.UpdateDevice, h.middleware.PermissionRequired("device", "update"))
+	e.DELETE("/:id", h.DeleteDeviceByID, h.middleware.PermissionRequired("device", "delete"))
+	e.POST("/:id/recover", h.RecoverDevice, h.middleware.PermissionRequired("device", "recover"))
+	e.PATCH("/:id/status", h.UpdateDeviceStatus, h.middleware.PermissionRequired("device", "status"))
+	e.GET("/:id/status-history", h.ListDeviceStatusChanges, h.middleware.PermissionRequired("device", "read"))
 
 	e.POST("/register", h.RegisterDevice, h.middleware.PermissionRequired("device", "register"))
 
-	// bulk operation endpoints
-	// TODO: add middleware to protect only for admin
-	// e.POST("/bulk", h.CreateNewDeviceInBulk)
-	// e.DELETE("/bulk", h.DeleteDeviceInBulk)
-	// e.PATCH("/bulk", h.UpdateDeviceInBulk)
-	// status endpoints
-	// e.PATCH("/:id/status", h.UpdateDeviceStatus)
-	// e.PATCH("/:id/online", h.MarkDeviceOnline)
-	// e.PATCH("/:id/offline", h.MarkDeviceOffline)
+	// bulk operation endpoints, all or none of the devices are changed
+	e.POST("/bulk", h.CreateNewDeviceInBulk, h.middleware.PermissionRequired("device", "create"))
+	e.PATCH("/bulk", h.UpdateDeviceInBulk, h.middleware.PermissionRequired("device", "update"))
+	e.DELETE("/bulk", h.DeleteDeviceInBulk, h.middleware.PermissionRequired("device", "delete"))
+	e.POST("/import", h.ImportDevices, h.middleware.PermissionRequired("device", "import"))
+	e.GET("/export", h.ExportDevices, h.middleware.PermissionRequired("device", "export"))
 
 	// Provision flow
 	e.POST("/provision", h.ProvisionDevice, h.middleware.PermissionRequired("device", "provision"))
 	// e.GET("/connect", h.UpgradeToDeviceSession, h.middleware.PermissionRequired("device", "session"))
 	e.POST("/session/refresh", h.RefreshSessionToken, h.middleware.PermissionRequired("device", "session_refresh"))
 
+	e.GET("/:id/stats", h.GetDeviceIngestStats, h.middleware.PermissionRequired("device", "read"))
+
+	e.POST("/:id/commands", h.SendDeviceCommand, h.middleware.PermissionRequired("device", "command"))
+	e.GET("/:id/commands", h.ListDeviceCommands, h.middleware.PermissionRequired("device", "read"))
+	e.GET("/:id/commands/:command_id", h.GetDeviceCommand, h.middleware.PermissionRequired("device", "read"))
+
 }
 
 func (h *DeviceHandler) ProvisionDevice(c echo.Context) error {
@@ -151,99 +170,180 @@ func bindDeviceConnectionHeaders(c echo.Context, connTokens *deviceauth.DeviceCo
 	c.Response().Header().Set(contextkey.HeaderDeviceRefreshToken, connTokens.RefreshToken)
 }
 
-// func (h *DeviceHandler) CreateNewDevice(c echo.Context) error {
-// 	var dto dto.CreateNewDeviceDTO
-// 	reqPath := utils.GetRequestUrlPath(c)
+func (h *DeviceHandler) ListDevices(c echo.Context) error {
+	var dto dto.DeviceQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
 
-// 	// Bind body request and validate fields
-// 	if err := utils.BindAndValidate(c, &dto); err != nil {
-// 		return err
-// 	}
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	paginationConfig, filterParams, err := dto.AsModel()
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to list %s", domain.EntityDevice)).WithPath(reqPath)
+	}
 
-// 	createdDevice, err := h.DeviceService.CreateDevice(c.Request().Context(), dto.AsModel())
-// 	if err != nil {
-// 		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, "failed to insert device").WithPath(reqPath).Wrap(err)
-// 	}
+	devices, total, err := h.DeviceService.ListDevices(c.Request().Context(), filterParams, paginationConfig)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityDevice)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"devices": devices,
+		"total":   total,
+		"limit":   paginationConfig.PageSize,
+		"offset":  dto.Offset,
+	})
+}
 
-// 	h.log.Debug("device created", zap.String("device_id", createdDevice.ID.String()))
-// 	return response.JSON(c, int(http.StatusCreated), echo.Map{
-// 		"message": "device created successfully",
-// 		"device":  createdDevice,
-// 	})
-// }
+func (h *DeviceHandler) CountDevicesByStatus(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
 
-// func (h *DeviceHandler) GetDeviceByID(c echo.Context) error {
-// 	reqID := c.Param("id")
-// 	reqPath := utils.GetRequestUrlPath(c)
+	counts, err := h.DeviceService.CountDevicesByStatus(c.Request().Context())
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to count %s by status", domain.EntityDevice)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"counts": counts,
+	})
+}
 
-// 	deviceID, err := uuid.Parse(reqID)
-// 	if err != nil {
-// 		return apperror.ErrBadRequest.WithMessage("invalid device ID format").WithDetails(echo.Map{
-// 			"error": err.Error(),
-// 		}).WithPath(reqPath).Wrap(err)
-// 	}
+func (h *DeviceHandler) GetDeviceByID(c echo.Context) error {
+	var dto dto.GetDeviceQueryDTO
+	reqPath := utils.GetRequestUrlPath(c)
 
-// 	deviceExist, err := h.DeviceService.GetDeviceByID(c.Request().Context(), deviceID)
-// 	if err != nil {
-// 		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve device with ID %s", reqID)).WithPath(reqPath)
-// 	}
+	deviceID, err := parseUUIDParam(c, "id", domain.EntityDevice)
+	if err != nil {
+		return err
+	}
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	includeSensors, err := dto.IncludeSensors()
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest).WithPath(reqPath)
+	}
 
-// 	h.log.Debug("device query executed", zap.String("device_id", deviceExist.ID.String()))
-// 	return response.JSON(c, int(http.StatusOK), echo.Map{
-// 		"device": deviceExist,
-// 	})
-// }
+	var device *model.Device
+	if includeSensors {
+		device, err = h.DeviceService.GetDeviceWithSensors(c.Request().Context(), deviceID)
+	} else {
+		device, err = h.DeviceService.GetDeviceByID(c.Request().Context(), deviceID)
+	}
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityDevice, deviceID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"device": device,
+	})
+}
 
-// func (h *DeviceHandler) DeleteDeviceByID(c echo.Context) error {
-// 	reqID := c.Param("id")
-// 	reqPath := utils.GetRequestUrlPath(c)
+func (h *DeviceHandler) UpdateDevice(c echo.Context) error {
+	var dto dto.UpdateDeviceRequest
+	reqPath := utils.GetRequestUrlPath(c)
 
-// 	deviceID, err := uuid.Parse(reqID)
-// 	if err != nil {
-// 		return apperror.ErrBadRequest.WithMessage("invalid device ID format").WithDetails(echo.Map{
-// 			"error": err.Error(),
-// 		}).WithPath(reqPath).Wrap(err)
-// 	}
+	deviceID, err := parseUUIDParam(c, "id", domain.EntityDevice)
+	if err != nil {
+		return err
+	}
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
 
-// 	if err := h.DeviceService.DeleteDevice(c.Request().Context(), deviceID); err != nil {
-// 		return apperror.ErrorHandler(err, apperror.ErrCodeInternal, fmt.Sprintf("failed to delete device with ID %s", reqID)).WithPath(reqPath)
-// 	}
-// 	h.log.Debug("device deleted", zap.String("device_id", deviceID.String()))
-// 	return response.JSON(c, int(http.StatusOK), echo.Map{
-// 		"device_id": deviceID,
-// 	})
-// }
+	device, err := h.DeviceService.UpdateDevice(c.Request().Context(), dto.AsModel(deviceID))
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to update %s with ID %s", domain.EntityDevice, deviceID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"device": device,
+	})
+}
 
-// func (h *DeviceHandler) UpdateDevice(c echo.Context) error {
-// 	var dto dto.UpdateDeviceDTO
-// 	reqID := c.Param("id")
-// 	reqPath := utils.GetRequestUrlPath(c)
+func (h *DeviceHandler) DeleteDeviceByID(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
 
-// 	deviceID, err := uuid.Parse(reqID)
-// 	if err != nil {
-// 		return apperror.ErrBadRequest.WithMessage("invalid device ID format").WithDetails(echo.Map{
-// 			"error": err.Error(),
-// 		}).WithPath(reqPath).Wrap(err)
-// 	}
+	deviceID, err := parseUUIDParam(c, "id", domain.EntityDevice)
+	if err != nil {
+		return err
+	}
 
-// 	// Bind body request and validate fields
-// 	if err := utils.BindAndValidate(c, &dto); err !=  -->nil {
-// 		return err
-// 	}
+	if err := h.DeviceService.DeleteDevice(c.Request().Context(), deviceID); err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBDelete, fmt.Sprintf("failed to delete %s with ID %s", domain.EntityDevice, deviceID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message":   "device deleted successfully",
+		"device_id": deviceID,
+	})
+}
 
-// 	deviceUpdates := dto.ToDevice()
-// 	deviceUpdates.ID = deviceID
+func (h *DeviceHandler) RecoverDevice(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
 
-// 	updatedDevice, err := h.DeviceService.UpdateDevice(c.Request().Context(), deviceUpdates)
-// 	if err != nil {
-// 		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to update device with ID %s", reqID)).WithPath(reqPath)
-// 	}
+	deviceID, err := parseUUIDParam(c, "id", domain.EntityDevice)
+	if err != nil {
+		return err
+	}
 
-// 	h.log.Debug("device updated", zap.String("device_id", deviceID.String()))
-// 	return response.JSON(c, int(http.StatusOK), echo.Map{
-// 		"device": updatedDevice,
-// 	})
-// }
+	device, err := h.DeviceService.RecoverDevice(c.Request().Context(), deviceID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to recover %s with ID %s", domain.EntityDevice, deviceID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "device recovered successfully",
+		"device":  device,
+	})
+}
+
+func (h *DeviceHandler) UpdateDeviceStatus(c echo.Context) error {
+	var dto dto.UpdateDeviceStatusRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	deviceID, err := parseUUIDParam(c, "id", domain.EntityDevice)
+	if err != nil {
+		return err
+	}
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	userID, err := middleware.GetAccessUserIDClaims(c)
+	if err != nil {
+		return err
+	}
+
+	device, err := h.DeviceService.UpdateDeviceStatus(c.Request().Context(), dto.AsModel(deviceID, *userID))
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to update status of %s with ID %s", domain.EntityDevice, deviceID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"device": device,
+	})
+}
+
+func (h *DeviceHandler) ListDeviceStatusChanges(c echo.Context) error {
+	var params dto.DeviceStatusHistoryQueryDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	deviceID, err := parseUUIDParam(c, "id", domain.EntityDevice)
+	if err != nil {
+		return err
+	}
+	if err := utils.BindAndValidate(c, &params); err != nil {
+		return err
+	}
+	paginationOpt, err := params.AsModel()
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest).WithPath(reqPath)
+	}
+
+	changes, total, err := h.DeviceService.ListDeviceStatusChanges(c.Request().Context(), deviceID, paginationOpt)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve status history of %s with ID %s", domain.EntityDevice, deviceID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"status_changes": changes,
+		"total":          total,
+		"limit":          paginationOpt.PageSize,
+		"offset":         params.Offset,
+	})
+}
 
 func (h *DeviceHandler) RegisterDevice(c echo.Context) error {
 	var dto dto.RegisterDeviceRequest
@@ -255,13 +355,15 @@ func (h *DeviceHandler) RegisterDevice(c echo.Context) error {
 		return err
 	}
 
-	device, err := h.DeviceService.CreateDevice(ctx, dto.AsModel())
+	device, provisionCode, err := h.DeviceService.CreateDevice(ctx, dto.AsModel())
 	if err != nil {
 		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, "failed to register new device").WithPath(path)
 	}
 
 	responsePayload := echo.Map{
-		"message": "device registered successfully",
+		"message":        "device registered successfully",
+		"device_id":      device.ID,
+		"provision_code": provisionCode,
 	}
 	if !config.InProd() {
 		responsePayload["device"] = device
@@ -270,128 +372,315 @@ func (h *DeviceHandler) RegisterDevice(c echo.Context) error {
 
 }
 
-// func (h *DeviceHandler) CreateNewDeviceInBulk(c echo.Context) error {
-// 	var dto dto.BulkCreateDevicesDTO
-// 	reqPath := utils.GetRequestUrlPath(c)
+func (h *DeviceHandler) CreateNewDeviceInBulk(c echo.Context) error {
+	var dto dto.BulkCreateDevicesRequest
+	reqPath := utils.GetRequestUrlPath(c)
 
-// 	if err := utils.BindAndValidate(c, &dto); err != nil {
-// 		return err
-// 	}
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
 
-// 	createdDevices, err := h.DeviceService.BulkCreateDevices(c.Request().Context(), dto.ToDevices())
-// 	if err != nil {
-// 		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, "failed to bulk insert devices").WithPath(reqPath)
-// 	}
+	devices, provisionCodes, err := h.DeviceService.BulkCreateDevices(c.Request().Context(), dto.AsModel())
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, "failed to bulk register devices").WithPath(reqPath)
+	}
 
-// 	h.log.Debug("bulk device creation successful", zap.Int("count", len(createdDevices)))
-// 	return response.JSON(c, http.StatusCreated, echo.Map{
-// 		"message": "devices created successfully",
-// 		"devices": createdDevices,
-// 	})
-// }
+	deviceIDs := make([]uuid.UUID, 0, len(devices))
+	// the provision codes are only returned once, they're stored hashed
+	registered := make([]echo.Map, 0, len(devices))
+	for i, device := range devices {
+		deviceIDs = append(deviceIDs, device.ID)
+		registered = append(registered, echo.Map{"device_id": device.ID, "provision_code": provisionCodes[i]})
+	}
+	responsePayload := echo.Map{
+		"message":         "devices registered successfully",
+		"device_ids":      deviceIDs,
+		"provision_codes": registered,
+	}
+	if !config.InProd() {
+		responsePayload["devices"] = devices
+	}
+	return response.JSON(c, http.StatusCreated, responsePayload)
+}
 
-// func (h *DeviceHandler) DeleteDeviceInBulk(c echo.Context) error {
-// 	var dto dto.BulkDeleteDeviceDTO
-// 	reqPath := utils.GetRequestUrlPath(c)
+func (h *DeviceHandler) UpdateDeviceInBulk(c echo.Context) error {
+	var dto dto.BulkUpdateDevicesRequest
+	reqPath := utils.GetRequestUrlPath(c)
 
-// 	if err := utils.BindAndValidate(c, &dto); err != nil {
-// 		return err
-// 	}
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
 
-// 	uuids, err := dto.ToUUIDs()
-// 	if err != nil {
-// 		return apperror.ErrBadRequest.WithMessage("invalid UUID format").WithPath(reqPath).Wrap(err)
-// 	}
+	devices, err := h.DeviceService.BulkUpdateDevices(c.Request().Context(), dto.AsModel())
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, "failed to bulk update devices").WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "devices updated successfully",
+		"devices": devices,
+	})
+}
 
-// 	if err := h.DeviceService.BulkDeleteDevices(c.Request().Context(), uuids); err != nil {
-// 		return apperror.ErrorHandler(err, apperror.ErrCodeDBDelete, "failed to bulk delete devices").
-// 			WithPath(reqPath).Wrap(err)
-// 	}
+func (h *DeviceHandler) DeleteDeviceInBulk(c echo.Context) error {
+	var dto dto.BulkDeleteDevicesRequest
+	reqPath := utils.GetRequestUrlPath(c)
 
-// 	h.log.Debug("bulk device deletion successful", zap.Int("count", len(uuids)))
-// 	return response.JSON(c, http.StatusOK, echo.Map{
-// 		"message":    "devices deleted successfully",
-// 		"device_ids": dto.DeviceIDs,
-// 	})
-// }
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
 
-// func (h *DeviceHandler) UpdateDeviceInBulk(c echo.Context) error {
-// 	var dto dto.BulkUpdateDeviceDTO
-// 	reqPath := utils.GetRequestUrlPath(c)
+	deviceIDs := dto.AsModel()
+	if err := h.DeviceService.BulkDeleteDevices(c.Request().Context(), deviceIDs); err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBDelete, "failed to bulk delete devices").WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message":    "devices deleted successfully",
+		"device_ids": deviceIDs,
+	})
+}
 
-// 	if err := utils.BindAndValidate(c, &dto); err != nil {
-// 		return err
-// 	}
+// ImportDevices registers the devices of a csv or ndjson manifest, sent as the body or as the manifest file of a
+// multipart form. The report answers once with the provision codes of the devices created.
+func (h *DeviceHandler) ImportDevices(c echo.Context) error {
+	var dto dto.DeviceImportQueryDTO
+	reqPath := utils.GetRequestUrlPath(c)
 
-// 	updatedDevices, err := h.DeviceService.BulkUpdateDevices(c.Request().Context(), dto.ToDevices())
-// 	if err != nil {
-// 		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, "").WithPath(reqPath).Wrap(err)
-// 	}
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, &dto); err != nil {
+		return apperror.ErrBadRequest.WithMessage("invalid query parameters").WithPath(reqPath).Wrap(err)
+	}
+	if err := dto.Validate(); err != nil {
+		return apperror.ErrValidation.WithMessage("validation failed").WithDetails(echo.Map{
+			"error": err.Error(),
+		}).WithPath(reqPath).Wrap(err)
+	}
 
-// 	return response.JSON(c, int(http.StatusOK), echo.Map{
-// 		"message": "devices updated successfully",
-// 		"devices": updatedDevices,
-// 	})
-// }
+	manifest, contentType, fileName, err := openDeviceManifest(c)
+	if err != nil {
+		return apperror.ErrBadRequest.WithMessage("failed to read device manifest").WithDetails(echo.Map{
+			"error": err.Error(),
+		}).WithPath(reqPath).Wrap(err)
+	}
+	defer manifest.Close()
 
-// func (h *DeviceHandler) UpdateDeviceStatus(c echo.Context) error {
-// 	paramID := c.Param("id")
-// 	reqPath := utils.GetRequestUrlPath(c)
+	var format devicemanifest.Format
+	if dto.Format != "" {
+		format, err = devicemanifest.ParseFormat(dto.Format)
+	} else {
+		format, err = devicemanifest.FormatOf(contentType, fileName)
+	}
+	if err != nil {
+		return apperror.ErrBadRequest.WithMessage(err.Error()).WithPath(reqPath)
+	}
+	rows, err := devicemanifest.Read(http.MaxBytesReader(c.Response(), manifest, maxDeviceManifestBytes), format)
+	if err != nil {
+		return apperror.ErrBadRequest.WithMessage("invalid device manifest").WithDetails(echo.Map{
+			"error": err.Error(),
+		}).WithPath(reqPath).Wrap(err)
+	}
 
-// 	deviceID, err := uuid.Parse(paramID)
-// 	if err != nil {
-// 		return apperror.ErrInvalidUUID.WithMessage("invalid uuid format").WithPath(reqPath).Wrap(err)
-// 	}
+	report, err := h.DeviceService.ImportDevices(c.Request().Context(), rows, dto.DryRun)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, "failed to import devices").WithPath(reqPath)
+	}
+	status := http.StatusOK
+	if report.Created > 0 {
+		status = http.StatusCreated
+	}
+	return response.JSON(c, status, echo.Map{
+		"report": report,
+	})
+}
 
-// 	var dto dto.UpdateDeviceStatusDTO
-// 	if err := utils.BindAndValidate(c, &dto); err != nil {
-// 		return err
-// 	}
+// ExportDevices writes the devices matching the list filters as a manifest the import reads, csv or ndjson.
+func (h *DeviceHandler) ExportDevices(c echo.Context) error {
+	var params dto.DeviceQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
 
-// 	err = h.DeviceService.UpdateDeviceStatus(c.Request().Context(), deviceID, domain.Status(dto.Status))
-// 	if err != nil {
-// 		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate)
-// 	}
+	if err := utils.BindAndValidate(c, &params); err != nil {
+		return err
+	}
+	format := devicemanifest.FormatCSV
+	if raw := c.QueryParam("format"); raw != "" {
+		var err error
+		if format, err = devicemanifest.ParseFormat(raw); err != nil {
+			return apperror.ErrBadRequest.WithMessage(err.Error()).WithPath(reqPath)
+		}
+	}
+	_, filterParams, err := params.AsModel()
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to export %s", domain.EntityDevice)).WithPath(reqPath)
+	}
 
-// 	return response.JSON(c, int(http.StatusOK), echo.Map{
-// 		"message": fmt.Sprintf("device with ID %s status updated to %s", deviceID.String(), dto.Status),
-// 	})
-// }
-// func (h *DeviceHandler) MarkDeviceOnline(c echo.Context) error {
-// 	paramID := c.Param("id")
-// 	reqPath := utils.GetRequestUrlPath(c)
+	devices, _, err := h.DeviceService.ListDevices(c.Request().Context(), filterParams, nil)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to export %s", domain.EntityDevice)).WithPath(reqPath)
+	}
 
-// 	deviceID, err := uuid.Parse(paramID)
-// 	if err != nil {
-// 		return apperror.ErrInvalidUUID.WithMessage("invalid uuid format").WithPath(reqPath).Wrap(err)
-// 	}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, format.ContentType())
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=devices-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format))
+	res.WriteHeader(http.StatusOK)
+	writer := devicemanifest.NewWriter(res, format)
+	for _, device := range devices {
+		if err := writer.Write(dto.RegisterDeviceRequestFromDevice(device)); err != nil {
+			// the status is out already, the manifest is cut short
+			h.logger.Error("failed to export device", zap.String("device_id", device.ID.String()), zap.Error(err))
+			return nil
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		h.logger.Error("failed to flush device export", zap.Error(err))
+	}
+	return nil
+}
 
-// 	updatedDevice, err := h.DeviceService.MarkDeviceAsOnline(c.Request().Context(), deviceID)
-// 	if err != nil {
-// 		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate)
-// 	}
+// maxDeviceManifestBytes bounds a manifest body, a thousand devices with thresholds and metadata fit well within.
+const maxDeviceManifestBytes = 16 << 20
+
+// openDeviceManifest opens the manifest file of a multipart form, or the request body.
+func openDeviceManifest(c echo.Context) (io.ReadCloser, string, string, error) {
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		fileHeader, err := c.FormFile("manifest")
+		if err != nil {
+			return nil, "", "", err
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, "", "", err
+		}
+		return file, fileHeader.Header.Get(echo.HeaderContentType), fileHeader.Filename, nil
+	}
+	return c.Request().Body, c.Request().Header.Get(echo.HeaderContentType), "", nil
+}
 
-// 	return response.JSON(c, int(http.StatusOK), echo.Map{
-// 		"message": fmt.Sprintf("device with ID %s marked as online", deviceID.String()),
-// 		"device":  updatedDevice,
-// 	})
-// }
-// func (h *DeviceHandler) MarkDeviceOffline(c echo.Context) error {
-// 	paramID := c.Param("id")
-// 	reqPath := utils.GetRequestUrlPath(c)
+func (h *DeviceHandler) GetDeviceIngestStats(c echo.Context) error {
+	reqID := c.Param("id")
+	reqPath := utils.GetRequestUrlPath(c)
 
-// 	deviceID, err := uuid.Parse(paramID)
-// 	if err != nil {
-// 		return apperror.ErrInvalidUUID.WithMessage("invalid uuid format").WithPath(reqPath).Wrap(err)
-// 	}
+	deviceID, err := uuid.Parse(reqID)
+	if err != nil {
+		return apperror.ErrBadRequest.WithMessagef("invalid %s ID format", domain.EntityDevice).WithDetails(echo.Map{
+			"device_id": reqID,
+			"error":     err.Error(),
+		}).WithPath(reqPath).Wrap(err)
+	}
 
-// 	updatedDevice, err := h.DeviceService.MarkDeviceAsOffline(c.Request().Context(), deviceID)
-// 	if err != nil {
-// 		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate)
-// 	}
+	stats, err := h.IngestStatsService.GetDeviceStats(c.Request().Context(), deviceID)
+	if err != nil {
+		return err
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"stats": stats,
+	})
+}
 
-// 	return response.JSON(c, int(http.StatusOK), echo.Map{
-// 		"message": fmt.Sprintf("device with ID %s marked as offline", deviceID.String()),
-// 		"device":  updatedDevice,
-// 	})
-// }
+func (h *DeviceHandler) SendDeviceCommand(c echo.Context) error {
+	var dto dto.SendCommandRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	deviceID, err := parseUUIDParam(c, "id", domain.EntityDevice)
+	if err != nil {
+		return err
+	}
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	if dto.IdempotencyKey == "" {
+		dto.IdempotencyKey = c.Request().Header.Get("Idempotency-Key")
+		if len(dto.IdempotencyKey) > 255 {
+			return apperror.ErrBadRequest.WithMessage("Idempotency-Key header must not exceed 255 characters").WithPath(reqPath)
+		}
+	}
+	userID, err := middleware.GetAccessUserIDClaims(c)
+	if err != nil {
+		return err
+	}
+
+	command, err := dto.AsModel(deviceID, *userID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to send %s", domain.EntityCommand)).WithPath(reqPath)
+	}
+	if dto.RPC {
+		return h.callDeviceCommand(c, command, &dto)
+	}
+	command, err = h.CommandService.Send(c.Request().Context(), command, dto.TTL())
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to send %s to %s with ID %s", domain.EntityCommand, domain.EntityDevice, deviceID)).WithPath(reqPath)
+	}
+
+	return response.JSON(c, http.StatusAccepted, echo.Map{
+		"command": command,
+	})
+}
+
+// callDeviceCommand answers a rpc command with the device's answer, or a gateway timeout leaving the command pending.
+func (h *DeviceHandler) callDeviceCommand(c echo.Context, command *model.Command, dto *dto.SendCommandRequest) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	deviceID := command.DeviceID
+	command, answer, err := h.CommandService.Call(c.Request().Context(), command, dto.TTL(), dto.RPCTimeout())
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to call %s on %s with ID %s", domain.EntityCommand, domain.EntityDevice, deviceID)).WithPath(reqPath)
+	}
+
+	// a retried rpc of a finished command answers with the stored result
+	var devicePayload interface{} = command.Result
+	if answer != nil {
+		devicePayload = answer.Payload
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"command":  command,
+		"response": devicePayload,
+	})
+}
+
+func (h *DeviceHandler) ListDeviceCommands(c echo.Context) error {
+	var dto dto.CommandQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	deviceID, err := parseUUIDParam(c, "id", domain.EntityDevice)
+	if err != nil {
+		return err
+	}
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	paginationConfig, filterParams, err := dto.AsModel(deviceID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeBadRequest, fmt.Sprintf("failed to list %s", domain.EntityCommand)).WithPath(reqPath)
+	}
+
+	commands, total, err := h.CommandService.ListCommands(c.Request().Context(), filterParams, paginationConfig)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityCommand)).WithPath(reqPath)
+	}
+
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"commands": commands,
+		"total":    total,
+		"limit":    paginationConfig.PageSize,
+		"offset":   dto.Offset,
+	})
+}
+
+func (h *DeviceHandler) GetDeviceCommand(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	deviceID, err := parseUUIDParam(c, "id", domain.EntityDevice)
+	if err != nil {
+		return err
+	}
+	commandID, err := parseUUIDParam(c, "command_id", domain.EntityCommand)
+	if err != nil {
+		return err
+	}
+
+	command, err := h.CommandService.GetCommand(c.Request().Context(), deviceID, commandID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityCommand, commandID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"command": command,
+	})
+}
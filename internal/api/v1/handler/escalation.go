@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/middleware"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/di"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/response"
+	"github.com/vars7899/iots/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type EscalationHandler struct {
+	EscalationService *service.EscalationService
+	middleware        *middleware.MiddlewareRegistry
+	logger            *zap.Logger
+}
+
+func NewEscalationHandler(container *di.AppContainer, baseLogger *zap.Logger) *EscalationHandler {
+	return &EscalationHandler{
+		EscalationService: container.Services.EscalationService,
+		middleware:        container.Api.Middleware,
+		logger:            logger.Named(baseLogger, "EscalationHandler"),
+	}
+}
+
+func (h *EscalationHandler) SetupRoutes(e *echo.Group) {
+	e.GET("/policies", h.ListPolicies, h.middleware.PermissionRequired("escalation", "read"))
+	e.POST("/policies", h.CreatePolicy, h.middleware.PermissionRequired("escalation", "create"))
+	e.GET("/policies/:id", h.GetPolicy, h.middleware.PermissionRequired("escalation", "read"))
+	e.PUT("/policies/:id", h.UpdatePolicy, h.middleware.PermissionRequired("escalation", "update"))
+	e.DELETE("/policies/:id", h.DeletePolicy, h.middleware.PermissionRequired("escalation", "delete"))
+
+	e.GET("/schedules", h.ListSchedules, h.middleware.PermissionRequired("escalation", "read"))
+	e.POST("/schedules", h.CreateSchedule, h.middleware.PermissionRequired("escalation", "create"))
+	e.GET("/schedules/:id", h.GetSchedule, h.middleware.PermissionRequired("escalation", "read"))
+	e.PUT("/schedules/:id", h.UpdateSchedule, h.middleware.PermissionRequired("escalation", "update"))
+	e.DELETE("/schedules/:id", h.DeleteSchedule, h.middleware.PermissionRequired("escalation", "delete"))
+	e.GET("/schedules/:id/oncall", h.GetOnCall, h.middleware.PermissionRequired("escalation", "read"))
+	e.POST("/schedules/:id/overrides", h.AddOverride, h.middleware.PermissionRequired("escalation", "update"))
+	e.DELETE("/schedules/:id/overrides/:override_id", h.DeleteOverride, h.middleware.PermissionRequired("escalation", "update"))
+}
+
+func (h *EscalationHandler) ListPolicies(c echo.Context) error {
+	policies, err := h.EscalationService.ListPolicies(c.Request().Context())
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityEscalationPolicy)).WithPath(utils.GetRequestUrlPath(c))
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"policies": policies,
+	})
+}
+
+func (h *EscalationHandler) CreatePolicy(c echo.Context) error {
+	var dto dto.EscalationPolicyRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	policy := &model.EscalationPolicy{}
+	dto.ApplyTo(policy)
+	policy, err := h.EscalationService.CreatePolicy(c.Request().Context(), policy)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to create %s", domain.EntityEscalationPolicy)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusCreated, echo.Map{
+		"message": "escalation policy created successfully",
+		"policy":  policy,
+	})
+}
+
+func (h *EscalationHandler) GetPolicy(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	policyID, err := parseUUIDParam(c, "id", domain.EntityEscalationPolicy)
+	if err != nil {
+		return err
+	}
+
+	policy, err := h.EscalationService.GetPolicy(c.Request().Context(), policyID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityEscalationPolicy, policyID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"policy": policy,
+	})
+}
+
+func (h *EscalationHandler) UpdatePolicy(c echo.Context) error {
+	var dto dto.EscalationPolicyRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	policyID, err := parseUUIDParam(c, "id", domain.EntityEscalationPolicy)
+	if err != nil {
+		return err
+	}
+
+	policy, err := h.EscalationService.GetPolicy(c.Request().Context(), policyID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityEscalationPolicy, policyID)).WithPath(reqPath)
+	}
+	dto.ApplyTo(policy)
+
+	policy, err = h.EscalationService.UpdatePolicy(c.Request().Context(), policy)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to update %s with ID %s", domain.EntityEscalationPolicy, policyID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "escalation policy updated successfully",
+		"policy":  policy,
+	})
+}
+
+func (h *EscalationHandler) DeletePolicy(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	policyID, err := parseUUIDParam(c, "id", domain.EntityEscalationPolicy)
+	if err != nil {
+		return err
+	}
+
+	if err := h.EscalationService.DeletePolicy(c.Request().Context(), policyID); err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBDelete, fmt.Sprintf("failed to delete %s with ID %s", domain.EntityEscalationPolicy, policyID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "escalation policy deleted successfully",
+	})
+}
+
+func (h *EscalationHandler) ListSchedules(c echo.Context) error {
+	schedules, err := h.EscalationService.ListSchedules(c.Request().Context())
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityOnCallSchedule)).WithPath(utils.GetRequestUrlPath(c))
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"schedules": schedules,
+	})
+}
+
+func (h *EscalationHandler) CreateSchedule(c echo.Context) error {
+	var dto dto.OnCallScheduleRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+
+	schedule := &model.OnCallSchedule{}
+	dto.ApplyTo(schedule)
+	schedule, err := h.EscalationService.CreateSchedule(c.Request().Context(), schedule)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to create %s", domain.EntityOnCallSchedule)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusCreated, echo.Map{
+		"message":  "on-call schedule created successfully",
+		"schedule": schedule,
+	})
+}
+
+func (h *EscalationHandler) GetSchedule(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	scheduleID, err := parseUUIDParam(c, "id", domain.EntityOnCallSchedule)
+	if err != nil {
+		return err
+	}
+
+	schedule, err := h.EscalationService.GetSchedule(c.Request().Context(), scheduleID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityOnCallSchedule, scheduleID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"schedule": schedule,
+	})
+}
+
+func (h *EscalationHandler) UpdateSchedule(c echo.Context) error {
+	var dto dto.OnCallScheduleRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	scheduleID, err := parseUUIDParam(c, "id", domain.EntityOnCallSchedule)
+	if err != nil {
+		return err
+	}
+
+	schedule, err := h.EscalationService.GetSchedule(c.Request().Context(), scheduleID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityOnCallSchedule, scheduleID)).WithPath(reqPath)
+	}
+	dto.ApplyTo(schedule)
+
+	schedule, err = h.EscalationService.UpdateSchedule(c.Request().Context(), schedule)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to update %s with ID %s", domain.EntityOnCallSchedule, scheduleID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message":  "on-call schedule updated successfully",
+		"schedule": schedule,
+	})
+}
+
+func (h *EscalationHandler) DeleteSchedule(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	scheduleID, err := parseUUIDParam(c, "id", domain.EntityOnCallSchedule)
+	if err != nil {
+		return err
+	}
+
+	if err := h.EscalationService.DeleteSchedule(c.Request().Context(), scheduleID); err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBDelete, fmt.Sprintf("failed to delete %s with ID %s", domain.EntityOnCallSchedule, scheduleID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "on-call schedule deleted successfully",
+	})
+}
+
+func (h *EscalationHandler) GetOnCall(c echo.Context) error {
+	var dto dto.OnCallQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	scheduleID, err := parseUUIDParam(c, "id", domain.EntityOnCallSchedule)
+	if err != nil {
+		return err
+	}
+
+	at := dto.Time()
+	user, err := h.EscalationService.OnCall(c.Request().Context(), scheduleID, at)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to resolve on-call user of %s with ID %s", domain.EntityOnCallSchedule, scheduleID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"at":   at,
+		"user": user.PublicView(),
+	})
+}
+
+func (h *EscalationHandler) AddOverride(c echo.Context) error {
+	var dto dto.OnCallOverrideRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	scheduleID, err := parseUUIDParam(c, "id", domain.EntityOnCallSchedule)
+	if err != nil {
+		return err
+	}
+
+	override, err := h.EscalationService.AddOverride(c.Request().Context(), dto.AsModel(scheduleID))
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to add %s", domain.EntityOnCallOverride)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusCreated, echo.Map{
+		"message":  "on-call override added successfully",
+		"override": override,
+	})
+}
+
+func (h *EscalationHandler) DeleteOverride(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	scheduleID, err := parseUUIDParam(c, "id", domain.EntityOnCallSchedule)
+	if err != nil {
+		return err
+	}
+	overrideID, err := parseUUIDParam(c, "override_id", domain.EntityOnCallOverride)
+	if err != nil {
+		return err
+	}
+
+	if err := h.EscalationService.DeleteOverride(c.Request().Context(), scheduleID, overrideID); err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBDelete, fmt.Sprintf("failed to delete %s with ID %s", domain.EntityOnCallOverride, overrideID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "on-call override deleted successfully",
+	})
+}
+
+func parseUUIDParam(c echo.Context, name string, entity string) (uuid.UUID, error) {
+	reqID := c.Param(name)
+	id, err := uuid.Parse(reqID)
+	if err != nil {
+		return uuid.Nil, apperror.ErrBadRequest.WithMessagef("invalid %s ID format", entity).WithDetails(echo.Map{
+			name:    reqID,
+			"error": err.Error(),
+		}).WithPath(utils.GetRequestUrlPath(c)).Wrap(err)
+	}
+	return id, nil
+}
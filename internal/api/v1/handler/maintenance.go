@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/middleware"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/di"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/response"
+	"github.com/vars7899/iots/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type MaintenanceHandler struct {
+	MaintenanceService *service.MaintenanceService
+	middleware         *middleware.MiddlewareRegistry
+	logger             *zap.Logger
+}
+
+func NewMaintenanceHandler(container *di.AppContainer, baseLogger *zap.Logger) *MaintenanceHandler {
+	return &MaintenanceHandler{
+		MaintenanceService: container.Services.MaintenanceService,
+		middleware:         container.Api.Middleware,
+		logger:             logger.Named(baseLogger, "MaintenanceHandler"),
+	}
+}
+
+func (h *MaintenanceHandler) SetupRoutes(e *echo.Group) {
+	e.GET("", h.ListWindows, h.middleware.PermissionRequired("maintenance", "read"))
+	e.POST("", h.CreateWindow, h.middleware.PermissionRequired("maintenance", "create"))
+	e.GET("/:id", h.GetWindow, h.middleware.PermissionRequired("maintenance", "read"))
+	e.PATCH("/:id", h.UpdateWindow, h.middleware.PermissionRequired("maintenance", "update"))
+	e.DELETE("/:id", h.DeleteWindow, h.middleware.PermissionRequired("maintenance", "delete"))
+}
+
+func (h *MaintenanceHandler) ListWindows(c echo.Context) error {
+	var dto dto.MaintenanceQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	var deviceID *uuid.UUID
+	if dto.DeviceID != nil {
+		id := uuid.MustParse(*dto.DeviceID)
+		deviceID = &id
+	}
+
+	windows, err := h.MaintenanceService.ListWindows(c.Request().Context(), deviceID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityMaintenanceWindow)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"maintenance_windows": windows,
+	})
+}
+
+func (h *MaintenanceHandler) CreateWindow(c echo.Context) error {
+	var dto dto.CreateMaintenanceWindowRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	userID, err := middleware.GetAccessUserIDClaims(c)
+	if err != nil {
+		return err
+	}
+
+	window := dto.AsModel()
+	window.CreatedBy = userID
+	window, err = h.MaintenanceService.CreateWindow(c.Request().Context(), window)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to create %s", domain.EntityMaintenanceWindow)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusCreated, echo.Map{
+		"message":            "maintenance window created successfully",
+		"maintenance_window": window,
+	})
+}
+
+func (h *MaintenanceHandler) GetWindow(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	windowID, err := h.parseWindowID(c)
+	if err != nil {
+		return err
+	}
+
+	window, err := h.MaintenanceService.GetWindow(c.Request().Context(), windowID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityMaintenanceWindow, windowID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"maintenance_window": window,
+	})
+}
+
+func (h *MaintenanceHandler) UpdateWindow(c echo.Context) error {
+	var dto dto.UpdateMaintenanceWindowRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	windowID, err := h.parseWindowID(c)
+	if err != nil {
+		return err
+	}
+
+	window, err := h.MaintenanceService.GetWindow(c.Request().Context(), windowID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityMaintenanceWindow, windowID)).WithPath(reqPath)
+	}
+	dto.ApplyTo(window)
+
+	window, err = h.MaintenanceService.UpdateWindow(c.Request().Context(), window)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to update %s with ID %s", domain.EntityMaintenanceWindow, windowID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message":            "maintenance window updated successfully",
+		"maintenance_window": window,
+	})
+}
+
+func (h *MaintenanceHandler) DeleteWindow(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	windowID, err := h.parseWindowID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.MaintenanceService.DeleteWindow(c.Request().Context(), windowID); err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBDelete, fmt.Sprintf("failed to delete %s with ID %s", domain.EntityMaintenanceWindow, windowID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "maintenance window deleted successfully",
+	})
+}
+
+func (h *MaintenanceHandler) parseWindowID(c echo.Context) (uuid.UUID, error) {
+	reqID := c.Param("id")
+	windowID, err := uuid.Parse(reqID)
+	if err != nil {
+		return uuid.Nil, apperror.ErrBadRequest.WithMessagef("invalid %s ID format", domain.EntityMaintenanceWindow).WithDetails(echo.Map{
+			"window_id": reqID,
+			"error":     err.Error(),
+		}).WithPath(utils.GetRequestUrlPath(c)).Wrap(err)
+	}
+	return windowID, nil
+}
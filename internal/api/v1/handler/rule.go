@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/middleware"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/di"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/response"
+	"github.com/vars7899/iots/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type RuleHandler struct {
+	RuleService *service.RuleService
+	middleware  *middleware.MiddlewareRegistry
+	logger      *zap.Logger
+}
+
+func NewRuleHandler(container *di.AppContainer, baseLogger *zap.Logger) *RuleHandler {
+	return &RuleHandler{
+		RuleService: container.Services.RuleService,
+		middleware:  container.Api.Middleware,
+		logger:      logger.Named(baseLogger, "RuleHandler"),
+	}
+}
+
+func (h *RuleHandler) SetupRoutes(e *echo.Group) {
+	e.GET("", h.ListRules, h.middleware.PermissionRequired("rule", "read"))
+	e.POST("", h.CreateRule, h.middleware.PermissionRequired("rule", "create"))
+	e.GET("/:id", h.GetRule, h.middleware.PermissionRequired("rule", "read"))
+	e.PATCH("/:id", h.UpdateRule, h.middleware.PermissionRequired("rule", "update"))
+	e.DELETE("/:id", h.DeleteRule, h.middleware.PermissionRequired("rule", "delete"))
+}
+
+func (h *RuleHandler) ListRules(c echo.Context) error {
+	var dto dto.RuleQueryParamsDTO
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	var deviceID *uuid.UUID
+	if dto.DeviceID != nil {
+		id := uuid.MustParse(*dto.DeviceID)
+		deviceID = &id
+	}
+
+	rules, err := h.RuleService.ListRules(c.Request().Context(), deviceID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to list %s", domain.EntityRule)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"rules": rules,
+	})
+}
+
+func (h *RuleHandler) CreateRule(c echo.Context) error {
+	var dto dto.CreateRuleRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	userID, err := middleware.GetAccessUserIDClaims(c)
+	if err != nil {
+		return err
+	}
+
+	rule := dto.AsModel()
+	rule.CreatedBy = userID
+	rule, err = h.RuleService.CreateRule(c.Request().Context(), rule)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to create %s", domain.EntityRule)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusCreated, echo.Map{
+		"message": "rule created successfully",
+		"rule":    rule,
+	})
+}
+
+func (h *RuleHandler) GetRule(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	ruleID, err := h.parseRuleID(c)
+	if err != nil {
+		return err
+	}
+
+	rule, err := h.RuleService.GetRule(c.Request().Context(), ruleID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityRule, ruleID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"rule": rule,
+	})
+}
+
+func (h *RuleHandler) UpdateRule(c echo.Context) error {
+	var dto dto.UpdateRuleRequest
+	reqPath := utils.GetRequestUrlPath(c)
+
+	if err := utils.BindAndValidate(c, &dto); err != nil {
+		return err
+	}
+	ruleID, err := h.parseRuleID(c)
+	if err != nil {
+		return err
+	}
+
+	rule, err := h.RuleService.GetRule(c.Request().Context(), ruleID)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve %s with ID %s", domain.EntityRule, ruleID)).WithPath(reqPath)
+	}
+	dto.ApplyTo(rule)
+
+	rule, err = h.RuleService.UpdateRule(c.Request().Context(), rule)
+	if err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to update %s with ID %s", domain.EntityRule, ruleID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "rule updated successfully",
+		"rule":    rule,
+	})
+}
+
+func (h *RuleHandler) DeleteRule(c echo.Context) error {
+	reqPath := utils.GetRequestUrlPath(c)
+
+	ruleID, err := h.parseRuleID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.RuleService.DeleteRule(c.Request().Context(), ruleID); err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeDBDelete, fmt.Sprintf("failed to delete %s with ID %s", domain.EntityRule, ruleID)).WithPath(reqPath)
+	}
+	return response.JSON(c, http.StatusOK, echo.Map{
+		"message": "rule deleted successfully",
+	})
+}
+
+func (h *RuleHandler) parseRuleID(c echo.Context) (uuid.UUID, error) {
+	reqID := c.Param("id")
+	ruleID, err := uuid.Parse(reqID)
+	if err != nil {
+		return uuid.Nil, apperror.ErrBadRequest.WithMessagef("invalid %s ID format", domain.EntityRule).WithDetails(echo.Map{
+			"rule_id": reqID,
+			"error":   err.Error(),
+		}).WithPath(utils.GetRequestUrlPath(c)).Wrap(err)
+	}
+	return ruleID, nil
+}
@@ -1,45 +1,74 @@
 package handler
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/vars7899/iots/internal/ws"
 	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/auth/deviceauth"
+	"github.com/vars7899/iots/pkg/contextkey"
 	"github.com/vars7899/iots/pkg/di"
 	"go.uber.org/zap"
 )
 
 type TelemetryWebSocketHandler struct {
-	deps *di.AppContainer
-	hub  *ws.Hub
-	l    *zap.Logger
+	deps              *di.AppContainer
+	hub               *ws.Hub
+	deviceAuthService deviceauth.DeviceAuthService
+	l                 *zap.Logger
 }
 
 func NewTelemetryWebSocketHandler(deps *di.AppContainer, baseLogger *zap.Logger) *TelemetryWebSocketHandler {
 	return &TelemetryWebSocketHandler{
-		deps: deps,
-		hub:  deps.WsHub,
-		l:    baseLogger.Named("TelemetryWebSocketHandler"),
+		deps:              deps,
+		hub:               deps.WsHub,
+		deviceAuthService: deps.CoreServices.DeviceAuthService,
+		l:                 baseLogger.Named("TelemetryWebSocketHandler"),
 	}
 }
 
 func (h *TelemetryWebSocketHandler) HandleConnection(c echo.Context) error {
 	h.l.Debug("HandleConnection called for /api/v1/sensor/telemetry")
-	conn, err := ws.Upgrader.Upgrade(c.Response(), c.Request(), nil)
+
+	req := c.Request()
+	connectionToken := req.Header.Get(contextkey.HeaderDeviceConnectionToken)
+	refreshToken := req.Header.Get(contextkey.HeaderDeviceRefreshToken)
+	if connectionToken == "" || refreshToken == "" {
+		return apperror.ErrMissingAuth.WithMessage("missing device connection or refresh token")
+	}
+
+	claims, rotatedTokens, err := h.deviceAuthService.Authenticate(req.Context(), connectionToken, refreshToken)
+	if err != nil || claims == nil {
+		h.l.Warn("telemetry device authentication failed", zap.Error(err))
+		return apperror.ErrUnauthorized.WithMessage("invalid device token")
+	}
+	deviceID, err := claims.DeviceID()
+	if err != nil {
+		return apperror.ErrUnauthorized.WithMessage("malformed device token")
+	}
+
+	// rotated tokens have to travel with the upgrade response, headers can't be set once the connection is hijacked
+	responseHeader := http.Header{}
+	if rotatedTokens != nil {
+		responseHeader.Set(contextkey.HeaderDeviceConnectionToken, rotatedTokens.ConnectionToken)
+		responseHeader.Set(contextkey.HeaderDeviceRefreshToken, rotatedTokens.RefreshToken)
+	}
+
+	conn, err := ws.Upgrader.Upgrade(c.Response(), req, responseHeader)
 	if err != nil {
 		h.l.Error("websocket upgrade failed", zap.Error(err))
 		return apperror.ErrInternal.WithMessage("failed to upgrade to websocket connection").Wrap(err)
 	}
 
-	wsClient := ws.NewClient(h.hub, ws.SensorTelemetryClient, conn, h.l.Named("Client"), &ws.WebSocketClientConfig{
+	// NewClient starts the read pump
+	wsClient := ws.NewClient(h.hub, ws.SensorTelemetryClient, deviceID, conn, h.l.Named("Client"), &ws.WebSocketClientConfig{
 		MaxReadLimit: 512,
 		PongTimeout:  60 * time.Second,
 	})
 
 	h.hub.RegisterClient(wsClient)
 
-	go wsClient.ReadPump()
-
 	return nil
 }
@@ -46,6 +46,41 @@ func RegisterRoutes(e *echo.Echo, container *di.AppContainer) {
 			container.Api.Middleware.JWT, container.Api.Middleware.JTI, container.Api.Middleware.AccessControl,
 		},
 	})
+	manager.AddRoute(api.RouteConfig{
+		Prefix:  "/alert",
+		Handler: handler.NewAlertHandler(container, logger),
+		Middleware: []echo.MiddlewareFunc{
+			container.Api.Middleware.JWT, container.Api.Middleware.JTI,
+		},
+	})
+	manager.AddRoute(api.RouteConfig{
+		Prefix:  "/rule",
+		Handler: handler.NewRuleHandler(container, logger),
+		Middleware: []echo.MiddlewareFunc{
+			container.Api.Middleware.JWT, container.Api.Middleware.JTI,
+		},
+	})
+	manager.AddRoute(api.RouteConfig{
+		Prefix:  "/maintenance",
+		Handler: handler.NewMaintenanceHandler(container, logger),
+		Middleware: []echo.MiddlewareFunc{
+			container.Api.Middleware.JWT, container.Api.Middleware.JTI,
+		},
+	})
+	manager.AddRoute(api.RouteConfig{
+		Prefix:  "/escalation",
+		Handler: handler.NewEscalationHandler(container, logger),
+		Middleware: []echo.MiddlewareFunc{
+			container.Api.Middleware.JWT, container.Api.Middleware.JTI,
+		},
+	})
+	manager.AddRoute(api.RouteConfig{
+		Prefix:  "/command",
+		Handler: handler.NewCommandHandler(container, logger),
+		Middleware: []echo.MiddlewareFunc{
+			container.Api.Middleware.JWT, container.Api.Middleware.JTI,
+		},
+	})
 	// V1 Websocket upgraded routes
 	manager.AddWebsocketRoute(api.WsRouteConfig{
 		Path:    "/sensor/telemetry",
@@ -57,12 +92,21 @@ func RegisterRoutes(e *echo.Echo, container *di.AppContainer) {
 
 	manager.AddWebsocketRoute(api.WsRouteConfig{
 		Path:    "/device/session/connect",
-		Handler: websocket.NewWebsocketHandler(wsManager, container.CoreServices.NatsPublisher, container.CoreServices.DeviceAuthService, container.Config.Websocket, logger).HandleConnection,
+		Handler: websocket.NewWebsocketHandler(wsManager, container.CoreServices.NatsPublisher, container.CoreServices.DeviceAuthService, container.Services.CommandService, container.CoreServices.CommandRegistry, container.Config.Websocket, logger).HandleConnection,
 		Middleware: []echo.MiddlewareFunc{
 			middleware.NewJWTMiddleware(container.CoreServices.AuthTokenService, logger),
 			middleware.NewJTIMiddleware(container.CoreServices.AuthTokenService, logger),
 		},
 	})
 
+	manager.AddWebsocketRoute(api.WsRouteConfig{
+		Path:    "/device/:id/messages",
+		Handler: websocket.NewUserMessageHandler(container.CoreServices.UserMessageRouter, container.Config.Websocket, logger).HandleConnection,
+		Middleware: []echo.MiddlewareFunc{
+			container.Api.Middleware.JWT, container.Api.Middleware.JTI,
+			container.Api.Middleware.PermissionRequired("device", "read"),
+		},
+	})
+
 	manager.Mount()
 }
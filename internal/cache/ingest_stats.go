@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// UnknownSensorKey buckets messages whose sensor couldn't be determined (e.g. undecodable payloads).
+	UnknownSensorKey = "unknown"
+	// DeviceTotalKey holds the counters of every message of the device regardless of sensor.
+	DeviceTotalKey = "device"
+)
+
+type IngestEvent struct {
+	DeviceID   uuid.UUID
+	SensorKey  string // sensor id, UnknownSensorKey when the payload didn't name a valid sensor
+	Bytes      int
+	Accepted   bool
+	Reason     string // rejection reason, ignored when accepted
+	ReceivedAt time.Time
+}
+
+// IngestCounters are the counters kept for one device and sensor since they were last drained.
+type IngestCounters struct {
+	SensorKey         string
+	Received          int64
+	Accepted          int64
+	Rejected          map[string]int64 // rejection reason -> count
+	Bytes             int64
+	FirstMessageAt    time.Time
+	LastMessageAt     time.Time
+	InterArrivalSumMs int64
+	InterArrivalCount int64
+}
+
+type IngestStatsStore interface {
+	Record(ctx context.Context, event IngestEvent) error                                                // count a single received message
+	DeviceCounters(ctx context.Context, deviceID uuid.UUID) (*IngestCounters, []*IngestCounters, error) // device totals and counters per sensor since the last drain, nil totals when nothing was received
+	Devices(ctx context.Context) ([]uuid.UUID, error)                                                   // devices with counters, used by the rollup
+	Drain(ctx context.Context, deviceID uuid.UUID) (*IngestCounters, []*IngestCounters, error)          // take the counters of the device and reset them, the message times are kept
+	Restore(ctx context.Context, deviceID uuid.UUID, counters []*IngestCounters) error                  // add drained counters back after a failed rollup
+}
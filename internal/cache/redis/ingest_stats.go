@@ -0,0 +1,279 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/cache"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	INGEST_STATS_PREFIX  = "ingest"
+	ingestRejectedPrefix = "rejected:"
+
+	// defaultIngestStatsTTL is how long the counters of a device that stopped sending are kept, the rollup
+	// drains them well before
+	defaultIngestStatsTTL = 7 * 24 * time.Hour
+)
+
+// recordIngestScript updates the counters of one message atomically, the inter-arrival gap needs the previous last_at.
+// KEYS: sensor hash, device total hash, device sensor set, device set
+// ARGV: received at (unix ms), bytes, accepted (1/0), rejection reason, sensor key, device id, ttl (seconds)
+var recordIngestScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local function bump(key)
+	local last = redis.call('HGET', key, 'last_at')
+	if last then
+		local gap = now - tonumber(last)
+		if gap >= 0 then
+			redis.call('HINCRBY', key, 'gap_sum_ms', gap)
+			redis.call('HINCRBY', key, 'gap_count', 1)
+			redis.call('HSET', key, 'last_at', now)
+		end
+	else
+		redis.call('HSET', key, 'first_at', now, 'last_at', now)
+	end
+	redis.call('HINCRBY', key, 'received', 1)
+	redis.call('HINCRBY', key, 'bytes', ARGV[2])
+	if ARGV[3] == '1' then
+		redis.call('HINCRBY', key, 'accepted', 1)
+	else
+		redis.call('HINCRBY', key, 'rejected:' .. ARGV[4], 1)
+	end
+end
+bump(KEYS[1])
+bump(KEYS[2])
+redis.call('SADD', KEYS[3], ARGV[5])
+redis.call('SADD', KEYS[4], ARGV[6])
+for _, key in ipairs(KEYS) do
+	redis.call('EXPIRE', key, ARGV[7])
+end
+return 1
+`)
+
+// drainIngestScript returns the counter hashes and resets them in one step, so concurrent rollups never take the
+// same counts. first_at and last_at stay, the next inter-arrival gap is measured from last_at.
+// KEYS: counter hashes of the device
+var drainIngestScript = redis.NewScript(`
+local drained = {}
+for i, key in ipairs(KEYS) do
+	local fields = redis.call('HGETALL', key)
+	drained[i] = fields
+	for j = 1, #fields, 2 do
+		if fields[j] ~= 'first_at' and fields[j] ~= 'last_at' then
+			redis.call('HDEL', key, fields[j])
+		end
+	end
+end
+return drained
+`)
+
+type IngestStatsStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	logger *zap.Logger
+}
+
+// NewRedisIngestStatsStore keeps the counters of a device for ttl after its last message, 7 days when ttl isn't set.
+func NewRedisIngestStatsStore(cfg *config.RedisConfig, ttl time.Duration, baseLogger *zap.Logger) *IngestStatsStore {
+	if ttl <= 0 {
+		ttl = defaultIngestStatsTTL
+	}
+	return &IngestStatsStore{
+		client: NewRedisClient(cfg),
+		prefix: cfg.Prefix,
+		ttl:    ttl,
+		logger: logger.Named(baseLogger, "RedisIngestStatsStore"),
+	}
+}
+
+func (s *IngestStatsStore) devicesKey() string {
+	return fmt.Sprintf("%s:%s:devices", s.prefix, INGEST_STATS_PREFIX)
+}
+
+func (s *IngestStatsStore) sensorsKey(deviceID uuid.UUID) string {
+	return fmt.Sprintf("%s:%s:%s:sensors", s.prefix, INGEST_STATS_PREFIX, deviceID)
+}
+
+func (s *IngestStatsStore) countersKey(deviceID uuid.UUID, sensorKey string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", s.prefix, INGEST_STATS_PREFIX, deviceID, sensorKey)
+}
+
+func (s *IngestStatsStore) Record(ctx context.Context, event cache.IngestEvent) error {
+	if event.SensorKey == "" {
+		event.SensorKey = cache.UnknownSensorKey
+	}
+	if event.ReceivedAt.IsZero() {
+		event.ReceivedAt = time.Now()
+	}
+	accepted := "0"
+	if event.Accepted {
+		accepted = "1"
+	}
+
+	keys := []string{
+		s.countersKey(event.DeviceID, event.SensorKey),
+		s.countersKey(event.DeviceID, cache.DeviceTotalKey),
+		s.sensorsKey(event.DeviceID),
+		s.devicesKey(),
+	}
+	args := []interface{}{event.ReceivedAt.UnixMilli(), event.Bytes, accepted, event.Reason, event.SensorKey, event.DeviceID.String(), int64(s.ttl / time.Second)}
+	if err := recordIngestScript.Run(ctx, s.client, keys, args...).Err(); err != nil {
+		return apperror.ErrInternal.WithMessage("failed to record ingest statistics").Wrap(err)
+	}
+	return nil
+}
+
+func (s *IngestStatsStore) DeviceCounters(ctx context.Context, deviceID uuid.UUID) (*cache.IngestCounters, []*cache.IngestCounters, error) {
+	sensorKeys, err := s.client.SMembers(ctx, s.sensorsKey(deviceID)).Result()
+	if err != nil {
+		return nil, nil, apperror.ErrInternal.WithMessage("failed to list ingest statistics sensors").Wrap(err)
+	}
+
+	pipe := s.client.Pipeline()
+	totalCmd := pipe.HGetAll(ctx, s.countersKey(deviceID, cache.DeviceTotalKey))
+	cmds := make([]*redis.MapStringStringCmd, len(sensorKeys))
+	for i, sensorKey := range sensorKeys {
+		cmds[i] = pipe.HGetAll(ctx, s.countersKey(deviceID, sensorKey))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, nil, apperror.ErrInternal.WithMessage("failed to read ingest statistics").Wrap(err)
+	}
+
+	if len(totalCmd.Val()) == 0 {
+		return nil, nil, nil
+	}
+	total := parseIngestCounters(cache.DeviceTotalKey, totalCmd.Val())
+
+	sensors := make([]*cache.IngestCounters, 0, len(sensorKeys))
+	for i, sensorKey := range sensorKeys {
+		fields := cmds[i].Val()
+		if len(fields) == 0 {
+			continue
+		}
+		sensors = append(sensors, parseIngestCounters(sensorKey, fields))
+	}
+	return total, sensors, nil
+}
+
+func (s *IngestStatsStore) Drain(ctx context.Context, deviceID uuid.UUID) (*cache.IngestCounters, []*cache.IngestCounters, error) {
+	sensorKeys, err := s.client.SMembers(ctx, s.sensorsKey(deviceID)).Result()
+	if err != nil {
+		return nil, nil, apperror.ErrInternal.WithMessage("failed to list ingest statistics sensors").Wrap(err)
+	}
+
+	sensorKeys = append([]string{cache.DeviceTotalKey}, sensorKeys...)
+	keys := make([]string, len(sensorKeys))
+	for i, sensorKey := range sensorKeys {
+		keys[i] = s.countersKey(deviceID, sensorKey)
+	}
+	result, err := drainIngestScript.Run(ctx, s.client, keys).Slice()
+	if err != nil {
+		return nil, nil, apperror.ErrInternal.WithMessage("failed to drain ingest statistics").Wrap(err)
+	}
+
+	var total *cache.IngestCounters
+	sensors := make([]*cache.IngestCounters, 0, len(sensorKeys)-1)
+	for i, raw := range result {
+		fields := hashFields(raw)
+		if len(fields) == 0 {
+			continue
+		}
+		counters := parseIngestCounters(sensorKeys[i], fields)
+		if i == 0 {
+			total = counters
+		} else {
+			sensors = append(sensors, counters)
+		}
+	}
+	return total, sensors, nil
+}
+
+func (s *IngestStatsStore) Restore(ctx context.Context, deviceID uuid.UUID, counters []*cache.IngestCounters) error {
+	pipe := s.client.TxPipeline()
+	for _, c := range counters {
+		key := s.countersKey(deviceID, c.SensorKey)
+		pipe.HIncrBy(ctx, key, "received", c.Received)
+		pipe.HIncrBy(ctx, key, "accepted", c.Accepted)
+		pipe.HIncrBy(ctx, key, "bytes", c.Bytes)
+		pipe.HIncrBy(ctx, key, "gap_sum_ms", c.InterArrivalSumMs)
+		pipe.HIncrBy(ctx, key, "gap_count", c.InterArrivalCount)
+		for reason, count := range c.Rejected {
+			pipe.HIncrBy(ctx, key, ingestRejectedPrefix+reason, count)
+		}
+		pipe.Expire(ctx, key, s.ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return apperror.ErrInternal.WithMessage("failed to restore ingest statistics").Wrap(err)
+	}
+	return nil
+}
+
+// hashFields turns the flat field, value reply of HGETALL inside a script into a map.
+func hashFields(raw interface{}) map[string]string {
+	flat, _ := raw.([]interface{})
+	fields := make(map[string]string, len(flat)/2)
+	for i := 0; i+1 < len(flat); i += 2 {
+		field, _ := flat[i].(string)
+		value, _ := flat[i+1].(string)
+		fields[field] = value
+	}
+	return fields
+}
+
+func (s *IngestStatsStore) Devices(ctx context.Context) ([]uuid.UUID, error) {
+	members, err := s.client.SMembers(ctx, s.devicesKey()).Result()
+	if err != nil {
+		return nil, apperror.ErrInternal.WithMessage("failed to list ingest statistics devices").Wrap(err)
+	}
+
+	deviceIDs := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		deviceID, err := uuid.Parse(member)
+		if err != nil {
+			s.logger.Warn("skipping malformed device id in ingest statistics", zap.String("member", member))
+			continue
+		}
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	return deviceIDs, nil
+}
+
+func parseIngestCounters(sensorKey string, fields map[string]string) *cache.IngestCounters {
+	counters := &cache.IngestCounters{SensorKey: sensorKey, Rejected: make(map[string]int64)}
+	for field, raw := range fields {
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case field == "received":
+			counters.Received = value
+		case field == "accepted":
+			counters.Accepted = value
+		case field == "bytes":
+			counters.Bytes = value
+		case field == "first_at":
+			counters.FirstMessageAt = time.UnixMilli(value)
+		case field == "last_at":
+			counters.LastMessageAt = time.UnixMilli(value)
+		case field == "gap_sum_ms":
+			counters.InterArrivalSumMs = value
+		case field == "gap_count":
+			counters.InterArrivalCount = value
+		case strings.HasPrefix(field, ingestRejectedPrefix):
+			counters.Rejected[strings.TrimPrefix(field, ingestRejectedPrefix)] = value
+		}
+	}
+	return counters
+}
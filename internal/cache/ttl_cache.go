@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+type ttlEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// TTLCache is a small in-process cache with per entry expiry for lookups on hot paths (e.g. telemetry ingest).
+// When full, expired entries are dropped first, then an arbitrary entry is evicted.
+type TTLCache[K comparable, V any] struct {
+	entries    map[K]ttlEntry[V]
+	ttl        time.Duration
+	maxEntries int
+	mu         sync.RWMutex
+}
+
+func NewTTLCache[K comparable, V any](ttl time.Duration, maxEntries int) *TTLCache[K, V] {
+	return &TTLCache[K, V]{
+		entries:    make(map[K]ttlEntry[V]),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+func (c *TTLCache[K, V]) Get(key K) (V, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+
+	if !ok || time.Now().After(entry.expiresAt) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (c *TTLCache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evict()
+	}
+	c.entries[key] = ttlEntry[V]{value: value, expiresAt: time.Now().Add(c.ttl)}
+}
+
+func (c *TTLCache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+func (c *TTLCache[K, V]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// evict must be called with the write lock held.
+func (c *TTLCache[K, V]) evict() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+	for key := range c.entries {
+		delete(c.entries, key)
+		return
+	}
+}
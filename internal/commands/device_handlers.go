@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"context"
+
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/pkg/apperror"
+	"gorm.io/datatypes"
+)
+
+// RegisterDeviceHandlers registers the handlers of the device@ commands.
+func RegisterDeviceHandlers(r *Registry) error {
+	handlers := map[string]HandlerFunc{
+		"device@init":          handleDeviceInit,
+		"device@status_update": handleDeviceStatusUpdate,
+	}
+	for code, handler := range handlers {
+		if err := r.Register(code, handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleDeviceInit marks the device online, settles the schema versions of the commands it declared and tells its
+// users it is ready, reporting back what it announced.
+func handleDeviceInit(ctx context.Context, req *Request) (map[string]interface{}, error) {
+	declared, _ := req.Command.Payload["command_versions"].(map[string]interface{})
+	versions, unsupported := NegotiateVersions(req.Schemas, declared)
+	if err := req.Repos.Devices.UpdateCommandVersions(ctx, req.Device.ID, versions); err != nil {
+		return nil, err
+	}
+	req.Device.CommandVersions = datatypes.NewJSONType(versions)
+	// payloads are checked as decoded json, a map of ints isn't an object to them
+	versionsPayload := make(map[string]interface{}, len(versions))
+	for code, version := range versions {
+		versionsPayload[code] = version
+	}
+
+	req.Device.Status = model.DeviceStatusOnline
+	req.NotifyUsers("device@init_ack", map[string]interface{}{
+		"status":           string(req.Device.Status),
+		"firmware_version": req.Command.Payload["firmware_version"],
+		"sensors":          req.Command.Payload["sensors"],
+		"command_versions": versionsPayload,
+	})
+	result := map[string]interface{}{
+		"message":          "Device init received",
+		"status":           req.Device.Status,
+		"firmware_version": req.Command.Payload["firmware_version"],
+		"sensors":          req.Command.Payload["sensors"],
+		"command_versions": versions,
+	}
+	if len(unsupported) > 0 {
+		result["unsupported_versions"] = unsupported // command code to its active versions
+	}
+	return result, nil
+}
+
+// deviceReportedStatuses are the statuses a device may report about itself.
+var deviceReportedStatuses = map[string]model.DeviceStatus{
+	"online": model.DeviceStatusOnline,
+	"faulty": model.DeviceStatusFaulty,
+}
+
+// handleDeviceStatusUpdate applies the status the device reports, e.g. faulty after a failed self check.
+func handleDeviceStatusUpdate(ctx context.Context, req *Request) (map[string]interface{}, error) {
+	reported, _ := req.Command.Payload["status"].(string)
+	status, ok := deviceReportedStatuses[reported]
+	if !ok {
+		return nil, apperror.ErrBadRequest.WithMessagef("device cannot report status %q", reported)
+	}
+	req.Device.Status = status
+	return map[string]interface{}{
+		"message": "Status updated",
+		"status":  req.Device.Status,
+	}, nil
+}
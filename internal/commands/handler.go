@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+)
+
+// Repositories are the stores a handler may read and write.
+type Repositories struct {
+	Devices repository.DeviceRepository
+}
+
+// Request is one command for a handler. Handlers change Device in place, the processor saves a status change and
+// publishes it.
+type Request struct {
+	Command      model.DeviceCommand
+	Schema       *config.CommandSchema // as of the schema version of the command
+	Schemas      *config.DeviceCommandSchemaRegistry
+	Device       *model.Device
+	Repos        *Repositories
+	UserMessages []UserMessage // published once the command succeeded
+}
+
+// UserMessage is a s>u command for the users subscribed to the device of the request.
+type UserMessage struct {
+	CommandCode string
+	Payload     map[string]interface{}
+}
+
+// NotifyUsers queues a s>u command for the users subscribed to the device, the processor checks it against its
+// schema before publishing it.
+func (r *Request) NotifyUsers(commandCode string, payload map[string]interface{}) {
+	r.UserMessages = append(r.UserMessages, UserMessage{CommandCode: commandCode, Payload: payload})
+}
+
+// Handler processes the commands of one command code, the result is the payload of the command_processed event.
+type Handler interface {
+	Handle(ctx context.Context, req *Request) (map[string]interface{}, error)
+}
+
+type HandlerFunc func(ctx context.Context, req *Request) (map[string]interface{}, error)
+
+func (f HandlerFunc) Handle(ctx context.Context, req *Request) (map[string]interface{}, error) {
+	return f(ctx, req)
+}
+
+// Middleware wraps every handler of a registry, e.g. to log, measure or authorize commands.
+type Middleware func(next Handler) Handler
+
+// Registry holds the handler of each command code.
+type Registry struct {
+	mu         sync.RWMutex
+	handlers   map[string]Handler
+	middleware []Middleware
+}
+
+// NewRegistry creates a registry whose handlers run inside the middleware, the first one outermost.
+func NewRegistry(middleware ...Middleware) *Registry {
+	return &Registry{
+		handlers:   make(map[string]Handler),
+		middleware: middleware,
+	}
+}
+
+// Register sets the handler of the command code, a code has exactly one handler.
+func (r *Registry) Register(commandCode string, handler Handler) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.handlers[commandCode]; ok {
+		return apperror.ErrConflict.WithMessagef("command %s already has a handler", commandCode)
+	}
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+	r.handlers[commandCode] = handler
+	return nil
+}
+
+// Handler returns the handler of the command code wrapped in the registry middleware.
+func (r *Registry) Handler(commandCode string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	handler, ok := r.handlers[commandCode]
+	return handler, ok
+}
+
+// Codes returns the command codes with a handler, sorted.
+func (r *Registry) Codes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	codes := make([]string, 0, len(r.handlers))
+	for code := range r.handlers {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Validate checks that every command the server receives according to the schema has a handler.
+func (r *Registry) Validate(schemas *config.DeviceCommandSchemaConfig) error {
+	if schemas == nil {
+		return apperror.ErrMissingConfig.WithMessage("device command schema is not loaded")
+	}
+	var missing []string
+	for code, schema := range schemas.Commands {
+		if schema.Endpoints().To != config.EndpointServer {
+			continue // sent to users or devices, the server only forwards it
+		}
+		if _, ok := r.Handler(code); !ok {
+			missing = append(missing, code)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return apperror.ErrInit.WithMessage(fmt.Sprintf("no handler for command(s) %s", strings.Join(missing, ", ")))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package commands_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/commands"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/pkg/apperror"
+)
+
+func TestRegistryRunsHandlersThroughMiddleware(t *testing.T) {
+	metrics := commands.NewMetrics()
+	registry := commands.NewRegistry(metrics.Middleware(), commands.AuthorizeDevice())
+	require.NoError(t, commands.RegisterDeviceHandlers(registry))
+	assert.Error(t, registry.Register("device@init", commands.HandlerFunc(nil)), "a code has one handler")
+
+	schemas := &config.DeviceCommandSchemaConfig{Commands: map[string]config.CommandSchema{
+		"device@init":          {Direction: "d>s"},
+		"device@status_update": {Direction: "d>s"},
+		"device@reboot":        {Direction: "s>d"}, // sent by the server, no handler needed
+	}}
+	require.NoError(t, registry.Validate(schemas))
+	schemas.Commands["device@locate"] = config.CommandSchema{Direction: "d>s"}
+	assert.ErrorContains(t, registry.Validate(schemas), "device@locate")
+
+	handler, ok := registry.Handler("device@status_update")
+	require.True(t, ok)
+
+	device := &model.Device{ID: uuid.New(), Status: model.DeviceStatusOnline}
+	req := &commands.Request{
+		Command: model.DeviceCommand{DeviceID: device.ID, CommandCode: "device@status_update", Payload: map[string]interface{}{"status": "faulty"}},
+		Device:  device,
+	}
+	_, err := handler.Handle(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, model.DeviceStatusFaulty, device.Status)
+
+	device.Status = model.DeviceStatusDecommissioned
+	_, err = handler.Handle(context.Background(), req)
+	assert.Equal(t, apperror.ErrCodeForbidden, apperror.FromError(err).Code)
+	assert.Equal(t, model.DeviceStatusDecommissioned, device.Status, "handler must not run for a decommissioned device")
+
+	assert.Equal(t, []commands.CommandStats{{CommandCode: "device@status_update", Handled: 2, Failed: 1}},
+		clearDurations(metrics.Snapshot()))
+}
+
+func clearDurations(stats []commands.CommandStats) []commands.CommandStats {
+	for i := range stats {
+		stats[i].TotalDuration = 0
+	}
+	return stats
+}
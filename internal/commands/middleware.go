@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/pkg/apperror"
+	"go.uber.org/zap"
+)
+
+// Logging logs every handled command with its outcome and duration.
+func Logging(l *zap.Logger) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *Request) (map[string]interface{}, error) {
+			start := time.Now()
+			result, err := next.Handle(ctx, req)
+			fields := []zap.Field{
+				zap.String("command", req.Command.CommandCode),
+				zap.String("device_id", req.Command.DeviceID.String()),
+				zap.Duration("duration", time.Since(start)),
+			}
+			if err != nil {
+				l.Warn("command handler failed", append(fields, zap.Error(err))...)
+			} else {
+				l.Debug("command handled", fields...)
+			}
+			return result, err
+		})
+	}
+}
+
+// AuthorizeDevice refuses commands from devices that aren't allowed to talk to the server anymore.
+func AuthorizeDevice() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *Request) (map[string]interface{}, error) {
+			switch req.Device.Status {
+			case model.DeviceStatusDecommissioned, model.DeviceStatusSuspended:
+				return nil, apperror.ErrForbidden.WithMessagef("device is %s", req.Device.Status)
+			}
+			return next.Handle(ctx, req)
+		})
+	}
+}
+
+// CommandStats are the counters of one command code.
+type CommandStats struct {
+	CommandCode   string        `json:"command_code"`
+	Handled       int64         `json:"handled"`
+	Failed        int64         `json:"failed"`
+	TotalDuration time.Duration `json:"total_duration"`
+}
+
+// Metrics counts handled and failed commands per command code.
+type Metrics struct {
+	mu    sync.Mutex
+	stats map[string]*CommandStats
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{stats: make(map[string]*CommandStats)}
+}
+
+// Middleware records every handled command.
+func (m *Metrics) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, req *Request) (map[string]interface{}, error) {
+			start := time.Now()
+			result, err := next.Handle(ctx, req)
+			m.record(req.Command.CommandCode, time.Since(start), err)
+			return result, err
+		})
+	}
+}
+
+func (m *Metrics) record(commandCode string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.stats[commandCode]
+	if !ok {
+		stats = &CommandStats{CommandCode: commandCode}
+		m.stats[commandCode] = stats
+	}
+	stats.Handled++
+	stats.TotalDuration += duration
+	if err != nil {
+		stats.Failed++
+	}
+}
+
+// Snapshot returns a copy of the counters, ordered by command code.
+func (m *Metrics) Snapshot() []CommandStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make([]CommandStats, 0, len(m.stats))
+	for _, stats := range m.stats {
+		snapshot = append(snapshot, *stats)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].CommandCode < snapshot[j].CommandCode
+	})
+	return snapshot
+}
@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/vars7899/iots/config"
+)
+
+// Violation codes of a payload checked against its schema.
+const (
+	ViolationRequired     = "required"
+	ViolationInvalidType  = "invalid_type"
+	ViolationUnknownField = "unknown_field"
+	ViolationInvalidSpec  = "invalid_schema" // the schema declares a data type the validator doesn't know
+)
+
+// Violation is one way a command payload breaks its schema.
+type Violation struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Field, v.Message)
+}
+
+// ValidatePayload checks the payload against the schema and returns every violation, ordered by field. Null values
+// count as missing.
+func ValidatePayload(schema *config.PayloadSchema, payload map[string]interface{}) []Violation {
+	var violations []Violation
+
+	for _, field := range schema.Required {
+		if payload[field] == nil {
+			violations = append(violations, Violation{Field: field, Code: ViolationRequired, Message: "field is required"})
+		}
+	}
+
+	for field, value := range payload {
+		property, ok := schema.Properties[field]
+		if !ok {
+			if schema.UnknownFields == config.UnknownFieldsReject {
+				violations = append(violations, Violation{Field: field, Code: ViolationUnknownField, Message: "field is not defined by the schema"})
+			}
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		known, valid := matchesDataType(property.DataType, value)
+		switch {
+		case !known:
+			violations = append(violations, Violation{Field: field, Code: ViolationInvalidSpec, Message: fmt.Sprintf("schema declares unsupported data type %q", property.DataType)})
+		case !valid:
+			violations = append(violations, Violation{Field: field, Code: ViolationInvalidType, Message: fmt.Sprintf("expected %s, got %s", property.DataType, jsonTypeName(value))})
+		}
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Field < violations[j].Field
+	})
+	return violations
+}
+
+// matchesDataType reports whether the data type is known and whether the decoded json value is of that type.
+func matchesDataType(dataType string, value interface{}) (known bool, valid bool) {
+	switch dataType {
+	case config.PayloadTypeString:
+		_, ok := value.(string)
+		return true, ok
+	case config.PayloadTypeInt:
+		n, ok := number(value)
+		return true, ok && n == math.Trunc(n) && !math.IsInf(n, 0)
+	case config.PayloadTypeFloat:
+		_, ok := number(value)
+		return true, ok
+	case config.PayloadTypeBool:
+		_, ok := value.(bool)
+		return true, ok
+	case config.PayloadTypeStringArray:
+		switch items := value.(type) {
+		case []string:
+			return true, true
+		case []interface{}:
+			for _, item := range items {
+				if _, ok := item.(string); !ok {
+					return true, false
+				}
+			}
+			return true, true
+		}
+		return true, false
+	case config.PayloadTypeObject:
+		_, ok := value.(map[string]interface{})
+		return true, ok
+	}
+	return false, false
+}
+
+func number(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case float64, float32, int, int64, json.Number:
+		return "number"
+	case []interface{}, []string:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,53 @@
+package commands_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/commands"
+)
+
+func TestValidatePayloadListsEveryViolation(t *testing.T) {
+	schema := &config.PayloadSchema{
+		Required: []string{"firmware_version", "sensors", "interval"},
+		Properties: map[string]config.PayloadPropertiesSchema{
+			"firmware_version": {DataType: config.PayloadTypeString},
+			"sensors":          {DataType: config.PayloadTypeStringArray},
+			"interval":         {DataType: config.PayloadTypeInt},
+			"ratio":            {DataType: config.PayloadTypeFloat},
+			"debug":            {DataType: config.PayloadTypeBool},
+			"options":          {DataType: config.PayloadTypeObject},
+		},
+		UnknownFields: config.UnknownFieldsReject,
+	}
+
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"firmware_version": "1.2.0",
+		"sensors": ["temp", 3],
+		"interval": 1.5,
+		"ratio": 2,
+		"debug": "yes",
+		"options": {"level": 1},
+		"colour": "red"
+	}`), &payload))
+
+	violations := commands.ValidatePayload(schema, payload)
+	assert.Equal(t, []commands.Violation{
+		{Field: "colour", Code: commands.ViolationUnknownField, Message: "field is not defined by the schema"},
+		{Field: "debug", Code: commands.ViolationInvalidType, Message: "expected bool, got string"},
+		{Field: "interval", Code: commands.ViolationInvalidType, Message: "expected int, got number"},
+		{Field: "sensors", Code: commands.ViolationInvalidType, Message: "expected string_array, got array"},
+	}, violations)
+
+	// missing required fields, unknown fields allowed by default
+	schema.UnknownFields = ""
+	violations = commands.ValidatePayload(schema, map[string]interface{}{"interval": 10.0, "colour": "red"})
+	assert.Equal(t, []commands.Violation{
+		{Field: "firmware_version", Code: commands.ViolationRequired, Message: "field is required"},
+		{Field: "sensors", Code: commands.ViolationRequired, Message: "field is required"},
+	}, violations)
+}
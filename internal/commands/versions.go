@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"sort"
+
+	"github.com/vars7899/iots/config"
+)
+
+// NegotiateVersions picks the schema version of each command a device declared at device@init, as command code to
+// version. Declared versions that aren't active are left out, the device gets the latest version of those commands,
+// and reported with the active versions so the device knows what to move to. Unknown command codes are ignored.
+func NegotiateVersions(schemas *config.DeviceCommandSchemaRegistry, declared map[string]interface{}) (map[string]int, map[string][]int) {
+	negotiated := make(map[string]int)
+	unsupported := make(map[string][]int)
+
+	codes := make([]string, 0, len(declared))
+	for code := range declared {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	for _, code := range codes {
+		schema := schemas.GetCommandByCode(code)
+		if schema == nil {
+			continue
+		}
+		// json numbers, version 0 would pick the latest
+		version, ok := declared[code].(float64)
+		if ok && version >= 1 && version == float64(int(version)) && schema.Version(int(version)) != nil {
+			negotiated[code] = int(version)
+			continue
+		}
+		unsupported[code] = schema.ActiveVersions()
+	}
+	return negotiated, unsupported
+}
@@ -12,8 +12,30 @@ var DB_Tables = []interface{}{
 	&model.UserRole{},
 	&model.RolePermission{},
 	&model.Device{},
+	&model.DeviceStatusChange{},
 	&model.Sensor{},
 	&model.Telemetry{},
+	&model.TelemetryRejection{},
+	&model.DeviceIngestStats{},
+	&model.Alert{},
+	&model.AlertNote{},
+	&model.NotificationDelivery{},
+	&model.NotificationAttempt{},
+	&model.Rule{},
+	&model.RuleState{},
+	&model.MaintenanceWindow{},
+	&model.DeviceMaintenance{},
+	&model.OnCallSchedule{},
+	&model.OnCallOverride{},
+	&model.EscalationPolicy{},
+	&model.AlertEscalation{},
+	&model.Command{},
+	&model.CommandSchedule{},
+	&model.CommandCampaign{},
+	&model.CommandCampaignWave{},
+	&model.CommandCampaignTarget{},
+	&model.DeadLetter{},
+	&model.Lease{},
 	&model.AccessGroup{},
 	// &model.DeviceEvent{},
 	&domain.GeoLocation{},
@@ -0,0 +1,57 @@
+package devicemanifest
+
+// csv columns of a manifest, the tags and the capabilities are separated by ; and the json columns hold json
+const (
+	ColumnName                = "name"
+	ColumnDescription         = "description"
+	ColumnMACAddress          = "mac_address"
+	ColumnIPAddress           = "ip_address"
+	ColumnManufacturer        = "manufacturer"
+	ColumnModelNumber         = "model_number"
+	ColumnSerialNumber        = "serial_number"
+	ColumnFirmwareVersion     = "firmware_version"
+	ColumnHardwareVersion     = "hardware_version"
+	ColumnSoftwareVersion     = "software_version"
+	ColumnLatitude            = "latitude"
+	ColumnLongitude           = "longitude"
+	ColumnAltitude            = "altitude"
+	ColumnLocationDescription = "location_description"
+	ColumnTags                = "tags"
+	ColumnCapabilities        = "capabilities"
+	ColumnTelemetryEnabled    = "telemetry_enabled"
+	ColumnReportingFrequency  = "reporting_frequency_seconds"
+	ColumnBatchSize           = "batch_size"
+	ColumnRetentionPeriod     = "retention_period_days"
+	ColumnStorageQuota        = "storage_quota_bytes"
+	ColumnCompressionEnabled  = "compression_enabled"
+	ColumnEncryptionEnabled   = "encryption_enabled"
+	ColumnAlertThresholds     = "alert_thresholds"
+	ColumnMetadata            = "metadata"
+)
+
+// Columns are the csv columns in the order they are exported.
+var Columns = []string{
+	ColumnName, ColumnDescription, ColumnMACAddress, ColumnIPAddress,
+	ColumnManufacturer, ColumnModelNumber, ColumnSerialNumber, ColumnFirmwareVersion, ColumnHardwareVersion, ColumnSoftwareVersion,
+	ColumnLatitude, ColumnLongitude, ColumnAltitude, ColumnLocationDescription,
+	ColumnTags, ColumnCapabilities,
+	ColumnTelemetryEnabled, ColumnReportingFrequency, ColumnBatchSize, ColumnRetentionPeriod, ColumnStorageQuota,
+	ColumnCompressionEnabled, ColumnEncryptionEnabled, ColumnAlertThresholds,
+	ColumnMetadata,
+}
+
+// requiredColumns are the columns a csv manifest can't do without, the other ones are optional.
+var requiredColumns = []string{ColumnName, ColumnMACAddress, ColumnManufacturer, ColumnModelNumber, ColumnSerialNumber}
+
+var telemetryColumns = []string{
+	ColumnTelemetryEnabled, ColumnReportingFrequency, ColumnBatchSize, ColumnRetentionPeriod, ColumnStorageQuota,
+	ColumnCompressionEnabled, ColumnEncryptionEnabled, ColumnAlertThresholds,
+}
+
+var knownColumns = func() map[string]bool {
+	known := make(map[string]bool, len(Columns))
+	for _, column := range Columns {
+		known[column] = true
+	}
+	return known
+}()
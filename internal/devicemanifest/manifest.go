@@ -0,0 +1,322 @@
+// Package devicemanifest reads and writes the manifests of devices onboarded in batches, a CSV file with a header
+// row or NDJSON with a device registration per line. An exported manifest imports again.
+package devicemanifest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"gorm.io/datatypes"
+)
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+// MaxRows is the most devices a manifest registers at once.
+const MaxRows = 1000
+
+// listSeparator separates the tags and the capabilities in a csv cell.
+const listSeparator = ";"
+
+func ParseFormat(raw string) (Format, error) {
+	switch Format(strings.ToLower(raw)) {
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatNDJSON, "jsonl":
+		return FormatNDJSON, nil
+	}
+	return "", fmt.Errorf("unknown manifest format %q, expected csv or ndjson", raw)
+}
+
+// FormatOf guesses the format of a manifest from its content type, then from its file name.
+func FormatOf(contentType, fileName string) (Format, error) {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mediaType {
+		case "text/csv":
+			return FormatCSV, nil
+		case "application/x-ndjson", "application/ndjson", "application/jsonl":
+			return FormatNDJSON, nil
+		}
+	}
+	if ext := strings.TrimPrefix(filepath.Ext(fileName), "."); ext != "" {
+		return ParseFormat(ext)
+	}
+	return "", errors.New("cannot tell the manifest format, set the format to csv or ndjson")
+}
+
+func (f Format) ContentType() string {
+	if f == FormatCSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// Row is a device of a manifest, Err is why its line couldn't be read as a device registration.
+type Row struct {
+	Line   int
+	Device *dto.RegisterDeviceRequest
+	Err    error
+}
+
+// Read reads every device of the manifest. A row that can't be read doesn't stop the others, an error is returned
+// when the manifest itself is unreadable.
+func Read(r io.Reader, format Format) ([]*Row, error) {
+	var rows []*Row
+	var err error
+	switch format {
+	case FormatCSV:
+		rows, err = readCSV(r)
+	case FormatNDJSON:
+		rows, err = readNDJSON(r)
+	default:
+		return nil, fmt.Errorf("unknown manifest format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("manifest has no device")
+	}
+	return rows, nil
+}
+
+func readNDJSON(r io.Reader) ([]*Row, error) {
+	var rows []*Row
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		if len(rows) == MaxRows {
+			return nil, fmt.Errorf("manifest has more than %d devices", MaxRows)
+		}
+		row := &Row{Line: line}
+		var device dto.RegisterDeviceRequest
+		if err := json.Unmarshal(raw, &device); err != nil {
+			row.Err = fmt.Errorf("invalid json: %w", err)
+		} else {
+			row.Device = &device
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return rows, nil
+}
+
+func readCSV(r io.Reader) ([]*Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("manifest has no header row")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest header: %w", err)
+	}
+	index := make(map[string]int, len(header))
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !knownColumns[column] {
+			return nil, fmt.Errorf("unknown manifest column %q", column)
+		}
+		index[column] = i
+	}
+	for _, column := range requiredColumns {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("manifest is missing the %s column", column)
+		}
+	}
+
+	var rows []*Row
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var line int
+		if parseErr := (*csv.ParseError)(nil); errors.As(err, &parseErr) {
+			// a malformed line is the row's error, the reader carries on with the next one
+			line = parseErr.StartLine
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read manifest: %w", err)
+		} else if isBlankRecord(record) {
+			continue
+		} else {
+			line, _ = reader.FieldPos(0)
+		}
+		if len(rows) == MaxRows {
+			return nil, fmt.Errorf("manifest has more than %d devices", MaxRows)
+		}
+		row := &Row{Line: line, Err: err}
+		if err == nil {
+			row.Device, row.Err = decodeRecord(record, index)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func isBlankRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeRecord(record []string, index map[string]int) (*dto.RegisterDeviceRequest, error) {
+	cell := func(column string) string {
+		if i, ok := index[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	hasAny := func(columns ...string) bool {
+		for _, column := range columns {
+			if cell(column) != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	device := &dto.RegisterDeviceRequest{
+		Name:        cell(ColumnName),
+		Description: cell(ColumnDescription),
+		MACAddress:  cell(ColumnMACAddress),
+		IPAddress:   cell(ColumnIPAddress),
+		Specification: dto.DeviceSpecificationPayload{
+			Manufacturer:    cell(ColumnManufacturer),
+			ModelNumber:     cell(ColumnModelNumber),
+			SerialNumber:    cell(ColumnSerialNumber),
+			FirmwareVersion: cell(ColumnFirmwareVersion),
+			HardwareVersion: cell(ColumnHardwareVersion),
+			SoftwareVersion: cell(ColumnSoftwareVersion),
+		},
+		Tags:         splitList(cell(ColumnTags)),
+		Capabilities: splitList(cell(ColumnCapabilities)),
+	}
+	var err error
+	if metadata := cell(ColumnMetadata); metadata != "" {
+		if !json.Valid([]byte(metadata)) {
+			return nil, fmt.Errorf("%s is not valid json", ColumnMetadata)
+		}
+		device.Metadata = datatypes.JSON(metadata)
+	}
+
+	if hasAny(ColumnLatitude, ColumnLongitude, ColumnAltitude, ColumnLocationDescription) {
+		location := &dto.LocationPayload{Description: cell(ColumnLocationDescription)}
+		if location.Latitude, err = parseFloat(cell(ColumnLatitude), ColumnLatitude); err != nil {
+			return nil, err
+		}
+		if location.Longitude, err = parseFloat(cell(ColumnLongitude), ColumnLongitude); err != nil {
+			return nil, err
+		}
+		if altitude := cell(ColumnAltitude); altitude != "" {
+			value, err := parseFloat(altitude, ColumnAltitude)
+			if err != nil {
+				return nil, err
+			}
+			location.Altitude = &value
+		}
+		device.Location = location
+	}
+
+	if hasAny(telemetryColumns...) {
+		telemetry := &dto.TelemetryConfigPayload{}
+		if telemetry.Enabled, err = parseBool(cell(ColumnTelemetryEnabled), ColumnTelemetryEnabled); err != nil {
+			return nil, err
+		}
+		if telemetry.ReportingFrequency, err = parseInt(cell(ColumnReportingFrequency), ColumnReportingFrequency); err != nil {
+			return nil, err
+		}
+		if telemetry.BatchSize, err = parseInt(cell(ColumnBatchSize), ColumnBatchSize); err != nil {
+			return nil, err
+		}
+		if telemetry.RetentionPeriod, err = parseInt(cell(ColumnRetentionPeriod), ColumnRetentionPeriod); err != nil {
+			return nil, err
+		}
+		if storageQuota := cell(ColumnStorageQuota); storageQuota != "" {
+			if telemetry.StorageQuota, err = strconv.ParseInt(storageQuota, 10, 64); err != nil {
+				return nil, fmt.Errorf("%s is not an integer: %s", ColumnStorageQuota, storageQuota)
+			}
+		}
+		if telemetry.CompressionEnabled, err = parseBool(cell(ColumnCompressionEnabled), ColumnCompressionEnabled); err != nil {
+			return nil, err
+		}
+		if telemetry.EncryptionEnabled, err = parseBool(cell(ColumnEncryptionEnabled), ColumnEncryptionEnabled); err != nil {
+			return nil, err
+		}
+		if thresholds := cell(ColumnAlertThresholds); thresholds != "" {
+			if err := json.Unmarshal([]byte(thresholds), &telemetry.AlertThresholds); err != nil {
+				return nil, fmt.Errorf("%s is not valid json: %w", ColumnAlertThresholds, err)
+			}
+		}
+		device.TelemetryConfig = telemetry
+	}
+	return device, nil
+}
+
+func splitList(raw string) []string {
+	var values []string
+	for _, value := range strings.Split(raw, listSeparator) {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func parseFloat(raw, column string) (float64, error) {
+	if raw == "" {
+		return 0, fmt.Errorf("%s is required with a location", column)
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a number: %s", column, raw)
+	}
+	return value, nil
+}
+
+func parseInt(raw, column string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not an integer: %s", column, raw)
+	}
+	return value, nil
+}
+
+func parseBool(raw, column string) (bool, error) {
+	if raw == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s is not a boolean: %s", column, raw)
+	}
+	return value, nil
+}
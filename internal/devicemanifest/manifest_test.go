@@ -0,0 +1,88 @@
+package devicemanifest_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/devicemanifest"
+	"gorm.io/datatypes"
+)
+
+func factoryBatch() []*dto.RegisterDeviceRequest {
+	altitude := 212.5
+	return []*dto.RegisterDeviceRequest{
+		{
+			Name:          "boiler, east wing",
+			MACAddress:    "00:1a:2b:3c:4d:5e",
+			Specification: dto.DeviceSpecificationPayload{Manufacturer: "Acme", ModelNumber: "B-200", SerialNumber: "SN-001", FirmwareVersion: "1.4.2"},
+			Location:      &dto.LocationPayload{Latitude: 49.2827, Longitude: -123.1207, Altitude: &altitude, Description: "plant 2"},
+			TelemetryConfig: &dto.TelemetryConfigPayload{
+				Enabled: true, ReportingFrequency: 30, BatchSize: 10, RetentionPeriod: 90, StorageQuota: 1 << 30, CompressionEnabled: true,
+			},
+			Metadata:     datatypes.JSON(`{"line":"A","shift":2}`),
+			Tags:         pq.StringArray{"boiler", "east"},
+			Capabilities: pq.StringArray{"temperature", "pressure"},
+		},
+		{
+			Name:          "chiller",
+			Description:   `spare, "do not deploy"`,
+			MACAddress:    "00:1a:2b:3c:4d:5f",
+			IPAddress:     "10.0.4.17",
+			Specification: dto.DeviceSpecificationPayload{Manufacturer: "Acme", ModelNumber: "C-10", SerialNumber: "SN-002"},
+		},
+	}
+}
+
+func TestManifestExportImportsAgain(t *testing.T) {
+	for _, format := range []devicemanifest.Format{devicemanifest.FormatCSV, devicemanifest.FormatNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			var manifest bytes.Buffer
+			writer := devicemanifest.NewWriter(&manifest, format)
+			for _, device := range factoryBatch() {
+				require.NoError(t, writer.Write(device))
+			}
+			require.NoError(t, writer.Flush())
+
+			rows, err := devicemanifest.Read(&manifest, format)
+			require.NoError(t, err)
+			require.Len(t, rows, 2)
+			for i, device := range factoryBatch() {
+				require.NoError(t, rows[i].Err)
+				assert.Equal(t, device, rows[i].Device)
+			}
+		})
+	}
+}
+
+func TestManifestBadRowsDoNotStopTheOthers(t *testing.T) {
+	manifest := strings.Join([]string{
+		"name,mac_address,manufacturer,model_number,serial_number,latitude,longitude,batch_size",
+		"boiler,00:1a:2b:3c:4d:5e,Acme,B-200,SN-001,49.28,-123.12,10",
+		"",
+		"chiller,00:1a:2b:3c:4d:5f,Acme,C-10,SN-002,north,-123.12,",
+		"pump,00:1a:2b:3c:4d:60,Acme,P-1,SN-003,,,ten",
+		"fan,00:1a:2b:3c:4d:61,Acme,F-1,SN-004,,,",
+	}, "\n")
+
+	rows, err := devicemanifest.Read(strings.NewReader(manifest), devicemanifest.FormatCSV)
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	assert.NoError(t, rows[0].Err)
+	assert.Equal(t, 10, rows[0].Device.TelemetryConfig.BatchSize)
+	assert.EqualError(t, rows[1].Err, "latitude is not a number: north")
+	assert.Equal(t, 4, rows[1].Line, "the blank line still counts")
+	assert.EqualError(t, rows[2].Err, "batch_size is not an integer: ten")
+	require.NoError(t, rows[3].Err)
+	assert.Nil(t, rows[3].Device.Location)
+	assert.Nil(t, rows[3].Device.TelemetryConfig)
+
+	_, err = devicemanifest.Read(strings.NewReader("name,mac_address,colour\n"), devicemanifest.FormatCSV)
+	assert.EqualError(t, err, `unknown manifest column "colour"`)
+	_, err = devicemanifest.Read(strings.NewReader("\n\n"), devicemanifest.FormatNDJSON)
+	assert.EqualError(t, err, "manifest has no device")
+}
@@ -0,0 +1,109 @@
+package devicemanifest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/vars7899/iots/internal/api/v1/dto"
+)
+
+// Writer writes devices as a manifest, Flush must be called once every device is written.
+type Writer struct {
+	format      Format
+	csv         *csv.Writer
+	json        *json.Encoder
+	wroteHeader bool
+}
+
+func NewWriter(w io.Writer, format Format) *Writer {
+	if format == FormatCSV {
+		return &Writer{format: format, csv: csv.NewWriter(w)}
+	}
+	return &Writer{format: format, json: json.NewEncoder(w)}
+}
+
+func (w *Writer) Write(device *dto.RegisterDeviceRequest) error {
+	if w.format != FormatCSV {
+		return w.json.Encode(device)
+	}
+	if !w.wroteHeader {
+		if err := w.csv.Write(Columns); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	record, err := encodeRecord(device)
+	if err != nil {
+		return err
+	}
+	return w.csv.Write(record)
+}
+
+// Flush writes what is buffered, a csv manifest without a device still gets its header.
+func (w *Writer) Flush() error {
+	if w.format != FormatCSV {
+		return nil
+	}
+	if !w.wroteHeader {
+		if err := w.csv.Write(Columns); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+func encodeRecord(device *dto.RegisterDeviceRequest) ([]string, error) {
+	cells := map[string]string{
+		ColumnName:            device.Name,
+		ColumnDescription:     device.Description,
+		ColumnMACAddress:      device.MACAddress,
+		ColumnIPAddress:       device.IPAddress,
+		ColumnManufacturer:    device.Specification.Manufacturer,
+		ColumnModelNumber:     device.Specification.ModelNumber,
+		ColumnSerialNumber:    device.Specification.SerialNumber,
+		ColumnFirmwareVersion: device.Specification.FirmwareVersion,
+		ColumnHardwareVersion: device.Specification.HardwareVersion,
+		ColumnSoftwareVersion: device.Specification.SoftwareVersion,
+		ColumnTags:            strings.Join(device.Tags, listSeparator),
+		ColumnCapabilities:    strings.Join(device.Capabilities, listSeparator),
+	}
+	if len(device.Metadata) > 0 && string(device.Metadata) != "null" {
+		cells[ColumnMetadata] = string(device.Metadata)
+	}
+	if location := device.Location; location != nil {
+		cells[ColumnLatitude] = strconv.FormatFloat(location.Latitude, 'f', -1, 64)
+		cells[ColumnLongitude] = strconv.FormatFloat(location.Longitude, 'f', -1, 64)
+		if location.Altitude != nil {
+			cells[ColumnAltitude] = strconv.FormatFloat(*location.Altitude, 'f', -1, 64)
+		}
+		cells[ColumnLocationDescription] = location.Description
+	}
+	if telemetry := device.TelemetryConfig; telemetry != nil {
+		cells[ColumnTelemetryEnabled] = strconv.FormatBool(telemetry.Enabled)
+		cells[ColumnReportingFrequency] = strconv.Itoa(telemetry.ReportingFrequency)
+		cells[ColumnBatchSize] = strconv.Itoa(telemetry.BatchSize)
+		cells[ColumnRetentionPeriod] = strconv.Itoa(telemetry.RetentionPeriod)
+		cells[ColumnStorageQuota] = strconv.FormatInt(telemetry.StorageQuota, 10)
+		cells[ColumnCompressionEnabled] = strconv.FormatBool(telemetry.CompressionEnabled)
+		cells[ColumnEncryptionEnabled] = strconv.FormatBool(telemetry.EncryptionEnabled)
+		if len(telemetry.AlertThresholds) > 0 {
+			thresholds, err := json.Marshal(telemetry.AlertThresholds)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode alert thresholds of %s: %w", device.MACAddress, err)
+			}
+			cells[ColumnAlertThresholds] = string(thresholds)
+		}
+	}
+
+	record := make([]string, len(Columns))
+	for i, column := range Columns {
+		record[i] = cells[column]
+	}
+	return record, nil
+}
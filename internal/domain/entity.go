@@ -1,11 +1,31 @@
 package domain
 
 const (
-	EntitySensor     = "sensor"
-	EntityDevice     = "device"
-	EntityUser       = "user"
-	EntityTelemetry  = "telemetry"
-	EntityAccessRule = "access rule"
-	EntityRole       = "role"
-	EntityToken      = "token"
+	EntitySensor              = "sensor"
+	EntityDevice              = "device"
+	EntityDeviceStatusChange  = "device status change"
+	EntityUser                = "user"
+	EntityTelemetry           = "telemetry"
+	EntityTelemetryRejection  = "telemetry rejection"
+	EntityIngestStats         = "ingest statistics"
+	EntityAlert               = "alert"
+	EntityAlertNote           = "alert note"
+	EntityNotification        = "notification delivery"
+	EntityNotificationAttempt = "notification attempt"
+	EntityRule                = "rule"
+	EntityRuleState           = "rule state"
+	EntityMaintenanceWindow   = "maintenance window"
+	EntityDeviceMaintenance   = "device maintenance"
+	EntityEscalationPolicy    = "escalation policy"
+	EntityAlertEscalation     = "alert escalation"
+	EntityOnCallSchedule      = "on-call schedule"
+	EntityOnCallOverride      = "on-call override"
+	EntityCommand             = "command"
+	EntityCommandSchedule     = "command schedule"
+	EntityCommandCampaign     = "command campaign"
+	EntityDeadLetter          = "dead letter"
+	EntityLease               = "lease"
+	EntityAccessRule          = "access rule"
+	EntityRole                = "role"
+	EntityToken               = "token"
 )
@@ -1,6 +1,13 @@
 package domain
 
+import "github.com/google/uuid"
+
 type DeviceFilter struct {
-	Name   *string `query:"name"`
-	Status *string `query:"status"`
+	Name         *string `query:"name"` // case insensitive substring of the name
+	Status       []string
+	Tags         []string // devices having every tag
+	Capabilities []string // devices having every capability
+	OwnerID      *uuid.UUID
+	Manufacturer *string
+	Deleted      bool // only the soft deleted devices
 }
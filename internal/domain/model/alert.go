@@ -0,0 +1,289 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+type ThresholdCondition string
+
+const (
+	ThresholdAbove       ThresholdCondition = "above"        // breached when value > Value
+	ThresholdBelow       ThresholdCondition = "below"        // breached when value < Value
+	ThresholdOutsideBand ThresholdCondition = "outside_band" // breached when value < Low or value > High
+	// condition of alerts raised by a composite rule, see Alert.RuleID
+	ConditionRule ThresholdCondition = "rule"
+)
+
+type AlertSeverity string
+
+const (
+	AlertSeverityInfo     AlertSeverity = "info"
+	AlertSeverityWarning  AlertSeverity = "warning"
+	AlertSeverityCritical AlertSeverity = "critical"
+)
+
+type AlertStatus string
+
+const (
+	AlertStatusOpen         AlertStatus = "open"
+	AlertStatusAcknowledged AlertStatus = "acknowledged"  // an operator is on it, the alert is still raised
+	AlertStatusFlapping     AlertStatus = "flapping"      // kept raised while the source keeps breaching and clearing
+	AlertStatusResolved     AlertStatus = "resolved"      // closed by an operator
+	AlertStatusAutoResolved AlertStatus = "auto_resolved" // the reading went back inside the threshold
+)
+
+func IsValidAlertStatus(inputStr string) bool {
+	switch AlertStatus(inputStr) {
+	case AlertStatusOpen, AlertStatusAcknowledged, AlertStatusFlapping, AlertStatusResolved, AlertStatusAutoResolved:
+		return true
+	default:
+		return false
+	}
+}
+
+// ActiveAlertStatuses are the statuses of a raised alert.
+var ActiveAlertStatuses = []AlertStatus{AlertStatusOpen, AlertStatusAcknowledged, AlertStatusFlapping}
+
+// AlertSeverityRank orders severities, higher is worse.
+func AlertSeverityRank(severity AlertSeverity) int {
+	switch severity {
+	case AlertSeverityInfo:
+		return 1
+	case AlertSeverityWarning:
+		return 2
+	case AlertSeverityCritical:
+		return 3
+	}
+	return 0
+}
+
+// AlertFingerprint identifies the source of an alert, the same rule or the same sensor field condition on the same
+// device. There is at most one raised alert per fingerprint, repeated breaches count as occurrences of it.
+func AlertFingerprint(deviceID uuid.UUID, ruleID *uuid.UUID, sensorID uuid.UUID, fieldCode string, condition ThresholdCondition) string {
+	var source string
+	if ruleID != nil {
+		source = "rule:" + ruleID.String()
+	} else {
+		source = fmt.Sprintf("sensor:%s|%s|%s", sensorID, fieldCode, condition)
+	}
+	sum := sha256.Sum256([]byte(deviceID.String() + "|" + source))
+	return hex.EncodeToString(sum[:16])
+}
+
+// AlertGroupBy selects how related alerts are grouped, see TelemetryConfig.AlertGroupBy.
+type AlertGroupBy string
+
+const (
+	AlertGroupByDevice AlertGroupBy = "device"
+	AlertGroupBySite   AlertGroupBy = "site" // device metadata site, devices without one fall back to their own group
+)
+
+// AlertGroupKey returns the group of the device's alerts, e.g. "device:<id>" or "site:plant-1".
+func AlertGroupKey(groupBy AlertGroupBy, device *Device) string {
+	if groupBy == AlertGroupBySite {
+		if site := DeviceSite(device); site != "" {
+			return "site:" + site
+		}
+	}
+	return "device:" + device.ID.String()
+}
+
+// AlertGroup summarises the raised alerts sharing a group key.
+type AlertGroup struct {
+	GroupKey        string        `json:"group_key"`
+	Alerts          int64         `json:"alerts"`
+	Occurrences     int64         `json:"occurrences"`
+	Flapping        int64         `json:"flapping"`
+	Severity        AlertSeverity `json:"severity"` // worst severity in the group
+	LastTriggeredAt time.Time     `json:"last_triggered_at"`
+}
+
+// AlertThreshold is a single threshold rule for one sensor field.
+//
+// Hysteresis keeps a firing alert open until the value is back inside the threshold by that margin, so a reading
+// hovering around the limit doesn't flap. MinDurationSeconds requires the breach to hold for that long before firing,
+// ClearDurationSeconds requires the value to stay back to normal for that long before the alert auto resolves.
+type AlertThreshold struct {
+	Condition            ThresholdCondition `json:"condition"`
+	Value                *float64           `json:"value,omitempty"` // above, below
+	Low                  *float64           `json:"low,omitempty"`   // outside_band
+	High                 *float64           `json:"high,omitempty"`  // outside_band
+	Hysteresis           float64            `json:"hysteresis,omitempty"`
+	MinDurationSeconds   int                `json:"min_duration_seconds,omitempty"`
+	ClearDurationSeconds int                `json:"clear_duration_seconds,omitempty"`
+	Severity             AlertSeverity      `json:"severity,omitempty"` // defaults to warning
+}
+
+// AlertThresholds is the format of TelemetryConfig.AlertThresholds, keyed by sensor code then field code, e.g.
+//
+//	{"sensor-temp-001": {"1": {"condition": "above", "value": 40, "hysteresis": 1.5, "min_duration_seconds": 30}}}
+type AlertThresholds map[string]map[string]AlertThreshold
+
+func ParseAlertThresholds(raw datatypes.JSON) (AlertThresholds, error) {
+	thresholds := AlertThresholds{}
+	if len(raw) == 0 || string(raw) == "null" {
+		return thresholds, nil
+	}
+	if err := json.Unmarshal(raw, &thresholds); err != nil {
+		return nil, fmt.Errorf("invalid alert thresholds: %w", err)
+	}
+	if err := thresholds.Validate(); err != nil {
+		return nil, err
+	}
+	return thresholds, nil
+}
+
+func (t AlertThresholds) Validate() error {
+	for sensorCode, fields := range t {
+		for fieldCode, threshold := range fields {
+			if err := threshold.Validate(); err != nil {
+				return fmt.Errorf("alert threshold %s/%s: %w", sensorCode, fieldCode, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (t AlertThreshold) Validate() error {
+	switch t.Condition {
+	case ThresholdAbove, ThresholdBelow:
+		if t.Value == nil {
+			return fmt.Errorf("condition %s requires value", t.Condition)
+		}
+	case ThresholdOutsideBand:
+		if t.Low == nil || t.High == nil {
+			return fmt.Errorf("condition %s requires low and high", t.Condition)
+		}
+		if *t.Low >= *t.High {
+			return fmt.Errorf("low must be less than high")
+		}
+		if 2*t.Hysteresis >= *t.High-*t.Low {
+			return fmt.Errorf("hysteresis must be less than half the band")
+		}
+	default:
+		return fmt.Errorf("unknown condition %q", t.Condition)
+	}
+	if t.Hysteresis < 0 {
+		return fmt.Errorf("hysteresis must not be negative")
+	}
+	if t.MinDurationSeconds < 0 {
+		return fmt.Errorf("min_duration_seconds must not be negative")
+	}
+	if t.ClearDurationSeconds < 0 {
+		return fmt.Errorf("clear_duration_seconds must not be negative")
+	}
+	switch t.Severity {
+	case "", AlertSeverityInfo, AlertSeverityWarning, AlertSeverityCritical:
+	default:
+		return fmt.Errorf("unknown severity %q", t.Severity)
+	}
+	return nil
+}
+
+// Breached reports whether the value is outside the threshold.
+func (t AlertThreshold) Breached(value float64) bool {
+	switch t.Condition {
+	case ThresholdAbove:
+		return value > *t.Value
+	case ThresholdBelow:
+		return value < *t.Value
+	case ThresholdOutsideBand:
+		return value < *t.Low || value > *t.High
+	}
+	return false
+}
+
+// Cleared reports whether the value is back inside the threshold by at least the hysteresis margin.
+func (t AlertThreshold) Cleared(value float64) bool {
+	switch t.Condition {
+	case ThresholdAbove:
+		return value <= *t.Value-t.Hysteresis
+	case ThresholdBelow:
+		return value >= *t.Value+t.Hysteresis
+	case ThresholdOutsideBand:
+		return value >= *t.Low+t.Hysteresis && value <= *t.High-t.Hysteresis
+	}
+	return true
+}
+
+func (t AlertThreshold) MinDuration() time.Duration {
+	return time.Duration(t.MinDurationSeconds) * time.Second
+}
+
+func (t AlertThreshold) ClearDuration() time.Duration {
+	return time.Duration(t.ClearDurationSeconds) * time.Second
+}
+
+func (t AlertThreshold) SeverityOrDefault() AlertSeverity {
+	if t.Severity == "" {
+		return AlertSeverityWarning
+	}
+	return t.Severity
+}
+
+// Alert is raised when a sensor field breaches its alert threshold.
+type Alert struct {
+	ID           uuid.UUID          `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	DeviceID     uuid.UUID          `gorm:"type:uuid;not null;index:idx_alert_source" json:"device_id"`
+	SensorID     uuid.UUID          `gorm:"type:uuid;not null;index:idx_alert_source" json:"sensor_id"`
+	SensorCode   string             `gorm:"type:varchar(100);not null" json:"sensor_code"`
+	FieldCode    string             `gorm:"type:varchar(50);not null;index:idx_alert_source" json:"field_code"` // empty for rule alerts
+	RuleID       *uuid.UUID         `gorm:"type:uuid;index" json:"rule_id,omitempty"`
+	Condition    ThresholdCondition `gorm:"type:varchar(20);not null" json:"condition"`
+	Threshold    datatypes.JSON     `gorm:"type:jsonb" json:"threshold"` // snapshot of the threshold or rule definition that fired
+	Severity     AlertSeverity      `gorm:"type:varchar(20);not null;index" json:"severity"`
+	Status       AlertStatus        `gorm:"type:varchar(20);not null;index" json:"status"`
+	Message      string             `gorm:"type:text" json:"message"`
+	TriggerValue float64            `json:"trigger_value"`
+	LastValue    float64            `json:"last_value"`
+	TriggeredAt  time.Time          `gorm:"not null;index" json:"triggered_at"` // when the breach started
+	// same source, same fingerprint, see AlertFingerprint
+	Fingerprint     string     `gorm:"type:varchar(64);index" json:"fingerprint"`
+	GroupKey        string     `gorm:"type:varchar(255);index" json:"group_key"`
+	OccurrenceCount int        `gorm:"not null;default:1" json:"occurrence_count"`
+	LastOccurredAt  *time.Time `json:"last_occurred_at"`
+	// reopenings within the flap window starting at FlapWindowStart, ClearedAt is set while a flapping alert is quiet
+	FlapCount       int        `gorm:"not null;default:0" json:"flap_count"`
+	FlapWindowStart *time.Time `json:"flap_window_start,omitempty"`
+	ClearedAt       *time.Time `gorm:"index" json:"cleared_at,omitempty"`
+	// readings that raised and auto resolved the alert
+	TriggerTelemetryID *uuid.UUID `gorm:"type:uuid" json:"trigger_telemetry_id"`
+	ResolveTelemetryID *uuid.UUID `gorm:"type:uuid" json:"resolve_telemetry_id"`
+
+	AcknowledgedBy *uuid.UUID  `gorm:"type:uuid" json:"acknowledged_by"`
+	AcknowledgedAt *time.Time  `json:"acknowledged_at"`
+	ResolvedBy     *uuid.UUID  `gorm:"type:uuid" json:"resolved_by"` // nil when auto resolved
+	ResolvedAt     *time.Time  `json:"resolved_at"`
+	Notes          []AlertNote `gorm:"foreignKey:AlertID;constraint:OnDelete:CASCADE" json:"notes,omitempty"`
+	CreatedAt      time.Time   `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time   `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// IsActive reports whether the alert is still raised.
+func (a *Alert) IsActive() bool {
+	return a.Status == AlertStatusOpen || a.Status == AlertStatusAcknowledged || a.Status == AlertStatusFlapping
+}
+
+// Occurred counts a repeated breach of a raised alert.
+func (a *Alert) Occurred(at time.Time, value float64) {
+	a.OccurrenceCount++
+	a.LastOccurredAt = &at
+	a.LastValue = value
+	a.ClearedAt = nil
+}
+
+// AlertNote is an operator comment on an alert, acknowledge and resolve notes included.
+type AlertNote struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	AlertID   uuid.UUID `gorm:"type:uuid;not null;index" json:"alert_id"`
+	AuthorID  uuid.UUID `gorm:"type:uuid;not null" json:"author_id"`
+	Body      string    `gorm:"type:text;not null" json:"body"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
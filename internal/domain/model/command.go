@@ -4,25 +4,31 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/datatypes"
 )
 
 type DeviceCommandType string
 
 var (
-	CommandTypeCommand  DeviceCommandType = "command"
-	CommandTypeAck      DeviceCommandType = "ack"
-	CommandTypeResponse DeviceCommandType = "response"
-	CommandTypeError    DeviceCommandType = "error"
+	CommandTypeCommand   DeviceCommandType = "command"
+	CommandTypeAck       DeviceCommandType = "ack"
+	CommandTypeResponse  DeviceCommandType = "response"
+	CommandTypeError     DeviceCommandType = "error"
+	CommandTypeProcessed DeviceCommandType = "processed" // a device lists the queued commands it already ran, payload "command_ids"
 )
 
 type DeviceCommand struct {
-	ID          uuid.UUID              `json:"id"`
-	DeviceID    uuid.UUID              `json:"device_id"`
-	Type        DeviceCommandType      `json:"type"`
-	Command     string                 `json:"command"`
-	CommandCode string                 `json:"command_code"`
-	Payload     map[string]interface{} `json:"payload"`
-	Timestamp   time.Time              `json:"timestamp"`
+	ID             uuid.UUID              `json:"id"`
+	DeviceID       uuid.UUID              `json:"device_id"`
+	Type           DeviceCommandType      `json:"type"`
+	Command        string                 `json:"command"`
+	CommandCode    string                 `json:"command_code"`
+	Payload        map[string]interface{} `json:"payload"`
+	Timestamp      time.Time              `json:"timestamp"`
+	CorrelationID  uuid.UUID              `json:"correlation_id,omitempty"`  // set on device replies (ack, response, error) to the command they answer
+	IdempotencyKey string                 `json:"idempotency_key,omitempty"` // same on every attempt of a command, devices drop the ones they already ran
+	Attempt        int                    `json:"attempt,omitempty"`         // 1 on the first delivery, higher on retries
+	SchemaVersion  int                    `json:"schema_version,omitempty"`  // version of the command schema the payload follows, the negotiated one when missing
 }
 
 const (
@@ -36,9 +42,18 @@ const (
 	EventTypeCommandProcessed = "command_processed"
 	EventTypeCommandFailed    = "command_failed"
 
+	// Lifecycle events of commands sent to devices, correlated to the command by CorrelationID
+	EventTypeCommandDelivered    = "command_delivered"
+	EventTypeCommandAcknowledged = "command_acknowledged"
+	EventTypeCommandCompleted    = "command_completed"
+
 	// State events
 	EventTypeStateChanged = "state_changed"
 
+	// Hot reloads of the command schema file, the payload has the changes and the error of a rejected one
+	EventTypeCommandSchemaReloaded = "command_schema_reloaded"
+	EventTypeCommandSchemaRejected = "command_schema_rejected"
+
 	// Error events
 	EventTypeError = "error"
 )
@@ -68,3 +83,107 @@ func NewDeviceErrorEvent(commandID, deviceID uuid.UUID, commandCode, message, er
 		},
 	}
 }
+
+type CommandStatus string
+
+const (
+	CommandStatusQueued       CommandStatus = "queued"       // stored, not published yet
+	CommandStatusSent         CommandStatus = "sent"         // published to the device's outbound subject
+	CommandStatusDelivered    CommandStatus = "delivered"    // written to the device's session
+	CommandStatusAcknowledged CommandStatus = "acknowledged" // the device accepted it
+	CommandStatusCompleted    CommandStatus = "completed"    // the device answered with a response
+	CommandStatusFailed       CommandStatus = "failed"       // publishing failed or the device answered with an error
+	CommandStatusExpired      CommandStatus = "expired"      // not finished before ExpiresAt
+)
+
+func IsValidCommandStatus(inputStr string) bool {
+	switch CommandStatus(inputStr) {
+	case CommandStatusQueued, CommandStatusSent, CommandStatusDelivered, CommandStatusAcknowledged,
+		CommandStatusCompleted, CommandStatusFailed, CommandStatusExpired:
+		return true
+	default:
+		return false
+	}
+}
+
+// commandStatusStage orders the statuses, a command only moves to a later stage. Events can arrive out of order,
+// e.g. the device's ack before the delivery report.
+var commandStatusStage = map[CommandStatus]int{
+	CommandStatusQueued:       0,
+	CommandStatusSent:         1,
+	CommandStatusDelivered:    2,
+	CommandStatusAcknowledged: 3,
+	CommandStatusCompleted:    4,
+	CommandStatusFailed:       4,
+	CommandStatusExpired:      4,
+}
+
+// UnfinishedCommandStatuses are the statuses a command can still leave.
+var UnfinishedCommandStatuses = []CommandStatus{CommandStatusQueued, CommandStatusSent, CommandStatusDelivered, CommandStatusAcknowledged}
+
+// Command is a command sent to a device through the API and the history of its lifecycle. The ID is the id of the
+// published DeviceCommand, device replies and lifecycle events refer to it as their correlation id.
+type Command struct {
+	ID                uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	DeviceID          uuid.UUID      `gorm:"type:uuid;not null;index:idx_command_device_created;uniqueIndex:idx_command_idempotency" json:"device_id"`
+	IdempotencyKey    *string        `gorm:"type:varchar(255);uniqueIndex:idx_command_idempotency" json:"idempotency_key,omitempty"` // set by the client, unique per device
+	CommandCode       string         `gorm:"type:varchar(100);not null;index" json:"command_code"`
+	Command           string         `gorm:"type:varchar(100);not null" json:"command"`
+	SchemaVersion     int            `gorm:"not null;default:1" json:"schema_version"` // version of the command schema the payload follows, the device's one
+	Payload           datatypes.JSON `gorm:"type:jsonb" json:"payload"`
+	Status            CommandStatus  `gorm:"type:varchar(20);not null;index" json:"status"`
+	IssuedBy          *uuid.UUID     `gorm:"type:uuid" json:"issued_by"`
+	ScheduleID        *uuid.UUID     `gorm:"type:uuid;index" json:"schedule_id,omitempty"` // schedule that sent the command
+	CampaignID        *uuid.UUID     `gorm:"type:uuid;index" json:"campaign_id,omitempty"` // campaign that sent the command
+	Result            datatypes.JSON `gorm:"type:jsonb" json:"result,omitempty"`           // payload of the device's response or error
+	Error             string         `gorm:"type:text" json:"error,omitempty"`
+	ExpiresAt         time.Time      `gorm:"not null;index" json:"expires_at"`
+	AckTimeoutSeconds int            `gorm:"not null;default:0" json:"ack_timeout_seconds"` // 0 publishes once without waiting for an ack
+	MaxAttempts       int            `gorm:"not null;default:1" json:"max_attempts"`
+	Attempts          int            `gorm:"not null;default:0" json:"attempts"`
+	AckDeadline       *time.Time     `gorm:"index" json:"ack_deadline,omitempty"` // the delivered command is published again once passed without an ack
+	SentAt            *time.Time     `json:"sent_at"`
+	DeliveredAt       *time.Time     `json:"delivered_at"`
+	AcknowledgedAt    *time.Time     `json:"acknowledged_at"`
+	FinishedAt        *time.Time     `json:"finished_at"` // completed, failed or expired
+	CreatedAt         time.Time      `gorm:"autoCreateTime;index:idx_command_device_created" json:"created_at"`
+	UpdatedAt         time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// IsFinished reports whether the command reached a final status.
+func (c *Command) IsFinished() bool {
+	return commandStatusStage[c.Status] == commandStatusStage[CommandStatusCompleted]
+}
+
+// Advance moves the command to the status at the given time, it reports false when the command is already past it.
+func (c *Command) Advance(status CommandStatus, at time.Time) bool {
+	if c.IsFinished() || commandStatusStage[status] <= commandStatusStage[c.Status] {
+		return false
+	}
+	c.Status = status
+	switch status {
+	case CommandStatusSent:
+		c.SentAt = &at
+	case CommandStatusDelivered:
+		c.DeliveredAt = &at
+	case CommandStatusAcknowledged:
+		c.AcknowledgedAt = &at
+		c.AckDeadline = nil
+	case CommandStatusCompleted, CommandStatusFailed, CommandStatusExpired:
+		c.FinishedAt = &at
+		c.AckDeadline = nil
+	}
+	return true
+}
+
+// AckWait is how long the current attempt waits for the ack, the ack timeout doubles with every retry up to maxWait.
+func (c *Command) AckWait(maxWait time.Duration) time.Duration {
+	wait := time.Duration(c.AckTimeoutSeconds) * time.Second
+	for i := 1; i < c.Attempts && wait < maxWait; i++ {
+		wait *= 2
+	}
+	if maxWait > 0 && wait > maxWait {
+		wait = maxWait
+	}
+	return wait
+}
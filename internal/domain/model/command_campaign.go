@@ -0,0 +1,185 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/datatypes"
+)
+
+type CampaignStatus string
+
+const (
+	CampaignStatusRunning   CampaignStatus = "running"   // waves are sent as the previous one finishes
+	CampaignStatusPaused    CampaignStatus = "paused"    // by hand or because a wave failed too often
+	CampaignStatusCompleted CampaignStatus = "completed" // every wave finished
+	CampaignStatusAborted   CampaignStatus = "aborted"   // stopped for good, unsent devices never get the command
+)
+
+func IsValidCampaignStatus(inputStr string) bool {
+	switch CampaignStatus(inputStr) {
+	case CampaignStatusRunning, CampaignStatusPaused, CampaignStatusCompleted, CampaignStatusAborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// CommandCampaign sends one command to a fleet of devices in waves. The devices are selected once at creation,
+// either as an explicit list or as the devices having all of the tags and capabilities and one of the statuses.
+// Each wave is sent once the previous one finished, a wave failing for more than MaxFailurePercent of its devices
+// pauses the campaign.
+type CommandCampaign struct {
+	ID                  uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Name                string         `gorm:"type:varchar(255);not null" json:"name"`
+	Description         string         `gorm:"type:text" json:"description"`
+	CommandCode         string         `gorm:"type:varchar(100);not null" json:"command_code"`
+	Payload             datatypes.JSON `gorm:"type:jsonb" json:"payload"`
+	TTLSeconds          int            `gorm:"not null;default:0" json:"ttl_seconds"` // 0 uses command.default_ttl
+	DeviceIDs           pq.StringArray `gorm:"type:text[]" json:"device_ids,omitempty"`
+	Tags                pq.StringArray `gorm:"type:text[]" json:"tags,omitempty"`
+	Capabilities        pq.StringArray `gorm:"type:text[]" json:"capabilities,omitempty"`
+	Statuses            pq.StringArray `gorm:"type:text[]" json:"statuses,omitempty"`
+	WavePercents        pq.Int64Array  `gorm:"type:integer[]" json:"wave_percents,omitempty"`   // cumulative share of the devices covered after each wave, e.g. 1, 10, 100
+	BatchSize           int            `gorm:"not null;default:0" json:"batch_size,omitempty"`  // devices per wave, instead of percentages
+	WaveIntervalSeconds int            `gorm:"not null;default:0" json:"wave_interval_seconds"` // pause between a finished wave and the next one
+	MaxFailurePercent   int            `gorm:"not null;default:10" json:"max_failure_percent"`
+	Status              CampaignStatus `gorm:"type:varchar(20);not null;index" json:"status"`
+	StatusReason        string         `gorm:"type:text" json:"status_reason,omitempty"`
+	TotalDevices        int            `gorm:"not null;default:0" json:"total_devices"`
+	CurrentWave         int            `gorm:"not null;default:0" json:"current_wave"` // 0 until the first wave is sent
+	CreatedBy           *uuid.UUID     `gorm:"type:uuid" json:"created_by"`
+	FinishedAt          *time.Time     `json:"finished_at,omitempty"`
+	CreatedAt           time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt           time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+
+	Waves []*CommandCampaignWave `gorm:"foreignKey:CampaignID;constraint:OnDelete:CASCADE" json:"waves,omitempty"`
+}
+
+func (c *CommandCampaign) Validate() error {
+	if len(c.DeviceIDs) > 0 {
+		if len(c.Tags) > 0 || len(c.Capabilities) > 0 || len(c.Statuses) > 0 {
+			return fmt.Errorf("device_ids can't be combined with tags, capabilities or statuses")
+		}
+		for _, id := range c.DeviceIDs {
+			if _, err := uuid.Parse(id); err != nil {
+				return fmt.Errorf("device id %q is not a uuid", id)
+			}
+		}
+	} else if len(c.Tags) == 0 && len(c.Capabilities) == 0 && len(c.Statuses) == 0 {
+		return fmt.Errorf("campaign needs device_ids, tags, capabilities or statuses")
+	}
+	for _, status := range c.Statuses {
+		if !IsValidDeviceStatus(status) {
+			return fmt.Errorf("unknown device status %q", status)
+		}
+	}
+
+	if len(c.WavePercents) > 0 && c.BatchSize > 0 {
+		return fmt.Errorf("set either wave_percents or batch_size")
+	}
+	for i, percent := range c.WavePercents {
+		if percent <= 0 || percent > 100 {
+			return fmt.Errorf("wave %d: percent must be between 1 and 100", i+1)
+		}
+		if i > 0 && percent <= c.WavePercents[i-1] {
+			return fmt.Errorf("wave %d: percent must be higher than the previous wave", i+1)
+		}
+	}
+	if c.BatchSize < 0 {
+		return fmt.Errorf("batch_size must not be negative")
+	}
+	if c.MaxFailurePercent < 0 || c.MaxFailurePercent > 100 {
+		return fmt.Errorf("max_failure_percent must be between 0 and 100")
+	}
+	if c.WaveIntervalSeconds < 0 || c.TTLSeconds < 0 {
+		return fmt.Errorf("wave_interval_seconds and ttl_seconds must not be negative")
+	}
+	return nil
+}
+
+// Selects reports whether the device matches the tag, capability and status filters of the campaign.
+func (c *CommandCampaign) Selects(device *Device) bool {
+	for _, tag := range c.Tags {
+		if !slices.Contains(device.Tags, tag) {
+			return false
+		}
+	}
+	for _, capability := range c.Capabilities {
+		if !slices.Contains(device.Capabilities, capability) {
+			return false
+		}
+	}
+	return len(c.Statuses) == 0 || slices.Contains(c.Statuses, string(device.Status))
+}
+
+// PlanWaves splits the devices of the campaign into wave sizes. Percentages that leave devices over get a final
+// wave for the rest, without percentages or batch size every device is in one wave.
+func (c *CommandCampaign) PlanWaves(total int) []int {
+	var sizes []int
+	covered := 0
+	switch {
+	case len(c.WavePercents) > 0:
+		for _, percent := range c.WavePercents {
+			upTo := (total*int(percent) + 99) / 100
+			if upTo > covered {
+				sizes = append(sizes, upTo-covered)
+				covered = upTo
+			}
+		}
+	case c.BatchSize > 0:
+		for covered+c.BatchSize < total {
+			sizes = append(sizes, c.BatchSize)
+			covered += c.BatchSize
+		}
+	}
+	if covered < total {
+		sizes = append(sizes, total-covered)
+	}
+	return sizes
+}
+
+// FailureRateExceeded reports whether failed out of the devices of a wave is more than the campaign tolerates.
+func (c *CommandCampaign) FailureRateExceeded(failed int, waveSize int) bool {
+	return waveSize > 0 && failed*100 > c.MaxFailurePercent*waveSize
+}
+
+// CommandCampaignWave is one stage of a campaign, its devices are the targets of the same wave number.
+type CommandCampaignWave struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+	CampaignID      uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_campaign_wave" json:"-"`
+	Number          int        `gorm:"not null;uniqueIndex:idx_campaign_wave" json:"number"` // starting at 1
+	Size            int        `gorm:"not null" json:"size"`
+	FailureAccepted bool       `gorm:"not null;default:false" json:"failure_accepted"` // resumed despite its failures
+	StartedAt       *time.Time `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at"` // every command of the wave finished
+
+	Progress *CampaignWaveProgress `gorm:"-" json:"progress,omitempty"`
+}
+
+// CommandCampaignTarget is a device of a campaign and the command it was sent.
+type CommandCampaignTarget struct {
+	CampaignID uuid.UUID  `gorm:"type:uuid;primaryKey;index:idx_campaign_target_wave" json:"campaign_id"`
+	DeviceID   uuid.UUID  `gorm:"type:uuid;primaryKey" json:"device_id"`
+	Wave       int        `gorm:"not null;index:idx_campaign_target_wave" json:"wave"`
+	CommandID  *uuid.UUID `gorm:"type:uuid" json:"command_id,omitempty"`
+	Error      string     `gorm:"type:text;not null;default:''" json:"error,omitempty"` // why the command couldn't be sent
+}
+
+// CampaignWaveProgress counts the devices of a wave by the state of their command, failed includes commands that
+// expired or couldn't be sent.
+type CampaignWaveProgress struct {
+	Wave      int `json:"-"`
+	Total     int `json:"total"`
+	Unsent    int `json:"unsent"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// Pending is the number of devices whose command hasn't finished yet, sent or not.
+func (p *CampaignWaveProgress) Pending() int {
+	return p.Total - p.Completed - p.Failed
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/pkg/cron"
+	"gorm.io/datatypes"
+)
+
+type CommandTargetType string
+
+const (
+	CommandTargetDevice CommandTargetType = "device"
+	CommandTargetTag    CommandTargetType = "tag"
+	CommandTargetSite   CommandTargetType = "site" // devices grouped by their metadata site
+)
+
+// CommandTarget selects the devices a command is sent to when it doesn't address a single device directly.
+type CommandTarget struct {
+	TargetType CommandTargetType `gorm:"type:varchar(20);not null" json:"target_type"`
+	DeviceID   *uuid.UUID        `gorm:"type:uuid;index" json:"device_id,omitempty"` // device target
+	Tag        string            `gorm:"type:varchar(255)" json:"tag,omitempty"`     // tag target
+	Site       string            `gorm:"type:varchar(255)" json:"site,omitempty"`    // site target
+}
+
+func (t *CommandTarget) Validate() error {
+	switch t.TargetType {
+	case CommandTargetDevice:
+		if t.DeviceID == nil {
+			return fmt.Errorf("device target needs a device_id")
+		}
+	case CommandTargetTag:
+		if t.Tag == "" {
+			return fmt.Errorf("tag target needs a tag")
+		}
+	case CommandTargetSite:
+		if t.Site == "" {
+			return fmt.Errorf("site target needs a site")
+		}
+	default:
+		return fmt.Errorf("unknown target type %q", t.TargetType)
+	}
+	return nil
+}
+
+// Covers reports whether the device is selected by the target.
+func (t *CommandTarget) Covers(device *Device) bool {
+	switch t.TargetType {
+	case CommandTargetDevice:
+		return t.DeviceID != nil && *t.DeviceID == device.ID
+	case CommandTargetTag:
+		return slices.Contains(device.Tags, t.Tag)
+	case CommandTargetSite:
+		return DeviceSite(device) == t.Site
+	}
+	return false
+}
+
+// CommandSchedule sends a command to the devices of its target once at RunAt or on every activation of its cron
+// expression, evaluated in Timezone. Every command it sends links back to it through Command.ScheduleID.
+type CommandSchedule struct {
+	ID          uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Name        string         `gorm:"type:varchar(255);not null" json:"name"`
+	Description string         `gorm:"type:text" json:"description"`
+	Target      CommandTarget  `gorm:"embedded" json:"target"`
+	CommandCode string         `gorm:"type:varchar(100);not null" json:"command_code"`
+	Payload     datatypes.JSON `gorm:"type:jsonb" json:"payload"`
+	TTLSeconds  int            `gorm:"not null;default:0" json:"ttl_seconds"`   // 0 uses command.default_ttl
+	RunAt       *time.Time     `json:"run_at,omitempty"`                        // one-off schedule
+	Cron        string         `gorm:"type:varchar(100)" json:"cron,omitempty"` // recurring schedule
+	Timezone    string         `gorm:"type:varchar(64);not null;default:'UTC'" json:"timezone"`
+	Enabled     bool           `gorm:"not null;default:true" json:"enabled"`
+	NextRunAt   *time.Time     `gorm:"index" json:"next_run_at"` // nil once a one-off schedule ran
+	LastRunAt   *time.Time     `json:"last_run_at"`
+	LastError   string         `gorm:"type:text" json:"last_error,omitempty"`
+	CreatedBy   *uuid.UUID     `gorm:"type:uuid" json:"created_by"`
+	CreatedAt   time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (s *CommandSchedule) Validate() error {
+	if err := s.Target.Validate(); err != nil {
+		return err
+	}
+	if (s.RunAt == nil) == (s.Cron == "") {
+		return fmt.Errorf("schedule needs either run_at or cron")
+	}
+	if s.Cron != "" {
+		if _, err := cron.Parse(s.Cron); err != nil {
+			return err
+		}
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+	if s.TTLSeconds < 0 {
+		return fmt.Errorf("ttl_seconds must not be negative")
+	}
+	return nil
+}
+
+// NextRunAfter returns the first run of the schedule after t, nil when it won't run anymore.
+func (s *CommandSchedule) NextRunAfter(t time.Time) *time.Time {
+	if s.RunAt != nil {
+		if s.RunAt.After(t) {
+			runAt := *s.RunAt
+			return &runAt
+		}
+		return nil
+	}
+	schedule, err := cron.Parse(s.Cron)
+	if err != nil {
+		return nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil
+	}
+	next := schedule.Next(t.In(loc))
+	if next.IsZero() {
+		return nil
+	}
+	next = next.UTC()
+	return &next
+}
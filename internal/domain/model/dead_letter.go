@@ -0,0 +1,59 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DeadLetterStatus string
+
+const (
+	DeadLetterStatusPending   DeadLetterStatus = "pending"   // waiting for an operator to fix, re-drive or discard it
+	DeadLetterStatusRedriven  DeadLetterStatus = "redriven"  // published again, back to pending when it fails again
+	DeadLetterStatusDiscarded DeadLetterStatus = "discarded" // given up on by an operator
+)
+
+func IsValidDeadLetterStatus(inputStr string) bool {
+	switch DeadLetterStatus(inputStr) {
+	case DeadLetterStatusPending, DeadLetterStatusRedriven, DeadLetterStatusDiscarded:
+		return true
+	default:
+		return false
+	}
+}
+
+// DeadLetter keeps a message the command processor or the command status worker couldn't handle, as it was
+// received, so it can be fixed and re-driven to the subject it came from.
+type DeadLetter struct {
+	ID            uuid.UUID        `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Subject       string           `gorm:"type:varchar(255);not null;index" json:"subject"` // nats subject the message came from and is re-driven to
+	Fingerprint   string           `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"`  // sha256 of subject and message, the same message failing again counts as another attempt
+	Message       string           `gorm:"type:text;not null" json:"message"`               // raw message, not necessarily valid json
+	DeviceID      *uuid.UUID       `gorm:"type:uuid;index" json:"device_id,omitempty"`      // when the message could be decoded
+	CommandCode   string           `gorm:"type:varchar(100);index" json:"command_code"`     // "unknown" when the message couldn't be decoded
+	ErrorCode     string           `gorm:"type:varchar(100)" json:"error_code"`             // of the last failure
+	Error         string           `gorm:"type:text" json:"error"`                          // of the last failure
+	Attempts      int              `gorm:"not null;default:1" json:"attempts"`              // failures of the message, re-drives included
+	Status        DeadLetterStatus `gorm:"type:varchar(20);not null;default:'pending';index" json:"status"`
+	FirstFailedAt time.Time        `gorm:"not null" json:"first_failed_at"`
+	LastFailedAt  time.Time        `gorm:"not null;index" json:"last_failed_at"`
+	EditedAt      *time.Time       `json:"edited_at,omitempty"`
+	RedrivenAt    *time.Time       `json:"redriven_at,omitempty"`
+	ResolvedAt    *time.Time       `gorm:"index" json:"resolved_at,omitempty"` // re-driven or discarded, retention of resolved dead letters counts from here
+	CreatedAt     time.Time        `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time        `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// DeadLetterCommandCodeUnknown is the command code of dead letters that couldn't be decoded.
+const DeadLetterCommandCodeUnknown = "unknown"
+
+// DeadLetterVolume counts the dead letters of a command code, subject and status.
+type DeadLetterVolume struct {
+	CommandCode  string           `json:"command_code"`
+	Subject      string           `json:"subject"`
+	Status       DeadLetterStatus `json:"status"`
+	Count        int64            `json:"count"`
+	Attempts     int64            `json:"attempts"` // failures of these dead letters, re-drives included
+	LastFailedAt time.Time        `json:"last_failed_at"`
+}
@@ -33,6 +33,14 @@ type Device struct {
 	UpdatedAt       time.Time           `json:"updated_at" gorm:"autoUpdateTime"`
 	DeletedAt       gorm.DeletedAt      `json:"-" gorm:"index"`
 	Sensors         []Sensor            `json:"sensors" gorm:"foreignKey:DeviceID;constraints:OnDelete:CASCADE,OnUpdate:CASCADE"`
+
+	// schema version of each command negotiated at device@init, the latest one for commands missing
+	CommandVersions datatypes.JSONType[map[string]int] `json:"command_versions" gorm:"type:jsonb;not null;default:'{}'"`
+}
+
+// CommandVersion is the schema version the device speaks for the command, 0 for the latest.
+func (d *Device) CommandVersion(commandCode string) int {
+	return d.CommandVersions.Data()[commandCode]
 }
 
 func (d *Device) PublicView() *Device {
@@ -139,6 +147,18 @@ var (
 	DeviceStatusSuspended        DeviceStatus = "suspended"
 )
 
+// DeviceStatuses are all the lifecycle statuses of a device.
+var DeviceStatuses = []DeviceStatus{
+	DeviceStatusPendingProvision,
+	DeviceStatusProvisioned,
+	DeviceStatusDecommissioned,
+	DeviceStatusOnline,
+	DeviceStatusOffline,
+	DeviceStatusFaulty,
+	DeviceStatusUnderMaintenance,
+	DeviceStatusSuspended,
+}
+
 func IsValidDeviceStatus(inputStr string) bool {
 	switch DeviceStatus(inputStr) {
 	case
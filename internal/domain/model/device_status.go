@@ -0,0 +1,51 @@
+package model
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// deviceStatusTransitions are the statuses a device can move to from each status. A decommissioned device stays
+// decommissioned, a suspended one is reinstated offline and goes online once it connects again.
+var deviceStatusTransitions = map[DeviceStatus][]DeviceStatus{
+	DeviceStatusPendingProvision: {DeviceStatusProvisioned, DeviceStatusDecommissioned},
+	DeviceStatusProvisioned:      {DeviceStatusOnline, DeviceStatusOffline, DeviceStatusFaulty, DeviceStatusUnderMaintenance, DeviceStatusSuspended, DeviceStatusDecommissioned},
+	DeviceStatusOnline:           {DeviceStatusOffline, DeviceStatusFaulty, DeviceStatusUnderMaintenance, DeviceStatusSuspended, DeviceStatusDecommissioned},
+	DeviceStatusOffline:          {DeviceStatusOnline, DeviceStatusFaulty, DeviceStatusUnderMaintenance, DeviceStatusSuspended, DeviceStatusDecommissioned},
+	DeviceStatusFaulty:           {DeviceStatusOnline, DeviceStatusOffline, DeviceStatusUnderMaintenance, DeviceStatusSuspended, DeviceStatusDecommissioned},
+	DeviceStatusUnderMaintenance: {DeviceStatusProvisioned, DeviceStatusOnline, DeviceStatusOffline, DeviceStatusFaulty, DeviceStatusSuspended, DeviceStatusDecommissioned},
+	DeviceStatusSuspended:        {DeviceStatusOffline, DeviceStatusUnderMaintenance, DeviceStatusDecommissioned},
+	DeviceStatusDecommissioned:   {},
+}
+
+// NextDeviceStatuses are the statuses a device in the status can move to.
+func NextDeviceStatuses(from DeviceStatus) []DeviceStatus {
+	return slices.Clone(deviceStatusTransitions[from])
+}
+
+func CanTransitionDeviceStatus(from, to DeviceStatus) bool {
+	return slices.Contains(deviceStatusTransitions[from], to)
+}
+
+type DeviceStatusActor string
+
+const (
+	DeviceStatusActorUser   DeviceStatusActor = "user"   // through the api, ActorID is the user
+	DeviceStatusActorDevice DeviceStatusActor = "device" // reported by the device itself
+	DeviceStatusActorSystem DeviceStatusActor = "system" // maintenance windows and other background work
+)
+
+// DeviceStatusChange is a transition in the status history of a device.
+type DeviceStatusChange struct {
+	ID         uuid.UUID         `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	DeviceID   uuid.UUID         `gorm:"type:uuid;not null;index:idx_device_status_changes_device,priority:1" json:"device_id"`
+	FromStatus DeviceStatus      `gorm:"type:varchar(20);not null" json:"from_status"`
+	ToStatus   DeviceStatus      `gorm:"type:varchar(20);not null" json:"to_status"`
+	Reason     string            `gorm:"type:text;not null;default:''" json:"reason"`
+	ActorType  DeviceStatusActor `gorm:"type:varchar(20);not null" json:"actor_type"`
+	ActorID    *uuid.UUID        `gorm:"type:uuid" json:"actor_id,omitempty"`
+	Source     string            `gorm:"type:varchar(100);not null;default:''" json:"source"` // api, provision, maintenance or the command code the device sent
+	ChangedAt  time.Time         `gorm:"not null;index:idx_device_status_changes_device,priority:2" json:"changed_at"`
+}
@@ -0,0 +1,176 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/datatypes"
+)
+
+const maxEscalationTiers = 10
+
+// OnCallSchedule rotates its members, each one is on call for ShiftHours in turn starting at RotationStart.
+// Overrides take precedence over the rotation.
+type OnCallSchedule struct {
+	ID            uuid.UUID        `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Name          string           `gorm:"type:varchar(255);not null" json:"name"`
+	Description   string           `gorm:"type:text" json:"description"`
+	Members       pq.StringArray   `gorm:"type:text[];not null" json:"members"` // user ids in rotation order
+	RotationStart time.Time        `gorm:"not null" json:"rotation_start"`
+	ShiftHours    int              `gorm:"not null;default:168" json:"shift_hours"`
+	Overrides     []OnCallOverride `gorm:"foreignKey:ScheduleID;constraint:OnDelete:CASCADE" json:"overrides,omitempty"`
+	CreatedAt     time.Time        `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time        `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// OnCallOverride puts a user on call instead of the rotation, e.g. to cover a holiday.
+type OnCallOverride struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	ScheduleID uuid.UUID `gorm:"type:uuid;not null;index" json:"schedule_id"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null" json:"user_id"`
+	StartsAt   time.Time `gorm:"not null" json:"starts_at"`
+	EndsAt     time.Time `gorm:"not null" json:"ends_at"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (s *OnCallSchedule) Validate() error {
+	if len(s.Members) == 0 {
+		return fmt.Errorf("schedule needs at least one member")
+	}
+	for _, member := range s.Members {
+		if _, err := uuid.Parse(member); err != nil {
+			return fmt.Errorf("member %q is not a user id", member)
+		}
+	}
+	if s.ShiftHours <= 0 {
+		return fmt.Errorf("shift_hours must be positive")
+	}
+	return nil
+}
+
+// OnCallAt returns the user on call at t, the latest created override covering t wins over the rotation.
+func (s *OnCallSchedule) OnCallAt(t time.Time) (uuid.UUID, bool) {
+	var override *OnCallOverride
+	for i := range s.Overrides {
+		o := &s.Overrides[i]
+		if t.Before(o.StartsAt) || !t.Before(o.EndsAt) {
+			continue
+		}
+		if override == nil || o.CreatedAt.After(override.CreatedAt) {
+			override = o
+		}
+	}
+	if override != nil {
+		return override.UserID, true
+	}
+
+	if len(s.Members) == 0 || s.ShiftHours <= 0 {
+		return uuid.Nil, false
+	}
+	shift := time.Duration(s.ShiftHours) * time.Hour
+	elapsed := t.Sub(s.RotationStart)
+	index := int64(elapsed / shift)
+	if elapsed < 0 {
+		index-- // shifts before the rotation start run backwards
+	}
+	n := int64(len(s.Members))
+	userID, err := uuid.Parse(s.Members[((index%n)+n)%n])
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// EscalationTier is notified once the alert has been unacknowledged for AfterMinutes, the first tier usually at 0.
+type EscalationTier struct {
+	AfterMinutes int         `json:"after_minutes"`
+	ScheduleIDs  []uuid.UUID `json:"schedule_ids,omitempty"` // whoever is on call
+	UserIDs      []uuid.UUID `json:"user_ids,omitempty"`
+}
+
+// EscalationPolicy applies to the alerts matching all of its filters, empty filters match everything. Among the
+// matching enabled policies the highest priority one is used.
+type EscalationPolicy struct {
+	ID          uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Name        string         `gorm:"type:varchar(255);not null" json:"name"`
+	Description string         `gorm:"type:text" json:"description"`
+	DeviceID    *uuid.UUID     `gorm:"type:uuid;index" json:"device_id,omitempty"`
+	Tag         string         `gorm:"type:varchar(255)" json:"tag,omitempty"`
+	Severity    AlertSeverity  `gorm:"type:varchar(20)" json:"severity,omitempty"`
+	Priority    int            `gorm:"not null;default:0" json:"priority"`
+	Tiers       datatypes.JSON `gorm:"type:jsonb;not null" json:"tiers"` // []EscalationTier
+	Enabled     bool           `gorm:"not null;default:true" json:"enabled"`
+	CreatedAt   time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (p *EscalationPolicy) ParseTiers() ([]EscalationTier, error) {
+	var tiers []EscalationTier
+	if err := json.Unmarshal(p.Tiers, &tiers); err != nil {
+		return nil, fmt.Errorf("invalid escalation tiers: %w", err)
+	}
+	if err := ValidateEscalationTiers(tiers); err != nil {
+		return nil, err
+	}
+	return tiers, nil
+}
+
+func ValidateEscalationTiers(tiers []EscalationTier) error {
+	if len(tiers) == 0 {
+		return fmt.Errorf("policy needs at least one tier")
+	}
+	if len(tiers) > maxEscalationTiers {
+		return fmt.Errorf("policy has more than %d tiers", maxEscalationTiers)
+	}
+	for i, tier := range tiers {
+		if len(tier.ScheduleIDs) == 0 && len(tier.UserIDs) == 0 {
+			return fmt.Errorf("tier %d: needs a schedule or a user", i)
+		}
+		if tier.AfterMinutes < 0 {
+			return fmt.Errorf("tier %d: after_minutes must not be negative", i)
+		}
+		if i > 0 && tier.AfterMinutes <= tiers[i-1].AfterMinutes {
+			return fmt.Errorf("tier %d: after_minutes must be later than the previous tier", i)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the policy covers an alert of the device.
+func (p *EscalationPolicy) Matches(alert *Alert, device *Device) bool {
+	if p.DeviceID != nil && *p.DeviceID != alert.DeviceID {
+		return false
+	}
+	if p.Severity != "" && p.Severity != alert.Severity {
+		return false
+	}
+	if p.Tag != "" && (device == nil || !slices.Contains(device.Tags, p.Tag)) {
+		return false
+	}
+	return true
+}
+
+type EscalationStatus string
+
+const (
+	EscalationStatusPending   EscalationStatus = "pending"   // tiers left to notify
+	EscalationStatusCompleted EscalationStatus = "completed" // every tier notified
+	EscalationStatusCancelled EscalationStatus = "cancelled" // alert acknowledged or resolved
+)
+
+// AlertEscalation is the durable escalation schedule of one alert, the escalation worker notifies NextTier once
+// NextAt passed.
+type AlertEscalation struct {
+	AlertID        uuid.UUID        `gorm:"type:uuid;primaryKey" json:"alert_id"`
+	PolicyID       uuid.UUID        `gorm:"type:uuid;not null;index" json:"policy_id"`
+	Status         EscalationStatus `gorm:"type:varchar(20);not null;index" json:"status"`
+	NextTier       int              `gorm:"not null;default:0" json:"next_tier"`
+	NextAt         time.Time        `gorm:"not null;index" json:"next_at"`
+	StartedAt      time.Time        `gorm:"not null" json:"started_at"`
+	LastNotifiedAt *time.Time       `json:"last_notified_at,omitempty"`
+	UpdatedAt      time.Time        `gorm:"autoUpdateTime" json:"updated_at"`
+}
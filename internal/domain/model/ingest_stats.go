@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// DeviceIngestStats is the periodic Postgres rollup of the live ingest counters kept in redis,
+// one row per device and sensor (sensor_key is "unknown" for payloads that named no valid sensor).
+// Every rollup adds the counts redis gathered since the previous one, so the totals outlive a redis flush.
+type DeviceIngestStats struct {
+	DeviceID          uuid.UUID      `gorm:"type:uuid;primaryKey" json:"device_id"`
+	SensorKey         string         `gorm:"type:varchar(64);primaryKey" json:"sensor_key"`
+	Received          int64          `gorm:"not null;default:0" json:"received"`
+	Accepted          int64          `gorm:"not null;default:0" json:"accepted"`
+	Rejected          datatypes.JSON `gorm:"type:jsonb" json:"rejected"` // rejection reason -> count
+	Bytes             int64          `gorm:"not null;default:0" json:"bytes"`
+	FirstMessageAt    *time.Time     `json:"first_message_at"`
+	LastMessageAt     *time.Time     `json:"last_message_at"`
+	AvgInterArrivalMs float64        `gorm:"not null;default:0" json:"avg_inter_arrival_ms"`
+	InterArrivalSumMs int64          `gorm:"not null;default:0" json:"-"` // the average is recomputed from these on every rollup
+	InterArrivalCount int64          `gorm:"not null;default:0" json:"-"`
+	RolledUpAt        time.Time      `gorm:"autoUpdateTime" json:"rolled_up_at"`
+}
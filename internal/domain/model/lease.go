@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// Lease is held by one instance of a service at a time, e.g. the command scheduler that several command publishers
+// run. The holder renews it before it expires, another instance takes it over once it did.
+type Lease struct {
+	Name      string    `gorm:"type:varchar(100);primaryKey" json:"name"`
+	Holder    string    `gorm:"type:varchar(255);not null" json:"holder"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
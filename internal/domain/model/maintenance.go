@@ -0,0 +1,177 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MaintenanceScope string
+
+const (
+	MaintenanceScopeDevice MaintenanceScope = "device" // a single device
+	MaintenanceScopeTag    MaintenanceScope = "tag"    // every device with the tag
+	MaintenanceScopeSite   MaintenanceScope = "site"   // every device whose metadata "site" value matches
+)
+
+type MaintenanceRecurrence string
+
+const (
+	MaintenanceRecurrenceNone   MaintenanceRecurrence = "none" // one-off
+	MaintenanceRecurrenceDaily  MaintenanceRecurrence = "daily"
+	MaintenanceRecurrenceWeekly MaintenanceRecurrence = "weekly"
+)
+
+// DeviceMetadataSiteKey is the device metadata key site scoped maintenance windows match on.
+const DeviceMetadataSiteKey = "site"
+
+// MaintenanceWindow suppresses alerts of the devices in scope while it is active and marks the telemetry they
+// send. StartsAt and EndsAt are the first occurrence, recurring windows repeat it every day or week until RecurUntil
+// at the same wall clock time in Timezone, across daylight saving changes.
+type MaintenanceWindow struct {
+	ID              uuid.UUID             `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Name            string                `gorm:"type:varchar(255);not null" json:"name"`
+	Description     string                `gorm:"type:text" json:"description"`
+	Scope           MaintenanceScope      `gorm:"type:varchar(20);not null;index" json:"scope"`
+	DeviceID        *uuid.UUID            `gorm:"type:uuid;index" json:"device_id,omitempty"` // device scope
+	Tag             string                `gorm:"type:varchar(255)" json:"tag,omitempty"`     // tag scope
+	Site            string                `gorm:"type:varchar(255)" json:"site,omitempty"`    // site scope
+	StartsAt        time.Time             `gorm:"not null;index" json:"starts_at"`
+	EndsAt          time.Time             `gorm:"not null" json:"ends_at"`
+	Recurrence      MaintenanceRecurrence `gorm:"type:varchar(20);not null;default:'none'" json:"recurrence"`
+	RecurUntil      *time.Time            `json:"recur_until,omitempty"`                                   // recurring forever when nil
+	Timezone        string                `gorm:"type:varchar(64);not null;default:'UTC'" json:"timezone"` // IANA name, occurrences repeat at the wall clock time of StartsAt there
+	SetDeviceStatus bool                  `gorm:"not null;default:false" json:"set_device_status"`         // move devices to under_maintenance while active
+	CreatedBy       *uuid.UUID            `gorm:"type:uuid" json:"created_by"`
+	CreatedAt       time.Time             `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time             `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (w *MaintenanceWindow) Validate() error {
+	switch w.Scope {
+	case MaintenanceScopeDevice:
+		if w.DeviceID == nil {
+			return fmt.Errorf("device scope needs a device_id")
+		}
+	case MaintenanceScopeTag:
+		if w.Tag == "" {
+			return fmt.Errorf("tag scope needs a tag")
+		}
+	case MaintenanceScopeSite:
+		if w.Site == "" {
+			return fmt.Errorf("site scope needs a site")
+		}
+	default:
+		return fmt.Errorf("unknown scope %q", w.Scope)
+	}
+	if !w.EndsAt.After(w.StartsAt) {
+		return fmt.Errorf("ends_at must be after starts_at")
+	}
+	if _, err := time.LoadLocation(w.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", w.Timezone)
+	}
+	switch w.Recurrence {
+	case MaintenanceRecurrenceNone:
+	case MaintenanceRecurrenceDaily, MaintenanceRecurrenceWeekly:
+		if w.Duration() > time.Duration(w.periodDays())*24*time.Hour {
+			return fmt.Errorf("a %s window can't be longer than its period", w.Recurrence)
+		}
+		if w.RecurUntil != nil && w.RecurUntil.Before(w.EndsAt) {
+			return fmt.Errorf("recur_until must not be before ends_at")
+		}
+	default:
+		return fmt.Errorf("unknown recurrence %q", w.Recurrence)
+	}
+	return nil
+}
+
+func (w *MaintenanceWindow) Duration() time.Duration {
+	return w.EndsAt.Sub(w.StartsAt)
+}
+
+// periodDays is the number of calendar days between the occurrences of a recurring window, 0 for a one-off.
+func (w *MaintenanceWindow) periodDays() int {
+	switch w.Recurrence {
+	case MaintenanceRecurrenceDaily:
+		return 1
+	case MaintenanceRecurrenceWeekly:
+		return 7
+	}
+	return 0
+}
+
+// ActiveAt reports whether an occurrence of the window covers t.
+func (w *MaintenanceWindow) ActiveAt(t time.Time) bool {
+	if t.Before(w.StartsAt) {
+		return false
+	}
+	period := w.periodDays()
+	if period == 0 {
+		return t.Before(w.EndsAt)
+	}
+
+	// the last occurrence starting by t, stepping in calendar days keeps the wall clock time when a day has 23 or
+	// 25 hours
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start, local := w.StartsAt.In(loc), t.In(loc)
+	days := int(time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC).
+		Sub(time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)).Hours() / 24)
+	n := days / period
+	occurrence := start.AddDate(0, 0, n*period)
+	if occurrence.After(t) {
+		occurrence = start.AddDate(0, 0, (n-1)*period)
+	}
+	if w.RecurUntil != nil && occurrence.After(*w.RecurUntil) {
+		return false
+	}
+	return t.Sub(occurrence) < w.Duration()
+}
+
+// Over reports whether no occurrence of the window ends after t.
+func (w *MaintenanceWindow) Over(t time.Time) bool {
+	if w.periodDays() == 0 {
+		return !t.Before(w.EndsAt)
+	}
+	return w.RecurUntil != nil && !t.Before(w.RecurUntil.Add(w.Duration()))
+}
+
+// Covers reports whether the device is in the scope of the window.
+func (w *MaintenanceWindow) Covers(device *Device) bool {
+	switch w.Scope {
+	case MaintenanceScopeDevice:
+		return w.DeviceID != nil && *w.DeviceID == device.ID
+	case MaintenanceScopeTag:
+		return slices.Contains(device.Tags, w.Tag)
+	case MaintenanceScopeSite:
+		return DeviceSite(device) == w.Site
+	}
+	return false
+}
+
+// DeviceSite returns the site the device metadata assigns it to, empty when there is none.
+func DeviceSite(device *Device) string {
+	if len(device.Metadata) == 0 {
+		return ""
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(device.Metadata, &metadata); err != nil {
+		return ""
+	}
+	site, _ := metadata[DeviceMetadataSiteKey].(string)
+	return site
+}
+
+// DeviceMaintenance records a device a maintenance window moved to under_maintenance, and the status to restore
+// once no window holds it anymore.
+type DeviceMaintenance struct {
+	DeviceID       uuid.UUID    `gorm:"type:uuid;primaryKey" json:"device_id"`
+	WindowID       uuid.UUID    `gorm:"type:uuid;not null;index" json:"window_id"`
+	PreviousStatus DeviceStatus `gorm:"type:varchar(20);not null" json:"previous_status"`
+	StartedAt      time.Time    `gorm:"not null" json:"started_at"`
+}
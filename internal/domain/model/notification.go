@@ -0,0 +1,46 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+type NotificationStatus string
+
+const (
+	NotificationStatusPending NotificationStatus = "pending" // waiting for its first or next attempt
+	NotificationStatusSent    NotificationStatus = "sent"
+	NotificationStatusFailed  NotificationStatus = "failed" // out of attempts or rejected permanently
+)
+
+// NotificationDelivery is one notification on one channel for one recipient, it carries the retry state.
+type NotificationDelivery struct {
+	ID            uuid.UUID             `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Channel       string                `gorm:"type:varchar(50);not null;index" json:"channel"`
+	Event         string                `gorm:"type:varchar(100);not null;index" json:"event"`
+	Recipient     string                `gorm:"type:varchar(255)" json:"recipient"`
+	Subject       string                `gorm:"type:varchar(255)" json:"subject"`
+	Payload       datatypes.JSON        `gorm:"type:jsonb" json:"payload"` // the notification as it was raised
+	Status        NotificationStatus    `gorm:"type:varchar(20);not null;index:idx_notification_due" json:"status"`
+	AttemptCount  int                   `gorm:"not null;default:0" json:"attempt_count"`
+	MaxAttempts   int                   `gorm:"not null" json:"max_attempts"`
+	NextAttemptAt time.Time             `gorm:"not null;index:idx_notification_due" json:"next_attempt_at"`
+	LastError     string                `gorm:"type:text" json:"last_error,omitempty"`
+	SentAt        *time.Time            `json:"sent_at"`
+	Attempts      []NotificationAttempt `gorm:"foreignKey:DeliveryID;constraint:OnDelete:CASCADE" json:"attempts,omitempty"`
+	CreatedAt     time.Time             `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time             `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// NotificationAttempt is the delivery log, one row per send attempt.
+type NotificationAttempt struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	DeliveryID uuid.UUID `gorm:"type:uuid;not null;index" json:"delivery_id"`
+	Attempt    int       `gorm:"not null" json:"attempt"`
+	Success    bool      `json:"success"`
+	Error      string    `gorm:"type:text" json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -0,0 +1,242 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+type RuleMatch string
+
+const (
+	RuleMatchAll RuleMatch = "all" // default
+	RuleMatchAny RuleMatch = "any"
+)
+
+type RuleWindowType string
+
+const (
+	RuleWindowSliding  RuleWindowType = "sliding"  // the readings of the last duration, evaluated on every reading
+	RuleWindowTumbling RuleWindowType = "tumbling" // fixed consecutive buckets, the last completed bucket is evaluated
+)
+
+type RuleAggregate string
+
+const (
+	RuleAggregateLast  RuleAggregate = "last" // default
+	RuleAggregateAvg   RuleAggregate = "avg"
+	RuleAggregateMin   RuleAggregate = "min"
+	RuleAggregateMax   RuleAggregate = "max"
+	RuleAggregateSum   RuleAggregate = "sum"
+	RuleAggregateCount RuleAggregate = "count"
+	RuleAggregateDelta RuleAggregate = "delta" // last - first
+	RuleAggregateRate  RuleAggregate = "rate"  // delta per second
+)
+
+type RuleOperator string
+
+const (
+	RuleOperatorGt  RuleOperator = ">"
+	RuleOperatorGte RuleOperator = ">="
+	RuleOperatorLt  RuleOperator = "<"
+	RuleOperatorLte RuleOperator = "<="
+	RuleOperatorEq  RuleOperator = "=="
+	RuleOperatorNeq RuleOperator = "!="
+)
+
+type RuleActionType string
+
+const (
+	RuleActionEvent RuleActionType = "event" // publish a rule event
+	RuleActionAlert RuleActionType = "alert" // publish a rule event and raise an alert
+)
+
+const (
+	maxRuleConditions     = 16
+	maxRuleWindowDuration = 24 * time.Hour
+)
+
+// RuleDefinition is the declarative part of a rule, e.g. CO2 above 1200 ppm for 10 minutes while motion is detected:
+//
+//	{
+//	  "match": "all",
+//	  "for_seconds": 600,
+//	  "conditions": [
+//	    {"id": "co2", "sensor_code": "sensor-co2-001", "field": "1", "operator": ">", "value": 1200},
+//	    {"id": "motion", "sensor_code": "sensor-motion-001", "field": "1", "window": {"type": "sliding", "duration_seconds": 60}, "aggregate": "max", "operator": ">=", "value": 1}
+//	  ],
+//	  "action": {"type": "alert", "severity": "warning"}
+//	}
+//
+// and temperature rising more than 5 degrees in 2 minutes:
+//
+//	{"conditions": [{"id": "rise", "sensor_code": "sensor-temp-001", "field": "1", "window": {"type": "sliding", "duration_seconds": 120}, "aggregate": "delta", "operator": ">", "value": 5}], "action": {"type": "event"}}
+type RuleDefinition struct {
+	Match      RuleMatch       `json:"match,omitempty"`
+	Conditions []RuleCondition `json:"conditions"`
+	ForSeconds int             `json:"for_seconds,omitempty"` // conditions must hold this long before the rule fires
+	Action     RuleAction      `json:"action"`
+}
+
+type RuleCondition struct {
+	ID         string        `json:"id"` // unique within the rule, names the value in events
+	SensorCode string        `json:"sensor_code"`
+	Field      string        `json:"field"`
+	Window     *RuleWindow   `json:"window,omitempty"` // latest reading when omitted
+	Aggregate  RuleAggregate `json:"aggregate,omitempty"`
+	Operator   RuleOperator  `json:"operator"`
+	Value      float64       `json:"value"`
+}
+
+type RuleWindow struct {
+	Type            RuleWindowType `json:"type"`
+	DurationSeconds int            `json:"duration_seconds"`
+}
+
+type RuleAction struct {
+	Type     RuleActionType `json:"type"`
+	Severity AlertSeverity  `json:"severity,omitempty"` // alert action, defaults to warning
+	Message  string         `json:"message,omitempty"`
+}
+
+func ParseRuleDefinition(raw datatypes.JSON) (*RuleDefinition, error) {
+	var def RuleDefinition
+	if err := json.Unmarshal(raw, &def); err != nil {
+		return nil, fmt.Errorf("invalid rule definition: %w", err)
+	}
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	return &def, nil
+}
+
+func (d *RuleDefinition) Validate() error {
+	switch d.Match {
+	case "", RuleMatchAll, RuleMatchAny:
+	default:
+		return fmt.Errorf("unknown match %q", d.Match)
+	}
+	if len(d.Conditions) == 0 {
+		return fmt.Errorf("rule needs at least one condition")
+	}
+	if len(d.Conditions) > maxRuleConditions {
+		return fmt.Errorf("rule has more than %d conditions", maxRuleConditions)
+	}
+	seen := make(map[string]bool, len(d.Conditions))
+	for i, cond := range d.Conditions {
+		if cond.ID == "" {
+			return fmt.Errorf("condition %d: id is required", i)
+		}
+		if seen[cond.ID] {
+			return fmt.Errorf("condition %s: duplicate id", cond.ID)
+		}
+		seen[cond.ID] = true
+		if err := cond.Validate(); err != nil {
+			return fmt.Errorf("condition %s: %w", cond.ID, err)
+		}
+	}
+	if d.ForSeconds < 0 {
+		return fmt.Errorf("for_seconds must not be negative")
+	}
+	switch d.Action.Type {
+	case RuleActionEvent, RuleActionAlert:
+	default:
+		return fmt.Errorf("unknown action %q", d.Action.Type)
+	}
+	switch d.Action.Severity {
+	case "", AlertSeverityInfo, AlertSeverityWarning, AlertSeverityCritical:
+	default:
+		return fmt.Errorf("unknown severity %q", d.Action.Severity)
+	}
+	return nil
+}
+
+func (c *RuleCondition) Validate() error {
+	if c.SensorCode == "" || c.Field == "" {
+		return fmt.Errorf("sensor_code and field are required")
+	}
+	switch c.Operator {
+	case RuleOperatorGt, RuleOperatorGte, RuleOperatorLt, RuleOperatorLte, RuleOperatorEq, RuleOperatorNeq:
+	default:
+		return fmt.Errorf("unknown operator %q", c.Operator)
+	}
+	switch c.Aggregate {
+	case "", RuleAggregateLast:
+	case RuleAggregateAvg, RuleAggregateMin, RuleAggregateMax, RuleAggregateSum, RuleAggregateCount, RuleAggregateDelta, RuleAggregateRate:
+		if c.Window == nil {
+			return fmt.Errorf("aggregate %s needs a window", c.Aggregate)
+		}
+	default:
+		return fmt.Errorf("unknown aggregate %q", c.Aggregate)
+	}
+	if c.Window != nil {
+		switch c.Window.Type {
+		case RuleWindowSliding, RuleWindowTumbling:
+		default:
+			return fmt.Errorf("unknown window type %q", c.Window.Type)
+		}
+		if c.Window.DurationSeconds <= 0 || c.Window.Duration() > maxRuleWindowDuration {
+			return fmt.Errorf("window duration_seconds must be between 1 and %d", int(maxRuleWindowDuration.Seconds()))
+		}
+	}
+	return nil
+}
+
+func (c *RuleCondition) AggregateOrDefault() RuleAggregate {
+	if c.Aggregate == "" {
+		return RuleAggregateLast
+	}
+	return c.Aggregate
+}
+
+// Compare applies the operator to the value of the condition.
+func (c *RuleCondition) Compare(value float64) bool {
+	switch c.Operator {
+	case RuleOperatorGt:
+		return value > c.Value
+	case RuleOperatorGte:
+		return value >= c.Value
+	case RuleOperatorLt:
+		return value < c.Value
+	case RuleOperatorLte:
+		return value <= c.Value
+	case RuleOperatorEq:
+		return value == c.Value
+	case RuleOperatorNeq:
+		return value != c.Value
+	}
+	return false
+}
+
+func (w *RuleWindow) Duration() time.Duration {
+	return time.Duration(w.DurationSeconds) * time.Second
+}
+
+func (d *RuleDefinition) For() time.Duration {
+	return time.Duration(d.ForSeconds) * time.Second
+}
+
+// Rule is evaluated over the readings of one device, or of every device when DeviceID is nil.
+type Rule struct {
+	ID          uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Name        string         `gorm:"type:varchar(255);not null" json:"name"`
+	Description string         `gorm:"type:text" json:"description"`
+	DeviceID    *uuid.UUID     `gorm:"type:uuid;index" json:"device_id"`
+	Definition  datatypes.JSON `gorm:"type:jsonb;not null" json:"definition"` // RuleDefinition
+	Enabled     bool           `gorm:"not null;default:true;index" json:"enabled"`
+	CreatedBy   *uuid.UUID     `gorm:"type:uuid" json:"created_by"`
+	CreatedAt   time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// RuleState is the checkpoint of the window and matching state of a rule for one device.
+type RuleState struct {
+	RuleID         uuid.UUID      `gorm:"type:uuid;primaryKey" json:"rule_id"`
+	DeviceID       uuid.UUID      `gorm:"type:uuid;primaryKey" json:"device_id"`
+	RuleVersion    time.Time      `gorm:"not null" json:"rule_version"` // Rule.UpdatedAt the state was built against
+	State          datatypes.JSON `gorm:"type:jsonb" json:"state"`
+	CheckpointedAt time.Time      `gorm:"not null" json:"checkpointed_at"`
+}
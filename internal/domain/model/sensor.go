@@ -12,18 +12,22 @@ import (
 const SensorName = "sensor"
 
 type Sensor struct {
-	ID        uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
-	DeviceID  string         `gorm:"type:varchar(100);not null;index" json:"device_id"`
-	Name      string         `gorm:"type:varchar(255);not null" json:"name"`
-	Type      SensorType     `gorm:"type:varchar(50);not null" json:"type"`
-	Status    domain.Status  `gorm:"type:varchar(50);not null" json:"status"`
-	Unit      string         `gorm:"type:varchar(20)" json:"unit"`
-	Precision int            `gorm:"type:int" json:"precision"`
-	Location  string         `gorm:"type:varchar(255)" json:"location"`
-	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index"`
-	MetaData  datatypes.JSON `gorm:"type:jsonb"`
+	ID        uuid.UUID     `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	DeviceID  string        `gorm:"type:varchar(100);not null;index" json:"device_id"`
+	Name      string        `gorm:"type:varchar(255);not null" json:"name"`
+	Type      SensorType    `gorm:"type:varchar(50);not null" json:"type"`
+	Status    domain.Status `gorm:"type:varchar(50);not null" json:"status"`
+	Unit      string        `gorm:"type:varchar(20)" json:"unit"`
+	Precision int           `gorm:"type:int" json:"precision"`
+	Location  string        `gorm:"type:varchar(255)" json:"location"`
+	// SchemaCode binds the sensor to a sensor schema (configs/sensor.schema.yaml), telemetry declaring
+	// another code is rejected. SchemaVersion pins a version, 0 accepts any registered version.
+	SchemaCode    string         `gorm:"type:varchar(100);index" json:"schema_code"`
+	SchemaVersion int            `gorm:"type:int;not null;default:0" json:"schema_version"`
+	CreatedAt     time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
+	MetaData      datatypes.JSON `gorm:"type:jsonb"`
 }
 
 type SensorType string
@@ -13,6 +13,8 @@ type Telemetry struct {
 	Sensor    Sensor         `gorm:"foreignKey:SensorID;references:ID;constraints:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
 	Timestamp time.Time      `gorm:"not null;index" json:"timestamp"`
 	Data      datatypes.JSON `gorm:"type:jsonb" json:"data"`
-	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	// collected while the device was under a maintenance window
+	Maintenance bool      `gorm:"not null;default:false;index" json:"maintenance"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 }
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+type TelemetryRejectionReason string
+
+const (
+	RejectionSensorNotFound        TelemetryRejectionReason = "sensor_not_found"
+	RejectionDeviceMismatch        TelemetryRejectionReason = "device_mismatch"
+	RejectionSchemaCodeMismatch    TelemetryRejectionReason = "schema_code_mismatch"
+	RejectionSchemaVersionMismatch TelemetryRejectionReason = "schema_version_mismatch"
+
+	// reasons only counted in ingest statistics, they are not written to the rejection log
+	RejectionMalformedPayload TelemetryRejectionReason = "malformed_payload"
+	RejectionInvalidPayload   TelemetryRejectionReason = "invalid_payload"
+	RejectionIngestFailed     TelemetryRejectionReason = "ingest_failed"
+)
+
+// Error lets a reason travel as the cause of an apperror, see errors.As.
+func (r TelemetryRejectionReason) Error() string {
+	return string(r)
+}
+
+// TelemetryRejection records telemetry refused because the declared sensor binding didn't match.
+type TelemetryRejection struct {
+	ID            uuid.UUID                `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	DeviceID      uuid.UUID                `gorm:"type:uuid;not null;index" json:"device_id"`
+	SensorID      uuid.UUID                `gorm:"type:uuid;not null;index" json:"sensor_id"`
+	SensorCode    string                   `gorm:"type:varchar(100)" json:"sensor_code"`
+	SchemaVersion int                      `gorm:"type:int" json:"schema_version"`
+	Reason        TelemetryRejectionReason `gorm:"type:varchar(50);not null;index" json:"reason"`
+	Detail        string                   `gorm:"type:text" json:"detail"`
+	Payload       datatypes.JSON           `gorm:"type:jsonb" json:"payload"`
+	CreatedAt     time.Time                `gorm:"autoCreateTime;index" json:"created_at"`
+}
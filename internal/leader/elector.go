@@ -0,0 +1,76 @@
+// Package leader elects one instance among the replicas of a service through a lease stored in the database, so
+// work like firing command schedules runs exactly once however many instances are up.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Elector competes for one lease. The leader has to call Lead again before the ttl runs out to keep leading.
+type Elector struct {
+	leases repository.LeaseRepository
+	name   string
+	holder string
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	leading bool
+
+	l *zap.Logger
+}
+
+func NewElector(leases repository.LeaseRepository, name string, ttl time.Duration, baseLogger *zap.Logger) *Elector {
+	hostname, _ := os.Hostname()
+	return &Elector{
+		leases: leases,
+		name:   name,
+		holder: fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.NewString()[:8]),
+		ttl:    ttl,
+		l:      logger.Named(baseLogger, "LeaderElector").With(zap.String("lease", name)),
+	}
+}
+
+// Lead takes or renews the lease and reports whether this instance leads. A failed attempt counts as not leading,
+// the lease of a leader that can't reach the database expires for another instance to take over.
+func (e *Elector) Lead(ctx context.Context) bool {
+	acquired, err := e.leases.Acquire(ctx, e.name, e.holder, e.ttl)
+	if err != nil {
+		e.l.Error("failed to acquire lease", zap.Error(err))
+		acquired = false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if acquired != e.leading {
+		if acquired {
+			e.l.Info("became leader", zap.String("holder", e.holder))
+		} else {
+			e.l.Info("lost leadership", zap.String("holder", e.holder))
+		}
+		e.leading = acquired
+	}
+	return acquired
+}
+
+// Resign gives the lease up so another instance can take over without waiting for it to expire.
+func (e *Elector) Resign(ctx context.Context) {
+	e.mu.Lock()
+	leading := e.leading
+	e.leading = false
+	e.mu.Unlock()
+	if !leading {
+		return
+	}
+	if err := e.leases.Release(ctx, e.name, e.holder); err != nil {
+		e.l.Warn("failed to release lease", zap.Error(err))
+	}
+}
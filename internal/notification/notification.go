@@ -0,0 +1,82 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notification events, they are the keys of NotificationConfig.Routes and of the email templates.
+const (
+	EventPasswordReset     = "password_reset"
+	EventAlertTriggered    = "alert_triggered"
+	EventAlertAcknowledged = "alert_acknowledged"
+	EventAlertResolved     = "alert_resolved"
+	EventAlertFlapping     = "alert_flapping"
+	EventAlertEscalated    = "alert_escalated"
+	// device events are raised as device_<event type>, e.g. device_state_changed
+	DeviceEventPrefix = "device_"
+)
+
+// Notification is what callers raise, it fans out to one delivery per routed channel and recipient.
+type Notification struct {
+	Event      string                 `json:"event"`
+	Subject    string                 `json:"subject"`
+	Recipients []string               `json:"recipients,omitempty"` // email addresses, channels without addressing ignore them
+	Data       map[string]interface{} `json:"data"`
+}
+
+// Message is a single delivery handed to a channel. Data is the notification data after a JSON round trip, so
+// templates see the same json field names whether the message is sent right away or retried later.
+type Message struct {
+	DeliveryID uuid.UUID
+	Event      string
+	Recipient  string
+	Subject    string
+	Data       map[string]interface{}
+	CreatedAt  time.Time
+}
+
+type Channel interface {
+	Name() string
+	Recipients(n *Notification) []string              // recipients the notification is delivered to on this channel, none skips the channel
+	Send(ctx context.Context, message *Message) error // wrap the error with Permanent when retrying can't help
+}
+
+// Channel names used in NotificationConfig.Routes.
+const (
+	ChannelWebhook = "webhook"
+	ChannelEmail   = "email"
+	ChannelOutbox  = "outbox"
+)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a send error as not worth retrying, e.g. the receiver rejected the request.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var permErr *permanentError
+	return errors.As(err, &permErr)
+}
+
+// category is the part of the event before the first underscore, alert for alert_triggered.
+func category(event string) string {
+	if i := strings.Index(event, "_"); i > 0 {
+		return event[:i]
+	}
+	return event
+}
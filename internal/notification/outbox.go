@@ -0,0 +1,80 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const outboxRecipient = "outbox"
+
+type outboxEntry struct {
+	DeliveryID string                 `json:"delivery_id"`
+	Event      string                 `json:"event"`
+	Recipient  string                 `json:"recipient"`
+	Subject    string                 `json:"subject"`
+	Text       string                 `json:"text"`
+	Data       map[string]interface{} `json:"data"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// OutboxChannel writes every message to a file in a local directory, it stands in for email and webhooks during
+// development so the password reset link of a user can be read from disk.
+type OutboxChannel struct {
+	dir       string
+	templates *Templates
+	l         *zap.Logger
+}
+
+func NewOutboxChannel(cfg *config.OutboxChannelConfig, templates *Templates, baseLogger *zap.Logger) (*OutboxChannel, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create outbox dir %s: %w", cfg.Dir, err)
+	}
+	return &OutboxChannel{
+		dir:       cfg.Dir,
+		templates: templates,
+		l:         logger.Named(baseLogger, "OutboxChannel"),
+	}, nil
+}
+
+func (c *OutboxChannel) Name() string { return ChannelOutbox }
+
+func (c *OutboxChannel) Recipients(n *Notification) []string {
+	if len(n.Recipients) > 0 {
+		return n.Recipients
+	}
+	return []string{outboxRecipient}
+}
+
+func (c *OutboxChannel) Send(ctx context.Context, message *Message) error {
+	rendered, err := c.templates.Render(message)
+	if err != nil {
+		return Permanent(err)
+	}
+	entry, err := json.MarshalIndent(outboxEntry{
+		DeliveryID: message.DeliveryID.String(),
+		Event:      message.Event,
+		Recipient:  message.Recipient,
+		Subject:    rendered.Subject,
+		Text:       rendered.Text,
+		Data:       message.Data,
+		CreatedAt:  message.CreatedAt,
+	}, "", "  ")
+	if err != nil {
+		return Permanent(err)
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.json", message.CreatedAt.UTC().Format("20060102T150405"), message.Event, message.DeliveryID)
+	if err := os.WriteFile(filepath.Join(c.dir, name), entry, 0o640); err != nil {
+		return fmt.Errorf("write outbox entry: %w", err)
+	}
+	c.l.Info("notification written to outbox", zap.String("event", message.Event), zap.String("file", name))
+	return nil
+}
@@ -0,0 +1,110 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type sendMailFunc func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+// SmtpChannel emails the rendered template of the notification, one delivery per recipient.
+type SmtpChannel struct {
+	cfg       *config.SmtpChannelConfig
+	from      *mail.Address
+	templates *Templates
+	sendMail  sendMailFunc
+	l         *zap.Logger
+}
+
+func NewSmtpChannel(cfg *config.SmtpChannelConfig, templates *Templates, baseLogger *zap.Logger) (*SmtpChannel, error) {
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp from address %q: %w", cfg.From, err)
+	}
+	return &SmtpChannel{
+		cfg:       cfg,
+		from:      from,
+		templates: templates,
+		sendMail:  smtp.SendMail,
+		l:         logger.Named(baseLogger, "SmtpChannel"),
+	}, nil
+}
+
+func (c *SmtpChannel) Name() string { return ChannelEmail }
+
+func (c *SmtpChannel) Recipients(n *Notification) []string {
+	if len(n.Recipients) > 0 {
+		return n.Recipients
+	}
+	return c.cfg.DefaultRecipients
+}
+
+func (c *SmtpChannel) Send(ctx context.Context, message *Message) error {
+	to, err := mail.ParseAddress(message.Recipient)
+	if err != nil {
+		return Permanent(fmt.Errorf("invalid recipient %q: %w", message.Recipient, err))
+	}
+	rendered, err := c.templates.Render(message)
+	if err != nil {
+		return Permanent(err)
+	}
+	body, err := buildMimeMessage(c.from, to, rendered)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	var auth smtp.Auth
+	if c.cfg.Username != "" {
+		auth = smtp.PlainAuth("", c.cfg.Username, c.cfg.Password, c.cfg.Host)
+	}
+	addr := net.JoinHostPort(c.cfg.Host, strconv.Itoa(c.cfg.Port))
+
+	// net/smtp has no context support, run it aside so a cancelled dispatch isn't held up
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.sendMail(addr, auth, c.from.Address, []string{to.Address}, body)
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("smtp send failed: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func buildMimeMessage(from *mail.Address, to *mail.Address, rendered *RenderedMessage) ([]byte, error) {
+	boundaryBytes := make([]byte, 12)
+	if _, err := rand.Read(boundaryBytes); err != nil {
+		return nil, err
+	}
+	boundary := hex.EncodeToString(boundaryBytes)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", rendered.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, rendered.Text)
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/html; charset=utf-8\r\n\r\n%s\r\n", boundary, rendered.Html)
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+package notification
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+const defaultTemplate = "default"
+
+// Templates renders messages from templates/<name>.tmpl, each file defines a "subject", "text" and "html" block.
+// The template of an event is looked up by the event name, then its category (alert for alert_triggered), then default.
+type Templates struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+type RenderedMessage struct {
+	Subject string
+	Text    string
+	Html    string
+}
+
+func LoadTemplates() (*Templates, error) {
+	files, err := fs.Glob(templateFS, "templates/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{
+		text: make(map[string]*texttemplate.Template),
+		html: make(map[string]*htmltemplate.Template),
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+		textTmpl, err := texttemplate.ParseFS(templateFS, file)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", name, err)
+		}
+		htmlTmpl, err := htmltemplate.ParseFS(templateFS, file)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", name, err)
+		}
+		t.text[name] = textTmpl
+		t.html[name] = htmlTmpl
+	}
+	if _, ok := t.text[defaultTemplate]; !ok {
+		return nil, fmt.Errorf("missing %s template", defaultTemplate)
+	}
+	return t, nil
+}
+
+func (t *Templates) Render(message *Message) (*RenderedMessage, error) {
+	name := t.lookup(message.Event)
+
+	var subject, text, html bytes.Buffer
+	if err := t.text[name].ExecuteTemplate(&subject, "subject", message); err != nil {
+		return nil, fmt.Errorf("render %s subject: %w", name, err)
+	}
+	if err := t.text[name].ExecuteTemplate(&text, "text", message); err != nil {
+		return nil, fmt.Errorf("render %s text: %w", name, err)
+	}
+	if err := t.html[name].ExecuteTemplate(&html, "html", message); err != nil {
+		return nil, fmt.Errorf("render %s html: %w", name, err)
+	}
+	return &RenderedMessage{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()),
+		Html:    strings.TrimSpace(html.String()),
+	}, nil
+}
+
+func (t *Templates) lookup(event string) string {
+	for _, name := range []string{event, category(event)} {
+		if _, ok := t.text[name]; ok {
+			return name
+		}
+	}
+	return defaultTemplate
+}
@@ -0,0 +1,113 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	HeaderWebhookEvent     = "X-Iots-Event"
+	HeaderWebhookDelivery  = "X-Iots-Delivery"
+	HeaderWebhookTimestamp = "X-Iots-Timestamp"
+	HeaderWebhookSignature = "X-Iots-Signature"
+
+	defaultWebhookTimeout = 10 * time.Second
+)
+
+type webhookBody struct {
+	ID        string                 `json:"id"`
+	Event     string                 `json:"event"`
+	Subject   string                 `json:"subject,omitempty"`
+	Data      map[string]interface{} `json:"data"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// WebhookChannel posts the notification as JSON to a single endpoint. The body is signed with HMAC-SHA256 over
+// "<timestamp>.<body>", receivers should recompute it with Sign and reject stale timestamps.
+type WebhookChannel struct {
+	url    string
+	secret []byte
+	client *http.Client
+	l      *zap.Logger
+}
+
+func NewWebhookChannel(cfg *config.WebhookChannelConfig, baseLogger *zap.Logger) *WebhookChannel {
+	timeout := defaultWebhookTimeout
+	if cfg.Timeout > 0 {
+		timeout = cfg.Timeout
+	}
+	return &WebhookChannel{
+		url:    cfg.Url,
+		secret: []byte(cfg.Secret),
+		client: &http.Client{Timeout: timeout},
+		l:      logger.Named(baseLogger, "WebhookChannel"),
+	}
+}
+
+func (c *WebhookChannel) Name() string { return ChannelWebhook }
+
+func (c *WebhookChannel) Recipients(n *Notification) []string {
+	return []string{c.url}
+}
+
+func (c *WebhookChannel) Send(ctx context.Context, message *Message) error {
+	body, err := json.Marshal(webhookBody{
+		ID:        message.DeliveryID.String(),
+		Event:     message.Event,
+		Subject:   message.Subject,
+		Data:      message.Data,
+		CreatedAt: message.CreatedAt,
+	})
+	if err != nil {
+		return Permanent(fmt.Errorf("encode webhook body: %w", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, message.Recipient, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(fmt.Errorf("build webhook request: %w", err))
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookEvent, message.Event)
+	req.Header.Set(HeaderWebhookDelivery, message.DeliveryID.String())
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, Sign(c.secret, timestamp, body))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096)) // let the connection be reused
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusRequestTimeout || res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return fmt.Errorf("webhook responded %d", res.StatusCode)
+	default:
+		return Permanent(fmt.Errorf("webhook rejected the delivery with %d", res.StatusCode))
+	}
+}
+
+// Sign returns the X-Iots-Signature value of a webhook body.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/pkg/pagination"
+)
+
+type AlertRepository interface {
+	GetByID(ctx context.Context, alertID uuid.UUID) (*model.Alert, error)                                                   // alert with its notes
+	List(ctx context.Context, filter *dto.AlertFilter, paginationOpt *pagination.Pagination) ([]*model.Alert, int64, error) // filtered & paginated alerts, newest first by default
+	AddNote(ctx context.Context, note *model.AlertNote) error                                                               // append a note to an alert
+	Create(ctx context.Context, alert *model.Alert) error                                                                   // persist a new alert
+	Update(ctx context.Context, alert *model.Alert) error                                                                   // save changes to an existing alert
+	FindActiveByRule(ctx context.Context, ruleID uuid.UUID, deviceID uuid.UUID) (*model.Alert, error)                       // currently raised alert of the rule for the device, nil when there is none
+	FindActive(ctx context.Context, deviceID uuid.UUID, sensorID uuid.UUID, fieldCode string) (*model.Alert, error)         // currently raised alert for the sensor field, nil when there is none
+	FindLatestByFingerprint(ctx context.Context, fingerprint string) (*model.Alert, error)                                  // most recent alert of the source, raised or not, nil when there is none
+	ListQuietFlapping(ctx context.Context, clearedBefore time.Time) ([]*model.Alert, error)                                 // flapping alerts cleared since before the given time
+	ListGroups(ctx context.Context) ([]*model.AlertGroup, error)                                                            // raised alerts summarised per group key, worst first
+}
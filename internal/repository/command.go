@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/pkg/pagination"
+)
+
+type CommandRepository interface {
+	Create(ctx context.Context, command *model.Command) error                                                                   // persist a new command
+	Update(ctx context.Context, command *model.Command) error                                                                   // save lifecycle changes
+	GetByID(ctx context.Context, commandID uuid.UUID) (*model.Command, error)                                                   // command by id
+	List(ctx context.Context, filter *dto.CommandFilter, paginationOpt *pagination.Pagination) ([]*model.Command, int64, error) // filtered & paginated commands, newest first by default
+	ListExpired(ctx context.Context, now time.Time, limit int) ([]*model.Command, error)                                        // unfinished commands past their expiry, oldest first
+	ListAckOverdue(ctx context.Context, now time.Time, limit int) ([]*model.Command, error)                                     // delivered commands past their ack deadline, oldest deadline first
+	TargetDevices(ctx context.Context, target *model.CommandTarget) ([]*model.Device, error)                                    // devices the target selects
+	GetByIdempotencyKey(ctx context.Context, deviceID uuid.UUID, key string) (*model.Command, error)                            // command of the device created with the key
+	ListUndelivered(ctx context.Context, deviceID uuid.UUID, now time.Time, limit int) ([]*model.Command, error)                // queued or sent commands of the device not expired yet, oldest first
+}
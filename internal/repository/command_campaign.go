@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+)
+
+type CommandCampaignRepository interface {
+	Create(ctx context.Context, campaign *model.CommandCampaign, targets []*model.CommandCampaignTarget) error // campaign, its waves and targets in one transaction
+	GetByID(ctx context.Context, campaignID uuid.UUID) (*model.CommandCampaign, error)                         // campaign with its waves
+	List(ctx context.Context, statuses []model.CampaignStatus) ([]*model.CommandCampaign, error)               // newest first, every campaign when statuses is empty
+
+	// Transition moves the campaign to the status when it is in one of from, it reports false when it isn't. The
+	// API and the rollout worker both change the status, the condition keeps them from overwriting each other.
+	Transition(ctx context.Context, campaignID uuid.UUID, from []model.CampaignStatus, to model.CampaignStatus, reason string) (bool, error)
+	SetCurrentWave(ctx context.Context, campaignID uuid.UUID, wave int) error
+	UpdateWave(ctx context.Context, wave *model.CommandCampaignWave) error
+
+	ListUnsentTargets(ctx context.Context, campaignID uuid.UUID, wave int) ([]*model.CommandCampaignTarget, error) // targets of the wave without a command or send error
+	UpdateTarget(ctx context.Context, target *model.CommandCampaignTarget) error
+	WaveProgress(ctx context.Context, campaignID uuid.UUID) ([]*model.CampaignWaveProgress, error) // per wave counts of the target commands, by wave number
+}
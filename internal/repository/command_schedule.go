@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+)
+
+type CommandScheduleRepository interface {
+	Create(ctx context.Context, schedule *model.CommandSchedule) error
+	Update(ctx context.Context, schedule *model.CommandSchedule) error
+	Delete(ctx context.Context, scheduleID uuid.UUID) error
+	GetByID(ctx context.Context, scheduleID uuid.UUID) (*model.CommandSchedule, error)
+	List(ctx context.Context, deviceID *uuid.UUID) ([]*model.CommandSchedule, error)         // every schedule, only device targeted ones of the device when deviceID is set
+	ListDue(ctx context.Context, now time.Time, limit int) ([]*model.CommandSchedule, error) // enabled schedules whose next run passed, oldest first
+
+	// ClaimRun moves the schedule from the run at runAt to the next one, it reports false when another scheduler
+	// claimed that run first.
+	ClaimRun(ctx context.Context, scheduleID uuid.UUID, runAt time.Time, nextRunAt *time.Time, now time.Time) (bool, error)
+	SetLastError(ctx context.Context, scheduleID uuid.UUID, lastError string) error // outcome of the last run, empty when it succeeded
+}
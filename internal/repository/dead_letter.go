@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/pkg/pagination"
+)
+
+type DeadLetterRepository interface {
+	Record(ctx context.Context, letter *model.DeadLetter) error                                                                                          // insert, or count another failure of the dead letter with the same fingerprint
+	UpdateWhereStatus(ctx context.Context, letterID uuid.UUID, status model.DeadLetterStatus, changes map[string]interface{}) (*model.DeadLetter, error) // apply an edit, re-drive or discard only while the dead letter has the status, ErrConflict otherwise
+	GetByID(ctx context.Context, letterID uuid.UUID) (*model.DeadLetter, error)                                                                          // dead letter by id
+	List(ctx context.Context, filter *dto.DeadLetterFilter, paginationOpt *pagination.Pagination) ([]*model.DeadLetter, int64, error)                    // filtered & paginated dead letters, latest failure first by default
+	Volume(ctx context.Context, since time.Time) ([]*model.DeadLetterVolume, error)                                                                      // dead letters failing since the time, per command code, subject and status
+	DeletePendingBefore(ctx context.Context, before time.Time) (int64, error)                                                                            // pending dead letters whose last failure is older than the time
+	DeleteResolvedBefore(ctx context.Context, before time.Time) (int64, error)                                                                           // re-driven and discarded dead letters resolved before the time
+}
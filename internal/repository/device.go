@@ -32,16 +32,17 @@ type DeviceRepository interface {
 	SearchByTags(ctx context.Context, tags []string, opt *pagination.Pagination) ([]*model.Device, int64, error)                           // search device by tag list
 	SearchByCapabilities(ctx context.Context, capabilities []string, paginationOpt *pagination.Pagination) ([]*model.Device, int64, error) // search device by capabilities
 
-	AssignOwner(ctx context.Context, deviceID uuid.UUID, ownerID uuid.UUID) error             // assign owner to a device
-	UpdateStatus(ctx context.Context, deviceID uuid.UUID, newStatus model.DeviceStatus) error // update device status
-	UpdateLastConnected(ctx context.Context, deviceID uuid.UUID, timestamp time.Time) error   // update device last connected timestamp
+	AssignOwner(ctx context.Context, deviceID uuid.UUID, ownerID uuid.UUID) error                 // assign owner to a device
+	UpdateLastConnected(ctx context.Context, deviceID uuid.UUID, timestamp time.Time) error       // update device last connected timestamp
+	UpdateCommandVersions(ctx context.Context, deviceID uuid.UUID, versions map[string]int) error // replace the command schema versions negotiated by the device
 
 	CountByStatus(ctx context.Context) (map[model.DeviceStatus]int64, error) // retrieve the count the number of all status
 
 	FindByMACAddr(ctx context.Context, addr string) (*model.Device, error) // find whether device exist with provided MAC address
 	ExistByMACAddr(ctx context.Context, addr string) (bool, error)         // check whether a device with mac address exist
 
-	MarkAsProvisioned(ctx context.Context, deviceID uuid.UUID) error // update the device status to the 'provisioned'
+	TransitionStatus(ctx context.Context, change *model.DeviceStatusChange) (*model.Device, error)                                               // move the device to change.ToStatus if the lifecycle allows it and record the change, change.FromStatus is filled in
+	ListStatusChanges(ctx context.Context, deviceID uuid.UUID, paginationOpt *pagination.Pagination) ([]*model.DeviceStatusChange, int64, error) // status history of the device, latest first
 
 	// // Specialized operations
 	// FindNearLocation(ctx context.Context, lat, lon float64, radiusKm float64, page, pageSize int) ([]device.Device, int64, error)
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+)
+
+type EscalationRepository interface {
+	CreatePolicy(ctx context.Context, policy *model.EscalationPolicy) error
+	UpdatePolicy(ctx context.Context, policy *model.EscalationPolicy) error
+	DeletePolicy(ctx context.Context, policyID uuid.UUID) error
+	GetPolicy(ctx context.Context, policyID uuid.UUID) (*model.EscalationPolicy, error)
+	ListPolicies(ctx context.Context) ([]*model.EscalationPolicy, error)
+	ListEnabledPolicies(ctx context.Context) ([]*model.EscalationPolicy, error) // highest priority first
+
+	StartEscalation(ctx context.Context, escalation *model.AlertEscalation) error // restarts a cancelled or completed escalation of the alert, no-op while it is escalating
+	UpdateEscalation(ctx context.Context, escalation *model.AlertEscalation) error
+	GetEscalation(ctx context.Context, alertID uuid.UUID) (*model.AlertEscalation, error)                          // nil when the alert has none
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.AlertEscalation, error) // pending escalations due at now, hidden from other claims for the lease
+	CancelEscalation(ctx context.Context, alertID uuid.UUID) error
+}
+
+type OnCallRepository interface {
+	CreateSchedule(ctx context.Context, schedule *model.OnCallSchedule) error
+	UpdateSchedule(ctx context.Context, schedule *model.OnCallSchedule) error // overrides are left as is
+	DeleteSchedule(ctx context.Context, scheduleID uuid.UUID) error
+	GetSchedule(ctx context.Context, scheduleID uuid.UUID) (*model.OnCallSchedule, error) // with overrides that haven't ended
+	ListSchedules(ctx context.Context) ([]*model.OnCallSchedule, error)
+
+	AddOverride(ctx context.Context, override *model.OnCallOverride) error
+	DeleteOverride(ctx context.Context, scheduleID uuid.UUID, overrideID uuid.UUID) error
+}
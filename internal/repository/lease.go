@@ -0,0 +1,11 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+type LeaseRepository interface {
+	Acquire(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) // take or renew the lease, false while another holder has it
+	Release(ctx context.Context, name string, holder string) error                            // give the lease up if held
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+)
+
+type MaintenanceRepository interface {
+	Create(ctx context.Context, window *model.MaintenanceWindow) error
+	Update(ctx context.Context, window *model.MaintenanceWindow) error
+	Delete(ctx context.Context, windowID uuid.UUID) error
+	GetByID(ctx context.Context, windowID uuid.UUID) (*model.MaintenanceWindow, error)
+	List(ctx context.Context, deviceID *uuid.UUID) ([]*model.MaintenanceWindow, error)  // every window, only device scoped ones of the device when deviceID is set
+	ListCurrent(ctx context.Context, now time.Time) ([]*model.MaintenanceWindow, error) // windows with an occurrence ending after now
+
+	DevicesInScope(ctx context.Context, window *model.MaintenanceWindow) ([]*model.Device, error) // devices the window covers
+
+	ListDeviceMaintenance(ctx context.Context) ([]*model.DeviceMaintenance, error)                                                             // devices currently held in under_maintenance by a window
+	StartDeviceMaintenance(ctx context.Context, maintenance *model.DeviceMaintenance, change *model.DeviceStatusChange) (*model.Device, error) // record the previous status and move the device to under_maintenance
+	EndDeviceMaintenance(ctx context.Context, maintenance *model.DeviceMaintenance, change *model.DeviceStatusChange) (*model.Device, error)   // restore the previous status, nil device when the status was changed meanwhile
+}
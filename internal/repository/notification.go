@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/vars7899/iots/internal/domain/model"
+)
+
+type NotificationRepository interface {
+	CreateDeliveries(ctx context.Context, deliveries []*model.NotificationDelivery) error                               // persist new deliveries in one go
+	UpdateDelivery(ctx context.Context, delivery *model.NotificationDelivery) error                                     // save retry state
+	AddAttempt(ctx context.Context, attempt *model.NotificationAttempt) error                                           // append to the delivery log
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.NotificationDelivery, error) // pending deliveries due at now, oldest first, hidden from other claims for the lease
+}
@@ -0,0 +1,213 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pagination"
+	"github.com/vars7899/iots/pkg/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AlertRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewAlertRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.AlertRepository {
+	return &AlertRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "AlertRepositoryPostgres"),
+	}
+}
+
+func (r *AlertRepositoryPostgres) GetByID(ctx context.Context, alertID uuid.UUID) (*model.Alert, error) {
+	var alert model.Alert
+	err := r.db.WithContext(ctx).
+		Preload("Notes", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at ASC") }).
+		First(&alert, "id = ?", alertID).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityAlert)
+	}
+	return &alert, nil
+}
+
+func (r *AlertRepositoryPostgres) List(ctx context.Context, filter *dto.AlertFilter, paginationOpt *pagination.Pagination) ([]*model.Alert, int64, error) {
+	queryBuilder := func(tx *gorm.DB) *gorm.DB {
+		if filter == nil {
+			return tx
+		}
+		if filter.DeviceID != nil {
+			tx = tx.Where("device_id = ?", *filter.DeviceID)
+		}
+		if filter.SensorID != nil {
+			tx = tx.Where("sensor_id = ?", *filter.SensorID)
+		}
+		if filter.SensorCode != nil {
+			tx = tx.Where("sensor_code = ?", *filter.SensorCode)
+		}
+		if len(filter.Status) > 0 {
+			tx = tx.Where("status IN ?", filter.Status)
+		}
+		if filter.GroupKey != nil {
+			tx = tx.Where("group_key = ?", *filter.GroupKey)
+		}
+		if filter.Severity != nil {
+			tx = tx.Where("severity = ?", *filter.Severity)
+		}
+		if filter.TriggeredFrom != nil {
+			tx = tx.Where("triggered_at >= ?", *filter.TriggeredFrom)
+		}
+		if filter.TriggeredTo != nil {
+			tx = tx.Where("triggered_at <= ?", *filter.TriggeredTo)
+		}
+		return tx
+	}
+
+	if paginationOpt == nil {
+		paginationOpt = &pagination.Pagination{}
+	}
+	if paginationOpt.SortBy == "" {
+		paginationOpt.SortBy = "triggered_at"
+		paginationOpt.SortOrder = "desc"
+	}
+
+	alerts, count, err := FindWithPagination[model.Alert](ctx, r.db, paginationOpt, queryBuilder, r.l)
+	if err != nil {
+		return nil, 0, err
+	}
+	return utils.ConvertVectorToPointerVector(alerts), count, nil
+}
+
+func (r *AlertRepositoryPostgres) AddNote(ctx context.Context, note *model.AlertNote) error {
+	if err := r.db.WithContext(ctx).Create(note).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityAlertNote)
+	}
+	return nil
+}
+
+func (r *AlertRepositoryPostgres) Create(ctx context.Context, alert *model.Alert) error {
+	if err := r.db.WithContext(ctx).Create(alert).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityAlert)
+	}
+	return nil
+}
+
+func (r *AlertRepositoryPostgres) Update(ctx context.Context, alert *model.Alert) error {
+	// notes are appended through AddNote, never rewritten with the alert
+	if err := r.db.WithContext(ctx).Omit(clause.Associations).Save(alert).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityAlert)
+	}
+	return nil
+}
+
+func (r *AlertRepositoryPostgres) FindActive(ctx context.Context, deviceID uuid.UUID, sensorID uuid.UUID, fieldCode string) (*model.Alert, error) {
+	var alert model.Alert
+	err := r.db.WithContext(ctx).
+		Where("device_id = ? AND sensor_id = ? AND field_code = ? AND status IN ?", deviceID, sensorID, fieldCode, model.ActiveAlertStatuses).
+		Order("triggered_at DESC").
+		First(&alert).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityAlert)
+	}
+	return &alert, nil
+}
+
+func (r *AlertRepositoryPostgres) FindActiveByRule(ctx context.Context, ruleID uuid.UUID, deviceID uuid.UUID) (*model.Alert, error) {
+	var alert model.Alert
+	err := r.db.WithContext(ctx).
+		Where("rule_id = ? AND device_id = ? AND status IN ?", ruleID, deviceID, model.ActiveAlertStatuses).
+		Order("triggered_at DESC").
+		First(&alert).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityAlert)
+	}
+	return &alert, nil
+}
+
+func (r *AlertRepositoryPostgres) FindLatestByFingerprint(ctx context.Context, fingerprint string) (*model.Alert, error) {
+	var alert model.Alert
+	err := r.db.WithContext(ctx).
+		Where("fingerprint = ?", fingerprint).
+		Order("triggered_at DESC").
+		First(&alert).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityAlert)
+	}
+	return &alert, nil
+}
+
+func (r *AlertRepositoryPostgres) ListQuietFlapping(ctx context.Context, clearedBefore time.Time) ([]*model.Alert, error) {
+	var alerts []*model.Alert
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND cleared_at IS NOT NULL AND cleared_at <= ?", model.AlertStatusFlapping, clearedBefore).
+		Find(&alerts).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityAlert)
+	}
+	return alerts, nil
+}
+
+func (r *AlertRepositoryPostgres) ListGroups(ctx context.Context) ([]*model.AlertGroup, error) {
+	var rows []struct {
+		GroupKey        string
+		Alerts          int64
+		Occurrences     int64
+		Flapping        int64
+		SeverityRank    int
+		LastTriggeredAt time.Time
+	}
+	err := r.db.WithContext(ctx).
+		Model(&model.Alert{}).
+		Select(`group_key,
+			COUNT(*) AS alerts,
+			COALESCE(SUM(occurrence_count), 0) AS occurrences,
+			COUNT(*) FILTER (WHERE status = ?) AS flapping,
+			MAX(CASE severity WHEN ? THEN 3 WHEN ? THEN 2 WHEN ? THEN 1 ELSE 0 END) AS severity_rank,
+			MAX(triggered_at) AS last_triggered_at`,
+			model.AlertStatusFlapping, model.AlertSeverityCritical, model.AlertSeverityWarning, model.AlertSeverityInfo).
+		Where("status IN ?", model.ActiveAlertStatuses).
+		Group("group_key").
+		Order("severity_rank DESC, alerts DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityAlert)
+	}
+
+	groups := make([]*model.AlertGroup, 0, len(rows))
+	for _, row := range rows {
+		group := &model.AlertGroup{
+			GroupKey:        row.GroupKey,
+			Alerts:          row.Alerts,
+			Occurrences:     row.Occurrences,
+			Flapping:        row.Flapping,
+			LastTriggeredAt: row.LastTriggeredAt,
+		}
+		for _, severity := range []model.AlertSeverity{model.AlertSeverityInfo, model.AlertSeverityWarning, model.AlertSeverityCritical} {
+			if model.AlertSeverityRank(severity) == row.SeverityRank {
+				group.Severity = severity
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pagination"
+	"github.com/vars7899/iots/pkg/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type CommandRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewCommandRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.CommandRepository {
+	return &CommandRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "CommandRepositoryPostgres"),
+	}
+}
+
+func (r *CommandRepositoryPostgres) Create(ctx context.Context, command *model.Command) error {
+	if err := r.db.WithContext(ctx).Create(command).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityCommand)
+	}
+	return nil
+}
+
+func (r *CommandRepositoryPostgres) Update(ctx context.Context, command *model.Command) error {
+	if err := r.db.WithContext(ctx).Save(command).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityCommand)
+	}
+	return nil
+}
+
+func (r *CommandRepositoryPostgres) GetByID(ctx context.Context, commandID uuid.UUID) (*model.Command, error) {
+	var command model.Command
+	if err := r.db.WithContext(ctx).First(&command, "id = ?", commandID).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommand)
+	}
+	return &command, nil
+}
+
+func (r *CommandRepositoryPostgres) List(ctx context.Context, filter *dto.CommandFilter, paginationOpt *pagination.Pagination) ([]*model.Command, int64, error) {
+	queryBuilder := func(tx *gorm.DB) *gorm.DB {
+		if filter == nil {
+			return tx
+		}
+		if filter.DeviceID != nil {
+			tx = tx.Where("device_id = ?", *filter.DeviceID)
+		}
+		if filter.ScheduleID != nil {
+			tx = tx.Where("schedule_id = ?", *filter.ScheduleID)
+		}
+		if filter.CampaignID != nil {
+			tx = tx.Where("campaign_id = ?", *filter.CampaignID)
+		}
+		if filter.CommandCode != nil {
+			tx = tx.Where("command_code = ?", *filter.CommandCode)
+		}
+		if len(filter.Status) > 0 {
+			tx = tx.Where("status IN ?", filter.Status)
+		}
+		if filter.CreatedFrom != nil {
+			tx = tx.Where("created_at >= ?", *filter.CreatedFrom)
+		}
+		if filter.CreatedTo != nil {
+			tx = tx.Where("created_at <= ?", *filter.CreatedTo)
+		}
+		return tx
+	}
+
+	if paginationOpt == nil {
+		paginationOpt = &pagination.Pagination{}
+	}
+	if paginationOpt.SortBy == "" {
+		paginationOpt.SortBy = "created_at"
+		paginationOpt.SortOrder = "desc"
+	}
+
+	commands, count, err := FindWithPagination[model.Command](ctx, r.db, paginationOpt, queryBuilder, r.l)
+	if err != nil {
+		return nil, 0, err
+	}
+	return utils.ConvertVectorToPointerVector(commands), count, nil
+}
+
+func (r *CommandRepositoryPostgres) ListExpired(ctx context.Context, now time.Time, limit int) ([]*model.Command, error) {
+	var commands []*model.Command
+	err := r.db.WithContext(ctx).
+		Where("status IN ? AND expires_at <= ?", model.UnfinishedCommandStatuses, now).
+		Order("expires_at ASC").
+		Limit(limit).
+		Find(&commands).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommand)
+	}
+	return commands, nil
+}
+
+func (r *CommandRepositoryPostgres) ListAckOverdue(ctx context.Context, now time.Time, limit int) ([]*model.Command, error) {
+	var commands []*model.Command
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND ack_deadline <= ?", model.CommandStatusDelivered, now).
+		Order("ack_deadline ASC").
+		Limit(limit).
+		Find(&commands).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommand)
+	}
+	return commands, nil
+}
+
+func (r *CommandRepositoryPostgres) ListUndelivered(ctx context.Context, deviceID uuid.UUID, now time.Time, limit int) ([]*model.Command, error) {
+	var commands []*model.Command
+	err := r.db.WithContext(ctx).
+		Where("device_id = ? AND status IN ? AND expires_at > ?", deviceID, []model.CommandStatus{model.CommandStatusQueued, model.CommandStatusSent}, now).
+		Order("created_at ASC").
+		Limit(limit).
+		Find(&commands).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommand)
+	}
+	return commands, nil
+}
+
+func (r *CommandRepositoryPostgres) GetByIdempotencyKey(ctx context.Context, deviceID uuid.UUID, key string) (*model.Command, error) {
+	var command model.Command
+	if err := r.db.WithContext(ctx).First(&command, "device_id = ? AND idempotency_key = ?", deviceID, key).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommand)
+	}
+	return &command, nil
+}
+
+func (r *CommandRepositoryPostgres) TargetDevices(ctx context.Context, target *model.CommandTarget) ([]*model.Device, error) {
+	tx := r.db.WithContext(ctx).Model(&model.Device{})
+	switch target.TargetType {
+	case model.CommandTargetDevice:
+		tx = tx.Where("id = ?", target.DeviceID)
+	case model.CommandTargetTag:
+		tx = tx.Where("tags @> ?", pq.StringArray{target.Tag})
+	case model.CommandTargetSite:
+		tx = tx.Where("metadata ->> ? = ?", model.DeviceMetadataSiteKey, target.Site)
+	default:
+		return nil, nil
+	}
+	var devices []*model.Device
+	if err := tx.Find(&devices).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityDevice)
+	}
+	return devices, nil
+}
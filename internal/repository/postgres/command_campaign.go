@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const commandCampaignTargetBatchSize = 500
+
+type CommandCampaignRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewCommandCampaignRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.CommandCampaignRepository {
+	return &CommandCampaignRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "CommandCampaignRepositoryPostgres"),
+	}
+}
+
+func (r *CommandCampaignRepositoryPostgres) Create(ctx context.Context, campaign *model.CommandCampaign, targets []*model.CommandCampaignTarget) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(campaign).Error; err != nil {
+			return err
+		}
+		for _, target := range targets {
+			target.CampaignID = campaign.ID
+		}
+		if len(targets) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(targets, commandCampaignTargetBatchSize).Error
+	})
+	if err != nil {
+		return apperror.MapDBError(err, domain.EntityCommandCampaign)
+	}
+	return nil
+}
+
+func (r *CommandCampaignRepositoryPostgres) GetByID(ctx context.Context, campaignID uuid.UUID) (*model.CommandCampaign, error) {
+	var campaign model.CommandCampaign
+	err := r.db.WithContext(ctx).
+		Preload("Waves", func(tx *gorm.DB) *gorm.DB { return tx.Order("number ASC") }).
+		First(&campaign, "id = ?", campaignID).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommandCampaign)
+	}
+	return &campaign, nil
+}
+
+func (r *CommandCampaignRepositoryPostgres) List(ctx context.Context, statuses []model.CampaignStatus) ([]*model.CommandCampaign, error) {
+	tx := r.db.WithContext(ctx).Order("created_at DESC")
+	if len(statuses) > 0 {
+		tx = tx.Where("status IN ?", statuses)
+	}
+	var campaigns []*model.CommandCampaign
+	if err := tx.Find(&campaigns).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommandCampaign)
+	}
+	return campaigns, nil
+}
+
+func (r *CommandCampaignRepositoryPostgres) Transition(ctx context.Context, campaignID uuid.UUID, from []model.CampaignStatus, to model.CampaignStatus, reason string) (bool, error) {
+	updates := map[string]interface{}{
+		"status":        to,
+		"status_reason": reason,
+	}
+	if to == model.CampaignStatusCompleted || to == model.CampaignStatusAborted {
+		updates["finished_at"] = time.Now()
+	}
+	tx := r.db.WithContext(ctx).Model(&model.CommandCampaign{}).
+		Where("id = ? AND status IN ?", campaignID, from).
+		Updates(updates)
+	if tx.Error != nil {
+		return false, apperror.MapDBError(tx.Error, domain.EntityCommandCampaign)
+	}
+	return tx.RowsAffected == 1, nil
+}
+
+func (r *CommandCampaignRepositoryPostgres) SetCurrentWave(ctx context.Context, campaignID uuid.UUID, wave int) error {
+	err := r.db.WithContext(ctx).Model(&model.CommandCampaign{}).
+		Where("id = ?", campaignID).
+		Update("current_wave", wave).Error
+	if err != nil {
+		return apperror.MapDBError(err, domain.EntityCommandCampaign)
+	}
+	return nil
+}
+
+func (r *CommandCampaignRepositoryPostgres) UpdateWave(ctx context.Context, wave *model.CommandCampaignWave) error {
+	if err := r.db.WithContext(ctx).Save(wave).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityCommandCampaign)
+	}
+	return nil
+}
+
+func (r *CommandCampaignRepositoryPostgres) ListUnsentTargets(ctx context.Context, campaignID uuid.UUID, wave int) ([]*model.CommandCampaignTarget, error) {
+	var targets []*model.CommandCampaignTarget
+	err := r.db.WithContext(ctx).
+		Where("campaign_id = ? AND wave = ? AND command_id IS NULL AND error = ''", campaignID, wave).
+		Find(&targets).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommandCampaign)
+	}
+	return targets, nil
+}
+
+func (r *CommandCampaignRepositoryPostgres) UpdateTarget(ctx context.Context, target *model.CommandCampaignTarget) error {
+	if err := r.db.WithContext(ctx).Save(target).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityCommandCampaign)
+	}
+	return nil
+}
+
+func (r *CommandCampaignRepositoryPostgres) WaveProgress(ctx context.Context, campaignID uuid.UUID) ([]*model.CampaignWaveProgress, error) {
+	var progress []*model.CampaignWaveProgress
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT t.wave AS wave,
+			COUNT(*) AS total,
+			COUNT(*) FILTER (WHERE t.command_id IS NULL AND t.error = '') AS unsent,
+			COUNT(*) FILTER (WHERE c.status = ?) AS completed,
+			COUNT(*) FILTER (WHERE c.status IN ? OR (t.command_id IS NULL AND t.error <> '')) AS failed
+		FROM command_campaign_targets t
+		LEFT JOIN commands c ON c.id = t.command_id
+		WHERE t.campaign_id = ?
+		GROUP BY t.wave
+		ORDER BY t.wave`,
+		model.CommandStatusCompleted,
+		[]model.CommandStatus{model.CommandStatusFailed, model.CommandStatusExpired},
+		campaignID,
+	).Scan(&progress).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommandCampaign)
+	}
+	return progress, nil
+}
@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type CommandScheduleRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewCommandScheduleRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.CommandScheduleRepository {
+	return &CommandScheduleRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "CommandScheduleRepositoryPostgres"),
+	}
+}
+
+func (r *CommandScheduleRepositoryPostgres) Create(ctx context.Context, schedule *model.CommandSchedule) error {
+	if err := r.db.WithContext(ctx).Create(schedule).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityCommandSchedule)
+	}
+	return nil
+}
+
+func (r *CommandScheduleRepositoryPostgres) Update(ctx context.Context, schedule *model.CommandSchedule) error {
+	if err := r.db.WithContext(ctx).Save(schedule).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityCommandSchedule)
+	}
+	return nil
+}
+
+func (r *CommandScheduleRepositoryPostgres) Delete(ctx context.Context, scheduleID uuid.UUID) error {
+	tx := r.db.WithContext(ctx).Where("id = ?", scheduleID).Delete(&model.CommandSchedule{})
+	if tx.Error != nil {
+		return apperror.MapDBError(tx.Error, domain.EntityCommandSchedule)
+	}
+	if tx.RowsAffected == 0 {
+		return apperror.ErrNotFound.WithMessagef("%s not found", domain.EntityCommandSchedule)
+	}
+	return nil
+}
+
+func (r *CommandScheduleRepositoryPostgres) GetByID(ctx context.Context, scheduleID uuid.UUID) (*model.CommandSchedule, error) {
+	var schedule model.CommandSchedule
+	if err := r.db.WithContext(ctx).First(&schedule, "id = ?", scheduleID).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommandSchedule)
+	}
+	return &schedule, nil
+}
+
+func (r *CommandScheduleRepositoryPostgres) List(ctx context.Context, deviceID *uuid.UUID) ([]*model.CommandSchedule, error) {
+	tx := r.db.WithContext(ctx).Order("created_at DESC")
+	if deviceID != nil {
+		tx = tx.Where("device_id = ?", *deviceID)
+	}
+	var schedules []*model.CommandSchedule
+	if err := tx.Find(&schedules).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommandSchedule)
+	}
+	return schedules, nil
+}
+
+func (r *CommandScheduleRepositoryPostgres) ListDue(ctx context.Context, now time.Time, limit int) ([]*model.CommandSchedule, error) {
+	var schedules []*model.CommandSchedule
+	err := r.db.WithContext(ctx).
+		Where("enabled = ? AND next_run_at <= ?", true, now).
+		Order("next_run_at ASC").
+		Limit(limit).
+		Find(&schedules).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityCommandSchedule)
+	}
+	return schedules, nil
+}
+
+func (r *CommandScheduleRepositoryPostgres) ClaimRun(ctx context.Context, scheduleID uuid.UUID, runAt time.Time, nextRunAt *time.Time, now time.Time) (bool, error) {
+	tx := r.db.WithContext(ctx).Model(&model.CommandSchedule{}).
+		Where("id = ? AND next_run_at = ?", scheduleID, runAt).
+		Updates(map[string]interface{}{
+			"next_run_at": nextRunAt,
+			"last_run_at": now,
+		})
+	if tx.Error != nil {
+		return false, apperror.MapDBError(tx.Error, domain.EntityCommandSchedule)
+	}
+	return tx.RowsAffected == 1, nil
+}
+
+func (r *CommandScheduleRepositoryPostgres) SetLastError(ctx context.Context, scheduleID uuid.UUID, lastError string) error {
+	err := r.db.WithContext(ctx).Model(&model.CommandSchedule{}).
+		Where("id = ?", scheduleID).
+		Update("last_error", lastError).Error
+	if err != nil {
+		return apperror.MapDBError(err, domain.EntityCommandSchedule)
+	}
+	return nil
+}
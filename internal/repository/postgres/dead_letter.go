@@ -0,0 +1,153 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pagination"
+	"github.com/vars7899/iots/pkg/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DeadLetterRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewDeadLetterRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.DeadLetterRepository {
+	return &DeadLetterRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "DeadLetterRepositoryPostgres"),
+	}
+}
+
+func (r *DeadLetterRepositoryPostgres) Record(ctx context.Context, letter *model.DeadLetter) error {
+	// a re-driven message failing again is pending again, with the error of the last failure
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "fingerprint"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"attempts":       gorm.Expr("dead_letters.attempts + 1"),
+			"error_code":     letter.ErrorCode,
+			"error":          letter.Error,
+			"status":         model.DeadLetterStatusPending,
+			"last_failed_at": letter.LastFailedAt,
+			"resolved_at":    nil,
+			"updated_at":     time.Now(),
+		}),
+	}).Create(letter).Error
+	if err != nil {
+		return apperror.MapDBError(err, domain.EntityDeadLetter)
+	}
+	return nil
+}
+
+// UpdateWhereStatus updates the columns of the changes in one conditional statement, of concurrent re-drives only one
+// moves the dead letter out of pending and the attempts a consumer counts meanwhile are kept.
+func (r *DeadLetterRepositoryPostgres) UpdateWhereStatus(ctx context.Context, letterID uuid.UUID, status model.DeadLetterStatus, changes map[string]interface{}) (*model.DeadLetter, error) {
+	var letter model.DeadLetter
+	tx := r.db.WithContext(ctx).Model(&model.DeadLetter{}).Clauses(clause.Returning{}).
+		Where("id = ? AND status = ?", letterID, status).
+		Updates(changes).Scan(&letter)
+	if tx.Error != nil {
+		return nil, apperror.MapDBError(tx.Error, domain.EntityDeadLetter)
+	}
+	if tx.RowsAffected == 0 {
+		return nil, apperror.ErrConflict.WithMessagef("%s is no longer %s", domain.EntityDeadLetter, status)
+	}
+	return &letter, nil
+}
+
+func (r *DeadLetterRepositoryPostgres) GetByID(ctx context.Context, letterID uuid.UUID) (*model.DeadLetter, error) {
+	var letter model.DeadLetter
+	if err := r.db.WithContext(ctx).First(&letter, "id = ?", letterID).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityDeadLetter)
+	}
+	return &letter, nil
+}
+
+func (r *DeadLetterRepositoryPostgres) List(ctx context.Context, filter *dto.DeadLetterFilter, paginationOpt *pagination.Pagination) ([]*model.DeadLetter, int64, error) {
+	queryBuilder := func(tx *gorm.DB) *gorm.DB {
+		if filter == nil {
+			return tx
+		}
+		if filter.Subject != nil {
+			tx = tx.Where("subject = ?", *filter.Subject)
+		}
+		if filter.DeviceID != nil {
+			tx = tx.Where("device_id = ?", *filter.DeviceID)
+		}
+		if filter.CommandCode != nil {
+			tx = tx.Where("command_code = ?", *filter.CommandCode)
+		}
+		if filter.ErrorCode != nil {
+			tx = tx.Where("error_code = ?", *filter.ErrorCode)
+		}
+		if len(filter.Status) > 0 {
+			tx = tx.Where("status IN ?", filter.Status)
+		}
+		if filter.FailedFrom != nil {
+			tx = tx.Where("last_failed_at >= ?", *filter.FailedFrom)
+		}
+		if filter.FailedTo != nil {
+			tx = tx.Where("last_failed_at <= ?", *filter.FailedTo)
+		}
+		return tx
+	}
+
+	if paginationOpt == nil {
+		paginationOpt = &pagination.Pagination{}
+	}
+	if paginationOpt.SortBy == "" {
+		paginationOpt.SortBy = "last_failed_at"
+		paginationOpt.SortOrder = "desc"
+	}
+
+	letters, count, err := FindWithPagination[model.DeadLetter](ctx, r.db, paginationOpt, queryBuilder, r.l)
+	if err != nil {
+		return nil, 0, err
+	}
+	return utils.ConvertVectorToPointerVector(letters), count, nil
+}
+
+func (r *DeadLetterRepositoryPostgres) Volume(ctx context.Context, since time.Time) ([]*model.DeadLetterVolume, error) {
+	var volume []*model.DeadLetterVolume
+	err := r.db.WithContext(ctx).Model(&model.DeadLetter{}).
+		Select("command_code, subject, status, COUNT(*) AS count, COALESCE(SUM(attempts), 0) AS attempts, MAX(last_failed_at) AS last_failed_at").
+		Where("last_failed_at >= ?", since).
+		Group("command_code, subject, status").
+		Order("count DESC, command_code ASC").
+		Scan(&volume).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityDeadLetter)
+	}
+	return volume, nil
+}
+
+func (r *DeadLetterRepositoryPostgres) DeletePendingBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("status = ? AND last_failed_at < ?", model.DeadLetterStatusPending, before).
+		Delete(&model.DeadLetter{})
+	if result.Error != nil {
+		return 0, apperror.MapDBError(result.Error, domain.EntityDeadLetter)
+	}
+	return result.RowsAffected, nil
+}
+
+func (r *DeadLetterRepositoryPostgres) DeleteResolvedBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("status IN ? AND resolved_at < ?", []model.DeadLetterStatus{model.DeadLetterStatusRedriven, model.DeadLetterStatusDiscarded}, before).
+		Delete(&model.DeadLetter{})
+	if result.Error != nil {
+		return 0, apperror.MapDBError(result.Error, domain.EntityDeadLetter)
+	}
+	return result.RowsAffected, nil
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/vars7899/iots/internal/domain"
 	"github.com/vars7899/iots/internal/domain/model"
 	"github.com/vars7899/iots/internal/repository"
@@ -13,6 +14,7 @@ import (
 	"github.com/vars7899/iots/pkg/pagination"
 	"github.com/vars7899/iots/pkg/utils"
 	"go.uber.org/zap"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -114,14 +116,14 @@ func (r *DeviceRepositoryPostgres) Delete(ctx context.Context, deviceID uuid.UUI
 }
 
 func (r *DeviceRepositoryPostgres) Recover(ctx context.Context, deviceID uuid.UUID) error {
-	tx := r.db.WithContext(ctx).Model(&model.Device{}).Where("id = ?", deviceID).Update("deleted_at", nil)
+	tx := r.db.WithContext(ctx).Unscoped().Model(&model.Device{}).Where("id = ? AND deleted_at IS NOT NULL", deviceID).Update("deleted_at", nil)
 	if tx.Error != nil {
 		r.logger.Debug("Failed to recover deleted device", zap.String("device_id", deviceID.String()), zap.Error(tx.Error))
 		return apperror.MapDBError(tx.Error, domain.EntityDevice)
 	}
 	if tx.RowsAffected == 0 {
 		r.logger.Debug("Failed to recover deleted device: no matching record found", zap.String("device_id", deviceID.String()))
-		return apperror.ErrNotFound.WithMessagef("delete operation failed: no matching %s found", domain.EntityDevice)
+		return apperror.ErrNotFound.WithMessagef("recover operation failed: no deleted %s found with ID %s", domain.EntityDevice, deviceID)
 	}
 	return nil
 }
@@ -131,13 +133,31 @@ func (r *DeviceRepositoryPostgres) List(ctx context.Context, filter *domain.Devi
 	if len(opt) > 0 {
 		paginationConfig = opt[0]
 	}
+	if filter == nil {
+		filter = &domain.DeviceFilter{}
+	}
 
 	queryBuilder := func(tx *gorm.DB) *gorm.DB {
+		if filter.Deleted {
+			tx = tx.Unscoped().Where("deleted_at IS NOT NULL")
+		}
 		if filter.Name != nil {
-			tx = tx.Where("name ILIKE ?", filter.Name)
+			tx = tx.Where("name ILIKE ?", "%"+*filter.Name+"%")
+		}
+		if len(filter.Status) > 0 {
+			tx = tx.Where("status IN ?", filter.Status)
+		}
+		if len(filter.Tags) > 0 {
+			tx = tx.Where("tags @> ?", pq.StringArray(filter.Tags))
+		}
+		if len(filter.Capabilities) > 0 {
+			tx = tx.Where("capabilities @> ?", pq.StringArray(filter.Capabilities))
+		}
+		if filter.OwnerID != nil {
+			tx = tx.Where("owner_id = ?", *filter.OwnerID)
 		}
-		if filter.Status != nil {
-			tx = tx.Where("status = ?", filter.Status)
+		if filter.Manufacturer != nil {
+			tx = tx.Where("manufacturer = ?", *filter.Manufacturer)
 		}
 		return tx
 	}
@@ -154,7 +174,7 @@ func (r *DeviceRepositoryPostgres) List(ctx context.Context, filter *domain.Devi
 	var devices []model.Device
 	if err := queryBuilder(r.db.WithContext(ctx)).Find(&devices).Error; err != nil {
 		r.logger.Debug("Failed to list devices without pagination", zap.Error(err))
-		return nil, 0, err
+		return nil, 0, apperror.MapDBError(err, domain.EntityDevice)
 	}
 
 	return utils.ConvertVectorToPointerVector(devices), int64(len(devices)), nil
@@ -214,7 +234,7 @@ func (r *DeviceRepositoryPostgres) SearchByName(ctx context.Context, searchStr s
 
 func (r *DeviceRepositoryPostgres) SearchByTags(ctx context.Context, tags []string, paginationConfig *pagination.Pagination) ([]*model.Device, int64, error) {
 	queryBuilder := func(tx *gorm.DB) *gorm.DB {
-		return tx.Where("tags @> ?", tags)
+		return tx.Where("tags @> ?", pq.StringArray(tags))
 	}
 
 	devices, totalDevice, err := FindWithPagination[model.Device](ctx, r.db, paginationConfig, queryBuilder, r.logger)
@@ -227,7 +247,7 @@ func (r *DeviceRepositoryPostgres) SearchByTags(ctx context.Context, tags []stri
 
 func (r *DeviceRepositoryPostgres) SearchByCapabilities(ctx context.Context, capabilities []string, paginationConfig *pagination.Pagination) ([]*model.Device, int64, error) {
 	queryBuilder := func(tx *gorm.DB) *gorm.DB {
-		return tx.Where("capabilities @> ?", capabilities)
+		return tx.Where("capabilities @> ?", pq.StringArray(capabilities))
 	}
 
 	devices, totalDevice, err := FindWithPagination[model.Device](ctx, r.db, paginationConfig, queryBuilder, r.logger)
@@ -251,15 +271,80 @@ func (r *DeviceRepositoryPostgres) AssignOwner(ctx context.Context, deviceID uui
 	return nil
 }
 
-func (r *DeviceRepositoryPostgres) UpdateStatus(ctx context.Context, deviceID uuid.UUID, newStatus model.DeviceStatus) error {
-	tx := r.db.WithContext(ctx).Model(&model.Device{}).Where("id = ?", deviceID).Update("status", newStatus)
+func (r *DeviceRepositoryPostgres) TransitionStatus(ctx context.Context, change *model.DeviceStatusChange) (*model.Device, error) {
+	var device *model.Device
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		device, err = transitionDeviceStatus(tx, change)
+		return err
+	})
+	if err != nil {
+		r.logger.Debug("Failed to transition device status", zap.Error(err), zap.String("device_id", change.DeviceID.String()), zap.String("status", string(change.ToStatus)))
+		return nil, err
+	}
+	return device, nil
+}
+
+func (r *DeviceRepositoryPostgres) ListStatusChanges(ctx context.Context, deviceID uuid.UUID, paginationOpt *pagination.Pagination) ([]*model.DeviceStatusChange, int64, error) {
+	queryBuilder := func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("device_id = ?", deviceID)
+	}
+
+	if paginationOpt == nil {
+		paginationOpt = &pagination.Pagination{}
+	}
+	if paginationOpt.SortBy == "" {
+		paginationOpt.SortBy = "changed_at"
+		paginationOpt.SortOrder = "desc"
+	}
+
+	changes, count, err := FindWithPagination[model.DeviceStatusChange](ctx, r.db, paginationOpt, queryBuilder, r.logger)
+	if err != nil {
+		return nil, 0, apperror.MapDBError(err, domain.EntityDeviceStatusChange)
+	}
+	return utils.ConvertVectorToPointerVector(changes), count, nil
+}
+
+// transitionDeviceStatus moves the device to change.ToStatus and records the change, within the transaction tx. The
+// device row stays locked until tx ends so concurrent transitions are checked one after the other. Nothing is
+// recorded when the device already has the status.
+func transitionDeviceStatus(tx *gorm.DB, change *model.DeviceStatusChange) (*model.Device, error) {
+	var device model.Device
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", change.DeviceID).First(&device).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityDevice)
+	}
+	change.FromStatus = device.Status
+	if change.FromStatus == change.ToStatus {
+		return &device, nil
+	}
+	if !model.CanTransitionDeviceStatus(change.FromStatus, change.ToStatus) {
+		return nil, apperror.ErrConflict.
+			WithMessagef("device cannot go from %s to %s", change.FromStatus, change.ToStatus).
+			WithDetails(map[string]interface{}{"allowed": model.NextDeviceStatuses(change.FromStatus)})
+	}
+
+	if change.ChangedAt.IsZero() {
+		change.ChangedAt = time.Now()
+	}
+	if err := tx.Model(&device).Update("status", change.ToStatus).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityDevice)
+	}
+	if err := tx.Create(change).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityDeviceStatusChange)
+	}
+	device.Status = change.ToStatus
+	return &device, nil
+}
+
+func (r *DeviceRepositoryPostgres) UpdateCommandVersions(ctx context.Context, deviceID uuid.UUID, versions map[string]int) error {
+	tx := r.db.WithContext(ctx).Model(&model.Device{}).Where("id = ?", deviceID).Update("command_versions", datatypes.NewJSONType(versions))
 	if tx.Error != nil {
-		r.logger.Debug("Failed to update device status", zap.Error(tx.Error), zap.String("device_id", deviceID.String()), zap.String("status", string(newStatus)))
+		r.logger.Debug("Failed to update command versions", zap.Error(tx.Error), zap.String("device_id", deviceID.String()))
 		return apperror.MapDBError(tx.Error, domain.EntityDevice)
 	}
 	if tx.RowsAffected == 0 {
-		r.logger.Debug("Failed to update device status: no matching record found", zap.String("device_id", deviceID.String()), zap.String("status", string(newStatus)))
-		return apperror.ErrNotFound.WithMessagef("update device status operation failed: no matching %s found", domain.EntityDevice)
+		r.logger.Debug("Failed to update command versions: no matching record found", zap.String("device_id", deviceID.String()))
+		return apperror.ErrNotFound.WithMessagef("update command versions operation failed: no matching %s found", domain.EntityDevice)
 	}
 	return nil
 }
@@ -279,8 +364,8 @@ func (r *DeviceRepositoryPostgres) UpdateLastConnected(ctx context.Context, devi
 
 func (r *DeviceRepositoryPostgres) CountByStatus(ctx context.Context) (map[model.DeviceStatus]int64, error) {
 	type countResult struct {
-		StatusName model.DeviceStatus
-		Count      int64
+		Status model.DeviceStatus
+		Count  int64
 	}
 
 	var results []countResult
@@ -292,7 +377,7 @@ func (r *DeviceRepositoryPostgres) CountByStatus(ctx context.Context) (map[model
 	// restructure
 	StatusCountMap := make(map[model.DeviceStatus]int64, len(results))
 	for _, r := range results {
-		StatusCountMap[r.StatusName] = r.Count
+		StatusCountMap[r.Status] = r.Count
 	}
 	return StatusCountMap, nil
 }
@@ -310,20 +395,7 @@ func (r *DeviceRepositoryPostgres) ExistByMACAddr(ctx context.Context, macAddr s
 	if err := r.db.WithContext(ctx).Model(&model.Device{}).Where("mac_address = ?", macAddr).Count(&count).Error; err != nil {
 		return false, apperror.MapDBError(err, domain.EntityDevice)
 	}
-	return count > 1, nil
-}
-
-func (r *DeviceRepositoryPostgres) MarkAsProvisioned(ctx context.Context, deviceID uuid.UUID) error {
-	tx := r.db.WithContext(ctx).Model(&model.Device{}).Where("id = ?", deviceID).Update("status", model.DeviceStatusProvisioned)
-	if tx.Error != nil {
-		r.logger.Debug("Failed to mark device as provisioned", zap.Error(tx.Error), zap.String("device_id", deviceID.String()))
-		return apperror.MapDBError(tx.Error, domain.EntityDevice)
-	}
-	if tx.RowsAffected == 0 {
-		r.logger.Debug("Failed to mark device as provisioned: no matching record found", zap.String("device_id", deviceID.String()))
-		return apperror.ErrNotFound.WithMessagef("mark device as provisioned operation failed: no matching %s found", domain.EntityDevice)
-	}
-	return nil
+	return count > 0, nil
 }
 
 // func (r *DeviceRepositoryPostgres) GetDeviceCountTransaction(tx *gorm.DB) (int64, error) {
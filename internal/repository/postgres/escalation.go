@@ -0,0 +1,233 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type EscalationRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewEscalationRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.EscalationRepository {
+	return &EscalationRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "EscalationRepositoryPostgres"),
+	}
+}
+
+func (r *EscalationRepositoryPostgres) CreatePolicy(ctx context.Context, policy *model.EscalationPolicy) error {
+	if err := r.db.WithContext(ctx).Create(policy).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityEscalationPolicy)
+	}
+	return nil
+}
+
+func (r *EscalationRepositoryPostgres) UpdatePolicy(ctx context.Context, policy *model.EscalationPolicy) error {
+	if err := r.db.WithContext(ctx).Save(policy).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityEscalationPolicy)
+	}
+	return nil
+}
+
+func (r *EscalationRepositoryPostgres) DeletePolicy(ctx context.Context, policyID uuid.UUID) error {
+	tx := r.db.WithContext(ctx).Where("id = ?", policyID).Delete(&model.EscalationPolicy{})
+	if tx.Error != nil {
+		return apperror.MapDBError(tx.Error, domain.EntityEscalationPolicy)
+	}
+	if tx.RowsAffected == 0 {
+		return apperror.ErrNotFound.WithMessagef("%s not found", domain.EntityEscalationPolicy)
+	}
+	return nil
+}
+
+func (r *EscalationRepositoryPostgres) GetPolicy(ctx context.Context, policyID uuid.UUID) (*model.EscalationPolicy, error) {
+	var policy model.EscalationPolicy
+	if err := r.db.WithContext(ctx).First(&policy, "id = ?", policyID).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityEscalationPolicy)
+	}
+	return &policy, nil
+}
+
+func (r *EscalationRepositoryPostgres) ListPolicies(ctx context.Context) ([]*model.EscalationPolicy, error) {
+	var policies []*model.EscalationPolicy
+	if err := r.db.WithContext(ctx).Order("priority DESC, created_at ASC").Find(&policies).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityEscalationPolicy)
+	}
+	return policies, nil
+}
+
+func (r *EscalationRepositoryPostgres) ListEnabledPolicies(ctx context.Context) ([]*model.EscalationPolicy, error) {
+	var policies []*model.EscalationPolicy
+	err := r.db.WithContext(ctx).Where("enabled = ?", true).Order("priority DESC, created_at ASC").Find(&policies).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityEscalationPolicy)
+	}
+	return policies, nil
+}
+
+func (r *EscalationRepositoryPostgres) StartEscalation(ctx context.Context, escalation *model.AlertEscalation) error {
+	// a reopened alert keeps its id, its cancelled or completed escalation starts over from the first tier
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "alert_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"policy_id":        escalation.PolicyID,
+			"status":           model.EscalationStatusPending,
+			"next_tier":        0,
+			"next_at":          escalation.NextAt,
+			"started_at":       escalation.StartedAt,
+			"last_notified_at": nil,
+			"updated_at":       time.Now(),
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Neq{Column: clause.Column{Table: "alert_escalations", Name: "status"}, Value: model.EscalationStatusPending},
+		}},
+	}).Create(escalation).Error
+	if err != nil {
+		return apperror.MapDBError(err, domain.EntityAlertEscalation)
+	}
+	return nil
+}
+
+func (r *EscalationRepositoryPostgres) UpdateEscalation(ctx context.Context, escalation *model.AlertEscalation) error {
+	if err := r.db.WithContext(ctx).Save(escalation).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityAlertEscalation)
+	}
+	return nil
+}
+
+func (r *EscalationRepositoryPostgres) GetEscalation(ctx context.Context, alertID uuid.UUID) (*model.AlertEscalation, error) {
+	var escalation model.AlertEscalation
+	err := r.db.WithContext(ctx).First(&escalation, "alert_id = ?", alertID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityAlertEscalation)
+	}
+	return &escalation, nil
+}
+
+func (r *EscalationRepositoryPostgres) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.AlertEscalation, error) {
+	var escalations []*model.AlertEscalation
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_at <= ?", model.EscalationStatusPending, now).
+			Order("next_at ASC").
+			Limit(limit).
+			Find(&escalations).Error
+		if err != nil || len(escalations) == 0 {
+			return err
+		}
+
+		alertIDs := make([]uuid.UUID, len(escalations))
+		for i, escalation := range escalations {
+			alertIDs[i] = escalation.AlertID
+		}
+		// a crashed worker's claims come due again once the lease ran out
+		return tx.Model(&model.AlertEscalation{}).Where("alert_id IN ?", alertIDs).
+			Update("next_at", now.Add(lease)).Error
+	})
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityAlertEscalation)
+	}
+	return escalations, nil
+}
+
+func (r *EscalationRepositoryPostgres) CancelEscalation(ctx context.Context, alertID uuid.UUID) error {
+	err := r.db.WithContext(ctx).Model(&model.AlertEscalation{}).
+		Where("alert_id = ? AND status = ?", alertID, model.EscalationStatusPending).
+		Update("status", model.EscalationStatusCancelled).Error
+	if err != nil {
+		return apperror.MapDBError(err, domain.EntityAlertEscalation)
+	}
+	return nil
+}
+
+type OnCallRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewOnCallRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.OnCallRepository {
+	return &OnCallRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "OnCallRepositoryPostgres"),
+	}
+}
+
+func (r *OnCallRepositoryPostgres) CreateSchedule(ctx context.Context, schedule *model.OnCallSchedule) error {
+	if err := r.db.WithContext(ctx).Omit(clause.Associations).Create(schedule).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityOnCallSchedule)
+	}
+	return nil
+}
+
+func (r *OnCallRepositoryPostgres) UpdateSchedule(ctx context.Context, schedule *model.OnCallSchedule) error {
+	if err := r.db.WithContext(ctx).Omit(clause.Associations).Save(schedule).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityOnCallSchedule)
+	}
+	return nil
+}
+
+func (r *OnCallRepositoryPostgres) DeleteSchedule(ctx context.Context, scheduleID uuid.UUID) error {
+	tx := r.db.WithContext(ctx).Where("id = ?", scheduleID).Delete(&model.OnCallSchedule{})
+	if tx.Error != nil {
+		return apperror.MapDBError(tx.Error, domain.EntityOnCallSchedule)
+	}
+	if tx.RowsAffected == 0 {
+		return apperror.ErrNotFound.WithMessagef("%s not found", domain.EntityOnCallSchedule)
+	}
+	return nil
+}
+
+func (r *OnCallRepositoryPostgres) GetSchedule(ctx context.Context, scheduleID uuid.UUID) (*model.OnCallSchedule, error) {
+	var schedule model.OnCallSchedule
+	err := r.db.WithContext(ctx).
+		Preload("Overrides", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("ends_at > ?", time.Now()).Order("starts_at ASC")
+		}).
+		First(&schedule, "id = ?", scheduleID).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityOnCallSchedule)
+	}
+	return &schedule, nil
+}
+
+func (r *OnCallRepositoryPostgres) ListSchedules(ctx context.Context) ([]*model.OnCallSchedule, error) {
+	var schedules []*model.OnCallSchedule
+	if err := r.db.WithContext(ctx).Order("name ASC").Find(&schedules).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityOnCallSchedule)
+	}
+	return schedules, nil
+}
+
+func (r *OnCallRepositoryPostgres) AddOverride(ctx context.Context, override *model.OnCallOverride) error {
+	if err := r.db.WithContext(ctx).Create(override).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityOnCallOverride)
+	}
+	return nil
+}
+
+func (r *OnCallRepositoryPostgres) DeleteOverride(ctx context.Context, scheduleID uuid.UUID, overrideID uuid.UUID) error {
+	tx := r.db.WithContext(ctx).Where("id = ? AND schedule_id = ?", overrideID, scheduleID).Delete(&model.OnCallOverride{})
+	if tx.Error != nil {
+		return apperror.MapDBError(tx.Error, domain.EntityOnCallOverride)
+	}
+	if tx.RowsAffected == 0 {
+		return apperror.ErrNotFound.WithMessagef("%s not found", domain.EntityOnCallOverride)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sumRejectedSQL adds the rejection counts per reason of the stored row and the rolled up one.
+const sumRejectedSQL = `(SELECT COALESCE(jsonb_object_agg(reason, COALESCE((device_ingest_stats.rejected ->> reason)::bigint, 0) + COALESCE((EXCLUDED.rejected ->> reason)::bigint, 0)), '{}'::jsonb)
+	FROM jsonb_object_keys(COALESCE(device_ingest_stats.rejected, '{}'::jsonb) || COALESCE(EXCLUDED.rejected, '{}'::jsonb)) AS reason)`
+
+const avgInterArrivalSQL = `CASE WHEN device_ingest_stats.inter_arrival_count + EXCLUDED.inter_arrival_count = 0 THEN 0
+	ELSE (device_ingest_stats.inter_arrival_sum_ms + EXCLUDED.inter_arrival_sum_ms)::float8 / (device_ingest_stats.inter_arrival_count + EXCLUDED.inter_arrival_count) END`
+
+type IngestStatsRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewIngestStatsRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.IngestStatsRepository {
+	return &IngestStatsRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "IngestStatsRepositoryPostgres"),
+	}
+}
+
+func (r *IngestStatsRepositoryPostgres) Upsert(ctx context.Context, stats []*model.DeviceIngestStats) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	// the rows hold the counts since the previous rollup, they add up with what is stored
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "device_id"}, {Name: "sensor_key"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "received"}, Value: gorm.Expr("device_ingest_stats.received + EXCLUDED.received")},
+			{Column: clause.Column{Name: "accepted"}, Value: gorm.Expr("device_ingest_stats.accepted + EXCLUDED.accepted")},
+			{Column: clause.Column{Name: "rejected"}, Value: gorm.Expr(sumRejectedSQL)},
+			{Column: clause.Column{Name: "bytes"}, Value: gorm.Expr("device_ingest_stats.bytes + EXCLUDED.bytes")},
+			{Column: clause.Column{Name: "first_message_at"}, Value: gorm.Expr("LEAST(device_ingest_stats.first_message_at, EXCLUDED.first_message_at)")},
+			{Column: clause.Column{Name: "last_message_at"}, Value: gorm.Expr("GREATEST(device_ingest_stats.last_message_at, EXCLUDED.last_message_at)")},
+			{Column: clause.Column{Name: "inter_arrival_sum_ms"}, Value: gorm.Expr("device_ingest_stats.inter_arrival_sum_ms + EXCLUDED.inter_arrival_sum_ms")},
+			{Column: clause.Column{Name: "inter_arrival_count"}, Value: gorm.Expr("device_ingest_stats.inter_arrival_count + EXCLUDED.inter_arrival_count")},
+			{Column: clause.Column{Name: "avg_inter_arrival_ms"}, Value: gorm.Expr(avgInterArrivalSQL)},
+			{Column: clause.Column{Name: "rolled_up_at"}, Value: gorm.Expr("EXCLUDED.rolled_up_at")},
+		},
+	}).CreateInBatches(stats, 100).Error
+	if err != nil {
+		return apperror.MapDBError(err, domain.EntityIngestStats)
+	}
+	return nil
+}
+
+func (r *IngestStatsRepositoryPostgres) ListByDeviceID(ctx context.Context, deviceID uuid.UUID) ([]*model.DeviceIngestStats, error) {
+	var stats []*model.DeviceIngestStats
+	if err := r.db.WithContext(ctx).Where("device_id = ?", deviceID).Order("sensor_key").Find(&stats).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityIngestStats)
+	}
+	return stats, nil
+}
@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type LeaseRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewLeaseRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.LeaseRepository {
+	return &LeaseRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "LeaseRepositoryPostgres"),
+	}
+}
+
+// Acquire inserts the lease or takes it over when the holder renews it or it expired, in a single statement so two
+// instances can't both win.
+func (r *LeaseRepositoryPostgres) Acquire(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	tx := r.db.WithContext(ctx).Exec(`
+		INSERT INTO leases (name, holder, expires_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at, updated_at = EXCLUDED.updated_at
+		WHERE leases.holder = EXCLUDED.holder OR leases.expires_at < ?`,
+		name, holder, now.Add(ttl), now, now)
+	if tx.Error != nil {
+		return false, apperror.MapDBError(tx.Error, domain.EntityLease)
+	}
+	return tx.RowsAffected == 1, nil
+}
+
+func (r *LeaseRepositoryPostgres) Release(ctx context.Context, name string, holder string) error {
+	if err := r.db.WithContext(ctx).Where("name = ? AND holder = ?", name, holder).Delete(&model.Lease{}).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityLease)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MaintenanceRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewMaintenanceRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.MaintenanceRepository {
+	return &MaintenanceRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "MaintenanceRepositoryPostgres"),
+	}
+}
+
+func (r *MaintenanceRepositoryPostgres) Create(ctx context.Context, window *model.MaintenanceWindow) error {
+	if err := r.db.WithContext(ctx).Create(window).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityMaintenanceWindow)
+	}
+	return nil
+}
+
+func (r *MaintenanceRepositoryPostgres) Update(ctx context.Context, window *model.MaintenanceWindow) error {
+	if err := r.db.WithContext(ctx).Save(window).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityMaintenanceWindow)
+	}
+	return nil
+}
+
+func (r *MaintenanceRepositoryPostgres) Delete(ctx context.Context, windowID uuid.UUID) error {
+	tx := r.db.WithContext(ctx).Where("id = ?", windowID).Delete(&model.MaintenanceWindow{})
+	if tx.Error != nil {
+		return apperror.MapDBError(tx.Error, domain.EntityMaintenanceWindow)
+	}
+	if tx.RowsAffected == 0 {
+		return apperror.ErrNotFound.WithMessagef("%s not found", domain.EntityMaintenanceWindow)
+	}
+	return nil
+}
+
+func (r *MaintenanceRepositoryPostgres) GetByID(ctx context.Context, windowID uuid.UUID) (*model.MaintenanceWindow, error) {
+	var window model.MaintenanceWindow
+	if err := r.db.WithContext(ctx).First(&window, "id = ?", windowID).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityMaintenanceWindow)
+	}
+	return &window, nil
+}
+
+func (r *MaintenanceRepositoryPostgres) List(ctx context.Context, deviceID *uuid.UUID) ([]*model.MaintenanceWindow, error) {
+	tx := r.db.WithContext(ctx).Order("starts_at DESC")
+	if deviceID != nil {
+		tx = tx.Where("device_id = ?", *deviceID)
+	}
+	var windows []*model.MaintenanceWindow
+	if err := tx.Find(&windows).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityMaintenanceWindow)
+	}
+	return windows, nil
+}
+
+func (r *MaintenanceRepositoryPostgres) ListCurrent(ctx context.Context, now time.Time) ([]*model.MaintenanceWindow, error) {
+	var windows []*model.MaintenanceWindow
+	err := r.db.WithContext(ctx).
+		Where("(recurrence = ? AND ends_at > ?) OR (recurrence <> ? AND (recur_until IS NULL OR recur_until > ?))",
+			model.MaintenanceRecurrenceNone, now, model.MaintenanceRecurrenceNone, now.Add(-7*24*time.Hour)).
+		Find(&windows).Error
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityMaintenanceWindow)
+	}
+
+	// the query over-selects recurring windows, their last occurrence depends on the duration
+	current := windows[:0]
+	for _, window := range windows {
+		if !window.Over(now) {
+			current = append(current, window)
+		}
+	}
+	return current, nil
+}
+
+func (r *MaintenanceRepositoryPostgres) DevicesInScope(ctx context.Context, window *model.MaintenanceWindow) ([]*model.Device, error) {
+	tx := r.db.WithContext(ctx).Model(&model.Device{})
+	switch window.Scope {
+	case model.MaintenanceScopeDevice:
+		tx = tx.Where("id = ?", window.DeviceID)
+	case model.MaintenanceScopeTag:
+		tx = tx.Where("tags @> ?", pq.StringArray{window.Tag})
+	case model.MaintenanceScopeSite:
+		tx = tx.Where("metadata ->> ? = ?", model.DeviceMetadataSiteKey, window.Site)
+	default:
+		return nil, nil
+	}
+	var devices []*model.Device
+	if err := tx.Find(&devices).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityDevice)
+	}
+	return devices, nil
+}
+
+func (r *MaintenanceRepositoryPostgres) ListDeviceMaintenance(ctx context.Context) ([]*model.DeviceMaintenance, error) {
+	var maintenance []*model.DeviceMaintenance
+	if err := r.db.WithContext(ctx).Find(&maintenance).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityDeviceMaintenance)
+	}
+	return maintenance, nil
+}
+
+func (r *MaintenanceRepositoryPostgres) StartDeviceMaintenance(ctx context.Context, maintenance *model.DeviceMaintenance, change *model.DeviceStatusChange) (*model.Device, error) {
+	var device *model.Device
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(maintenance).Error; err != nil {
+			return apperror.MapDBError(err, domain.EntityDeviceMaintenance)
+		}
+		var err error
+		device, err = transitionDeviceStatus(tx, change)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+func (r *MaintenanceRepositoryPostgres) EndDeviceMaintenance(ctx context.Context, maintenance *model.DeviceMaintenance, change *model.DeviceStatusChange) (*model.Device, error) {
+	var device *model.Device
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&model.DeviceMaintenance{}, "device_id = ?", maintenance.DeviceID).Error; err != nil {
+			return apperror.MapDBError(err, domain.EntityDeviceMaintenance)
+		}
+		var status model.DeviceStatus
+		err := tx.Model(&model.Device{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", maintenance.DeviceID).Pluck("status", &status).Error
+		if err != nil {
+			return apperror.MapDBError(err, domain.EntityDevice)
+		}
+		if status != model.DeviceStatusUnderMaintenance {
+			return nil // someone changed the status meanwhile, it's theirs to keep
+		}
+		device, err = transitionDeviceStatus(tx, change)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type NotificationRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewNotificationRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.NotificationRepository {
+	return &NotificationRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "NotificationRepositoryPostgres"),
+	}
+}
+
+func (r *NotificationRepositoryPostgres) CreateDeliveries(ctx context.Context, deliveries []*model.NotificationDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := r.db.WithContext(ctx).Create(&deliveries).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityNotification)
+	}
+	return nil
+}
+
+func (r *NotificationRepositoryPostgres) UpdateDelivery(ctx context.Context, delivery *model.NotificationDelivery) error {
+	if err := r.db.WithContext(ctx).Omit(clause.Associations).Save(delivery).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityNotification)
+	}
+	return nil
+}
+
+func (r *NotificationRepositoryPostgres) AddAttempt(ctx context.Context, attempt *model.NotificationAttempt) error {
+	if err := r.db.WithContext(ctx).Create(attempt).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityNotificationAttempt)
+	}
+	return nil
+}
+
+func (r *NotificationRepositoryPostgres) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.NotificationDelivery, error) {
+	var deliveries []*model.NotificationDelivery
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", model.NotificationStatusPending, now).
+			Order("next_attempt_at ASC").
+			Limit(limit).
+			Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		deliveryIDs := make([]uuid.UUID, len(deliveries))
+		for i, delivery := range deliveries {
+			deliveryIDs[i] = delivery.ID
+		}
+		// a crashed dispatcher's claims come due again once the lease ran out
+		return tx.Model(&model.NotificationDelivery{}).Where("id IN ?", deliveryIDs).
+			Update("next_attempt_at", now.Add(lease)).Error
+	})
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityNotification)
+	}
+	return deliveries, nil
+}
@@ -82,7 +82,7 @@ func FindWithPagination[T any](ctx context.Context, db *gorm.DB, p *pagination.P
 	}
 
 	// apply for updates
-	for options.ForUpdate {
+	if options.ForUpdate {
 		dataTx = dataTx.Clauses(clause.Locking{Strength: "UPDATE"})
 	}
 
@@ -99,7 +99,9 @@ func FindWithPagination[T any](ctx context.Context, db *gorm.DB, p *pagination.P
 
 		select {
 		case err = <-countErrChan:
-			return nil, 0, apperror.MapDBError(err, modelType)
+			if err != nil {
+				return nil, 0, apperror.MapDBError(err, modelType)
+			}
 		case <-ctx.Done():
 			return nil, 0, apperror.ErrContextTimeout.WithMessage("query timed out before completion due to context timeout").Wrap(err)
 		}
@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RuleRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewRuleRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.RuleRepository {
+	return &RuleRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "RuleRepositoryPostgres"),
+	}
+}
+
+func (r *RuleRepositoryPostgres) Create(ctx context.Context, rule *model.Rule) error {
+	if err := r.db.WithContext(ctx).Create(rule).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityRule)
+	}
+	return nil
+}
+
+func (r *RuleRepositoryPostgres) Update(ctx context.Context, rule *model.Rule) error {
+	tx := r.db.WithContext(ctx).Save(rule)
+	if tx.Error != nil {
+		return apperror.MapDBError(tx.Error, domain.EntityRule)
+	}
+	return nil
+}
+
+func (r *RuleRepositoryPostgres) Delete(ctx context.Context, ruleID uuid.UUID) error {
+	tx := r.db.WithContext(ctx).Where("id = ?", ruleID).Delete(&model.Rule{})
+	if tx.Error != nil {
+		return apperror.MapDBError(tx.Error, domain.EntityRule)
+	}
+	if tx.RowsAffected == 0 {
+		return apperror.ErrNotFound.WithMessagef("%s not found", domain.EntityRule)
+	}
+	return nil
+}
+
+func (r *RuleRepositoryPostgres) GetByID(ctx context.Context, ruleID uuid.UUID) (*model.Rule, error) {
+	var rule model.Rule
+	if err := r.db.WithContext(ctx).First(&rule, "id = ?", ruleID).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityRule)
+	}
+	return &rule, nil
+}
+
+func (r *RuleRepositoryPostgres) List(ctx context.Context, deviceID *uuid.UUID) ([]*model.Rule, error) {
+	var rules []*model.Rule
+	tx := r.db.WithContext(ctx).Order("created_at ASC")
+	if deviceID != nil {
+		tx = tx.Where("device_id = ? OR device_id IS NULL", *deviceID)
+	}
+	if err := tx.Find(&rules).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityRule)
+	}
+	return rules, nil
+}
+
+func (r *RuleRepositoryPostgres) ListEnabled(ctx context.Context) ([]*model.Rule, error) {
+	var rules []*model.Rule
+	if err := r.db.WithContext(ctx).Where("enabled = ?", true).Find(&rules).Error; err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityRule)
+	}
+	return rules, nil
+}
+
+type RuleStateRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewRuleStateRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.RuleStateRepository {
+	return &RuleStateRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "RuleStateRepositoryPostgres"),
+	}
+}
+
+func (r *RuleStateRepositoryPostgres) Upsert(ctx context.Context, states []*model.RuleState) error {
+	if len(states) == 0 {
+		return nil
+	}
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "rule_id"}, {Name: "device_id"}},
+		UpdateAll: true,
+	}).CreateInBatches(states, 100).Error
+	if err != nil {
+		return apperror.MapDBError(err, domain.EntityRuleState)
+	}
+	return nil
+}
+
+func (r *RuleStateRepositoryPostgres) Get(ctx context.Context, ruleID uuid.UUID, deviceID uuid.UUID) (*model.RuleState, error) {
+	var state model.RuleState
+	err := r.db.WithContext(ctx).First(&state, "rule_id = ? AND device_id = ?", ruleID, deviceID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, apperror.MapDBError(err, domain.EntityRuleState)
+	}
+	return &state, nil
+}
+
+func (r *RuleStateRepositoryPostgres) DeleteByRuleID(ctx context.Context, ruleID uuid.UUID) error {
+	if err := r.db.WithContext(ctx).Where("rule_id = ?", ruleID).Delete(&model.RuleState{}).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityRuleState)
+	}
+	return nil
+}
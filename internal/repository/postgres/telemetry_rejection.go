@@ -0,0 +1,32 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/vars7899/iots/internal/domain"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type TelemetryRejectionRepositoryPostgres struct {
+	db *gorm.DB
+	l  *zap.Logger
+}
+
+func NewTelemetryRejectionRepositoryPostgres(db *gorm.DB, baseLogger *zap.Logger) repository.TelemetryRejectionRepository {
+	return &TelemetryRejectionRepositoryPostgres{
+		db: db,
+		l:  logger.Named(baseLogger, "TelemetryRejectionRepositoryPostgres"),
+	}
+}
+
+func (r *TelemetryRejectionRepositoryPostgres) Create(ctx context.Context, rejection *model.TelemetryRejection) error {
+	if err := r.db.WithContext(ctx).Create(rejection).Error; err != nil {
+		return apperror.MapDBError(err, domain.EntityTelemetryRejection)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+)
+
+type RuleRepository interface {
+	Create(ctx context.Context, rule *model.Rule) error
+	Update(ctx context.Context, rule *model.Rule) error
+	Delete(ctx context.Context, ruleID uuid.UUID) error
+	GetByID(ctx context.Context, ruleID uuid.UUID) (*model.Rule, error)
+	List(ctx context.Context, deviceID *uuid.UUID) ([]*model.Rule, error) // every rule, only the device's (and fleet wide) ones when deviceID is set
+	ListEnabled(ctx context.Context) ([]*model.Rule, error)               // rules the engine evaluates
+}
+
+type RuleStateRepository interface {
+	Upsert(ctx context.Context, states []*model.RuleState) error                             // save checkpoints
+	Get(ctx context.Context, ruleID uuid.UUID, deviceID uuid.UUID) (*model.RuleState, error) // checkpoint of the rule for the device, nil when there is none
+	DeleteByRuleID(ctx context.Context, ruleID uuid.UUID) error                              // drop checkpoints of a changed or deleted rule
+}
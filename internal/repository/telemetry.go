@@ -3,9 +3,20 @@ package repository
 import (
 	"context"
 
+	"github.com/google/uuid"
+
 	"github.com/vars7899/iots/internal/domain/model"
 )
 
 type TelemetryRepository interface {
 	Ingest(ctx context.Context, telemetryData *model.Telemetry) error
-}
\ No newline at end of file
+}
+
+type IngestStatsRepository interface {
+	Upsert(ctx context.Context, stats []*model.DeviceIngestStats) error                         // add the counters gathered since the previous rollup
+	ListByDeviceID(ctx context.Context, deviceID uuid.UUID) ([]*model.DeviceIngestStats, error) // rolled up counters of every sensor of the device
+}
+
+type TelemetryRejectionRepository interface {
+	Create(ctx context.Context, rejection *model.TelemetryRejection) error // record a refused telemetry payload
+}
@@ -0,0 +1,105 @@
+package rules
+
+import (
+	"time"
+
+	"github.com/vars7899/iots/internal/domain/model"
+)
+
+type Transition int
+
+const (
+	TransitionNone    Transition = iota
+	TransitionFired              // conditions held for the rule duration
+	TransitionCleared            // a fired rule stopped matching
+)
+
+// State is the evaluation state of one rule for one device, it is serialized as is for checkpoints.
+type State struct {
+	Conditions    map[string]*ConditionState `json:"conditions"`
+	MatchingSince time.Time                  `json:"matching_since,omitempty"`
+	Active        bool                       `json:"active"`
+	FiredAt       time.Time                  `json:"fired_at,omitempty"`
+}
+
+type Evaluation struct {
+	Matched    bool
+	Transition Transition
+	Values     map[string]float64 // condition id -> evaluated value, conditions without data are left out
+}
+
+func NewState() *State {
+	return &State{Conditions: make(map[string]*ConditionState)}
+}
+
+// Observe feeds a reading of the sensor to the conditions on it and reports whether any condition used it.
+func (st *State) Observe(def *model.RuleDefinition, sensorCode string, value func(field string) (float64, bool), at time.Time) bool {
+	used := false
+	for i := range def.Conditions {
+		cond := &def.Conditions[i]
+		if cond.SensorCode != sensorCode {
+			continue
+		}
+		v, ok := value(cond.Field)
+		if !ok {
+			continue
+		}
+		st.condition(cond.ID).Add(cond, Sample{At: at, Value: v})
+		used = true
+	}
+	return used
+}
+
+// Evaluate checks the conditions at now and moves the rule between matching, fired and cleared.
+func (st *State) Evaluate(def *model.RuleDefinition, now time.Time) *Evaluation {
+	eval := &Evaluation{Values: make(map[string]float64, len(def.Conditions))}
+
+	matchAny := def.Match == model.RuleMatchAny
+	matched := !matchAny
+	for i := range def.Conditions {
+		cond := &def.Conditions[i]
+		value, ok := st.condition(cond.ID).Value(cond, now)
+		holds := ok && cond.Compare(value)
+		if ok {
+			eval.Values[cond.ID] = value
+		}
+		if matchAny && holds {
+			matched = true
+		}
+		if !matchAny && !holds {
+			matched = false
+		}
+	}
+	eval.Matched = matched
+
+	if !matched {
+		st.MatchingSince = time.Time{}
+		if st.Active {
+			st.Active = false
+			eval.Transition = TransitionCleared
+		}
+		return eval
+	}
+
+	if st.MatchingSince.IsZero() {
+		st.MatchingSince = now
+	}
+	if !st.Active && now.Sub(st.MatchingSince) >= def.For() {
+		st.Active = true
+		st.FiredAt = now
+		eval.Transition = TransitionFired
+	}
+	return eval
+}
+
+func (st *State) condition(id string) *ConditionState {
+	if st.Conditions == nil {
+		st.Conditions = make(map[string]*ConditionState)
+	}
+	cs, ok := st.Conditions[id]
+	if !ok {
+		cs = &ConditionState{}
+		st.Conditions[id] = cs
+	}
+	return cs
+}
@@ -0,0 +1,102 @@
+package rules_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/rules"
+)
+
+func reading(v float64) func(string) (float64, bool) {
+	return func(string) (float64, bool) { return v, true }
+}
+
+func TestRuleFiresAfterDurationAcrossSensors(t *testing.T) {
+	def := &model.RuleDefinition{
+		ForSeconds: 600,
+		Conditions: []model.RuleCondition{
+			{ID: "co2", SensorCode: "co2", Field: "1", Operator: model.RuleOperatorGt, Value: 1200},
+			{ID: "motion", SensorCode: "motion", Field: "1", Window: &model.RuleWindow{Type: model.RuleWindowSliding, DurationSeconds: 60}, Aggregate: model.RuleAggregateMax, Operator: model.RuleOperatorGte, Value: 1},
+		},
+		Action: model.RuleAction{Type: model.RuleActionAlert},
+	}
+	require.NoError(t, def.Validate())
+
+	st := rules.NewState()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	st.Observe(def, "co2", reading(1300), start)
+	assert.False(t, st.Evaluate(def, start).Matched, "no motion reading yet")
+
+	// both hold from here on, the rule fires only once they held for ten minutes
+	for i := 0; i <= 10; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		st.Observe(def, "motion", reading(1), at)
+		st.Observe(def, "co2", reading(1300), at)
+		eval := st.Evaluate(def, at)
+		require.True(t, eval.Matched)
+		if i < 10 {
+			assert.Equal(t, rules.TransitionNone, eval.Transition, "minute %d", i)
+		} else {
+			assert.Equal(t, rules.TransitionFired, eval.Transition)
+			assert.Equal(t, 1300.0, eval.Values["co2"])
+		}
+	}
+
+	// the motion window empties out, the rule clears
+	at := start.Add(12 * time.Minute)
+	st.Observe(def, "co2", reading(1300), at)
+	eval := st.Evaluate(def, at)
+	assert.False(t, eval.Matched)
+	assert.Equal(t, rules.TransitionCleared, eval.Transition)
+}
+
+func TestSlidingDeltaAndTumblingAverage(t *testing.T) {
+	rise := &model.RuleDefinition{
+		Conditions: []model.RuleCondition{
+			{ID: "rise", SensorCode: "temp", Field: "1", Window: &model.RuleWindow{Type: model.RuleWindowSliding, DurationSeconds: 120}, Aggregate: model.RuleAggregateDelta, Operator: model.RuleOperatorGt, Value: 5},
+		},
+		Action: model.RuleAction{Type: model.RuleActionEvent},
+	}
+	st := rules.NewState()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	st.Observe(rise, "temp", reading(20), start)
+	st.Observe(rise, "temp", reading(24), start.Add(time.Minute))
+	assert.False(t, st.Evaluate(rise, start.Add(time.Minute)).Matched)
+
+	// 20 dropped out of the window, the rise is measured from 24
+	st.Observe(rise, "temp", reading(27), start.Add(150*time.Second))
+	eval := st.Evaluate(rise, start.Add(150*time.Second))
+	assert.False(t, eval.Matched)
+	assert.Equal(t, 3.0, eval.Values["rise"])
+
+	st.Observe(rise, "temp", reading(30), start.Add(170*time.Second))
+	eval = st.Evaluate(rise, start.Add(170*time.Second))
+	assert.Equal(t, rules.TransitionFired, eval.Transition)
+	assert.Equal(t, 6.0, eval.Values["rise"])
+
+	avg := &model.RuleDefinition{
+		Conditions: []model.RuleCondition{
+			{ID: "avg", SensorCode: "temp", Field: "1", Window: &model.RuleWindow{Type: model.RuleWindowTumbling, DurationSeconds: 60}, Aggregate: model.RuleAggregateAvg, Operator: model.RuleOperatorGt, Value: 25},
+		},
+		Action: model.RuleAction{Type: model.RuleActionEvent},
+	}
+	st = rules.NewState()
+	st.Observe(avg, "temp", reading(20), start)
+	st.Observe(avg, "temp", reading(40), start.Add(30*time.Second))
+	assert.False(t, st.Evaluate(avg, start.Add(30*time.Second)).Matched, "bucket is still open")
+
+	// the first reading of the next bucket closes the previous one
+	st.Observe(avg, "temp", reading(10), start.Add(70*time.Second))
+	eval = st.Evaluate(avg, start.Add(70*time.Second))
+	assert.True(t, eval.Matched)
+	assert.Equal(t, 30.0, eval.Values["avg"])
+
+	// a late reading of the closed bucket is ignored
+	st.Observe(avg, "temp", reading(100), start.Add(50*time.Second))
+	assert.Equal(t, 30.0, st.Evaluate(avg, start.Add(70*time.Second)).Values["avg"])
+}
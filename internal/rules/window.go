@@ -0,0 +1,178 @@
+package rules
+
+import (
+	"sort"
+	"time"
+
+	"github.com/vars7899/iots/internal/domain/model"
+)
+
+// MaxWindowSamples bounds the readings a sliding window keeps, the oldest are dropped first.
+var MaxWindowSamples = 10000
+
+type Sample struct {
+	At    time.Time `json:"at"`
+	Value float64   `json:"value"`
+}
+
+// Accumulator keeps running aggregates, so tumbling windows don't need their readings.
+type Accumulator struct {
+	Count int     `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	First Sample  `json:"first"`
+	Last  Sample  `json:"last"`
+}
+
+func (a *Accumulator) Add(s Sample) {
+	if a.Count == 0 {
+		a.Min, a.Max, a.First, a.Last = s.Value, s.Value, s, s
+	} else {
+		if s.Value < a.Min {
+			a.Min = s.Value
+		}
+		if s.Value > a.Max {
+			a.Max = s.Value
+		}
+		if s.At.Before(a.First.At) {
+			a.First = s
+		}
+		if !s.At.Before(a.Last.At) {
+			a.Last = s
+		}
+	}
+	a.Count++
+	a.Sum += s.Value
+}
+
+// Aggregate returns the aggregate, false when there isn't enough data for it.
+func (a *Accumulator) Aggregate(aggregate model.RuleAggregate) (float64, bool) {
+	if a.Count == 0 {
+		if aggregate == model.RuleAggregateCount {
+			return 0, true
+		}
+		return 0, false
+	}
+	switch aggregate {
+	case model.RuleAggregateAvg:
+		return a.Sum / float64(a.Count), true
+	case model.RuleAggregateMin:
+		return a.Min, true
+	case model.RuleAggregateMax:
+		return a.Max, true
+	case model.RuleAggregateSum:
+		return a.Sum, true
+	case model.RuleAggregateCount:
+		return float64(a.Count), true
+	case model.RuleAggregateDelta:
+		if a.Count < 2 {
+			return 0, false
+		}
+		return a.Last.Value - a.First.Value, true
+	case model.RuleAggregateRate:
+		span := a.Last.At.Sub(a.First.At).Seconds()
+		if a.Count < 2 || span <= 0 {
+			return 0, false
+		}
+		return (a.Last.Value - a.First.Value) / span, true
+	default:
+		return a.Last.Value, true
+	}
+}
+
+// ConditionState is the window of one condition, only the part matching the window type is used.
+type ConditionState struct {
+	Latest *Sample `json:"latest,omitempty"` // no window
+
+	Samples []Sample `json:"samples,omitempty"` // sliding
+
+	Bucket      *Accumulator `json:"bucket,omitempty"` // tumbling, bucket being filled
+	BucketIndex int64        `json:"bucket_index,omitempty"`
+	Closed      *Accumulator `json:"closed,omitempty"` // tumbling, last completed bucket
+	ClosedIndex int64        `json:"closed_index,omitempty"`
+}
+
+func (cs *ConditionState) Add(cond *model.RuleCondition, s Sample) {
+	if cond.Window == nil {
+		if cs.Latest == nil || !s.At.Before(cs.Latest.At) {
+			cs.Latest = &s
+		}
+		return
+	}
+
+	switch cond.Window.Type {
+	case model.RuleWindowSliding:
+		n := len(cs.Samples)
+		cs.Samples = append(cs.Samples, s)
+		if n > 0 && s.At.Before(cs.Samples[n-1].At) {
+			sort.SliceStable(cs.Samples, func(i, j int) bool { return cs.Samples[i].At.Before(cs.Samples[j].At) })
+		}
+		if len(cs.Samples) > MaxWindowSamples {
+			cs.Samples = cs.Samples[len(cs.Samples)-MaxWindowSamples:]
+		}
+	case model.RuleWindowTumbling:
+		index := bucketIndex(s.At, cond.Window.Duration())
+		cs.roll(index)
+		if (cs.Bucket != nil && index < cs.BucketIndex) || (cs.Closed != nil && index <= cs.ClosedIndex) {
+			return // late reading of a bucket already closed
+		}
+		if cs.Bucket == nil {
+			cs.Bucket = &Accumulator{}
+			cs.BucketIndex = index
+		}
+		cs.Bucket.Add(s)
+	}
+}
+
+// Value evaluates the window at now, false when it holds no usable data.
+func (cs *ConditionState) Value(cond *model.RuleCondition, now time.Time) (float64, bool) {
+	aggregate := cond.AggregateOrDefault()
+	if cond.Window == nil {
+		if cs.Latest == nil {
+			return 0, false
+		}
+		return cs.Latest.Value, true
+	}
+
+	switch cond.Window.Type {
+	case model.RuleWindowSliding:
+		cs.evict(now.Add(-cond.Window.Duration()))
+		acc := &Accumulator{}
+		for _, s := range cs.Samples {
+			if s.At.After(now) {
+				break
+			}
+			acc.Add(s)
+		}
+		return acc.Aggregate(aggregate)
+	case model.RuleWindowTumbling:
+		index := bucketIndex(now, cond.Window.Duration())
+		cs.roll(index)
+		if cs.Closed == nil || cs.ClosedIndex != index-1 {
+			return 0, false // the previous bucket saw no readings
+		}
+		return cs.Closed.Aggregate(aggregate)
+	}
+	return 0, false
+}
+
+// evict drops the samples at or before the window start.
+func (cs *ConditionState) evict(windowStart time.Time) {
+	i := sort.Search(len(cs.Samples), func(i int) bool { return cs.Samples[i].At.After(windowStart) })
+	if i > 0 {
+		cs.Samples = append(cs.Samples[:0], cs.Samples[i:]...)
+	}
+}
+
+// roll closes the bucket being filled once time moved past it.
+func (cs *ConditionState) roll(index int64) {
+	if cs.Bucket != nil && cs.BucketIndex < index {
+		cs.Closed, cs.ClosedIndex = cs.Bucket, cs.BucketIndex
+		cs.Bucket = nil
+	}
+}
+
+func bucketIndex(at time.Time, duration time.Duration) int64 {
+	return at.UnixNano() / int64(duration)
+}
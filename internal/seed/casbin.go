@@ -30,9 +30,47 @@ var permissions = []model.Permission{
 	{Code: "device:create", Name: "Create Devices"},
 	{Code: "device:update", Name: "Update Devices"},
 	{Code: "device:delete", Name: "Delete Devices"},
+	{Code: "device:recover", Name: "Recover Deleted Devices"},
+	{Code: "device:status", Name: "Change Device Status"},
+	{Code: "device:import", Name: "Import Device Manifests"},
+	{Code: "device:export", Name: "Export Device Manifests"},
 	{Code: "device:restart", Name: "Restart Devices"},
 	{Code: "device:firmware:update", Name: "Update Device Firmware"},
 	{Code: "device:session_refresh", Name: "Refresh device session tokens"},
+	{Code: "device:command", Name: "Send Commands to Devices"},
+
+	// Alerts
+	{Code: "alert:read", Name: "Read Alerts"},
+	{Code: "alert:acknowledge", Name: "Acknowledge Alerts"},
+	{Code: "alert:resolve", Name: "Resolve Alerts"},
+	{Code: "alert:comment", Name: "Comment on Alerts"},
+	// Rules
+	{Code: "rule:read", Name: "Read Rules"},
+	{Code: "rule:create", Name: "Create Rules"},
+	{Code: "rule:update", Name: "Update Rules"},
+	{Code: "rule:delete", Name: "Delete Rules"},
+	// Maintenance windows
+	{Code: "maintenance:read", Name: "Read Maintenance Windows"},
+	{Code: "maintenance:create", Name: "Create Maintenance Windows"},
+	{Code: "maintenance:update", Name: "Update Maintenance Windows"},
+	{Code: "maintenance:delete", Name: "Delete Maintenance Windows"},
+	// Escalation policies and on-call schedules
+	{Code: "escalation:read", Name: "Read Escalation Policies and Schedules"},
+	{Code: "escalation:create", Name: "Create Escalation Policies and Schedules"},
+	{Code: "escalation:update", Name: "Update Escalation Policies and Schedules"},
+	{Code: "escalation:delete", Name: "Delete Escalation Policies and Schedules"},
+	// Command schedules
+	{Code: "command_schedule:read", Name: "Read Command Schedules"},
+	{Code: "command_schedule:create", Name: "Create Command Schedules"},
+	{Code: "command_schedule:update", Name: "Update Command Schedules"},
+	{Code: "command_schedule:delete", Name: "Delete Command Schedules"},
+	// Command campaigns
+	{Code: "command_campaign:read", Name: "Read Command Campaigns"},
+	{Code: "command_campaign:create", Name: "Create Command Campaigns"},
+	{Code: "command_campaign:update", Name: "Pause, Resume and Abort Command Campaigns"},
+	// Dead letters of commands and command events
+	{Code: "dead_letter:read", Name: "Read Dead Letters"},
+	{Code: "dead_letter:update", Name: "Edit, Re-drive and Discard Dead Letters"},
 
 	// Location or site management
 	{Code: "location:read", Name: "Read Locations"},
@@ -86,10 +124,18 @@ var rolePermissions = map[string][]string{
 	"admin": {
 		"user:read", "user:create", "user:update", "user:delete",
 		"sensor:read", "sensor:create", "sensor:update", "sensor:delete", "sensor:configure",
-		"device:register", "device:provision", "device:session_refresh",
+		"device:register", "device:provision", "device:session_refresh", "device:read", "device:command",
+		"device:create", "device:update", "device:delete", "device:recover", "device:status", "device:import", "device:export",
+		"alert:read", "alert:acknowledge", "alert:resolve", "alert:comment",
+		"rule:read", "rule:create", "rule:update", "rule:delete",
+		"maintenance:read", "maintenance:create", "maintenance:update", "maintenance:delete",
+		"escalation:read", "escalation:create", "escalation:update", "escalation:delete",
+		"command_schedule:read", "command_schedule:create", "command_schedule:update", "command_schedule:delete",
+		"command_campaign:read", "command_campaign:create", "command_campaign:update",
+		"dead_letter:read", "dead_letter:update",
 	},
 	"viewer": {
-		"user:read", "sensor:read", "sensor:create",
+		"user:read", "sensor:read", "sensor:create", "device:read", "alert:read", "rule:read", "maintenance:read", "escalation:read", "command_schedule:read", "command_campaign:read", "dead_letter:read",
 	},
 	"sensor.read": {
 		"sensor:read",
@@ -0,0 +1,625 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/cache"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/notification"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pagination"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultThresholdCacheTTL  = 30 * time.Second
+	defaultAlertFlapWindow    = 10 * time.Minute
+	defaultAlertFlapThreshold = 3
+)
+
+// AlertEvent is published to the alerts.* subjects.
+type AlertEvent struct {
+	Event     string       `json:"event"` // triggered, acknowledged, flapping, resolved
+	Alert     *model.Alert `json:"alert"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// AlertListener is told about every alert event after it is stored (e.g. to escalate unacknowledged alerts).
+// Listeners run on the caller's path and handle their own errors.
+type AlertListener interface {
+	AlertChanged(ctx context.Context, event string, alert *model.Alert)
+}
+
+type alertKey struct {
+	deviceID  uuid.UUID
+	sensorID  uuid.UUID
+	fieldCode string
+}
+
+// thresholdState tracks one sensor field between readings: pending while a breach waits out the
+// minimum duration, firing while an alert is raised, clearing while a firing alert waits out the clear duration.
+type thresholdState struct {
+	mu            sync.Mutex
+	loaded        bool // active alert looked up in the store, done once per process
+	pendingSince  time.Time
+	clearingSince time.Time
+	alert         *model.Alert
+}
+
+// AlertService evaluates accepted readings against the device's TelemetryConfig.AlertThresholds and raises
+// and auto resolves alerts.
+//
+// Alerts are deduplicated by fingerprint: a source keeps one raised alert and repeated breaches count as occurrences.
+// An alert auto resolved less than the flap window ago is reopened instead of raising a new one, after flapThreshold
+// reopenings within the window it turns flapping and stays raised until its source has been quiet for the window.
+type AlertService struct {
+	alertRepo      repository.AlertRepository
+	deviceRepo     repository.DeviceRepository
+	publisher      pubsub.PubSubPublisher
+	notifier       Notifier
+	listeners      []AlertListener
+	thresholdCache *cache.TTLCache[uuid.UUID, model.AlertThresholds]
+	groupCache     *cache.TTLCache[uuid.UUID, string]
+	groupBy        model.AlertGroupBy
+	flapWindow     time.Duration
+	flapThreshold  int
+	states         map[alertKey]*thresholdState
+	mu             sync.Mutex
+	l              *zap.Logger
+}
+
+func NewAlertService(alertRepo repository.AlertRepository, deviceRepo repository.DeviceRepository, publisher pubsub.PubSubPublisher, notifier Notifier, cfg *config.TelemetryConfig, baseLogger *zap.Logger) *AlertService {
+	l := logger.Named(baseLogger, "AlertService")
+
+	ttl := defaultThresholdCacheTTL
+	flapWindow := defaultAlertFlapWindow
+	flapThreshold := defaultAlertFlapThreshold
+	groupBy := model.AlertGroupByDevice
+	if cfg != nil {
+		if cfg.ThresholdCacheTTL > 0 {
+			ttl = cfg.ThresholdCacheTTL
+		}
+		if cfg.AlertFlapWindow > 0 {
+			flapWindow = cfg.AlertFlapWindow
+		}
+		if cfg.AlertFlapThreshold > 0 {
+			flapThreshold = cfg.AlertFlapThreshold
+		}
+		switch model.AlertGroupBy(cfg.AlertGroupBy) {
+		case "", model.AlertGroupByDevice:
+		case model.AlertGroupBySite:
+			groupBy = model.AlertGroupBySite
+		default:
+			l.Warn("unknown alert grouping, grouping by device", zap.String("alert_group_by", cfg.AlertGroupBy))
+		}
+	}
+	return &AlertService{
+		alertRepo:      alertRepo,
+		deviceRepo:     deviceRepo,
+		publisher:      publisher,
+		notifier:       notifier,
+		thresholdCache: cache.NewTTLCache[uuid.UUID, model.AlertThresholds](ttl, 0),
+		groupCache:     cache.NewTTLCache[uuid.UUID, string](ttl, 0),
+		groupBy:        groupBy,
+		flapWindow:     flapWindow,
+		flapThreshold:  flapThreshold,
+		states:         make(map[alertKey]*thresholdState),
+		l:              l,
+	}
+}
+
+// ObserveTelemetry implements TelemetryObserver.
+func (s *AlertService) ObserveTelemetry(ctx context.Context, reading *TelemetryReading) {
+	thresholds, err := s.deviceThresholds(ctx, reading.DeviceID)
+	if err != nil {
+		s.l.Error("failed to load alert thresholds", zap.String("device_id", reading.DeviceID.String()), zap.Error(err))
+		return
+	}
+
+	for fieldCode, threshold := range thresholds[reading.SensorCode] {
+		value, ok := numericValue(reading.Data[fieldCode])
+		if !ok {
+			continue
+		}
+		key := alertKey{deviceID: reading.DeviceID, sensorID: reading.SensorID, fieldCode: fieldCode}
+		if err := s.evaluate(ctx, key, reading, threshold, value); err != nil {
+			s.l.Error("failed to evaluate alert threshold",
+				zap.String("device_id", reading.DeviceID.String()),
+				zap.String("sensor_id", reading.SensorID.String()),
+				zap.String("field_code", fieldCode),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// InvalidateThresholds drops the cached thresholds of the device, call it after the telemetry config changed.
+// Other instances keep theirs until the threshold cache ttl runs out.
+func (s *AlertService) InvalidateThresholds(deviceID uuid.UUID) {
+	s.thresholdCache.Delete(deviceID)
+}
+
+// DeviceChanged implements DeviceListener, the device update may have changed its alert thresholds.
+func (s *AlertService) DeviceChanged(deviceID uuid.UUID) {
+	s.InvalidateThresholds(deviceID)
+}
+
+func (s *AlertService) deviceThresholds(ctx context.Context, deviceID uuid.UUID) (model.AlertThresholds, error) {
+	if thresholds, ok := s.thresholdCache.Get(deviceID); ok {
+		return thresholds, nil
+	}
+
+	device, err := s.deviceRepo.GetByID(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	thresholds, err := model.ParseAlertThresholds(device.TelemetryConfig.AlertThresholds)
+	if err != nil {
+		// a broken config must not be retried on every reading
+		s.l.Warn("ignoring invalid alert thresholds", zap.String("device_id", deviceID.String()), zap.Error(err))
+		thresholds = model.AlertThresholds{}
+	}
+	s.thresholdCache.Set(deviceID, thresholds)
+	return thresholds, nil
+}
+
+func (s *AlertService) state(key alertKey) *thresholdState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[key]
+	if !ok {
+		st = &thresholdState{}
+		s.states[key] = st
+	}
+	return st
+}
+
+func (s *AlertService) evaluate(ctx context.Context, key alertKey, reading *TelemetryReading, threshold model.AlertThreshold, value float64) error {
+	st := s.state(key)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if !st.loaded {
+		alert, err := s.alertRepo.FindActive(ctx, key.deviceID, key.sensorID, key.fieldCode)
+		if err != nil {
+			return err
+		}
+		st.alert = alert
+		st.loaded = true
+	}
+
+	if st.alert != nil {
+		alert := st.alert
+		if !threshold.Cleared(value) {
+			st.clearingSince = time.Time{}
+			if alert.ClearedAt == nil {
+				return nil
+			}
+			// a quiet flapping alert is back
+			if threshold.Breached(value) && !reading.Maintenance {
+				alert.Occurred(reading.Timestamp, value)
+			} else {
+				alert.ClearedAt = nil
+			}
+			return s.alertRepo.Update(ctx, alert)
+		}
+		if st.clearingSince.IsZero() {
+			st.clearingSince = reading.Timestamp
+		}
+		if reading.Timestamp.Sub(st.clearingSince) < threshold.ClearDuration() {
+			return nil
+		}
+		alert.LastValue = value
+		if alert.Status == model.AlertStatusFlapping {
+			if alert.ClearedAt == nil {
+				clearedAt := st.clearingSince
+				alert.ClearedAt = &clearedAt
+				return s.alertRepo.Update(ctx, alert)
+			}
+			if reading.Timestamp.Sub(*alert.ClearedAt) < s.flapWindow {
+				return nil
+			}
+		}
+		if err := s.autoResolve(ctx, alert, reading.Timestamp, telemetryRef(reading.TelemetryID)); err != nil {
+			return err
+		}
+		st.reset()
+		return nil
+	}
+
+	// nothing triggers during maintenance, a breach still present afterwards starts over
+	if reading.Maintenance || !threshold.Breached(value) {
+		st.pendingSince = time.Time{}
+		return nil
+	}
+	if st.pendingSince.IsZero() {
+		st.pendingSince = reading.Timestamp
+	}
+	if reading.Timestamp.Sub(st.pendingSince) < threshold.MinDuration() {
+		return nil
+	}
+
+	snapshot, _ := json.Marshal(threshold)
+	alert := &model.Alert{
+		DeviceID:     key.deviceID,
+		SensorID:     key.sensorID,
+		SensorCode:   reading.SensorCode,
+		FieldCode:    key.fieldCode,
+		Condition:    threshold.Condition,
+		Threshold:    snapshot,
+		Severity:     threshold.SeverityOrDefault(),
+		Status:       model.AlertStatusOpen,
+		Message:      thresholdMessage(reading.SensorCode, key.fieldCode, threshold, value),
+		TriggerValue: value,
+		LastValue:    value,
+		TriggeredAt:  st.pendingSince,
+		// the reading that crossed the minimum duration, the breach started at TriggeredAt
+		TriggerTelemetryID: telemetryRef(reading.TelemetryID),
+	}
+	alert, err := s.raise(ctx, alert, reading.Timestamp)
+	if err != nil {
+		return err
+	}
+	st.alert = alert
+	return nil
+}
+
+// RaiseRuleAlert opens an alert for a composite rule that fired on the device, the reading is the one that completed
+// the match. While an alert of the rule is still raised for the device the firing counts as another occurrence.
+func (s *AlertService) RaiseRuleAlert(ctx context.Context, rule *model.Rule, action model.RuleAction, reading *TelemetryReading, message string, triggeredAt time.Time) (*model.Alert, error) {
+	severity := action.Severity
+	if severity == "" {
+		severity = model.AlertSeverityWarning
+	}
+	ruleID := rule.ID
+	alert := &model.Alert{
+		DeviceID:           reading.DeviceID,
+		SensorID:           reading.SensorID,
+		SensorCode:         reading.SensorCode,
+		RuleID:             &ruleID,
+		Condition:          model.ConditionRule,
+		Threshold:          rule.Definition,
+		Severity:           severity,
+		Status:             model.AlertStatusOpen,
+		Message:            message,
+		TriggeredAt:        triggeredAt,
+		TriggerTelemetryID: telemetryRef(reading.TelemetryID),
+	}
+	return s.raise(ctx, alert, reading.Timestamp)
+}
+
+// ResolveRuleAlert auto resolves the active alert of the rule for the device, if any. A flapping alert is only marked
+// cleared, ResolveQuietFlapping resolves it once it stayed quiet for the flap window.
+func (s *AlertService) ResolveRuleAlert(ctx context.Context, ruleID uuid.UUID, reading *TelemetryReading) error {
+	alert, err := s.alertRepo.FindActiveByRule(ctx, ruleID, reading.DeviceID)
+	if err != nil || alert == nil {
+		return err
+	}
+	if alert.Status == model.AlertStatusFlapping {
+		if alert.ClearedAt != nil {
+			return nil
+		}
+		clearedAt := reading.Timestamp
+		alert.ClearedAt = &clearedAt
+		return s.alertRepo.Update(ctx, alert)
+	}
+	return s.autoResolve(ctx, alert, reading.Timestamp, telemetryRef(reading.TelemetryID))
+}
+
+// ResolveQuietFlapping auto resolves the flapping alerts whose source has been clear for the flap window, including
+// the ones no reading comes in for anymore.
+func (s *AlertService) ResolveQuietFlapping(ctx context.Context, now time.Time) (int, error) {
+	alerts, err := s.alertRepo.ListQuietFlapping(ctx, now.Add(-s.flapWindow))
+	if err != nil {
+		return 0, err
+	}
+
+	resolved := 0
+	for _, alert := range alerts {
+		ok, err := s.resolveQuiet(ctx, alert.ID, now)
+		if err != nil {
+			return resolved, err
+		}
+		if ok {
+			resolved++
+		}
+	}
+	return resolved, nil
+}
+
+func (s *AlertService) resolveQuiet(ctx context.Context, alertID uuid.UUID, now time.Time) (bool, error) {
+	alert, err := s.alertRepo.GetByID(ctx, alertID)
+	if err != nil {
+		return false, err
+	}
+	st := s.state(alertKey{deviceID: alert.DeviceID, sensorID: alert.SensorID, fieldCode: alert.FieldCode})
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	// re-read under the lock, a reading may have brought it back meanwhile
+	alert, err = s.alertRepo.GetByID(ctx, alertID)
+	if err != nil {
+		return false, err
+	}
+	if alert.Status != model.AlertStatusFlapping || alert.ClearedAt == nil || now.Sub(*alert.ClearedAt) < s.flapWindow {
+		return false, nil
+	}
+	if err := s.autoResolve(ctx, alert, now, nil); err != nil {
+		return false, err
+	}
+	if st.alert != nil && st.alert.ID == alert.ID {
+		st.reset()
+	}
+	return true, nil
+}
+
+// raise stores a breach of the candidate's source. A still raised alert of the same fingerprint counts another
+// occurrence, one auto resolved within the flap window is reopened, anything else raises the candidate.
+func (s *AlertService) raise(ctx context.Context, candidate *model.Alert, at time.Time) (*model.Alert, error) {
+	candidate.Fingerprint = model.AlertFingerprint(candidate.DeviceID, candidate.RuleID, candidate.SensorID, candidate.FieldCode, candidate.Condition)
+	latest, err := s.alertRepo.FindLatestByFingerprint(ctx, candidate.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	if latest != nil && latest.IsActive() {
+		latest.Occurred(at, candidate.TriggerValue)
+		if err := s.alertRepo.Update(ctx, latest); err != nil {
+			return nil, err
+		}
+		return latest, nil
+	}
+	if latest != nil && latest.Status == model.AlertStatusAutoResolved && latest.ResolvedAt != nil && at.Sub(*latest.ResolvedAt) < s.flapWindow {
+		return s.reopen(ctx, latest, candidate, at)
+	}
+
+	candidate.GroupKey = s.groupKey(ctx, candidate.DeviceID)
+	candidate.OccurrenceCount = 1
+	candidate.LastOccurredAt = &at
+	if err := s.alertRepo.Create(ctx, candidate); err != nil {
+		return nil, err
+	}
+	s.publish(ctx, pubsub.NatsTopicAlertTriggered, "triggered", candidate)
+	return candidate, nil
+}
+
+// reopen raises an alert that auto resolved moments ago again, as flapping once it reopened flapThreshold times
+// within the flap window. Turning flapping is published once, the alert stays quiet from there on.
+func (s *AlertService) reopen(ctx context.Context, alert *model.Alert, candidate *model.Alert, at time.Time) (*model.Alert, error) {
+	if alert.FlapWindowStart == nil || at.Sub(*alert.FlapWindowStart) >= s.flapWindow {
+		alert.FlapWindowStart = &at
+		alert.FlapCount = 0
+	}
+	alert.FlapCount++
+	alert.Occurred(at, candidate.TriggerValue)
+	alert.Severity = candidate.Severity
+	alert.Message = candidate.Message
+	alert.Threshold = candidate.Threshold
+	alert.TriggerTelemetryID = candidate.TriggerTelemetryID
+	alert.ResolveTelemetryID = nil
+	alert.ResolvedAt = nil
+	alert.AcknowledgedBy = nil
+	alert.AcknowledgedAt = nil
+
+	topic, event := pubsub.NatsTopicAlertTriggered, "triggered"
+	alert.Status = model.AlertStatusOpen
+	if alert.FlapCount >= s.flapThreshold {
+		topic, event = pubsub.NatsTopicAlertFlapping, "flapping"
+		alert.Status = model.AlertStatusFlapping
+	}
+	if err := s.alertRepo.Update(ctx, alert); err != nil {
+		return nil, err
+	}
+	s.publish(ctx, topic, event, alert)
+	return alert, nil
+}
+
+func (s *AlertService) autoResolve(ctx context.Context, alert *model.Alert, at time.Time, resolveTelemetryID *uuid.UUID) error {
+	alert.Status = model.AlertStatusAutoResolved
+	alert.ResolvedAt = &at
+	alert.ResolveTelemetryID = resolveTelemetryID
+	alert.ClearedAt = nil
+	if err := s.alertRepo.Update(ctx, alert); err != nil {
+		return err
+	}
+	s.publish(ctx, pubsub.NatsTopicAlertResolved, "resolved", alert)
+	return nil
+}
+
+func (s *AlertService) groupKey(ctx context.Context, deviceID uuid.UUID) string {
+	if key, ok := s.groupCache.Get(deviceID); ok {
+		return key
+	}
+	device, err := s.deviceRepo.GetByID(ctx, deviceID)
+	if err != nil {
+		s.l.Warn("failed to load device for alert grouping", zap.String("device_id", deviceID.String()), zap.Error(err))
+		return model.AlertGroupKey(model.AlertGroupByDevice, &model.Device{ID: deviceID})
+	}
+	key := model.AlertGroupKey(s.groupBy, device)
+	s.groupCache.Set(deviceID, key)
+	return key
+}
+
+// ListGroups summarises the raised alerts per group.
+func (s *AlertService) ListGroups(ctx context.Context) ([]*model.AlertGroup, error) {
+	return s.alertRepo.ListGroups(ctx)
+}
+
+// ListAlerts returns the alerts matching the filter.
+func (s *AlertService) ListAlerts(ctx context.Context, filter *dto.AlertFilter, paginationOpt *pagination.Pagination) ([]*model.Alert, int64, error) {
+	return s.alertRepo.List(ctx, filter, paginationOpt)
+}
+
+func (s *AlertService) GetAlert(ctx context.Context, alertID uuid.UUID) (*model.Alert, error) {
+	return s.alertRepo.GetByID(ctx, alertID)
+}
+
+// Acknowledge marks an open or flapping alert as being handled by the user, the alert stays raised until it resolves.
+// A flapping alert stays flapping, so its oscillation is still held instead of raising a fresh alert each time.
+func (s *AlertService) Acknowledge(ctx context.Context, alertID uuid.UUID, userID uuid.UUID, note string) (*model.Alert, error) {
+	return s.transition(ctx, alertID, userID, note, func(alert *model.Alert, now time.Time) error {
+		switch {
+		case alert.Status == model.AlertStatusFlapping && alert.AcknowledgedAt != nil:
+			return apperror.ErrConflict.WithMessage("flapping alert is already acknowledged")
+		case alert.Status == model.AlertStatusOpen:
+			alert.Status = model.AlertStatusAcknowledged
+		case alert.Status != model.AlertStatusFlapping:
+			return apperror.ErrConflict.WithMessagef("alert is %s, only open or flapping alerts can be acknowledged", alert.Status)
+		}
+		alert.AcknowledgedBy = &userID
+		alert.AcknowledgedAt = &now
+		return nil
+	}, pubsub.NatsTopicAlertAcknowledged, "acknowledged")
+}
+
+// Resolve closes an open or acknowledged alert on behalf of the user.
+func (s *AlertService) Resolve(ctx context.Context, alertID uuid.UUID, userID uuid.UUID, note string) (*model.Alert, error) {
+	return s.transition(ctx, alertID, userID, note, func(alert *model.Alert, now time.Time) error {
+		if !alert.IsActive() {
+			return apperror.ErrConflict.WithMessagef("alert is already %s", alert.Status)
+		}
+		alert.Status = model.AlertStatusResolved
+		alert.ResolvedBy = &userID
+		alert.ResolvedAt = &now
+		alert.ClearedAt = nil
+		return nil
+	}, pubsub.NatsTopicAlertResolved, "resolved")
+}
+
+func (s *AlertService) AddNote(ctx context.Context, alertID uuid.UUID, userID uuid.UUID, body string) (*model.AlertNote, error) {
+	if _, err := s.alertRepo.GetByID(ctx, alertID); err != nil {
+		return nil, err
+	}
+	note := &model.AlertNote{AlertID: alertID, AuthorID: userID, Body: body}
+	if err := s.alertRepo.AddNote(ctx, note); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// transition applies an operator change under the same lock the evaluator uses, so a reading can't auto resolve
+// the alert halfway through, and keeps the in-memory state in line with the stored alert.
+func (s *AlertService) transition(ctx context.Context, alertID uuid.UUID, userID uuid.UUID, note string, apply func(alert *model.Alert, now time.Time) error, topic string, event string) (*model.Alert, error) {
+	alert, err := s.alertRepo.GetByID(ctx, alertID)
+	if err != nil {
+		return nil, err
+	}
+
+	st := s.state(alertKey{deviceID: alert.DeviceID, sensorID: alert.SensorID, fieldCode: alert.FieldCode})
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	// re-read under the lock, the evaluator may have resolved it meanwhile
+	alert, err = s.alertRepo.GetByID(ctx, alertID)
+	if err != nil {
+		return nil, err
+	}
+	if err := apply(alert, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := s.alertRepo.Update(ctx, alert); err != nil {
+		return nil, err
+	}
+
+	if st.alert != nil && st.alert.ID == alert.ID {
+		if alert.IsActive() {
+			st.alert = alert
+		} else {
+			st.reset()
+		}
+	}
+
+	if note != "" {
+		alertNote := &model.AlertNote{AlertID: alert.ID, AuthorID: userID, Body: note}
+		if err := s.alertRepo.AddNote(ctx, alertNote); err != nil {
+			s.l.Error("failed to save alert note", zap.String("alert_id", alert.ID.String()), zap.Error(err))
+		} else {
+			alert.Notes = append(alert.Notes, *alertNote)
+		}
+	}
+
+	s.publish(ctx, topic, event, alert)
+	return alert, nil
+}
+
+func (st *thresholdState) reset() {
+	st.alert = nil
+	st.pendingSince = time.Time{}
+	st.clearingSince = time.Time{}
+}
+
+// AddListener registers a listener, it must be called before the telemetry workers start.
+func (s *AlertService) AddListener(listener AlertListener) {
+	s.listeners = append(s.listeners, listener)
+}
+
+func (s *AlertService) publish(ctx context.Context, topic string, event string, alert *model.Alert) {
+	payload := AlertEvent{Event: event, Alert: alert, Timestamp: time.Now()}
+	if err := s.publisher.Publish(ctx, topic, payload); err != nil {
+		s.l.Error("failed to publish alert event", zap.String("topic", topic), zap.String("alert_id", alert.ID.String()), zap.Error(err))
+	}
+	for _, listener := range s.listeners {
+		listener.AlertChanged(ctx, event, alert)
+	}
+
+	if s.notifier == nil {
+		return
+	}
+	n := &notification.Notification{
+		Event:   "alert_" + event,
+		Subject: fmt.Sprintf("[%s] %s", alert.Severity, alert.Message),
+		Data:    map[string]interface{}{"alert": alert},
+	}
+	if err := s.notifier.Notify(ctx, n); err != nil {
+		s.l.Error("failed to raise alert notification", zap.String("alert_id", alert.ID.String()), zap.Error(err))
+	}
+}
+
+func thresholdMessage(sensorCode string, fieldCode string, threshold model.AlertThreshold, value float64) string {
+	switch threshold.Condition {
+	case model.ThresholdAbove:
+		return fmt.Sprintf("%s field %s is %g, above %g", sensorCode, fieldCode, value, *threshold.Value)
+	case model.ThresholdBelow:
+		return fmt.Sprintf("%s field %s is %g, below %g", sensorCode, fieldCode, value, *threshold.Value)
+	default:
+		return fmt.Sprintf("%s field %s is %g, outside %g..%g", sensorCode, fieldCode, value, *threshold.Low, *threshold.High)
+	}
+}
+
+func telemetryRef(telemetryID uuid.UUID) *uuid.UUID {
+	if telemetryID == uuid.Nil {
+		return nil
+	}
+	return &telemetryID
+}
+
+func numericValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,340 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+type fakeDeviceRepo struct {
+	repository.DeviceRepository
+	device *model.Device
+}
+
+func (r *fakeDeviceRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.Device, error) {
+	return r.device, nil
+}
+
+type fakeAlertRepo struct {
+	repository.AlertRepository
+	alerts []*model.Alert
+	notes  []*model.AlertNote
+}
+
+func (r *fakeAlertRepo) GetByID(ctx context.Context, alertID uuid.UUID) (*model.Alert, error) {
+	for _, alert := range r.alerts {
+		if alert.ID == alertID {
+			return alert, nil
+		}
+	}
+	return nil, apperror.ErrNotFound
+}
+
+func (r *fakeAlertRepo) AddNote(ctx context.Context, note *model.AlertNote) error {
+	note.ID = uuid.New()
+	r.notes = append(r.notes, note)
+	return nil
+}
+
+func (r *fakeAlertRepo) Create(ctx context.Context, alert *model.Alert) error {
+	alert.ID = uuid.New()
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+func (r *fakeAlertRepo) Update(ctx context.Context, alert *model.Alert) error { return nil }
+
+func (r *fakeAlertRepo) FindActive(ctx context.Context, deviceID uuid.UUID, sensorID uuid.UUID, fieldCode string) (*model.Alert, error) {
+	return nil, nil
+}
+
+func (r *fakeAlertRepo) FindLatestByFingerprint(ctx context.Context, fingerprint string) (*model.Alert, error) {
+	var latest *model.Alert
+	for _, alert := range r.alerts {
+		if alert.Fingerprint == fingerprint && (latest == nil || alert.TriggeredAt.After(latest.TriggeredAt)) {
+			latest = alert
+		}
+	}
+	return latest, nil
+}
+
+func (r *fakeAlertRepo) ListQuietFlapping(ctx context.Context, clearedBefore time.Time) ([]*model.Alert, error) {
+	var alerts []*model.Alert
+	for _, alert := range r.alerts {
+		if alert.Status == model.AlertStatusFlapping && alert.ClearedAt != nil && !alert.ClearedAt.After(clearedBefore) {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, nil
+}
+
+type fakePublisher struct {
+	pubsub.PubSubPublisher
+	topics []string
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, topic string, message interface{}) error {
+	p.topics = append(p.topics, topic)
+	return nil
+}
+
+func TestAlertThresholdHysteresisAndMinDuration(t *testing.T) {
+	limit := 40.0
+	thresholds, err := json.Marshal(model.AlertThresholds{
+		"sensor-temp-001": {"1": {Condition: model.ThresholdAbove, Value: &limit, Hysteresis: 2, MinDurationSeconds: 30}},
+	})
+	require.NoError(t, err)
+
+	deviceID, sensorID := uuid.New(), uuid.New()
+	device := &model.Device{ID: deviceID}
+	device.TelemetryConfig.AlertThresholds = thresholds
+
+	alertRepo := &fakeAlertRepo{}
+	publisher := &fakePublisher{}
+	alertService := service.NewAlertService(alertRepo, &fakeDeviceRepo{device: device}, publisher, nil, nil, zap.NewNop())
+
+	start := time.Now()
+	observe := func(offset time.Duration, value float64) {
+		alertService.ObserveTelemetry(context.Background(), &service.TelemetryReading{
+			DeviceID:   deviceID,
+			SensorID:   sensorID,
+			SensorCode: "sensor-temp-001",
+			Timestamp:  start.Add(offset),
+			Data:       map[string]interface{}{"1": value},
+		})
+	}
+
+	// breach that doesn't last the minimum duration
+	observe(0, 41)
+	observe(10*time.Second, 39)
+	assert.Empty(t, alertRepo.alerts)
+
+	// breach held for 30s fires once
+	observe(20*time.Second, 42)
+	observe(40*time.Second, 43)
+	observe(50*time.Second, 44)
+	require.Len(t, alertRepo.alerts, 1)
+	alert := alertRepo.alerts[0]
+	assert.Equal(t, model.AlertStatusOpen, alert.Status)
+	assert.Equal(t, start.Add(20*time.Second), alert.TriggeredAt)
+	assert.Equal(t, 44.0, alert.TriggerValue)
+
+	// inside the limit but within hysteresis keeps it open
+	observe(60*time.Second, 39)
+	assert.Equal(t, model.AlertStatusOpen, alert.Status)
+
+	observe(70*time.Second, 38)
+	assert.Equal(t, model.AlertStatusAutoResolved, alert.Status)
+	assert.Equal(t, []string{pubsub.NatsTopicAlertTriggered, pubsub.NatsTopicAlertResolved}, publisher.topics)
+}
+
+func TestAlertAcknowledgeAndClearDuration(t *testing.T) {
+	limit := 40.0
+	thresholds, err := json.Marshal(model.AlertThresholds{
+		"sensor-temp-001": {"1": {Condition: model.ThresholdAbove, Value: &limit, ClearDurationSeconds: 60}},
+	})
+	require.NoError(t, err)
+
+	deviceID, sensorID, userID := uuid.New(), uuid.New(), uuid.New()
+	device := &model.Device{ID: deviceID}
+	device.TelemetryConfig.AlertThresholds = thresholds
+
+	alertRepo := &fakeAlertRepo{}
+	publisher := &fakePublisher{}
+	alertService := service.NewAlertService(alertRepo, &fakeDeviceRepo{device: device}, publisher, nil, nil, zap.NewNop())
+
+	start := time.Now()
+	observe := func(offset time.Duration, value float64) {
+		alertService.ObserveTelemetry(context.Background(), &service.TelemetryReading{
+			TelemetryID: uuid.New(),
+			DeviceID:    deviceID,
+			SensorID:    sensorID,
+			SensorCode:  "sensor-temp-001",
+			Timestamp:   start.Add(offset),
+			Data:        map[string]interface{}{"1": value},
+		})
+	}
+
+	observe(0, 45)
+	require.Len(t, alertRepo.alerts, 1)
+	alert := alertRepo.alerts[0]
+	assert.NotNil(t, alert.TriggerTelemetryID)
+
+	_, err = alertService.Acknowledge(context.Background(), alert.ID, userID, "on it")
+	require.NoError(t, err)
+	assert.Equal(t, model.AlertStatusAcknowledged, alert.Status)
+	assert.Equal(t, &userID, alert.AcknowledgedBy)
+	require.Len(t, alertRepo.notes, 1)
+
+	// acknowledging twice is a conflict
+	_, err = alertService.Acknowledge(context.Background(), alert.ID, userID, "")
+	assert.Equal(t, apperror.ErrCodeConflict, apperror.FromError(err).Code)
+
+	// back to normal, but not for long enough
+	observe(10*time.Second, 35)
+	observe(40*time.Second, 41)
+	observe(50*time.Second, 35)
+	assert.Equal(t, model.AlertStatusAcknowledged, alert.Status)
+
+	observe(110*time.Second, 35)
+	assert.Equal(t, model.AlertStatusAutoResolved, alert.Status)
+	assert.NotNil(t, alert.ResolveTelemetryID)
+	assert.Nil(t, alert.ResolvedBy)
+	assert.Equal(t, []string{pubsub.NatsTopicAlertTriggered, pubsub.NatsTopicAlertAcknowledged, pubsub.NatsTopicAlertResolved}, publisher.topics)
+}
+
+func TestAlertOscillationTurnsFlapping(t *testing.T) {
+	limit := 40.0
+	thresholds, err := json.Marshal(model.AlertThresholds{
+		"sensor-temp-001": {"1": {Condition: model.ThresholdAbove, Value: &limit}},
+	})
+	require.NoError(t, err)
+
+	deviceID, sensorID := uuid.New(), uuid.New()
+	device := &model.Device{ID: deviceID, Metadata: []byte(`{"site": "plant-1"}`)}
+	device.TelemetryConfig.AlertThresholds = thresholds
+
+	alertRepo := &fakeAlertRepo{}
+	publisher := &fakePublisher{}
+	cfg := &config.TelemetryConfig{AlertFlapWindow: 10 * time.Minute, AlertFlapThreshold: 2, AlertGroupBy: "site"}
+	alertService := service.NewAlertService(alertRepo, &fakeDeviceRepo{device: device}, publisher, nil, cfg, zap.NewNop())
+
+	start := time.Now()
+	observe := func(offset time.Duration, value float64) {
+		alertService.ObserveTelemetry(context.Background(), &service.TelemetryReading{
+			DeviceID:   deviceID,
+			SensorID:   sensorID,
+			SensorCode: "sensor-temp-001",
+			Timestamp:  start.Add(offset),
+			Data:       map[string]interface{}{"1": value},
+		})
+	}
+
+	// oscillating around the limit: raised, reopened once, then held as flapping
+	for i := 0; i < 5; i++ {
+		observe(time.Duration(2*i)*time.Minute, 41)
+		observe(time.Duration(2*i+1)*time.Minute, 39)
+	}
+	require.Len(t, alertRepo.alerts, 1)
+	alert := alertRepo.alerts[0]
+	assert.Equal(t, model.AlertStatusFlapping, alert.Status)
+	assert.Equal(t, 5, alert.OccurrenceCount)
+	assert.Equal(t, "site:plant-1", alert.GroupKey)
+	assert.Equal(t, []string{
+		pubsub.NatsTopicAlertTriggered, pubsub.NatsTopicAlertResolved,
+		pubsub.NatsTopicAlertTriggered, pubsub.NatsTopicAlertResolved,
+		pubsub.NatsTopicAlertFlapping,
+	}, publisher.topics)
+
+	// quiet for less than the flap window keeps it flapping, the sweep resolves it afterwards
+	resolved, err := alertService.ResolveQuietFlapping(context.Background(), start.Add(15*time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, resolved)
+	assert.Equal(t, model.AlertStatusFlapping, alert.Status)
+
+	resolved, err = alertService.ResolveQuietFlapping(context.Background(), start.Add(20*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, resolved)
+	assert.Equal(t, model.AlertStatusAutoResolved, alert.Status)
+}
+
+func TestAlertAcknowledgeKeepsFlapping(t *testing.T) {
+	limit := 40.0
+	thresholds, err := json.Marshal(model.AlertThresholds{
+		"sensor-temp-001": {"1": {Condition: model.ThresholdAbove, Value: &limit}},
+	})
+	require.NoError(t, err)
+
+	deviceID, sensorID, userID := uuid.New(), uuid.New(), uuid.New()
+	device := &model.Device{ID: deviceID}
+	device.TelemetryConfig.AlertThresholds = thresholds
+
+	alertRepo := &fakeAlertRepo{}
+	publisher := &fakePublisher{}
+	cfg := &config.TelemetryConfig{AlertFlapWindow: 10 * time.Minute, AlertFlapThreshold: 2}
+	alertService := service.NewAlertService(alertRepo, &fakeDeviceRepo{device: device}, publisher, nil, cfg, zap.NewNop())
+
+	start := time.Now()
+	observe := func(offset time.Duration, value float64) {
+		alertService.ObserveTelemetry(context.Background(), &service.TelemetryReading{
+			DeviceID:   deviceID,
+			SensorID:   sensorID,
+			SensorCode: "sensor-temp-001",
+			Timestamp:  start.Add(offset),
+			Data:       map[string]interface{}{"1": value},
+		})
+	}
+	oscillate := func(from, to int) {
+		for i := from; i < to; i++ {
+			observe(time.Duration(2*i)*time.Minute, 41)
+			observe(time.Duration(2*i+1)*time.Minute, 39)
+		}
+	}
+
+	oscillate(0, 3)
+	require.Len(t, alertRepo.alerts, 1)
+	alert := alertRepo.alerts[0]
+	require.Equal(t, model.AlertStatusFlapping, alert.Status)
+
+	_, err = alertService.Acknowledge(context.Background(), alert.ID, userID, "known loose sensor")
+	require.NoError(t, err)
+	assert.Equal(t, model.AlertStatusFlapping, alert.Status)
+	assert.Equal(t, &userID, alert.AcknowledgedBy)
+
+	_, err = alertService.Acknowledge(context.Background(), alert.ID, userID, "")
+	assert.Equal(t, apperror.ErrCodeConflict, apperror.FromError(err).Code)
+
+	// the oscillation goes on without raising a fresh alert
+	published := len(publisher.topics)
+	oscillate(3, 5)
+	require.Len(t, alertRepo.alerts, 1)
+	assert.Equal(t, model.AlertStatusFlapping, alert.Status)
+	assert.Len(t, publisher.topics, published)
+	assert.Equal(t, pubsub.NatsTopicAlertAcknowledged, publisher.topics[published-1])
+}
+
+func TestAlertThresholdsReloadWhenTheDeviceChanged(t *testing.T) {
+	deviceID, sensorID := uuid.New(), uuid.New()
+	device := &model.Device{ID: deviceID}
+
+	alertRepo := &fakeAlertRepo{}
+	alertService := service.NewAlertService(alertRepo, &fakeDeviceRepo{device: device}, &fakePublisher{}, nil, nil, zap.NewNop())
+	observe := func(value float64) {
+		alertService.ObserveTelemetry(context.Background(), &service.TelemetryReading{
+			DeviceID:   deviceID,
+			SensorID:   sensorID,
+			SensorCode: "sensor-temp-001",
+			Timestamp:  time.Now(),
+			Data:       map[string]interface{}{"1": value},
+		})
+	}
+
+	observe(50)
+	assert.Empty(t, alertRepo.alerts)
+
+	limit := 40.0
+	thresholds, err := json.Marshal(model.AlertThresholds{
+		"sensor-temp-001": {"1": {Condition: model.ThresholdAbove, Value: &limit}},
+	})
+	require.NoError(t, err)
+	device.TelemetryConfig.AlertThresholds = thresholds
+
+	observe(51)
+	assert.Empty(t, alertRepo.alerts, "the thresholds are cached")
+
+	alertService.DeviceChanged(deviceID)
+	observe(52)
+	assert.Len(t, alertRepo.alerts, 1)
+}
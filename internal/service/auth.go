@@ -10,6 +10,7 @@ import (
 	"github.com/vars7899/iots/config"
 	"github.com/vars7899/iots/internal/api/v1/dto"
 	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/notification"
 	"github.com/vars7899/iots/pkg/apperror"
 	"github.com/vars7899/iots/pkg/auth"
 	"github.com/vars7899/iots/pkg/auth/token"
@@ -23,17 +24,19 @@ type authService struct {
 	accessControlService auth.AccessControlService
 	authTokenService     auth.AuthTokenService
 	resetPasswordService ResetPasswordTokenService
+	notifier             Notifier
 	config               *config.AppConfig
 	logger               *zap.Logger
 }
 
-func NewAuthService(userService UserService, roleService RoleService, accessControlService auth.AccessControlService, authTokenService auth.AuthTokenService, resetPasswordService ResetPasswordTokenService, config *config.AppConfig, baseLogger *zap.Logger) AuthService {
+func NewAuthService(userService UserService, roleService RoleService, accessControlService auth.AccessControlService, authTokenService auth.AuthTokenService, resetPasswordService ResetPasswordTokenService, notifier Notifier, config *config.AppConfig, baseLogger *zap.Logger) AuthService {
 	return &authService{
 		userService:          userService,
 		roleService:          roleService,
 		accessControlService: accessControlService,
 		authTokenService:     authTokenService,
 		resetPasswordService: resetPasswordService,
+		notifier:             notifier,
 		config:               config,
 		logger:               logger.Named(baseLogger, "AuthService"),
 	}
@@ -191,11 +194,12 @@ func (s *authService) LogoutUser(ctx context.Context, userID *uuid.UUID, claims
 	return nil
 }
 
-func (s *authService) RequestPasswordReset(ctx context.Context, email string) (*model.ResetPasswordToken, *string, error) {
+// RequestPasswordReset issues a reset token and sends the reset link to the user through the notification channels.
+func (s *authService) RequestPasswordReset(ctx context.Context, email string) error {
 	user, err := s.userService.FindByEmail(ctx, email)
 	if err != nil {
 		s.logger.Warn("User not found during password reset request", zap.String("email", email), zap.Error(err))
-		return nil, nil, err
+		return err
 	}
 
 	if err := s.resetPasswordService.DeleteTokensByUserID(ctx, user.ID); err != nil {
@@ -205,14 +209,24 @@ func (s *authService) RequestPasswordReset(ctx context.Context, email string) (*
 
 	resetToken, err := s.resetPasswordService.CreateToken(ctx, user.ID, s.config.Auth.RequestResetPasswordTokenTTL)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
 	resetLink := fmt.Sprintf("%s/reset-password?token=%s", s.config.Frontend.BaseUrl, resetToken.Token)
 
-	// send email otp later
-
-	return resetToken, &resetLink, nil
+	n := &notification.Notification{
+		Event:      notification.EventPasswordReset,
+		Recipients: []string{user.Email},
+		Data: map[string]interface{}{
+			"username":   user.Username,
+			"reset_link": resetLink,
+			"expires_at": resetToken.ExpiresAt.Format(time.RFC1123),
+		},
+	}
+	if err := s.notifier.Notify(ctx, n); err != nil {
+		return apperror.ErrorHandler(err, apperror.ErrCodeInternal, "failed to send password reset instructions")
+	}
+	return nil
 }
 
 func (s *authService) ResetPassword(ctx context.Context, resetToken, newRawPassword string) error {
@@ -0,0 +1,665 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/commands"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pagination"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCommandTTL      = 5 * time.Minute
+	defaultCommandMaxTTL   = 24 * time.Hour
+	defaultAckTimeout      = 30 * time.Second
+	defaultMaxAckWait      = 5 * time.Minute
+	defaultMaxAttempts     = 3
+	defaultRPCTimeout      = 10 * time.Second
+	defaultMaxRPCTimeout   = time.Minute
+	commandExpiryBatchSize = 100
+	commandQueueFlushLimit = 500 // queued commands delivered on reconnect, the oldest ones
+	commandReplyBuffer     = 8   // replies kept for a call until it reads them
+
+	// commandFailureTimeout is the reason of the command_failed event of a command that ran out of time, either
+	// its ttl or its attempts
+	commandFailureTimeout = "timeout"
+)
+
+// commandStatusByEvent maps the command status events to the status they move the command to.
+var commandStatusByEvent = map[string]model.CommandStatus{
+	model.EventTypeCommandDelivered:    model.CommandStatusDelivered,
+	model.EventTypeCommandAcknowledged: model.CommandStatusAcknowledged,
+	model.EventTypeCommandCompleted:    model.CommandStatusCompleted,
+	model.EventTypeCommandFailed:       model.CommandStatusFailed,
+}
+
+// CommandService sends user commands to devices and keeps their lifecycle from the events published on
+// pubsub.NatsTopicCommandStatus by the device gateway and the command processor.
+type CommandService struct {
+	commandRepo repository.CommandRepository
+	deviceRepo  repository.DeviceRepository
+	registry    *config.DeviceCommandSchemaRegistry
+	publisher   pubsub.PubSubPublisher
+	defaultTTL  time.Duration
+	maxTTL      time.Duration
+	ackTimeout  time.Duration
+	maxAckWait  time.Duration
+	maxAttempts int
+	rpcTimeout  time.Duration
+	maxRPCWait  time.Duration
+	replies     *commandReplies
+	l           *zap.Logger
+}
+
+func NewCommandService(commandRepo repository.CommandRepository, deviceRepo repository.DeviceRepository, registry *config.DeviceCommandSchemaRegistry, publisher pubsub.PubSubPublisher, cfg *config.CommandConfig, baseLogger *zap.Logger) *CommandService {
+	defaultTTL, maxTTL := defaultCommandTTL, defaultCommandMaxTTL
+	ackTimeout, maxAckWait, maxAttempts := defaultAckTimeout, defaultMaxAckWait, defaultMaxAttempts
+	rpcTimeout, maxRPCWait := defaultRPCTimeout, defaultMaxRPCTimeout
+	if cfg != nil {
+		if cfg.DefaultTTL > 0 {
+			defaultTTL = cfg.DefaultTTL
+		}
+		if cfg.MaxTTL > 0 {
+			maxTTL = cfg.MaxTTL
+		}
+		if cfg.AckTimeout > 0 {
+			ackTimeout = cfg.AckTimeout
+		}
+		if cfg.MaxAckWait > 0 {
+			maxAckWait = cfg.MaxAckWait
+		}
+		if cfg.MaxAttempts > 0 {
+			maxAttempts = cfg.MaxAttempts
+		}
+		if cfg.RPCTimeout > 0 {
+			rpcTimeout = cfg.RPCTimeout
+		}
+		if cfg.MaxRPCTimeout > 0 {
+			maxRPCWait = cfg.MaxRPCTimeout
+		}
+	}
+	return &CommandService{
+		commandRepo: commandRepo,
+		deviceRepo:  deviceRepo,
+		registry:    registry,
+		publisher:   publisher,
+		defaultTTL:  defaultTTL,
+		maxTTL:      maxTTL,
+		ackTimeout:  ackTimeout,
+		maxAckWait:  maxAckWait,
+		maxAttempts: maxAttempts,
+		rpcTimeout:  rpcTimeout,
+		maxRPCWait:  maxRPCWait,
+		replies:     newCommandReplies(publisher, baseLogger),
+		l:           logger.Named(baseLogger, "CommandService"),
+	}
+}
+
+// Send stores the command as queued and publishes it to the device's outbound subject, a zero ttl uses the default.
+// A command without an ack within its ack timeout is published again until it runs out of attempts. A command with an
+// idempotency key the device already has returns the earlier command instead. The payload is checked against the
+// schema version of the command the device negotiated. A publish failure is recorded on the command rather than
+// returned.
+func (s *CommandService) Send(ctx context.Context, command *model.Command, ttl time.Duration) (*model.Command, error) {
+	schema, err := userCommandSchema(s.registry, command.CommandCode)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = s.defaultTTL
+	}
+	if ttl > s.maxTTL {
+		return nil, apperror.ErrValidation.WithMessagef("ttl must not exceed %s", s.maxTTL)
+	}
+	payload, err := commandPayload(command)
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := s.deviceRepo.GetByID(ctx, command.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device.Status == model.DeviceStatusDecommissioned {
+		return nil, apperror.ErrConflict.WithMessage("device is decommissioned")
+	}
+	// the payload follows the version of the command the device negotiated
+	version := device.CommandVersion(command.CommandCode)
+	if schema = schema.Version(version); schema == nil {
+		return nil, apperror.ErrConflict.WithMessagef("device speaks version %d of %s, which is no longer active", version, command.CommandCode)
+	}
+	if violations := commands.ValidatePayload(&schema.Payload, payload); len(violations) > 0 {
+		return nil, apperror.ErrValidation.WithMessagef("payload of %s version %d has %d violation(s)", command.CommandCode, schema.SchemaVersion, len(violations)).WithDetails(map[string]interface{}{
+			"violations": violations,
+		})
+	}
+
+	if command.IdempotencyKey != nil {
+		existing, err := s.commandRepo.GetByIdempotencyKey(ctx, command.DeviceID, *command.IdempotencyKey)
+		if err == nil {
+			return s.replay(existing, command)
+		}
+		if apperror.FromError(err).Code != apperror.ErrCodeNotFound {
+			return nil, err
+		}
+	}
+
+	if command.AckTimeoutSeconds == 0 {
+		command.AckTimeoutSeconds = int(s.ackTimeout / time.Second)
+	}
+	if command.MaxAttempts == 0 {
+		command.MaxAttempts = s.maxAttempts
+	}
+	command.Command = schema.Command
+	command.SchemaVersion = schema.SchemaVersion
+	command.Status = model.CommandStatusQueued
+	command.ExpiresAt = time.Now().Add(ttl)
+	if err := s.commandRepo.Create(ctx, command); err != nil {
+		// a concurrent request with the same key won the insert
+		if command.IdempotencyKey != nil && apperror.FromError(err).Code == apperror.ErrCodeDuplicateKey {
+			if existing, getErr := s.commandRepo.GetByIdempotencyKey(ctx, command.DeviceID, *command.IdempotencyKey); getErr == nil {
+				return s.replay(existing, command)
+			}
+		}
+		return nil, err
+	}
+
+	s.publish(ctx, command, payload, time.Now())
+	if err := s.commandRepo.Update(ctx, command); err != nil {
+		return nil, err
+	}
+	return command, nil
+}
+
+// Call sends the command like Send and waits up to the timeout for the device's answer, a zero timeout uses the
+// default. The answer is taken from the reply subject of the command so any API replica can wait on it, the command
+// itself moves on through the status events as usual. Calls waiting on the same command, e.g. a retried request with
+// the same idempotency key, share the subscription of the reply subject. Without an answer in time the command is
+// returned with a gateway timeout and stays pending.
+func (s *CommandService) Call(ctx context.Context, command *model.Command, ttl time.Duration, timeout time.Duration) (*model.Command, *model.DeviceEvent, error) {
+	if timeout <= 0 {
+		timeout = s.rpcTimeout
+	}
+	if timeout > s.maxRPCWait {
+		return nil, nil, apperror.ErrValidation.WithMessagef("rpc timeout must not exceed %s", s.maxRPCWait)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// subscribed before the command is published, a fast device can't answer before anyone listens
+	if command.ID == uuid.Nil {
+		command.ID = uuid.New()
+	}
+	replies, err := s.replies.wait(command.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	sent, err := s.Send(ctx, command, ttl)
+	if err != nil {
+		s.replies.leave(command.ID, replies)
+		return nil, nil, err
+	}
+	if sent.ID != command.ID {
+		// a retried request waits on the command of the first one
+		s.replies.leave(command.ID, replies)
+		if replies, err = s.replies.wait(sent.ID); err != nil {
+			return nil, nil, err
+		}
+		commandID := sent.ID
+		if sent, err = s.commandRepo.GetByID(ctx, commandID); err != nil {
+			s.replies.leave(commandID, replies)
+			return nil, nil, err
+		}
+	}
+	defer s.replies.leave(sent.ID, replies)
+	if sent.IsFinished() {
+		return sent, nil, nil
+	}
+
+	for {
+		select {
+		case data := <-replies:
+			var event model.DeviceEvent
+			if err := json.Unmarshal(data, &event); err != nil || event.DeviceID != sent.DeviceID {
+				continue
+			}
+			if status, ok := commandStatusByEvent[event.Type]; ok {
+				// the status worker stores the same change, the caller gets it without waiting for it
+				applyStatusEvent(sent, status, &event)
+			}
+			return sent, &event, nil
+		case <-ctx.Done():
+			return sent, nil, s.rpcTimeoutError(sent, timeout)
+		}
+	}
+}
+
+// commandReplies fans the reply subject of a command out to the calls waiting on it. The publisher holds a single
+// subscription per subject, so the first call subscribes, the others join it and the last one to leave unsubscribes.
+type commandReplies struct {
+	publisher pubsub.PubSubPublisher
+	mu        sync.Mutex
+	subjects  map[uuid.UUID]*replySubject
+	l         *zap.Logger
+}
+
+type replySubject struct {
+	waiters map[chan []byte]struct{}
+	cancel  context.CancelFunc
+}
+
+func newCommandReplies(publisher pubsub.PubSubPublisher, baseLogger *zap.Logger) *commandReplies {
+	return &commandReplies{
+		publisher: publisher,
+		subjects:  make(map[uuid.UUID]*replySubject),
+		l:         logger.Named(baseLogger, "CommandReplies"),
+	}
+}
+
+// wait returns the channel the replies of the command are passed to, the caller must leave with it once done.
+func (r *commandReplies) wait(commandID uuid.UUID) (chan []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subject, ok := r.subjects[commandID]
+	if !ok {
+		// not bound to the request of the first call, the others keep waiting when it times out
+		ctx, cancel := context.WithCancel(context.Background())
+		replies, err := r.publisher.Subscribe(ctx, pubsub.NatsTopicCommandRepliesPrefixf(commandID))
+		if err != nil {
+			cancel()
+			return nil, apperror.ErrInternal.WithMessage("failed to wait for the command's answer").Wrap(err)
+		}
+		subject = &replySubject{waiters: make(map[chan []byte]struct{}), cancel: cancel}
+		r.subjects[commandID] = subject
+		go r.fanOut(ctx, subject, replies)
+	}
+	waiter := make(chan []byte, commandReplyBuffer)
+	subject.waiters[waiter] = struct{}{}
+	return waiter, nil
+}
+
+func (r *commandReplies) fanOut(ctx context.Context, subject *replySubject, replies <-chan []byte) {
+	for {
+		select {
+		case data, ok := <-replies:
+			if !ok {
+				return
+			}
+			r.mu.Lock()
+			for waiter := range subject.waiters {
+				select {
+				case waiter <- data:
+				default: // the call stopped reading, it is about to leave
+				}
+			}
+			r.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *commandReplies) leave(commandID uuid.UUID, waiter chan []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subject, ok := r.subjects[commandID]
+	if !ok {
+		return
+	}
+	delete(subject.waiters, waiter)
+	if len(subject.waiters) > 0 {
+		return
+	}
+	delete(r.subjects, commandID)
+	subject.cancel()
+	if err := r.publisher.Unsubscribe(context.Background(), pubsub.NatsTopicCommandRepliesPrefixf(commandID)); err != nil {
+		r.l.Warn("failed to unsubscribe from command replies", zap.String("command_id", commandID.String()), zap.Error(err))
+	}
+}
+
+func (s *CommandService) rpcTimeoutError(command *model.Command, timeout time.Duration) error {
+	return apperror.ErrGatewayTimeout.WithMessagef("device didn't answer within %s, the command is still %s", timeout, command.Status).WithDetails(map[string]interface{}{
+		"command_id": command.ID,
+		"status":     command.Status,
+	})
+}
+
+// replay returns the command created earlier with the same idempotency key, reusing the key for another command
+// is a conflict.
+func (s *CommandService) replay(existing *model.Command, command *model.Command) (*model.Command, error) {
+	if existing.CommandCode != command.CommandCode || !sameJSON(existing.Payload, command.Payload) {
+		return nil, apperror.ErrConflict.WithMessagef("idempotency key %s was used for another command", *command.IdempotencyKey)
+	}
+	return existing, nil
+}
+
+// publish publishes the next attempt of the command and sets the deadline of its ack.
+func (s *CommandService) publish(ctx context.Context, command *model.Command, payload map[string]interface{}, now time.Time) {
+	command.Attempts++
+	message := deviceCommand(command, payload, now)
+	if err := s.publisher.Publish(ctx, pubsub.NatsTopicCommandsOutboundPrefixf(command.DeviceID), message); err != nil {
+		s.l.Error("failed to publish command",
+			zap.String("command_id", command.ID.String()),
+			zap.String("device_id", command.DeviceID.String()),
+			zap.Int("attempt", command.Attempts),
+			zap.Error(err),
+		)
+		if command.Attempts == 1 {
+			command.Advance(model.CommandStatusFailed, now)
+			command.Error = "failed to publish command"
+			return
+		}
+	} else {
+		command.Advance(model.CommandStatusSent, now)
+	}
+	// a retry that failed to publish waits for its deadline like a lost one
+	if command.AckTimeoutSeconds > 0 {
+		deadline := now.Add(command.AckWait(s.maxAckWait))
+		command.AckDeadline = &deadline
+	}
+}
+
+// QueuedCommands returns the commands the device didn't get yet because it wasn't connected, oldest first, for the
+// device gateway to deliver when the device reconnects. Their ack deadline restarts from now so a retry doesn't
+// race the delivery.
+func (s *CommandService) QueuedCommands(ctx context.Context, deviceID uuid.UUID, now time.Time) ([]*model.DeviceCommand, error) {
+	commands, err := s.commandRepo.ListUndelivered(ctx, deviceID, now, commandQueueFlushLimit)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*model.DeviceCommand, 0, len(commands))
+	for _, command := range commands {
+		payload, err := commandPayload(command)
+		if err != nil {
+			s.l.Warn("skipping queued command with invalid payload", zap.String("command_id", command.ID.String()), zap.Error(err))
+			continue
+		}
+		if command.Attempts == 0 {
+			command.Attempts = 1 // never published, the gateway delivers the first attempt
+		}
+		message := deviceCommand(command, payload, now)
+		messages = append(messages, &message)
+
+		command.Advance(model.CommandStatusSent, now)
+		if command.AckTimeoutSeconds > 0 {
+			deadline := now.Add(command.AckWait(s.maxAckWait))
+			command.AckDeadline = &deadline
+		}
+		if err := s.commandRepo.Update(ctx, command); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+// MarkProcessed completes the queued commands the device reports it already ran, so they aren't delivered again.
+// Ids of other devices' commands or of finished commands are skipped, it returns the number of completed commands.
+func (s *CommandService) MarkProcessed(ctx context.Context, deviceID uuid.UUID, commandIDs []uuid.UUID, now time.Time) (int, error) {
+	completed := 0
+	for _, commandID := range commandIDs {
+		command, err := s.commandRepo.GetByID(ctx, commandID)
+		if err != nil {
+			if apperror.FromError(err).Code == apperror.ErrCodeNotFound {
+				continue
+			}
+			return completed, err
+		}
+		if command.DeviceID != deviceID || !command.Advance(model.CommandStatusCompleted, now) {
+			continue
+		}
+		if err := s.commandRepo.Update(ctx, command); err != nil {
+			return completed, err
+		}
+		completed++
+	}
+	return completed, nil
+}
+
+// GetCommand returns the command of the device.
+func (s *CommandService) GetCommand(ctx context.Context, deviceID uuid.UUID, commandID uuid.UUID) (*model.Command, error) {
+	command, err := s.commandRepo.GetByID(ctx, commandID)
+	if err != nil {
+		return nil, err
+	}
+	if command.DeviceID != deviceID {
+		return nil, apperror.ErrNotFound.WithMessagef("command %s not found for device %s", commandID, deviceID)
+	}
+	return command, nil
+}
+
+// ListCommands returns the command history matching the filter.
+func (s *CommandService) ListCommands(ctx context.Context, filter *dto.CommandFilter, paginationOpt *pagination.Pagination) ([]*model.Command, int64, error) {
+	return s.commandRepo.List(ctx, filter, paginationOpt)
+}
+
+// HandleStatusEvent applies a command status event to the command it is correlated with. Events of commands that
+// weren't sent through the API are ignored, as are events a command is already past.
+func (s *CommandService) HandleStatusEvent(ctx context.Context, event *model.DeviceEvent) error {
+	status, ok := commandStatusByEvent[event.Type]
+	if !ok || event.CorrelationID == uuid.Nil {
+		return nil
+	}
+	command, err := s.commandRepo.GetByID(ctx, event.CorrelationID)
+	if err != nil {
+		if apperror.FromError(err).Code == apperror.ErrCodeNotFound {
+			return nil
+		}
+		return err
+	}
+	if command.DeviceID != event.DeviceID {
+		s.l.Warn("ignoring command event from another device",
+			zap.String("command_id", command.ID.String()),
+			zap.String("device_id", event.DeviceID.String()),
+		)
+		return nil
+	}
+
+	if !applyStatusEvent(command, status, event) {
+		return nil
+	}
+	return s.commandRepo.Update(ctx, command)
+}
+
+// applyStatusEvent moves the command to the status of the event and keeps the result of a finished one, it reports
+// false when the command is already past the status.
+func applyStatusEvent(command *model.Command, status model.CommandStatus, event *model.DeviceEvent) bool {
+	at := event.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+	if !command.Advance(status, at) {
+		return false
+	}
+	switch status {
+	case model.CommandStatusCompleted, model.CommandStatusFailed:
+		if len(event.Payload) > 0 {
+			result, err := json.Marshal(event.Payload)
+			if err == nil {
+				command.Result = result
+			}
+		}
+		if reason, ok := event.Payload["error"].(string); ok && status == model.CommandStatusFailed {
+			command.Error = reason
+		}
+	}
+	return true
+}
+
+// RetryUnacknowledged publishes the commands past their ack deadline again, the ones out of attempts fail with a
+// timeout. Only delivered commands spend attempts, a sent one never reached the device and waits for it to reconnect
+// to be flushed from the queue.
+func (s *CommandService) RetryUnacknowledged(ctx context.Context, now time.Time) (retried int, failed int, err error) {
+	for {
+		overdue, err := s.commandRepo.ListAckOverdue(ctx, now, commandExpiryBatchSize)
+		if err != nil {
+			return retried, failed, err
+		}
+		for _, command := range overdue {
+			if !now.Before(command.ExpiresAt) {
+				// no retry past the ttl, the command expires instead
+				if err := s.expire(ctx, command, now); err != nil {
+					return retried, failed, err
+				}
+				failed++
+				continue
+			}
+			if command.Attempts >= command.MaxAttempts {
+				if command.Advance(model.CommandStatusFailed, now) {
+					command.Error = fmt.Sprintf("no acknowledgement after %d attempt(s)", command.Attempts)
+					if err := s.commandRepo.Update(ctx, command); err != nil {
+						return retried, failed, err
+					}
+					s.publishTimeout(ctx, command, now)
+					failed++
+				}
+				continue
+			}
+			payload, err := commandPayload(command)
+			if err != nil {
+				return retried, failed, err
+			}
+			s.publish(ctx, command, payload, now)
+			if err := s.commandRepo.Update(ctx, command); err != nil {
+				return retried, failed, err
+			}
+			retried++
+		}
+		if len(overdue) < commandExpiryBatchSize {
+			return retried, failed, nil
+		}
+	}
+}
+
+// ExpireOverdue marks the unfinished commands past their expiry as expired.
+func (s *CommandService) ExpireOverdue(ctx context.Context, now time.Time) (int, error) {
+	expired := 0
+	for {
+		commands, err := s.commandRepo.ListExpired(ctx, now, commandExpiryBatchSize)
+		if err != nil {
+			return expired, err
+		}
+		for _, command := range commands {
+			if command.IsFinished() {
+				continue
+			}
+			if err := s.expire(ctx, command, now); err != nil {
+				return expired, err
+			}
+			expired++
+		}
+		if len(commands) < commandExpiryBatchSize {
+			return expired, nil
+		}
+	}
+}
+
+// expire marks the command expired and reports its timeout.
+func (s *CommandService) expire(ctx context.Context, command *model.Command, now time.Time) error {
+	command.Advance(model.CommandStatusExpired, now)
+	command.Error = "command did not finish before it expired"
+	if err := s.commandRepo.Update(ctx, command); err != nil {
+		return err
+	}
+	s.publishTimeout(ctx, command, now)
+	return nil
+}
+
+// publishTimeout publishes the command_failed event of a command that ran out of time.
+func (s *CommandService) publishTimeout(ctx context.Context, command *model.Command, now time.Time) {
+	event := model.DeviceEvent{
+		ID:          uuid.New(),
+		Type:        model.EventTypeCommandFailed,
+		DeviceID:    command.DeviceID,
+		CommandCode: command.CommandCode,
+		Timestamp:   now,
+		Payload: map[string]interface{}{
+			"reason":   commandFailureTimeout,
+			"error":    command.Error,
+			"status":   command.Status,
+			"attempts": command.Attempts,
+		},
+		CorrelationID: command.ID,
+	}
+	// a rpc still waiting gets the failure instead of running into its own timeout
+	if err := s.publisher.Publish(ctx, pubsub.NatsTopicCommandRepliesPrefixf(command.ID), event); err != nil {
+		s.l.Warn("failed to publish command timeout reply", zap.String("command_id", command.ID.String()), zap.Error(err))
+	}
+	if err := s.publisher.Publish(ctx, pubsub.NatsTopicCommandStatus, event); err != nil {
+		s.l.Warn("failed to publish command timeout", zap.String("command_id", command.ID.String()), zap.Error(err))
+	}
+}
+
+// deviceCommand is the message the device gets for the current attempt of the command.
+func deviceCommand(command *model.Command, payload map[string]interface{}, now time.Time) model.DeviceCommand {
+	idempotencyKey := command.ID.String()
+	if command.IdempotencyKey != nil {
+		idempotencyKey = *command.IdempotencyKey
+	}
+	return model.DeviceCommand{
+		ID:             command.ID,
+		DeviceID:       command.DeviceID,
+		Type:           model.CommandTypeCommand,
+		Command:        command.Command,
+		CommandCode:    command.CommandCode,
+		Payload:        payload,
+		Timestamp:      now,
+		IdempotencyKey: idempotencyKey,
+		Attempt:        command.Attempts,
+		SchemaVersion:  command.SchemaVersion,
+	}
+}
+
+// userCommandSchema looks up the schema of a command users send to devices, commands of any other direction are
+// rejected.
+func userCommandSchema(registry *config.DeviceCommandSchemaRegistry, commandCode string) (*config.CommandSchema, error) {
+	schema := registry.GetCommandByCode(commandCode)
+	if schema == nil {
+		return nil, apperror.ErrValidation.WithMessagef("unknown command code %s", commandCode)
+	}
+	if direction := schema.Endpoints(); direction.From != config.EndpointUser || !direction.ToDevice() {
+		return nil, apperror.ErrValidation.WithMessagef("%s (%s) can't be sent by users, only u>d commands can", commandCode, schema.Direction)
+	}
+	return schema, nil
+}
+
+// commandPayload decodes the stored payload of the command.
+func commandPayload(command *model.Command) (map[string]interface{}, error) {
+	var payload map[string]interface{}
+	if len(command.Payload) > 0 {
+		if err := json.Unmarshal(command.Payload, &payload); err != nil {
+			return nil, apperror.ErrValidation.WithMessage("payload must be a json object").Wrap(err)
+		}
+	}
+	return payload, nil
+}
+
+// sameJSON reports whether both documents hold the same value, regardless of formatting and key order.
+func sameJSON(a, b []byte) bool {
+	var va, vb interface{}
+	if len(a) > 0 {
+		if err := json.Unmarshal(a, &va); err != nil {
+			return false
+		}
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &vb); err != nil {
+			return false
+		}
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -10,8 +10,10 @@ import (
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/commands"
 	"github.com/vars7899/iots/internal/domain/model"
 	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
 	"github.com/vars7899/iots/pkg/apperror"
 	"github.com/vars7899/iots/pkg/logger"
 	"github.com/vars7899/iots/pkg/pubsub"
@@ -20,8 +22,11 @@ import (
 
 type CommandProcessorService struct {
 	commandRegistry *config.DeviceCommandSchemaRegistry
+	handlers        *commands.Registry
 	pubsub          pubsub.PubSubPublisher
 	deviceRepo      repository.DeviceRepository
+	deviceStatus    *service.DeviceStatusService
+	deadLetters     *service.DeadLetterService
 	inboundSub      *nats.Subscription
 	mu              sync.RWMutex
 	ctx             context.Context
@@ -29,13 +34,24 @@ type CommandProcessorService struct {
 	logger          *zap.Logger
 }
 
-func NewCommandProcessorService(reg *config.DeviceCommandSchemaRegistry, pubsub pubsub.PubSubPublisher, deviceRepo repository.DeviceRepository, baseLogger *zap.Logger) (*CommandProcessorService, error) {
+func NewCommandProcessorService(reg *config.DeviceCommandSchemaRegistry, handlers *commands.Registry, pubsub pubsub.PubSubPublisher, deviceRepo repository.DeviceRepository, deviceStatus *service.DeviceStatusService, deadLetters *service.DeadLetterService, baseLogger *zap.Logger) (*CommandProcessorService, error) {
 	logger := logger.Named(baseLogger, "CommandProcessorService")
 
 	if reg == nil {
 		logger.Error("missing device command schema registry")
 		return nil, apperror.ErrMissingDependency.WithMessage("device command schema registry is nil").AsInternal()
 	}
+	if handlers == nil {
+		logger.Error("missing command handler registry")
+		return nil, apperror.ErrMissingDependency.WithMessage("command handler registry is nil").AsInternal()
+	}
+	// every command the server receives needs a handler, better to fail now than on the first message, and a hot
+	// reload adding one without a handler is rejected
+	if err := handlers.Validate(reg.GetConfig()); err != nil {
+		logger.Error("command handler registry is incomplete", zap.Error(err))
+		return nil, err
+	}
+	reg.AddReloadCheck(handlers.Validate)
 	if pubsub == nil {
 		logger.Error("missing pubsub publisher")
 		return nil, apperror.ErrMissingDependency.WithMessage("pubsub publisher is nil").AsInternal()
@@ -44,13 +60,24 @@ func NewCommandProcessorService(reg *config.DeviceCommandSchemaRegistry, pubsub
 		logger.Error("missing device repository")
 		return nil, apperror.ErrMissingDependency.WithMessage("device repository is nil").AsInternal()
 	}
+	if deviceStatus == nil {
+		logger.Error("missing device status service")
+		return nil, apperror.ErrMissingDependency.WithMessage("device status service is nil").AsInternal()
+	}
+	if deadLetters == nil {
+		logger.Error("missing dead letter service")
+		return nil, apperror.ErrMissingDependency.WithMessage("dead letter service is nil").AsInternal()
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &CommandProcessorService{
 		commandRegistry: reg,
+		handlers:        handlers,
 		pubsub:          pubsub,
 		deviceRepo:      deviceRepo,
+		deviceStatus:    deviceStatus,
+		deadLetters:     deadLetters,
 		ctx:             ctx,
 		cancel:          cancel,
 		logger:          logger,
@@ -111,9 +138,16 @@ func (s *CommandProcessorService) handleMessage(ctx context.Context, messageData
 		res.Payload["error_code"] = "unmarshal_error"
 
 		s.publishEvent(ctx, pubsub.NatsTopicSystemEvents, res)
+		s.deadLetter(ctx, messageData, cmd, "unmarshal_error", err.Error())
 		return
 	}
 
+	// a failed command is kept as a dead letter with the message as received, it can be fixed and re-driven
+	fail := func(errCode, errMessage string, details map[string]interface{}) {
+		s.publishErrorEventWithDetails(ctx, cmd, errCode, errMessage, details)
+		s.deadLetter(ctx, messageData, cmd, errCode, errMessage)
+	}
+
 	// now populate event with data from the command
 	res.DeviceID = cmd.DeviceID
 	res.CommandCode = cmd.CommandCode
@@ -124,7 +158,7 @@ func (s *CommandProcessorService) handleMessage(ctx context.Context, messageData
 	s.logger.Info("Received command", zap.String("command", cmd.CommandCode))
 
 	if cmd.DeviceID.String() == "" {
-		s.publishErrorEvent(ctx, cmd, "missing_device_id", "device id is required for command")
+		fail("missing_device_id", "device id is required for command", nil)
 		return
 	}
 
@@ -141,31 +175,80 @@ func (s *CommandProcessorService) handleMessage(ctx context.Context, messageData
 			errMessage = "internal error while retrieving device"
 		}
 
-		s.publishErrorEvent(ctx, cmd, errCode, errMessage)
+		fail(errCode, errMessage, nil)
 		return
 	}
 	if device == nil {
-		s.publishErrorEvent(ctx, cmd, "device_not_found", fmt.Sprintf("device with id '%s' not found", cmd.DeviceID))
+		fail("device_not_found", fmt.Sprintf("device with id '%s' not found", cmd.DeviceID), nil)
 		return
 	}
 
 	commandSchema := s.commandRegistry.GetCommandByCode(cmd.CommandCode)
 	if commandSchema == nil {
 		s.logger.Error("Received command with unknown code", zap.String("command", cmd.Command))
-		s.publishErrorEvent(ctx, cmd, "unknown_command", fmt.Sprintf("unknown command code: %s", cmd.CommandCode))
+		fail("unknown_command", fmt.Sprintf("unknown command code: %s", cmd.CommandCode), nil)
+		return
+	}
+
+	// a device reply to a command sent to it, it only moves that command along
+	if cmd.CorrelationID != uuid.Nil {
+		if !commandSchema.Endpoints().ToDevice() {
+			fail("direction_not_allowed", fmt.Sprintf("%s (%s) is not sent to devices, it can't be answered", cmd.CommandCode, commandSchema.Direction), nil)
+			return
+		}
+		if _, ok := replyEventTypes[cmd.Type]; !ok {
+			fail("invalid_reply", fmt.Sprintf("command type %q cannot answer a command", cmd.Type), nil)
+			return
+		}
+		s.handleReply(ctx, cmd)
+		return
+	}
+
+	// only commands from devices to the server are processed, e.g. a device sending the s>u device@init_ack
+	direction := commandSchema.Endpoints()
+	if !direction.FromDevice() || direction.To != config.EndpointServer {
+		fail("direction_not_allowed", fmt.Sprintf("%s (%s) can't be sent by a device to the server", cmd.CommandCode, commandSchema.Direction), nil)
+		return
+	}
+
+	// the payload follows the version the command names, or else the one the device negotiated
+	version := cmd.SchemaVersion
+	if version == 0 {
+		version = device.CommandVersion(cmd.CommandCode)
+	}
+	versionedSchema := commandSchema.Version(version)
+	if versionedSchema == nil {
+		fail("unsupported_version", fmt.Sprintf("version %d of %s is not active", version, cmd.CommandCode), map[string]interface{}{
+			"active_versions": commandSchema.ActiveVersions(),
+		})
+		return
+	}
+	commandSchema = versionedSchema
+
+	if violations := commands.ValidatePayload(&commandSchema.Payload, cmd.Payload); len(violations) > 0 {
+		fail("invalid_payload", fmt.Sprintf("payload of %s has %d violation(s)", cmd.CommandCode, len(violations)), map[string]interface{}{
+			"violations": violations,
+		})
 		return
 	}
 
 	originalStatus := device.Status // to track change
 
-	processResult, err := s.processCommand(ctx, cmd, device)
+	req := &commands.Request{
+		Command: cmd,
+		Schema:  commandSchema,
+		Schemas: s.commandRegistry,
+		Device:  device,
+		Repos:   &commands.Repositories{Devices: s.deviceRepo},
+	}
+	processResult, err := s.processCommand(ctx, req)
 
 	if err != nil {
 		// If there was an error processing the command
 		if appErr, ok := err.(*apperror.AppError); ok {
-			s.publishErrorEvent(ctx, cmd, string(appErr.Code), appErr.Message)
+			fail(string(appErr.Code), appErr.Message, nil)
 		} else {
-			s.publishErrorEvent(ctx, cmd, "PROCESSING_ERROR", err.Error())
+			fail("PROCESSING_ERROR", err.Error(), nil)
 		}
 		return
 	}
@@ -175,33 +258,25 @@ func (s *CommandProcessorService) handleMessage(ctx context.Context, messageData
 	// Add other state change checks as needed
 	// || originalFirmwareVersion != device.FirmwareVersion
 
-	// If state changed, update database
+	// the status the device reported goes through its lifecycle, which also publishes the state change
 	if stateChanged {
-		if updateErr := s.deviceRepo.UpdateStatus(ctx, device.ID, device.Status); updateErr != nil {
-			appErr := apperror.MapDBError(updateErr, "device state update")
+		change := &model.DeviceStatusChange{
+			DeviceID:  device.ID,
+			ToStatus:  device.Status,
+			Reason:    fmt.Sprintf("reported by the device with %s", cmd.CommandCode),
+			ActorType: model.DeviceStatusActorDevice,
+			ActorID:   &device.ID,
+			Source:    cmd.CommandCode,
+		}
+		if _, updateErr := s.deviceStatus.Transition(ctx, change); updateErr != nil {
+			appErr := apperror.ErrorHandler(updateErr, apperror.ErrCodeDBUpdate)
 			s.logger.Error("Failed to update device state",
 				zap.String("device_id", cmd.DeviceID.String()),
 				zap.Error(appErr))
 
-			s.publishErrorEvent(ctx, cmd, string(appErr.Code), fmt.Sprintf("Failed to update device state: %s", appErr.Message))
+			fail(string(appErr.Code), fmt.Sprintf("Failed to update device state: %s", appErr.Message), nil)
 			return
 		}
-
-		// Publish state change event
-		stateEvent := model.DeviceEvent{
-			Type:        model.EventTypeStateChanged,
-			DeviceID:    cmd.DeviceID,
-			CommandCode: cmd.CommandCode,
-			Timestamp:   time.Now(),
-			Payload: map[string]interface{}{
-				"previous_status": originalStatus,
-				"current_status":  device.Status,
-				"device":          device,
-			},
-		}
-
-		deviceEventTopic := fmt.Sprintf("%s.%s", pubsub.NatsTopicDeviceEventsPrefix, cmd.DeviceID)
-		s.publishEvent(ctx, deviceEventTopic, stateEvent)
 	}
 
 	// Publish success event
@@ -218,45 +293,97 @@ func (s *CommandProcessorService) handleMessage(ctx context.Context, messageData
 	topic := pubsub.NatsTopicCommandsOutboundPrefixf(cmd.DeviceID)
 	s.publishEvent(ctx, topic, successEvent)
 
+	for _, message := range req.UserMessages {
+		s.notifyUsers(ctx, cmd, message)
+	}
+
 	s.logger.Info("Command processed successfully",
 		zap.String("command", cmd.CommandCode),
 		zap.String("device_id", cmd.DeviceID.String()))
 }
 
-// processCommand handles the actual command logic
-func (s *CommandProcessorService) processCommand(ctx context.Context, cmd model.DeviceCommand, device *model.Device) (map[string]interface{}, error) {
-	// This is where command-specific logic lives
-	switch cmd.CommandCode {
-	case "device@init_ack":
-		s.logger.Info("Processing device initialization acknowledgment",
-			zap.String("device_id", cmd.DeviceID.String()))
-
-		// Update device status to online
-		device.Status = model.DeviceStatusOnline
-
-		return map[string]interface{}{
-			"message": "Device initialized successfully",
-			"status":  device.Status,
-		}, nil
-
-	// Add more command handlers here
-	case "device@status_update":
-		// Handle status update command
-		return map[string]interface{}{
-			"message": "Status updated",
-		}, nil
-
-	case "device@reboot":
-		// Handle reboot command
-		return map[string]interface{}{
-			"message": "Reboot initiated",
-		}, nil
-
-	default:
-		return nil, apperror.Errorf(apperror.ErrCodeBadRequest, "Unsupported command code: %s", cmd.CommandCode)
+// processCommand runs the handler registered for the command code
+func (s *CommandProcessorService) processCommand(ctx context.Context, req *commands.Request) (map[string]interface{}, error) {
+	handler, ok := s.handlers.Handler(req.Command.CommandCode)
+	if !ok {
+		return nil, apperror.Errorf(apperror.ErrCodeBadRequest, "Unsupported command code: %s", req.Command.CommandCode)
+	}
+	return handler.Handle(ctx, req)
+}
+
+// notifyUsers publishes a s>u message of a handler to the users subscribed to the device, messages not matching
+// their schema are dropped.
+func (s *CommandProcessorService) notifyUsers(ctx context.Context, cmd model.DeviceCommand, message commands.UserMessage) {
+	schema := s.commandRegistry.GetCommandByCode(message.CommandCode)
+	if schema == nil || schema.Endpoints() != (config.CommandDirection{From: config.EndpointServer, To: config.EndpointUser}) {
+		s.logger.Error("Dropping user message that isn't a s>u command", zap.String("command", message.CommandCode))
+		return
+	}
+	if violations := commands.ValidatePayload(&schema.Payload, message.Payload); len(violations) > 0 {
+		s.logger.Error("Dropping user message with invalid payload",
+			zap.String("command", message.CommandCode),
+			zap.Any("violations", violations))
+		return
+	}
+
+	userMessage := model.DeviceCommand{
+		ID:            uuid.New(),
+		DeviceID:      cmd.DeviceID,
+		Type:          model.DeviceCommandType(schema.Type),
+		Command:       schema.Command,
+		CommandCode:   message.CommandCode,
+		Payload:       message.Payload,
+		Timestamp:     time.Now(),
+		CorrelationID: cmd.ID,
+	}
+	topic := pubsub.NatsTopicUserMessagesPrefixf(cmd.DeviceID)
+	if err := s.pubsub.Publish(ctx, topic, userMessage); err != nil {
+		s.logger.Warn("Failed to publish user message",
+			zap.String("topic", topic),
+			zap.String("command", message.CommandCode),
+			zap.Error(err),
+		)
 	}
 }
 
+// replyEventTypes maps the reply types a device answers a command with to the command status event they raise.
+var replyEventTypes = map[model.DeviceCommandType]string{
+	model.CommandTypeAck:      model.EventTypeCommandAcknowledged,
+	model.CommandTypeResponse: model.EventTypeCommandCompleted,
+	model.CommandTypeError:    model.EventTypeCommandFailed,
+}
+
+// handleReply publishes the status event of the command the device replied to, the reply payload is the event payload.
+// The reply type is one of replyEventTypes.
+func (s *CommandProcessorService) handleReply(ctx context.Context, cmd model.DeviceCommand) {
+	eventType := replyEventTypes[cmd.Type]
+
+	payload := cmd.Payload
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	event := model.DeviceEvent{
+		ID:            uuid.New(),
+		Type:          eventType,
+		DeviceID:      cmd.DeviceID,
+		CommandCode:   cmd.CommandCode,
+		Timestamp:     cmd.Timestamp,
+		Payload:       payload,
+		CorrelationID: cmd.CorrelationID,
+	}
+	s.publishEvent(ctx, pubsub.NatsTopicCommandStatus, event)
+	if event.Type != model.EventTypeCommandAcknowledged {
+		// the answer of a rpc command, nobody listens when the command wasn't sent as one
+		s.publishEvent(ctx, pubsub.NatsTopicCommandRepliesPrefixf(cmd.CorrelationID), event)
+	}
+
+	s.logger.Info("Command reply received",
+		zap.String("command", cmd.CommandCode),
+		zap.String("type", string(cmd.Type)),
+		zap.String("device_id", cmd.DeviceID.String()),
+		zap.String("correlation_id", cmd.CorrelationID.String()))
+}
+
 func (s *CommandProcessorService) publishEvent(ctx context.Context, topic string, event model.DeviceEvent) {
 	if err := s.pubsub.Publish(ctx, topic, event); err != nil {
 		s.logger.Warn("Failed to publish event",
@@ -274,8 +401,8 @@ func (s *CommandProcessorService) publishEvent(ctx context.Context, topic string
 	)
 }
 
-// publishErrorEvent publishes an error event
-func (s *CommandProcessorService) publishErrorEvent(ctx context.Context, cmd model.DeviceCommand, errCode, errMessage string) {
+// publishErrorEventWithDetails publishes an error event carrying extra payload fields, e.g. the payload violations
+func (s *CommandProcessorService) publishErrorEventWithDetails(ctx context.Context, cmd model.DeviceCommand, errCode, errMessage string, details map[string]interface{}) {
 	event := model.DeviceEvent{
 		Type:        model.EventTypeError,
 		DeviceID:    cmd.DeviceID,
@@ -288,6 +415,9 @@ func (s *CommandProcessorService) publishErrorEvent(ctx context.Context, cmd mod
 		},
 		CorrelationID: cmd.ID,
 	}
+	for key, value := range details {
+		event.Payload[key] = value
+	}
 
 	// nats: publish to both the device-specific channel and system events
 	if cmd.DeviceID.String() != "" {
@@ -305,6 +435,21 @@ func (s *CommandProcessorService) publishErrorEvent(ctx context.Context, cmd mod
 		zap.String("device_id", cmd.DeviceID.String()))
 }
 
+// deadLetter keeps a message that failed as received, cmd has what could be decoded of it.
+func (s *CommandProcessorService) deadLetter(ctx context.Context, messageData []byte, cmd model.DeviceCommand, errCode, errMessage string) {
+	letter := &model.DeadLetter{
+		Subject:     pubsub.NatsTopicCommandsInbound,
+		Message:     string(messageData),
+		CommandCode: cmd.CommandCode,
+		ErrorCode:   errCode,
+		Error:       errMessage,
+	}
+	if cmd.DeviceID != uuid.Nil {
+		letter.DeviceID = &cmd.DeviceID
+	}
+	s.deadLetters.Record(ctx, letter)
+}
+
 // Stop gracefully shuts down the service
 func (s *CommandProcessorService) Stop() error {
 	s.mu.Lock()
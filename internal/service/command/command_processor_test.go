@@ -0,0 +1,150 @@
+package command_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/commands"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/internal/service/command"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+type fakeDeviceRepo struct {
+	repository.DeviceRepository
+	device *model.Device
+}
+
+func (r *fakeDeviceRepo) GetByID(ctx context.Context, deviceID uuid.UUID) (*model.Device, error) {
+	if r.device.ID != deviceID {
+		return nil, nil
+	}
+	return r.device, nil
+}
+
+type fakeDeadLetterRepo struct {
+	repository.DeadLetterRepository
+	mu      sync.Mutex
+	letters []*model.DeadLetter
+}
+
+func (r *fakeDeadLetterRepo) Record(ctx context.Context, letter *model.DeadLetter) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *letter
+	r.letters = append(r.letters, &copied)
+	return nil
+}
+
+func (r *fakeDeadLetterRepo) errorCodes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	codes := make([]string, 0, len(r.letters))
+	for _, letter := range r.letters {
+		codes = append(codes, letter.ErrorCode)
+	}
+	return codes
+}
+
+// inboundPublisher feeds the processor the messages of the inbound subject and keeps the events it publishes.
+type inboundPublisher struct {
+	pubsub.PubSubPublisher
+	inbound chan []byte
+	mu      sync.Mutex
+	events  map[string][]model.DeviceEvent
+}
+
+func (p *inboundPublisher) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	return p.inbound, nil
+}
+
+func (p *inboundPublisher) Unsubscribe(ctx context.Context, topic string) error {
+	return nil
+}
+
+func (p *inboundPublisher) Publish(ctx context.Context, topic string, message interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if event, ok := message.(model.DeviceEvent); ok {
+		p.events[topic] = append(p.events[topic], event)
+	}
+	return nil
+}
+
+func (p *inboundPublisher) published(topic string) []model.DeviceEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]model.DeviceEvent(nil), p.events[topic]...)
+}
+
+func TestCommandProcessorChecksTheDirectionOfDeviceMessages(t *testing.T) {
+	registry := config.NewDeviceCommandSchemaRegistry()
+	require.NoError(t, registry.LoadDeviceCommandSchemaRegistry("device.command.schema", "yaml", "../../../configs", zap.NewNop()))
+	handlers := commands.NewRegistry()
+	require.NoError(t, commands.RegisterDeviceHandlers(handlers))
+
+	device := &model.Device{ID: uuid.New(), Status: model.DeviceStatusOnline}
+	deviceRepo := &fakeDeviceRepo{device: device}
+	publisher := &inboundPublisher{inbound: make(chan []byte), events: map[string][]model.DeviceEvent{}}
+	deadLetterRepo := &fakeDeadLetterRepo{}
+
+	processor, err := command.NewCommandProcessorService(registry, handlers, publisher, deviceRepo,
+		service.NewDeviceStatusService(deviceRepo, publisher, zap.NewNop()),
+		service.NewDeadLetterService(deadLetterRepo, publisher, nil, zap.NewNop()), zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, processor.Start())
+	defer processor.Stop()
+
+	send := func(cmd model.DeviceCommand) {
+		cmd.ID, cmd.DeviceID, cmd.Timestamp = uuid.New(), device.ID, time.Now()
+		message, err := json.Marshal(cmd)
+		require.NoError(t, err)
+		publisher.inbound <- message
+	}
+	deviceErrors := pubsub.NatsTopicCommandsOutboundPrefixf(device.ID)
+
+	// the server answers device@init with the s>u device@init_ack, a device can't send it
+	send(model.DeviceCommand{Type: model.CommandTypeAck, Command: "init_ack", CommandCode: "device@init_ack", Payload: map[string]interface{}{"status": "ready"}})
+	// device@reboot goes u>d, a device only sends it back as a reply carrying the id of the command
+	send(model.DeviceCommand{Type: model.CommandTypeCommand, Command: "reboot", CommandCode: "device@reboot"})
+	// a reply needs a reply type
+	send(model.DeviceCommand{Type: model.CommandTypeCommand, Command: "reboot", CommandCode: "device@reboot", CorrelationID: uuid.New()})
+
+	require.Eventually(t, func() bool { return len(deadLetterRepo.errorCodes()) == 3 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"direction_not_allowed", "direction_not_allowed", "invalid_reply"}, deadLetterRepo.errorCodes())
+	rejected := publisher.published(deviceErrors)
+	require.Len(t, rejected, 3, "the device hears about each rejected message")
+	for _, event := range rejected {
+		assert.Equal(t, model.EventTypeError, event.Type)
+	}
+	assert.Empty(t, publisher.published(pubsub.NatsTopicCommandStatus), "nothing was answered")
+
+	// a valid reply moves the command it answers along, the reply of a rpc command also goes to its reply subject
+	rebootID := uuid.New()
+	send(model.DeviceCommand{Type: model.CommandTypeAck, Command: "reboot", CommandCode: "device@reboot", CorrelationID: rebootID})
+	send(model.DeviceCommand{Type: model.CommandTypeResponse, Command: "reboot", CommandCode: "device@reboot", CorrelationID: rebootID, Payload: map[string]interface{}{"rebooted": true}})
+
+	require.Eventually(t, func() bool { return len(publisher.published(pubsub.NatsTopicCommandStatus)) == 2 }, time.Second, 10*time.Millisecond)
+	statuses := publisher.published(pubsub.NatsTopicCommandStatus)
+	assert.Equal(t, model.EventTypeCommandAcknowledged, statuses[0].Type)
+	assert.Equal(t, model.EventTypeCommandCompleted, statuses[1].Type)
+	for _, event := range statuses {
+		assert.Equal(t, rebootID, event.CorrelationID)
+		assert.Equal(t, device.ID, event.DeviceID)
+	}
+	replies := publisher.published(pubsub.NatsTopicCommandRepliesPrefixf(rebootID))
+	require.Len(t, replies, 1, "an ack isn't the answer of a rpc command")
+	assert.Equal(t, true, replies[0].Payload["rebooted"])
+	assert.Len(t, deadLetterRepo.errorCodes(), 3, "replies aren't dead letters")
+	assert.Len(t, publisher.published(deviceErrors), 3)
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/commands"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pagination"
+	"go.uber.org/zap"
+)
+
+const campaignDevicePageSize = 500
+
+// CommandCampaignService rolls a command out to a fleet of devices wave by wave. The devices and their waves are
+// fixed when the campaign is created, only the leading command publisher calls Advance to send the waves.
+type CommandCampaignService struct {
+	campaignRepo   repository.CommandCampaignRepository
+	deviceRepo     repository.DeviceRepository
+	commandService *CommandService
+	registry       *config.DeviceCommandSchemaRegistry
+	l              *zap.Logger
+}
+
+func NewCommandCampaignService(campaignRepo repository.CommandCampaignRepository, deviceRepo repository.DeviceRepository, commandService *CommandService, registry *config.DeviceCommandSchemaRegistry, baseLogger *zap.Logger) *CommandCampaignService {
+	return &CommandCampaignService{
+		campaignRepo:   campaignRepo,
+		deviceRepo:     deviceRepo,
+		commandService: commandService,
+		registry:       registry,
+		l:              logger.Named(baseLogger, "CommandCampaignService"),
+	}
+}
+
+// CreateCampaign selects the devices of the campaign and splits them into its waves, the first wave is sent on the
+// next rollout.
+func (s *CommandCampaignService) CreateCampaign(ctx context.Context, campaign *model.CommandCampaign) (*model.CommandCampaign, error) {
+	if err := campaign.Validate(); err != nil {
+		return nil, apperror.ErrValidation.WithMessage(err.Error()).Wrap(err)
+	}
+	commandSchema, err := userCommandSchema(s.registry, campaign.CommandCode)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := commandPayload(&model.Command{Payload: campaign.Payload})
+	if err != nil {
+		return nil, err
+	}
+	if violations := commands.ValidatePayload(&commandSchema.Payload, payload); len(violations) > 0 {
+		return nil, apperror.ErrValidation.WithMessagef("payload of %s has %d violation(s)", campaign.CommandCode, len(violations)).WithDetails(map[string]interface{}{
+			"violations": violations,
+		})
+	}
+
+	devices, err := s.selectDevices(ctx, campaign)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, apperror.ErrValidation.WithMessage("no device matches the campaign")
+	}
+
+	var targets []*model.CommandCampaignTarget
+	campaign.Waves = nil
+	for i, size := range campaign.PlanWaves(len(devices)) {
+		campaign.Waves = append(campaign.Waves, &model.CommandCampaignWave{Number: i + 1, Size: size})
+		for _, device := range devices[len(targets) : len(targets)+size] {
+			targets = append(targets, &model.CommandCampaignTarget{DeviceID: device.ID, Wave: i + 1})
+		}
+	}
+	campaign.TotalDevices = len(devices)
+	campaign.CurrentWave = 0
+	campaign.Status = model.CampaignStatusRunning
+
+	if err := s.campaignRepo.Create(ctx, campaign, targets); err != nil {
+		return nil, err
+	}
+	s.l.Info("command campaign created",
+		zap.String("campaign_id", campaign.ID.String()),
+		zap.String("command", campaign.CommandCode),
+		zap.Int("devices", campaign.TotalDevices),
+		zap.Int("waves", len(campaign.Waves)),
+	)
+	return campaign, nil
+}
+
+// GetCampaign returns the campaign with the progress of each of its waves.
+func (s *CommandCampaignService) GetCampaign(ctx context.Context, campaignID uuid.UUID) (*model.CommandCampaign, error) {
+	campaign, err := s.campaignRepo.GetByID(ctx, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	progress, err := s.campaignRepo.WaveProgress(ctx, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	byWave := make(map[int]*model.CampaignWaveProgress, len(progress))
+	for _, p := range progress {
+		byWave[p.Wave] = p
+	}
+	for _, wave := range campaign.Waves {
+		wave.Progress = byWave[wave.Number]
+	}
+	return campaign, nil
+}
+
+func (s *CommandCampaignService) ListCampaigns(ctx context.Context, statuses []model.CampaignStatus) ([]*model.CommandCampaign, error) {
+	return s.campaignRepo.List(ctx, statuses)
+}
+
+// PauseCampaign stops the campaign before its next wave, the commands of a wave already sent keep running.
+func (s *CommandCampaignService) PauseCampaign(ctx context.Context, campaignID uuid.UUID, reason string) (*model.CommandCampaign, error) {
+	if reason == "" {
+		reason = "paused by user"
+	}
+	return s.transition(ctx, campaignID, []model.CampaignStatus{model.CampaignStatusRunning}, model.CampaignStatusPaused, reason)
+}
+
+// ResumeCampaign continues a paused campaign. When the current wave paused it by failing too often, its failures
+// are accepted and the campaign moves on once the rest of the wave finished.
+func (s *CommandCampaignService) ResumeCampaign(ctx context.Context, campaignID uuid.UUID) (*model.CommandCampaign, error) {
+	campaign, err := s.GetCampaign(ctx, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if campaign.Status != model.CampaignStatusPaused {
+		return nil, apperror.ErrConflict.WithMessagef("campaign is %s", campaign.Status)
+	}
+	if wave := currentWave(campaign); wave != nil && wave.Progress != nil && !wave.FailureAccepted &&
+		campaign.FailureRateExceeded(wave.Progress.Failed, wave.Size) {
+		wave.FailureAccepted = true
+		if err := s.campaignRepo.UpdateWave(ctx, wave); err != nil {
+			return nil, err
+		}
+	}
+	return s.transition(ctx, campaignID, []model.CampaignStatus{model.CampaignStatusPaused}, model.CampaignStatusRunning, "")
+}
+
+// AbortCampaign stops the campaign for good, devices of waves not sent yet never get the command.
+func (s *CommandCampaignService) AbortCampaign(ctx context.Context, campaignID uuid.UUID, reason string) (*model.CommandCampaign, error) {
+	if reason == "" {
+		reason = "aborted by user"
+	}
+	return s.transition(ctx, campaignID, []model.CampaignStatus{model.CampaignStatusRunning, model.CampaignStatusPaused}, model.CampaignStatusAborted, reason)
+}
+
+func (s *CommandCampaignService) transition(ctx context.Context, campaignID uuid.UUID, from []model.CampaignStatus, to model.CampaignStatus, reason string) (*model.CommandCampaign, error) {
+	moved, err := s.campaignRepo.Transition(ctx, campaignID, from, to, reason)
+	if err != nil {
+		return nil, err
+	}
+	campaign, err := s.GetCampaign(ctx, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if !moved {
+		return nil, apperror.ErrConflict.WithMessagef("campaign is %s", campaign.Status)
+	}
+	s.l.Info("command campaign status changed",
+		zap.String("campaign_id", campaignID.String()),
+		zap.String("status", string(to)),
+		zap.String("reason", reason),
+	)
+	return campaign, nil
+}
+
+// Advance moves every running campaign on and returns the number of waves it started. A campaign whose current
+// wave failed too often is paused, one whose last wave finished is completed.
+func (s *CommandCampaignService) Advance(ctx context.Context, now time.Time) (int, error) {
+	campaigns, err := s.campaignRepo.List(ctx, []model.CampaignStatus{model.CampaignStatusRunning})
+	if err != nil {
+		return 0, err
+	}
+	started := 0
+	for _, campaign := range campaigns {
+		waveStarted, err := s.advance(ctx, campaign.ID, now)
+		if err != nil {
+			s.l.Error("failed to advance command campaign", zap.String("campaign_id", campaign.ID.String()), zap.Error(err))
+			continue
+		}
+		if waveStarted {
+			started++
+		}
+	}
+	return started, nil
+}
+
+func (s *CommandCampaignService) advance(ctx context.Context, campaignID uuid.UUID, now time.Time) (bool, error) {
+	campaign, err := s.GetCampaign(ctx, campaignID)
+	if err != nil {
+		return false, err
+	}
+	wave := currentWave(campaign)
+	if wave == nil {
+		return true, s.startWave(ctx, campaign, campaign.Waves[0], now)
+	}
+
+	progress := wave.Progress
+	if progress == nil {
+		progress = &model.CampaignWaveProgress{Wave: wave.Number}
+	}
+	if progress.Unsent > 0 {
+		// the publisher stopped while sending the wave
+		return false, s.sendWave(ctx, campaign, wave)
+	}
+	if !wave.FailureAccepted && campaign.FailureRateExceeded(progress.Failed, wave.Size) {
+		reason := fmt.Sprintf("wave %d: %d of %d device(s) failed, more than %d%%", wave.Number, progress.Failed, wave.Size, campaign.MaxFailurePercent)
+		_, err := s.campaignRepo.Transition(ctx, campaign.ID, []model.CampaignStatus{model.CampaignStatusRunning}, model.CampaignStatusPaused, reason)
+		if err == nil {
+			s.l.Warn("command campaign paused", zap.String("campaign_id", campaign.ID.String()), zap.String("reason", reason))
+		}
+		return false, err
+	}
+	if progress.Pending() > 0 {
+		return false, nil
+	}
+
+	if wave.FinishedAt == nil {
+		wave.FinishedAt = &now
+		if err := s.campaignRepo.UpdateWave(ctx, wave); err != nil {
+			return false, err
+		}
+		s.l.Info("command campaign wave finished",
+			zap.String("campaign_id", campaign.ID.String()),
+			zap.Int("wave", wave.Number),
+			zap.Int("completed", progress.Completed),
+			zap.Int("failed", progress.Failed),
+		)
+	}
+	if wave.Number == len(campaign.Waves) {
+		_, err := s.campaignRepo.Transition(ctx, campaign.ID, []model.CampaignStatus{model.CampaignStatusRunning}, model.CampaignStatusCompleted, "")
+		return false, err
+	}
+	if now.Before(wave.FinishedAt.Add(time.Duration(campaign.WaveIntervalSeconds) * time.Second)) {
+		return false, nil
+	}
+	return true, s.startWave(ctx, campaign, campaign.Waves[wave.Number], now)
+}
+
+func (s *CommandCampaignService) startWave(ctx context.Context, campaign *model.CommandCampaign, wave *model.CommandCampaignWave, now time.Time) error {
+	if err := s.campaignRepo.SetCurrentWave(ctx, campaign.ID, wave.Number); err != nil {
+		return err
+	}
+	wave.StartedAt = &now
+	if err := s.campaignRepo.UpdateWave(ctx, wave); err != nil {
+		return err
+	}
+	s.l.Info("command campaign wave started",
+		zap.String("campaign_id", campaign.ID.String()),
+		zap.Int("wave", wave.Number),
+		zap.Int("devices", wave.Size),
+	)
+	return s.sendWave(ctx, campaign, wave)
+}
+
+// sendWave sends the command to the devices of the wave that didn't get it yet. The idempotency key of the campaign
+// keeps a device from getting it twice when sending is picked up again.
+func (s *CommandCampaignService) sendWave(ctx context.Context, campaign *model.CommandCampaign, wave *model.CommandCampaignWave) error {
+	targets, err := s.campaignRepo.ListUnsentTargets(ctx, campaign.ID, wave.Number)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("campaign:%s", campaign.ID)
+	for _, target := range targets {
+		command := &model.Command{
+			DeviceID:       target.DeviceID,
+			CommandCode:    campaign.CommandCode,
+			Payload:        campaign.Payload,
+			IssuedBy:       campaign.CreatedBy,
+			CampaignID:     &campaign.ID,
+			IdempotencyKey: &key,
+		}
+		sent, err := s.commandService.Send(ctx, command, time.Duration(campaign.TTLSeconds)*time.Second)
+		if err != nil {
+			target.Error = err.Error()
+		} else {
+			target.CommandID = &sent.ID
+		}
+		if err := s.campaignRepo.UpdateTarget(ctx, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectDevices returns the devices of the campaign, decommissioned devices are left out.
+func (s *CommandCampaignService) selectDevices(ctx context.Context, campaign *model.CommandCampaign) ([]*model.Device, error) {
+	if len(campaign.DeviceIDs) > 0 {
+		var devices []*model.Device
+		seen := make(map[uuid.UUID]bool, len(campaign.DeviceIDs))
+		for _, id := range campaign.DeviceIDs {
+			deviceID := uuid.MustParse(id)
+			if seen[deviceID] {
+				continue
+			}
+			seen[deviceID] = true
+			device, err := s.deviceRepo.GetByID(ctx, deviceID)
+			if err != nil {
+				if apperror.FromError(err).Code == apperror.ErrCodeNotFound {
+					return nil, apperror.ErrValidation.WithMessagef("device %s not found", id)
+				}
+				return nil, err
+			}
+			if device.Status != model.DeviceStatusDecommissioned {
+				devices = append(devices, device)
+			}
+		}
+		return devices, nil
+	}
+
+	// the most selective filter queries the devices, the rest is checked on each of them
+	var searches []func(p *pagination.Pagination) ([]*model.Device, int64, error)
+	switch {
+	case len(campaign.Tags) > 0:
+		searches = append(searches, func(p *pagination.Pagination) ([]*model.Device, int64, error) {
+			return s.deviceRepo.SearchByTags(ctx, campaign.Tags, p)
+		})
+	case len(campaign.Capabilities) > 0:
+		searches = append(searches, func(p *pagination.Pagination) ([]*model.Device, int64, error) {
+			return s.deviceRepo.SearchByCapabilities(ctx, campaign.Capabilities, p)
+		})
+	default:
+		for _, status := range campaign.Statuses {
+			searches = append(searches, func(p *pagination.Pagination) ([]*model.Device, int64, error) {
+				return s.deviceRepo.GetByStatus(ctx, model.DeviceStatus(status), p)
+			})
+		}
+	}
+
+	var devices []*model.Device
+	for _, search := range searches {
+		for page := 1; ; page++ {
+			found, total, err := search(&pagination.Pagination{Page: page, PageSize: campaignDevicePageSize, SortBy: "id"})
+			if err != nil {
+				return nil, err
+			}
+			for _, device := range found {
+				if device.Status != model.DeviceStatusDecommissioned && campaign.Selects(device) {
+					devices = append(devices, device)
+				}
+			}
+			if len(found) < campaignDevicePageSize || int64(page*campaignDevicePageSize) >= total {
+				break
+			}
+		}
+	}
+	return devices, nil
+}
+
+// currentWave returns the wave the campaign sent last, nil before the first one.
+func currentWave(campaign *model.CommandCampaign) *model.CommandCampaignWave {
+	if campaign.CurrentWave < 1 || campaign.CurrentWave > len(campaign.Waves) {
+		return nil
+	}
+	return campaign.Waves[campaign.CurrentWave-1]
+}
@@ -0,0 +1,221 @@
+package service_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/pagination"
+	"go.uber.org/zap"
+)
+
+type fakeFleetRepo struct {
+	repository.DeviceRepository
+	devices []*model.Device
+}
+
+func (r *fakeFleetRepo) GetByID(ctx context.Context, id uuid.UUID) (*model.Device, error) {
+	for _, device := range r.devices {
+		if device.ID == id {
+			return device, nil
+		}
+	}
+	return nil, apperror.ErrNotFound
+}
+
+func (r *fakeFleetRepo) SearchByTags(ctx context.Context, tags []string, p *pagination.Pagination) ([]*model.Device, int64, error) {
+	var found []*model.Device
+	for _, device := range r.devices {
+		if slices.Contains(device.Tags, tags[0]) {
+			found = append(found, device)
+		}
+	}
+	start := min(p.GetOffset(), len(found))
+	end := min(start+p.GetLimit(), len(found))
+	return found[start:end], int64(len(found)), nil
+}
+
+type fakeCommandCampaignRepo struct {
+	repository.CommandCampaignRepository
+	commandRepo *fakeCommandRepo
+	campaign    *model.CommandCampaign
+	targets     []*model.CommandCampaignTarget
+}
+
+func (r *fakeCommandCampaignRepo) Create(ctx context.Context, campaign *model.CommandCampaign, targets []*model.CommandCampaignTarget) error {
+	campaign.ID = uuid.New()
+	for _, target := range targets {
+		target.CampaignID = campaign.ID
+	}
+	r.campaign, r.targets = campaign, targets
+	return nil
+}
+
+func (r *fakeCommandCampaignRepo) GetByID(ctx context.Context, campaignID uuid.UUID) (*model.CommandCampaign, error) {
+	return r.campaign, nil
+}
+
+func (r *fakeCommandCampaignRepo) List(ctx context.Context, statuses []model.CampaignStatus) ([]*model.CommandCampaign, error) {
+	if slices.Contains(statuses, r.campaign.Status) {
+		return []*model.CommandCampaign{r.campaign}, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeCommandCampaignRepo) Transition(ctx context.Context, campaignID uuid.UUID, from []model.CampaignStatus, to model.CampaignStatus, reason string) (bool, error) {
+	if !slices.Contains(from, r.campaign.Status) {
+		return false, nil
+	}
+	r.campaign.Status, r.campaign.StatusReason = to, reason
+	return true, nil
+}
+
+func (r *fakeCommandCampaignRepo) SetCurrentWave(ctx context.Context, campaignID uuid.UUID, wave int) error {
+	r.campaign.CurrentWave = wave
+	return nil
+}
+
+func (r *fakeCommandCampaignRepo) UpdateWave(ctx context.Context, wave *model.CommandCampaignWave) error {
+	return nil
+}
+
+func (r *fakeCommandCampaignRepo) ListUnsentTargets(ctx context.Context, campaignID uuid.UUID, wave int) ([]*model.CommandCampaignTarget, error) {
+	var unsent []*model.CommandCampaignTarget
+	for _, target := range r.targets {
+		if target.Wave == wave && target.CommandID == nil && target.Error == "" {
+			unsent = append(unsent, target)
+		}
+	}
+	return unsent, nil
+}
+
+func (r *fakeCommandCampaignRepo) UpdateTarget(ctx context.Context, target *model.CommandCampaignTarget) error {
+	return nil
+}
+
+func (r *fakeCommandCampaignRepo) WaveProgress(ctx context.Context, campaignID uuid.UUID) ([]*model.CampaignWaveProgress, error) {
+	byWave := map[int]*model.CampaignWaveProgress{}
+	var progress []*model.CampaignWaveProgress
+	for _, target := range r.targets {
+		p, ok := byWave[target.Wave]
+		if !ok {
+			p = &model.CampaignWaveProgress{Wave: target.Wave}
+			byWave[target.Wave] = p
+			progress = append(progress, p)
+		}
+		p.Total++
+		switch {
+		case target.CommandID == nil && target.Error == "":
+			p.Unsent++
+		case target.CommandID == nil:
+			p.Failed++
+		case r.commandRepo.commands[*target.CommandID].Status == model.CommandStatusCompleted:
+			p.Completed++
+		case r.commandRepo.commands[*target.CommandID].Status == model.CommandStatusFailed:
+			p.Failed++
+		}
+	}
+	return progress, nil
+}
+
+// finishWave finishes the commands of the wave, the first failed of them fail.
+func (r *fakeCommandCampaignRepo) finishWave(wave int, failed int) {
+	for _, target := range r.targets {
+		if target.Wave != wave || target.CommandID == nil {
+			continue
+		}
+		command := r.commandRepo.commands[*target.CommandID]
+		if failed > 0 {
+			command.Status = model.CommandStatusFailed
+			failed--
+		} else {
+			command.Status = model.CommandStatusCompleted
+		}
+	}
+}
+
+func TestCommandCampaignRollsOutInWaves(t *testing.T) {
+	fleet := &fakeFleetRepo{}
+	for i := 0; i < 12; i++ {
+		device := &model.Device{ID: uuid.New(), Status: model.DeviceStatusOnline, Tags: []string{"gateway"}}
+		if i == 10 {
+			device.Status = model.DeviceStatusDecommissioned
+		}
+		if i == 11 {
+			device.Tags = []string{"sensor"}
+		}
+		fleet.devices = append(fleet.devices, device)
+	}
+	commandRepo := &fakeCommandRepo{commands: map[uuid.UUID]*model.Command{}}
+	campaignRepo := &fakeCommandCampaignRepo{commandRepo: commandRepo}
+	registry := loadCommandRegistry(t)
+	commandService := service.NewCommandService(commandRepo, fleet, registry, &fakePublisher{}, nil, zap.NewNop())
+	campaigns := service.NewCommandCampaignService(campaignRepo, fleet, commandService, registry, zap.NewNop())
+	ctx, now := context.Background(), time.Now()
+
+	campaign, err := campaigns.CreateCampaign(ctx, &model.CommandCampaign{
+		Name:              "rolling reboot",
+		CommandCode:       "device@reboot",
+		Payload:           []byte(`{"delay_seconds": 5, "reason": "update"}`),
+		Tags:              []string{"gateway"},
+		WavePercents:      []int64{10, 50, 100},
+		MaxFailurePercent: 25,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 10, campaign.TotalDevices, "decommissioned and untagged devices are left out")
+	require.Len(t, campaign.Waves, 3)
+	assert.Equal(t, []int{1, 4, 5}, []int{campaign.Waves[0].Size, campaign.Waves[1].Size, campaign.Waves[2].Size})
+
+	started, err := campaigns.Advance(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, started)
+	assert.Len(t, commandRepo.commands, 1)
+
+	// the next wave waits until the first one finished
+	started, err = campaigns.Advance(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, started)
+	campaignRepo.finishWave(1, 0)
+	started, err = campaigns.Advance(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, started)
+	assert.Len(t, commandRepo.commands, 5)
+
+	// 2 of 4 failing is over the 25% the campaign tolerates
+	campaignRepo.finishWave(2, 2)
+	_, err = campaigns.Advance(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignStatusPaused, campaign.Status)
+	assert.Contains(t, campaign.StatusReason, "wave 2")
+	assert.Len(t, commandRepo.commands, 5)
+
+	_, err = campaigns.PauseCampaign(ctx, campaign.ID, "")
+	assert.Equal(t, apperror.ErrCodeConflict, apperror.FromError(err).Code)
+
+	// resuming accepts the failures of the wave
+	_, err = campaigns.ResumeCampaign(ctx, campaign.ID)
+	require.NoError(t, err)
+	started, err = campaigns.Advance(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, started)
+	assert.Len(t, commandRepo.commands, 10)
+
+	campaignRepo.finishWave(3, 0)
+	_, err = campaigns.Advance(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignStatusCompleted, campaign.Status)
+	for _, command := range commandRepo.commands {
+		assert.Equal(t, campaign.ID, *command.CampaignID)
+	}
+
+	_, err = campaigns.AbortCampaign(ctx, campaign.ID, "")
+	assert.Equal(t, apperror.ErrCodeConflict, apperror.FromError(err).Code, "a completed campaign can't be aborted")
+}
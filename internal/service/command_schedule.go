@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/commands"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const commandScheduleBatchSize = 50
+
+// CommandScheduleService keeps the command schedules and fires the due ones through the CommandService. Only the
+// leading command publisher calls RunDue, claiming each run keeps an overlapping leader from firing it again.
+type CommandScheduleService struct {
+	scheduleRepo   repository.CommandScheduleRepository
+	commandRepo    repository.CommandRepository
+	commandService *CommandService
+	registry       *config.DeviceCommandSchemaRegistry
+	l              *zap.Logger
+}
+
+func NewCommandScheduleService(scheduleRepo repository.CommandScheduleRepository, commandRepo repository.CommandRepository, commandService *CommandService, registry *config.DeviceCommandSchemaRegistry, baseLogger *zap.Logger) *CommandScheduleService {
+	return &CommandScheduleService{
+		scheduleRepo:   scheduleRepo,
+		commandRepo:    commandRepo,
+		commandService: commandService,
+		registry:       registry,
+		l:              logger.Named(baseLogger, "CommandScheduleService"),
+	}
+}
+
+func (s *CommandScheduleService) CreateSchedule(ctx context.Context, schedule *model.CommandSchedule) (*model.CommandSchedule, error) {
+	if err := s.prepare(schedule, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := s.scheduleRepo.Create(ctx, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *CommandScheduleService) UpdateSchedule(ctx context.Context, schedule *model.CommandSchedule) (*model.CommandSchedule, error) {
+	if err := s.prepare(schedule, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := s.scheduleRepo.Update(ctx, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *CommandScheduleService) DeleteSchedule(ctx context.Context, scheduleID uuid.UUID) error {
+	return s.scheduleRepo.Delete(ctx, scheduleID)
+}
+
+func (s *CommandScheduleService) GetSchedule(ctx context.Context, scheduleID uuid.UUID) (*model.CommandSchedule, error) {
+	return s.scheduleRepo.GetByID(ctx, scheduleID)
+}
+
+func (s *CommandScheduleService) ListSchedules(ctx context.Context, deviceID *uuid.UUID) ([]*model.CommandSchedule, error) {
+	return s.scheduleRepo.List(ctx, deviceID)
+}
+
+// prepare validates the schedule and its command and sets its next run.
+func (s *CommandScheduleService) prepare(schedule *model.CommandSchedule, now time.Time) error {
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+	if err := schedule.Validate(); err != nil {
+		return apperror.ErrValidation.WithMessage(err.Error()).Wrap(err)
+	}
+	commandSchema, err := userCommandSchema(s.registry, schedule.CommandCode)
+	if err != nil {
+		return err
+	}
+	payload, err := commandPayload(&model.Command{Payload: schedule.Payload})
+	if err != nil {
+		return err
+	}
+	if violations := commands.ValidatePayload(&commandSchema.Payload, payload); len(violations) > 0 {
+		return apperror.ErrValidation.WithMessagef("payload of %s has %d violation(s)", schedule.CommandCode, len(violations)).WithDetails(map[string]interface{}{
+			"violations": violations,
+		})
+	}
+
+	schedule.NextRunAt = schedule.NextRunAfter(now)
+	if schedule.NextRunAt == nil && schedule.Enabled {
+		return apperror.ErrValidation.WithMessage("schedule has no run in the future")
+	}
+	return nil
+}
+
+// RunDue fires the schedules whose next run passed, a run missed while no scheduler was up fires once.
+func (s *CommandScheduleService) RunDue(ctx context.Context, now time.Time) (int, error) {
+	fired := 0
+	for {
+		schedules, err := s.scheduleRepo.ListDue(ctx, now, commandScheduleBatchSize)
+		if err != nil {
+			return fired, err
+		}
+		for _, schedule := range schedules {
+			runAt := *schedule.NextRunAt
+			nextRunAt := schedule.NextRunAfter(now)
+			claimed, err := s.scheduleRepo.ClaimRun(ctx, schedule.ID, runAt, nextRunAt, now)
+			if err != nil {
+				return fired, err
+			}
+			if !claimed {
+				continue
+			}
+			schedule.NextRunAt, schedule.LastRunAt = nextRunAt, &now
+
+			schedule.LastError = s.run(ctx, schedule, runAt)
+			if err := s.scheduleRepo.SetLastError(ctx, schedule.ID, schedule.LastError); err != nil {
+				return fired, err
+			}
+			fired++
+		}
+		if len(schedules) < commandScheduleBatchSize {
+			return fired, nil
+		}
+	}
+}
+
+// run sends the command of the schedule to every device of its target and returns the error to record, empty when
+// every device got it. The idempotency key of the run keeps a device from getting it twice.
+func (s *CommandScheduleService) run(ctx context.Context, schedule *model.CommandSchedule, runAt time.Time) string {
+	devices, err := s.commandRepo.TargetDevices(ctx, &schedule.Target)
+	if err != nil {
+		s.l.Error("failed to resolve schedule target", zap.String("schedule_id", schedule.ID.String()), zap.Error(err))
+		return fmt.Sprintf("failed to resolve target: %s", err)
+	}
+
+	var sent, failed int
+	var firstErr error
+	for _, device := range devices {
+		if device.Status == model.DeviceStatusDecommissioned {
+			continue
+		}
+		key := fmt.Sprintf("schedule:%s:%d", schedule.ID, runAt.Unix())
+		command := &model.Command{
+			DeviceID:       device.ID,
+			CommandCode:    schedule.CommandCode,
+			Payload:        schedule.Payload,
+			IssuedBy:       schedule.CreatedBy,
+			ScheduleID:     &schedule.ID,
+			IdempotencyKey: &key,
+		}
+		if _, err := s.commandService.Send(ctx, command, time.Duration(schedule.TTLSeconds)*time.Second); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		sent++
+	}
+
+	s.l.Info("command schedule fired",
+		zap.String("schedule_id", schedule.ID.String()),
+		zap.String("command", schedule.CommandCode),
+		zap.Int("sent", sent),
+		zap.Int("failed", failed),
+	)
+	if failed > 0 {
+		return fmt.Sprintf("%d of %d device(s) failed: %s", failed, sent+failed, firstErr)
+	}
+	if sent == 0 {
+		return "no device matched the target"
+	}
+	return ""
+}
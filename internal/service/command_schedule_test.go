@@ -0,0 +1,111 @@
+package service_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"go.uber.org/zap"
+)
+
+type fakeCommandScheduleRepo struct {
+	repository.CommandScheduleRepository
+	mu        sync.Mutex
+	schedules []*model.CommandSchedule
+}
+
+func (r *fakeCommandScheduleRepo) Create(ctx context.Context, schedule *model.CommandSchedule) error {
+	schedule.ID = uuid.New()
+	r.schedules = append(r.schedules, schedule)
+	return nil
+}
+
+func (r *fakeCommandScheduleRepo) ListDue(ctx context.Context, now time.Time, limit int) ([]*model.CommandSchedule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []*model.CommandSchedule
+	for _, schedule := range r.schedules {
+		if schedule.Enabled && schedule.NextRunAt != nil && !schedule.NextRunAt.After(now) {
+			copied := *schedule // every scheduler reads its own row
+			due = append(due, &copied)
+		}
+	}
+	return due, nil
+}
+
+func (r *fakeCommandScheduleRepo) ClaimRun(ctx context.Context, scheduleID uuid.UUID, runAt time.Time, nextRunAt *time.Time, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, schedule := range r.schedules {
+		if schedule.ID == scheduleID && schedule.NextRunAt != nil && schedule.NextRunAt.Equal(runAt) {
+			schedule.NextRunAt, schedule.LastRunAt = nextRunAt, &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeCommandScheduleRepo) SetLastError(ctx context.Context, scheduleID uuid.UUID, lastError string) error {
+	return nil
+}
+
+func TestCommandScheduleFiresEachRunOnce(t *testing.T) {
+	device := &model.Device{ID: uuid.New(), Status: model.DeviceStatusOnline, Tags: []string{"gateway"}}
+	commandRepo := &fakeCommandRepo{commands: map[uuid.UUID]*model.Command{}, devices: []*model.Device{device}}
+	scheduleRepo := &fakeCommandScheduleRepo{}
+	registry := loadCommandRegistry(t)
+	newScheduler := func() *service.CommandScheduleService {
+		commandService := service.NewCommandService(commandRepo, &fakeDeviceRepo{device: device}, registry, &fakePublisher{}, nil, zap.NewNop())
+		return service.NewCommandScheduleService(scheduleRepo, commandRepo, commandService, registry, zap.NewNop())
+	}
+	first, second := newScheduler(), newScheduler()
+
+	schedule, err := first.CreateSchedule(context.Background(), &model.CommandSchedule{
+		Name:        "weekly reboot",
+		Target:      model.CommandTarget{TargetType: model.CommandTargetTag, Tag: "gateway"},
+		CommandCode: "device@reboot",
+		Payload:     []byte(`{"delay_seconds": 5, "reason": "update"}`),
+		Cron:        "0 3 * * sun",
+		Timezone:    "Europe/Berlin",
+		Enabled:     true,
+	})
+	require.NoError(t, err)
+	runAt := *schedule.NextRunAt
+	assert.Equal(t, time.Sunday, runAt.Weekday())
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	assert.Equal(t, 3, runAt.In(berlin).Hour())
+
+	// a second scheduler overlapping the leader loses the claim of the run
+	fired, err := first.RunDue(context.Background(), runAt.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, fired)
+	fired, err = second.RunDue(context.Background(), runAt.Add(2*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 0, fired)
+
+	require.Len(t, commandRepo.commands, 1)
+	for _, command := range commandRepo.commands {
+		assert.Equal(t, schedule.ID, *command.ScheduleID)
+		assert.Equal(t, device.ID, command.DeviceID)
+	}
+	local := runAt.In(berlin)
+	assert.True(t, time.Date(local.Year(), local.Month(), local.Day()+7, 3, 0, 0, 0, berlin).Equal(*schedule.NextRunAt))
+
+	past := time.Now().Add(-time.Hour)
+	_, err = first.CreateSchedule(context.Background(), &model.CommandSchedule{
+		Name:        "too late",
+		Target:      model.CommandTarget{TargetType: model.CommandTargetTag, Tag: "gateway"},
+		CommandCode: "device@reboot",
+		Payload:     []byte(`{"delay_seconds": 5, "reason": "update"}`),
+		RunAt:       &past,
+		Enabled:     true,
+	})
+	assert.Error(t, err, "a one-off schedule in the past never runs")
+}
@@ -0,0 +1,440 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+type fakeCommandRepo struct {
+	repository.CommandRepository
+	commands map[uuid.UUID]*model.Command
+	devices  []*model.Device // every target selects them
+}
+
+func (r *fakeCommandRepo) TargetDevices(ctx context.Context, target *model.CommandTarget) ([]*model.Device, error) {
+	return r.devices, nil
+}
+
+func (r *fakeCommandRepo) Create(ctx context.Context, command *model.Command) error {
+	if command.ID == uuid.Nil {
+		command.ID = uuid.New()
+	}
+	command.CreatedAt = time.Now()
+	r.commands[command.ID] = command
+	return nil
+}
+
+func (r *fakeCommandRepo) Update(ctx context.Context, command *model.Command) error { return nil }
+
+func (r *fakeCommandRepo) GetByID(ctx context.Context, commandID uuid.UUID) (*model.Command, error) {
+	if command, ok := r.commands[commandID]; ok {
+		return command, nil
+	}
+	return nil, apperror.ErrNotFound
+}
+
+func (r *fakeCommandRepo) GetByIdempotencyKey(ctx context.Context, deviceID uuid.UUID, key string) (*model.Command, error) {
+	for _, command := range r.commands {
+		if command.DeviceID == deviceID && command.IdempotencyKey != nil && *command.IdempotencyKey == key {
+			return command, nil
+		}
+	}
+	return nil, apperror.ErrNotFound
+}
+
+func (r *fakeCommandRepo) ListAckOverdue(ctx context.Context, now time.Time, limit int) ([]*model.Command, error) {
+	var overdue []*model.Command
+	for _, command := range r.commands {
+		if command.Status == model.CommandStatusDelivered && command.AckDeadline != nil && !command.AckDeadline.After(now) {
+			overdue = append(overdue, command)
+		}
+	}
+	return overdue, nil
+}
+
+func (r *fakeCommandRepo) ListUndelivered(ctx context.Context, deviceID uuid.UUID, now time.Time, limit int) ([]*model.Command, error) {
+	var undelivered []*model.Command
+	for _, command := range r.commands {
+		if command.DeviceID == deviceID && command.ExpiresAt.After(now) &&
+			(command.Status == model.CommandStatusQueued || command.Status == model.CommandStatusSent) {
+			undelivered = append(undelivered, command)
+		}
+	}
+	sort.Slice(undelivered, func(i, j int) bool { return undelivered[i].CreatedAt.Before(undelivered[j].CreatedAt) })
+	return undelivered, nil
+}
+
+func loadCommandRegistry(t *testing.T) *config.DeviceCommandSchemaRegistry {
+	registry := config.NewDeviceCommandSchemaRegistry()
+	require.NoError(t, registry.LoadDeviceCommandSchemaRegistry("device.command.schema", "yaml", "../../configs", zap.NewNop()))
+	return registry
+}
+
+// rpcPublisher answers the commands published to a device on their reply subject, like the command processor does
+// for the reply of the device.
+type rpcPublisher struct {
+	fakePublisher
+	answer map[string]interface{} // nil leaves the device silent
+	subs   map[string]chan []byte
+}
+
+func (p *rpcPublisher) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	p.subs[topic] = make(chan []byte, 1)
+	return p.subs[topic], nil
+}
+
+func (p *rpcPublisher) Unsubscribe(ctx context.Context, topic string) error {
+	delete(p.subs, topic)
+	return nil
+}
+
+func (p *rpcPublisher) Publish(ctx context.Context, topic string, message interface{}) error {
+	p.topics = append(p.topics, topic)
+	cmd, ok := message.(model.DeviceCommand)
+	if !ok || p.answer == nil {
+		return nil
+	}
+	reply, _ := json.Marshal(model.DeviceEvent{Type: model.EventTypeCommandCompleted, DeviceID: cmd.DeviceID, CorrelationID: cmd.ID, Timestamp: time.Now(), Payload: p.answer})
+	if sub, ok := p.subs[pubsub.NatsTopicCommandRepliesPrefixf(cmd.ID)]; ok {
+		sub <- reply
+	}
+	return nil
+}
+
+func TestCommandCallWaitsForDeviceAnswer(t *testing.T) {
+	deviceID := uuid.New()
+	commandRepo := &fakeCommandRepo{commands: map[uuid.UUID]*model.Command{}}
+	publisher := &rpcPublisher{answer: map[string]interface{}{"uptime": 42}, subs: map[string]chan []byte{}}
+	cfg := &config.CommandConfig{RPCTimeout: 50 * time.Millisecond, MaxRPCTimeout: time.Second}
+	commandService := service.NewCommandService(commandRepo, &fakeDeviceRepo{device: &model.Device{ID: deviceID, Status: model.DeviceStatusOnline}}, loadCommandRegistry(t), publisher, cfg, zap.NewNop())
+	call := func() (*model.Command, *model.DeviceEvent, error) {
+		return commandService.Call(context.Background(), &model.Command{DeviceID: deviceID, CommandCode: "device@reboot", Payload: []byte(`{"delay_seconds": 5}`)}, time.Minute, 0)
+	}
+
+	command, answer, err := call()
+	require.NoError(t, err)
+	require.NotNil(t, answer)
+	assert.Equal(t, 42.0, answer.Payload["uptime"])
+	assert.Equal(t, model.CommandStatusCompleted, command.Status)
+	assert.Empty(t, publisher.subs, "the reply subject is left once answered")
+
+	// a silent device times out the call, the command stays pending
+	publisher.answer = nil
+	command, answer, err = call()
+	assert.Equal(t, apperror.ErrCodeGatewayTimeout, apperror.FromError(err).Code)
+	assert.Nil(t, answer)
+	assert.Equal(t, model.CommandStatusSent, command.Status)
+
+	_, _, err = commandService.Call(context.Background(), &model.Command{DeviceID: deviceID, CommandCode: "device@reboot"}, time.Minute, time.Hour)
+	assert.Equal(t, apperror.ErrCodeValidation, apperror.FromError(err).Code)
+}
+
+// sharedReplyPublisher holds a single subscription per subject like NatsPubSub does, the device answers the
+// commands published to it after a delay.
+type sharedReplyPublisher struct {
+	pubsub.PubSubPublisher
+	delay     time.Duration
+	published chan model.DeviceCommand
+	mu        sync.Mutex
+	subs      map[string]*replySubscription
+}
+
+type replySubscription struct {
+	ctx context.Context
+	ch  chan []byte
+}
+
+func (p *sharedReplyPublisher) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.subs[topic]; ok {
+		return nil, errors.New("already subscribed to topic: " + topic)
+	}
+	p.subs[topic] = &replySubscription{ctx: ctx, ch: make(chan []byte)}
+	return p.subs[topic].ch, nil
+}
+
+func (p *sharedReplyPublisher) Unsubscribe(ctx context.Context, topic string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.subs, topic)
+	return nil
+}
+
+func (p *sharedReplyPublisher) Publish(ctx context.Context, topic string, message interface{}) error {
+	cmd, ok := message.(model.DeviceCommand)
+	if !ok {
+		return nil
+	}
+	p.published <- cmd
+	time.AfterFunc(p.delay, func() {
+		p.mu.Lock()
+		sub, ok := p.subs[pubsub.NatsTopicCommandRepliesPrefixf(cmd.ID)]
+		p.mu.Unlock()
+		if !ok {
+			return
+		}
+		reply, _ := json.Marshal(model.DeviceEvent{Type: model.EventTypeCommandCompleted, DeviceID: cmd.DeviceID, CorrelationID: cmd.ID, Timestamp: time.Now()})
+		select {
+		case sub.ch <- reply:
+		case <-sub.ctx.Done():
+		}
+	})
+	return nil
+}
+
+// copyingCommandRepo stores copies of the commands like the database does, concurrent calls don't share them.
+type copyingCommandRepo struct {
+	repository.CommandRepository
+	mu       sync.Mutex
+	commands map[uuid.UUID]model.Command
+}
+
+func (r *copyingCommandRepo) Create(ctx context.Context, command *model.Command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if command.ID == uuid.Nil {
+		command.ID = uuid.New()
+	}
+	r.commands[command.ID] = *command
+	return nil
+}
+
+func (r *copyingCommandRepo) Update(ctx context.Context, command *model.Command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands[command.ID] = *command
+	return nil
+}
+
+func (r *copyingCommandRepo) GetByID(ctx context.Context, commandID uuid.UUID) (*model.Command, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if command, ok := r.commands[commandID]; ok {
+		return &command, nil
+	}
+	return nil, apperror.ErrNotFound
+}
+
+func (r *copyingCommandRepo) GetByIdempotencyKey(ctx context.Context, deviceID uuid.UUID, key string) (*model.Command, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, command := range r.commands {
+		if command.DeviceID == deviceID && command.IdempotencyKey != nil && *command.IdempotencyKey == key {
+			return &command, nil
+		}
+	}
+	return nil, apperror.ErrNotFound
+}
+
+func TestCommandCallsShareTheReplySubject(t *testing.T) {
+	deviceID := uuid.New()
+	key := "reboot-after-update"
+	commandRepo := &copyingCommandRepo{commands: map[uuid.UUID]model.Command{}}
+	publisher := &sharedReplyPublisher{delay: 300 * time.Millisecond, published: make(chan model.DeviceCommand, 1), subs: map[string]*replySubscription{}}
+	cfg := &config.CommandConfig{MaxRPCTimeout: time.Second}
+	commandService := service.NewCommandService(commandRepo, &fakeDeviceRepo{device: &model.Device{ID: deviceID, Status: model.DeviceStatusOnline}}, loadCommandRegistry(t), publisher, cfg, zap.NewNop())
+	call := func(timeout time.Duration) (*model.Command, *model.DeviceEvent, error) {
+		return commandService.Call(context.Background(), &model.Command{DeviceID: deviceID, CommandCode: "device@reboot", Payload: []byte(`{"delay_seconds": 5}`), IdempotencyKey: &key}, time.Minute, timeout)
+	}
+
+	type result struct {
+		command *model.Command
+		err     error
+	}
+	first := make(chan result, 1)
+	go func() {
+		command, _, err := call(100 * time.Millisecond)
+		first <- result{command, err}
+	}()
+	<-publisher.published
+
+	// the retry waits on the command of the first call and gets the answer after the first call gave up
+	command, answer, err := call(time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, answer)
+	assert.Equal(t, model.CommandStatusCompleted, command.Status)
+
+	firstResult := <-first
+	assert.Equal(t, apperror.ErrCodeGatewayTimeout, apperror.FromError(firstResult.err).Code)
+	assert.Equal(t, command.ID, firstResult.command.ID)
+	commandRepo.mu.Lock()
+	assert.Len(t, commandRepo.commands, 1)
+	commandRepo.mu.Unlock()
+
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	assert.Empty(t, publisher.subs, "the reply subject is left by the last call")
+}
+
+func TestCommandLifecycleFromStatusEvents(t *testing.T) {
+	deviceID := uuid.New()
+	commandRepo := &fakeCommandRepo{commands: map[uuid.UUID]*model.Command{}}
+	publisher := &fakePublisher{}
+	commandService := service.NewCommandService(commandRepo, &fakeDeviceRepo{device: &model.Device{ID: deviceID, Status: model.DeviceStatusOnline}}, loadCommandRegistry(t), publisher, nil, zap.NewNop())
+
+	_, err := commandService.Send(context.Background(), &model.Command{DeviceID: deviceID, CommandCode: "device@unknown"}, 0)
+	assert.Equal(t, apperror.ErrCodeValidation, apperror.FromError(err).Code)
+
+	// only u>d commands can be sent by users
+	_, err = commandService.Send(context.Background(), &model.Command{DeviceID: deviceID, CommandCode: "device@init", Payload: []byte(`{"firmware_version": "1.2.0", "sensors": ["temp"]}`)}, time.Minute)
+	assert.Equal(t, apperror.ErrCodeValidation, apperror.FromError(err).Code)
+
+	// payloads are checked against the schema
+	_, err = commandService.Send(context.Background(), &model.Command{DeviceID: deviceID, CommandCode: "device@reboot", Payload: []byte(`{"delay_seconds": "soon"}`)}, time.Minute)
+	assert.Equal(t, apperror.ErrCodeValidation, apperror.FromError(err).Code)
+	assert.Empty(t, publisher.topics)
+
+	command, err := commandService.Send(context.Background(), &model.Command{DeviceID: deviceID, CommandCode: "device@reboot", Payload: []byte(`{"delay_seconds": 5, "reason": "update"}`)}, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, model.CommandStatusSent, command.Status)
+	assert.Equal(t, []string{pubsub.NatsTopicCommandsOutboundPrefixf(deviceID)}, publisher.topics)
+
+	event := func(eventType string, payload map[string]interface{}) *model.DeviceEvent {
+		return &model.DeviceEvent{Type: eventType, DeviceID: deviceID, CorrelationID: command.ID, Timestamp: time.Now(), Payload: payload}
+	}
+
+	// the ack overtaking the delivery report leaves the command acknowledged
+	require.NoError(t, commandService.HandleStatusEvent(context.Background(), event(model.EventTypeCommandAcknowledged, nil)))
+	require.NoError(t, commandService.HandleStatusEvent(context.Background(), event(model.EventTypeCommandDelivered, nil)))
+	assert.Equal(t, model.CommandStatusAcknowledged, command.Status)
+	assert.Nil(t, command.DeliveredAt)
+
+	require.NoError(t, commandService.HandleStatusEvent(context.Background(), event(model.EventTypeCommandCompleted, map[string]interface{}{"uptime": 42})))
+	assert.Equal(t, model.CommandStatusCompleted, command.Status)
+	assert.JSONEq(t, `{"uptime": 42}`, string(command.Result))
+
+	// finished commands don't move anymore
+	require.NoError(t, commandService.HandleStatusEvent(context.Background(), event(model.EventTypeCommandFailed, map[string]interface{}{"error": "late"})))
+	assert.Equal(t, model.CommandStatusCompleted, command.Status)
+	assert.Empty(t, command.Error)
+}
+
+func TestCommandRetriesUntilAttemptsRunOut(t *testing.T) {
+	deviceID := uuid.New()
+	commandRepo := &fakeCommandRepo{commands: map[uuid.UUID]*model.Command{}}
+	publisher := &fakePublisher{}
+	cfg := &config.CommandConfig{AckTimeout: 10 * time.Second, MaxAckWait: 15 * time.Second, MaxAttempts: 3}
+	commandService := service.NewCommandService(commandRepo, &fakeDeviceRepo{device: &model.Device{ID: deviceID, Status: model.DeviceStatusOnline}}, loadCommandRegistry(t), publisher, cfg, zap.NewNop())
+
+	key := "reboot-2024-05-01"
+	send := func(payload string) (*model.Command, error) {
+		return commandService.Send(context.Background(), &model.Command{DeviceID: deviceID, CommandCode: "device@reboot", Payload: []byte(payload), IdempotencyKey: &key}, time.Hour)
+	}
+	command, err := send(`{"delay_seconds": 5, "reason": "update"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 1, command.Attempts)
+
+	// a retried request returns the first command, the key can't be reused for another payload
+	again, err := send(`{"reason": "update", "delay_seconds": 5}`)
+	require.NoError(t, err)
+	assert.Equal(t, command.ID, again.ID)
+	_, err = send(`{"delay_seconds": 30, "reason": "update"}`)
+	assert.Equal(t, apperror.ErrCodeConflict, apperror.FromError(err).Code)
+	assert.Len(t, commandRepo.commands, 1)
+	assert.Len(t, publisher.topics, 1)
+
+	// the ack timeout doubles with every attempt up to the max wait, the attempts count once the device has the command
+	require.NoError(t, commandService.HandleStatusEvent(context.Background(), &model.DeviceEvent{Type: model.EventTypeCommandDelivered, DeviceID: deviceID, CorrelationID: command.ID, Timestamp: time.Now()}))
+	start := *command.AckDeadline
+	retried, failed, err := commandService.RetryUnacknowledged(context.Background(), start)
+	require.NoError(t, err)
+	assert.Equal(t, 1, retried)
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, 2, command.Attempts)
+	assert.Equal(t, start.Add(15*time.Second), *command.AckDeadline)
+
+	_, _, err = commandService.RetryUnacknowledged(context.Background(), *command.AckDeadline)
+	require.NoError(t, err)
+	assert.Equal(t, 3, command.Attempts)
+
+	retried, failed, err = commandService.RetryUnacknowledged(context.Background(), *command.AckDeadline)
+	require.NoError(t, err)
+	assert.Equal(t, 0, retried)
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, model.CommandStatusFailed, command.Status)
+	assert.Nil(t, command.AckDeadline)
+	assert.Equal(t, pubsub.NatsTopicCommandStatus, publisher.topics[len(publisher.topics)-1], "timeout is reported as command_failed")
+}
+
+func TestOfflineDeviceKeepsItsCommandsPastTheAckDeadline(t *testing.T) {
+	deviceID := uuid.New()
+	commandRepo := &fakeCommandRepo{commands: map[uuid.UUID]*model.Command{}}
+	cfg := &config.CommandConfig{AckTimeout: 10 * time.Second, MaxAckWait: 15 * time.Second, MaxAttempts: 2}
+	commandService := service.NewCommandService(commandRepo, &fakeDeviceRepo{device: &model.Device{ID: deviceID, Status: model.DeviceStatusOffline}}, loadCommandRegistry(t), &fakePublisher{}, cfg, zap.NewNop())
+
+	command, err := commandService.Send(context.Background(), &model.Command{DeviceID: deviceID, CommandCode: "device@reboot", Payload: []byte(`{"delay_seconds": 5, "reason": "update"}`)}, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, model.CommandStatusSent, command.Status)
+
+	// nobody got the command, passing the ack deadline again and again doesn't use up its attempts
+	now := *command.AckDeadline
+	for i := 0; i < 5; i++ {
+		retried, failed, err := commandService.RetryUnacknowledged(context.Background(), now)
+		require.NoError(t, err)
+		assert.Zero(t, retried)
+		assert.Zero(t, failed)
+		now = now.Add(time.Minute)
+	}
+	assert.Equal(t, model.CommandStatusSent, command.Status)
+	assert.Equal(t, 1, command.Attempts)
+
+	// the device reconnects and gets it, from then on an ack is expected
+	queued, err := commandService.QueuedCommands(context.Background(), deviceID, now)
+	require.NoError(t, err)
+	require.Len(t, queued, 1)
+	assert.Equal(t, command.ID, queued[0].ID)
+	require.NoError(t, commandService.HandleStatusEvent(context.Background(), &model.DeviceEvent{Type: model.EventTypeCommandDelivered, DeviceID: deviceID, CorrelationID: command.ID, Timestamp: now}))
+
+	retried, _, err := commandService.RetryUnacknowledged(context.Background(), *command.AckDeadline)
+	require.NoError(t, err)
+	assert.Equal(t, 1, retried)
+	assert.Equal(t, 2, command.Attempts)
+}
+
+func TestQueuedCommandsSkipProcessedAndExpired(t *testing.T) {
+	deviceID := uuid.New()
+	commandRepo := &fakeCommandRepo{commands: map[uuid.UUID]*model.Command{}}
+	commandService := service.NewCommandService(commandRepo, &fakeDeviceRepo{device: &model.Device{ID: deviceID, Status: model.DeviceStatusOffline}}, loadCommandRegistry(t), &fakePublisher{}, nil, zap.NewNop())
+
+	var sent []*model.Command
+	for _, ttl := range []time.Duration{time.Hour, time.Minute, time.Hour, time.Hour} {
+		command, err := commandService.Send(context.Background(), &model.Command{DeviceID: deviceID, CommandCode: "device@reboot", Payload: []byte(`{"delay_seconds": 5, "reason": "update"}`)}, ttl)
+		require.NoError(t, err)
+		sent = append(sent, command)
+		time.Sleep(time.Millisecond)
+	}
+
+	// the device ran the third one before it lost the connection
+	completed, err := commandService.MarkProcessed(context.Background(), deviceID, []uuid.UUID{sent[2].ID, uuid.New()}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, completed)
+	completed, err = commandService.MarkProcessed(context.Background(), uuid.New(), []uuid.UUID{sent[3].ID}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, completed, "another device can't complete the command")
+
+	// reconnecting after the second one expired
+	queued, err := commandService.QueuedCommands(context.Background(), deviceID, time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, queued, 2)
+	assert.Equal(t, sent[0].ID, queued[0].ID)
+	assert.Equal(t, sent[3].ID, queued[1].ID)
+	assert.Equal(t, model.CommandTypeCommand, queued[0].Type)
+	assert.Equal(t, "update", queued[0].Payload["reason"])
+}
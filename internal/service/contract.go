@@ -6,9 +6,12 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/devicemanifest"
+	"github.com/vars7899/iots/internal/domain"
 	"github.com/vars7899/iots/internal/domain/model"
 	"github.com/vars7899/iots/pkg/auth/deviceauth"
 	"github.com/vars7899/iots/pkg/auth/token"
+	"github.com/vars7899/iots/pkg/pagination"
 )
 
 type RoleService interface {
@@ -43,12 +46,32 @@ type AuthService interface {
 	LoginUser(ctx context.Context, identifiers *dto.LoginCredentials) (*model.User, *token.AuthTokenSet, error)
 	RefreshAuthTokens(ctx context.Context, refreshTokenStr string) (*model.User, *token.AuthTokenSet, error)
 	LogoutUser(ctx context.Context, userID *uuid.UUID, claims *token.AccessTokenClaims, refreshTokenStr string) error
-	RequestPasswordReset(ctx context.Context, email string) (*model.ResetPasswordToken, *string, error)
+	RequestPasswordReset(ctx context.Context, email string) error
 	ResetPassword(ctx context.Context, resetToken, newRawPassword string) error
 }
 
+// DeviceListener is told about devices whose configuration changed or that were deleted, e.g. to drop what it
+// cached of them.
+type DeviceListener interface {
+	DeviceChanged(deviceID uuid.UUID)
+}
+
 type DeviceService interface {
-	CreateDevice(ctx context.Context, device *model.Device) (*model.Device, error)
+	AddListener(listener DeviceListener)
+	CreateDevice(ctx context.Context, device *model.Device) (*model.Device, string, error)
 	ProvisionDevice(ctx context.Context, idStr string, provisionCode string) (*deviceauth.DeviceConnectionTokens, error)
 	RefreshDeviceTokens(ctx context.Context, connectionTokenStr string, refreshTokenStr string) (*deviceauth.DeviceConnectionTokens, error)
+	GetDeviceByID(ctx context.Context, deviceID uuid.UUID) (*model.Device, error)
+	GetDeviceWithSensors(ctx context.Context, deviceID uuid.UUID) (*model.Device, error)
+	ListDevices(ctx context.Context, filter *domain.DeviceFilter, paginationOpt *pagination.Pagination) ([]*model.Device, int64, error)
+	UpdateDevice(ctx context.Context, deviceUpdates *model.Device) (*model.Device, error)
+	DeleteDevice(ctx context.Context, deviceID uuid.UUID) error
+	RecoverDevice(ctx context.Context, deviceID uuid.UUID) (*model.Device, error)
+	UpdateDeviceStatus(ctx context.Context, change *model.DeviceStatusChange) (*model.Device, error)
+	ListDeviceStatusChanges(ctx context.Context, deviceID uuid.UUID, paginationOpt *pagination.Pagination) ([]*model.DeviceStatusChange, int64, error)
+	CountDevicesByStatus(ctx context.Context) (map[model.DeviceStatus]int64, error)
+	BulkCreateDevices(ctx context.Context, devices []*model.Device) ([]*model.Device, []string, error)
+	BulkUpdateDevices(ctx context.Context, devicesUpdates []*model.Device) ([]*model.Device, error)
+	BulkDeleteDevices(ctx context.Context, deviceIDs []uuid.UUID) error
+	ImportDevices(ctx context.Context, rows []*devicemanifest.Row, dryRun bool) (*dto.DeviceImportReport, error)
 }
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pagination"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDeadLetterRetention         = 30 * 24 * time.Hour
+	defaultResolvedDeadLetterRetention = 7 * 24 * time.Hour
+)
+
+// redrivableSubjects are the subjects whose consumers record dead letters, a dead letter goes back to its subject.
+var redrivableSubjects = map[string]bool{
+	pubsub.NatsTopicCommandsInbound: true,
+	pubsub.NatsTopicCommandStatus:   true,
+}
+
+// DeadLetterService keeps the messages the command processor and the command status worker couldn't handle. An
+// operator fixes a dead letter and re-drives it to the subject it came from, a re-driven message failing again is
+// the same dead letter with one more attempt.
+type DeadLetterService struct {
+	repo              repository.DeadLetterRepository
+	publisher         pubsub.PubSubPublisher
+	retention         time.Duration
+	resolvedRetention time.Duration
+	l                 *zap.Logger
+}
+
+func NewDeadLetterService(repo repository.DeadLetterRepository, publisher pubsub.PubSubPublisher, cfg *config.CommandConfig, baseLogger *zap.Logger) *DeadLetterService {
+	retention, resolvedRetention := defaultDeadLetterRetention, defaultResolvedDeadLetterRetention
+	if cfg != nil {
+		if cfg.DeadLetterRetention > 0 {
+			retention = cfg.DeadLetterRetention
+		}
+		if cfg.ResolvedDeadLetterRetention > 0 {
+			resolvedRetention = cfg.ResolvedDeadLetterRetention
+		}
+	}
+	return &DeadLetterService{
+		repo:              repo,
+		publisher:         publisher,
+		retention:         retention,
+		resolvedRetention: resolvedRetention,
+		l:                 logger.Named(baseLogger, "DeadLetterService"),
+	}
+}
+
+// Record keeps a message that failed, it's best effort so a database outage never blocks the consumer.
+func (s *DeadLetterService) Record(ctx context.Context, letter *model.DeadLetter) {
+	now := time.Now()
+	letter.Fingerprint = deadLetterFingerprint(letter.Subject, letter.Message)
+	letter.Status = model.DeadLetterStatusPending
+	letter.Attempts = 1
+	letter.FirstFailedAt = now
+	letter.LastFailedAt = now
+	if letter.CommandCode == "" {
+		letter.CommandCode = model.DeadLetterCommandCodeUnknown
+	}
+
+	if err := s.repo.Record(ctx, letter); err != nil {
+		s.l.Error("failed to record dead letter, the message is lost",
+			zap.String("subject", letter.Subject),
+			zap.String("command", letter.CommandCode),
+			zap.String("error_code", letter.ErrorCode),
+			zap.String("raw", letter.Message),
+			zap.Error(err),
+		)
+		return
+	}
+	s.l.Warn("message dead lettered",
+		zap.String("dead_letter_id", letter.ID.String()),
+		zap.String("subject", letter.Subject),
+		zap.String("command", letter.CommandCode),
+		zap.String("error_code", letter.ErrorCode),
+	)
+}
+
+func (s *DeadLetterService) GetDeadLetter(ctx context.Context, letterID uuid.UUID) (*model.DeadLetter, error) {
+	return s.repo.GetByID(ctx, letterID)
+}
+
+func (s *DeadLetterService) ListDeadLetters(ctx context.Context, filter *dto.DeadLetterFilter, paginationOpt *pagination.Pagination) ([]*model.DeadLetter, int64, error) {
+	return s.repo.List(ctx, filter, paginationOpt)
+}
+
+// Edit replaces the message of a pending dead letter, e.g. to fix a payload before re-driving it.
+func (s *DeadLetterService) Edit(ctx context.Context, letterID uuid.UUID, message string) (*model.DeadLetter, error) {
+	letter, err := s.pendingDeadLetter(ctx, letterID)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid([]byte(message)) {
+		return nil, apperror.ErrValidation.WithMessage("message is not valid json")
+	}
+
+	// only the message is written, a consumer counting another failure meanwhile keeps its attempt
+	return s.repo.UpdateWhereStatus(ctx, letter.ID, model.DeadLetterStatusPending, map[string]interface{}{
+		"message":     message,
+		"fingerprint": deadLetterFingerprint(letter.Subject, message),
+		"edited_at":   time.Now(),
+	})
+}
+
+// Redrive publishes the message of a pending dead letter to the subject it came from. The dead letter is re-driven
+// before it is published, a consumer failing on it again moves it back to pending. Of concurrent re-drives only the
+// one moving it out of pending publishes.
+func (s *DeadLetterService) Redrive(ctx context.Context, letterID uuid.UUID) (*model.DeadLetter, error) {
+	letter, err := s.pendingDeadLetter(ctx, letterID)
+	if err != nil {
+		return nil, err
+	}
+	if !redrivableSubjects[letter.Subject] {
+		return nil, apperror.ErrValidation.WithMessagef("messages of %s can't be re-driven", letter.Subject)
+	}
+	if !json.Valid([]byte(letter.Message)) {
+		return nil, apperror.ErrValidation.WithMessage("message is not valid json, edit it before re-driving it")
+	}
+
+	now := time.Now()
+	letter, err = s.repo.UpdateWhereStatus(ctx, letter.ID, model.DeadLetterStatusPending, map[string]interface{}{
+		"status":      model.DeadLetterStatusRedriven,
+		"redriven_at": now,
+		"resolved_at": now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.publisher.Publish(ctx, letter.Subject, json.RawMessage(letter.Message)); err != nil {
+		_, updateErr := s.repo.UpdateWhereStatus(ctx, letter.ID, model.DeadLetterStatusRedriven, map[string]interface{}{
+			"status":      model.DeadLetterStatusPending,
+			"resolved_at": nil,
+		})
+		if updateErr != nil {
+			s.l.Error("failed to move dead letter back to pending", zap.String("dead_letter_id", letter.ID.String()), zap.Error(updateErr))
+		}
+		return nil, apperror.ErrInternal.WithMessagef("failed to publish to %s", letter.Subject).Wrap(err)
+	}
+
+	s.l.Info("dead letter re-driven",
+		zap.String("dead_letter_id", letter.ID.String()),
+		zap.String("subject", letter.Subject),
+		zap.String("command", letter.CommandCode),
+		zap.Int("attempts", letter.Attempts),
+	)
+	return letter, nil
+}
+
+// Discard gives up on a pending dead letter, it's deleted with the resolved ones.
+func (s *DeadLetterService) Discard(ctx context.Context, letterID uuid.UUID) (*model.DeadLetter, error) {
+	letter, err := s.pendingDeadLetter(ctx, letterID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.UpdateWhereStatus(ctx, letter.ID, model.DeadLetterStatusPending, map[string]interface{}{
+		"status":      model.DeadLetterStatusDiscarded,
+		"resolved_at": time.Now(),
+	})
+}
+
+// Volume counts the dead letters that failed since the time per command code, subject and status.
+func (s *DeadLetterService) Volume(ctx context.Context, since time.Time) ([]*model.DeadLetterVolume, error) {
+	volume, err := s.repo.Volume(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+	if volume == nil {
+		volume = []*model.DeadLetterVolume{}
+	}
+	return volume, nil
+}
+
+// Prune deletes the pending dead letters past the retention and the resolved ones past the resolved retention, it
+// returns the number deleted.
+func (s *DeadLetterService) Prune(ctx context.Context, now time.Time) (int64, error) {
+	pending, err := s.repo.DeletePendingBefore(ctx, now.Add(-s.retention))
+	if err != nil {
+		return 0, err
+	}
+	resolved, err := s.repo.DeleteResolvedBefore(ctx, now.Add(-s.resolvedRetention))
+	if err != nil {
+		return pending, err
+	}
+	return pending + resolved, nil
+}
+
+func (s *DeadLetterService) pendingDeadLetter(ctx context.Context, letterID uuid.UUID) (*model.DeadLetter, error) {
+	letter, err := s.repo.GetByID(ctx, letterID)
+	if err != nil {
+		return nil, err
+	}
+	if letter.Status != model.DeadLetterStatusPending {
+		return nil, apperror.ErrConflict.WithMessagef("dead letter is %s", letter.Status)
+	}
+	return letter, nil
+}
+
+// deadLetterFingerprint identifies a message on a subject. A re-driven message is published the way json.Marshal
+// writes it, compacted and html escaped, so valid json is normalized the same way first.
+func deadLetterFingerprint(subject, message string) string {
+	var compact, escaped bytes.Buffer
+	if err := json.Compact(&compact, []byte(message)); err == nil {
+		json.HTMLEscape(&escaped, compact.Bytes())
+		message = escaped.String()
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s", subject, message)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,217 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+type fakeDeadLetterRepo struct {
+	repository.DeadLetterRepository
+	letters      []*model.DeadLetter
+	beforeUpdate func() // runs once right before the next update, like a concurrent request getting in first
+}
+
+func (r *fakeDeadLetterRepo) Record(ctx context.Context, letter *model.DeadLetter) error {
+	for _, existing := range r.letters {
+		if existing.Fingerprint == letter.Fingerprint {
+			existing.Attempts++
+			existing.ErrorCode, existing.Error = letter.ErrorCode, letter.Error
+			existing.Status, existing.ResolvedAt = model.DeadLetterStatusPending, nil
+			existing.LastFailedAt = letter.LastFailedAt
+			letter.ID = existing.ID
+			return nil
+		}
+	}
+	letter.ID = uuid.New()
+	copied := *letter
+	r.letters = append(r.letters, &copied)
+	return nil
+}
+
+func (r *fakeDeadLetterRepo) GetByID(ctx context.Context, letterID uuid.UUID) (*model.DeadLetter, error) {
+	for _, letter := range r.letters {
+		if letter.ID == letterID {
+			copied := *letter
+			return &copied, nil
+		}
+	}
+	return nil, apperror.ErrNotFound
+}
+
+func (r *fakeDeadLetterRepo) UpdateWhereStatus(ctx context.Context, letterID uuid.UUID, status model.DeadLetterStatus, changes map[string]interface{}) (*model.DeadLetter, error) {
+	if before := r.beforeUpdate; before != nil {
+		r.beforeUpdate = nil
+		before()
+	}
+	timeOrNil := func(value interface{}) *time.Time {
+		if value == nil {
+			return nil
+		}
+		at := value.(time.Time)
+		return &at
+	}
+	for _, letter := range r.letters {
+		if letter.ID != letterID {
+			continue
+		}
+		if letter.Status != status {
+			return nil, apperror.ErrConflict
+		}
+		for column, value := range changes {
+			switch column {
+			case "status":
+				letter.Status = value.(model.DeadLetterStatus)
+			case "message":
+				letter.Message = value.(string)
+			case "fingerprint":
+				letter.Fingerprint = value.(string)
+			case "edited_at":
+				letter.EditedAt = timeOrNil(value)
+			case "redriven_at":
+				letter.RedrivenAt = timeOrNil(value)
+			case "resolved_at":
+				letter.ResolvedAt = timeOrNil(value)
+			}
+		}
+		copied := *letter
+		return &copied, nil
+	}
+	return nil, apperror.ErrNotFound
+}
+
+// wirePublisher keeps what would go over the wire, encoded like the nats publisher does.
+type wirePublisher struct {
+	pubsub.PubSubPublisher
+	topics   []string
+	messages [][]byte
+}
+
+func (p *wirePublisher) Publish(ctx context.Context, topic string, message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	p.topics = append(p.topics, topic)
+	p.messages = append(p.messages, data)
+	return nil
+}
+
+func TestDeadLetterRedriveFailingAgainIsAnotherAttempt(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeDeadLetterRepo{}
+	publisher := &wirePublisher{}
+	deadLetters := service.NewDeadLetterService(repo, publisher, nil, zap.NewNop())
+
+	deviceID := uuid.New()
+	raw := `{
+		"device_id": "` + deviceID.String() + `",
+		"command_code": "device@status_update",
+		"payload": {"status": "<unknown>"}
+	}`
+	deadLetters.Record(ctx, &model.DeadLetter{
+		Subject:     pubsub.NatsTopicCommandsInbound,
+		Message:     raw,
+		DeviceID:    &deviceID,
+		CommandCode: "device@status_update",
+		ErrorCode:   "invalid_payload",
+		Error:       "payload of device@status_update has 1 violation(s)",
+	})
+	require.Len(t, repo.letters, 1)
+	letterID := repo.letters[0].ID
+	assert.Equal(t, model.DeadLetterStatusPending, repo.letters[0].Status)
+	assert.Equal(t, 1, repo.letters[0].Attempts)
+
+	letter, err := deadLetters.Redrive(ctx, letterID)
+	require.NoError(t, err)
+	assert.Equal(t, model.DeadLetterStatusRedriven, letter.Status)
+	require.Equal(t, []string{pubsub.NatsTopicCommandsInbound}, publisher.topics)
+
+	_, err = deadLetters.Redrive(ctx, letterID)
+	assert.Equal(t, apperror.ErrCodeConflict, apperror.FromError(err).Code, "only pending dead letters are re-driven")
+
+	// the processor fails on the re-driven message, compacted and escaped on the way, and records it again
+	deadLetters.Record(ctx, &model.DeadLetter{
+		Subject:   pubsub.NatsTopicCommandsInbound,
+		Message:   string(publisher.messages[0]),
+		ErrorCode: "invalid_payload",
+		Error:     "payload of device@status_update has 1 violation(s)",
+	})
+	require.Len(t, repo.letters, 1, "a re-driven message failing again is the same dead letter")
+	assert.Equal(t, model.DeadLetterStatusPending, repo.letters[0].Status)
+	assert.Equal(t, 2, repo.letters[0].Attempts)
+
+	_, err = deadLetters.Edit(ctx, letterID, `{"device_id": `)
+	assert.Equal(t, apperror.ErrCodeValidation, apperror.FromError(err).Code)
+
+	letter, err = deadLetters.Discard(ctx, letterID)
+	require.NoError(t, err)
+	assert.Equal(t, model.DeadLetterStatusDiscarded, letter.Status)
+	assert.NotNil(t, letter.ResolvedAt)
+}
+
+func TestDeadLetterUndecodableMessageNeedsAnEdit(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeDeadLetterRepo{}
+	publisher := &wirePublisher{}
+	deadLetters := service.NewDeadLetterService(repo, publisher, nil, zap.NewNop())
+
+	deadLetters.Record(ctx, &model.DeadLetter{Subject: pubsub.NatsTopicCommandsInbound, Message: `{"command_code": "device@init"`, ErrorCode: "unmarshal_error"})
+	require.Len(t, repo.letters, 1)
+	letterID := repo.letters[0].ID
+	assert.Equal(t, model.DeadLetterCommandCodeUnknown, repo.letters[0].CommandCode)
+
+	_, err := deadLetters.Redrive(ctx, letterID)
+	assert.Equal(t, apperror.ErrCodeValidation, apperror.FromError(err).Code)
+	assert.Empty(t, publisher.topics)
+
+	_, err = deadLetters.Edit(ctx, letterID, `{"command_code": "device@init"}`)
+	require.NoError(t, err)
+	letter, err := deadLetters.Redrive(ctx, letterID)
+	require.NoError(t, err)
+	assert.Equal(t, model.DeadLetterStatusRedriven, letter.Status)
+	assert.JSONEq(t, `{"command_code": "device@init"}`, string(publisher.messages[0]))
+}
+
+func TestDeadLetterConcurrentChangesDontOverwriteEachOther(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeDeadLetterRepo{}
+	publisher := &wirePublisher{}
+	deadLetters := service.NewDeadLetterService(repo, publisher, nil, zap.NewNop())
+
+	failure := &model.DeadLetter{Subject: pubsub.NatsTopicCommandStatus, Message: `{"type": "command_completed"}`, ErrorCode: "db_error"}
+	deadLetters.Record(ctx, failure)
+	require.Len(t, repo.letters, 1)
+	letterID := repo.letters[0].ID
+
+	// a consumer counts another failure between the edit reading the dead letter and writing the new message
+	repo.beforeUpdate = func() {
+		deadLetters.Record(ctx, &model.DeadLetter{Subject: failure.Subject, Message: failure.Message, ErrorCode: "db_error"})
+	}
+	letter, err := deadLetters.Edit(ctx, letterID, `{"type": "command_completed", "correlation_id": "6f1c"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 2, letter.Attempts, "the edit keeps the attempt counted meanwhile")
+	assert.NotNil(t, letter.EditedAt)
+
+	// another operator re-drives it between the re-drive reading the dead letter and moving it out of pending
+	var concurrentErr error
+	repo.beforeUpdate = func() {
+		_, concurrentErr = deadLetters.Redrive(ctx, letterID)
+	}
+	_, err = deadLetters.Redrive(ctx, letterID)
+	require.NoError(t, concurrentErr)
+	assert.Equal(t, apperror.ErrCodeConflict, apperror.FromError(err).Code)
+	assert.Len(t, publisher.topics, 1, "the message is published once")
+	assert.Equal(t, model.DeadLetterStatusRedriven, repo.letters[0].Status)
+}
@@ -3,45 +3,75 @@ package service
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/devicemanifest"
+	"github.com/vars7899/iots/internal/domain"
 	"github.com/vars7899/iots/internal/domain/model"
 	"github.com/vars7899/iots/internal/repository"
 	"github.com/vars7899/iots/pkg/apperror"
 	"github.com/vars7899/iots/pkg/auth/deviceauth"
 	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pagination"
 	"github.com/vars7899/iots/pkg/utils"
 	"go.uber.org/zap"
 )
 
+// deviceImportChunkSize is the most devices of a manifest created in one transaction.
+const deviceImportChunkSize = 100
+
 type deviceService struct {
 	deviceRepo        repository.DeviceRepository
+	deviceStatus      *DeviceStatusService
 	deviceAuthService deviceauth.DeviceAuthService
+	listeners         []DeviceListener
 	log               *zap.Logger
 }
 
 type DeviceServiceOpts struct {
 }
 
-func NewDeviceService(deviceRepo repository.DeviceRepository, deviceAuthService deviceauth.DeviceAuthService, baseLogger *zap.Logger) DeviceService {
+func NewDeviceService(deviceRepo repository.DeviceRepository, deviceStatus *DeviceStatusService, deviceAuthService deviceauth.DeviceAuthService, baseLogger *zap.Logger) DeviceService {
 	return &deviceService{
 		deviceRepo:        deviceRepo,
+		deviceStatus:      deviceStatus,
 		deviceAuthService: deviceAuthService,
 		log:               logger.Named(baseLogger, "DeviceService"),
 	}
 }
 
+// AddListener registers a listener, it must be called before the api starts.
+func (s *deviceService) AddListener(listener DeviceListener) {
+	s.listeners = append(s.listeners, listener)
+}
+
+func (s *deviceService) notifyChanged(deviceIDs ...uuid.UUID) {
+	for _, listener := range s.listeners {
+		for _, deviceID := range deviceIDs {
+			listener.DeviceChanged(deviceID)
+		}
+	}
+}
+
 func (s *deviceService) PreRegister(ctx context.Context, device *model.Device) (*model.Device, error) {
 	return nil, nil
 }
 
-func (s *deviceService) CreateDevice(ctx context.Context, device *model.Device) (*model.Device, error) {
-	exist, err := s.deviceRepo.ExistByMACAddr(ctx, device.MACAddress)
+// CreateDevice registers the device along with its provision code, the code is returned once and only its hash is
+// kept.
+func (s *deviceService) CreateDevice(ctx context.Context, device *model.Device) (*model.Device, string, error) {
+	return s.createDevice(ctx, s.deviceRepo, device)
+}
+
+func (s *deviceService) createDevice(ctx context.Context, repo repository.DeviceRepository, device *model.Device) (*model.Device, string, error) {
+	exist, err := repo.ExistByMACAddr(ctx, device.MACAddress)
 	if err != nil {
-		return nil, apperror.ErrorHandler(err, apperror.ErrCodeInternal, "failed to validate mac address")
+		return nil, "", apperror.ErrorHandler(err, apperror.ErrCodeInternal, "failed to validate mac address")
 	}
 	if exist {
-		return nil, apperror.ErrDBInsert.WithMessagef("failed to create device: device with %s is either protected or already present", device.MACAddress)
+		return nil, "", apperror.ErrDBInsert.WithMessagef("failed to create device: device with %s is either protected or already present", device.MACAddress)
 	}
 
 	// // Generate secure initial connection token
@@ -52,18 +82,28 @@ func (s *deviceService) CreateDevice(ctx context.Context, device *model.Device)
 	// }
 	// device.HashConnectionToken(token)
 
-	provisionCode, err := utils.GenerateSecureToken(32)
+	provisionCode, err := issueProvisionCode(device)
 	if err != nil {
-		return nil, apperror.ErrInternal
+		return nil, "", err
 	}
-	s.log.Debug("provision code", zap.String("raw_provision_code", provisionCode))
-	device.StoreProvisionCode(provisionCode)
 
-	createdDevice, err := s.deviceRepo.Create(ctx, device)
+	createdDevice, err := repo.Create(ctx, device)
 	if err != nil {
-		return nil, ServiceError(err, apperror.ErrCodeDBInsert)
+		return nil, "", ServiceError(err, apperror.ErrCodeDBInsert)
 	}
-	return createdDevice, nil
+	return createdDevice, provisionCode, nil
+}
+
+// issueProvisionCode stores the hash of a new provision code on the device, the code itself is returned once.
+func issueProvisionCode(device *model.Device) (string, error) {
+	provisionCode, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return "", apperror.ErrInternal
+	}
+	if err := device.StoreProvisionCode(provisionCode); err != nil {
+		return "", apperror.ErrInternal.Wrap(err)
+	}
+	return provisionCode, nil
 }
 
 func (s *deviceService) ProvisionDevice(ctx context.Context, idStr string, provisionCode string) (*deviceauth.DeviceConnectionTokens, error) {
@@ -73,6 +113,15 @@ func (s *deviceService) ProvisionDevice(ctx context.Context, idStr string, provi
 	}
 
 	var connectionTokens *deviceauth.DeviceConnectionTokens
+	var provisioned *model.Device
+	change := &model.DeviceStatusChange{
+		DeviceID:  deviceID,
+		ToStatus:  model.DeviceStatusProvisioned,
+		Reason:    "provisioned with its provision code",
+		ActorType: model.DeviceStatusActorDevice,
+		ActorID:   &deviceID,
+		Source:    "provision",
+	}
 
 	err = s.deviceRepo.Transaction(ctx, func(txRepo repository.DeviceRepository) error {
 		device, err := txRepo.GetByID(ctx, deviceID)
@@ -84,9 +133,14 @@ func (s *deviceService) ProvisionDevice(ctx context.Context, idStr string, provi
 			return apperror.ErrInvalidCredentials.WithMessage("invalid provision credentials")
 		}
 
-		// update device status as 'provisioned'
-		if err := txRepo.MarkAsProvisioned(ctx, device.ID); err != nil {
-			return apperror.ErrorHandler(err, apperror.ErrCodeInternal)
+		// a provisioned device provisioning again only gets new tokens, retired and suspended ones get none
+		switch device.Status {
+		case model.DeviceStatusDecommissioned, model.DeviceStatusSuspended:
+			return apperror.ErrForbidden.WithMessagef("device is %s and cannot be provisioned", device.Status)
+		case model.DeviceStatusPendingProvision:
+			if provisioned, err = txRepo.TransitionStatus(ctx, change); err != nil {
+				return apperror.ErrorHandler(err, apperror.ErrCodeInternal)
+			}
 		}
 		// generate device authentication tokens
 		tokens, err := s.deviceAuthService.IssueTokens(ctx, device.ID)
@@ -100,6 +154,7 @@ func (s *deviceService) ProvisionDevice(ctx context.Context, idStr string, provi
 	if err != nil {
 		return nil, err
 	}
+	s.deviceStatus.Changed(ctx, provisioned, change)
 
 	return connectionTokens, nil
 }
@@ -113,53 +168,223 @@ func (s *deviceService) GetDeviceByID(ctx context.Context, deviceID uuid.UUID) (
 	if err != nil {
 		return nil, apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve device with ID %s", deviceID))
 	}
-	if deviceExist == nil {
-		return nil, apperror.ErrNotFound.WithMessagef("device with ID %s not found", deviceID)
+	return deviceExist, nil
+}
+
+func (s *deviceService) GetDeviceWithSensors(ctx context.Context, deviceID uuid.UUID) (*model.Device, error) {
+	deviceExist, err := s.deviceRepo.GetByIDWithSensors(ctx, deviceID)
+	if err != nil {
+		return nil, apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to retrieve device with ID %s", deviceID))
 	}
 	return deviceExist, nil
 }
 
+func (s *deviceService) ListDevices(ctx context.Context, filter *domain.DeviceFilter, paginationOpt *pagination.Pagination) ([]*model.Device, int64, error) {
+	devices, total, err := s.deviceRepo.List(ctx, filter, paginationOpt)
+	if err != nil {
+		return nil, 0, ServiceError(err, apperror.ErrCodeDBQuery)
+	}
+	return devices, total, nil
+}
+
 func (s *deviceService) UpdateDevice(ctx context.Context, deviceUpdates *model.Device) (*model.Device, error) {
 	updatedDevice, err := s.deviceRepo.Update(ctx, deviceUpdates)
 	if err != nil {
-		return nil, ServiceError(err, apperror.ErrDBUpdate)
+		return nil, ServiceError(err, apperror.ErrCodeDBUpdate)
 	}
+	s.notifyChanged(updatedDevice.ID)
 	return updatedDevice, nil
 }
 
+// DeleteDevice soft deletes the device, RecoverDevice brings it back.
 func (s *deviceService) DeleteDevice(ctx context.Context, deviceID uuid.UUID) error {
-	deviceExist, err := s.deviceRepo.GetByID(ctx, deviceID)
+	if err := s.deviceRepo.Delete(ctx, deviceID); err != nil {
+		return ServiceError(err, apperror.ErrCodeDBDelete)
+	}
+	s.notifyChanged(deviceID)
+	return nil
+}
+
+func (s *deviceService) RecoverDevice(ctx context.Context, deviceID uuid.UUID) (*model.Device, error) {
+	if err := s.deviceRepo.Recover(ctx, deviceID); err != nil {
+		return nil, ServiceError(err, apperror.ErrCodeDBUpdate)
+	}
+	return s.GetDeviceByID(ctx, deviceID)
+}
+
+func (s *deviceService) UpdateDeviceStatus(ctx context.Context, change *model.DeviceStatusChange) (*model.Device, error) {
+	return s.deviceStatus.Transition(ctx, change)
+}
+
+func (s *deviceService) ListDeviceStatusChanges(ctx context.Context, deviceID uuid.UUID, paginationOpt *pagination.Pagination) ([]*model.DeviceStatusChange, int64, error) {
+	return s.deviceStatus.History(ctx, deviceID, paginationOpt)
+}
+
+// CountDevicesByStatus counts the devices of every status, the statuses without a device count 0.
+func (s *deviceService) CountDevicesByStatus(ctx context.Context) (map[model.DeviceStatus]int64, error) {
+	counts, err := s.deviceRepo.CountByStatus(ctx)
 	if err != nil {
-		return ServiceError(err, apperror.ErrDBQuery)
+		return nil, ServiceError(err, apperror.ErrCodeDBQuery)
 	}
-	if deviceExist == nil {
-		return ServiceError(apperror.ErrNotFound, fmt.Sprintf("failed to delete device: no device found with id: %s", deviceID))
+	for _, status := range model.DeviceStatuses {
+		if _, ok := counts[status]; !ok {
+			counts[status] = 0
+		}
 	}
+	return counts, nil
+}
 
-	if err = s.deviceRepo.Delete(ctx, deviceID); err != nil {
-		return ServiceError(err, apperror.ErrDBDelete)
+// BulkCreateDevices registers the devices in one transaction, none is created when one fails. The provision codes
+// are returned in the order of the devices, like CreateDevice only once.
+func (s *deviceService) BulkCreateDevices(ctx context.Context, devices []*model.Device) ([]*model.Device, []string, error) {
+	createdDevices := make([]*model.Device, 0, len(devices))
+	provisionCodes := make([]string, 0, len(devices))
+	err := s.deviceRepo.Transaction(ctx, func(txRepo repository.DeviceRepository) error {
+		for i, device := range devices {
+			createdDevice, provisionCode, err := s.createDevice(ctx, txRepo, device)
+			if err != nil {
+				return apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to create device at position %d", i))
+			}
+			createdDevices = append(createdDevices, createdDevice)
+			provisionCodes = append(provisionCodes, provisionCode)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return createdDevices, provisionCodes, nil
+}
+
+// BulkUpdateDevices updates the devices in one transaction, none is updated when one fails.
+func (s *deviceService) BulkUpdateDevices(ctx context.Context, devicesUpdates []*model.Device) ([]*model.Device, error) {
+	updatedDevices := make([]*model.Device, 0, len(devicesUpdates))
+	err := s.deviceRepo.Transaction(ctx, func(txRepo repository.DeviceRepository) error {
+		for _, deviceUpdates := range devicesUpdates {
+			updatedDevice, err := txRepo.Update(ctx, deviceUpdates)
+			if err != nil {
+				return ServiceError(err, apperror.ErrCodeDBUpdate, fmt.Sprintf("failed to update device with ID %s", deviceUpdates.ID))
+			}
+			updatedDevices = append(updatedDevices, updatedDevice)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	for _, updatedDevice := range updatedDevices {
+		s.notifyChanged(updatedDevice.ID)
+	}
+	return updatedDevices, nil
+}
+
+// BulkDeleteDevices soft deletes the devices in one transaction, none is deleted when one fails.
+func (s *deviceService) BulkDeleteDevices(ctx context.Context, deviceIDs []uuid.UUID) error {
+	err := s.deviceRepo.Transaction(ctx, func(txRepo repository.DeviceRepository) error {
+		for _, deviceID := range deviceIDs {
+			if err := txRepo.Delete(ctx, deviceID); err != nil {
+				return ServiceError(err, apperror.ErrCodeDBDelete, fmt.Sprintf("failed to delete device with ID %s", deviceID))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.notifyChanged(deviceIDs...)
 	return nil
 }
 
-// func (s *DeviceService) BulkCreateDevices(ctx context.Context, devices []*model.Device) ([]*model.Device, error) {
-// 	return s.deviceRepo.BulkCreate(ctx, devices)
-// }
+type deviceImport struct {
+	result *dto.DeviceImportResult
+	device *model.Device
+}
 
-// func (s *DeviceService) BulkDeleteDevices(ctx context.Context, ids []uuid.UUID) error {
-// 	return s.deviceRepo.BulkDelete(ctx, ids)
-// }
+// ImportDevices registers the devices of a manifest. Every row is validated like a registration first, the valid
+// ones are created in transactions of deviceImportChunkSize devices: a chunk failing creates none of its devices and
+// the other chunks carry on. The report has a result per row, with the provision code of the devices created.
+func (s *deviceService) ImportDevices(ctx context.Context, rows []*devicemanifest.Row, dryRun bool) (*dto.DeviceImportReport, error) {
+	report := &dto.DeviceImportReport{DryRun: dryRun, Total: len(rows), Results: make([]*dto.DeviceImportResult, 0, len(rows))}
+	fail := func(result *dto.DeviceImportResult, err error) {
+		result.Error = err.Error()
+		report.Failed++
+	}
 
-// func (s *DeviceService) BulkUpdateDevices(ctx context.Context, devices []*model.Device) ([]*model.Device, error) {
-// 	return s.deviceRepo.BulkUpdate(ctx, devices)
-// }
+	imports := make([]*deviceImport, 0, len(rows))
+	macLines := make(map[string]int, len(rows))
+	for _, row := range rows {
+		result := &dto.DeviceImportResult{Line: row.Line}
+		report.Results = append(report.Results, result)
+		if row.Err != nil {
+			fail(result, row.Err)
+			continue
+		}
+		result.Name, result.MACAddress = row.Device.Name, row.Device.MACAddress
+		if err := row.Device.Validate(); err != nil {
+			fail(result, err)
+			continue
+		}
+		mac := strings.ToLower(row.Device.MACAddress)
+		if line, ok := macLines[mac]; ok {
+			fail(result, fmt.Errorf("mac address %s is already on line %d", row.Device.MACAddress, line))
+			continue
+		}
+		macLines[mac] = row.Line
+		exist, err := s.deviceRepo.ExistByMACAddr(ctx, row.Device.MACAddress)
+		if err != nil {
+			return nil, apperror.ErrorHandler(err, apperror.ErrCodeInternal, "failed to validate mac address")
+		}
+		if exist {
+			fail(result, fmt.Errorf("device with %s is either protected or already present", row.Device.MACAddress))
+			continue
+		}
+		imports = append(imports, &deviceImport{result: result, device: row.Device.AsModel()})
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	for start := 0; start < len(imports); start += deviceImportChunkSize {
+		chunk := imports[start:min(start+deviceImportChunkSize, len(imports))]
+		if err := s.importChunk(ctx, chunk); err != nil {
+			s.log.Warn("device import chunk rolled back", zap.Int("first_line", chunk[0].result.Line), zap.Int("devices", len(chunk)), zap.Error(err))
+			for _, imported := range chunk {
+				if imported.result.Error == "" {
+					fail(imported.result, fmt.Errorf("not created, its chunk rolled back: %w", err))
+				} else {
+					report.Failed++
+				}
+				imported.result.DeviceID, imported.result.ProvisionCode = nil, ""
+			}
+			continue
+		}
+		report.Created += len(chunk)
+	}
+	s.log.Info("devices imported", zap.Int("total", report.Total), zap.Int("created", report.Created), zap.Int("failed", report.Failed))
+	return report, nil
+}
+
+func (s *deviceService) importChunk(ctx context.Context, chunk []*deviceImport) error {
+	// hashing the provision codes is slow, it's done before the transaction
+	provisionCodes := make([]string, len(chunk))
+	for i, imported := range chunk {
+		provisionCode, err := issueProvisionCode(imported.device)
+		if err != nil {
+			return err
+		}
+		provisionCodes[i] = provisionCode
+	}
 
-// func (s *DeviceService) MarkDeviceAsOnline(ctx context.Context, deviceID uuid.UUID) (*model.Device, error) {
-// 	return s.deviceRepo.MarkOnline(ctx, deviceID)
-// }
-// func (s *DeviceService) MarkDeviceAsOffline(ctx context.Context, deviceID uuid.UUID) (*model.Device, error) {
-// 	return s.deviceRepo.MarkOffline(ctx, deviceID)
-// }
-// func (s *DeviceService) UpdateDeviceStatus(ctx context.Context, deviceID uuid.UUID, status domain.Status) error {
-// 	return s.deviceRepo.UpdateStatus(ctx, deviceID, status)
-// }
+	return s.deviceRepo.Transaction(ctx, func(txRepo repository.DeviceRepository) error {
+		for i, imported := range chunk {
+			createdDevice, err := txRepo.Create(ctx, imported.device)
+			if err != nil {
+				imported.result.Error = err.Error()
+				return fmt.Errorf("line %d: %w", imported.result.Line, err)
+			}
+			imported.result.DeviceID = &createdDevice.ID
+			imported.result.ProvisionCode = provisionCodes[i]
+		}
+		return nil
+	})
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pagination"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+// DeviceStatusService changes the status of devices. A transition the lifecycle doesn't allow is refused, every
+// other one is kept in the status history of the device and published as its state_changed event.
+type DeviceStatusService struct {
+	deviceRepo repository.DeviceRepository
+	publisher  pubsub.PubSubPublisher
+	l          *zap.Logger
+}
+
+func NewDeviceStatusService(deviceRepo repository.DeviceRepository, publisher pubsub.PubSubPublisher, baseLogger *zap.Logger) *DeviceStatusService {
+	return &DeviceStatusService{
+		deviceRepo: deviceRepo,
+		publisher:  publisher,
+		l:          logger.Named(baseLogger, "DeviceStatusService"),
+	}
+}
+
+// Transition moves the device to change.ToStatus, a device already in the status is left as is.
+func (s *DeviceStatusService) Transition(ctx context.Context, change *model.DeviceStatusChange) (*model.Device, error) {
+	if !model.IsValidDeviceStatus(string(change.ToStatus)) {
+		return nil, apperror.ErrValidation.WithMessagef("invalid device status %s", change.ToStatus)
+	}
+	device, err := s.deviceRepo.TransitionStatus(ctx, change)
+	if err != nil {
+		return nil, ServiceError(err, apperror.ErrCodeDBUpdate, "failed to change device status")
+	}
+	s.Changed(ctx, device, change)
+	return device, nil
+}
+
+// Changed publishes the state_changed event of a transition committed by the repositories, e.g. along with the
+// provisioning or the maintenance of the device. Nothing is published when the status didn't change.
+func (s *DeviceStatusService) Changed(ctx context.Context, device *model.Device, change *model.DeviceStatusChange) {
+	if s == nil || s.publisher == nil || device == nil || change.FromStatus == change.ToStatus {
+		return
+	}
+	event := model.DeviceEvent{
+		ID:        uuid.New(),
+		Type:      model.EventTypeStateChanged,
+		DeviceID:  device.ID,
+		Timestamp: change.ChangedAt,
+		Payload: map[string]interface{}{
+			"previous_status": change.FromStatus,
+			"current_status":  change.ToStatus,
+			"reason":          change.Reason,
+			"actor_type":      change.ActorType,
+			"actor_id":        change.ActorID,
+			"source":          change.Source,
+			"device":          device,
+		},
+	}
+	if change.ActorType == model.DeviceStatusActorDevice {
+		event.CommandCode = change.Source
+	}
+
+	topic := pubsub.NatsTopicDeviceEventsPrefixf(device.ID)
+	if err := s.publisher.Publish(ctx, topic, event); err != nil {
+		s.l.Warn("Failed to publish device state change", zap.String("topic", topic), zap.String("device_id", device.ID.String()), zap.Error(err))
+	}
+}
+
+func (s *DeviceStatusService) History(ctx context.Context, deviceID uuid.UUID, paginationOpt *pagination.Pagination) ([]*model.DeviceStatusChange, int64, error) {
+	if _, err := s.deviceRepo.GetByID(ctx, deviceID); err != nil {
+		return nil, 0, ServiceError(err, apperror.ErrCodeDBQuery)
+	}
+	changes, total, err := s.deviceRepo.ListStatusChanges(ctx, deviceID, paginationOpt)
+	if err != nil {
+		return nil, 0, ServiceError(err, apperror.ErrCodeDBQuery)
+	}
+	return changes, total, nil
+}
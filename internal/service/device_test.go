@@ -0,0 +1,224 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/devicemanifest"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/internal/validation"
+	"github.com/vars7899/iots/pkg/apperror"
+	"go.uber.org/zap"
+)
+
+type memoryDeviceRepo struct {
+	repository.DeviceRepository
+	devices map[uuid.UUID]*model.Device
+	deleted map[uuid.UUID]bool
+	history []*model.DeviceStatusChange
+}
+
+func newMemoryDeviceRepo() *memoryDeviceRepo {
+	return &memoryDeviceRepo{devices: map[uuid.UUID]*model.Device{}, deleted: map[uuid.UUID]bool{}}
+}
+
+// Transaction rolls every change of the function back when it fails.
+func (r *memoryDeviceRepo) Transaction(ctx context.Context, fn func(txRepo repository.DeviceRepository) error) error {
+	tx := newMemoryDeviceRepo()
+	for deviceID, device := range r.devices {
+		copied := *device
+		tx.devices[deviceID] = &copied
+	}
+	for deviceID := range r.deleted {
+		tx.deleted[deviceID] = true
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	r.devices, r.deleted = tx.devices, tx.deleted
+	return nil
+}
+
+func (r *memoryDeviceRepo) ExistByMACAddr(ctx context.Context, macAddr string) (bool, error) {
+	for _, device := range r.devices {
+		if device.MACAddress == macAddr {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryDeviceRepo) Create(ctx context.Context, device *model.Device) (*model.Device, error) {
+	device.ID = uuid.New()
+	device.Status = model.DeviceStatusPendingProvision
+	r.devices[device.ID] = device
+	return device, nil
+}
+
+func (r *memoryDeviceRepo) GetByID(ctx context.Context, deviceID uuid.UUID) (*model.Device, error) {
+	device, ok := r.devices[deviceID]
+	if !ok || r.deleted[deviceID] {
+		return nil, apperror.ErrNotFound
+	}
+	return device, nil
+}
+
+func (r *memoryDeviceRepo) Delete(ctx context.Context, deviceID uuid.UUID) error {
+	if _, ok := r.devices[deviceID]; !ok || r.deleted[deviceID] {
+		return apperror.ErrNotFound
+	}
+	r.deleted[deviceID] = true
+	return nil
+}
+
+// TransitionStatus checks the lifecycle like the postgres repository, the history is kept in memory.
+func (r *memoryDeviceRepo) TransitionStatus(ctx context.Context, change *model.DeviceStatusChange) (*model.Device, error) {
+	device, ok := r.devices[change.DeviceID]
+	if !ok {
+		return nil, apperror.ErrNotFound
+	}
+	change.FromStatus = device.Status
+	if change.FromStatus == change.ToStatus {
+		return device, nil
+	}
+	if !model.CanTransitionDeviceStatus(change.FromStatus, change.ToStatus) {
+		return nil, apperror.ErrConflict.WithMessagef("device cannot go from %s to %s", change.FromStatus, change.ToStatus)
+	}
+	device.Status = change.ToStatus
+	r.history = append(r.history, change)
+	return device, nil
+}
+
+func (r *memoryDeviceRepo) CountByStatus(ctx context.Context) (map[model.DeviceStatus]int64, error) {
+	counts := map[model.DeviceStatus]int64{}
+	for deviceID, device := range r.devices {
+		if !r.deleted[deviceID] {
+			counts[device.Status]++
+		}
+	}
+	return counts, nil
+}
+
+func TestDeviceBulkOperationsAreAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryDeviceRepo()
+	devices := service.NewDeviceService(repo, service.NewDeviceStatusService(repo, nil, zap.NewNop()), nil, zap.NewNop())
+
+	created, provisionCodes, err := devices.BulkCreateDevices(ctx, []*model.Device{
+		{Name: "boiler", MACAddress: "00:1a:2b:3c:4d:5e"},
+		{Name: "chiller", MACAddress: "00:1a:2b:3c:4d:5f"},
+	})
+	require.NoError(t, err)
+	require.Len(t, created, 2)
+	require.Len(t, provisionCodes, 2)
+	for i, device := range created {
+		assert.NoError(t, device.CompareProvisionCode(provisionCodes[i]), "the code provisions the device at its position")
+	}
+	assert.Error(t, created[0].CompareProvisionCode(provisionCodes[1]))
+
+	_, _, err = devices.BulkCreateDevices(ctx, []*model.Device{
+		{Name: "pump", MACAddress: "00:1a:2b:3c:4d:60"},
+		{Name: "boiler again", MACAddress: "00:1a:2b:3c:4d:5e"},
+	})
+	require.Error(t, err)
+	assert.Len(t, repo.devices, 2, "the pump is rolled back with the duplicate boiler")
+
+	err = devices.BulkDeleteDevices(ctx, []uuid.UUID{created[0].ID, uuid.New()})
+	assert.Equal(t, apperror.ErrCodeNotFound, apperror.FromError(err).Code)
+	assert.Empty(t, repo.deleted, "the boiler is kept when another device can't be deleted")
+
+	require.NoError(t, devices.BulkDeleteDevices(ctx, []uuid.UUID{created[0].ID}))
+	counts, err := devices.CountDevicesByStatus(ctx)
+	require.NoError(t, err)
+	assert.Len(t, counts, len(model.DeviceStatuses), "every status is counted")
+	assert.Equal(t, int64(1), counts[model.DeviceStatusPendingProvision])
+	assert.Equal(t, int64(0), counts[model.DeviceStatusOnline])
+}
+
+func TestDeviceImportReportsEveryRow(t *testing.T) {
+	validation.Init(zap.NewNop())
+	ctx := context.Background()
+	repo := newMemoryDeviceRepo()
+	devices := service.NewDeviceService(repo, service.NewDeviceStatusService(repo, nil, zap.NewNop()), nil, zap.NewNop())
+	_, _, err := devices.CreateDevice(ctx, &model.Device{Name: "boiler", MACAddress: "00:1a:2b:3c:4d:5e"})
+	require.NoError(t, err)
+
+	registration := func(name, macAddr string) *dto.RegisterDeviceRequest {
+		return &dto.RegisterDeviceRequest{
+			Name:          name,
+			MACAddress:    macAddr,
+			Specification: dto.DeviceSpecificationPayload{Manufacturer: "Acme", ModelNumber: "X-1", SerialNumber: name},
+		}
+	}
+	rows := []*devicemanifest.Row{
+		{Line: 2, Device: registration("chiller", "00:1a:2b:3c:4d:5f")},
+		{Line: 3, Device: registration("boiler", "00:1a:2b:3c:4d:5e")},
+		{Line: 4, Device: registration("pump", "not a mac")},
+		{Line: 5, Device: registration("chiller twin", "00:1A:2B:3C:4D:5F")},
+		{Line: 6, Err: errors.New("batch_size is not an integer: ten")},
+		{Line: 7, Device: registration("fan", "00:1a:2b:3c:4d:61")},
+	}
+
+	report, err := devices.ImportDevices(ctx, rows, true)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Created)
+	assert.Equal(t, 4, report.Failed)
+	assert.Len(t, repo.devices, 1, "a dry run creates nothing")
+
+	report, err = devices.ImportDevices(ctx, rows, false)
+	require.NoError(t, err)
+	assert.Equal(t, 6, report.Total)
+	assert.Equal(t, 2, report.Created)
+	assert.Equal(t, 4, report.Failed)
+	require.Len(t, report.Results, 6)
+	assert.Contains(t, report.Results[1].Error, "already present")
+	assert.NotEmpty(t, report.Results[2].Error)
+	assert.Equal(t, "mac address 00:1A:2B:3C:4D:5F is already on line 2", report.Results[3].Error)
+	assert.Equal(t, "batch_size is not an integer: ten", report.Results[4].Error)
+
+	for _, result := range []*dto.DeviceImportResult{report.Results[0], report.Results[5]} {
+		require.NotNil(t, result.DeviceID)
+		assert.Empty(t, result.Error)
+		assert.NoError(t, repo.devices[*result.DeviceID].CompareProvisionCode(result.ProvisionCode), "the report has the code the device provisions with")
+	}
+}
+
+func TestDeviceStatusFollowsTheLifecycle(t *testing.T) {
+	repo := newMemoryDeviceRepo()
+	publisher := &fakePublisher{}
+	statuses := service.NewDeviceStatusService(repo, publisher, zap.NewNop())
+	device, err := repo.Create(context.Background(), &model.Device{Name: "pump", MACAddress: "00:1a:2b:3c:4d:60"})
+	require.NoError(t, err)
+	userID := uuid.New()
+	transition := func(to model.DeviceStatus) error {
+		_, err := statuses.Transition(context.Background(), &model.DeviceStatusChange{
+			DeviceID: device.ID, ToStatus: to, Reason: "test", ActorType: model.DeviceStatusActorUser, ActorID: &userID, Source: "api",
+		})
+		return err
+	}
+
+	assert.Equal(t, apperror.ErrCodeConflict, apperror.FromError(transition(model.DeviceStatusOnline)).Code, "pending devices are provisioned first")
+	require.NoError(t, transition(model.DeviceStatusProvisioned))
+	require.NoError(t, transition(model.DeviceStatusSuspended))
+	assert.Equal(t, apperror.ErrCodeConflict, apperror.FromError(transition(model.DeviceStatusOnline)).Code, "suspended devices are reinstated first")
+	require.NoError(t, transition(model.DeviceStatusOffline))
+	require.NoError(t, transition(model.DeviceStatusOffline), "a device already in the status is left alone")
+	require.NoError(t, transition(model.DeviceStatusDecommissioned))
+	for _, status := range model.DeviceStatuses {
+		if status != model.DeviceStatusDecommissioned {
+			assert.Error(t, transition(status), "decommissioned is for good")
+		}
+	}
+
+	require.Len(t, repo.history, 4)
+	assert.Equal(t, model.DeviceStatusSuspended, repo.history[2].FromStatus)
+	assert.Equal(t, model.DeviceStatusOffline, repo.history[2].ToStatus)
+	assert.Len(t, publisher.topics, 4, "one state_changed event per recorded change")
+}
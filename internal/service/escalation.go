@@ -0,0 +1,326 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/notification"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	escalationClaimLease = 2 * time.Minute // a claimed escalation is retried after this when its worker died
+	escalationBatchSize  = 100
+)
+
+// EscalationService escalates unacknowledged alerts through the tiers of the matching escalation policy. The
+// schedule of every alert is stored, so escalations carry on after a restart.
+type EscalationService struct {
+	escalationRepo repository.EscalationRepository
+	onCallRepo     repository.OnCallRepository
+	alertRepo      repository.AlertRepository
+	deviceRepo     repository.DeviceRepository
+	userRepo       repository.UserRepository
+	notifier       Notifier
+	wake           chan struct{}
+	l              *zap.Logger
+}
+
+func NewEscalationService(escalationRepo repository.EscalationRepository, onCallRepo repository.OnCallRepository, alertRepo repository.AlertRepository, deviceRepo repository.DeviceRepository, userRepo repository.UserRepository, notifier Notifier, baseLogger *zap.Logger) *EscalationService {
+	return &EscalationService{
+		escalationRepo: escalationRepo,
+		onCallRepo:     onCallRepo,
+		alertRepo:      alertRepo,
+		deviceRepo:     deviceRepo,
+		userRepo:       userRepo,
+		notifier:       notifier,
+		wake:           make(chan struct{}, 1),
+		l:              logger.Named(baseLogger, "EscalationService"),
+	}
+}
+
+func (s *EscalationService) CreatePolicy(ctx context.Context, policy *model.EscalationPolicy) (*model.EscalationPolicy, error) {
+	if _, err := policy.ParseTiers(); err != nil {
+		return nil, apperror.ErrValidation.WithMessage(err.Error()).Wrap(err)
+	}
+	if err := s.escalationRepo.CreatePolicy(ctx, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (s *EscalationService) UpdatePolicy(ctx context.Context, policy *model.EscalationPolicy) (*model.EscalationPolicy, error) {
+	if _, err := policy.ParseTiers(); err != nil {
+		return nil, apperror.ErrValidation.WithMessage(err.Error()).Wrap(err)
+	}
+	if err := s.escalationRepo.UpdatePolicy(ctx, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (s *EscalationService) DeletePolicy(ctx context.Context, policyID uuid.UUID) error {
+	return s.escalationRepo.DeletePolicy(ctx, policyID)
+}
+
+func (s *EscalationService) GetPolicy(ctx context.Context, policyID uuid.UUID) (*model.EscalationPolicy, error) {
+	return s.escalationRepo.GetPolicy(ctx, policyID)
+}
+
+func (s *EscalationService) ListPolicies(ctx context.Context) ([]*model.EscalationPolicy, error) {
+	return s.escalationRepo.ListPolicies(ctx)
+}
+
+func (s *EscalationService) CreateSchedule(ctx context.Context, schedule *model.OnCallSchedule) (*model.OnCallSchedule, error) {
+	if err := schedule.Validate(); err != nil {
+		return nil, apperror.ErrValidation.WithMessage(err.Error()).Wrap(err)
+	}
+	if err := s.onCallRepo.CreateSchedule(ctx, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *EscalationService) UpdateSchedule(ctx context.Context, schedule *model.OnCallSchedule) (*model.OnCallSchedule, error) {
+	if err := schedule.Validate(); err != nil {
+		return nil, apperror.ErrValidation.WithMessage(err.Error()).Wrap(err)
+	}
+	if err := s.onCallRepo.UpdateSchedule(ctx, schedule); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *EscalationService) DeleteSchedule(ctx context.Context, scheduleID uuid.UUID) error {
+	return s.onCallRepo.DeleteSchedule(ctx, scheduleID)
+}
+
+func (s *EscalationService) GetSchedule(ctx context.Context, scheduleID uuid.UUID) (*model.OnCallSchedule, error) {
+	return s.onCallRepo.GetSchedule(ctx, scheduleID)
+}
+
+func (s *EscalationService) ListSchedules(ctx context.Context) ([]*model.OnCallSchedule, error) {
+	return s.onCallRepo.ListSchedules(ctx)
+}
+
+func (s *EscalationService) AddOverride(ctx context.Context, override *model.OnCallOverride) (*model.OnCallOverride, error) {
+	if !override.EndsAt.After(override.StartsAt) {
+		return nil, apperror.ErrValidation.WithMessage("ends_at must be after starts_at")
+	}
+	if _, err := s.onCallRepo.GetSchedule(ctx, override.ScheduleID); err != nil {
+		return nil, err
+	}
+	if err := s.onCallRepo.AddOverride(ctx, override); err != nil {
+		return nil, err
+	}
+	return override, nil
+}
+
+func (s *EscalationService) DeleteOverride(ctx context.Context, scheduleID uuid.UUID, overrideID uuid.UUID) error {
+	return s.onCallRepo.DeleteOverride(ctx, scheduleID, overrideID)
+}
+
+// OnCall returns the user on call for the schedule at the time.
+func (s *EscalationService) OnCall(ctx context.Context, scheduleID uuid.UUID, at time.Time) (*model.User, error) {
+	schedule, err := s.onCallRepo.GetSchedule(ctx, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	userID, ok := schedule.OnCallAt(at)
+	if !ok {
+		return nil, apperror.ErrNotFound.WithMessage("nobody is on call")
+	}
+	return s.userRepo.GetByID(ctx, userID)
+}
+
+// AlertChanged implements AlertListener, triggered alerts start escalating and acknowledged or resolved ones stop.
+func (s *EscalationService) AlertChanged(ctx context.Context, event string, alert *model.Alert) {
+	var err error
+	switch event {
+	case "triggered":
+		err = s.start(ctx, alert)
+	case "acknowledged", "resolved":
+		err = s.escalationRepo.CancelEscalation(ctx, alert.ID)
+	}
+	if err != nil {
+		s.l.Error("failed to update alert escalation", zap.String("event", event), zap.String("alert_id", alert.ID.String()), zap.Error(err))
+	}
+}
+
+func (s *EscalationService) start(ctx context.Context, alert *model.Alert) error {
+	policy, tiers, err := s.matchPolicy(ctx, alert)
+	if err != nil || policy == nil {
+		return err
+	}
+
+	now := time.Now()
+	escalation := &model.AlertEscalation{
+		AlertID:   alert.ID,
+		PolicyID:  policy.ID,
+		Status:    model.EscalationStatusPending,
+		NextTier:  0,
+		NextAt:    now.Add(time.Duration(tiers[0].AfterMinutes) * time.Minute),
+		StartedAt: now,
+	}
+	if err := s.escalationRepo.StartEscalation(ctx, escalation); err != nil {
+		return err
+	}
+	if tiers[0].AfterMinutes == 0 {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// matchPolicy returns the highest priority enabled policy covering the alert, nil when none does.
+func (s *EscalationService) matchPolicy(ctx context.Context, alert *model.Alert) (*model.EscalationPolicy, []model.EscalationTier, error) {
+	policies, err := s.escalationRepo.ListEnabledPolicies(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var device *model.Device
+	for _, policy := range policies {
+		if policy.Tag != "" && device == nil {
+			if device, err = s.deviceRepo.GetByID(ctx, alert.DeviceID); err != nil {
+				return nil, nil, err
+			}
+		}
+		if !policy.Matches(alert, device) {
+			continue
+		}
+		tiers, err := policy.ParseTiers()
+		if err != nil {
+			s.l.Warn("skipping escalation policy with invalid tiers", zap.String("policy_id", policy.ID.String()), zap.Error(err))
+			continue
+		}
+		return policy, tiers, nil
+	}
+	return nil, nil, nil
+}
+
+// ProcessDue notifies the due tier of every claimed escalation and returns how many were processed.
+func (s *EscalationService) ProcessDue(ctx context.Context) (int, error) {
+	now := time.Now()
+	escalations, err := s.escalationRepo.ClaimDue(ctx, now, escalationClaimLease, escalationBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, escalation := range escalations {
+		if err := s.escalate(ctx, escalation, now); err != nil {
+			// the claim lease runs out and the tier is tried again
+			s.l.Error("failed to escalate alert", zap.String("alert_id", escalation.AlertID.String()), zap.Int("tier", escalation.NextTier), zap.Error(err))
+		}
+	}
+	return len(escalations), nil
+}
+
+// Wake is signalled when an escalation is due right away, the escalation worker listens on it besides its ticker.
+func (s *EscalationService) Wake() <-chan struct{} {
+	return s.wake
+}
+
+func (s *EscalationService) escalate(ctx context.Context, escalation *model.AlertEscalation, now time.Time) error {
+	alert, err := s.alertRepo.GetByID(ctx, escalation.AlertID)
+	if err != nil {
+		if apperror.FromError(err).Code == apperror.ErrCodeNotFound {
+			return s.finish(ctx, escalation, model.EscalationStatusCancelled)
+		}
+		return err
+	}
+	if alert.Status != model.AlertStatusOpen && alert.Status != model.AlertStatusFlapping {
+		return s.finish(ctx, escalation, model.EscalationStatusCancelled)
+	}
+
+	policy, err := s.escalationRepo.GetPolicy(ctx, escalation.PolicyID)
+	if err != nil {
+		if apperror.FromError(err).Code == apperror.ErrCodeNotFound {
+			return s.finish(ctx, escalation, model.EscalationStatusCancelled)
+		}
+		return err
+	}
+	tiers, err := policy.ParseTiers()
+	if err != nil || escalation.NextTier >= len(tiers) {
+		return s.finish(ctx, escalation, model.EscalationStatusCompleted)
+	}
+
+	tier := tiers[escalation.NextTier]
+	recipients, err := s.recipients(ctx, tier, now)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		s.l.Warn("escalation tier has nobody to notify", zap.String("alert_id", alert.ID.String()), zap.Int("tier", escalation.NextTier))
+	} else if s.notifier != nil {
+		err := s.notifier.Notify(ctx, &notification.Notification{
+			Event:      notification.EventAlertEscalated,
+			Subject:    fmt.Sprintf("[%s] escalation %d: %s", alert.Severity, escalation.NextTier+1, alert.Message),
+			Recipients: recipients,
+			Data: map[string]interface{}{
+				"alert":  alert,
+				"tier":   escalation.NextTier + 1,
+				"policy": policy.Name,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	escalation.LastNotifiedAt = &now
+	escalation.NextTier++
+	if escalation.NextTier >= len(tiers) {
+		return s.finish(ctx, escalation, model.EscalationStatusCompleted)
+	}
+	escalation.NextAt = escalation.StartedAt.Add(time.Duration(tiers[escalation.NextTier].AfterMinutes) * time.Minute)
+	return s.escalationRepo.UpdateEscalation(ctx, escalation)
+}
+
+func (s *EscalationService) finish(ctx context.Context, escalation *model.AlertEscalation, status model.EscalationStatus) error {
+	escalation.Status = status
+	return s.escalationRepo.UpdateEscalation(ctx, escalation)
+}
+
+// recipients resolves the email addresses of the tier's users and of whoever is on call on its schedules.
+func (s *EscalationService) recipients(ctx context.Context, tier model.EscalationTier, at time.Time) ([]string, error) {
+	userIDs := append([]uuid.UUID{}, tier.UserIDs...)
+	for _, scheduleID := range tier.ScheduleIDs {
+		schedule, err := s.onCallRepo.GetSchedule(ctx, scheduleID)
+		if err != nil {
+			if apperror.FromError(err).Code == apperror.ErrCodeNotFound {
+				s.l.Warn("escalation tier references a missing schedule", zap.String("schedule_id", scheduleID.String()))
+				continue
+			}
+			return nil, err
+		}
+		if userID, ok := schedule.OnCallAt(at); ok {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	seen := make(map[uuid.UUID]bool, len(userIDs))
+	var emails []string
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		user, err := s.userRepo.GetByID(ctx, userID)
+		if err != nil {
+			if apperror.FromError(err).Code == apperror.ErrCodeNotFound {
+				continue
+			}
+			return nil, err
+		}
+		emails = append(emails, user.Email)
+	}
+	return emails, nil
+}
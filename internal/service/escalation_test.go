@@ -0,0 +1,247 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/notification"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"go.uber.org/zap"
+)
+
+type fakeEscalationRepo struct {
+	repository.EscalationRepository
+	policies    []*model.EscalationPolicy
+	escalations map[uuid.UUID]*model.AlertEscalation
+}
+
+func (r *fakeEscalationRepo) ListEnabledPolicies(ctx context.Context) ([]*model.EscalationPolicy, error) {
+	return r.policies, nil
+}
+
+func (r *fakeEscalationRepo) GetPolicy(ctx context.Context, policyID uuid.UUID) (*model.EscalationPolicy, error) {
+	for _, policy := range r.policies {
+		if policy.ID == policyID {
+			return policy, nil
+		}
+	}
+	return nil, apperror.ErrNotFound
+}
+
+func (r *fakeEscalationRepo) StartEscalation(ctx context.Context, escalation *model.AlertEscalation) error {
+	if existing, ok := r.escalations[escalation.AlertID]; ok && existing.Status == model.EscalationStatusPending {
+		return nil
+	}
+	r.escalations[escalation.AlertID] = escalation
+	return nil
+}
+
+func (r *fakeEscalationRepo) UpdateEscalation(ctx context.Context, escalation *model.AlertEscalation) error {
+	r.escalations[escalation.AlertID] = escalation
+	return nil
+}
+
+func (r *fakeEscalationRepo) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.AlertEscalation, error) {
+	var due []*model.AlertEscalation
+	for _, escalation := range r.escalations {
+		if escalation.Status == model.EscalationStatusPending && !escalation.NextAt.After(now) {
+			claimed := *escalation
+			escalation.NextAt = now.Add(lease)
+			due = append(due, &claimed)
+		}
+	}
+	return due, nil
+}
+
+func (r *fakeEscalationRepo) CancelEscalation(ctx context.Context, alertID uuid.UUID) error {
+	if escalation, ok := r.escalations[alertID]; ok && escalation.Status == model.EscalationStatusPending {
+		escalation.Status = model.EscalationStatusCancelled
+	}
+	return nil
+}
+
+type fakeOnCallRepo struct {
+	repository.OnCallRepository
+	schedule *model.OnCallSchedule
+}
+
+func (r *fakeOnCallRepo) GetSchedule(ctx context.Context, scheduleID uuid.UUID) (*model.OnCallSchedule, error) {
+	return r.schedule, nil
+}
+
+type fakeUserRepo struct {
+	repository.UserRepository
+	users map[uuid.UUID]*model.User
+}
+
+func (r *fakeUserRepo) GetByID(ctx context.Context, userID uuid.UUID) (*model.User, error) {
+	if user, ok := r.users[userID]; ok {
+		return user, nil
+	}
+	return nil, apperror.ErrNotFound
+}
+
+type fakeNotifier struct {
+	sent []*notification.Notification
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, notification *notification.Notification) error {
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+func TestEscalationThroughOnCallTiers(t *testing.T) {
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	users := &fakeUserRepo{users: map[uuid.UUID]*model.User{
+		alice: {ID: alice, Email: "alice@example.com"},
+		bob:   {ID: bob, Email: "bob@example.com"},
+		carol: {ID: carol, Email: "carol@example.com"},
+	}}
+
+	// weekly rotation alice -> bob, bob covers alice's current week
+	now := time.Now()
+	schedule := &model.OnCallSchedule{
+		ID:            uuid.New(),
+		Members:       pq.StringArray{alice.String(), bob.String()},
+		RotationStart: now.Add(-time.Hour),
+		ShiftHours:    168,
+	}
+	require.NoError(t, schedule.Validate())
+	onCall, _ := schedule.OnCallAt(now)
+	assert.Equal(t, alice, onCall)
+	onCall, _ = schedule.OnCallAt(now.Add(8 * 24 * time.Hour))
+	assert.Equal(t, bob, onCall)
+	schedule.Overrides = []model.OnCallOverride{{UserID: bob, StartsAt: now.Add(-time.Minute), EndsAt: now.Add(time.Hour)}}
+
+	tiers, err := json.Marshal([]model.EscalationTier{
+		{AfterMinutes: 0, ScheduleIDs: []uuid.UUID{schedule.ID}},
+		{AfterMinutes: 15, UserIDs: []uuid.UUID{carol}},
+	})
+	require.NoError(t, err)
+	critical := &model.EscalationPolicy{ID: uuid.New(), Severity: model.AlertSeverityCritical, Priority: 10, Tiers: tiers, Enabled: true}
+
+	escalationRepo := &fakeEscalationRepo{policies: []*model.EscalationPolicy{critical}, escalations: map[uuid.UUID]*model.AlertEscalation{}}
+	alertRepo := &fakeAlertRepo{}
+	notifier := &fakeNotifier{}
+	escalationService := service.NewEscalationService(escalationRepo, &fakeOnCallRepo{schedule: schedule}, alertRepo, &fakeDeviceRepo{}, users, notifier, zap.NewNop())
+
+	ctx := context.Background()
+	warning := &model.Alert{DeviceID: uuid.New(), Severity: model.AlertSeverityWarning, Status: model.AlertStatusOpen}
+	alert := &model.Alert{DeviceID: uuid.New(), Severity: model.AlertSeverityCritical, Status: model.AlertStatusOpen, Message: "freezer above -15C"}
+	require.NoError(t, alertRepo.Create(ctx, warning))
+	require.NoError(t, alertRepo.Create(ctx, alert))
+
+	// only the critical alert matches the policy
+	escalationService.AlertChanged(ctx, "triggered", warning)
+	escalationService.AlertChanged(ctx, "triggered", alert)
+	require.Len(t, escalationRepo.escalations, 1)
+
+	// first tier goes to whoever is on call, the override wins
+	processed, err := escalationService.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed)
+	require.Len(t, notifier.sent, 1)
+	assert.Equal(t, notification.EventAlertEscalated, notifier.sent[0].Event)
+	assert.Equal(t, []string{"bob@example.com"}, notifier.sent[0].Recipients)
+
+	escalation := escalationRepo.escalations[alert.ID]
+	assert.Equal(t, 1, escalation.NextTier)
+	assert.Equal(t, escalation.StartedAt.Add(15*time.Minute), escalation.NextAt)
+
+	// nothing more until the next tier is due
+	processed, err = escalationService.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, processed)
+
+	escalation.NextAt = time.Now()
+	_, err = escalationService.ProcessDue(ctx)
+	require.NoError(t, err)
+	require.Len(t, notifier.sent, 2)
+	assert.Equal(t, []string{"carol@example.com"}, notifier.sent[1].Recipients)
+	assert.Equal(t, model.EscalationStatusCompleted, escalationRepo.escalations[alert.ID].Status)
+
+	// acknowledging stops an escalation before its next tier
+	acked := &model.Alert{DeviceID: uuid.New(), Severity: model.AlertSeverityCritical, Status: model.AlertStatusOpen}
+	require.NoError(t, alertRepo.Create(ctx, acked))
+	escalationService.AlertChanged(ctx, "triggered", acked)
+	acked.Status = model.AlertStatusAcknowledged
+	escalationService.AlertChanged(ctx, "acknowledged", acked)
+	_, err = escalationService.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Len(t, notifier.sent, 2)
+	assert.Equal(t, model.EscalationStatusCancelled, escalationRepo.escalations[acked.ID].Status)
+}
+
+func TestReopenedAlertEscalatesAgain(t *testing.T) {
+	limit := 40.0
+	thresholds, err := json.Marshal(model.AlertThresholds{
+		"sensor-temp-001": {"1": {Condition: model.ThresholdAbove, Value: &limit}},
+	})
+	require.NoError(t, err)
+	device := &model.Device{ID: uuid.New()}
+	device.TelemetryConfig.AlertThresholds = thresholds
+
+	carol := uuid.New()
+	users := &fakeUserRepo{users: map[uuid.UUID]*model.User{carol: {ID: carol, Email: "carol@example.com"}}}
+	tiers, err := json.Marshal([]model.EscalationTier{
+		{AfterMinutes: 0, UserIDs: []uuid.UUID{carol}},
+		{AfterMinutes: 15, UserIDs: []uuid.UUID{carol}},
+	})
+	require.NoError(t, err)
+	policy := &model.EscalationPolicy{ID: uuid.New(), Priority: 10, Tiers: tiers, Enabled: true}
+
+	escalationRepo := &fakeEscalationRepo{policies: []*model.EscalationPolicy{policy}, escalations: map[uuid.UUID]*model.AlertEscalation{}}
+	alertRepo := &fakeAlertRepo{}
+	notifier := &fakeNotifier{}
+	deviceRepo := &fakeDeviceRepo{device: device}
+	escalationService := service.NewEscalationService(escalationRepo, &fakeOnCallRepo{}, alertRepo, deviceRepo, users, notifier, zap.NewNop())
+	cfg := &config.TelemetryConfig{AlertFlapWindow: 10 * time.Minute, AlertFlapThreshold: 3}
+	alertService := service.NewAlertService(alertRepo, deviceRepo, &fakePublisher{}, nil, cfg, zap.NewNop())
+	alertService.AddListener(escalationService)
+
+	ctx := context.Background()
+	start, sensorID := time.Now(), uuid.New()
+	observe := func(offset time.Duration, value float64) {
+		alertService.ObserveTelemetry(ctx, &service.TelemetryReading{
+			DeviceID:   device.ID,
+			SensorID:   sensorID,
+			SensorCode: "sensor-temp-001",
+			Timestamp:  start.Add(offset),
+			Data:       map[string]interface{}{"1": value},
+		})
+	}
+
+	// triggered and escalated, then auto resolved which cancels the escalation
+	observe(0, 41)
+	require.Len(t, alertRepo.alerts, 1)
+	alert := alertRepo.alerts[0]
+	processed, err := escalationService.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed)
+	observe(time.Minute, 39)
+	assert.Equal(t, model.AlertStatusAutoResolved, alert.Status)
+	assert.Equal(t, model.EscalationStatusCancelled, escalationRepo.escalations[alert.ID].Status)
+
+	// reopened under the same id, the escalation starts over and is due again
+	observe(2*time.Minute, 41)
+	require.Len(t, alertRepo.alerts, 1)
+	assert.Equal(t, model.AlertStatusOpen, alert.Status)
+	escalation := escalationRepo.escalations[alert.ID]
+	assert.Equal(t, model.EscalationStatusPending, escalation.Status)
+	assert.Equal(t, 0, escalation.NextTier)
+	processed, err = escalationService.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed)
+	require.Len(t, notifier.sent, 2)
+	assert.Equal(t, []string{"carol@example.com"}, notifier.sent[1].Recipients)
+}
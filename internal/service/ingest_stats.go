@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/cache"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// IngestStatsService keeps per device and sensor ingestion counters. Counting happens in redis on every message,
+// Rollup periodically moves the counts to postgres so they survive a redis flush. The statistics of a device are
+// the postgres totals plus what redis counted since the last rollup.
+type IngestStatsService struct {
+	store      cache.IngestStatsStore
+	statsRepo  repository.IngestStatsRepository
+	deviceRepo repository.DeviceRepository
+	l          *zap.Logger
+}
+
+func NewIngestStatsService(store cache.IngestStatsStore, statsRepo repository.IngestStatsRepository, deviceRepo repository.DeviceRepository, baseLogger *zap.Logger) *IngestStatsService {
+	return &IngestStatsService{
+		store:      store,
+		statsRepo:  statsRepo,
+		deviceRepo: deviceRepo,
+		l:          logger.Named(baseLogger, "IngestStatsService"),
+	}
+}
+
+// Record counts one received message, it's best effort so a redis outage never blocks ingestion.
+func (s *IngestStatsService) Record(ctx context.Context, event cache.IngestEvent) {
+	if err := s.store.Record(ctx, event); err != nil {
+		s.l.Warn("failed to record ingest statistics", zap.String("device_id", event.DeviceID.String()), zap.Error(err))
+	}
+}
+
+func (s *IngestStatsService) GetDeviceStats(ctx context.Context, deviceID uuid.UUID) (*dto.DeviceIngestStatsDTO, error) {
+	if _, err := s.deviceRepo.GetByID(ctx, deviceID); err != nil {
+		return nil, apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to fetch device %s", deviceID))
+	}
+
+	stats := &dto.DeviceIngestStatsDTO{DeviceID: deviceID, Sensors: []dto.SensorIngestStatsDTO{}, Source: dto.IngestStatsSourceRollup}
+
+	rows, err := s.statsRepo.ListByDeviceID(ctx, deviceID)
+	if err != nil {
+		return nil, apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to fetch ingest statistics of device %s", deviceID))
+	}
+	merged := make(map[string]*cache.IngestCounters, len(rows))
+	for _, row := range rows {
+		if stats.RolledUpAt == nil || row.RolledUpAt.After(*stats.RolledUpAt) {
+			rolledUpAt := row.RolledUpAt
+			stats.RolledUpAt = &rolledUpAt
+		}
+		merged[row.SensorKey] = rollupAsCounters(row)
+	}
+
+	total, sensors, err := s.store.DeviceCounters(ctx, deviceID)
+	if err != nil {
+		s.l.Warn("failed to read live ingest statistics, falling back to rollup", zap.String("device_id", deviceID.String()), zap.Error(err))
+	} else {
+		stats.Source = dto.IngestStatsSourceLive
+		if total != nil {
+			sensors = append(sensors, total)
+		}
+		for _, counters := range sensors {
+			addCounters(merged, counters)
+		}
+	}
+
+	for sensorKey, counters := range merged {
+		if sensorKey == cache.DeviceTotalKey {
+			stats.Totals = countersAsDTO(counters)
+			continue
+		}
+		stats.Sensors = append(stats.Sensors, dto.SensorIngestStatsDTO{SensorID: sensorKey, IngestStatsDTO: countersAsDTO(counters)})
+	}
+	sortSensorStats(stats.Sensors)
+	return stats, nil
+}
+
+// Rollup moves the live counters of every device to postgres and returns the number of devices rolled up. The
+// counters are drained from redis first, counts a failed write couldn't store are given back for the next rollup.
+func (s *IngestStatsService) Rollup(ctx context.Context) (int, error) {
+	deviceIDs, err := s.store.Devices(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rolledUp := 0
+	for _, deviceID := range deviceIDs {
+		total, sensors, err := s.store.Drain(ctx, deviceID)
+		if err != nil {
+			return rolledUp, err
+		}
+		var drained []*cache.IngestCounters
+		if total != nil {
+			drained = append(drained, total)
+		}
+		drained = append(drained, sensors...)
+
+		rows := make([]*model.DeviceIngestStats, 0, len(drained))
+		for _, counters := range drained {
+			if counters.Received > 0 {
+				rows = append(rows, countersAsModel(deviceID, counters))
+			}
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		if err := s.statsRepo.Upsert(ctx, rows); err != nil {
+			if restoreErr := s.store.Restore(ctx, deviceID, drained); restoreErr != nil {
+				s.l.Error("failed to restore drained ingest statistics, they are lost", zap.String("device_id", deviceID.String()), zap.Error(restoreErr))
+			}
+			return rolledUp, apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, fmt.Sprintf("failed to roll up ingest statistics of device %s", deviceID))
+		}
+		rolledUp++
+	}
+	return rolledUp, nil
+}
+
+// addCounters adds live counters to the rolled up ones of the same sensor.
+func addCounters(merged map[string]*cache.IngestCounters, live *cache.IngestCounters) {
+	counters, ok := merged[live.SensorKey]
+	if !ok {
+		counters = &cache.IngestCounters{SensorKey: live.SensorKey, Rejected: map[string]int64{}}
+		merged[live.SensorKey] = counters
+	}
+	counters.Received += live.Received
+	counters.Accepted += live.Accepted
+	counters.Bytes += live.Bytes
+	counters.InterArrivalSumMs += live.InterArrivalSumMs
+	counters.InterArrivalCount += live.InterArrivalCount
+	for reason, count := range live.Rejected {
+		counters.Rejected[reason] += count
+	}
+	if !live.FirstMessageAt.IsZero() && (counters.FirstMessageAt.IsZero() || live.FirstMessageAt.Before(counters.FirstMessageAt)) {
+		counters.FirstMessageAt = live.FirstMessageAt
+	}
+	if live.LastMessageAt.After(counters.LastMessageAt) {
+		counters.LastMessageAt = live.LastMessageAt
+	}
+}
+
+func averageInterArrival(counters *cache.IngestCounters) float64 {
+	if counters.InterArrivalCount == 0 {
+		return 0
+	}
+	return float64(counters.InterArrivalSumMs) / float64(counters.InterArrivalCount)
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func countersAsDTO(counters *cache.IngestCounters) dto.IngestStatsDTO {
+	stats := dto.IngestStatsDTO{
+		Received:          counters.Received,
+		Accepted:          counters.Accepted,
+		Rejected:          counters.Rejected,
+		Bytes:             counters.Bytes,
+		FirstMessageAt:    optionalTime(counters.FirstMessageAt),
+		LastMessageAt:     optionalTime(counters.LastMessageAt),
+		AvgInterArrivalMs: averageInterArrival(counters),
+	}
+	for _, count := range counters.Rejected {
+		stats.RejectedTotal += count
+	}
+	return stats
+}
+
+func countersAsModel(deviceID uuid.UUID, counters *cache.IngestCounters) *model.DeviceIngestStats {
+	rejected, _ := json.Marshal(counters.Rejected)
+	return &model.DeviceIngestStats{
+		DeviceID:          deviceID,
+		SensorKey:         counters.SensorKey,
+		Received:          counters.Received,
+		Accepted:          counters.Accepted,
+		Rejected:          rejected,
+		Bytes:             counters.Bytes,
+		FirstMessageAt:    optionalTime(counters.FirstMessageAt),
+		LastMessageAt:     optionalTime(counters.LastMessageAt),
+		AvgInterArrivalMs: averageInterArrival(counters),
+		InterArrivalSumMs: counters.InterArrivalSumMs,
+		InterArrivalCount: counters.InterArrivalCount,
+	}
+}
+
+func rollupAsCounters(row *model.DeviceIngestStats) *cache.IngestCounters {
+	counters := &cache.IngestCounters{
+		SensorKey:         row.SensorKey,
+		Received:          row.Received,
+		Accepted:          row.Accepted,
+		Rejected:          map[string]int64{},
+		Bytes:             row.Bytes,
+		InterArrivalSumMs: row.InterArrivalSumMs,
+		InterArrivalCount: row.InterArrivalCount,
+	}
+	if len(row.Rejected) > 0 {
+		_ = json.Unmarshal(row.Rejected, &counters.Rejected)
+	}
+	if row.FirstMessageAt != nil {
+		counters.FirstMessageAt = *row.FirstMessageAt
+	}
+	if row.LastMessageAt != nil {
+		counters.LastMessageAt = *row.LastMessageAt
+	}
+	return counters
+}
+
+func sortSensorStats(sensors []dto.SensorIngestStatsDTO) {
+	sort.Slice(sensors, func(i, j int) bool { return sensors[i].SensorID < sensors[j].SensorID })
+}
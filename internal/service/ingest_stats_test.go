@@ -0,0 +1,178 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/cache"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+)
+
+// memoryIngestStore counts like the redis store, flush drops everything as a redis restart would.
+type memoryIngestStore struct {
+	counters map[uuid.UUID]map[string]*cache.IngestCounters
+}
+
+func newMemoryIngestStore() *memoryIngestStore {
+	return &memoryIngestStore{counters: map[uuid.UUID]map[string]*cache.IngestCounters{}}
+}
+
+func (s *memoryIngestStore) flush() {
+	s.counters = map[uuid.UUID]map[string]*cache.IngestCounters{}
+}
+
+func (s *memoryIngestStore) add(deviceID uuid.UUID, c *cache.IngestCounters) {
+	if s.counters[deviceID] == nil {
+		s.counters[deviceID] = map[string]*cache.IngestCounters{}
+	}
+	counters, ok := s.counters[deviceID][c.SensorKey]
+	if !ok {
+		counters = &cache.IngestCounters{SensorKey: c.SensorKey, Rejected: map[string]int64{}}
+		s.counters[deviceID][c.SensorKey] = counters
+	}
+	counters.Received += c.Received
+	counters.Accepted += c.Accepted
+	counters.Bytes += c.Bytes
+	for reason, count := range c.Rejected {
+		counters.Rejected[reason] += count
+	}
+}
+
+func (s *memoryIngestStore) Record(ctx context.Context, event cache.IngestEvent) error {
+	for _, sensorKey := range []string{event.SensorKey, cache.DeviceTotalKey} {
+		c := &cache.IngestCounters{SensorKey: sensorKey, Received: 1, Bytes: int64(event.Bytes), Rejected: map[string]int64{}}
+		if event.Accepted {
+			c.Accepted = 1
+		} else {
+			c.Rejected[event.Reason] = 1
+		}
+		s.add(event.DeviceID, c)
+	}
+	return nil
+}
+
+func (s *memoryIngestStore) DeviceCounters(ctx context.Context, deviceID uuid.UUID) (*cache.IngestCounters, []*cache.IngestCounters, error) {
+	var sensors []*cache.IngestCounters
+	for sensorKey, counters := range s.counters[deviceID] {
+		if sensorKey != cache.DeviceTotalKey {
+			sensors = append(sensors, counters)
+		}
+	}
+	return s.counters[deviceID][cache.DeviceTotalKey], sensors, nil
+}
+
+func (s *memoryIngestStore) Devices(ctx context.Context) ([]uuid.UUID, error) {
+	var deviceIDs []uuid.UUID
+	for deviceID := range s.counters {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	return deviceIDs, nil
+}
+
+func (s *memoryIngestStore) Drain(ctx context.Context, deviceID uuid.UUID) (*cache.IngestCounters, []*cache.IngestCounters, error) {
+	total, sensors, _ := s.DeviceCounters(ctx, deviceID)
+	delete(s.counters, deviceID)
+	return total, sensors, nil
+}
+
+func (s *memoryIngestStore) Restore(ctx context.Context, deviceID uuid.UUID, counters []*cache.IngestCounters) error {
+	for _, c := range counters {
+		s.add(deviceID, c)
+	}
+	return nil
+}
+
+// memoryIngestStatsRepo adds the rolled up counts like the postgres upsert.
+type memoryIngestStatsRepo struct {
+	repository.IngestStatsRepository
+	rows map[string]*model.DeviceIngestStats
+	fail bool
+}
+
+func (r *memoryIngestStatsRepo) Upsert(ctx context.Context, stats []*model.DeviceIngestStats) error {
+	if r.fail {
+		return errors.New("database is down")
+	}
+	for _, row := range stats {
+		key := row.DeviceID.String() + "/" + row.SensorKey
+		stored, ok := r.rows[key]
+		if !ok {
+			r.rows[key] = row
+			continue
+		}
+		stored.Received += row.Received
+		stored.Accepted += row.Accepted
+		stored.Bytes += row.Bytes
+		stored.Rejected = row.Rejected // a single reason in the test
+	}
+	return nil
+}
+
+func (r *memoryIngestStatsRepo) ListByDeviceID(ctx context.Context, deviceID uuid.UUID) ([]*model.DeviceIngestStats, error) {
+	var rows []*model.DeviceIngestStats
+	for _, row := range r.rows {
+		if row.DeviceID == deviceID {
+			copied := *row
+			copied.Rejected = datatypes.JSON(append([]byte(nil), row.Rejected...))
+			rows = append(rows, &copied)
+		}
+	}
+	return rows, nil
+}
+
+func TestIngestStatsSurviveRedisFlush(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryIngestStore()
+	statsRepo := &memoryIngestStatsRepo{rows: map[string]*model.DeviceIngestStats{}}
+	devices := newMemoryDeviceRepo()
+	device, err := devices.Create(ctx, &model.Device{Name: "boiler", MACAddress: "00:1a:2b:3c:4d:5e"})
+	require.NoError(t, err)
+	stats := service.NewIngestStatsService(store, statsRepo, devices, zap.NewNop())
+
+	sensorKey := uuid.NewString()
+	record := func(n int) {
+		for i := 0; i < n; i++ {
+			stats.Record(ctx, cache.IngestEvent{DeviceID: device.ID, SensorKey: sensorKey, Bytes: 10, Accepted: true, ReceivedAt: time.Now()})
+		}
+	}
+	received := func() int64 {
+		deviceStats, err := stats.GetDeviceStats(ctx, device.ID)
+		require.NoError(t, err)
+		assert.Equal(t, dto.IngestStatsSourceLive, deviceStats.Source)
+		require.Len(t, deviceStats.Sensors, 1)
+		assert.Equal(t, deviceStats.Totals.Received, deviceStats.Sensors[0].Received)
+		return deviceStats.Totals.Received
+	}
+
+	record(3)
+	rolledUp, err := stats.Rollup(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rolledUp)
+	assert.Equal(t, int64(3), received(), "rolled up counts are still reported")
+
+	store.flush()
+	record(2)
+	assert.Equal(t, int64(5), received(), "a flush loses nothing that was rolled up")
+
+	statsRepo.fail = true
+	_, err = stats.Rollup(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, int64(5), received(), "a failed rollup gives the counts back")
+
+	statsRepo.fail = false
+	_, err = stats.Rollup(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), received())
+	assert.Equal(t, int64(5), statsRepo.rows[device.ID.String()+"/"+cache.DeviceTotalKey].Received)
+	assert.Equal(t, int64(50), statsRepo.rows[device.ID.String()+"/"+cache.DeviceTotalKey].Bytes)
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/cache"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// MaintenanceChecker tells whether a device was under a maintenance window at a point in time. Alerting and
+// data quality checks use it to ignore what happens during a service visit.
+type MaintenanceChecker interface {
+	UnderMaintenance(ctx context.Context, deviceID uuid.UUID, at time.Time) bool
+}
+
+const (
+	maintenanceWindowsRefresh = 30 * time.Second
+	maintenanceDeviceCacheTTL = time.Minute
+)
+
+type MaintenanceService struct {
+	maintenanceRepo repository.MaintenanceRepository
+	deviceRepo      repository.DeviceRepository
+	deviceStatus    *DeviceStatusService
+	deviceCache     *cache.TTLCache[uuid.UUID, *model.Device]
+
+	windowsMu       sync.RWMutex
+	windows         []*model.MaintenanceWindow // windows that aren't over
+	windowsLoadedAt time.Time
+
+	l *zap.Logger
+}
+
+func NewMaintenanceService(maintenanceRepo repository.MaintenanceRepository, deviceRepo repository.DeviceRepository, deviceStatus *DeviceStatusService, baseLogger *zap.Logger) *MaintenanceService {
+	return &MaintenanceService{
+		maintenanceRepo: maintenanceRepo,
+		deviceRepo:      deviceRepo,
+		deviceStatus:    deviceStatus,
+		deviceCache:     cache.NewTTLCache[uuid.UUID, *model.Device](maintenanceDeviceCacheTTL, 0),
+		l:               logger.Named(baseLogger, "MaintenanceService"),
+	}
+}
+
+func (s *MaintenanceService) CreateWindow(ctx context.Context, window *model.MaintenanceWindow) (*model.MaintenanceWindow, error) {
+	if window.Timezone == "" {
+		window.Timezone = "UTC"
+	}
+	if err := window.Validate(); err != nil {
+		return nil, apperror.ErrValidation.WithMessage(err.Error()).Wrap(err)
+	}
+	if err := s.maintenanceRepo.Create(ctx, window); err != nil {
+		return nil, err
+	}
+	s.invalidateWindows()
+	return window, nil
+}
+
+func (s *MaintenanceService) UpdateWindow(ctx context.Context, window *model.MaintenanceWindow) (*model.MaintenanceWindow, error) {
+	if window.Timezone == "" {
+		window.Timezone = "UTC"
+	}
+	if err := window.Validate(); err != nil {
+		return nil, apperror.ErrValidation.WithMessage(err.Error()).Wrap(err)
+	}
+	if err := s.maintenanceRepo.Update(ctx, window); err != nil {
+		return nil, err
+	}
+	s.invalidateWindows()
+	return window, nil
+}
+
+func (s *MaintenanceService) DeleteWindow(ctx context.Context, windowID uuid.UUID) error {
+	if err := s.maintenanceRepo.Delete(ctx, windowID); err != nil {
+		return err
+	}
+	s.invalidateWindows()
+	return nil
+}
+
+func (s *MaintenanceService) GetWindow(ctx context.Context, windowID uuid.UUID) (*model.MaintenanceWindow, error) {
+	return s.maintenanceRepo.GetByID(ctx, windowID)
+}
+
+func (s *MaintenanceService) ListWindows(ctx context.Context, deviceID *uuid.UUID) ([]*model.MaintenanceWindow, error) {
+	return s.maintenanceRepo.List(ctx, deviceID)
+}
+
+// UnderMaintenance implements MaintenanceChecker. A device is under maintenance while a window covers it or while
+// its status is under_maintenance, e.g. set by hand for an unplanned visit. Lookup failures count as not under
+// maintenance, a missed suppression is better than a missed alert.
+func (s *MaintenanceService) UnderMaintenance(ctx context.Context, deviceID uuid.UUID, at time.Time) bool {
+	device, err := s.device(ctx, deviceID)
+	if err != nil {
+		s.l.Error("failed to load device for maintenance check", zap.String("device_id", deviceID.String()), zap.Error(err))
+		return false
+	}
+	if device.Status == model.DeviceStatusUnderMaintenance {
+		return true
+	}
+
+	windows, err := s.currentWindows(ctx)
+	if err != nil {
+		s.l.Error("failed to load maintenance windows", zap.Error(err))
+		return false
+	}
+	for _, window := range windows {
+		if window.ActiveAt(at) && window.Covers(device) {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncDeviceStatus moves the devices of active windows with SetDeviceStatus to under_maintenance and restores the
+// previous status of the devices no window holds anymore.
+func (s *MaintenanceService) SyncDeviceStatus(ctx context.Context, now time.Time) (started int, ended int, err error) {
+	windows, err := s.maintenanceRepo.ListCurrent(ctx, now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	wanted := make(map[uuid.UUID]*model.DeviceMaintenance)
+	for _, window := range windows {
+		if !window.SetDeviceStatus || !window.ActiveAt(now) {
+			continue
+		}
+		devices, err := s.maintenanceRepo.DevicesInScope(ctx, window)
+		if err != nil {
+			return started, ended, err
+		}
+		for _, device := range devices {
+			if _, ok := wanted[device.ID]; ok {
+				continue
+			}
+			wanted[device.ID] = &model.DeviceMaintenance{
+				DeviceID:       device.ID,
+				WindowID:       window.ID,
+				PreviousStatus: device.Status,
+				StartedAt:      now,
+			}
+		}
+	}
+
+	held, err := s.maintenanceRepo.ListDeviceMaintenance(ctx)
+	if err != nil {
+		return started, ended, err
+	}
+	heldByDevice := make(map[uuid.UUID]bool, len(held))
+	for _, maintenance := range held {
+		heldByDevice[maintenance.DeviceID] = true
+		if _, ok := wanted[maintenance.DeviceID]; ok {
+			continue
+		}
+		change := maintenanceStatusChange(maintenance, maintenance.PreviousStatus, "maintenance window is over")
+		device, err := s.maintenanceRepo.EndDeviceMaintenance(ctx, maintenance, change)
+		if err != nil {
+			return started, ended, err
+		}
+		s.deviceStatus.Changed(ctx, device, change)
+		ended++
+	}
+
+	for deviceID, maintenance := range wanted {
+		if heldByDevice[deviceID] {
+			continue
+		}
+		if !model.CanTransitionDeviceStatus(maintenance.PreviousStatus, model.DeviceStatusUnderMaintenance) {
+			continue // already under maintenance by hand, retired or not provisioned yet, not ours to restore later
+		}
+		change := maintenanceStatusChange(maintenance, model.DeviceStatusUnderMaintenance, "maintenance window started")
+		device, err := s.maintenanceRepo.StartDeviceMaintenance(ctx, maintenance, change)
+		if err != nil {
+			return started, ended, err
+		}
+		s.deviceStatus.Changed(ctx, device, change)
+		started++
+	}
+	return started, ended, nil
+}
+
+func maintenanceStatusChange(maintenance *model.DeviceMaintenance, to model.DeviceStatus, reason string) *model.DeviceStatusChange {
+	return &model.DeviceStatusChange{
+		DeviceID:  maintenance.DeviceID,
+		ToStatus:  to,
+		Reason:    fmt.Sprintf("%s (window %s)", reason, maintenance.WindowID),
+		ActorType: model.DeviceStatusActorSystem,
+		Source:    "maintenance",
+	}
+}
+
+func (s *MaintenanceService) currentWindows(ctx context.Context) ([]*model.MaintenanceWindow, error) {
+	s.windowsMu.RLock()
+	windows, loadedAt := s.windows, s.windowsLoadedAt
+	s.windowsMu.RUnlock()
+	if !loadedAt.IsZero() && time.Since(loadedAt) < maintenanceWindowsRefresh {
+		return windows, nil
+	}
+
+	now := time.Now()
+	windows, err := s.maintenanceRepo.ListCurrent(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	s.windowsMu.Lock()
+	s.windows, s.windowsLoadedAt = windows, now
+	s.windowsMu.Unlock()
+	return windows, nil
+}
+
+func (s *MaintenanceService) invalidateWindows() {
+	s.windowsMu.Lock()
+	s.windowsLoadedAt = time.Time{}
+	s.windowsMu.Unlock()
+}
+
+func (s *MaintenanceService) device(ctx context.Context, deviceID uuid.UUID) (*model.Device, error) {
+	if device, ok := s.deviceCache.Get(deviceID); ok {
+		return device, nil
+	}
+	device, err := s.deviceRepo.GetByID(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	s.deviceCache.Set(deviceID, device)
+	return device, nil
+}
@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"go.uber.org/zap"
+)
+
+type fakeMaintenanceRepo struct {
+	repository.MaintenanceRepository
+	windows []*model.MaintenanceWindow
+}
+
+func (r *fakeMaintenanceRepo) ListCurrent(ctx context.Context, now time.Time) ([]*model.MaintenanceWindow, error) {
+	return r.windows, nil
+}
+
+func TestMaintenanceWindowSuppressesAlerts(t *testing.T) {
+	limit := 40.0
+	thresholds, err := json.Marshal(model.AlertThresholds{
+		"sensor-temp-001": {"1": {Condition: model.ThresholdAbove, Value: &limit}},
+	})
+	require.NoError(t, err)
+
+	deviceID, sensorID := uuid.New(), uuid.New()
+	device := &model.Device{ID: deviceID, Tags: pq.StringArray{"pump-station"}}
+	device.TelemetryConfig.AlertThresholds = thresholds
+	deviceRepo := &fakeDeviceRepo{device: device}
+
+	// weekly two hour service visit, the first one started an hour ago
+	start := time.Now().Add(-time.Hour)
+	window := &model.MaintenanceWindow{
+		Scope:      model.MaintenanceScopeTag,
+		Tag:        "pump-station",
+		StartsAt:   start,
+		EndsAt:     start.Add(2 * time.Hour),
+		Recurrence: model.MaintenanceRecurrenceWeekly,
+	}
+	require.NoError(t, window.Validate())
+	maintenanceService := service.NewMaintenanceService(&fakeMaintenanceRepo{windows: []*model.MaintenanceWindow{window}}, deviceRepo, nil, zap.NewNop())
+
+	ctx := context.Background()
+	assert.True(t, maintenanceService.UnderMaintenance(ctx, deviceID, time.Now()))
+	assert.False(t, maintenanceService.UnderMaintenance(ctx, deviceID, start.Add(3*time.Hour)))
+	assert.True(t, maintenanceService.UnderMaintenance(ctx, deviceID, start.Add(7*24*time.Hour+time.Minute)), "next week's visit")
+	assert.False(t, maintenanceService.UnderMaintenance(ctx, deviceID, start.Add(-time.Minute)))
+
+	alertRepo := &fakeAlertRepo{}
+	alertService := service.NewAlertService(alertRepo, deviceRepo, &fakePublisher{}, nil, nil, zap.NewNop())
+	observe := func(at time.Time, value float64) {
+		alertService.ObserveTelemetry(ctx, &service.TelemetryReading{
+			DeviceID:    deviceID,
+			SensorID:    sensorID,
+			SensorCode:  "sensor-temp-001",
+			Timestamp:   at,
+			Data:        map[string]interface{}{"1": value},
+			Maintenance: maintenanceService.UnderMaintenance(ctx, deviceID, at),
+		})
+	}
+
+	// the technician's test readings don't trigger anything
+	observe(start.Add(10*time.Minute), 90)
+	observe(start.Add(20*time.Minute), 95)
+	assert.Empty(t, alertRepo.alerts)
+
+	// a breach after the visit does
+	observe(start.Add(3*time.Hour), 90)
+	require.Len(t, alertRepo.alerts, 1)
+	assert.Equal(t, start.Add(3*time.Hour), alertRepo.alerts[0].TriggeredAt)
+}
+
+func TestRecurringMaintenanceKeepsItsWallClockTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	deviceID := uuid.New()
+	deviceRepo := &fakeDeviceRepo{device: &model.Device{ID: deviceID}}
+	visit := func(recurrence model.MaintenanceRecurrence, start time.Time) *service.MaintenanceService {
+		window := &model.MaintenanceWindow{
+			Scope:      model.MaintenanceScopeDevice,
+			DeviceID:   &deviceID,
+			StartsAt:   start,
+			EndsAt:     start.Add(2 * time.Hour),
+			Recurrence: recurrence,
+			Timezone:   "Europe/Berlin",
+		}
+		require.NoError(t, window.Validate())
+		return service.NewMaintenanceService(&fakeMaintenanceRepo{windows: []*model.MaintenanceWindow{window}}, deviceRepo, nil, zap.NewNop())
+	}
+	ctx := context.Background()
+
+	// daily at 08:00 in Berlin, starting before the clocks go forward on March 31st
+	daily := visit(model.MaintenanceRecurrenceDaily, time.Date(2024, 3, 29, 8, 0, 0, 0, berlin))
+	assert.True(t, daily.UnderMaintenance(ctx, deviceID, time.Date(2024, 3, 30, 9, 30, 0, 0, berlin)))
+	assert.True(t, daily.UnderMaintenance(ctx, deviceID, time.Date(2024, 4, 1, 8, 30, 0, 0, berlin)), "still at 08:00 in summer time")
+	assert.False(t, daily.UnderMaintenance(ctx, deviceID, time.Date(2024, 4, 1, 7, 30, 0, 0, berlin)))
+	assert.False(t, daily.UnderMaintenance(ctx, deviceID, time.Date(2024, 4, 1, 10, 30, 0, 0, berlin)))
+
+	// weekly on monday at 08:00, the clocks go back on October 27th
+	weekly := visit(model.MaintenanceRecurrenceWeekly, time.Date(2024, 10, 21, 8, 0, 0, 0, berlin))
+	assert.True(t, weekly.UnderMaintenance(ctx, deviceID, time.Date(2024, 10, 28, 8, 30, 0, 0, berlin)), "still at 08:00 in winter time")
+	assert.False(t, weekly.UnderMaintenance(ctx, deviceID, time.Date(2024, 10, 28, 10, 30, 0, 0, berlin)))
+	assert.False(t, weekly.UnderMaintenance(ctx, deviceID, time.Date(2024, 10, 29, 8, 30, 0, 0, berlin)))
+}
+
+func TestDeviceStatusUnderMaintenanceSuppressesAlerts(t *testing.T) {
+	deviceID := uuid.New()
+	deviceRepo := &fakeDeviceRepo{device: &model.Device{ID: deviceID, Status: model.DeviceStatusUnderMaintenance}}
+	maintenanceService := service.NewMaintenanceService(&fakeMaintenanceRepo{}, deviceRepo, nil, zap.NewNop())
+
+	// no window is scheduled, the status was set by hand
+	assert.True(t, maintenanceService.UnderMaintenance(context.Background(), deviceID, time.Now()))
+
+	onlineID := uuid.New()
+	onlineService := service.NewMaintenanceService(&fakeMaintenanceRepo{}, &fakeDeviceRepo{device: &model.Device{ID: onlineID, Status: model.DeviceStatusOnline}}, nil, zap.NewNop())
+	assert.False(t, onlineService.UnderMaintenance(context.Background(), onlineID, time.Now()))
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/notification"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultNotificationBatchSize   = 50
+	defaultNotificationMaxAttempts = 6
+	defaultNotificationBackoff     = 30 * time.Second
+	defaultNotificationMaxBackoff  = 30 * time.Minute
+	defaultNotificationRoute       = "default"
+
+	notificationClaimLease = 5 * time.Minute // a claimed delivery is sent again after this when its dispatcher died
+)
+
+// Notifier raises notifications, NotificationService implements it. Services take the interface so they keep
+// working without notifications configured (nil notifier).
+type Notifier interface {
+	Notify(ctx context.Context, n *notification.Notification) error
+}
+
+// NotificationService persists a delivery per routed channel and recipient, then sends them from the dispatch
+// worker with exponential backoff between attempts. Every attempt is logged.
+type NotificationService struct {
+	repo         repository.NotificationRepository
+	channels     map[string]notification.Channel
+	routes       map[string][]string
+	batchSize    int
+	maxAttempts  int
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
+	wake         chan struct{}
+	l            *zap.Logger
+}
+
+func NewNotificationService(repo repository.NotificationRepository, channels []notification.Channel, cfg *config.NotificationConfig, baseLogger *zap.Logger) *NotificationService {
+	s := &NotificationService{
+		repo:         repo,
+		channels:     make(map[string]notification.Channel, len(channels)),
+		routes:       map[string][]string{},
+		batchSize:    defaultNotificationBatchSize,
+		maxAttempts:  defaultNotificationMaxAttempts,
+		retryBackoff: defaultNotificationBackoff,
+		maxBackoff:   defaultNotificationMaxBackoff,
+		wake:         make(chan struct{}, 1),
+		l:            logger.Named(baseLogger, "NotificationService"),
+	}
+	for _, channel := range channels {
+		s.channels[channel.Name()] = channel
+	}
+	if cfg != nil {
+		if cfg.Routes != nil {
+			s.routes = cfg.Routes
+		}
+		if cfg.BatchSize > 0 {
+			s.batchSize = cfg.BatchSize
+		}
+		if cfg.MaxAttempts > 0 {
+			s.maxAttempts = cfg.MaxAttempts
+		}
+		if cfg.RetryBackoff > 0 {
+			s.retryBackoff = cfg.RetryBackoff
+		}
+		if cfg.MaxRetryBackoff > 0 {
+			s.maxBackoff = cfg.MaxRetryBackoff
+		}
+	}
+	return s
+}
+
+// Notify persists the deliveries of the notification and wakes the dispatcher, sending happens in the background.
+func (s *NotificationService) Notify(ctx context.Context, n *notification.Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return apperror.ErrInternal.WithMessage("failed to encode notification").Wrap(err)
+	}
+
+	now := time.Now()
+	var deliveries []*model.NotificationDelivery
+	for _, channelName := range s.route(n.Event) {
+		channel, ok := s.channels[channelName]
+		if !ok {
+			continue // routed but not enabled
+		}
+		for _, recipient := range channel.Recipients(n) {
+			deliveries = append(deliveries, &model.NotificationDelivery{
+				Channel:       channel.Name(),
+				Event:         n.Event,
+				Recipient:     recipient,
+				Subject:       n.Subject,
+				Payload:       payload,
+				Status:        model.NotificationStatusPending,
+				MaxAttempts:   s.maxAttempts,
+				NextAttemptAt: now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		s.l.Debug("notification has no deliveries", zap.String("event", n.Event))
+		return nil
+	}
+
+	if err := s.repo.CreateDeliveries(ctx, deliveries); err != nil {
+		return err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default: // a dispatch is already pending
+	}
+	return nil
+}
+
+// Wake is signalled when new deliveries are waiting, the dispatch worker listens on it besides its ticker.
+func (s *NotificationService) Wake() <-chan struct{} {
+	return s.wake
+}
+
+// DispatchDue sends the deliveries that are due and returns how many were attempted. Every instance dispatches,
+// a claimed delivery is hidden from the others so it goes out once.
+func (s *NotificationService) DispatchDue(ctx context.Context) (int, error) {
+	deliveries, err := s.repo.ClaimDue(ctx, time.Now(), notificationClaimLease, s.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		s.deliver(ctx, delivery)
+	}
+	return len(deliveries), nil
+}
+
+func (s *NotificationService) deliver(ctx context.Context, delivery *model.NotificationDelivery) {
+	log := s.l.With(zap.String("delivery_id", delivery.ID.String()), zap.String("channel", delivery.Channel), zap.String("event", delivery.Event))
+
+	start := time.Now()
+	sendErr := s.send(ctx, delivery)
+	if ctx.Err() != nil {
+		return // shutting down, the delivery is due again once the claim lease ran out
+	}
+
+	delivery.AttemptCount++
+	now := time.Now()
+	attempt := &model.NotificationAttempt{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.AttemptCount,
+		Success:    sendErr == nil,
+		DurationMs: now.Sub(start).Milliseconds(),
+	}
+
+	switch {
+	case sendErr == nil:
+		delivery.Status = model.NotificationStatusSent
+		delivery.SentAt = &now
+		delivery.LastError = ""
+	case notification.IsPermanent(sendErr) || delivery.AttemptCount >= delivery.MaxAttempts:
+		delivery.Status = model.NotificationStatusFailed
+		delivery.LastError = sendErr.Error()
+		attempt.Error = sendErr.Error()
+		log.Error("notification delivery failed", zap.Int("attempts", delivery.AttemptCount), zap.Error(sendErr))
+	default:
+		delivery.NextAttemptAt = now.Add(s.backoff(delivery.AttemptCount))
+		delivery.LastError = sendErr.Error()
+		attempt.Error = sendErr.Error()
+		log.Warn("notification delivery will be retried", zap.Int("attempts", delivery.AttemptCount), zap.Time("next_attempt_at", delivery.NextAttemptAt), zap.Error(sendErr))
+	}
+
+	if err := s.repo.AddAttempt(ctx, attempt); err != nil {
+		log.Error("failed to log notification attempt", zap.Error(err))
+	}
+	if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+		log.Error("failed to save notification delivery", zap.Error(err))
+	}
+}
+
+func (s *NotificationService) send(ctx context.Context, delivery *model.NotificationDelivery) error {
+	channel, ok := s.channels[delivery.Channel]
+	if !ok {
+		return notification.Permanent(apperror.ErrMissingConfig.WithMessagef("notification channel %s is not enabled", delivery.Channel))
+	}
+
+	// templates and webhooks see the notification data after a json round trip, the same way on every attempt
+	var n notification.Notification
+	if err := json.Unmarshal(delivery.Payload, &n); err != nil {
+		return notification.Permanent(err)
+	}
+	return channel.Send(ctx, &notification.Message{
+		DeliveryID: delivery.ID,
+		Event:      delivery.Event,
+		Recipient:  delivery.Recipient,
+		Subject:    delivery.Subject,
+		Data:       n.Data,
+		CreatedAt:  delivery.CreatedAt,
+	})
+}
+
+// backoff doubles the retry delay on every failed attempt up to the configured maximum.
+func (s *NotificationService) backoff(attempt int) time.Duration {
+	delay := s.retryBackoff
+	for i := 1; i < attempt && delay < s.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.maxBackoff {
+		delay = s.maxBackoff
+	}
+	return delay
+}
+
+func (s *NotificationService) route(event string) []string {
+	if channels, ok := s.routes[event]; ok {
+		return channels
+	}
+	return s.routes[defaultNotificationRoute]
+}
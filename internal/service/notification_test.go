@@ -0,0 +1,132 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/notification"
+	"github.com/vars7899/iots/internal/service"
+	"go.uber.org/zap"
+)
+
+type fakeNotificationRepo struct {
+	deliveries []*model.NotificationDelivery
+	attempts   []*model.NotificationAttempt
+}
+
+func (r *fakeNotificationRepo) CreateDeliveries(ctx context.Context, deliveries []*model.NotificationDelivery) error {
+	for _, delivery := range deliveries {
+		delivery.ID = uuid.New()
+		delivery.CreatedAt = time.Now()
+	}
+	r.deliveries = append(r.deliveries, deliveries...)
+	return nil
+}
+
+func (r *fakeNotificationRepo) UpdateDelivery(ctx context.Context, delivery *model.NotificationDelivery) error {
+	return nil
+}
+
+func (r *fakeNotificationRepo) AddAttempt(ctx context.Context, attempt *model.NotificationAttempt) error {
+	r.attempts = append(r.attempts, attempt)
+	return nil
+}
+
+func (r *fakeNotificationRepo) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.NotificationDelivery, error) {
+	var due []*model.NotificationDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.Status == model.NotificationStatusPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	return due, nil
+}
+
+type fakeChannel struct {
+	name       string
+	recipients []string
+	errs       []error // returned in order, nil once exhausted
+	sent       []*notification.Message
+}
+
+func (c *fakeChannel) Name() string { return c.name }
+
+func (c *fakeChannel) Recipients(n *notification.Notification) []string {
+	if len(n.Recipients) > 0 {
+		return n.Recipients
+	}
+	return c.recipients
+}
+
+func (c *fakeChannel) Send(ctx context.Context, message *notification.Message) error {
+	c.sent = append(c.sent, message)
+	if len(c.errs) == 0 {
+		return nil
+	}
+	err := c.errs[0]
+	c.errs = c.errs[1:]
+	return err
+}
+
+func TestNotificationRetryAndPermanentFailure(t *testing.T) {
+	repo := &fakeNotificationRepo{}
+	webhook := &fakeChannel{name: notification.ChannelWebhook, recipients: []string{"https://hooks.example.com"}, errs: []error{errors.New("503")}}
+	email := &fakeChannel{name: notification.ChannelEmail, errs: []error{notification.Permanent(errors.New("mailbox unavailable"))}}
+
+	notificationService := service.NewNotificationService(repo, []notification.Channel{webhook, email}, &config.NotificationConfig{
+		MaxAttempts:     3,
+		RetryBackoff:    time.Minute,
+		MaxRetryBackoff: time.Hour,
+		Routes: map[string][]string{
+			notification.EventPasswordReset: {notification.ChannelEmail},
+			"default":                       {notification.ChannelWebhook, notification.ChannelEmail},
+		},
+	}, zap.NewNop())
+
+	// no default email recipients, only the webhook gets the alert
+	require.NoError(t, notificationService.Notify(context.Background(), &notification.Notification{
+		Event: notification.EventAlertTriggered,
+		Data:  map[string]interface{}{"alert": map[string]interface{}{"id": "a1"}},
+	}))
+	require.NoError(t, notificationService.Notify(context.Background(), &notification.Notification{
+		Event:      notification.EventPasswordReset,
+		Recipients: []string{"user@example.com"},
+		Data:       map[string]interface{}{"reset_link": "https://app/reset"},
+	}))
+	require.Len(t, repo.deliveries, 2)
+	hookDelivery, mailDelivery := repo.deliveries[0], repo.deliveries[1]
+	assert.Equal(t, notification.ChannelWebhook, hookDelivery.Channel)
+	assert.Equal(t, "user@example.com", mailDelivery.Recipient)
+
+	sent, err := notificationService.DispatchDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+
+	// transient error backs off, permanent error fails right away
+	assert.Equal(t, model.NotificationStatusPending, hookDelivery.Status)
+	assert.Equal(t, 1, hookDelivery.AttemptCount)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), hookDelivery.NextAttemptAt, 5*time.Second)
+	assert.Equal(t, model.NotificationStatusFailed, mailDelivery.Status)
+	assert.Equal(t, "https://app/reset", email.sent[0].Data["reset_link"])
+
+	// nothing is due until the backoff passed
+	sent, err = notificationService.DispatchDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	hookDelivery.NextAttemptAt = time.Now()
+	_, err = notificationService.DispatchDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, model.NotificationStatusSent, hookDelivery.Status)
+	assert.NotNil(t, hookDelivery.SentAt)
+	require.Len(t, repo.attempts, 3)
+	assert.False(t, repo.attempts[0].Success)
+	assert.True(t, repo.attempts[2].Success)
+}
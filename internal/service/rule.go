@@ -0,0 +1,419 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/notification"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/rules"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+// RuleEvent is published to rules.events when a rule fires or clears on a device.
+type RuleEvent struct {
+	Event     string             `json:"event"` // fired, cleared
+	RuleID    uuid.UUID          `json:"rule_id"`
+	RuleName  string             `json:"rule_name"`
+	DeviceID  uuid.UUID          `json:"device_id"`
+	Values    map[string]float64 `json:"values"` // condition id -> evaluated value
+	AlertID   *uuid.UUID         `json:"alert_id,omitempty"`
+	Timestamp time.Time          `json:"timestamp"`
+}
+
+type compiledRule struct {
+	rule        *model.Rule
+	def         *model.RuleDefinition
+	sensorCodes map[string]bool
+}
+
+type ruleStateKey struct {
+	ruleID   uuid.UUID
+	deviceID uuid.UUID
+}
+
+type ruleState struct {
+	mu      sync.Mutex
+	state   *rules.State
+	version time.Time // Rule.UpdatedAt the state belongs to
+	dirty   bool      // changed since the last checkpoint
+}
+
+// RuleService manages composite rules and evaluates them over the accepted readings. Window state lives in memory
+// and is checkpointed to postgres so restarts don't lose partially filled windows. Every replica evaluates, a rule
+// changed through another one applies from the next checkpoint, which reloads the rules first.
+type RuleService struct {
+	ruleRepo     repository.RuleRepository
+	stateRepo    repository.RuleStateRepository
+	alertService *AlertService
+	publisher    pubsub.PubSubPublisher
+	notifier     Notifier
+
+	rulesMu sync.RWMutex
+	rules   []*compiledRule
+	loaded  bool
+
+	statesMu sync.Mutex
+	states   map[ruleStateKey]*ruleState
+
+	l *zap.Logger
+}
+
+func NewRuleService(ruleRepo repository.RuleRepository, stateRepo repository.RuleStateRepository, alertService *AlertService, publisher pubsub.PubSubPublisher, notifier Notifier, baseLogger *zap.Logger) *RuleService {
+	return &RuleService{
+		ruleRepo:     ruleRepo,
+		stateRepo:    stateRepo,
+		alertService: alertService,
+		publisher:    publisher,
+		notifier:     notifier,
+		states:       make(map[ruleStateKey]*ruleState),
+		l:            logger.Named(baseLogger, "RuleService"),
+	}
+}
+
+func (s *RuleService) CreateRule(ctx context.Context, rule *model.Rule) (*model.Rule, error) {
+	if _, err := model.ParseRuleDefinition(rule.Definition); err != nil {
+		return nil, apperror.ErrValidation.WithMessage(err.Error()).Wrap(err)
+	}
+	if err := s.ruleRepo.Create(ctx, rule); err != nil {
+		return nil, err
+	}
+	s.reload(ctx)
+	return rule, nil
+}
+
+// UpdateRule saves the rule, a changed rule starts evaluating from empty windows.
+func (s *RuleService) UpdateRule(ctx context.Context, rule *model.Rule) (*model.Rule, error) {
+	if _, err := model.ParseRuleDefinition(rule.Definition); err != nil {
+		return nil, apperror.ErrValidation.WithMessage(err.Error()).Wrap(err)
+	}
+	if err := s.ruleRepo.Update(ctx, rule); err != nil {
+		return nil, err
+	}
+	s.dropStates(ctx, rule.ID)
+	s.reload(ctx)
+	return rule, nil
+}
+
+func (s *RuleService) DeleteRule(ctx context.Context, ruleID uuid.UUID) error {
+	if err := s.ruleRepo.Delete(ctx, ruleID); err != nil {
+		return err
+	}
+	s.dropStates(ctx, ruleID)
+	s.reload(ctx)
+	return nil
+}
+
+func (s *RuleService) GetRule(ctx context.Context, ruleID uuid.UUID) (*model.Rule, error) {
+	return s.ruleRepo.GetByID(ctx, ruleID)
+}
+
+func (s *RuleService) ListRules(ctx context.Context, deviceID *uuid.UUID) ([]*model.Rule, error) {
+	return s.ruleRepo.List(ctx, deviceID)
+}
+
+// ObserveTelemetry implements TelemetryObserver.
+func (s *RuleService) ObserveTelemetry(ctx context.Context, reading *TelemetryReading) {
+	if !s.ensureLoaded(ctx) {
+		return
+	}
+
+	s.rulesMu.RLock()
+	compiled := s.rules
+	s.rulesMu.RUnlock()
+
+	for _, cr := range compiled {
+		if cr.rule.DeviceID != nil && *cr.rule.DeviceID != reading.DeviceID {
+			continue
+		}
+		if !cr.sensorCodes[reading.SensorCode] {
+			continue
+		}
+		if err := s.evaluate(ctx, cr, reading); err != nil {
+			s.l.Error("failed to evaluate rule",
+				zap.String("rule_id", cr.rule.ID.String()),
+				zap.String("device_id", reading.DeviceID.String()),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func (s *RuleService) evaluate(ctx context.Context, cr *compiledRule, reading *TelemetryReading) error {
+	st, err := s.state(ctx, cr.rule, reading.DeviceID)
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	value := func(field string) (float64, bool) { return numericValue(reading.Data[field]) }
+	if !st.state.Observe(cr.def, reading.SensorCode, value, reading.Timestamp) {
+		return nil
+	}
+	st.dirty = true
+	if reading.Maintenance && !st.state.Active {
+		// windows keep filling but the rule can't fire while the device is under maintenance, matching starts over afterwards
+		st.state.MatchingSince = time.Time{}
+		return nil
+	}
+	eval := st.state.Evaluate(cr.def, reading.Timestamp)
+
+	switch eval.Transition {
+	case rules.TransitionFired:
+		return s.fire(ctx, cr, reading, eval)
+	case rules.TransitionCleared:
+		return s.clear(ctx, cr, reading, eval)
+	}
+	return nil
+}
+
+func (s *RuleService) fire(ctx context.Context, cr *compiledRule, reading *TelemetryReading, eval *rules.Evaluation) error {
+	event := &RuleEvent{
+		Event:     "fired",
+		RuleID:    cr.rule.ID,
+		RuleName:  cr.rule.Name,
+		DeviceID:  reading.DeviceID,
+		Values:    eval.Values,
+		Timestamp: reading.Timestamp,
+	}
+
+	if cr.def.Action.Type == model.RuleActionAlert {
+		alert, err := s.alertService.RaiseRuleAlert(ctx, cr.rule, cr.def.Action, reading, ruleMessage(cr, eval), reading.Timestamp)
+		if err != nil {
+			return err
+		}
+		event.AlertID = &alert.ID
+	}
+	s.publish(ctx, cr, event)
+	return nil
+}
+
+func (s *RuleService) clear(ctx context.Context, cr *compiledRule, reading *TelemetryReading, eval *rules.Evaluation) error {
+	if cr.def.Action.Type == model.RuleActionAlert {
+		if err := s.alertService.ResolveRuleAlert(ctx, cr.rule.ID, reading); err != nil {
+			return err
+		}
+	}
+	s.publish(ctx, cr, &RuleEvent{
+		Event:     "cleared",
+		RuleID:    cr.rule.ID,
+		RuleName:  cr.rule.Name,
+		DeviceID:  reading.DeviceID,
+		Values:    eval.Values,
+		Timestamp: reading.Timestamp,
+	})
+	return nil
+}
+
+func (s *RuleService) publish(ctx context.Context, cr *compiledRule, event *RuleEvent) {
+	if err := s.publisher.Publish(ctx, pubsub.NatsTopicRuleEvents, event); err != nil {
+		s.l.Error("failed to publish rule event", zap.String("rule_id", event.RuleID.String()), zap.Error(err))
+	}
+
+	// alert actions notify through the alert, event actions have no other way out
+	if s.notifier == nil || cr.def.Action.Type != model.RuleActionEvent {
+		return
+	}
+	eventData, _ := json.Marshal(event)
+	var data map[string]interface{}
+	json.Unmarshal(eventData, &data)
+	n := &notification.Notification{
+		Event:   "rule_" + event.Event,
+		Subject: fmt.Sprintf("Rule %s %s on device %s", cr.rule.Name, event.Event, event.DeviceID),
+		Data:    map[string]interface{}{"rule_event": data},
+	}
+	if err := s.notifier.Notify(ctx, n); err != nil {
+		s.l.Error("failed to raise rule notification", zap.String("rule_id", event.RuleID.String()), zap.Error(err))
+	}
+}
+
+// Checkpoint reloads the rules and saves the state of every loaded rule and device that changed since the last
+// checkpoint. States of rules deleted, disabled or changed meanwhile are dropped instead of saved.
+func (s *RuleService) Checkpoint(ctx context.Context) (int, error) {
+	s.reload(ctx) // a failed reload keeps the loaded rules, their states are still worth saving
+	versions := s.ruleVersions()
+
+	s.statesMu.Lock()
+	keys := make([]ruleStateKey, 0, len(s.states))
+	states := make([]*ruleState, 0, len(s.states))
+	for key, st := range s.states {
+		if version, ok := versions[key.ruleID]; !ok || !st.version.Equal(version) {
+			// built against a rule that changed, e.g. by an evaluation racing the update
+			delete(s.states, key)
+			continue
+		}
+		keys = append(keys, key)
+		states = append(states, st)
+	}
+	s.statesMu.Unlock()
+
+	now := time.Now()
+	var checkpoints []*model.RuleState
+	var saved []*ruleState
+	for i, st := range states {
+		st.mu.Lock()
+		if st.dirty {
+			raw, err := json.Marshal(st.state)
+			if err != nil {
+				st.mu.Unlock()
+				return 0, apperror.ErrInternal.WithMessage("failed to encode rule state").Wrap(err)
+			}
+			checkpoints = append(checkpoints, &model.RuleState{
+				RuleID:         keys[i].ruleID,
+				DeviceID:       keys[i].deviceID,
+				RuleVersion:    st.version,
+				State:          raw,
+				CheckpointedAt: now,
+			})
+			saved = append(saved, st)
+			st.dirty = false
+		}
+		st.mu.Unlock()
+	}
+
+	if err := s.stateRepo.Upsert(ctx, checkpoints); err != nil {
+		// keep them dirty for the next checkpoint
+		for _, st := range saved {
+			st.mu.Lock()
+			st.dirty = true
+			st.mu.Unlock()
+		}
+		return 0, err
+	}
+	return len(checkpoints), nil
+}
+
+// state returns the in-memory state of the rule for the device, restored from its checkpoint the first time.
+func (s *RuleService) state(ctx context.Context, rule *model.Rule, deviceID uuid.UUID) (*ruleState, error) {
+	key := ruleStateKey{ruleID: rule.ID, deviceID: deviceID}
+
+	s.statesMu.Lock()
+	st, ok := s.states[key]
+	s.statesMu.Unlock()
+	if ok && st.version.Equal(rule.UpdatedAt) {
+		return st, nil
+	}
+
+	st = &ruleState{state: rules.NewState(), version: rule.UpdatedAt}
+	checkpoint, err := s.stateRepo.Get(ctx, rule.ID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint != nil && checkpoint.RuleVersion.Equal(rule.UpdatedAt) {
+		restored := rules.NewState()
+		if err := json.Unmarshal(checkpoint.State, restored); err != nil {
+			s.l.Warn("discarding unreadable rule checkpoint", zap.String("rule_id", rule.ID.String()), zap.Error(err))
+		} else {
+			st.state = restored
+		}
+	}
+
+	s.statesMu.Lock()
+	defer s.statesMu.Unlock()
+	if existing, ok := s.states[key]; ok && existing.version.Equal(rule.UpdatedAt) {
+		return existing, nil // restored concurrently
+	}
+	s.states[key] = st
+	return st, nil
+}
+
+func (s *RuleService) dropStates(ctx context.Context, ruleID uuid.UUID) {
+	s.statesMu.Lock()
+	for key := range s.states {
+		if key.ruleID == ruleID {
+			delete(s.states, key)
+		}
+	}
+	s.statesMu.Unlock()
+
+	if err := s.stateRepo.DeleteByRuleID(ctx, ruleID); err != nil {
+		s.l.Error("failed to delete rule checkpoints", zap.String("rule_id", ruleID.String()), zap.Error(err))
+	}
+}
+
+func (s *RuleService) ensureLoaded(ctx context.Context) bool {
+	s.rulesMu.RLock()
+	loaded := s.loaded
+	s.rulesMu.RUnlock()
+	if loaded {
+		return true
+	}
+	return s.reload(ctx)
+}
+
+// reload compiles the enabled rules, rules with a broken definition are skipped.
+func (s *RuleService) reload(ctx context.Context) bool {
+	enabled, err := s.ruleRepo.ListEnabled(ctx)
+	if err != nil {
+		s.l.Error("failed to load rules", zap.Error(err))
+		return false
+	}
+
+	compiled := make([]*compiledRule, 0, len(enabled))
+	for _, rule := range enabled {
+		def, err := model.ParseRuleDefinition(rule.Definition)
+		if err != nil {
+			s.l.Warn("skipping invalid rule", zap.String("rule_id", rule.ID.String()), zap.Error(err))
+			continue
+		}
+		cr := &compiledRule{rule: rule, def: def, sensorCodes: make(map[string]bool)}
+		for _, cond := range def.Conditions {
+			cr.sensorCodes[cond.SensorCode] = true
+		}
+		compiled = append(compiled, cr)
+	}
+
+	s.rulesMu.Lock()
+	s.rules = compiled
+	s.loaded = true
+	s.rulesMu.Unlock()
+
+	// a rule changed through another replica starts over from empty windows here as well
+	versions := s.ruleVersions()
+	s.statesMu.Lock()
+	for key, st := range s.states {
+		if version, ok := versions[key.ruleID]; !ok || !st.version.Equal(version) {
+			delete(s.states, key)
+		}
+	}
+	s.statesMu.Unlock()
+	return true
+}
+
+// ruleVersions returns the UpdatedAt of every loaded rule, states belong to the version they were built against.
+func (s *RuleService) ruleVersions() map[uuid.UUID]time.Time {
+	s.rulesMu.RLock()
+	defer s.rulesMu.RUnlock()
+	versions := make(map[uuid.UUID]time.Time, len(s.rules))
+	for _, cr := range s.rules {
+		versions[cr.rule.ID] = cr.rule.UpdatedAt
+	}
+	return versions
+}
+
+func ruleMessage(cr *compiledRule, eval *rules.Evaluation) string {
+	if cr.def.Action.Message != "" {
+		return cr.def.Action.Message
+	}
+	ids := make([]string, 0, len(eval.Values))
+	for id := range eval.Values {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	values := make([]string, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, fmt.Sprintf("%s=%g", id, eval.Values[id]))
+	}
+	return fmt.Sprintf("rule %s matched: %s", cr.rule.Name, strings.Join(values, ", "))
+}
@@ -0,0 +1,115 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"go.uber.org/zap"
+)
+
+// fakeRuleRepo is the rules table the replicas share, each load gets its own copies like rows read from postgres.
+type fakeRuleRepo struct {
+	repository.RuleRepository
+	rules map[uuid.UUID]*model.Rule
+}
+
+func (r *fakeRuleRepo) Update(ctx context.Context, rule *model.Rule) error {
+	rule.UpdatedAt = time.Now()
+	copied := *rule
+	r.rules[rule.ID] = &copied
+	return nil
+}
+
+func (r *fakeRuleRepo) Delete(ctx context.Context, ruleID uuid.UUID) error {
+	delete(r.rules, ruleID)
+	return nil
+}
+
+func (r *fakeRuleRepo) ListEnabled(ctx context.Context) ([]*model.Rule, error) {
+	var enabled []*model.Rule
+	for _, rule := range r.rules {
+		if rule.Enabled {
+			copied := *rule
+			enabled = append(enabled, &copied)
+		}
+	}
+	return enabled, nil
+}
+
+type fakeRuleStateRepo struct {
+	repository.RuleStateRepository
+	saved []*model.RuleState
+}
+
+func (r *fakeRuleStateRepo) Upsert(ctx context.Context, states []*model.RuleState) error {
+	r.saved = append(r.saved, states...)
+	return nil
+}
+
+func (r *fakeRuleStateRepo) Get(ctx context.Context, ruleID uuid.UUID, deviceID uuid.UUID) (*model.RuleState, error) {
+	return nil, nil
+}
+
+func (r *fakeRuleStateRepo) DeleteByRuleID(ctx context.Context, ruleID uuid.UUID) error { return nil }
+
+func TestRuleChangesReachEveryReplica(t *testing.T) {
+	definition := []byte(`{
+		"conditions": [{"id": "temp", "sensor_code": "sensor-temp-001", "field": "1", "window": {"type": "sliding", "duration_seconds": 300}, "aggregate": "avg", "operator": ">", "value": 40}],
+		"action": {"type": "event"}
+	}`)
+	freezer := &model.Rule{ID: uuid.New(), Name: "freezer", Definition: definition, Enabled: true, UpdatedAt: time.Now().Add(-time.Hour)}
+	cooler := &model.Rule{ID: uuid.New(), Name: "cooler", Definition: definition, Enabled: true, UpdatedAt: time.Now().Add(-time.Hour)}
+	ruleRepo := &fakeRuleRepo{rules: map[uuid.UUID]*model.Rule{freezer.ID: freezer, cooler.ID: cooler}}
+	stateRepo := &fakeRuleStateRepo{}
+
+	replica := func() *service.RuleService {
+		return service.NewRuleService(ruleRepo, stateRepo, nil, &fakePublisher{}, nil, zap.NewNop())
+	}
+	changing, other := replica(), replica()
+
+	ctx := context.Background()
+	deviceID := uuid.New()
+	observe := func(ruleService *service.RuleService) {
+		ruleService.ObserveTelemetry(ctx, &service.TelemetryReading{
+			DeviceID:   deviceID,
+			SensorID:   uuid.New(),
+			SensorCode: "sensor-temp-001",
+			Timestamp:  time.Now(),
+			Data:       map[string]interface{}{"1": 35.0},
+		})
+	}
+	observe(changing)
+	observe(other)
+
+	// the freezer rule changes and the cooler rule is deleted through one replica, the other one still has windows
+	// filled against the old rules and must not save them
+	updated := *freezer
+	updated.Definition = []byte(`{
+		"conditions": [{"id": "temp", "sensor_code": "sensor-temp-001", "field": "1", "operator": ">", "value": -15}],
+		"action": {"type": "event"}
+	}`)
+	_, err := changing.UpdateRule(ctx, &updated)
+	require.NoError(t, err)
+	require.NoError(t, changing.DeleteRule(ctx, cooler.ID))
+
+	saved, err := other.Checkpoint(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, saved)
+	assert.Empty(t, stateRepo.saved)
+
+	// from then on the other replica evaluates the changed rule
+	observe(other)
+	saved, err = other.Checkpoint(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, saved)
+	require.Len(t, stateRepo.saved, 1)
+	assert.Equal(t, freezer.ID, stateRepo.saved[0].RuleID)
+	assert.True(t, stateRepo.saved[0].RuleVersion.Equal(ruleRepo.rules[freezer.ID].UpdatedAt))
+}
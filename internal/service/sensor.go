@@ -16,12 +16,13 @@ import (
 )
 
 type SensorService struct {
-	sensorRepo repository.SensorRepository
-	logger     *zap.Logger
+	sensorRepo  repository.SensorRepository
+	sensorCache *SensorCache
+	logger      *zap.Logger
 }
 
-func NewSensorService(r repository.SensorRepository, baseLogger *zap.Logger) *SensorService {
-	return &SensorService{sensorRepo: r, logger: logger.Named(baseLogger, "SensorService")}
+func NewSensorService(r repository.SensorRepository, sensorCache *SensorCache, baseLogger *zap.Logger) *SensorService {
+	return &SensorService{sensorRepo: r, sensorCache: sensorCache, logger: logger.Named(baseLogger, "SensorService")}
 }
 
 func (s *SensorService) CreateSensor(ctx context.Context, sensor *model.Sensor) (*model.Sensor, error) {
@@ -29,7 +30,13 @@ func (s *SensorService) CreateSensor(ctx context.Context, sensor *model.Sensor)
 		return nil, apperror.ErrBadRequest.WithMessagef("cannot specify ID when creating a %s", domain.EntitySensor)
 	}
 	sensor.Status = domain.Pending
-	return s.sensorRepo.Create(ctx, sensor)
+	createdSensor, err := s.sensorRepo.Create(ctx, sensor)
+	if err != nil {
+		return nil, err
+	}
+	// drop a cached "not found" from telemetry that arrived before the sensor was registered
+	s.sensorCache.Delete(createdSensor.ID)
+	return createdSensor, nil
 }
 
 func (s *SensorService) GetSensor(ctx context.Context, sensorID uuid.UUID) (*model.Sensor, error) {
@@ -41,10 +48,12 @@ func (s *SensorService) GetSensor(ctx context.Context, sensorID uuid.UUID) (*mod
 }
 
 func (s *SensorService) UpdateSensor(ctx context.Context, sensorData *model.Sensor) (*model.Sensor, error) {
+	defer s.sensorCache.Delete(sensorData.ID)
 	return s.sensorRepo.Update(ctx, sensorData)
 }
 
 func (s *SensorService) DeleteSensor(ctx context.Context, sensorID uuid.UUID) error {
+	defer s.sensorCache.Delete(sensorID)
 	return s.sensorRepo.Delete(ctx, sensorID)
 }
 
@@ -2,21 +2,77 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/cache"
 	"github.com/vars7899/iots/internal/domain/model"
 	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/pkg/apperror"
 	"github.com/vars7899/iots/pkg/logger"
 	"go.uber.org/zap"
 )
 
+// SensorCache holds sensor lookups for the ingest path, a nil value caches a sensor that doesn't exist.
+type SensorCache = cache.TTLCache[uuid.UUID, *model.Sensor]
+
+const (
+	defaultSensorCacheTTL  = time.Minute
+	defaultSensorCacheSize = 10000
+)
+
+func NewSensorCache(cfg *config.TelemetryConfig) *SensorCache {
+	ttl, size := defaultSensorCacheTTL, defaultSensorCacheSize
+	if cfg != nil {
+		if cfg.SensorCacheTTL > 0 {
+			ttl = cfg.SensorCacheTTL
+		}
+		if cfg.SensorCacheSize > 0 {
+			size = cfg.SensorCacheSize
+		}
+	}
+	return cache.NewTTLCache[uuid.UUID, *model.Sensor](ttl, size)
+}
+
+// TelemetryReading is an accepted and stored reading, handed to every TelemetryObserver.
+type TelemetryReading struct {
+	TelemetryID   uuid.UUID
+	DeviceID      uuid.UUID
+	SensorID      uuid.UUID
+	SensorCode    string
+	SchemaVersion int
+	Timestamp     time.Time
+	Data          map[string]interface{}
+	Maintenance   bool // sent while the device was under a maintenance window
+}
+
+// TelemetryObserver reacts to accepted readings (e.g. alert evaluation). Observers run on the ingest path
+// and handle their own errors.
+type TelemetryObserver interface {
+	ObserveTelemetry(ctx context.Context, reading *TelemetryReading)
+}
+
 type TelemetryService struct {
 	telemetryRepo repository.TelemetryRepository
+	sensorRepo    repository.SensorRepository
+	rejectionRepo repository.TelemetryRejectionRepository
+	sensorCache   *SensorCache
+	maintenance   MaintenanceChecker
+	observers     []TelemetryObserver
 	l             *zap.Logger
 }
 
-func NewTelemetryService(telemetryRepo repository.TelemetryRepository, baseLogger *zap.Logger) *TelemetryService {
+func NewTelemetryService(telemetryRepo repository.TelemetryRepository, sensorRepo repository.SensorRepository, rejectionRepo repository.TelemetryRejectionRepository, sensorCache *SensorCache, maintenance MaintenanceChecker, baseLogger *zap.Logger) *TelemetryService {
 	return &TelemetryService{
 		telemetryRepo: telemetryRepo,
+		sensorRepo:    sensorRepo,
+		rejectionRepo: rejectionRepo,
+		sensorCache:   sensorCache,
+		maintenance:   maintenance,
 		l:             logger.Named(baseLogger, "TelemetryService"),
 	}
 }
@@ -24,3 +80,102 @@ func NewTelemetryService(telemetryRepo repository.TelemetryRepository, baseLogge
 func (s *TelemetryService) IngestSensorTelemetry(ctx context.Context, data *model.Telemetry) error {
 	return s.telemetryRepo.Ingest(ctx, data)
 }
+
+// UnderMaintenance reports whether the device was under a maintenance window at the time.
+func (s *TelemetryService) UnderMaintenance(ctx context.Context, deviceID uuid.UUID, at time.Time) bool {
+	if s.maintenance == nil {
+		return false
+	}
+	return s.maintenance.UnderMaintenance(ctx, deviceID, at)
+}
+
+// AddObserver registers an observer, it must be called before the telemetry workers start.
+func (s *TelemetryService) AddObserver(observer TelemetryObserver) {
+	s.observers = append(s.observers, observer)
+}
+
+func (s *TelemetryService) NotifyObservers(ctx context.Context, reading *TelemetryReading) {
+	for _, observer := range s.observers {
+		observer.ObserveTelemetry(ctx, reading)
+	}
+}
+
+// VerifySensorBinding checks the payload's sensor exists, is attached to the sending device and is bound
+// to the declared sensor code and schema version. Sensors created before the binding have no schema code,
+// their telemetry is accepted until they are bound. Refused payloads are recorded in the rejection log.
+func (s *TelemetryService) VerifySensorBinding(ctx context.Context, deviceID uuid.UUID, payload *dto.TelemetryPayloadDTO) error {
+	sensorID, err := uuid.Parse(payload.SensorID)
+	if err != nil {
+		return apperror.ErrValidation.WithMessage("invalid sensor id").Wrap(err)
+	}
+
+	sensor, err := s.lookupSensor(ctx, sensorID)
+	if err != nil {
+		return err
+	}
+
+	var (
+		reason model.TelemetryRejectionReason
+		appErr *apperror.AppError
+	)
+	switch {
+	case sensor == nil:
+		reason = model.RejectionSensorNotFound
+		appErr = apperror.ErrNotFound.WithMessagef("sensor %s not found", sensorID)
+	case sensor.DeviceID != deviceID.String():
+		reason = model.RejectionDeviceMismatch
+		appErr = apperror.ErrForbidden.WithMessagef("sensor %s is not attached to this device", sensorID)
+	case sensor.SchemaCode == "":
+		return nil
+	case sensor.SchemaCode != payload.SensorCode:
+		reason = model.RejectionSchemaCodeMismatch
+		appErr = apperror.ErrValidation.WithMessagef("sensor %s is bound to sensor code %s, got %s", sensorID, sensor.SchemaCode, payload.SensorCode)
+	case sensor.SchemaVersion != 0 && sensor.SchemaVersion != payload.SchemaVersion:
+		reason = model.RejectionSchemaVersionMismatch
+		appErr = apperror.ErrValidation.WithMessagef("sensor %s is bound to schema version %d, got %d", sensorID, sensor.SchemaVersion, payload.SchemaVersion)
+	default:
+		return nil
+	}
+
+	s.recordRejection(ctx, deviceID, sensorID, payload, reason, appErr.Message)
+	return appErr.Wrap(reason)
+}
+
+func (s *TelemetryService) lookupSensor(ctx context.Context, sensorID uuid.UUID) (*model.Sensor, error) {
+	if sensor, ok := s.sensorCache.Get(sensorID); ok {
+		return sensor, nil
+	}
+
+	sensor, err := s.sensorRepo.GetByID(ctx, sensorID)
+	if err != nil {
+		if apperror.FromError(err).Code != apperror.ErrCodeNotFound {
+			return nil, apperror.ErrorHandler(err, apperror.ErrCodeDBQuery, fmt.Sprintf("failed to fetch sensor %s", sensorID))
+		}
+		sensor = nil
+	}
+	s.sensorCache.Set(sensorID, sensor)
+	return sensor, nil
+}
+
+// recordRejection is best effort, a failed write must not change the outcome for the device.
+func (s *TelemetryService) recordRejection(ctx context.Context, deviceID uuid.UUID, sensorID uuid.UUID, payload *dto.TelemetryPayloadDTO, reason model.TelemetryRejectionReason, detail string) {
+	data, err := json.Marshal(payload.Data)
+	if err != nil {
+		data = nil
+	}
+
+	rejection := &model.TelemetryRejection{
+		DeviceID:      deviceID,
+		SensorID:      sensorID,
+		SensorCode:    payload.SensorCode,
+		SchemaVersion: payload.SchemaVersion,
+		Reason:        reason,
+		Detail:        detail,
+		Payload:       data,
+	}
+	if err := s.rejectionRepo.Create(ctx, rejection); err != nil {
+		s.l.Error("failed to record telemetry rejection", zap.String("device_id", deviceID.String()), zap.String("sensor_id", sensorID.String()), zap.Error(err))
+		return
+	}
+	s.l.Warn("telemetry rejected", zap.String("device_id", deviceID.String()), zap.String("sensor_id", sensorID.String()), zap.String("reason", string(reason)))
+}
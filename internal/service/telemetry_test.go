@@ -0,0 +1,89 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/repository"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"go.uber.org/zap"
+)
+
+type memorySensorRepo struct {
+	repository.SensorRepository
+	sensors map[uuid.UUID]*model.Sensor
+}
+
+func (r *memorySensorRepo) GetByID(ctx context.Context, sensorID uuid.UUID) (*model.Sensor, error) {
+	if sensor, ok := r.sensors[sensorID]; ok {
+		return sensor, nil
+	}
+	return nil, apperror.ErrNotFound
+}
+
+type memoryRejectionRepo struct {
+	rejections []*model.TelemetryRejection
+}
+
+func (r *memoryRejectionRepo) Create(ctx context.Context, rejection *model.TelemetryRejection) error {
+	r.rejections = append(r.rejections, rejection)
+	return nil
+}
+
+func TestVerifySensorBinding(t *testing.T) {
+	deviceID := uuid.New()
+	bound := &model.Sensor{ID: uuid.New(), DeviceID: deviceID.String(), SchemaCode: "temperature", SchemaVersion: 2}
+	anyVersion := &model.Sensor{ID: uuid.New(), DeviceID: deviceID.String(), SchemaCode: "temperature"}
+	unbound := &model.Sensor{ID: uuid.New(), DeviceID: deviceID.String()}
+	foreign := &model.Sensor{ID: uuid.New(), DeviceID: uuid.NewString(), SchemaCode: "temperature"}
+	sensors := &memorySensorRepo{sensors: map[uuid.UUID]*model.Sensor{}}
+	for _, sensor := range []*model.Sensor{bound, anyVersion, unbound, foreign} {
+		sensors.sensors[sensor.ID] = sensor
+	}
+
+	tests := []struct {
+		name          string
+		sensorID      uuid.UUID
+		sensorCode    string
+		schemaVersion int
+		reason        model.TelemetryRejectionReason // empty when accepted
+	}{
+		{name: "bound", sensorID: bound.ID, sensorCode: "temperature", schemaVersion: 2},
+		{name: "any version", sensorID: anyVersion.ID, sensorCode: "temperature", schemaVersion: 7},
+		{name: "unbound sensor from before the binding", sensorID: unbound.ID, sensorCode: "humidity", schemaVersion: 1},
+		{name: "sensor code mismatch", sensorID: bound.ID, sensorCode: "humidity", schemaVersion: 2, reason: model.RejectionSchemaCodeMismatch},
+		{name: "schema version mismatch", sensorID: bound.ID, sensorCode: "temperature", schemaVersion: 1, reason: model.RejectionSchemaVersionMismatch},
+		{name: "sensor of another device", sensorID: foreign.ID, sensorCode: "temperature", schemaVersion: 1, reason: model.RejectionDeviceMismatch},
+		{name: "unknown sensor", sensorID: uuid.New(), sensorCode: "temperature", schemaVersion: 1, reason: model.RejectionSensorNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejections := &memoryRejectionRepo{}
+			telemetry := service.NewTelemetryService(nil, sensors, rejections, service.NewSensorCache(nil), nil, zap.NewNop())
+
+			err := telemetry.VerifySensorBinding(context.Background(), deviceID, &dto.TelemetryPayloadDTO{
+				SensorID:      tt.sensorID.String(),
+				SensorCode:    tt.sensorCode,
+				SchemaVersion: tt.schemaVersion,
+				Data:          map[string]interface{}{"value": 21.5},
+			})
+			if tt.reason == "" {
+				assert.NoError(t, err)
+				assert.Empty(t, rejections.rejections)
+				return
+			}
+			var reason model.TelemetryRejectionReason
+			assert.True(t, errors.As(err, &reason))
+			assert.Equal(t, tt.reason, reason)
+			if assert.Len(t, rejections.rejections, 1) {
+				assert.Equal(t, tt.reason, rejections.rejections[0].Reason)
+			}
+		})
+	}
+}
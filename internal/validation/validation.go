@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
+	"github.com/vars7899/iots/config"
 	"github.com/vars7899/iots/internal/domain"
 	"github.com/vars7899/iots/pkg/logger"
 	"go.uber.org/zap"
@@ -23,6 +24,7 @@ func registerCustomValidators() {
 	registerUUIDValidator()
 	registerStatusValidator()
 	registerConnectionTypeValidator()
+	registerSensorSchemaCodeValidator()
 }
 
 func registerUUIDValidator() {
@@ -85,3 +87,14 @@ func registerConnectionTypeValidator() {
 		return domain.IsValidConnectionType(connectionTypeStr)
 	})
 }
+
+func registerSensorSchemaCodeValidator() {
+	// sensor_schema_code checks the code against the loaded sensor schema registry
+	Validate.RegisterValidation("sensor_schema_code", func(fl validator.FieldLevel) bool {
+		code, ok := fl.Field().Interface().(string)
+		if !ok {
+			return false
+		}
+		return config.SensorSchemaRepository.HasSensorCode(code)
+	})
+}
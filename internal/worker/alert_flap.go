@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// AlertFlapWorker resolves the flapping alerts that stayed quiet for the flap window, whether or not their device
+// still reports.
+func AlertFlapWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, alertService *service.AlertService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "AlertFlapWorker")
+	l.Info("alert flap worker started", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			resolved, err := alertService.ResolveQuietFlapping(ctx, time.Now())
+			if err != nil {
+				l.Error("failed to resolve quiet flapping alerts", zap.Int("resolved", resolved), zap.Error(err))
+				continue
+			}
+			if resolved > 0 {
+				l.Info("quiet flapping alerts resolved", zap.Int("resolved", resolved))
+			}
+		case <-ctx.Done():
+			l.Info("Application context cancelled alert flap worker existing")
+			return
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/auth/deviceauth"
+	"github.com/vars7899/iots/pkg/cbor"
+	"github.com/vars7899/iots/pkg/coap"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// CoapTelemetryPath is the Uri-Path devices POST telemetry to, coap://<host>:5683/telemetry?t=<compact_token>
+	CoapTelemetryPath = "telemetry"
+	// CoapTokenQueryKey is the Uri-Query key carrying the device compact token
+	CoapTokenQueryKey = "t"
+)
+
+// CoapTelemetryWorker serves telemetry over CoAP/UDP for constrained devices until ctx is cancelled.
+func CoapTelemetryWorker(ctx context.Context, wg *sync.WaitGroup, cfg *config.CoapConfig, telemetryService *service.TelemetryService, ingestStatsService *service.IngestStatsService, deviceAuthService deviceauth.DeviceAuthService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "CoapTelemetryWorker")
+
+	server := coap.NewServer(cfg, l)
+	server.Handle(coap.CodePOST, CoapTelemetryPath, NewCoapTelemetryHandler(telemetryService, ingestStatsService, deviceAuthService, l))
+
+	l.Info("coap telemetry worker started", zap.String("address", cfg.Address))
+	if err := server.ListenAndServe(ctx); err != nil {
+		l.Error("coap telemetry server failed", zap.Error(err))
+		return
+	}
+	l.Info("Application context cancelled coap telemetry worker existing")
+}
+
+// NewCoapTelemetryHandler authenticates the device with its compact token, decodes the JSON or CBOR
+// payload and hands it to the same ingestion path as the websocket telemetry worker.
+func NewCoapTelemetryHandler(telemetryService *service.TelemetryService, ingestStatsService *service.IngestStatsService, deviceAuthService deviceauth.DeviceAuthService, l *zap.Logger) coap.HandlerFunc {
+	return func(ctx context.Context, req *coap.Request) *coap.Response {
+		remoteAddr := req.RemoteAddr.String()
+
+		token, ok := req.Query(CoapTokenQueryKey)
+		if !ok || token == "" {
+			return coapErrorResponse(apperror.ErrMissingAuth.WithMessage("missing device compact token"))
+		}
+		claims, err := deviceAuthService.ValidateDeviceCompactToken(ctx, token)
+		if err != nil {
+			l.Warn("coap device authentication failed", zap.String("remote_addr", remoteAddr), zap.Error(err))
+			return coapErrorResponse(apperror.ErrUnauthorized.WithMessage("invalid device token"))
+		}
+
+		// json is assumed when the content format option is omitted
+		format, ok := req.ContentFormat()
+		if !ok {
+			format = coap.ContentFormatJSON
+		}
+		if format != coap.ContentFormatJSON && format != coap.ContentFormatCBOR {
+			RecordIngestOutcome(ctx, ingestStatsService, claims.DeviceID, nil, len(req.Payload), apperror.ErrBadRequest.WithMessage("unsupported content format"))
+			return &coap.Response{Code: coap.CodeUnsupportedContentFormat}
+		}
+
+		payloadDTO, err := decodeCoapTelemetryPayload(format, req.Payload)
+		if err != nil {
+			l.Debug("failed to decode coap telemetry payload", zap.String("device_id", claims.DeviceID.String()), zap.Error(err))
+			RecordIngestOutcome(ctx, ingestStatsService, claims.DeviceID, nil, len(req.Payload), err)
+			return coapErrorResponse(err)
+		}
+
+		telemetryModel, err := IngestTelemetryPayload(ctx, claims.DeviceID, payloadDTO, telemetryService)
+		RecordIngestOutcome(ctx, ingestStatsService, claims.DeviceID, payloadDTO, len(req.Payload), err)
+		if err != nil {
+			l.Error("failed to ingest coap telemetry payload",
+				zap.String("device_id", claims.DeviceID.String()),
+				zap.String("remote_addr", remoteAddr),
+				zap.Error(err),
+				zap.Any("payload_dto", payloadDTO),
+			)
+			return coapErrorResponse(err)
+		}
+
+		l.Debug("Successfully ingested coap telemetry data",
+			zap.String("device_id", claims.DeviceID.String()),
+			zap.String("sensor_id", telemetryModel.SensorID.String()),
+			zap.Bool("confirmable", req.Type == coap.TypeConfirmable),
+		)
+		return &coap.Response{Code: coap.CodeCreated}
+	}
+}
+
+func decodeCoapTelemetryPayload(format coap.ContentFormat, payload []byte) (*dto.TelemetryPayloadDTO, error) {
+	if len(payload) == 0 {
+		return nil, apperror.ErrBadRequest.WithMessage("empty telemetry payload")
+	}
+
+	if format == coap.ContentFormatCBOR {
+		doc, err := cbor.UnmarshalMap(payload)
+		if err != nil {
+			return nil, apperror.ErrBadRequest.WithMessage("invalid cbor telemetry payload").Wrap(err)
+		}
+		payloadDTO, err := dto.TelemetryPayloadFromMap(doc)
+		if err != nil {
+			return nil, apperror.ErrBadRequest.WithMessage("invalid cbor telemetry payload").Wrap(err)
+		}
+		return payloadDTO, nil
+	}
+
+	var payloadDTO dto.TelemetryPayloadDTO
+	if err := json.Unmarshal(payload, &payloadDTO); err != nil {
+		return nil, apperror.ErrBadRequest.WithMessage("invalid json telemetry payload").Wrap(err)
+	}
+	return &payloadDTO, nil
+}
+
+// coapErrorResponse maps an application error to a response code with a diagnostic payload (RFC 7252 section 5.5.2).
+func coapErrorResponse(err error) *coap.Response {
+	appErr := apperror.FromError(err)
+
+	code := coap.CodeFromHTTPStatus(appErr.Status())
+
+	diagnostic := appErr.Message
+	if appErr.InternalOnly() || code.Class() == 5 {
+		diagnostic = "internal error"
+	}
+	return &coap.Response{
+		Code:          code,
+		ContentFormat: coap.ContentFormatTextPlain,
+		Payload:       []byte(diagnostic),
+	}
+}
@@ -0,0 +1,155 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+// CommandStatusWorker applies the command lifecycle events published by the device gateway and the command processor,
+// the events it fails on are kept as dead letters. The workers of all replicas share a queue group, so each event is
+// applied once.
+func CommandStatusWorker(ctx context.Context, wg *sync.WaitGroup, subscriber pubsub.PubSubPublisher, commandService *service.CommandService, deadLetters *service.DeadLetterService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "CommandStatusWorker")
+
+	topic := pubsub.NatsTopicCommandStatus
+	events, err := subscriber.QueueSubscribe(ctx, topic, pubsub.NatsQueueCommandStatus)
+	if err != nil {
+		l.Error("failed to subscribe to command status events", zap.String("topic", topic), zap.Error(err))
+		return
+	}
+	l.Info("command status worker started", zap.String("topic", topic), zap.String("queue", pubsub.NatsQueueCommandStatus))
+
+	for {
+		select {
+		case data := <-events:
+			var event model.DeviceEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				l.Error("failed to parse command status event", zap.ByteString("raw", data), zap.Error(err))
+				deadLetters.Record(ctx, &model.DeadLetter{Subject: topic, Message: string(data), ErrorCode: "unmarshal_error", Error: err.Error()})
+				continue
+			}
+			if err := commandService.HandleStatusEvent(ctx, &event); err != nil {
+				l.Error("failed to apply command status event",
+					zap.String("event_type", event.Type),
+					zap.String("command_id", event.CorrelationID.String()),
+					zap.Error(err),
+				)
+				letter := &model.DeadLetter{
+					Subject:     topic,
+					Message:     string(data),
+					CommandCode: event.CommandCode,
+					ErrorCode:   string(apperror.FromError(err).Code),
+					Error:       err.Error(),
+				}
+				if event.DeviceID != uuid.Nil {
+					letter.DeviceID = &event.DeviceID
+				}
+				deadLetters.Record(ctx, letter)
+			}
+		case <-ctx.Done():
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := subscriber.Unsubscribe(cleanupCtx, topic); err != nil {
+				l.Warn("failed to unsubscribe from command status events", zap.Error(err))
+			}
+			cancel()
+			l.Info("Application context cancelled command status worker existing")
+			return
+		}
+	}
+}
+
+// CommandExpiryWorker expires the commands that didn't finish within their ttl.
+func CommandExpiryWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, commandService *service.CommandService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "CommandExpiryWorker")
+	l.Info("command expiry worker started", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			expired, err := commandService.ExpireOverdue(ctx, time.Now())
+			if err != nil {
+				l.Error("failed to expire commands", zap.Int("expired", expired), zap.Error(err))
+				continue
+			}
+			if expired > 0 {
+				l.Info("commands expired", zap.Int("expired", expired))
+			}
+		case <-ctx.Done():
+			l.Info("Application context cancelled command expiry worker existing")
+			return
+		}
+	}
+}
+
+// CommandRetryWorker publishes the commands again that weren't acknowledged in time.
+func CommandRetryWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, commandService *service.CommandService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "CommandRetryWorker")
+	l.Info("command retry worker started", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			retried, failed, err := commandService.RetryUnacknowledged(ctx, time.Now())
+			if err != nil {
+				l.Error("failed to retry commands", zap.Int("retried", retried), zap.Int("failed", failed), zap.Error(err))
+				continue
+			}
+			if retried > 0 || failed > 0 {
+				l.Info("unacknowledged commands handled", zap.Int("retried", retried), zap.Int("failed", failed))
+			}
+		case <-ctx.Done():
+			l.Info("Application context cancelled command retry worker existing")
+			return
+		}
+	}
+}
+
+// DeadLetterPruneWorker deletes the dead letters past their retention.
+func DeadLetterPruneWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, deadLetterService *service.DeadLetterService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "DeadLetterPruneWorker")
+	l.Info("dead letter prune worker started", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pruned, err := deadLetterService.Prune(ctx, time.Now())
+			if err != nil {
+				l.Error("failed to prune dead letters", zap.Int64("pruned", pruned), zap.Error(err))
+				continue
+			}
+			if pruned > 0 {
+				l.Info("dead letters pruned", zap.Int64("pruned", pruned))
+			}
+		case <-ctx.Done():
+			l.Info("Application context cancelled dead letter prune worker existing")
+			return
+		}
+	}
+}
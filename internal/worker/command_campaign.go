@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vars7899/iots/internal/leader"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// CommandCampaignWorker sends the next waves of the running command campaigns while this instance leads. It shares
+// the elector of the command scheduler, the same instance runs both.
+func CommandCampaignWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, elector *leader.Elector, campaignService *service.CommandCampaignService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "CommandCampaignWorker")
+	l.Info("command campaign worker started", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !elector.Lead(ctx) {
+				continue
+			}
+			started, err := campaignService.Advance(ctx, time.Now())
+			if err != nil {
+				l.Error("failed to advance command campaigns", zap.Error(err))
+				continue
+			}
+			if started > 0 {
+				l.Info("command campaign waves started", zap.Int("waves", started))
+			}
+		case <-ctx.Done():
+			l.Info("Application context cancelled command campaign worker existing")
+			return
+		}
+	}
+}
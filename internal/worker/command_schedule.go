@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vars7899/iots/internal/leader"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// CommandSchedulerWorker fires the due command schedules while this instance leads, the other instances only keep
+// competing for the lease.
+func CommandSchedulerWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, elector *leader.Elector, scheduleService *service.CommandScheduleService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "CommandSchedulerWorker")
+	l.Info("command scheduler worker started", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !elector.Lead(ctx) {
+				continue
+			}
+			fired, err := scheduleService.RunDue(ctx, time.Now())
+			if err != nil {
+				l.Error("failed to run command schedules", zap.Int("fired", fired), zap.Error(err))
+				continue
+			}
+			if fired > 0 {
+				l.Info("command schedules fired", zap.Int("fired", fired))
+			}
+		case <-ctx.Done():
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			elector.Resign(cleanupCtx)
+			cancel()
+			l.Info("Application context cancelled command scheduler worker existing")
+			return
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// EscalationWorker notifies the due tiers of unacknowledged alerts every interval, and right away when an alert
+// starts escalating immediately.
+func EscalationWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, escalationService *service.EscalationService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "EscalationWorker")
+	l.Info("escalation worker started", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	process := func() {
+		for {
+			processed, err := escalationService.ProcessDue(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					l.Error("alert escalation failed", zap.Error(err))
+				}
+				return
+			}
+			if processed == 0 {
+				return
+			}
+			// a full batch may have more due behind it
+		}
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			process()
+		case <-escalationService.Wake():
+			process()
+		case <-ctx.Done():
+			l.Info("Application context cancelled escalation worker existing")
+			return
+		}
+	}
+}
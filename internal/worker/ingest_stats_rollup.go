@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// IngestStatsRollupWorker moves the live ingest counters to postgres every interval and once more on shutdown.
+func IngestStatsRollupWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, ingestStatsService *service.IngestStatsService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "IngestStatsRollupWorker")
+	l.Info("ingest stats rollup worker started", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	rollup := func(ctx context.Context) {
+		start := time.Now()
+		devices, err := ingestStatsService.Rollup(ctx)
+		if err != nil {
+			l.Error("ingest stats rollup failed", zap.Int("devices", devices), zap.Error(err))
+			return
+		}
+		l.Debug("ingest stats rolled up", zap.Int("devices", devices), zap.Duration("took", time.Since(start)))
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			rollup(ctx)
+		case <-ctx.Done():
+			// application context is gone, give the final rollup its own deadline
+			finalCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			rollup(finalCtx)
+			cancel()
+			l.Info("Application context cancelled ingest stats rollup worker existing")
+			return
+		}
+	}
+}
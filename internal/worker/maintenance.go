@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// MaintenanceStatusWorker moves devices in and out of under_maintenance as their maintenance windows start and end.
+func MaintenanceStatusWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, maintenanceService *service.MaintenanceService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "MaintenanceStatusWorker")
+	l.Info("maintenance status worker started", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	syncStatus := func() {
+		started, ended, err := maintenanceService.SyncDeviceStatus(ctx, time.Now())
+		if err != nil {
+			l.Error("maintenance status sync failed", zap.Int("started", started), zap.Int("ended", ended), zap.Error(err))
+			return
+		}
+		if started > 0 || ended > 0 {
+			l.Info("device maintenance status synced", zap.Int("started", started), zap.Int("ended", ended))
+		}
+	}
+
+	syncStatus()
+	for {
+		select {
+		case <-ticker.C:
+			syncStatus()
+		case <-ctx.Done():
+			l.Info("Application context cancelled maintenance status worker existing")
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/internal/notification"
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+// NotificationDispatchWorker sends due notification deliveries every interval, and right away when new ones are raised.
+func NotificationDispatchWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, notificationService *service.NotificationService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "NotificationDispatchWorker")
+	l.Info("notification dispatch worker started", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	dispatch := func() {
+		for {
+			sent, err := notificationService.DispatchDue(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					l.Error("notification dispatch failed", zap.Error(err))
+				}
+				return
+			}
+			if sent == 0 {
+				return
+			}
+			// a full batch may have more due behind it
+		}
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			dispatch()
+		case <-notificationService.Wake():
+			dispatch()
+		case <-ctx.Done():
+			l.Info("Application context cancelled notification dispatch worker existing")
+			return
+		}
+	}
+}
+
+// DeviceEventNotificationWorker turns the device events published on device.events.<device id> into notifications,
+// only the configured event types are forwarded.
+func DeviceEventNotificationWorker(ctx context.Context, wg *sync.WaitGroup, subscriber pubsub.PubSubPublisher, notifier service.Notifier, eventTypes []string, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "DeviceEventNotificationWorker")
+
+	forward := make(map[string]bool, len(eventTypes))
+	for _, eventType := range eventTypes {
+		forward[eventType] = true
+	}
+
+	topic := pubsub.NatsTopicDeviceEventsPrefix + "*"
+	messages, err := subscriber.Subscribe(ctx, topic)
+	if err != nil {
+		l.Error("failed to subscribe to device events", zap.String("topic", topic), zap.Error(err))
+		return
+	}
+	defer subscriber.Unsubscribe(context.Background(), topic)
+	l.Info("device event notification worker started", zap.Strings("event_types", eventTypes))
+
+	for {
+		select {
+		case data := <-messages:
+			var event model.DeviceEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				l.Warn("dropping malformed device event", zap.Error(err))
+				continue
+			}
+			if !forward[event.Type] {
+				continue
+			}
+			var eventData map[string]interface{}
+			if err := json.Unmarshal(data, &eventData); err != nil {
+				continue
+			}
+
+			n := &notification.Notification{
+				Event:   notification.DeviceEventPrefix + event.Type,
+				Subject: fmt.Sprintf("Device %s: %s", event.DeviceID, event.Type),
+				Data:    map[string]interface{}{"event": eventData},
+			}
+			if err := notifier.Notify(ctx, n); err != nil {
+				l.Error("failed to raise device event notification", zap.String("device_id", event.DeviceID.String()), zap.String("type", event.Type), zap.Error(err))
+			}
+		case <-ctx.Done():
+			l.Info("Application context cancelled device event notification worker existing")
+			return
+		}
+	}
+}
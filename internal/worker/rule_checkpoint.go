@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vars7899/iots/internal/service"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// RuleCheckpointWorker reloads the rules and saves the changed rule window state every interval and once more on
+// shutdown, rules changed through another replica apply from the next tick.
+func RuleCheckpointWorker(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, ruleService *service.RuleService, baseLogger *zap.Logger) {
+	defer wg.Done()
+
+	l := logger.Named(baseLogger, "RuleCheckpointWorker")
+	l.Info("rule checkpoint worker started", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	checkpoint := func(ctx context.Context) {
+		start := time.Now()
+		states, err := ruleService.Checkpoint(ctx)
+		if err != nil {
+			l.Error("rule checkpoint failed", zap.Error(err))
+			return
+		}
+		l.Debug("rule states checkpointed", zap.Int("states", states), zap.Duration("took", time.Since(start)))
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			checkpoint(ctx)
+		case <-ctx.Done():
+			// application context is gone, give the final checkpoint its own deadline
+			finalCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			checkpoint(finalCtx)
+			cancel()
+			l.Info("Application context cancelled rule checkpoint worker existing")
+			return
+		}
+	}
+}
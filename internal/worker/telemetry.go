@@ -3,17 +3,22 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/vars7899/iots/internal/api/v1/dto"
+	"github.com/vars7899/iots/internal/cache"
+	"github.com/vars7899/iots/internal/domain/model"
 	"github.com/vars7899/iots/internal/service"
 	"github.com/vars7899/iots/internal/ws"
+	"github.com/vars7899/iots/pkg/apperror"
 	"github.com/vars7899/iots/pkg/logger"
 	"go.uber.org/zap"
 )
 
-func TelemetryWorker(ctx context.Context, wg *sync.WaitGroup, message <-chan ws.ClientMessage, telemetryService *service.TelemetryService, baseLogger *zap.Logger) {
+func TelemetryWorker(ctx context.Context, wg *sync.WaitGroup, message <-chan ws.ClientMessage, telemetryService *service.TelemetryService, ingestStatsService *service.IngestStatsService, baseLogger *zap.Logger) {
 	defer wg.Done()
 
 	l := logger.Named(baseLogger, "TelemetryWorker")
@@ -31,43 +36,23 @@ func TelemetryWorker(ctx context.Context, wg *sync.WaitGroup, message <-chan ws.
 			var payloadDTO dto.TelemetryPayloadDTO
 			if err := json.Unmarshal(msg.Message, &payloadDTO); err != nil {
 				l.Error("failed to pares telemetry message", zap.String("client_id", msg.Client.ID), zap.Error(err), zap.ByteString("raw", msg.Message))
+				RecordIngestOutcome(msg.Client.Ctx, ingestStatsService, msg.Client.DeviceID, nil, len(msg.Message), apperror.ErrBadRequest.Wrap(err))
 				// TODO: Send error feedback to client msg.Client.SendMessage([]byte("Error: Invalid JSON"))
 				continue // Skip to next message
 			}
 
-			if err := payloadDTO.ValidateBasicStructure(); err != nil {
-				l.Error("telemetry payload basic validation failed", zap.String("client_id", msg.Client.ID), zap.Error(err), zap.ByteString("raw", msg.Message))
-				// TODO: Send error feedback to client msg.Client.SendMessage([]byte(fmt.Sprintf("Error: Basic validation failed - %v", err.Error())))
-				continue // Skip to next message
-			}
-
-			if err := payloadDTO.ValidateAgainstSchema(); err != nil {
-				l.Error("telemetry payload schema validation failed", zap.String("client_id", msg.Client.ID), zap.Error(err), zap.Any("payload_dto", payloadDTO))
-				// TODO: Send error feedback to client msg.Client.SendMessage([]byte(fmt.Sprintf("Error: Schema validation failed - %v", err.Error())))
-				continue // Skip to next message
-			}
-
-			telemetryModel, err := payloadDTO.AsModel() // <-- Use the updated AsModel
-
-			if err != nil {
-				// This error indicates a problem during conversion (e.g., JSON marshalling of Data)
-				l.Error("failed to convert telemetry DTO to model", zap.String("client_id", msg.Client.ID), zap.Error(err), zap.Any("payload_dto", payloadDTO))
-				// TODO: Send error feedback
-				continue // Skip to next message
-			}
-
-			// 5. Call the Telemetry Service to ingest the model
 			// Use the client's context (derived from app context) for cancellation signals
 			ingestCtx, cancel := context.WithTimeout(msg.Client.Ctx, 10*time.Second) // Use a timeout for the service call
-			// ingestCtx := msg.Client.ctx // Alternative: use client's context directly
-			err = telemetryService.IngestSensorTelemetry(ingestCtx, telemetryModel)
+			telemetryModel, err := IngestTelemetryPayload(ingestCtx, msg.Client.DeviceID, &payloadDTO, telemetryService)
 			cancel() // Release context resources
+			RecordIngestOutcome(msg.Client.Ctx, ingestStatsService, msg.Client.DeviceID, &payloadDTO, len(msg.Message), err)
 
 			if err != nil {
-				l.Error("Telemetry service failed to ingest data",
+				l.Error("failed to ingest telemetry payload",
 					zap.String("client_id", msg.Client.ID),
+					zap.String("device_id", msg.Client.DeviceID.String()),
 					zap.Error(err),
-					zap.Any("telemetry_model", telemetryModel), // Log the model
+					zap.Any("payload_dto", payloadDTO),
 				)
 				// TODO: Handle service errors - send error back to client? Retry?
 				// msg.Client.SendMessage([]byte(fmt.Sprintf("Error processing data: %v", err.Error())))
@@ -83,3 +68,80 @@ func TelemetryWorker(ctx context.Context, wg *sync.WaitGroup, message <-chan ws.
 		}
 	}
 }
+
+// IngestTelemetryPayload is the validation and storage path shared by every telemetry transport
+// (websocket, coap). Validation failures are returned as apperror.ErrValidation, payloads for a sensor
+// the authenticated device doesn't own as apperror.ErrNotFound or apperror.ErrForbidden.
+func IngestTelemetryPayload(ctx context.Context, deviceID uuid.UUID, payloadDTO *dto.TelemetryPayloadDTO, telemetryService *service.TelemetryService) (*model.Telemetry, error) {
+	if err := payloadDTO.ValidateBasicStructure(); err != nil {
+		return nil, apperror.ErrValidation.WithMessage("telemetry payload basic validation failed").Wrap(err)
+	}
+
+	if err := payloadDTO.ValidateAgainstSchema(); err != nil {
+		return nil, apperror.ErrValidation.WithMessage("telemetry payload schema validation failed").Wrap(err)
+	}
+
+	if err := telemetryService.VerifySensorBinding(ctx, deviceID, payloadDTO); err != nil {
+		return nil, err
+	}
+
+	telemetryModel, err := payloadDTO.AsModel()
+	if err != nil {
+		// This error indicates a problem during conversion (e.g., JSON marshalling of Data)
+		return nil, apperror.ErrInternal.WithMessage("failed to convert telemetry payload to model").Wrap(err)
+	}
+
+	telemetryModel.Maintenance = telemetryService.UnderMaintenance(ctx, deviceID, telemetryModel.Timestamp)
+
+	if err := telemetryService.IngestSensorTelemetry(ctx, telemetryModel); err != nil {
+		return nil, apperror.ErrorHandler(err, apperror.ErrCodeDBInsert, "failed to ingest telemetry")
+	}
+
+	telemetryService.NotifyObservers(ctx, &service.TelemetryReading{
+		TelemetryID:   telemetryModel.ID,
+		DeviceID:      deviceID,
+		SensorID:      telemetryModel.SensorID,
+		SensorCode:    payloadDTO.SensorCode,
+		SchemaVersion: payloadDTO.SchemaVersion,
+		Timestamp:     telemetryModel.Timestamp,
+		Data:          payloadDTO.Data,
+		Maintenance:   telemetryModel.Maintenance,
+	})
+	return telemetryModel, nil
+}
+
+// RecordIngestOutcome counts a received message in the device ingest statistics. payloadDTO is nil when
+// the message couldn't be decoded, err is the outcome of decoding and IngestTelemetryPayload.
+func RecordIngestOutcome(ctx context.Context, ingestStatsService *service.IngestStatsService, deviceID uuid.UUID, payloadDTO *dto.TelemetryPayloadDTO, size int, err error) {
+	event := cache.IngestEvent{
+		DeviceID:   deviceID,
+		SensorKey:  cache.UnknownSensorKey,
+		Bytes:      size,
+		Accepted:   err == nil,
+		ReceivedAt: time.Now(),
+	}
+	if payloadDTO != nil {
+		if sensorID, parseErr := uuid.Parse(payloadDTO.SensorID); parseErr == nil {
+			event.SensorKey = sensorID.String()
+		}
+	}
+	if err != nil {
+		event.Reason = string(ingestRejectionReason(err))
+	}
+	ingestStatsService.Record(ctx, event)
+}
+
+func ingestRejectionReason(err error) model.TelemetryRejectionReason {
+	var reason model.TelemetryRejectionReason
+	if errors.As(err, &reason) {
+		return reason
+	}
+	switch apperror.FromError(err).Code {
+	case apperror.ErrCodeBadRequest:
+		return model.RejectionMalformedPayload
+	case apperror.ErrCodeValidation:
+		return model.RejectionInvalidPayload
+	default:
+		return model.RejectionIngestFailed
+	}
+}
@@ -29,6 +29,7 @@ type WebSocketClientConfig struct {
 
 type Client struct {
 	ID         string
+	DeviceID   uuid.UUID // authenticated device the connection belongs to
 	conn       *websocket.Conn
 	sendCh     chan []byte
 	hub        *Hub
@@ -45,11 +46,12 @@ type ClientMessage struct {
 	Message []byte
 }
 
-func NewClient(h *Hub, clientType WebsocketClientType, conn *websocket.Conn, baseLogger *zap.Logger, cfg *WebSocketClientConfig) *Client {
+func NewClient(h *Hub, clientType WebsocketClientType, deviceID uuid.UUID, conn *websocket.Conn, baseLogger *zap.Logger, cfg *WebSocketClientConfig) *Client {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	client := &Client{
 		ID:         uuid.NewString(),
+		DeviceID:   deviceID,
 		conn:       conn,
 		sendCh:     make(chan []byte, 256),
 		hub:        h,
@@ -22,6 +22,7 @@ const (
 	StatusInternalServerError = http.StatusInternalServerError
 	StatusServiceUnavailable  = http.StatusServiceUnavailable
 	StatusRequestTimeout      = http.StatusRequestTimeout
+	StatusGatewayTimeout      = http.StatusGatewayTimeout
 )
 
 const (
@@ -86,7 +87,8 @@ const (
 	ErrCodeDBPing          ErrorCode = "ERR-5015"
 
 	// Timeout errors (6xxx)
-	ErrCodeTimeout ErrorCode = "ERR-6000"
+	ErrCodeTimeout        ErrorCode = "ERR-6000"
+	ErrCodeGatewayTimeout ErrorCode = "ERR-6001"
 
 	// Context errors (7xxx)
 	ErrCodeContextCancelled ErrorCode = "ERR-7000"
@@ -174,7 +176,8 @@ var CodeMessages = map[ErrorCode]string{
 	ErrCodeDBPing:          "Failed to ping database",
 
 	// Timeout errors
-	ErrCodeTimeout: "Request deadline exceeded",
+	ErrCodeTimeout:        "Request deadline exceeded",
+	ErrCodeGatewayTimeout: "No answer from upstream in time",
 
 	// Context errors
 	ErrCodeContextCancelled: "Operation cancelled by context",
@@ -262,7 +265,8 @@ var CodeStatus = map[ErrorCode]int{
 	ErrCodeDBPing:          StatusServiceUnavailable,
 
 	// Timeout errors
-	ErrCodeTimeout: StatusRequestTimeout,
+	ErrCodeTimeout:        StatusRequestTimeout,
+	ErrCodeGatewayTimeout: StatusGatewayTimeout,
 
 	// Context errors
 	ErrCodeContextCancelled: StatusRequestTimeout,
@@ -553,7 +557,8 @@ var (
 	ErrDBPing          = New(ErrCodeDBPing)
 
 	// Timeout errors
-	ErrTimeout = New(ErrCodeTimeout)
+	ErrTimeout        = New(ErrCodeTimeout)
+	ErrGatewayTimeout = New(ErrCodeGatewayTimeout)
 
 	// Context errors
 	ErrContextCancelled = New(ErrCodeContextCancelled)
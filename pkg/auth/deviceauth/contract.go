@@ -11,6 +11,7 @@ type DeviceTokenService interface {
 	GenerateTokens(deviceID uuid.UUID) (*DeviceConnectionTokens, error)
 	ParseConnectionToken(tokenStr string) (*DeviceConnectionClaims, error)
 	ParseRefreshToken(tokenStr string) (*DeviceRefreshClaims, error)
+	ParseCompactToken(tokenStr string) (*CompactTokenClaims, error)
 	GetConnectionTTL() time.Duration
 	GetRefreshTTL() time.Duration
 	GetLeeway() time.Duration
@@ -24,6 +25,7 @@ type DeviceAuthService interface {
 	ValidateDeviceConnectionTokens(ctx context.Context, connectionToken string) (*DeviceConnectionClaims, error)
 	ParseDeviceRefreshTokens(ctx context.Context, refreshToken string) (*DeviceRefreshClaims, error)
 	ValidateDeviceRefreshTokens(ctx context.Context, refreshToken string) (*DeviceRefreshClaims, error)
+	ValidateDeviceCompactToken(ctx context.Context, compactToken string) (*CompactTokenClaims, error)
 	RevokeJTI(ctx context.Context, jti string, expiresAt time.Time) error
 	IsJTIRevoked(ctx context.Context, jti string) (bool, error)
 }
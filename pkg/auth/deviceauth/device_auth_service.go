@@ -146,6 +146,21 @@ func (s *deviceAuthService) ValidateDeviceRefreshTokens(ctx context.Context, ref
 	return claims, nil
 }
 
+func (s *deviceAuthService) ValidateDeviceCompactToken(ctx context.Context, compactTokenStr string) (*CompactTokenClaims, error) {
+	claims, err := s.deviceTokenService.ParseCompactToken(compactTokenStr)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := s.IsJTIRevoked(ctx, claims.JTI)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, apperror.ErrExpiredToken.WithMessage("device compact token revoked")
+	}
+	return claims, nil
+}
+
 func (s *deviceAuthService) RevokeJTI(ctx context.Context, jti string, expiresAt time.Time) error {
 	if jti == "" {
 		return nil
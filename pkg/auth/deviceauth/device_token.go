@@ -14,6 +14,7 @@ type DeviceConnectionTokens struct {
 	RefreshTokenExpiresAt    time.Time `json:"refresh_token_expires_at"`
 	ConnectionTokenJTI       string    `json:"connection_token_jti"`
 	RefreshTokenJTI          string    `json:"refresh_token_jti"`
+	CompactToken             string    `json:"compact_token"`
 }
 
 type DeviceConnectionClaims struct {
@@ -34,13 +34,20 @@ func (s *deviceConnectionTokenService) GenerateTokens(deviceID uuid.UUID) (*Devi
 		return nil, err
 	}
 
+	connectionExpiresAt := time.Now().Add(s.config.DeviceConnectionTokenTTL)
+	compactToken, err := encodeCompactToken(s.config.AccessSecret, deviceID, connectionJTI, connectionExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
 	return &DeviceConnectionTokens{
 		ConnectionToken:          connectionToken,
 		ConnectionTokenJTI:       connectionJTI,
-		ConnectionTokenExpiresAt: time.Now().Add(s.config.DeviceConnectionTokenTTL),
+		ConnectionTokenExpiresAt: connectionExpiresAt,
 		RefreshToken:             refreshToken,
 		RefreshTokenJTI:          refreshJTI,
 		RefreshTokenExpiresAt:    time.Now().Add(s.config.DeviceRefreshTokenTTL),
+		CompactToken:             compactToken,
 	}, nil
 }
 
@@ -80,6 +87,10 @@ func (s *deviceConnectionTokenService) ParseRefreshToken(tokenStr string) (*Devi
 	return claims, nil
 }
 
+func (s *deviceConnectionTokenService) ParseCompactToken(tokenStr string) (*CompactTokenClaims, error) {
+	return decodeCompactToken(s.config.AccessSecret, tokenStr, s.config.Leeway)
+}
+
 func (s *deviceConnectionTokenService) generateConnectionToken(deviceID uuid.UUID) (string, string, error) {
 	jti := uuid.NewString()
 
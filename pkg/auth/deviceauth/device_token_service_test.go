@@ -1,6 +1,8 @@
 package deviceauth_test
 
 import (
+	"encoding/base64"
+	"strings"
 	"testing"
 	"time"
 
@@ -64,3 +66,49 @@ func TestExpiredToken(t *testing.T) {
 	_, err = service.ParseConnectionToken(tokens.ConnectionToken)
 	assert.Error(t, err)
 }
+
+const base64URLAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+func TestCompactToken(t *testing.T) {
+	cfg := &config.JwtConfig{
+		AccessSecret:             "access-secret-key",
+		RefreshSecret:            "refresh-secret-key",
+		DeviceConnectionTokenTTL: 30 * time.Minute,
+		DeviceRefreshTokenTTL:    1 * time.Hour,
+	}
+	service := deviceauth.NewDeviceConnectionTokenService(cfg, zap.NewNop())
+
+	deviceID := uuid.New()
+	tokens, err := service.GenerateTokens(deviceID)
+	assert.NoError(t, err)
+	assert.Len(t, tokens.CompactToken, 71)
+
+	claims, err := service.ParseCompactToken(tokens.CompactToken)
+	assert.NoError(t, err)
+	assert.Equal(t, deviceID, claims.DeviceID)
+	assert.Equal(t, tokens.ConnectionTokenJTI, claims.JTI)
+
+	// flip a byte of the signature
+	raw, err := base64.RawURLEncoding.DecodeString(tokens.CompactToken)
+	assert.NoError(t, err)
+	raw[len(raw)-1] ^= 0xff
+	_, err = service.ParseCompactToken(base64.RawURLEncoding.EncodeToString(raw))
+	assert.Error(t, err)
+
+	// same bytes with non-zero padding bits in the last character
+	padded := []byte(tokens.CompactToken)
+	padded[len(padded)-1] = base64URLAlphabet[strings.IndexByte(base64URLAlphabet, padded[len(padded)-1])|1]
+	_, err = service.ParseCompactToken(string(padded))
+	assert.Error(t, err)
+
+	// signed with a different secret
+	other := deviceauth.NewDeviceConnectionTokenService(&config.JwtConfig{AccessSecret: "other", DeviceConnectionTokenTTL: time.Minute}, zap.NewNop())
+	_, err = other.ParseCompactToken(tokens.CompactToken)
+	assert.Error(t, err)
+
+	expiredService := deviceauth.NewDeviceConnectionTokenService(&config.JwtConfig{AccessSecret: "access-secret-key", DeviceConnectionTokenTTL: -1 * time.Minute}, zap.NewNop())
+	expired, err := expiredService.GenerateTokens(deviceID)
+	assert.NoError(t, err)
+	_, err = expiredService.ParseCompactToken(expired.CompactToken)
+	assert.Error(t, err)
+}
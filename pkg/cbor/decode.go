@@ -0,0 +1,375 @@
+// Package cbor is a minimal RFC 8949 decoder covering the subset constrained devices send for telemetry:
+// integers, floats (half/single/double), text and byte strings, arrays, maps, simple values and tags.
+// Maps are decoded into map[string]interface{}; integer keys are converted to their decimal form so
+// compact payloads like {1: 21.5} line up with the sensor schema field codes.
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const maxNestingDepth = 16
+
+const (
+	majorUnsigned = 0
+	majorNegative = 1
+	majorBytes    = 2
+	majorText     = 3
+	majorArray    = 4
+	majorMap      = 5
+	majorTag      = 6
+	majorSimple   = 7
+
+	additionalIndefinite = 31
+	breakMarker          = 0xff
+)
+
+var (
+	ErrUnexpectedEOF  = errors.New("cbor: unexpected end of data")
+	ErrTrailingData   = errors.New("cbor: trailing data after top-level item")
+	ErrMaxDepth       = errors.New("cbor: maximum nesting depth exceeded")
+	ErrInvalidMapKey  = errors.New("cbor: unsupported map key type")
+	ErrUnsupported    = errors.New("cbor: unsupported item")
+	ErrIntegerOverrun = errors.New("cbor: integer overflows int64")
+)
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+// Unmarshal decodes a single CBOR data item.
+func Unmarshal(data []byte) (interface{}, error) {
+	d := &decoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, ErrTrailingData
+	}
+	return v, nil
+}
+
+// UnmarshalMap decodes a single CBOR item and requires it to be a map.
+func UnmarshalMap(data []byte) (map[string]interface{}, error) {
+	v, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cbor: expected map at top level, got %T", v)
+	}
+	return m, nil
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, ErrUnexpectedEOF
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *decoder) readN(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, ErrUnexpectedEOF
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// readArgument reads the argument that follows the initial byte for the given additional info.
+func (d *decoder) readArgument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		b, err := d.readN(1)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(b[0]), nil
+	case info == 25:
+		b, err := d.readN(2)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err := d.readN(4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err := d.readN(8)
+		if err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(b), nil
+	default:
+		return 0, fmt.Errorf("cbor: invalid additional information %d", info)
+	}
+}
+
+func (d *decoder) decode(depth int) (interface{}, error) {
+	if depth > maxNestingDepth {
+		return nil, ErrMaxDepth
+	}
+
+	initial, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	major := initial >> 5
+	info := initial & 0x1f
+
+	switch major {
+	case majorUnsigned:
+		n, err := d.readArgument(info)
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return nil, ErrIntegerOverrun
+		}
+		return int64(n), nil
+
+	case majorNegative:
+		n, err := d.readArgument(info)
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return nil, ErrIntegerOverrun
+		}
+		return -1 - int64(n), nil
+
+	case majorBytes, majorText:
+		b, err := d.decodeString(major, info)
+		if err != nil {
+			return nil, err
+		}
+		if major == majorText {
+			return string(b), nil
+		}
+		return b, nil
+
+	case majorArray:
+		return d.decodeArray(info, depth)
+
+	case majorMap:
+		return d.decodeMap(info, depth)
+
+	case majorTag:
+		// tags carry semantic hints only, the telemetry path uses the enclosed value as is
+		if _, err := d.readArgument(info); err != nil {
+			return nil, err
+		}
+		return d.decode(depth + 1)
+
+	default:
+		return d.decodeSimple(info)
+	}
+}
+
+func (d *decoder) decodeString(major byte, info byte) ([]byte, error) {
+	if info != additionalIndefinite {
+		n, err := d.readArgument(info)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.readN(n)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(b))
+		copy(out, b)
+		return out, nil
+	}
+
+	// indefinite length string, concatenation of definite length chunks of the same major type
+	var out []byte
+	for {
+		if d.pos >= len(d.data) {
+			return nil, ErrUnexpectedEOF
+		}
+		if d.data[d.pos] == breakMarker {
+			d.pos++
+			return out, nil
+		}
+		chunkHead, _ := d.readByte()
+		if chunkHead>>5 != major || chunkHead&0x1f == additionalIndefinite {
+			return nil, errors.New("cbor: invalid chunk in indefinite length string")
+		}
+		chunk, err := d.decodeString(major, chunkHead&0x1f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, chunk...)
+	}
+}
+
+func (d *decoder) decodeArray(info byte, depth int) ([]interface{}, error) {
+	if info == additionalIndefinite {
+		out := make([]interface{}, 0)
+		for {
+			if d.pos >= len(d.data) {
+				return nil, ErrUnexpectedEOF
+			}
+			if d.data[d.pos] == breakMarker {
+				d.pos++
+				return out, nil
+			}
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+	}
+
+	n, err := d.readArgument(info)
+	if err != nil {
+		return nil, err
+	}
+	// every item takes at least one byte, reject lengths the buffer can't hold before allocating
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, ErrUnexpectedEOF
+	}
+	out := make([]interface{}, 0, n)
+	for i := uint64(0); i < n; i++ {
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (d *decoder) decodeMap(info byte, depth int) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+
+	readPair := func() error {
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return err
+		}
+		key, err := mapKey(k)
+		if err != nil {
+			return err
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return err
+		}
+		out[key] = v
+		return nil
+	}
+
+	if info == additionalIndefinite {
+		for {
+			if d.pos >= len(d.data) {
+				return nil, ErrUnexpectedEOF
+			}
+			if d.data[d.pos] == breakMarker {
+				d.pos++
+				return out, nil
+			}
+			if err := readPair(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	n, err := d.readArgument(info)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, ErrUnexpectedEOF
+	}
+	for i := uint64(0); i < n; i++ {
+		if err := readPair(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (d *decoder) decodeSimple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23: // null, undefined
+		return nil, nil
+	case 25:
+		b, err := d.readN(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfToFloat64(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.readN(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.readN(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case additionalIndefinite:
+		return nil, errors.New("cbor: unexpected break marker")
+	default:
+		return nil, fmt.Errorf("%w: simple value %d", ErrUnsupported, info)
+	}
+}
+
+func mapKey(k interface{}) (string, error) {
+	switch v := k.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	default:
+		return "", fmt.Errorf("%w: %T", ErrInvalidMapKey, k)
+	}
+}
+
+// halfToFloat64 converts an IEEE 754 half precision value, see RFC 8949 appendix D.
+func halfToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var val float64
+	switch exp {
+	case 0:
+		val = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			val = math.Inf(1)
+		} else {
+			val = math.NaN()
+		}
+	default:
+		val = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -val
+	}
+	return val
+}
@@ -0,0 +1,52 @@
+package cbor_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/pkg/cbor"
+)
+
+func TestUnmarshalTelemetryDocument(t *testing.T) {
+	// {"sensor_code": "env-002", "schema_version": 1, "data": {1: 21.5, 2: -3, 3: true}}
+	data := []byte{
+		0xa3,
+		0x6b, 's', 'e', 'n', 's', 'o', 'r', '_', 'c', 'o', 'd', 'e',
+		0x67, 'e', 'n', 'v', '-', '0', '0', '2',
+		0x6e, 's', 'c', 'h', 'e', 'm', 'a', '_', 'v', 'e', 'r', 's', 'i', 'o', 'n',
+		0x01,
+		0x64, 'd', 'a', 't', 'a',
+		0xa3,
+		0x01, 0xf9, 0x4d, 0x60, // half float 21.5
+		0x02, 0x22, // -3
+		0x03, 0xf5, // true
+	}
+
+	doc, err := cbor.UnmarshalMap(data)
+	require.NoError(t, err)
+	assert.Equal(t, "env-002", doc["sensor_code"])
+	assert.Equal(t, int64(1), doc["schema_version"])
+	assert.Equal(t, map[string]interface{}{"1": 21.5, "2": int64(-3), "3": true}, doc["data"])
+}
+
+func TestUnmarshalIndefiniteAndErrors(t *testing.T) {
+	// indefinite array [1, "a"] and float64 1.1
+	v, err := cbor.Unmarshal([]byte{0x9f, 0x01, 0x61, 'a', 0xff})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(1), "a"}, v)
+
+	v, err = cbor.Unmarshal([]byte{0xfb, 0x3f, 0xf1, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a})
+	require.NoError(t, err)
+	assert.Equal(t, 1.1, v)
+
+	_, err = cbor.Unmarshal([]byte{0x62, 'a'})
+	assert.ErrorIs(t, err, cbor.ErrUnexpectedEOF)
+
+	_, err = cbor.Unmarshal([]byte{0x01, 0x02})
+	assert.ErrorIs(t, err, cbor.ErrTrailingData)
+
+	// claims a huge array without the bytes to back it
+	_, err = cbor.Unmarshal([]byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	assert.ErrorIs(t, err, cbor.ErrUnexpectedEOF)
+}
@@ -0,0 +1,416 @@
+// Package coap implements the message layer of RFC 7252 and a small UDP server on top of it.
+// Only what the ingestion endpoint needs is implemented, block-wise transfer, observe and DTLS are out of scope.
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const ProtocolVersion = 1
+
+type Type uint8
+
+const (
+	TypeConfirmable     Type = 0
+	TypeNonConfirmable  Type = 1
+	TypeAcknowledgement Type = 2
+	TypeReset           Type = 3
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeConfirmable:
+		return "CON"
+	case TypeNonConfirmable:
+		return "NON"
+	case TypeAcknowledgement:
+		return "ACK"
+	case TypeReset:
+		return "RST"
+	default:
+		return fmt.Sprintf("Type(%d)", uint8(t))
+	}
+}
+
+// Code is the 8 bit class.detail code of a message.
+type Code uint8
+
+func NewCode(class uint8, detail uint8) Code {
+	return Code(class<<5 | detail&0x1f)
+}
+
+func (c Code) Class() uint8  { return uint8(c) >> 5 }
+func (c Code) Detail() uint8 { return uint8(c) & 0x1f }
+
+func (c Code) String() string {
+	return fmt.Sprintf("%d.%02d", c.Class(), c.Detail())
+}
+
+const (
+	CodeEmpty Code = 0
+
+	// request methods
+	CodeGET    Code = 1
+	CodePOST   Code = 2
+	CodePUT    Code = 3
+	CodeDELETE Code = 4
+
+	// response codes
+	CodeCreated                  Code = 2<<5 | 1
+	CodeChanged                  Code = 2<<5 | 4
+	CodeContent                  Code = 2<<5 | 5
+	CodeBadRequest               Code = 4<<5 | 0
+	CodeUnauthorized             Code = 4<<5 | 1
+	CodeBadOption                Code = 4<<5 | 2
+	CodeForbidden                Code = 4<<5 | 3
+	CodeNotFound                 Code = 4<<5 | 4
+	CodeMethodNotAllowed         Code = 4<<5 | 5
+	CodeRequestEntityTooLarge    Code = 4<<5 | 13
+	CodeUnprocessableEntity      Code = 4<<5 | 22 // RFC 8132
+	CodeUnsupportedContentFormat Code = 4<<5 | 15
+	CodeInternalServerError      Code = 5<<5 | 0
+	CodeServiceUnavailable       Code = 5<<5 | 3
+)
+
+func (c Code) IsRequest() bool { return c.Class() == 0 && c != CodeEmpty }
+
+type OptionID uint16
+
+const (
+	OptionIfMatch       OptionID = 1
+	OptionURIHost       OptionID = 3
+	OptionETag          OptionID = 4
+	OptionIfNoneMatch   OptionID = 5
+	OptionObserve       OptionID = 6
+	OptionURIPort       OptionID = 7
+	OptionLocationPath  OptionID = 8
+	OptionURIPath       OptionID = 11
+	OptionContentFormat OptionID = 12
+	OptionMaxAge        OptionID = 14
+	OptionURIQuery      OptionID = 15
+	OptionAccept        OptionID = 17
+	OptionLocationQuery OptionID = 20
+	OptionProxyURI      OptionID = 35
+	OptionProxyScheme   OptionID = 39
+	OptionSize1         OptionID = 60
+)
+
+// IsCritical reports whether a recipient must understand the option (odd option numbers).
+func (o OptionID) IsCritical() bool { return o&1 == 1 }
+
+var knownOptions = map[OptionID]struct{}{
+	OptionIfMatch: {}, OptionURIHost: {}, OptionETag: {}, OptionIfNoneMatch: {}, OptionObserve: {},
+	OptionURIPort: {}, OptionLocationPath: {}, OptionURIPath: {}, OptionContentFormat: {},
+	OptionMaxAge: {}, OptionURIQuery: {}, OptionAccept: {}, OptionLocationQuery: {},
+	OptionProxyURI: {}, OptionProxyScheme: {}, OptionSize1: {},
+}
+
+// Content formats registered in RFC 7252 section 12.3 and RFC 8949.
+type ContentFormat uint16
+
+const (
+	ContentFormatTextPlain ContentFormat = 0
+	ContentFormatJSON      ContentFormat = 50
+	ContentFormatCBOR      ContentFormat = 60
+)
+
+type Option struct {
+	ID    OptionID
+	Value []byte
+}
+
+type Message struct {
+	Type      Type
+	Code      Code
+	MessageID uint16
+	Token     []byte
+	Options   []Option
+	Payload   []byte
+}
+
+var (
+	ErrMessageTooShort   = errors.New("coap: message too short")
+	ErrInvalidVersion    = errors.New("coap: unsupported protocol version")
+	ErrInvalidTokenLen   = errors.New("coap: invalid token length")
+	ErrInvalidOption     = errors.New("coap: invalid option encoding")
+	ErrEmptyPayload      = errors.New("coap: payload marker followed by empty payload")
+	ErrInvalidEmptyMsg   = errors.New("coap: empty message must not carry token, options or payload")
+	ErrOptionValueTooBig = errors.New("coap: option value too long")
+)
+
+const maxOptionValueLen = 1034 // largest option defined by RFC 7252 (Proxy-Uri)
+
+// Unmarshal parses a datagram into a message. Format errors are reported so the caller can reject
+// confirmable messages with a reset as required by section 4.2.
+func Unmarshal(data []byte) (*Message, error) {
+	if len(data) < 4 {
+		return nil, ErrMessageTooShort
+	}
+	if data[0]>>6 != ProtocolVersion {
+		return nil, ErrInvalidVersion
+	}
+
+	msg := &Message{
+		Type:      Type(data[0] >> 4 & 0x03),
+		Code:      Code(data[1]),
+		MessageID: binary.BigEndian.Uint16(data[2:4]),
+	}
+
+	tkl := int(data[0] & 0x0f)
+	if tkl > 8 {
+		return nil, ErrInvalidTokenLen
+	}
+	if len(data) < 4+tkl {
+		return nil, ErrMessageTooShort
+	}
+	if tkl > 0 {
+		msg.Token = append([]byte(nil), data[4:4+tkl]...)
+	}
+
+	pos := 4 + tkl
+	var lastID uint16
+	for pos < len(data) {
+		if data[pos] == 0xff {
+			pos++
+			if pos == len(data) {
+				return nil, ErrEmptyPayload
+			}
+			msg.Payload = append([]byte(nil), data[pos:]...)
+			break
+		}
+
+		head := data[pos]
+		pos++
+
+		delta, n, err := readOptionNibble(head>>4, data[pos:])
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+		length, n, err := readOptionNibble(head&0x0f, data[pos:])
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+
+		if length > maxOptionValueLen {
+			return nil, ErrOptionValueTooBig
+		}
+		if length > len(data)-pos {
+			return nil, ErrInvalidOption
+		}
+		id := int(lastID) + delta
+		if id > 0xffff {
+			return nil, ErrInvalidOption
+		}
+		lastID = uint16(id)
+		msg.Options = append(msg.Options, Option{
+			ID:    OptionID(id),
+			Value: append([]byte(nil), data[pos:pos+length]...),
+		})
+		pos += length
+	}
+
+	if msg.Code == CodeEmpty && (tkl != 0 || len(msg.Options) != 0 || len(msg.Payload) != 0) {
+		return nil, ErrInvalidEmptyMsg
+	}
+	return msg, nil
+}
+
+// readOptionNibble decodes the extended delta/length encoding of section 3.1.
+func readOptionNibble(nibble byte, rest []byte) (int, int, error) {
+	switch nibble {
+	case 13:
+		if len(rest) < 1 {
+			return 0, 0, ErrInvalidOption
+		}
+		return int(rest[0]) + 13, 1, nil
+	case 14:
+		if len(rest) < 2 {
+			return 0, 0, ErrInvalidOption
+		}
+		return int(binary.BigEndian.Uint16(rest[:2])) + 269, 2, nil
+	case 15:
+		// reserved for the payload marker
+		return 0, 0, ErrInvalidOption
+	default:
+		return int(nibble), 0, nil
+	}
+}
+
+// Marshal encodes the message, options are emitted in ascending order as required by the delta encoding.
+func (m *Message) Marshal() ([]byte, error) {
+	if len(m.Token) > 8 {
+		return nil, ErrInvalidTokenLen
+	}
+
+	buf := make([]byte, 4, 4+len(m.Token)+len(m.Payload)+16)
+	buf[0] = ProtocolVersion<<6 | byte(m.Type&0x03)<<4 | byte(len(m.Token))
+	buf[1] = byte(m.Code)
+	binary.BigEndian.PutUint16(buf[2:4], m.MessageID)
+	buf = append(buf, m.Token...)
+
+	opts := make([]Option, len(m.Options))
+	copy(opts, m.Options)
+	sort.SliceStable(opts, func(i, j int) bool { return opts[i].ID < opts[j].ID })
+
+	var lastID OptionID
+	for _, opt := range opts {
+		if len(opt.Value) > maxOptionValueLen {
+			return nil, ErrOptionValueTooBig
+		}
+		delta := int(opt.ID - lastID)
+		lastID = opt.ID
+
+		deltaNibble, deltaExt := encodeOptionNibble(delta)
+		lengthNibble, lengthExt := encodeOptionNibble(len(opt.Value))
+
+		buf = append(buf, deltaNibble<<4|lengthNibble)
+		buf = append(buf, deltaExt...)
+		buf = append(buf, lengthExt...)
+		buf = append(buf, opt.Value...)
+	}
+
+	if len(m.Payload) > 0 {
+		buf = append(buf, 0xff)
+		buf = append(buf, m.Payload...)
+	}
+	return buf, nil
+}
+
+func encodeOptionNibble(v int) (byte, []byte) {
+	switch {
+	case v < 13:
+		return byte(v), nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		ext := make([]byte, 2)
+		binary.BigEndian.PutUint16(ext, uint16(v-269))
+		return 14, ext
+	}
+}
+
+// Option returns the first value of the given option.
+func (m *Message) Option(id OptionID) ([]byte, bool) {
+	for _, opt := range m.Options {
+		if opt.ID == id {
+			return opt.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (m *Message) AddOption(id OptionID, value []byte) {
+	m.Options = append(m.Options, Option{ID: id, Value: value})
+}
+
+func (m *Message) SetUintOption(id OptionID, v uint32) {
+	m.AddOption(id, EncodeUint(v))
+}
+
+// Path joins the Uri-Path options, e.g. "telemetry" or "v1/telemetry".
+func (m *Message) Path() string {
+	segments := make([]string, 0, 2)
+	for _, opt := range m.Options {
+		if opt.ID == OptionURIPath {
+			segments = append(segments, string(opt.Value))
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Query returns the value of a "key=value" Uri-Query option.
+func (m *Message) Query(key string) (string, bool) {
+	prefix := key + "="
+	for _, opt := range m.Options {
+		if opt.ID != OptionURIQuery {
+			continue
+		}
+		if v, ok := strings.CutPrefix(string(opt.Value), prefix); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// ContentFormat returns the declared content format, ok is false when the option is absent.
+func (m *Message) ContentFormat() (ContentFormat, bool) {
+	v, ok := m.Option(OptionContentFormat)
+	if !ok {
+		return 0, false
+	}
+	return ContentFormat(DecodeUint(v)), true
+}
+
+// UnrecognizedCriticalOption returns the first critical option the implementation doesn't know.
+func (m *Message) UnrecognizedCriticalOption() (OptionID, bool) {
+	for _, opt := range m.Options {
+		if _, known := knownOptions[opt.ID]; !known && opt.ID.IsCritical() {
+			return opt.ID, true
+		}
+	}
+	return 0, false
+}
+
+// EncodeUint encodes an uint option value with the minimal number of bytes (section 3.2).
+func EncodeUint(v uint32) []byte {
+	switch {
+	case v == 0:
+		return []byte{}
+	case v <= 0xff:
+		return []byte{byte(v)}
+	case v <= 0xffff:
+		return []byte{byte(v >> 8), byte(v)}
+	case v <= 0xffffff:
+		return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	default:
+		return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+	}
+}
+
+func DecodeUint(b []byte) uint32 {
+	var v uint32
+	for _, x := range b {
+		v = v<<8 | uint32(x)
+	}
+	return v
+}
+
+// CodeFromHTTPStatus maps an http status to the matching response code (RFC 8075 section 7).
+func CodeFromHTTPStatus(status int) Code {
+	switch status {
+	case 200, 204:
+		return CodeChanged
+	case 201:
+		return CodeCreated
+	case 400:
+		return CodeBadRequest
+	case 401:
+		return CodeUnauthorized
+	case 403:
+		return CodeForbidden
+	case 404:
+		return CodeNotFound
+	case 405:
+		return CodeMethodNotAllowed
+	case 413:
+		return CodeRequestEntityTooLarge
+	case 415:
+		return CodeUnsupportedContentFormat
+	case 422:
+		return CodeUnprocessableEntity
+	case 503:
+		return CodeServiceUnavailable
+	}
+	switch {
+	case status >= 400 && status < 500:
+		return CodeBadRequest
+	default:
+		return CodeInternalServerError
+	}
+}
@@ -0,0 +1,337 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/pkg/apperror"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaxMessageSize   = 1152 // recommended upper bound from RFC 7252 section 4.6
+	defaultExchangeLifetime = 247 * time.Second
+	defaultHandlerTimeout   = 10 * time.Second
+	defaultMaxInFlight      = 64
+)
+
+// Response is what a handler returns, the server wraps it into an ACK (piggybacked) or NON message.
+type Response struct {
+	Code          Code
+	ContentFormat ContentFormat
+	Payload       []byte
+}
+
+// Request is an incoming CoAP request with the address it arrived from.
+type Request struct {
+	*Message
+	RemoteAddr net.Addr
+}
+
+type HandlerFunc func(ctx context.Context, req *Request) *Response
+
+// exchange tracks a request for deduplication during EXCHANGE_LIFETIME (section 4.5).
+// A nil reply means the request is still being handled.
+type exchange struct {
+	reply     []byte
+	expiresAt time.Time
+}
+
+type Server struct {
+	config    *config.CoapConfig
+	routes    map[string]map[Code]HandlerFunc
+	exchanges map[string]*exchange
+	mu        sync.Mutex
+	inFlight  chan struct{}
+	messageID atomic.Uint32
+	conn      net.PacketConn
+	logger    *zap.Logger
+}
+
+func NewServer(cfg *config.CoapConfig, baseLogger *zap.Logger) *Server {
+	c := *cfg
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = defaultMaxMessageSize
+	}
+	if c.ExchangeLifetime <= 0 {
+		c.ExchangeLifetime = defaultExchangeLifetime
+	}
+	if c.HandlerTimeout <= 0 {
+		c.HandlerTimeout = defaultHandlerTimeout
+	}
+	if c.MaxInFlight <= 0 {
+		c.MaxInFlight = defaultMaxInFlight
+	}
+
+	s := &Server{
+		config:    &c,
+		routes:    make(map[string]map[Code]HandlerFunc),
+		exchanges: make(map[string]*exchange),
+		inFlight:  make(chan struct{}, c.MaxInFlight),
+		logger:    logger.Named(baseLogger, "CoapServer"),
+	}
+	// message ids of server initiated messages start at a random value (section 4.4)
+	s.messageID.Store(rand.Uint32N(0xffff))
+	return s
+}
+
+// Handle registers a handler for a method on a Uri-Path, e.g. Handle(CodePOST, "telemetry", h).
+func (s *Server) Handle(method Code, path string, h HandlerFunc) {
+	if _, ok := s.routes[path]; !ok {
+		s.routes[path] = make(map[Code]HandlerFunc)
+	}
+	s.routes[path][method] = h
+}
+
+// ListenAndServe listens on the configured UDP address and serves until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", s.config.Address)
+	if err != nil {
+		return apperror.ErrStart.WithMessagef("failed to listen for coap on %s", s.config.Address).Wrap(err)
+	}
+	return s.Serve(ctx, conn)
+}
+
+// Serve reads datagrams from conn until ctx is cancelled, the connection is closed when Serve returns.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	s.conn = conn
+	s.logger.Info("coap server listening", zap.String("address", conn.LocalAddr().String()))
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.sweepExchanges(ctx)
+	}()
+
+	// one extra byte to detect datagrams over the size limit
+	buf := make([]byte, s.config.MaxMessageSize+1)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				s.logger.Info("coap server stopped")
+				return nil
+			}
+			s.logger.Warn("failed to read coap datagram", zap.Error(err))
+			continue
+		}
+
+		datagram := make([]byte, n)
+		copy(datagram, buf[:n])
+
+		select {
+		case s.inFlight <- struct{}{}:
+		default:
+			s.rejectOverload(datagram, addr)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-s.inFlight }()
+			s.handleDatagram(ctx, datagram, addr)
+		}()
+	}
+}
+
+func (s *Server) handleDatagram(ctx context.Context, data []byte, addr net.Addr) {
+	if len(data) > s.config.MaxMessageSize {
+		s.logger.Debug("coap datagram exceeds max message size", zap.String("remote_addr", addr.String()), zap.Int("size", len(data)))
+		if head, ok := peekHeader(data); ok && head.Type == TypeConfirmable {
+			s.reply(addr, &Message{Type: TypeAcknowledgement, Code: CodeRequestEntityTooLarge, MessageID: head.MessageID, Token: head.Token})
+		}
+		return
+	}
+
+	msg, err := Unmarshal(data)
+	if err != nil {
+		s.logger.Debug("malformed coap message", zap.String("remote_addr", addr.String()), zap.Error(err))
+		// confirmable messages with format errors are rejected with a reset, others silently ignored (section 4.2, 4.3)
+		if head, ok := peekHeader(data); ok && head.Type == TypeConfirmable {
+			s.reply(addr, &Message{Type: TypeReset, MessageID: head.MessageID})
+		}
+		return
+	}
+
+	switch msg.Type {
+	case TypeAcknowledgement, TypeReset:
+		// the server never sends confirmable messages, nothing is waiting for these
+		return
+	}
+
+	if !msg.Code.IsRequest() {
+		// empty confirmable message is a ping, responses sent to a server are rejected the same way
+		if msg.Type == TypeConfirmable {
+			s.reply(addr, &Message{Type: TypeReset, MessageID: msg.MessageID})
+		}
+		return
+	}
+
+	key := fmt.Sprintf("%s/%d", addr.String(), msg.MessageID)
+	if duplicate, cached := s.lookupExchange(key); duplicate {
+		if cached != nil {
+			s.logger.Debug("retransmitting cached coap response", zap.String("remote_addr", addr.String()), zap.Uint16("message_id", msg.MessageID))
+			s.write(addr, cached)
+		}
+		return
+	}
+
+	resp := s.dispatch(ctx, &Request{Message: msg, RemoteAddr: addr})
+
+	out := &Message{
+		Code:  resp.Code,
+		Token: msg.Token,
+	}
+	if msg.Type == TypeConfirmable {
+		out.Type = TypeAcknowledgement
+		out.MessageID = msg.MessageID
+	} else {
+		out.Type = TypeNonConfirmable
+		out.MessageID = s.nextMessageID()
+	}
+	if len(resp.Payload) > 0 {
+		out.SetUintOption(OptionContentFormat, uint32(resp.ContentFormat))
+		out.Payload = resp.Payload
+	}
+
+	raw, err := out.Marshal()
+	if err != nil {
+		s.logger.Error("failed to encode coap response", zap.Error(err))
+		s.forgetExchange(key)
+		return
+	}
+	s.completeExchange(key, raw)
+	s.write(addr, raw)
+}
+
+func (s *Server) dispatch(ctx context.Context, req *Request) *Response {
+	if opt, ok := req.UnrecognizedCriticalOption(); ok {
+		return &Response{Code: CodeBadOption, Payload: []byte(fmt.Sprintf("unrecognized critical option %d", opt))}
+	}
+
+	methods, ok := s.routes[req.Path()]
+	if !ok {
+		return &Response{Code: CodeNotFound}
+	}
+	handler, ok := methods[req.Code]
+	if !ok {
+		return &Response{Code: CodeMethodNotAllowed}
+	}
+
+	handlerCtx, cancel := context.WithTimeout(ctx, s.config.HandlerTimeout)
+	defer cancel()
+
+	resp := handler(handlerCtx, req)
+	if resp == nil {
+		return &Response{Code: CodeInternalServerError}
+	}
+	return resp
+}
+
+// lookupExchange reports whether the message was already seen and returns the cached reply if any.
+// New exchanges are registered as in progress.
+func (s *Server) lookupExchange(key string) (bool, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ex, ok := s.exchanges[key]; ok && time.Now().Before(ex.expiresAt) {
+		return true, ex.reply
+	}
+	s.exchanges[key] = &exchange{expiresAt: time.Now().Add(s.config.ExchangeLifetime)}
+	return false, nil
+}
+
+func (s *Server) completeExchange(key string, reply []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ex, ok := s.exchanges[key]; ok {
+		ex.reply = reply
+	}
+}
+
+func (s *Server) forgetExchange(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.exchanges, key)
+}
+
+func (s *Server) sweepExchanges(ctx context.Context) {
+	ticker := time.NewTicker(s.config.ExchangeLifetime / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, ex := range s.exchanges {
+				if now.After(ex.expiresAt) {
+					delete(s.exchanges, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *Server) rejectOverload(data []byte, addr net.Addr) {
+	s.logger.Warn("coap server at max in-flight requests, rejecting message", zap.String("remote_addr", addr.String()))
+	if head, ok := peekHeader(data); ok && head.Type == TypeConfirmable {
+		s.reply(addr, &Message{Type: TypeAcknowledgement, Code: CodeServiceUnavailable, MessageID: head.MessageID, Token: head.Token})
+	}
+}
+
+func (s *Server) reply(addr net.Addr, msg *Message) {
+	raw, err := msg.Marshal()
+	if err != nil {
+		s.logger.Error("failed to encode coap message", zap.Error(err))
+		return
+	}
+	s.write(addr, raw)
+}
+
+func (s *Server) write(addr net.Addr, raw []byte) {
+	if _, err := s.conn.WriteTo(raw, addr); err != nil {
+		s.logger.Warn("failed to write coap datagram", zap.String("remote_addr", addr.String()), zap.Error(err))
+	}
+}
+
+func (s *Server) nextMessageID() uint16 {
+	return uint16(s.messageID.Add(1))
+}
+
+// peekHeader reads type, message id and token of a datagram that failed to parse completely.
+func peekHeader(data []byte) (*Message, bool) {
+	if len(data) < 4 || data[0]>>6 != ProtocolVersion {
+		return nil, false
+	}
+	head := &Message{
+		Type:      Type(data[0] >> 4 & 0x03),
+		MessageID: uint16(data[2])<<8 | uint16(data[3]),
+	}
+	if tkl := int(data[0] & 0x0f); tkl <= 8 && len(data) >= 4+tkl {
+		head.Token = data[4 : 4+tkl]
+	}
+	return head, true
+}
@@ -0,0 +1,169 @@
+package coap_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/pkg/coap"
+	"go.uber.org/zap"
+)
+
+func startTestServer(t *testing.T, handler coap.HandlerFunc) net.Addr {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := coap.NewServer(&config.CoapConfig{ExchangeLifetime: time.Minute}, zap.NewNop())
+	server.Handle(coap.CodePOST, "telemetry", handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = server.Serve(ctx, conn)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return conn.LocalAddr()
+}
+
+func exchange(t *testing.T, client net.Conn, req *coap.Message) *coap.Message {
+	t.Helper()
+
+	raw, err := req.Marshal()
+	require.NoError(t, err)
+	_, err = client.Write(raw)
+	require.NoError(t, err)
+
+	buf := make([]byte, 1500)
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(2*time.Second)))
+	n, err := client.Read(buf)
+	require.NoError(t, err)
+
+	resp, err := coap.Unmarshal(buf[:n])
+	require.NoError(t, err)
+	return resp
+}
+
+func telemetryRequest(msgType coap.Type, messageID uint16, payload string) *coap.Message {
+	req := &coap.Message{
+		Type:      msgType,
+		Code:      coap.CodePOST,
+		MessageID: messageID,
+		Token:     []byte{0xca, 0xfe},
+		Payload:   []byte(payload),
+	}
+	req.AddOption(coap.OptionURIPath, []byte("telemetry"))
+	req.AddOption(coap.OptionURIQuery, []byte("t=compact-token"))
+	req.SetUintOption(coap.OptionContentFormat, uint32(coap.ContentFormatJSON))
+	return req
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	req := telemetryRequest(coap.TypeConfirmable, 0x1234, `{"sensor_id":"x"}`)
+	// long option value to exercise the extended length encoding
+	req.AddOption(coap.OptionURIQuery, []byte("padding="+string(make([]byte, 300))))
+
+	raw, err := req.Marshal()
+	require.NoError(t, err)
+
+	decoded, err := coap.Unmarshal(raw)
+	require.NoError(t, err)
+	assert.Equal(t, coap.TypeConfirmable, decoded.Type)
+	assert.Equal(t, coap.CodePOST, decoded.Code)
+	assert.Equal(t, uint16(0x1234), decoded.MessageID)
+	assert.Equal(t, []byte{0xca, 0xfe}, decoded.Token)
+	assert.Equal(t, "telemetry", decoded.Path())
+	token, ok := decoded.Query("t")
+	assert.True(t, ok)
+	assert.Equal(t, "compact-token", token)
+	format, ok := decoded.ContentFormat()
+	assert.True(t, ok)
+	assert.Equal(t, coap.ContentFormatJSON, format)
+	assert.Equal(t, req.Payload, decoded.Payload)
+
+	_, err = coap.Unmarshal([]byte{0x40, 0x02, 0x00, 0x01, 0xff})
+	assert.ErrorIs(t, err, coap.ErrEmptyPayload)
+}
+
+func TestServerConfirmableRequest(t *testing.T) {
+	var calls atomic.Int32
+	addr := startTestServer(t, func(ctx context.Context, req *coap.Request) *coap.Response {
+		calls.Add(1)
+		token, _ := req.Query("t")
+		assert.Equal(t, "compact-token", token)
+		return &coap.Response{Code: coap.CodeCreated}
+	})
+
+	client, err := net.Dial("udp", addr.String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	req := telemetryRequest(coap.TypeConfirmable, 42, `{}`)
+	resp := exchange(t, client, req)
+	assert.Equal(t, coap.TypeAcknowledgement, resp.Type)
+	assert.Equal(t, coap.CodeCreated, resp.Code)
+	assert.Equal(t, uint16(42), resp.MessageID)
+	assert.Equal(t, req.Token, resp.Token)
+
+	// retransmission of the same message id gets the cached ack without reprocessing
+	dup := exchange(t, client, req)
+	assert.Equal(t, resp, dup)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestServerNonConfirmableRequest(t *testing.T) {
+	addr := startTestServer(t, func(ctx context.Context, req *coap.Request) *coap.Response {
+		return &coap.Response{Code: coap.CodeBadRequest, ContentFormat: coap.ContentFormatTextPlain, Payload: []byte("bad payload")}
+	})
+
+	client, err := net.Dial("udp", addr.String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	req := telemetryRequest(coap.TypeNonConfirmable, 7, `{}`)
+	resp := exchange(t, client, req)
+	assert.Equal(t, coap.TypeNonConfirmable, resp.Type)
+	assert.Equal(t, coap.CodeBadRequest, resp.Code)
+	assert.Equal(t, req.Token, resp.Token)
+	assert.Equal(t, "bad payload", string(resp.Payload))
+}
+
+func TestServerRejections(t *testing.T) {
+	addr := startTestServer(t, func(ctx context.Context, req *coap.Request) *coap.Response {
+		return &coap.Response{Code: coap.CodeCreated}
+	})
+
+	client, err := net.Dial("udp", addr.String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	// unknown path
+	notFound := &coap.Message{Type: coap.TypeConfirmable, Code: coap.CodePOST, MessageID: 1}
+	notFound.AddOption(coap.OptionURIPath, []byte("missing"))
+	assert.Equal(t, coap.CodeNotFound, exchange(t, client, notFound).Code)
+
+	// wrong method
+	get := telemetryRequest(coap.TypeConfirmable, 2, "")
+	get.Code = coap.CodeGET
+	assert.Equal(t, coap.CodeMethodNotAllowed, exchange(t, client, get).Code)
+
+	// unrecognized critical option
+	critical := telemetryRequest(coap.TypeConfirmable, 3, `{}`)
+	critical.AddOption(coap.OptionID(65001), []byte{1})
+	assert.Equal(t, coap.CodeBadOption, exchange(t, client, critical).Code)
+
+	// empty confirmable message (ping) is answered with a reset
+	ping := exchange(t, client, &coap.Message{Type: coap.TypeConfirmable, Code: coap.CodeEmpty, MessageID: 4})
+	assert.Equal(t, coap.TypeReset, ping.Type)
+	assert.Equal(t, uint16(4), ping.MessageID)
+}
@@ -0,0 +1,213 @@
+// Package cron parses standard five field cron expressions (minute hour day-of-month month day-of-week) and
+// computes their activations. Fields take *, numbers, ranges (1-5), steps (*/15, 0-30/10) and comma separated
+// lists, months and weekdays also take their three letter names (jan, mon). The descriptors @yearly, @monthly,
+// @weekly, @daily and @hourly are accepted as well. Like cron itself, an expression restricting both the day of
+// month and the day of week fires when either matches.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchYears bounds the search of the next activation, an expression like "0 0 30 2 *" never fires.
+const searchYears = 5
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{ // 7 is sunday as well
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Schedule is a parsed cron expression, each field is a bit set of the values it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool // the day fields were *, only the other one restricts the day
+}
+
+// Parse parses a five field cron expression or a descriptor.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if descriptor, ok := descriptors[strings.ToLower(expr)]; ok {
+		expr = descriptor
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d in %q", len(fields), expr)
+	}
+
+	s := &Schedule{}
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if s.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if s.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // sunday
+	}
+	s.domAny = strings.HasPrefix(fields[2], "*")
+	s.dowAny = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+func (f field) parse(expr string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("cron: invalid step in %s %q", f.name, part)
+			}
+			rangeExpr, step = part[:i], n
+		}
+
+		var low, high int
+		switch {
+		case rangeExpr == "*":
+			low, high = f.min, f.max
+		case strings.Contains(rangeExpr, "-"):
+			bounds := strings.SplitN(rangeExpr, "-", 2)
+			var err error
+			if low, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if high, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if high < low {
+				return 0, fmt.Errorf("cron: range %q of %s runs backwards", rangeExpr, f.name)
+			}
+		default:
+			value, err := f.value(rangeExpr)
+			if err != nil {
+				return 0, err
+			}
+			low, high = value, value
+			if step > 1 {
+				high = f.max // 5/15 is 5-max/15
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("cron: invalid %s %q", f.name, s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("cron: %s %d is out of range %d-%d", f.name, v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first activation after t in the location of t, the zero time when there is none within the
+// next years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + searchYears
+
+	// each field is moved forward until it matches, the lower fields reset when a higher one moves and a field
+	// wrapping around starts over from the month
+	reset := false
+search:
+	for t.Year() <= limit {
+		for s.month&(1<<uint(t.Month())) == 0 {
+			if !reset {
+				reset = true
+				t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+			}
+			t = t.AddDate(0, 1, 0)
+			if t.Month() == time.January {
+				continue search
+			}
+		}
+
+		for !s.dayMatches(t) {
+			if !reset {
+				reset = true
+				t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+			}
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			if t.Day() == 1 {
+				continue search
+			}
+		}
+
+		for s.hour&(1<<uint(t.Hour())) == 0 {
+			if !reset {
+				reset = true
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+			}
+			t = t.Add(time.Hour)
+			if t.Hour() == 0 {
+				continue search
+			}
+		}
+
+		for s.minute&(1<<uint(t.Minute())) == 0 {
+			reset = true
+			t = t.Add(time.Minute)
+			if t.Minute() == 0 {
+				continue search
+			}
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,39 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/pkg/cron"
+)
+
+func TestScheduleNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	cases := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"0 3 * * sun", time.Date(2024, 3, 27, 12, 0, 0, 0, berlin), time.Date(2024, 3, 31, 3, 0, 0, 0, berlin)}, // sunday the clocks go forward
+		{"0 * * * *", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"*/15 9-17 * * mon-fri", time.Date(2024, 5, 3, 17, 50, 0, 0, time.UTC), time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)},
+		{"30 2 29 feb *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 * 1", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)}, // day of month or monday
+		{"@monthly", time.Date(2024, 12, 15, 8, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, c := range cases {
+		schedule, err := cron.Parse(c.expr)
+		require.NoError(t, err, c.expr)
+		assert.True(t, c.want.Equal(schedule.Next(c.from)), "%s from %s: got %s", c.expr, c.from, schedule.Next(c.from))
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "0 0 * * 8", "5-1 * * * *", "*/0 * * * *", "0 0 * foo *"} {
+		_, err := cron.Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
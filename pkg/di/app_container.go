@@ -3,11 +3,15 @@ package di
 import (
 	"context"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/vars7899/iots/config"
 	"github.com/vars7899/iots/internal/cache"
 	"github.com/vars7899/iots/internal/cache/redis"
+	"github.com/vars7899/iots/internal/domain/model"
 	"github.com/vars7899/iots/internal/middleware"
+	"github.com/vars7899/iots/internal/notification"
 	"github.com/vars7899/iots/internal/repository"
 	"github.com/vars7899/iots/internal/repository/postgres"
 	"github.com/vars7899/iots/internal/service"
@@ -19,6 +23,7 @@ import (
 	"github.com/vars7899/iots/pkg/auth/token"
 	"github.com/vars7899/iots/pkg/logger"
 	"github.com/vars7899/iots/pkg/pubsub"
+	"github.com/vars7899/iots/pkg/websocket"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
@@ -45,6 +50,19 @@ type RepositoryProvider struct {
 	DeviceRepository             repository.DeviceRepository
 	UserRepository               repository.UserRepository
 	TelemetryRepository          repository.TelemetryRepository
+	TelemetryRejectionRepository repository.TelemetryRejectionRepository
+	IngestStatsRepository        repository.IngestStatsRepository
+	AlertRepository              repository.AlertRepository
+	NotificationRepository       repository.NotificationRepository
+	RuleRepository               repository.RuleRepository
+	RuleStateRepository          repository.RuleStateRepository
+	MaintenanceRepository        repository.MaintenanceRepository
+	EscalationRepository         repository.EscalationRepository
+	OnCallRepository             repository.OnCallRepository
+	CommandRepository            repository.CommandRepository
+	CommandScheduleRepository    repository.CommandScheduleRepository
+	CommandCampaignRepository    repository.CommandCampaignRepository
+	DeadLetterRepository         repository.DeadLetterRepository
 	RoleRepository               repository.RoleRepository
 	ResetPasswordTokenRepository repository.ResetPasswordTokenRepository
 }
@@ -54,6 +72,16 @@ type ServiceProvider struct {
 	DeviceService             service.DeviceService
 	UserService               service.UserService
 	TelemetryService          *service.TelemetryService
+	IngestStatsService        *service.IngestStatsService
+	AlertService              *service.AlertService
+	NotificationService       *service.NotificationService
+	RuleService               *service.RuleService
+	MaintenanceService        *service.MaintenanceService
+	EscalationService         *service.EscalationService
+	CommandService            *service.CommandService
+	CommandScheduleService    *service.CommandScheduleService
+	CommandCampaignService    *service.CommandCampaignService
+	DeadLetterService         *service.DeadLetterService
 	RoleService               service.RoleService
 	ResetPasswordTokenService service.ResetPasswordTokenService
 	AuthService               service.AuthService
@@ -61,11 +89,14 @@ type ServiceProvider struct {
 
 type CoreServiceProvider struct {
 	NatsPublisher        pubsub.PubSubPublisher // cross service communication
+	CommandRegistry      *config.DeviceCommandSchemaRegistry
 	AuthTokenService     auth.AuthTokenService
 	AccessControlService auth.AccessControlService
 	JWTTokenService      token.TokenService
 	JTIStoreService      cache.JTIStore
+	IngestStatsStore     cache.IngestStatsStore
 	DeviceAuthService    deviceauth.DeviceAuthService
+	UserMessageRouter    *websocket.UserRouter // s>u messages to the users subscribed to a device
 }
 
 func NewAppContainer(ctx context.Context, wg *sync.WaitGroup, db *gorm.DB, cfg *config.AppConfig, baseLogger *zap.Logger) (*AppContainer, error) {
@@ -147,6 +178,19 @@ func NewRepositoryProvider(db *gorm.DB, baseLogger *zap.Logger) (*RepositoryProv
 		DeviceRepository:             postgres.NewDeviceRepositoryPostgres(db, logger),
 		UserRepository:               postgres.NewUserRepositoryPostgres(db, logger),
 		TelemetryRepository:          postgres.NewTelemetryRepositoryPostgres(db, logger),
+		TelemetryRejectionRepository: postgres.NewTelemetryRejectionRepositoryPostgres(db, logger),
+		IngestStatsRepository:        postgres.NewIngestStatsRepositoryPostgres(db, logger),
+		AlertRepository:              postgres.NewAlertRepositoryPostgres(db, logger),
+		NotificationRepository:       postgres.NewNotificationRepositoryPostgres(db, logger),
+		RuleRepository:               postgres.NewRuleRepositoryPostgres(db, logger),
+		RuleStateRepository:          postgres.NewRuleStateRepositoryPostgres(db, logger),
+		MaintenanceRepository:        postgres.NewMaintenanceRepositoryPostgres(db, logger),
+		EscalationRepository:         postgres.NewEscalationRepositoryPostgres(db, logger),
+		OnCallRepository:             postgres.NewOnCallRepositoryPostgres(db, logger),
+		CommandRepository:            postgres.NewCommandRepositoryPostgres(db, logger),
+		CommandScheduleRepository:    postgres.NewCommandScheduleRepositoryPostgres(db, logger),
+		CommandCampaignRepository:    postgres.NewCommandCampaignRepositoryPostgres(db, logger),
+		DeadLetterRepository:         postgres.NewDeadLetterRepositoryPostgres(db, logger),
 		RoleRepository:               postgres.NewRoleRepositoryPostgres(db, logger),
 		ResetPasswordTokenRepository: postgres.NewResetPasswordTokenRepositoryPostgres(db, logger),
 	}, nil
@@ -189,6 +233,16 @@ func NewCoreServiceProvider(db *gorm.DB, cfg *config.AppConfig, baseLogger *zap.
 		return nil, apperror.ErrorHandler(err, apperror.ErrCodeInit)
 	}
 
+	commandRegistry := config.NewDeviceCommandSchemaRegistry()
+	if err := commandRegistry.LoadDeviceCommandSchemaRegistry("device.command.schema", "yaml", "./configs", logger); err != nil {
+		logger.Error("Device command schema registry initialization failed", zap.Error(err))
+		return nil, apperror.ErrorHandler(err, apperror.ErrCodeInit, "failed to load device command schema")
+	}
+
+	commandRegistry.OnReload(func(reload config.SchemaReload) {
+		publishSchemaReload(natsPubsub, reload, logger)
+	})
+
 	deviceConnectionTokenService := deviceauth.NewDeviceConnectionTokenService(cfg.Jwt, logger)
 
 	jwtTokenService := token.NewJwtTokenService(cfg.Jwt, logger)
@@ -196,16 +250,47 @@ func NewCoreServiceProvider(db *gorm.DB, cfg *config.AppConfig, baseLogger *zap.
 	authTokenService := auth.NewAuthTokenManger(jwtTokenService, jtiStoreService, logger)
 	deviceAuthService := deviceauth.NewDeviceAuthManager(deviceConnectionTokenService, *jtiStoreService, logger)
 
+	var ingestStatsTTL time.Duration
+	if cfg.Telemetry != nil {
+		ingestStatsTTL = cfg.Telemetry.StatsTTL
+	}
+
 	return &CoreServiceProvider{
 		NatsPublisher:        natsPubsub,
+		CommandRegistry:      commandRegistry,
 		JWTTokenService:      jwtTokenService,
 		JTIStoreService:      jtiStoreService,
+		IngestStatsStore:     redis.NewRedisIngestStatsStore(cfg.Redis, ingestStatsTTL, logger),
 		AuthTokenService:     authTokenService,
 		AccessControlService: accessControlService,
 		DeviceAuthService:    deviceAuthService,
+		UserMessageRouter:    websocket.NewUserRouter(natsPubsub, logger),
 	}, nil
 }
 
+// publishSchemaReload publishes the diff of a hot reload of the command schema as a system event.
+func publishSchemaReload(publisher pubsub.PubSubPublisher, reload config.SchemaReload, logger *zap.Logger) {
+	eventType := model.EventTypeCommandSchemaReloaded
+	if !reload.Applied {
+		eventType = model.EventTypeCommandSchemaRejected
+	}
+	event := model.DeviceEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		Timestamp: time.Now(),
+		Payload: map[string]interface{}{
+			"applied": reload.Applied,
+			"changes": reload.Changes,
+		},
+	}
+	if reload.Error != "" {
+		event.Payload["error"] = reload.Error
+	}
+	if err := publisher.Publish(context.Background(), pubsub.NatsTopicSystemEvents, event); err != nil {
+		logger.Warn("failed to publish command schema reload", zap.Error(err))
+	}
+}
+
 func NewApiProvider(coreProvider *CoreServiceProvider, baseLogger *zap.Logger) (*APIProvider, error) {
 	logger := logger.Named(baseLogger, "APIProvider")
 	if coreProvider == nil {
@@ -243,18 +328,53 @@ func NewServiceProvider(repoProvider *RepositoryProvider, coreProvider *CoreServ
 		repoProvider.RoleRepository == nil ||
 		repoProvider.SensorRepository == nil ||
 		repoProvider.TelemetryRepository == nil ||
+		repoProvider.TelemetryRejectionRepository == nil ||
+		repoProvider.IngestStatsRepository == nil ||
+		repoProvider.AlertRepository == nil ||
+		repoProvider.NotificationRepository == nil ||
+		repoProvider.RuleRepository == nil ||
+		repoProvider.RuleStateRepository == nil ||
+		repoProvider.MaintenanceRepository == nil ||
+		repoProvider.EscalationRepository == nil ||
+		repoProvider.OnCallRepository == nil ||
+		repoProvider.CommandRepository == nil ||
+		repoProvider.CommandScheduleRepository == nil ||
+		repoProvider.CommandCampaignRepository == nil ||
+		repoProvider.DeadLetterRepository == nil ||
 		repoProvider.UserRepository == nil {
 		logger.Error("ServiceProvider initialization failed: missing one or more of the required repository")
 		return nil, apperror.ErrMissingDependency.WithMessage("missing required one or more repository")
 	}
 
+	notificationChannels, err := newNotificationChannels(cfg.Notification, logger)
+	if err != nil {
+		logger.Error("ServiceProvider initialization failed: invalid notification channel", zap.Error(err))
+		return nil, apperror.ErrorHandler(err, apperror.ErrCodeInit, "failed to initialize notification channels")
+	}
+	notificationService := service.NewNotificationService(repoProvider.NotificationRepository, notificationChannels, cfg.Notification, logger)
+
 	roleService := service.NewRoleService(repoProvider.RoleRepository, cfg.Auth.DefaultNewUserRoleSlug, logger)
 	resetPasswordTokenService := service.NewResetPasswordTokenService(repoProvider.ResetPasswordTokenRepository, repoProvider.UserRepository, logger)
 	userService := service.NewUserService(repoProvider.UserRepository, logger)
-	sensorService := service.NewSensorService(repoProvider.SensorRepository, logger)
-	deviceService := service.NewDeviceService(repoProvider.DeviceRepository, coreProvider.DeviceAuthService, logger)
-	telemetryService := service.NewTelemetryService(repoProvider.TelemetryRepository, logger)
-	authService := service.NewAuthService(userService, roleService, coreProvider.AccessControlService, coreProvider.AuthTokenService, resetPasswordTokenService, config.GlobalConfig, logger)
+	sensorCache := service.NewSensorCache(cfg.Telemetry)
+	sensorService := service.NewSensorService(repoProvider.SensorRepository, sensorCache, logger)
+	deviceStatusService := service.NewDeviceStatusService(repoProvider.DeviceRepository, coreProvider.NatsPublisher, logger)
+	deviceService := service.NewDeviceService(repoProvider.DeviceRepository, deviceStatusService, coreProvider.DeviceAuthService, logger)
+	maintenanceService := service.NewMaintenanceService(repoProvider.MaintenanceRepository, repoProvider.DeviceRepository, deviceStatusService, logger)
+	telemetryService := service.NewTelemetryService(repoProvider.TelemetryRepository, repoProvider.SensorRepository, repoProvider.TelemetryRejectionRepository, sensorCache, maintenanceService, logger)
+	alertService := service.NewAlertService(repoProvider.AlertRepository, repoProvider.DeviceRepository, coreProvider.NatsPublisher, notificationService, cfg.Telemetry, logger)
+	telemetryService.AddObserver(alertService)
+	deviceService.AddListener(alertService)
+	escalationService := service.NewEscalationService(repoProvider.EscalationRepository, repoProvider.OnCallRepository, repoProvider.AlertRepository, repoProvider.DeviceRepository, repoProvider.UserRepository, notificationService, logger)
+	alertService.AddListener(escalationService)
+	ruleService := service.NewRuleService(repoProvider.RuleRepository, repoProvider.RuleStateRepository, alertService, coreProvider.NatsPublisher, notificationService, logger)
+	telemetryService.AddObserver(ruleService)
+	commandService := service.NewCommandService(repoProvider.CommandRepository, repoProvider.DeviceRepository, coreProvider.CommandRegistry, coreProvider.NatsPublisher, cfg.Command, logger)
+	commandScheduleService := service.NewCommandScheduleService(repoProvider.CommandScheduleRepository, repoProvider.CommandRepository, commandService, coreProvider.CommandRegistry, logger)
+	commandCampaignService := service.NewCommandCampaignService(repoProvider.CommandCampaignRepository, repoProvider.DeviceRepository, commandService, coreProvider.CommandRegistry, logger)
+	deadLetterService := service.NewDeadLetterService(repoProvider.DeadLetterRepository, coreProvider.NatsPublisher, cfg.Command, logger)
+	ingestStatsService := service.NewIngestStatsService(coreProvider.IngestStatsStore, repoProvider.IngestStatsRepository, repoProvider.DeviceRepository, logger)
+	authService := service.NewAuthService(userService, roleService, coreProvider.AccessControlService, coreProvider.AuthTokenService, resetPasswordTokenService, notificationService, config.GlobalConfig, logger)
 
 	logger.Info("ServiceProvider initialized successfully")
 
@@ -262,6 +382,16 @@ func NewServiceProvider(repoProvider *RepositoryProvider, coreProvider *CoreServ
 		SensorService:             sensorService,
 		DeviceService:             deviceService,
 		TelemetryService:          telemetryService,
+		IngestStatsService:        ingestStatsService,
+		AlertService:              alertService,
+		NotificationService:       notificationService,
+		RuleService:               ruleService,
+		MaintenanceService:        maintenanceService,
+		EscalationService:         escalationService,
+		CommandService:            commandService,
+		CommandScheduleService:    commandScheduleService,
+		CommandCampaignService:    commandCampaignService,
+		DeadLetterService:         deadLetterService,
 		UserService:               userService,
 		RoleService:               roleService,
 		ResetPasswordTokenService: resetPasswordTokenService,
@@ -269,6 +399,47 @@ func NewServiceProvider(repoProvider *RepositoryProvider, coreProvider *CoreServ
 	}, nil
 }
 
+// newNotificationChannels builds the enabled notification channels, none when notifications aren't configured.
+func newNotificationChannels(cfg *config.NotificationConfig, logger *zap.Logger) ([]notification.Channel, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	var channels []notification.Channel
+	if cfg.Webhook != nil && cfg.Webhook.Enabled {
+		if cfg.Webhook.Url == "" || cfg.Webhook.Secret == "" {
+			return nil, apperror.ErrMissingConfig.WithMessage("webhook notification channel needs a url and a secret")
+		}
+		channels = append(channels, notification.NewWebhookChannel(cfg.Webhook, logger))
+	}
+
+	emailEnabled := cfg.Smtp != nil && cfg.Smtp.Enabled
+	outboxEnabled := cfg.Outbox != nil && cfg.Outbox.Enabled
+	if !emailEnabled && !outboxEnabled {
+		return channels, nil
+	}
+
+	templates, err := notification.LoadTemplates()
+	if err != nil {
+		return nil, err
+	}
+	if emailEnabled {
+		smtpChannel, err := notification.NewSmtpChannel(cfg.Smtp, templates, logger)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, smtpChannel)
+	}
+	if outboxEnabled {
+		outboxChannel, err := notification.NewOutboxChannel(cfg.Outbox, templates, logger)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, outboxChannel)
+	}
+	return channels, nil
+}
+
 func (a *AppContainer) initWebsocketHub() {
 	a.WsHub = ws.NewHub(a.Logger)
 
@@ -292,9 +463,114 @@ func (a *AppContainer) initServiceWorker() error {
 	l := logger.Named(a.Logger, "ServiceWorker")
 
 	a.WaitGroup.Add(1)
-	go worker.TelemetryWorker(a.Ctx, a.WaitGroup, a.WsHub.GetSensorTelemetryMessageChannel(), a.Services.TelemetryService, l)
+	go worker.TelemetryWorker(a.Ctx, a.WaitGroup, a.WsHub.GetSensorTelemetryMessageChannel(), a.Services.TelemetryService, a.Services.IngestStatsService, l)
 
 	l.Info("Telemetry worker started")
 
+	if a.Config.Coap != nil && a.Config.Coap.Enabled {
+		a.WaitGroup.Add(1)
+		go worker.CoapTelemetryWorker(a.Ctx, a.WaitGroup, a.Config.Coap, a.Services.TelemetryService, a.Services.IngestStatsService, a.CoreServices.DeviceAuthService, l)
+
+		l.Info("Coap telemetry worker started")
+	}
+
+	rollupInterval := 5 * time.Minute
+	if a.Config.Telemetry != nil && a.Config.Telemetry.StatsRollupInterval > 0 {
+		rollupInterval = a.Config.Telemetry.StatsRollupInterval
+	}
+	a.WaitGroup.Add(1)
+	go worker.IngestStatsRollupWorker(a.Ctx, a.WaitGroup, rollupInterval, a.Services.IngestStatsService, l)
+
+	l.Info("Ingest stats rollup worker started")
+
+	checkpointInterval := time.Minute
+	if a.Config.Telemetry != nil && a.Config.Telemetry.RuleCheckpointInterval > 0 {
+		checkpointInterval = a.Config.Telemetry.RuleCheckpointInterval
+	}
+	a.WaitGroup.Add(1)
+	go worker.RuleCheckpointWorker(a.Ctx, a.WaitGroup, checkpointInterval, a.Services.RuleService, l)
+
+	l.Info("Rule checkpoint worker started")
+
+	maintenanceInterval := time.Minute
+	if a.Config.Telemetry != nil && a.Config.Telemetry.MaintenanceSyncInterval > 0 {
+		maintenanceInterval = a.Config.Telemetry.MaintenanceSyncInterval
+	}
+	a.WaitGroup.Add(1)
+	go worker.MaintenanceStatusWorker(a.Ctx, a.WaitGroup, maintenanceInterval, a.Services.MaintenanceService, l)
+
+	l.Info("Maintenance status worker started")
+
+	flapSweepInterval := time.Minute
+	if a.Config.Telemetry != nil && a.Config.Telemetry.AlertFlapSweepInterval > 0 {
+		flapSweepInterval = a.Config.Telemetry.AlertFlapSweepInterval
+	}
+	a.WaitGroup.Add(1)
+	go worker.AlertFlapWorker(a.Ctx, a.WaitGroup, flapSweepInterval, a.Services.AlertService, l)
+
+	l.Info("Alert flap worker started")
+
+	escalationInterval := 30 * time.Second
+	if a.Config.Notification != nil && a.Config.Notification.EscalationInterval > 0 {
+		escalationInterval = a.Config.Notification.EscalationInterval
+	}
+	a.WaitGroup.Add(1)
+	go worker.EscalationWorker(a.Ctx, a.WaitGroup, escalationInterval, a.Services.EscalationService, l)
+
+	l.Info("Escalation worker started")
+
+	a.WaitGroup.Add(1)
+	go a.CoreServices.UserMessageRouter.Run(a.Ctx, a.WaitGroup)
+
+	l.Info("User message router started")
+
+	a.WaitGroup.Add(1)
+	go worker.CommandStatusWorker(a.Ctx, a.WaitGroup, a.CoreServices.NatsPublisher, a.Services.CommandService, a.Services.DeadLetterService, l)
+
+	l.Info("Command status worker started")
+
+	commandExpiryInterval := 30 * time.Second
+	if a.Config.Command != nil && a.Config.Command.ExpiryInterval > 0 {
+		commandExpiryInterval = a.Config.Command.ExpiryInterval
+	}
+	a.WaitGroup.Add(1)
+	go worker.CommandExpiryWorker(a.Ctx, a.WaitGroup, commandExpiryInterval, a.Services.CommandService, l)
+
+	l.Info("Command expiry worker started")
+
+	commandRetryInterval := 5 * time.Second
+	if a.Config.Command != nil && a.Config.Command.RetryInterval > 0 {
+		commandRetryInterval = a.Config.Command.RetryInterval
+	}
+	a.WaitGroup.Add(1)
+	go worker.CommandRetryWorker(a.Ctx, a.WaitGroup, commandRetryInterval, a.Services.CommandService, l)
+
+	l.Info("Command retry worker started")
+
+	deadLetterPruneInterval := time.Hour
+	if a.Config.Command != nil && a.Config.Command.DeadLetterPruneInterval > 0 {
+		deadLetterPruneInterval = a.Config.Command.DeadLetterPruneInterval
+	}
+	a.WaitGroup.Add(1)
+	go worker.DeadLetterPruneWorker(a.Ctx, a.WaitGroup, deadLetterPruneInterval, a.Services.DeadLetterService, l)
+
+	l.Info("Dead letter prune worker started")
+
+	dispatchInterval := 5 * time.Second
+	if a.Config.Notification != nil && a.Config.Notification.DispatchInterval > 0 {
+		dispatchInterval = a.Config.Notification.DispatchInterval
+	}
+	a.WaitGroup.Add(1)
+	go worker.NotificationDispatchWorker(a.Ctx, a.WaitGroup, dispatchInterval, a.Services.NotificationService, l)
+
+	l.Info("Notification dispatch worker started")
+
+	if a.Config.Notification != nil && len(a.Config.Notification.DeviceEventTypes) > 0 {
+		a.WaitGroup.Add(1)
+		go worker.DeviceEventNotificationWorker(a.Ctx, a.WaitGroup, a.CoreServices.NatsPublisher, a.Services.NotificationService, a.Config.Notification.DeviceEventTypes, l)
+
+		l.Info("Device event notification worker started")
+	}
+
 	return nil
 }
@@ -46,6 +46,14 @@ func (n *NatsPubSub) Publish(ctx context.Context, topic string, message interfac
 }
 
 func (n *NatsPubSub) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	return n.subscribe(ctx, topic, "")
+}
+
+func (n *NatsPubSub) QueueSubscribe(ctx context.Context, topic string, queue string) (<-chan []byte, error) {
+	return n.subscribe(ctx, topic, queue)
+}
+
+func (n *NatsPubSub) subscribe(ctx context.Context, topic string, queue string) (<-chan []byte, error) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
@@ -55,12 +63,19 @@ func (n *NatsPubSub) Subscribe(ctx context.Context, topic string) (<-chan []byte
 
 	ch := make(chan []byte)
 
-	sub, err := n.conn.Subscribe(topic, func(msg *nats.Msg) {
+	handler := func(msg *nats.Msg) {
 		select {
 		case ch <- msg.Data:
 		case <-ctx.Done():
 		}
-	})
+	}
+	var sub *nats.Subscription
+	var err error
+	if queue == "" {
+		sub, err = n.conn.Subscribe(topic, handler)
+	} else {
+		sub, err = n.conn.QueueSubscribe(topic, queue, handler)
+	}
 	if err != nil {
 		return nil, err
 	}
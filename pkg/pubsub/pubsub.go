@@ -10,6 +10,8 @@ import (
 type PubSubPublisher interface {
 	Publish(ctx context.Context, topic string, message interface{}) error
 	Subscribe(ctx context.Context, topic string) (<-chan []byte, error)
+	// QueueSubscribe subscribes as a member of the queue group, each message goes to only one of its members
+	QueueSubscribe(ctx context.Context, topic string, queue string) (<-chan []byte, error)
 	Unsubscribe(ctx context.Context, topic string) error
 	Close() error
 }
@@ -22,11 +24,32 @@ const (
 	// Topic prefix for outgoing responses/events to specific devices
 	// Responses/events will be published to "commands.outbound.<deviceID>"
 	NatsTopicCommandsOutboundPrefix = "commands.outbound."
+	// Lifecycle events (delivered, acknowledged, completed, failed) of commands sent to devices
+	NatsTopicCommandStatus = "commands.status"
+	// Topic prefix of the final answer of a device to a command, "commands.replies.<commandID>". The API replica
+	// waiting on a rpc command subscribes to it before publishing the command and takes the first message.
+	NatsTopicCommandRepliesPrefix = "commands.replies."
 	// Topic prefix for general device state change events
 	// Events will be published to "device.events.<deviceID>"
 	NatsTopicDeviceEventsPrefix = "device.events."
+	// Topic prefix for s>u messages to the users subscribed to a device
+	// Messages will be published to "users.messages.<deviceID>", subscribe to NatsTopicUserMessages for every device
+	NatsTopicUserMessagesPrefix = "users.messages."
+	NatsTopicUserMessages       = "users.messages.>"
 	// System events (errors, monitoring, etc.)
 	NatsTopicSystemEvents = "system.events"
+	// Alert lifecycle events, subscribe to "alerts.*" for all of them
+	NatsTopicAlertTriggered    = "alerts.triggered"
+	NatsTopicAlertAcknowledged = "alerts.acknowledged"
+	NatsTopicAlertResolved     = "alerts.resolved"
+	NatsTopicAlertFlapping     = "alerts.flapping"
+	// Composite rule fired/cleared events
+	NatsTopicRuleEvents = "rules.events"
+)
+
+// Queue groups of the workers every replica runs but each message must be handled by only one of them.
+const (
+	NatsQueueCommandStatus = "command-status-workers"
 )
 
 func NatsTopicCommandsOutboundPrefixf(deviceID uuid.UUID) string {
@@ -36,3 +59,11 @@ func NatsTopicCommandsOutboundPrefixf(deviceID uuid.UUID) string {
 func NatsTopicDeviceEventsPrefixf(deviceID uuid.UUID) string {
 	return fmt.Sprintf("%s%s", NatsTopicDeviceEventsPrefix, deviceID.String())
 }
+
+func NatsTopicUserMessagesPrefixf(deviceID uuid.UUID) string {
+	return fmt.Sprintf("%s%s", NatsTopicUserMessagesPrefix, deviceID.String())
+}
+
+func NatsTopicCommandRepliesPrefixf(commandID uuid.UUID) string {
+	return fmt.Sprintf("%s%s", NatsTopicCommandRepliesPrefix, commandID.String())
+}
@@ -3,6 +3,7 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
@@ -20,20 +21,31 @@ import (
 	"go.uber.org/zap"
 )
 
+// CommandQueue keeps the commands published while their device wasn't connected, a message on the outbound subject
+// only reaches the sessions open at that time.
+type CommandQueue interface {
+	QueuedCommands(ctx context.Context, deviceID uuid.UUID, now time.Time) ([]*model.DeviceCommand, error)     // undelivered commands not expired yet, oldest first
+	MarkProcessed(ctx context.Context, deviceID uuid.UUID, commandIDs []uuid.UUID, now time.Time) (int, error) // commands the device already ran
+}
+
 type WebsocketHandler struct {
 	manager     *SessionManager
 	config      *config.WebsocketConfig
 	authService deviceauth.DeviceAuthService
 	nats        pubsub.PubSubPublisher
+	queue       CommandQueue
+	registry    *config.DeviceCommandSchemaRegistry
 	logger      *zap.Logger
 }
 
-func NewWebsocketHandler(sm *SessionManager, publisher pubsub.PubSubPublisher, auth deviceauth.DeviceAuthService, config *config.WebsocketConfig, baseLogger *zap.Logger) *WebsocketHandler {
+func NewWebsocketHandler(sm *SessionManager, publisher pubsub.PubSubPublisher, auth deviceauth.DeviceAuthService, queue CommandQueue, registry *config.DeviceCommandSchemaRegistry, config *config.WebsocketConfig, baseLogger *zap.Logger) *WebsocketHandler {
 	return &WebsocketHandler{
 		manager:     sm,
 		authService: auth,
 		config:      config,
 		nats:        publisher,
+		queue:       queue,
+		registry:    registry,
 		logger:      logger.Named(baseLogger, "WebsocketHandler"),
 	}
 }
@@ -115,6 +127,14 @@ func (h *WebsocketHandler) HandleConnection(c echo.Context) error {
 		h.writePump(session)
 	}()
 
+	// commands published while the device was away, a live one arriving meanwhile may come twice and is dropped
+	// by the device through its idempotency key
+	natsWg.Add(1)
+	go func() {
+		defer natsWg.Done()
+		h.flushQueue(session)
+	}()
+
 	pumpWg.Wait()
 	natsWg.Wait()
 
@@ -232,6 +252,72 @@ func (h *WebsocketHandler) subscribeAndPush(session *DeviceSession, topic string
 	}
 }
 
+// flushQueue delivers the queued commands of the device in order. Unlike live messages they wait for room in the
+// session channel instead of being dropped.
+func (h *WebsocketHandler) flushQueue(session *DeviceSession) {
+	if h.queue == nil {
+		return
+	}
+	commands, err := h.queue.QueuedCommands(session.ctx, session.DeviceID, time.Now())
+	if err != nil {
+		h.logger.Error("Failed to load queued commands",
+			zap.String("session_id", session.ID.String()),
+			zap.String("device_id", session.DeviceID.String()),
+			zap.Error(err))
+		return
+	}
+
+	for _, cmd := range commands {
+		message, err := json.Marshal(cmd)
+		if err != nil {
+			continue
+		}
+		select {
+		case session.Channel <- message:
+		case <-session.ctx.Done():
+			return
+		}
+	}
+	if len(commands) > 0 {
+		h.logger.Info("Delivered queued commands",
+			zap.String("session_id", session.ID.String()),
+			zap.String("device_id", session.DeviceID.String()),
+			zap.Int("commands", len(commands)))
+	}
+}
+
+// markProcessed completes the queued commands the device reports it already ran.
+func (h *WebsocketHandler) markProcessed(session *DeviceSession, report *model.DeviceCommand) {
+	if h.queue == nil {
+		return
+	}
+	rawIDs, _ := report.Payload["command_ids"].([]interface{})
+	commandIDs := make([]uuid.UUID, 0, len(rawIDs))
+	for _, rawID := range rawIDs {
+		str, _ := rawID.(string)
+		if commandID, err := uuid.Parse(str); err == nil {
+			commandIDs = append(commandIDs, commandID)
+		}
+	}
+	if len(commandIDs) == 0 {
+		return
+	}
+
+	completed, err := h.queue.MarkProcessed(session.ctx, session.DeviceID, commandIDs, time.Now())
+	if err != nil {
+		h.logger.Error("Failed to mark processed commands",
+			zap.String("session_id", session.ID.String()),
+			zap.String("device_id", session.DeviceID.String()),
+			zap.Error(err))
+		return
+	}
+	h.logger.Debug("Device reported processed commands",
+		zap.String("session_id", session.ID.String()),
+		zap.String("device_id", session.DeviceID.String()),
+		zap.Int("reported", len(commandIDs)),
+		zap.Int("completed", completed))
+}
+
 func (h *WebsocketHandler) readPump(session *DeviceSession) {
 	// Ensure session is closed when readPump exits
 	defer func() {
@@ -296,6 +382,15 @@ func (h *WebsocketHandler) readPump(session *DeviceSession) {
 			continue // Skip processing this message but keep connection open
 		}
 
+		if incomingCmd.Type == model.CommandTypeProcessed {
+			h.markProcessed(session, &incomingCmd)
+			continue
+		}
+
+		if !h.allowedFromDevice(session, &incomingCmd) {
+			continue
+		}
+
 		// Add DeviceID from the session to the command (important for routing in CommandProcessorService)
 		incomingCmd.ID = uuid.New()
 		incomingCmd.DeviceID = session.DeviceID
@@ -321,6 +416,48 @@ func (h *WebsocketHandler) readPump(session *DeviceSession) {
 	}
 }
 
+// allowedFromDevice checks the direction of a message read from the device, only d>* commands and replies to
+// commands sent to the device are published. The device gets an error event for anything else.
+func (h *WebsocketHandler) allowedFromDevice(session *DeviceSession, cmd *model.DeviceCommand) bool {
+	schema := h.registry.GetCommandByCode(cmd.CommandCode)
+	if schema == nil {
+		h.rejectFromDevice(session, cmd, "unknown_command", fmt.Sprintf("unknown command code: %s", cmd.CommandCode))
+		return false
+	}
+
+	direction := schema.Endpoints()
+	if cmd.CorrelationID != uuid.Nil {
+		if !direction.ToDevice() {
+			h.rejectFromDevice(session, cmd, "direction_not_allowed", fmt.Sprintf("%s (%s) is not sent to devices, it can't be answered", cmd.CommandCode, schema.Direction))
+			return false
+		}
+		return true
+	}
+	if !direction.FromDevice() {
+		h.rejectFromDevice(session, cmd, "direction_not_allowed", fmt.Sprintf("%s (%s) can't be sent by a device", cmd.CommandCode, schema.Direction))
+		return false
+	}
+	return true
+}
+
+func (h *WebsocketHandler) rejectFromDevice(session *DeviceSession, cmd *model.DeviceCommand, errCode, errMessage string) {
+	h.logger.Warn("Rejected message from device",
+		zap.String("session_id", session.ID.String()),
+		zap.String("device_id", session.DeviceID.String()),
+		zap.String("command_code", cmd.CommandCode),
+		zap.String("error_code", errCode))
+
+	message, err := json.Marshal(model.NewDeviceErrorEvent(cmd.ID, session.DeviceID, cmd.CommandCode, errMessage, errCode))
+	if err != nil {
+		return
+	}
+	select {
+	case session.Channel <- message:
+	default:
+		// the device isn't reading, the rejection only gets logged
+	}
+}
+
 func (h *WebsocketHandler) writePump(session *DeviceSession) {
 	// Ticker for sending ping messages to keep the connection alive
 	pingInterval := h.config.PingTimeout // Assuming PingInterval is defined in your config
@@ -336,10 +473,8 @@ func (h *WebsocketHandler) writePump(session *DeviceSession) {
 
 	h.logger.Debug("Starting write pump", zap.String("session_id", session.ID.String()))
 
-	// The write pump needs to close the session.Channel when it exits
-	// so the pushNatsMessagesToSession goroutines know to stop pushing messages.
-	// This should be done after the defer functions are set up.
-	defer close(session.Channel)
+	// session.Channel is left open, the goroutines pushing to it stop on the session context and closing it could
+	// race a push, e.g. of the queued commands, into a panic
 
 	for {
 		select {
@@ -364,6 +499,7 @@ func (h *WebsocketHandler) writePump(session *DeviceSession) {
 				zap.String("session_id", session.ID.String()),
 				zap.String("device_id", session.DeviceID.String()),
 				zap.Int("msg_size", len(message)))
+			h.reportDelivery(session, message)
 
 		case <-tick.C:
 			// Time to send a ping message
@@ -384,6 +520,34 @@ func (h *WebsocketHandler) writePump(session *DeviceSession) {
 	}
 }
 
+// reportDelivery publishes a command_delivered event when the message written to the device is a command,
+// the command service tracks the command's lifecycle from it.
+func (h *WebsocketHandler) reportDelivery(session *DeviceSession, message []byte) {
+	var cmd model.DeviceCommand
+	if err := json.Unmarshal(message, &cmd); err != nil || cmd.Type != model.CommandTypeCommand || cmd.ID == uuid.Nil {
+		return // events and replies pushed to the device
+	}
+
+	event := model.DeviceEvent{
+		ID:          uuid.New(),
+		Type:        model.EventTypeCommandDelivered,
+		DeviceID:    session.DeviceID,
+		CommandCode: cmd.CommandCode,
+		Timestamp:   time.Now(),
+		Payload: map[string]interface{}{
+			"session_id": session.ID.String(),
+		},
+		CorrelationID: cmd.ID,
+	}
+	if err := h.nats.Publish(context.Background(), pubsub.NatsTopicCommandStatus, event); err != nil {
+		h.logger.Warn("Failed to publish command delivery",
+			zap.String("session_id", session.ID.String()),
+			zap.String("device_id", session.DeviceID.String()),
+			zap.String("command_id", cmd.ID.String()),
+			zap.Error(err))
+	}
+}
+
 // func (h *WebsocketHandler) readPump(session *DeviceSession) {
 // 	defer func() {
 // 		session.Close()
@@ -0,0 +1,123 @@
+package websocket_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	gorillaWs "github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/internal/domain/model"
+	"github.com/vars7899/iots/pkg/auth/deviceauth"
+	"github.com/vars7899/iots/pkg/contextkey"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"github.com/vars7899/iots/pkg/websocket"
+	"go.uber.org/zap"
+)
+
+type fakeDeviceAuth struct {
+	deviceauth.DeviceAuthService
+	deviceID uuid.UUID
+}
+
+func (a *fakeDeviceAuth) Authenticate(ctx context.Context, connectionToken string, refreshToken string) (*deviceauth.DeviceConnectionClaims, *deviceauth.DeviceConnectionTokens, error) {
+	return &deviceauth.DeviceConnectionClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: a.deviceID.String()}}, nil, nil
+}
+
+// inboundPublisher keeps the commands the read pump publishes for the command processor, nothing is sent to the
+// device over its subjects.
+type inboundPublisher struct {
+	pubsub.PubSubPublisher
+	mu      sync.Mutex
+	inbound []model.DeviceCommand
+}
+
+func (p *inboundPublisher) Subscribe(ctx context.Context, topic string) (<-chan []byte, error) {
+	return make(chan []byte), nil
+}
+
+func (p *inboundPublisher) Unsubscribe(ctx context.Context, topic string) error {
+	return nil
+}
+
+func (p *inboundPublisher) Publish(ctx context.Context, topic string, message interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cmd, ok := message.(model.DeviceCommand); ok && topic == pubsub.NatsTopicCommandsInbound {
+		p.inbound = append(p.inbound, cmd)
+	}
+	return nil
+}
+
+func (p *inboundPublisher) published() []model.DeviceCommand {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]model.DeviceCommand(nil), p.inbound...)
+}
+
+func TestReadPumpOnlyPublishesWhatADeviceMaySend(t *testing.T) {
+	registry := config.NewDeviceCommandSchemaRegistry()
+	require.NoError(t, registry.LoadDeviceCommandSchemaRegistry("device.command.schema", "yaml", "../../configs", zap.NewNop()))
+
+	deviceID := uuid.New()
+	publisher := &inboundPublisher{}
+	handler := websocket.NewWebsocketHandler(websocket.NewSessionManager(zap.NewNop()), publisher, &fakeDeviceAuth{deviceID: deviceID}, nil, registry,
+		&config.WebsocketConfig{PingTimeout: time.Minute, PongTimeout: time.Minute, ReadDeadline: 1 << 16}, zap.NewNop())
+
+	e := echo.New()
+	e.GET("/ws", handler.HandleConnection)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	header := http.Header{}
+	header.Set(contextkey.HeaderDeviceConnectionToken, "connection")
+	header.Set(contextkey.HeaderDeviceRefreshToken, "refresh")
+	conn, _, err := gorillaWs.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", header)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	rejected := func(cmd model.DeviceCommand) model.DeviceEvent {
+		require.NoError(t, conn.WriteJSON(cmd))
+		var event model.DeviceEvent
+		require.NoError(t, conn.ReadJSON(&event))
+		return event
+	}
+
+	// device@init_ack goes s>u, it's the server's answer to device@init
+	event := rejected(model.DeviceCommand{ID: uuid.New(), Type: model.CommandTypeAck, CommandCode: "device@init_ack", Payload: map[string]interface{}{"status": "ready"}})
+	assert.Equal(t, model.EventTypeError, event.Type)
+	assert.Equal(t, "direction_not_allowed", event.Payload["error_code"])
+	assert.Equal(t, "device@init_ack", event.CommandCode)
+
+	// device@reboot goes u>d, without the id of the command it answers it's a device sending it
+	event = rejected(model.DeviceCommand{ID: uuid.New(), Type: model.CommandTypeCommand, CommandCode: "device@reboot"})
+	assert.Equal(t, "direction_not_allowed", event.Payload["error_code"])
+
+	// a reply to a command that never goes to a device
+	event = rejected(model.DeviceCommand{ID: uuid.New(), Type: model.CommandTypeAck, CommandCode: "device@init_ack", CorrelationID: uuid.New()})
+	assert.Equal(t, "direction_not_allowed", event.Payload["error_code"])
+
+	// the reply to a reboot and a d>s command are published, under the device of the session
+	rebootID := uuid.New()
+	require.NoError(t, conn.WriteJSON(model.DeviceCommand{Type: model.CommandTypeAck, CommandCode: "device@reboot", CorrelationID: rebootID}))
+	require.NoError(t, conn.WriteJSON(model.DeviceCommand{Type: model.CommandTypeCommand, CommandCode: "device@status_update", Payload: map[string]interface{}{"status": "faulty"}}))
+
+	require.Eventually(t, func() bool { return len(publisher.published()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	inbound := publisher.published()
+	assert.Equal(t, "device@reboot", inbound[0].CommandCode)
+	assert.Equal(t, rebootID, inbound[0].CorrelationID)
+	assert.Equal(t, "device@status_update", inbound[1].CommandCode)
+	for _, cmd := range inbound {
+		assert.Equal(t, deviceID, cmd.DeviceID)
+	}
+}
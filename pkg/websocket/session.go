@@ -75,9 +75,14 @@ func (s *DeviceSession) Close() error {
 	// Use the context cancellation to signal closure
 	s.cancel() // Signal goroutines to stop
 
+	// both pumps close the session when they stop, only the first one closes the connection
+	if !s.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
 	// Close the WebSocket connection
-	// Send a close message to the client
-	err := s.Conn.WriteMessage(gorillaWs.CloseMessage, gorillaWs.FormatCloseMessage(gorillaWs.CloseNormalClosure, ""))
+	// Send a close message to the client, as a control message it may be written while the write pump writes
+	err := s.Conn.WriteControl(gorillaWs.CloseMessage, gorillaWs.FormatCloseMessage(gorillaWs.CloseNormalClosure, ""), time.Now().Add(time.Second))
 	if err != nil {
 		s.logger.Warn("Failed to send close message to websocket", zap.Error(err))
 	}
@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	gorillaWs "github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/vars7899/iots/config"
+	"github.com/vars7899/iots/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// UserMessageHandler streams the s>u messages of a device to a user over a websocket, the user only listens.
+type UserMessageHandler struct {
+	router *UserRouter
+	config *config.WebsocketConfig
+	logger *zap.Logger
+}
+
+func NewUserMessageHandler(router *UserRouter, config *config.WebsocketConfig, baseLogger *zap.Logger) *UserMessageHandler {
+	return &UserMessageHandler{
+		router: router,
+		config: config,
+		logger: logger.Named(baseLogger, "UserMessageHandler"),
+	}
+}
+
+func (h *UserMessageHandler) HandleConnection(c echo.Context) error {
+	var upgrader = gorillaWs.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     func(r *http.Request) bool { return true },
+	}
+
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid device id")
+	}
+
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		h.logger.Error("WebSocket upgrade failed", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "websocket upgrade failed")
+	}
+	defer conn.Close()
+
+	sub := h.router.Subscribe(deviceID)
+	defer h.router.Unsubscribe(sub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the user doesn't send anything, reading only handles pongs and notices the connection closing
+	go func() {
+		defer cancel()
+		conn.SetReadDeadline(time.Now().Add(h.config.PongTimeout))
+		conn.SetPongHandler(func(string) error {
+			conn.SetReadDeadline(time.Now().Add(h.config.PongTimeout))
+			return nil
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	h.logger.Info("User subscribed to device messages",
+		zap.String("subscription_id", sub.ID.String()),
+		zap.String("device_id", deviceID.String()))
+
+	tick := time.NewTicker(h.config.PingTimeout)
+	defer tick.Stop()
+	for {
+		select {
+		case message := <-sub.Messages:
+			conn.SetWriteDeadline(time.Now().Add(15 * time.Second))
+			if err := conn.WriteMessage(gorillaWs.TextMessage, message); err != nil {
+				h.logger.Warn("Websocket write error", zap.String("subscription_id", sub.ID.String()), zap.Error(err))
+				return nil
+			}
+		case <-tick.C:
+			if err := conn.WriteMessage(gorillaWs.PingMessage, nil); err != nil {
+				h.logger.Warn("Websocket ping error", zap.String("subscription_id", sub.ID.String()), zap.Error(err))
+				return nil
+			}
+		case <-ctx.Done():
+			h.logger.Info("User websocket connection closed",
+				zap.String("subscription_id", sub.ID.String()),
+				zap.String("device_id", deviceID.String()))
+			return nil
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vars7899/iots/pkg/logger"
+	"github.com/vars7899/iots/pkg/pubsub"
+	"go.uber.org/zap"
+)
+
+const userSubscriptionBuffer = 64
+
+// UserSubscription receives the s>u messages of one device for a connected user.
+type UserSubscription struct {
+	ID       uuid.UUID
+	DeviceID uuid.UUID
+	Messages chan []byte
+}
+
+// UserRouter fans the s>u messages out to the users subscribed to their device. The pubsub only takes one
+// subscription per subject, so the router subscribes once to every device and routes by the device of a message.
+type UserRouter struct {
+	nats   pubsub.PubSubPublisher
+	subs   map[uuid.UUID]map[uuid.UUID]*UserSubscription // key <device_id>, value <subscription_id>
+	mu     sync.RWMutex
+	logger *zap.Logger
+}
+
+func NewUserRouter(nats pubsub.PubSubPublisher, baseLogger *zap.Logger) *UserRouter {
+	return &UserRouter{
+		nats:   nats,
+		subs:   make(map[uuid.UUID]map[uuid.UUID]*UserSubscription),
+		logger: logger.Named(baseLogger, "UserRouter"),
+	}
+}
+
+func (r *UserRouter) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	msgChan, err := r.nats.Subscribe(ctx, pubsub.NatsTopicUserMessages)
+	if err != nil {
+		r.logger.Error("Failed to subscribe to user messages", zap.Error(err))
+		return
+	}
+	r.logger.Info("User router started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			if err := r.nats.Unsubscribe(cleanupCtx, pubsub.NatsTopicUserMessages); err != nil {
+				r.logger.Warn("Failed to unsubscribe from user messages", zap.Error(err))
+			}
+			cancel()
+			r.logger.Info("Application context cancelled user router existing")
+			return
+		case msgData, ok := <-msgChan:
+			if !ok {
+				return
+			}
+			r.route(msgData)
+		}
+	}
+}
+
+func (r *UserRouter) route(msgData []byte) {
+	var message struct {
+		DeviceID uuid.UUID `json:"device_id"`
+	}
+	if err := json.Unmarshal(msgData, &message); err != nil || message.DeviceID == uuid.Nil {
+		r.logger.Warn("Dropping user message without device", zap.Error(err))
+		return
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, sub := range r.subs[message.DeviceID] {
+		select {
+		case sub.Messages <- msgData:
+		default:
+			// a slow user only misses messages, it doesn't hold up the other users
+			r.logger.Warn("User subscription full, dropping message",
+				zap.String("subscription_id", sub.ID.String()),
+				zap.String("device_id", sub.DeviceID.String()))
+		}
+	}
+}
+
+func (r *UserRouter) Subscribe(deviceID uuid.UUID) *UserSubscription {
+	sub := &UserSubscription{
+		ID:       uuid.New(),
+		DeviceID: deviceID,
+		Messages: make(chan []byte, userSubscriptionBuffer),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deviceSubs, ok := r.subs[deviceID]
+	if !ok {
+		deviceSubs = make(map[uuid.UUID]*UserSubscription)
+		r.subs[deviceID] = deviceSubs
+	}
+	deviceSubs[sub.ID] = sub
+	return sub
+}
+
+func (r *UserRouter) Unsubscribe(sub *UserSubscription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deviceSubs, ok := r.subs[sub.DeviceID]
+	if !ok {
+		return
+	}
+	delete(deviceSubs, sub.ID)
+	if len(deviceSubs) == 0 {
+		delete(r.subs, sub.DeviceID)
+	}
+}